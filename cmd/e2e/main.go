@@ -0,0 +1,288 @@
+// Command e2e runs the sink and the edge simulator against each other and
+// checks what ended up in the journal: every event the simulator sent,
+// once, in sequence order. It exits non-zero on the first failed check,
+// so it can gate CI next to the unit tests.
+//
+//	go run ./cmd/e2e -rate 500 -duration 10s
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const sensor = "e2e-sensor"
+
+type options struct {
+	sinkBin  string
+	edgeBin  string
+	rate     int
+	duration time.Duration
+	workers  int
+	keep     bool
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.sinkBin, "sink", "", "sink binary, built from ./cmd/sink if empty")
+	flag.StringVar(&o.edgeBin, "edge", "", "edge simulator binary, built from ./cmd/edge if empty")
+	flag.IntVar(&o.rate, "rate", 200, "events per second the simulator sends")
+	flag.DurationVar(&o.duration, "duration", 5*time.Second, "how long the simulator runs")
+	flag.IntVar(&o.workers, "workers", 4, "simulator workers")
+	flag.BoolVar(&o.keep, "keep", false, "keep the working directory, with the journal and logs, after a successful run")
+	flag.Parse()
+
+	if err := run(o); err != nil {
+		slog.Error("e2e failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(o options) (err error) {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "iotdemo-e2e-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// a failed run's logs and journal are what there is to go on
+		if err != nil || o.keep {
+			slog.Info("working directory kept", "dir", dir)
+			return
+		}
+		_ = os.RemoveAll(dir)
+	}()
+
+	if o.sinkBin == "" {
+		if o.sinkBin, err = build(ctx, dir, "sink"); err != nil {
+			return err
+		}
+	}
+	if o.edgeBin == "" {
+		if o.edgeBin, err = build(ctx, dir, "edge"); err != nil {
+			return err
+		}
+	}
+
+	addr, err := freeAddr()
+	if err != nil {
+		return err
+	}
+	journalDir := filepath.Join(dir, "journal")
+	cfgPath, err := writeConfig(dir, addr, journalDir)
+	if err != nil {
+		return err
+	}
+
+	sink, err := startSink(ctx, o.sinkBin, cfgPath, dir)
+	if err != nil {
+		return err
+	}
+	defer sink.kill()
+	if err := sink.waitHealthy(ctx, "http://"+addr+"/healthz", 15*time.Second); err != nil {
+		return err
+	}
+	slog.Info("sink started", "addr", addr, "dir", dir)
+
+	statePath := filepath.Join(dir, "edge-state.json")
+	edge := func(args ...string) error {
+		args = append([]string{
+			"-addr", "http://" + addr,
+			"-sensor", sensor,
+			"-rate", fmt.Sprint(o.rate),
+			"-duration", o.duration.String(),
+			"-workers", fmt.Sprint(o.workers),
+			"-state", statePath,
+			"-heartbeat", "500ms",
+		}, args...)
+		return runLogged(ctx, filepath.Join(dir, "edge.log"), o.edgeBin, args...)
+	}
+	if err := edge(); err != nil {
+		return fmt.Errorf("edge simulator: %w", err)
+	}
+	st, err := loadState(statePath)
+	if err != nil {
+		return err
+	}
+	if st.Sent != int64(st.Total) {
+		return fmt.Errorf("simulator delivered %d of %d events", st.Sent, st.Total)
+	}
+	slog.Info("simulator done", "sent", st.Sent)
+
+	// the same events again, as a device resending after lost responses
+	// would; dedup has to keep them out of the journal
+	if err := st.reset(statePath); err != nil {
+		return err
+	}
+	if err := edge("-resume"); err != nil {
+		return fmt.Errorf("edge simulator resend: %w", err)
+	}
+	slog.Info("simulator resent every event")
+
+	if err := sink.stop(30 * time.Second); err != nil {
+		return err
+	}
+
+	res, err := verifyJournal(journalDir, st)
+	if err != nil {
+		return err
+	}
+	slog.Info("journal verified",
+		"events", res.events,
+		"device_reports", res.devices,
+		"segments", res.segments,
+		"first_seq", res.firstSeq,
+		"last_seq", res.lastSeq,
+	)
+	return nil
+}
+
+// build compiles a command of this module into dir.
+func build(ctx context.Context, dir, name string) (string, error) {
+	bin := filepath.Join(dir, name)
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", bin, "github.com/andriibeee/iotdemo/cmd/"+name)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("build %s: %w", name, err)
+	}
+	return bin, nil
+}
+
+// freeAddr returns a loopback address nothing is listening on. Another
+// process could take it before the sink does, which is unlikely enough.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// writeConfig points the sink at addr and keeps every file it writes in
+// dir. Segments are kept small so the run spans several of them.
+func writeConfig(dir, addr, journalDir string) (string, error) {
+	cfg := fmt.Sprintf(`server:
+  addr: %q
+sink:
+  flush_interval: 100ms
+journal:
+  dir: %q
+  max_size: 8192
+rate_limit:
+  enabled: false
+quota:
+  state_file: %q
+backfill:
+  state_file: %q
+logging:
+  level: info
+  add_source: false
+`, addr, journalDir, filepath.Join(dir, "quota.json"), filepath.Join(dir, "backfill_quota.json"))
+
+	path := filepath.Join(dir, "sink.yaml")
+	return path, os.WriteFile(path, []byte(cfg), 0644)
+}
+
+// runLogged runs a command to completion with its output appended to
+// logPath.
+func runLogged(ctx context.Context, logPath, bin string, args ...string) error {
+	log, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stdout, cmd.Stderr = log, log
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w, see %s", err, logPath)
+	}
+	return nil
+}
+
+type sinkProcess struct {
+	cmd    *exec.Cmd
+	log    string
+	exited chan error
+}
+
+func startSink(ctx context.Context, bin, cfgPath, dir string) (*sinkProcess, error) {
+	logPath := filepath.Join(dir, "sink.log")
+	log, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	cmd := exec.CommandContext(ctx, bin, "-config", cfgPath)
+	cmd.Stdout, cmd.Stderr = log, log
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &sinkProcess{cmd: cmd, log: logPath, exited: make(chan error, 1)}
+	go func() { p.exited <- cmd.Wait() }()
+	return p, nil
+}
+
+func (p *sinkProcess) waitHealthy(ctx context.Context, url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, _, err := fasthttp.GetTimeout(nil, url, time.Second)
+		if err == nil && status == fasthttp.StatusOK {
+			return nil
+		}
+		select {
+		case err := <-p.exited:
+			p.exited <- err
+			return fmt.Errorf("sink exited during startup: %v, see %s", err, p.log)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("sink not healthy after %s, see %s", timeout, p.log)
+		}
+	}
+}
+
+// stop shuts the sink down the way an operator would, so its final flush
+// is part of what gets verified.
+func (p *sinkProcess) stop(timeout time.Duration) error {
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	select {
+	case err := <-p.exited:
+		p.exited <- err
+		if err != nil {
+			return fmt.Errorf("sink shutdown: %w, see %s", err, p.log)
+		}
+		return nil
+	case <-time.After(timeout):
+		return errors.New("sink didn't shut down within " + timeout.String() + ", see " + p.log)
+	}
+}
+
+func (p *sinkProcess) kill() {
+	select {
+	case err := <-p.exited:
+		p.exited <- err
+	default:
+		_ = p.cmd.Process.Kill()
+	}
+}
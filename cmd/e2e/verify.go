@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// edgeState is the part of the simulator's -state file the checks need.
+// The rest of the file is kept as is by reset.
+type edgeState struct {
+	RunID string `json:"run_id"`
+	Total int    `json:"total"`
+	Sent  int64  `json:"sent"`
+
+	raw map[string]json.RawMessage
+}
+
+func loadState(path string) (*edgeState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	st := &edgeState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("edge state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &st.raw); err != nil {
+		return nil, fmt.Errorf("edge state %s: %w", path, err)
+	}
+	if st.RunID == "" || st.Total == 0 {
+		return nil, fmt.Errorf("edge state %s has no run", path)
+	}
+	return st, nil
+}
+
+// reset marks every event of the run as unsent, so -resume sends them all
+// again with the ids they had.
+func (st *edgeState) reset(path string) error {
+	st.raw["done"], _ = json.Marshal(make([]byte, (st.Total+7)/8))
+	st.raw["sent"] = json.RawMessage("0")
+	data, err := json.Marshal(st.raw)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+type journalResult struct {
+	events            int
+	devices           int
+	segments          int
+	firstSeq, lastSeq uint64
+}
+
+// verifyJournal replays the journal the sink left behind and checks that
+// it holds each event of the run exactly once, under the simulator's
+// sensor and ids, and at least one heartbeat of the simulator's device,
+// with sequence numbers increasing by one.
+func verifyJournal(dir string, st *edgeState) (journalResult, error) {
+	var res journalResult
+
+	storage, err := journal.NewFileStorage(dir)
+	if err != nil {
+		return res, err
+	}
+	defer storage.Close()
+	names, err := storage.List(context.Background())
+	if err != nil {
+		return res, err
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, ".wal") {
+			res.segments++
+		}
+	}
+
+	j, err := journal.New(storage, 0)
+	if err != nil {
+		return res, err
+	}
+	defer j.Close()
+
+	seen := make([]bool, st.Total)
+	prefix := st.RunID + "-"
+	err = j.Replay(func(e *journal.Entry) error {
+		if res.lastSeq != 0 && e.Seq != res.lastSeq+1 {
+			return fmt.Errorf("seq %d follows %d", e.Seq, res.lastSeq)
+		}
+		if res.firstSeq == 0 {
+			res.firstSeq = e.Seq
+		}
+		res.lastSeq = e.Seq
+
+		if _, _, err := sink.DecodeDeviceKey(e.Key); err == nil {
+			info, err := sink.DecodeDeviceValue(e.Value)
+			if err != nil {
+				return fmt.Errorf("seq %d: %w", e.Seq, err)
+			}
+			if !slices.Contains(info.Sensors, sensor) {
+				return fmt.Errorf("seq %d: device %q reports sensors %q, want %q among them", e.Seq, info.Device, info.Sensors, sensor)
+			}
+			res.devices++
+			return nil
+		}
+
+		name, _, err := sink.DecodeKey(e.Key)
+		if err != nil {
+			return fmt.Errorf("seq %d: %w", e.Seq, err)
+		}
+		ev, err := sink.DecodeValue(e.Value)
+		if err != nil {
+			return fmt.Errorf("seq %d: %w", e.Seq, err)
+		}
+		if name != sensor || ev.Sensor != sensor {
+			return fmt.Errorf("seq %d: sensor %q under key of %q, want %q", e.Seq, ev.Sensor, name, sensor)
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(ev.IdempotencyID, prefix))
+		if err != nil || !strings.HasPrefix(ev.IdempotencyID, prefix) || i < 0 || i >= st.Total {
+			return fmt.Errorf("seq %d: id %q isn't from this run", e.Seq, ev.IdempotencyID)
+		}
+		if ev.Value != i {
+			return fmt.Errorf("seq %d: event %d has value %d", e.Seq, i, ev.Value)
+		}
+		if seen[i] {
+			return fmt.Errorf("seq %d: event %d journaled twice", e.Seq, i)
+		}
+		seen[i] = true
+		res.events++
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	if res.devices == 0 {
+		return res, errors.New("journal holds no heartbeat of the simulator's device")
+	}
+	if res.events != st.Total {
+		for i, ok := range seen {
+			if !ok {
+				return res, fmt.Errorf("journal holds %d of %d events, event %d is missing", res.events, st.Total, i)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// deviceClock is a sensor's clock that gains drift per second of real time
+// (loses it when negative) since start, the way cheap oscillators wander,
+// plus up to ±jitter of noise on every reading.
+type deviceClock struct {
+	start  time.Time
+	drift  time.Duration
+	jitter time.Duration
+}
+
+func (c deviceClock) now() time.Time {
+	now := time.Now()
+	skew := time.Duration(now.Sub(c.start).Seconds() * float64(c.drift))
+	if c.jitter > 0 {
+		skew += time.Duration(rand.Int64N(2*int64(c.jitter)+1)) - c.jitter
+	}
+	return now.Add(skew)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// heartbeat reports the device the simulated sensor is on every interval:
+// its firmware, a battery draining at a steady rate and a signal strength
+// that wanders, the way a fleet's devices keep the sink's /devices and
+// sensor health current alongside their readings.
+type heartbeat struct {
+	device   string
+	firmware string
+	sensor   string
+	interval time.Duration
+	battery  float64 // percent at the start, negative for mains power
+	drain    float64 // percent per hour
+	linger   time.Duration
+	clock    deviceClock
+
+	sent, failed atomic.Int64
+}
+
+// run sends a report right away and then every interval until stop is
+// closed or ctx is done.
+func (h *heartbeat) run(ctx context.Context, c *targets, stop <-chan struct{}) {
+	start := time.Now()
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.SendDevice(ctx, h.info(time.Since(start))); err != nil {
+			h.failed.Add(1)
+			slog.Debug("heartbeat failed", "error", err)
+		} else {
+			h.sent.Add(1)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lingerAfter keeps the reports going for linger once the readings have
+// ended, so the sink sees the sensor go silent on a device that's up.
+func (h *heartbeat) lingerAfter(ctx context.Context) {
+	if h.linger <= 0 {
+		return
+	}
+	slog.Info("readings done, still reporting the device", "for", h.linger)
+	select {
+	case <-time.After(h.linger):
+	case <-ctx.Done():
+	}
+}
+
+func (h *heartbeat) info(elapsed time.Duration) entity.DeviceInfo {
+	rssi := -75 + rand.IntN(21) // -75 to -55 dBm
+	info := entity.DeviceInfo{
+		Device:        h.device,
+		Firmware:      h.firmware,
+		RSSI:          &rssi,
+		Sensors:       []string{h.sensor},
+		UnixTimestamp: h.clock.now().UnixMilli(),
+	}
+	if h.battery >= 0 {
+		b := max(h.battery-h.drain*elapsed.Hours(), 0)
+		info.Battery = &b
+	}
+	return info
+}
@@ -7,179 +7,232 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/tidwall/lotsa"
-	"github.com/valyala/fasthttp"
 
 	"github.com/andriibeee/iotdemo/internal/entity"
-	"github.com/andriibeee/iotdemo/pkg/retry"
 )
 
-var ErrRateLimited = fmt.Errorf("rate limited")
-
 func main() {
-	addr := flag.String("addr", "http://localhost:8080", "sink address")
+	addr := flag.String("addr", "http://localhost:8080", "sink address, or comma-separated addresses each with an optional =<weight>, tried in order on failure")
 	sensor := flag.String("sensor", "edge-sensor-1", "sensor name")
 	rate := flag.Int("rate", 10, "messages per second")
 	duration := flag.Duration("duration", 10*time.Second, "how long to run")
 	workers := flag.Int("workers", 4, "number of concurrent workers")
+	statePath := flag.String("state", "edge-state.json", "file to save run progress to")
+	resume := flag.Bool("resume", false, "continue the run saved in -state instead of starting over")
+	retryBudget := flag.Int("retry-budget", 0, "retries per second shared by all workers, 0 = unlimited")
+	breaker := flag.Int("breaker", 0, "stop sending for 5s after this many consecutive failures, 0 = never")
+	clockDrift := flag.Duration("clock-drift", 0, "how far the sensor clock runs ahead per second, negative to fall behind")
+	clockJitter := flag.Duration("clock-jitter", 0, "random skew of up to ± this on each timestamp")
+	replay := flag.String("replay", "", "send the events of an NDJSON or CSV capture, - for stdin, instead of generating them")
+	replayFormat := flag.String("replay-format", "", "format of the -replay capture: ndjson or csv, by default from its extension")
+	speed := flag.Float64("speed", 1, "with -replay, how many times faster than captured to send, 0 = as fast as possible")
+	rewriteTS := flag.Bool("rewrite-ts", false, "with -replay, stamp events with the time they're sent")
+	keepIDs := flag.Bool("keep-ids", false, "with -replay, send the captured idempotency ids instead of new ones")
+	heartbeatEvery := flag.Duration("heartbeat", 0, "report the device to /device this often, 0 = never")
+	device := flag.String("device", "edge-device-1", "with -heartbeat, the name of the device the sensor is on")
+	firmware := flag.String("firmware", "sim-1.0.0", "with -heartbeat, the firmware version reported")
+	battery := flag.Float64("battery", 100, "with -heartbeat, battery percent at the start, negative for a mains-powered device")
+	batteryDrain := flag.Float64("battery-drain", 1, "with -heartbeat, battery percent used per hour")
+	linger := flag.Duration("heartbeat-linger", 0, "with -heartbeat, keep reporting the device this long after the last reading")
 	flag.Parse()
 
-	if err := run(*addr, *sensor, *rate, *duration, *workers); err != nil {
+	clock := deviceClock{drift: *clockDrift, jitter: *clockJitter}
+	var hb *heartbeat
+	if *heartbeatEvery > 0 {
+		hb = &heartbeat{
+			device:   *device,
+			firmware: *firmware,
+			interval: *heartbeatEvery,
+			battery:  *battery,
+			drain:    *batteryDrain,
+			linger:   *linger,
+		}
+	}
+	var err error
+	if *replay != "" {
+		if *resume {
+			slog.Error("-resume doesn't apply to -replay")
+			os.Exit(2)
+		}
+		if hb != nil {
+			slog.Error("-heartbeat doesn't apply to -replay")
+			os.Exit(2)
+		}
+		o := replayOptions{path: *replay, format: *replayFormat, speed: *speed, rewriteTS: *rewriteTS, keepIDs: *keepIDs}
+		err = runReplay(*addr, o, *workers, *retryBudget, *breaker, clock)
+	} else {
+		err = run(*addr, *sensor, *rate, *duration, *workers, *statePath, *resume, *retryBudget, *breaker, clock, hb)
+	}
+	if err != nil {
 		slog.Error("simulator failed", "error", err)
 		os.Exit(1)
 	}
 }
 
-func run(addr, sensor string, rate int, duration time.Duration, workers int) error {
+func run(addr, sensor string, rate int, duration time.Duration, workers int, statePath string, resume bool, retryBudget, breaker int, clock deviceClock, hb *heartbeat) error {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	total := rate * int(duration.Seconds())
-	if total == 0 {
-		return fmt.Errorf("nothing to send (rate=%d, duration=%s)", rate, duration)
+	var st *runState
+	if resume {
+		var err error
+		if st, err = loadRunState(statePath); err != nil {
+			return fmt.Errorf("resume: %w", err)
+		}
+		// the saved run decides what is sent, flags only how
+		sensor, rate = st.Sensor, st.Rate
+	} else {
+		total := rate * int(duration.Seconds())
+		if total == 0 {
+			return fmt.Errorf("nothing to send (rate=%d, duration=%s)", rate, duration)
+		}
+		st = newRunState(sensor, rate, total)
+	}
+
+	pending := st.pending()
+	if len(pending) == 0 {
+		slog.Info("run already complete", "state", statePath, "sent", st.Sent)
+		return nil
 	}
 
 	slog.Info("starting simulator",
 		"addr", addr,
 		"sensor", sensor,
 		"rate", rate,
-		"duration", duration,
 		"workers", workers,
-		"total", total,
+		"total", st.Total,
+		"pending", len(pending),
+		"resumed", resume,
+		"state", statePath,
+		"clock_drift", clock.drift,
+		"clock_jitter", clock.jitter,
+		"heartbeat", hb != nil,
 	)
 
-	client := &fasthttp.Client{
-		MaxConnsPerHost: workers * 2,
+	c, err := newTargets(addr, retryBudget, breaker)
+	if err != nil {
+		return err
 	}
+	defer c.Close()
 
-	var (
-		sent    atomic.Int64
-		failed  atomic.Int64
-		retried atomic.Int64
-	)
+	var failed atomic.Int64
+	baseSent, baseRetried, baseElapsed := st.Sent, st.Retried, st.Elapsed
 
 	interval := time.Second / time.Duration(rate)
 	start := time.Now()
+	clock.start = start
+
+	stopHeartbeat := make(chan struct{})
+	var heartbeats sync.WaitGroup
+	if hb != nil {
+		hb.sensor, hb.clock = sensor, clock
+		heartbeats.Add(1)
+		go func() {
+			defer heartbeats.Done()
+			hb.run(ctx, c, stopHeartbeat)
+		}()
+	}
+
+	save := func() {
+		st.progress(baseRetried+c.Retries(), baseElapsed+time.Since(start))
+		if err := st.save(statePath); err != nil {
+			slog.Warn("failed to save run state", "state", statePath, "error", err)
+		}
+	}
 
 	done := make(chan struct{})
+	var progress sync.WaitGroup
+	progress.Add(1)
 	go func() {
+		defer progress.Done()
 		ticker := time.NewTicker(time.Second)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				s, f, r := sent.Load(), failed.Load(), retried.Load()
-				slog.Info("progress",
+				save()
+				st.mu.Lock()
+				s := st.Sent
+				st.mu.Unlock()
+				attrs := []any{
 					"sent", s,
-					"failed", f,
-					"retried", r,
-					"elapsed", time.Since(start).Round(time.Second),
-				)
+					"failed", failed.Load(),
+					"retried", baseRetried + c.Retries(),
+					"elapsed", (baseElapsed + time.Since(start)).Round(time.Second),
+				}
+				if hb != nil {
+					attrs = append(attrs, "heartbeats", hb.sent.Load())
+				}
+				slog.Info("progress", attrs...)
 			case <-done:
 				return
 			}
 		}
 	}()
 
-	lotsa.Ops(total, workers, func(i, _ int) {
+	lotsa.Ops(len(pending), workers, func(k, _ int) {
 		select {
 		case <-ctx.Done():
 			return
 		default:
 		}
 
-		targetTime := start.Add(time.Duration(i) * interval)
+		targetTime := start.Add(time.Duration(k) * interval)
 		if wait := time.Until(targetTime); wait > 0 {
 			time.Sleep(wait)
 		}
 
+		i := pending[k]
 		ev := entity.Event{
-			IdempotencyID: uuid.NewString(),
+			IdempotencyID: st.eventID(i),
 			Sensor:        sensor,
 			Value:         i,
-			UnixTimestamp: time.Now().UnixMilli(),
+			UnixTimestamp: clock.now().UnixMilli(),
 		}
 
-		err := sendWithRetry(ctx, client, addr, ev, &retried)
-		if err != nil {
+		if err := c.Send(ctx, ev); err != nil {
 			failed.Add(1)
 			slog.Debug("send failed", "error", err, "event", i)
 		} else {
-			sent.Add(1)
+			st.markDone(i)
 		}
 	})
 
+	// the final save and the reads below mustn't race the ticker's
 	close(done)
+	progress.Wait()
+	save()
+	if hb != nil {
+		hb.lingerAfter(ctx)
+		close(stopHeartbeat)
+		heartbeats.Wait()
+	}
 
 	elapsed := time.Since(start)
-	actualRate := float64(sent.Load()) / elapsed.Seconds()
+	actualRate := float64(st.Sent-baseSent) / elapsed.Seconds()
 
 	slog.Info("done",
-		"sent", sent.Load(),
+		"sent", st.Sent,
 		"failed", failed.Load(),
-		"retried", retried.Load(),
-		"elapsed", elapsed.Round(time.Millisecond),
+		"pending", len(st.pending()),
+		"retried", st.Retried,
+		"throttled", c.Throttled(),
+		"elapsed", st.Elapsed.Round(time.Millisecond),
 		"actual_rate", fmt.Sprintf("%.1f/s", actualRate),
 	)
+	if hb != nil {
+		slog.Info("heartbeats", "device", hb.device, "sent", hb.sent.Load(), "failed", hb.failed.Load())
+	}
+	c.report()
+	if ctx.Err() != nil {
+		slog.Info("interrupted, continue with -resume", "state", statePath)
+	}
 
 	return nil
 }
-
-func sendWithRetry(ctx context.Context, client *fasthttp.Client, addr string, ev entity.Event, retried *atomic.Int64) error {
-	r := retry.New(
-		retry.MaxAttempts(3),
-		retry.Delay(retry.DelayOptions{
-			Delay: 100 * time.Millisecond,
-			Func:  retry.DoubleDelay,
-			Max:   time.Second,
-		}),
-	)
-
-	attempt := 0
-	return r(ctx, func(_ context.Context) error {
-		attempt++
-		if attempt > 1 {
-			retried.Add(1)
-		}
-
-		body, err := ev.MarshalMsg(nil)
-		if err != nil {
-			return fmt.Errorf("%w: marshal: %w", retry.ErrStop, err)
-		}
-
-		req := fasthttp.AcquireRequest()
-		resp := fasthttp.AcquireResponse()
-		defer fasthttp.ReleaseRequest(req)
-		defer fasthttp.ReleaseResponse(resp)
-
-		req.SetRequestURI(addr + "/ingest")
-		req.Header.SetMethod("POST")
-		req.Header.SetContentType("application/msgpack")
-		req.SetBody(body)
-
-		if err := client.DoTimeout(req, resp, 5*time.Second); err != nil {
-			return fmt.Errorf("request failed: %w", err)
-		}
-
-		code := resp.StatusCode()
-		switch {
-		case code == fasthttp.StatusAccepted:
-			return nil
-		// fail silently on dupes
-		case code == fasthttp.StatusConflict:
-			return nil
-		// error cases
-		case code == fasthttp.StatusTooManyRequests:
-			return ErrRateLimited
-		case code >= fasthttp.StatusInternalServerError:
-			return fmt.Errorf("server error: %d", code)
-		default:
-			return fmt.Errorf("%w: unexpected status: %d", retry.ErrStop, code)
-		}
-	})
-}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// replayOptions say how a capture of events is replayed.
+type replayOptions struct {
+	path   string // "-" for stdin
+	format string // ndjson or csv; empty goes by the extension
+	// speed divides the gaps between the captured timestamps, 0 sends as
+	// fast as the workers go.
+	speed     float64
+	rewriteTS bool // stamp events with the send time, not the captured one
+	keepIDs   bool // send the captured idempotency ids
+}
+
+// eventReader reads a capture one event at a time, returning io.EOF after
+// the last.
+type eventReader interface {
+	next() (entity.Event, error)
+}
+
+// ndjsonReader reads one JSON event per line, in the shape /ingest takes.
+type ndjsonReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 1<<20)
+	return &ndjsonReader{s: s}
+}
+
+func (r *ndjsonReader) next() (entity.Event, error) {
+	for r.s.Scan() {
+		r.line++
+		line := r.s.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var ev entity.Event
+		if err := entity.DecodeJSON(line, &ev); err != nil {
+			return ev, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return ev, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return entity.Event{}, err
+	}
+	return entity.Event{}, io.EOF
+}
+
+// csvReader reads events from a CSV file whose header names the columns:
+// sensor, val and ts are required, idempotency_id is optional and others
+// are ignored.
+type csvReader struct {
+	r    *csv.Reader
+	cols map[string]int
+	line int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"sensor", "val", "ts"} {
+		if _, ok := cols[name]; !ok {
+			return nil, errors.New("csv header has no " + name + " column")
+		}
+	}
+	return &csvReader{r: cr, cols: cols, line: 1}, nil
+}
+
+func (r *csvReader) next() (entity.Event, error) {
+	var ev entity.Event
+	rec, err := r.r.Read()
+	if err != nil {
+		return ev, err
+	}
+	r.line++
+	field := func(name string) string {
+		if i, ok := r.cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	ev.Sensor = field("sensor")
+	ev.IdempotencyID = field("idempotency_id")
+	if ev.Value, err = strconv.Atoi(field("val")); err != nil {
+		return ev, fmt.Errorf("line %d: val: %w", r.line, err)
+	}
+	if ev.UnixTimestamp, err = strconv.ParseInt(field("ts"), 10, 64); err != nil {
+		return ev, fmt.Errorf("line %d: ts: %w", r.line, err)
+	}
+	return ev, nil
+}
+
+func openCapture(o replayOptions) (eventReader, io.Closer, error) {
+	in, closer := io.Reader(os.Stdin), io.Closer(io.NopCloser(nil))
+	if o.path != "-" {
+		f, err := os.Open(o.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		in, closer = f, f
+	}
+
+	format := o.format
+	if format == "" {
+		format = "ndjson"
+		if strings.EqualFold(filepath.Ext(o.path), ".csv") {
+			format = "csv"
+		}
+	}
+	switch format {
+	case "ndjson":
+		return newNDJSONReader(in), closer, nil
+	case "csv":
+		r, err := newCSVReader(in)
+		if err != nil {
+			closer.Close()
+			return nil, nil, err
+		}
+		return r, closer, nil
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("unknown replay format %q, use ndjson or csv", format)
+	}
+}
+
+// runReplay sends the events of a capture, keeping the gaps between their
+// timestamps divided by o.speed. The capture is read as it's sent, so
+// stdin can be a live pipe.
+func runReplay(addr string, o replayOptions, workers, retryBudget, breaker int, clock deviceClock) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if o.speed < 0 {
+		return fmt.Errorf("speed must not be negative (speed=%g)", o.speed)
+	}
+	src, closer, err := openCapture(o)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	c, err := newTargets(addr, retryBudget, breaker)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	runID := uuid.NewString()
+	slog.Info("starting replay",
+		"addr", addr,
+		"capture", o.path,
+		"speed", o.speed,
+		"workers", workers,
+		"rewrite_ts", o.rewriteTS,
+		"keep_ids", o.keepIDs,
+		"run_id", runID,
+	)
+
+	var sent, failed atomic.Int64
+	start := time.Now()
+	clock.start = start
+
+	events := make(chan entity.Event, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for ev := range events {
+				if o.rewriteTS {
+					ev.UnixTimestamp = clock.now().UnixMilli()
+				}
+				if err := c.Send(ctx, ev); err != nil {
+					failed.Add(1)
+					slog.Debug("send failed", "error", err, "id", ev.IdempotencyID)
+					continue
+				}
+				sent.Add(1)
+			}
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				slog.Info("progress",
+					"sent", sent.Load(),
+					"failed", failed.Load(),
+					"retried", c.Retries(),
+					"elapsed", time.Since(start).Round(time.Second),
+				)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var (
+		read    int
+		firstTS int64
+		readErr error
+	)
+	for ctx.Err() == nil {
+		ev, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("replay %s: %w", o.path, err)
+			break
+		}
+		if read == 0 {
+			firstTS = ev.UnixTimestamp
+		}
+		if !o.keepIDs || ev.IdempotencyID == "" {
+			ev.IdempotencyID = runID + "-" + strconv.Itoa(read)
+		}
+		read++
+
+		if o.speed > 0 {
+			offset := time.Duration(float64(ev.UnixTimestamp-firstTS) * float64(time.Millisecond) / o.speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+			}
+		}
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+		}
+	}
+	close(events)
+	wg.Wait()
+	close(done)
+
+	elapsed := time.Since(start)
+	slog.Info("done",
+		"read", read,
+		"sent", sent.Load(),
+		"failed", failed.Load(),
+		"retried", c.Retries(),
+		"throttled", c.Throttled(),
+		"elapsed", elapsed.Round(time.Millisecond),
+		"actual_rate", fmt.Sprintf("%.1f/s", float64(sent.Load())/elapsed.Seconds()),
+	)
+	c.report()
+	if ctx.Err() != nil {
+		slog.Info("interrupted", "read", read)
+	}
+	return readErr
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runState is what -resume picks up after an interrupted run. Event ids
+// are derived from RunID and the event index, so an event retried after
+// resuming carries the id it had the first time and the sink's dedup
+// sees it as the same event.
+type runState struct {
+	mu sync.Mutex
+
+	RunID   string        `json:"run_id"`
+	Sensor  string        `json:"sensor"`
+	Rate    int           `json:"rate"`
+	Total   int           `json:"total"`
+	Sent    int64         `json:"sent"`
+	Retried int64         `json:"retried"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Done has bit i set once event i was accepted. Indices below Total
+	// without it, including events that failed, are pending.
+	Done []byte `json:"done"`
+}
+
+func newRunState(sensor string, rate, total int) *runState {
+	return &runState{
+		RunID:  uuid.NewString(),
+		Sensor: sensor,
+		Rate:   rate,
+		Total:  total,
+		Done:   make([]byte, (total+7)/8),
+	}
+}
+
+func loadRunState(path string) (*runState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st runState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	if len(st.Done) != (st.Total+7)/8 {
+		return nil, errors.New("state file " + path + " is inconsistent")
+	}
+	return &st, nil
+}
+
+// save writes the state via a temp file and rename.
+func (st *runState) save(path string) error {
+	st.mu.Lock()
+	data, err := json.Marshal(st)
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (st *runState) eventID(i int) string {
+	return st.RunID + "-" + strconv.Itoa(i)
+}
+
+// pending returns the indices of events not sent yet, in order.
+func (st *runState) pending() []int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var out []int
+	for i := range st.Total {
+		if st.Done[i/8]&(1<<(i%8)) == 0 {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func (st *runState) markDone(i int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.Done[i/8] |= 1 << (i % 8)
+	st.Sent++
+}
+
+func (st *runState) progress(retried int64, elapsed time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.Retried = retried
+	st.Elapsed = elapsed
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/client"
+	"github.com/andriibeee/iotdemo/pkg/retry"
+)
+
+// target is one sink events are sent to.
+type target struct {
+	addr   string
+	weight int
+	c      *client.Client
+
+	sent, failed atomic.Int64
+}
+
+// targets spreads events over one or more sinks. Each event goes to a
+// target drawn by weight, and if that fails for a reason other than being
+// rejected, to the others in the order they were listed, so a pair of
+// sinks behind no load balancer can be tested the way devices would use
+// them.
+type targets struct {
+	list  []*target
+	total int // sum of the weights
+
+	failovers atomic.Int64
+
+	mu          sync.Mutex
+	outageStart time.Time // first failure since the last success
+	longest     time.Duration
+}
+
+// parseTargets reads -addr: comma-separated URLs, each optionally followed
+// by =<weight>. Weight defaults to 1; 0 makes a standby that only takes
+// events the others failed.
+func parseTargets(spec string) (*targets, error) {
+	ts := &targets{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t := &target{addr: part, weight: 1}
+		if i := strings.LastIndexByte(part, '='); i >= 0 {
+			w, err := strconv.Atoi(part[i+1:])
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("target %q: weight must be a non-negative integer", part)
+			}
+			t.addr, t.weight = part[:i], w
+		}
+		ts.list = append(ts.list, t)
+		ts.total += t.weight
+	}
+	if len(ts.list) == 0 {
+		return nil, errors.New("no sink address")
+	}
+	if ts.total == 0 {
+		return nil, errors.New("every target has weight 0")
+	}
+	return ts, nil
+}
+
+// newTargets connects to the sinks in spec. The retry budget is shared by
+// all of them, the breaker is per target, so one that's down fails over
+// right away once its breaker opens.
+func newTargets(spec string, retryBudget, breaker int) (*targets, error) {
+	ts, err := parseTargets(spec)
+	if err != nil {
+		return nil, err
+	}
+	var budget *retry.Budget
+	if retryBudget > 0 {
+		budget = retry.NewBudget(retryBudget, time.Second)
+	}
+	for _, t := range ts.list {
+		opts := []client.Option{client.WithRetry(3, 100*time.Millisecond, time.Second)}
+		if budget != nil {
+			opts = append(opts, client.WithRetryBudget(budget))
+		}
+		if breaker > 0 {
+			opts = append(opts, client.WithBreaker(retry.NewBreaker(breaker, 5*time.Second)))
+		}
+		if t.c, err = client.New(t.addr, opts...); err != nil {
+			ts.Close()
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+func (ts *targets) addrs() []string {
+	out := make([]string, len(ts.list))
+	for i, t := range ts.list {
+		out[i] = t.addr + "=" + strconv.Itoa(t.weight)
+	}
+	return out
+}
+
+// pick draws a target by weight.
+func (ts *targets) pick() int {
+	n := rand.IntN(ts.total)
+	for i, t := range ts.list {
+		if n < t.weight {
+			return i
+		}
+		n -= t.weight
+	}
+	return 0
+}
+
+// Send delivers ev to a target drawn by weight, failing over to the rest
+// in order.
+func (ts *targets) Send(ctx context.Context, ev entity.Event) error {
+	return ts.deliver(ctx, func(t *target) error {
+		err := t.c.Send(ctx, ev)
+		if err != nil {
+			t.failed.Add(1)
+		} else {
+			t.sent.Add(1)
+		}
+		return err
+	})
+}
+
+// SendDevice delivers a device report like Send does an event. Reports
+// aren't counted as events sent.
+func (ts *targets) SendDevice(ctx context.Context, info entity.DeviceInfo) error {
+	return ts.deliver(ctx, func(t *target) error { return t.c.SendDevice(ctx, info) })
+}
+
+// deliver calls send with a target drawn by weight, then with the rest in
+// order until one succeeds.
+func (ts *targets) deliver(ctx context.Context, send func(t *target) error) error {
+	first := ts.pick()
+	var errs []error
+	for k := range ts.list {
+		i := first
+		if k > 0 {
+			// the others in listed order, skipping the one drawn
+			i = k - 1
+			if i >= first {
+				i++
+			}
+		}
+		t := ts.list[i]
+		err := send(t)
+		if err == nil {
+			if k > 0 {
+				ts.failovers.Add(1)
+			}
+			ts.recovered()
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.addr, err))
+		if errors.Is(err, client.ErrRejected) || ctx.Err() != nil {
+			break
+		}
+	}
+	ts.failing()
+	return errors.Join(errs...)
+}
+
+func (ts *targets) failing() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.outageStart.IsZero() {
+		ts.outageStart = time.Now()
+	}
+}
+
+// recovered ends an outage, if one is going on, and logs how long events
+// couldn't be delivered anywhere.
+func (ts *targets) recovered() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.outageStart.IsZero() {
+		return
+	}
+	d := time.Since(ts.outageStart)
+	ts.outageStart = time.Time{}
+	ts.longest = max(ts.longest, d)
+	slog.Info("delivering again", "after", d.Round(time.Millisecond))
+}
+
+// Retries is the number of retries over all targets.
+func (ts *targets) Retries() int64 {
+	var n int64
+	for _, t := range ts.list {
+		if t.c != nil {
+			n += t.c.Retries()
+		}
+	}
+	return n
+}
+
+// Throttled is the number of retries, over all targets, that waited for
+// a sink's Retry-After.
+func (ts *targets) Throttled() int64 {
+	var n int64
+	for _, t := range ts.list {
+		if t.c != nil {
+			n += t.c.Throttled()
+		}
+	}
+	return n
+}
+
+// report logs what each target took, with more than one.
+func (ts *targets) report() {
+	if len(ts.list) < 2 {
+		return
+	}
+	for _, t := range ts.list {
+		slog.Info("target", "addr", t.addr, "weight", t.weight, "sent", t.sent.Load(), "failed", t.failed.Load())
+	}
+	ts.mu.Lock()
+	longest := ts.longest
+	ts.mu.Unlock()
+	slog.Info("failover", "failovers", ts.failovers.Load(), "longest_outage", longest.Round(time.Millisecond))
+}
+
+func (ts *targets) Close() error {
+	var errs []error
+	for _, t := range ts.list {
+		if t.c != nil {
+			errs = append(errs, t.c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,27 @@
+// Command openapi writes the sink's OpenAPI document. It is run by
+// go generate in internal/transport.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/andriibeee/iotdemo/internal/transport"
+)
+
+func main() {
+	out := flag.String("out", "api/openapi.json", "output file")
+	flag.Parse()
+
+	doc, err := transport.OpenAPI()
+	if err != nil {
+		slog.Error("failed to build openapi document", "error", err)
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(*out, append(doc, '\n'), 0644); err != nil {
+		slog.Error("failed to write openapi document", "error", err)
+		os.Exit(1)
+	}
+}
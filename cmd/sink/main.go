@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
-	"errors"
 	"flag"
 	"log/slog"
 	"os"
@@ -11,104 +9,54 @@ import (
 	"syscall"
 
 	"github.com/andriibeee/iotdemo/internal/config"
-	"github.com/andriibeee/iotdemo/internal/sink"
-	"github.com/andriibeee/iotdemo/internal/transport"
-	"github.com/andriibeee/iotdemo/pkg/journal"
+	"github.com/andriibeee/iotdemo/internal/logging"
+	"github.com/andriibeee/iotdemo/pkg/sinkserver"
 )
 
 func main() {
 	cfgPath := flag.String("config", "", "path to config file")
+	forceTakeover := flag.Bool("force-takeover", false, "break a stale journal directory lock left by a dead process")
 	flag.Parse()
 
-	opts := &slog.HandlerOptions{
-		Level:     slog.LevelDebug,
-		AddSource: true,
-	}
-
-	logger := slog.New(slog.NewTextHandler(os.Stdout, opts))
-	slog.SetDefault(logger)
-
 	cfg, err := config.Load(*cfgPath)
 	if err != nil {
 		slog.Error("failed to load config", "error", err)
 		os.Exit(1)
 	}
 
-	if err := run(cfg); err != nil {
-		slog.Error("server error", "error", err)
+	logger, logCloser, err := logging.New(
+		logging.WithLevel(cfg.Logging.Level),
+		logging.WithFormat(cfg.Logging.Format),
+		logging.WithOutput(cfg.Logging.Output),
+		logging.WithSource(cfg.Logging.AddSource),
+		logging.WithRotation(cfg.Logging.MaxSize, cfg.Logging.MaxBackups),
+		logging.WithSampling(cfg.Logging.Sampling.Initial, cfg.Logging.Sampling.Interval),
+		logging.WithShipping(cfg.Logging.Ship.Protocol, cfg.Logging.Ship.Network, cfg.Logging.Ship.Address, cfg.Logging.Ship.Buffer),
+	)
+	if err != nil {
+		slog.Error("failed to set up logging", "error", err)
 		os.Exit(1)
 	}
-}
-
-func run(cfg *config.Config) error {
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+	slog.SetDefault(logger)
 
-	storage, err := journal.NewFileStorage(cfg.Journal.Dir)
+	err = run(cfg, *forceTakeover)
 	if err != nil {
-		return err
-	}
-
-	var journalOpts []journal.Option
-	if cfg.Journal.EncryptionKey != "" {
-		key, err := base64.StdEncoding.DecodeString(cfg.Journal.EncryptionKey)
-		if err != nil {
-			return errors.New("invalid encryption key: " + err.Error())
-		}
-		enc, err := journal.NewAESGCMEncryptor(key)
-		if err != nil {
-			return errors.New("failed to create encryptor: " + err.Error())
-		}
-		journalOpts = append(journalOpts, journal.WithEncryptor(enc))
-		slog.Info("journal encryption enabled")
+		slog.Error("server error", "error", err)
 	}
-
-	j, err := journal.New(storage, cfg.Journal.MaxSize, journalOpts...)
+	_ = logCloser.Close()
 	if err != nil {
-		return err
-	}
-	defer j.Close()
-
-	var middlewares []sink.Middleware
-
-	if cfg.Dedup.Enabled {
-		dedup := sink.NewDeduplicator(cfg.Dedup.CleaningInterval)
-		dedup.Start()
-		middlewares = append(middlewares, dedup.Middleware())
-		slog.Info("dedup enabled", "cleaning_interval", cfg.Dedup.CleaningInterval)
-	}
-
-	if cfg.RateLimit.Enabled {
-		rl := sink.NewRateLimiter(cfg.RateLimit.BytesPerSec)
-		middlewares = append(middlewares, rl.Middleware())
-		slog.Info("rate limit enabled", "bytes_per_sec", cfg.RateLimit.BytesPerSec)
+		os.Exit(1)
 	}
+}
 
-	s := sink.New(j,
-		sink.WithBufSize(cfg.Sink.BufferSize),
-		sink.WithMiddleware(middlewares...),
-	)
-
-	go func() {
-		if err := s.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
-			slog.Error("sink run error", "error", err)
-		}
-	}()
-
-	opts := []transport.Option{
-		transport.WithAddr(cfg.Server.Addr),
-		transport.WithReadTimeout(cfg.Server.ReadTimeout),
-		transport.WithWriteTimeout(cfg.Server.WriteTimeout),
-	}
+func run(cfg *config.Config, forceTakeover bool) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	if cfg.Server.TLS.Cert != "" {
-		opts = append(opts, transport.WithTLS(cfg.Server.TLS.Cert, cfg.Server.TLS.Key))
-	}
-	if cfg.Server.TLS.ClientCA != "" {
-		opts = append(opts, transport.WithClientCA(cfg.Server.TLS.ClientCA))
+	flush, rotate := controlSignals()
+	opts := []sinkserver.Option{sinkserver.WithFlushOn(flush), sinkserver.WithRotateOn(rotate)}
+	if forceTakeover {
+		opts = append(opts, sinkserver.WithForceTakeover())
 	}
-
-	srv := transport.New(s, opts...)
-
-	return srv.Run(ctx)
+	return sinkserver.Run(ctx, *cfg, opts...)
 }
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// controlSignals returns nil channels: there's no SIGUSR1 or SIGUSR2, so
+// flushing and rotating take the admin endpoints.
+func controlSignals() (flush, rotate <-chan os.Signal) {
+	return nil, nil
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// controlSignals delivers SIGUSR1, to flush, and SIGUSR2, to rotate the
+// journal.
+func controlSignals() (flush, rotate <-chan os.Signal) {
+	f, r := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	signal.Notify(f, syscall.SIGUSR1)
+	signal.Notify(r, syscall.SIGUSR2)
+	return f, r
+}
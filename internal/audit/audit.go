@@ -0,0 +1,214 @@
+// Package audit keeps an append-only, tamper-evident log of administrative
+// actions in a journal of its own. Each record carries the hash of the one
+// before it and its own hash over both, so editing, removing or reordering
+// a record breaks the chain from there on; with a key the hashes are
+// HMAC-SHA256, so rewriting the whole chain takes the key too.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+var ErrTampered = errors.New("audit: chain broken")
+
+// Journal is where records are kept; *journal.Journal implements it.
+type Journal interface {
+	Write(key, value []byte) (uint64, error)
+	Sync() error
+	Replay(fn func(*journal.Entry) error) error
+}
+
+var recordKey = []byte("audit")
+
+// Actor is who performed an action, as far as the sink can tell.
+type Actor struct {
+	// Addr is the client address, after trusted proxies.
+	Addr string `json:"addr"`
+	// Cert is the subject of the client certificate, with mutual TLS.
+	Cert      string `json:"cert,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Record is one audited action.
+type Record struct {
+	// Seq numbers records from 1, without gaps.
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Actor  Actor     `json:"actor"`
+	Action string    `json:"action"`
+	// Details are the action's parameters, such as a query string or a
+	// request body.
+	Details map[string]string `json:"details,omitempty"`
+	// Status is the HTTP status the action was answered with.
+	Status int    `json:"status"`
+	Prev   string `json:"prev"`
+	Hash   string `json:"hash"`
+}
+
+// Log appends records to a Journal.
+type Log struct {
+	mu   sync.Mutex
+	j    Journal
+	key  []byte
+	seq  uint64
+	last string
+	now  func() time.Time
+}
+
+// New continues the chain in j; key, if not empty, keys the hashes. The
+// chain is verified on the way, and a broken one is reported as
+// ErrTampered along with a usable Log, which appends after the last
+// record.
+func New(j Journal, key []byte) (*Log, error) {
+	l := &Log{j: j, key: key, now: time.Now}
+	err := l.replay(func(rec Record) bool {
+		l.seq, l.last = rec.Seq, rec.Hash
+		return true
+	})
+	chainIntact.Set(1)
+	if errors.Is(err, ErrTampered) {
+		chainIntact.Set(0)
+	}
+	return l, err
+}
+
+// Append completes rec with its sequence number, time and hashes, and
+// writes it durably.
+func (l *Log) Append(rec Record) (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.Seq = l.seq + 1
+	rec.Time = l.now().UTC()
+	rec.Prev = l.last
+	rec.Hash = ""
+	sum, err := l.sum(rec)
+	if err != nil {
+		return rec, err
+	}
+	rec.Hash = sum
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+
+	if _, err := l.j.Write(recordKey, data); err != nil {
+		writeErrors.Inc()
+		return rec, err
+	}
+	if err := l.j.Sync(); err != nil {
+		writeErrors.Inc()
+		return rec, err
+	}
+	l.seq, l.last = rec.Seq, rec.Hash
+	records.Inc()
+	return rec, nil
+}
+
+// Records returns up to limit records after seq, oldest first, and
+// whether the chain verified up to the last of them. Records past a break
+// are still returned.
+func (l *Log) Records(after uint64, limit int) ([]Record, bool, error) {
+	var out []Record
+	intact := true
+	err := l.replay(func(rec Record) bool {
+		if rec.Seq > after {
+			out = append(out, rec)
+		}
+		return len(out) < limit
+	})
+	if errors.Is(err, ErrTampered) {
+		intact, err = false, nil
+	}
+	return out, intact, err
+}
+
+// Verify checks the whole chain.
+func (l *Log) Verify() error {
+	err := l.replay(func(Record) bool { return true })
+	if errors.Is(err, ErrTampered) {
+		chainIntact.Set(0)
+	} else if err == nil {
+		chainIntact.Set(1)
+	}
+	return err
+}
+
+var errStop = errors.New("stop")
+
+// replay calls fn with each record until it returns false. A record
+// breaking the chain is still passed on, and ErrTampered is returned for
+// the first one once the replay is done.
+func (l *Log) replay(fn func(Record) bool) error {
+	var (
+		prev   string
+		seq    uint64
+		broken error
+	)
+	err := l.j.Replay(func(e *journal.Entry) error {
+		var rec Record
+		if err := json.Unmarshal(e.Value, &rec); err != nil {
+			return fmt.Errorf("%w: record after seq %d: %w", ErrTampered, seq, err)
+		}
+		if broken == nil {
+			if err := l.check(rec, prev, seq); err != nil {
+				broken = err
+			}
+		}
+		prev, seq = rec.Hash, rec.Seq
+		if !fn(rec) {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return err
+	}
+	return broken
+}
+
+func (l *Log) check(rec Record, prev string, seq uint64) error {
+	if rec.Seq != seq+1 {
+		return fmt.Errorf("%w: seq %d follows %d", ErrTampered, rec.Seq, seq)
+	}
+	if rec.Prev != prev {
+		return fmt.Errorf("%w: seq %d doesn't follow the record before it", ErrTampered, rec.Seq)
+	}
+	want := rec.Hash
+	rec.Hash = ""
+	sum, err := l.sum(rec)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sum), []byte(want)) {
+		return fmt.Errorf("%w: seq %d was modified", ErrTampered, rec.Seq)
+	}
+	return nil
+}
+
+// sum hashes rec as JSON with Hash empty; maps marshal with sorted keys,
+// so the encoding is stable.
+func (l *Log) sum(rec Record) (string, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	var h hash.Hash
+	if len(l.key) > 0 {
+		h = hmac.New(sha256.New, l.key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,10 @@
+package audit
+
+import "github.com/VictoriaMetrics/metrics"
+
+var (
+	records     = metrics.NewCounter("audit_records_total")
+	writeErrors = metrics.NewCounter("audit_write_errors_total")
+	// chainIntact is 0 once a check has found the chain broken.
+	chainIntact = metrics.NewGauge("audit_chain_intact", nil)
+)
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// sliceJournal keeps entries in memory where tests can tamper with them.
+type sliceJournal struct {
+	entries [][]byte
+}
+
+func (s *sliceJournal) Write(_, value []byte) (uint64, error) {
+	s.entries = append(s.entries, bytes.Clone(value))
+	return uint64(len(s.entries)), nil
+}
+
+func (s *sliceJournal) Sync() error { return nil }
+
+func (s *sliceJournal) Replay(fn func(*journal.Entry) error) error {
+	for i, v := range s.entries {
+		if err := fn(&journal.Entry{Key: recordKey, Value: v, Seq: uint64(i + 1)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendN(t *testing.T, l *Log, n int) {
+	for i := range n {
+		_, err := l.Append(Record{
+			Action:  "journal.truncate",
+			Actor:   Actor{Addr: "10.0.0.1", RequestID: fmt.Sprint("req-", i)},
+			Details: map[string]string{"query": fmt.Sprint("before=", i)},
+			Status:  200,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestLogChain(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+
+	l, err := New(j, nil)
+	require.NoError(t, err)
+	appendN(t, l, 5)
+
+	// a reopened log carries on the chain
+	l, err = New(j, nil)
+	require.NoError(t, err)
+	appendN(t, l, 2)
+	require.NoError(t, l.Verify())
+
+	records, intact, err := l.Records(0, 100)
+	require.NoError(t, err)
+	assert.True(t, intact)
+	require.Len(t, records, 7)
+	for i, rec := range records {
+		assert.Equal(t, uint64(i+1), rec.Seq)
+		if i > 0 {
+			assert.Equal(t, records[i-1].Hash, rec.Prev)
+		}
+	}
+	assert.Empty(t, records[0].Prev)
+	assert.Equal(t, "req-1", records[1].Actor.RequestID)
+
+	records, _, err = l.Records(5, 1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, uint64(6), records[0].Seq)
+}
+
+func TestLogTampered(t *testing.T) {
+	for name, tamper := range map[string]func(j *sliceJournal){
+		"edited": func(j *sliceJournal) {
+			j.entries[2] = bytes.Replace(j.entries[2], []byte("before=2"), []byte("before=9"), 1)
+		},
+		"removed": func(j *sliceJournal) {
+			j.entries = append(j.entries[:2], j.entries[3:]...)
+		},
+		"reordered": func(j *sliceJournal) {
+			j.entries[2], j.entries[3] = j.entries[3], j.entries[2]
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			j := &sliceJournal{}
+			l, err := New(j, []byte("key"))
+			require.NoError(t, err)
+			appendN(t, l, 5)
+
+			tamper(j)
+			assert.ErrorIs(t, l.Verify(), ErrTampered)
+			records, intact, err := l.Records(0, 2)
+			require.NoError(t, err)
+			assert.True(t, intact, "the first two records are untouched")
+			assert.Len(t, records, 2)
+			_, intact, err = l.Records(0, 100)
+			require.NoError(t, err)
+			assert.False(t, intact)
+
+			l, err = New(j, []byte("key"))
+			assert.ErrorIs(t, err, ErrTampered)
+			require.NotNil(t, l)
+		})
+	}
+}
+
+func TestLogKeyed(t *testing.T) {
+	j := &sliceJournal{}
+	l, err := New(j, []byte("key"))
+	require.NoError(t, err)
+	appendN(t, l, 3)
+
+	// without the key, the chain can't be verified or rewritten
+	_, err = New(j, []byte("other"))
+	assert.ErrorIs(t, err, ErrTampered)
+	_, err = New(j, nil)
+	assert.ErrorIs(t, err, ErrTampered)
+}
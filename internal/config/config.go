@@ -11,18 +11,81 @@ import (
 )
 
 type Config struct {
-	Server    Server    `koanf:"server"`
-	Sink      Sink      `koanf:"sink"`
-	Journal   Journal   `koanf:"journal"`
-	Dedup     Dedup     `koanf:"dedup"`
-	RateLimit RateLimit `koanf:"rate_limit"`
+	Server      Server      `koanf:"server"`
+	Sink        Sink        `koanf:"sink"`
+	Journal     Journal     `koanf:"journal"`
+	Replication Replication `koanf:"replication"`
+	Election    Election    `koanf:"election"`
+	Audit       Audit       `koanf:"audit"`
+	Dedup       Dedup       `koanf:"dedup"`
+	RateLimit   RateLimit   `koanf:"rate_limit"`
+	Quota       Quota       `koanf:"quota"`
+	Backfill    Backfill    `koanf:"backfill"`
+	Watchdog    Watchdog    `koanf:"watchdog"`
+	Stats       Stats       `koanf:"stats"`
+	CoAP        CoAP        `koanf:"coap"`
+	RemoteWrite RemoteWrite `koanf:"remote_write"`
+	Debug       Debug       `koanf:"debug"`
+	Metrics     Metrics     `koanf:"metrics"`
+	Logging     Logging     `koanf:"logging"`
 }
 
 type Server struct {
 	Addr         string        `koanf:"addr"`
+	BasePath     string        `koanf:"base_path"`
 	ReadTimeout  time.Duration `koanf:"read_timeout"`
 	WriteTimeout time.Duration `koanf:"write_timeout"`
+	MaxBodySize  int           `koanf:"max_body_size"`
 	TLS          TLS           `koanf:"tls"`
+	Compression  Compression   `koanf:"compression"`
+	// TrustedProxies are the CIDRs or addresses of load balancers whose
+	// X-Forwarded-For and, with ProxyProtocol, PROXY headers are believed.
+	TrustedProxies []string `koanf:"trusted_proxies"`
+	ProxyProtocol  bool     `koanf:"proxy_protocol"`
+	// BatchWorkers decode large NDJSON batches in parallel; 0 = GOMAXPROCS.
+	BatchWorkers int `koanf:"batch_workers"`
+	// BatchLimit caps the batches processed at once.
+	BatchLimit BatchLimit `koanf:"batch_limit"`
+	// JSONDecoder is "fast" for entity.DecodeJSON or "std" for
+	// encoding/json alone.
+	JSONDecoder string `koanf:"json_decoder"`
+	// TenantLabel labels http_route_duration_seconds by the tenant a
+	// header names.
+	TenantLabel TenantLabel `koanf:"tenant_label"`
+	// IPFilter turns away clients by address, on HTTP and CoAP.
+	IPFilter IPFilter `koanf:"ip_filter"`
+	// AdminToken is the bearer token the /admin/ routes need; without one
+	// they aren't served.
+	AdminToken string `koanf:"admin_token"`
+}
+
+// IPFilter lists CIDRs or addresses: clients in Deny are refused and, when
+// Allow isn't empty, so is everyone not in it.
+type IPFilter struct {
+	Allow []string `koanf:"allow"`
+	Deny  []string `koanf:"deny"`
+}
+
+// TenantLabel takes the tenant from Header, "" for no tenant label. Only
+// the listed Tenants are labeled, or without any the first Max seen; the
+// rest are "other".
+type TenantLabel struct {
+	Header  string   `koanf:"header"`
+	Tenants []string `koanf:"tenants"`
+	Max     int      `koanf:"max"`
+}
+
+// BatchLimit lets Concurrency batches be processed at once, 0 for no
+// limit. Up to Queue more, 0 for no limit, wait for at most Wait.
+type BatchLimit struct {
+	Concurrency int           `koanf:"concurrency"`
+	Queue       int           `koanf:"queue"`
+	Wait        time.Duration `koanf:"wait"`
+}
+
+type Compression struct {
+	Enabled bool `koanf:"enabled"`
+	MinSize int  `koanf:"min_size"`
 }
 
 type TLS struct {
@@ -34,50 +97,515 @@ type TLS struct {
 type Sink struct {
 	BufferSize    int           `koanf:"buffer_size"`
 	FlushInterval time.Duration `koanf:"flush_interval"`
+	FlushBytes    int64         `koanf:"flush_bytes"`
+	SyncInterval  time.Duration `koanf:"sync_interval"`
+	Priorities    []Priority    `koanf:"priorities"`
+	Transforms    []Transform   `koanf:"transforms"`
+	Horizon       Horizon       `koanf:"horizon"`
+	SensorNames   SensorNames   `koanf:"sensor_names"`
+	Sampling      EventSampling `koanf:"sampling"`
+	// Overflow is what a full buffer does: "evict" the oldest event,
+	// "reject" the new one, or "block" for up to OverflowWait.
+	Overflow     string        `koanf:"overflow"`
+	OverflowWait time.Duration `koanf:"overflow_wait"`
+	// SpillFile takes events evicted from a full buffer until the next
+	// flush; empty writes them to the journal one by one.
+	SpillFile string `koanf:"spill_file"`
+	// KeyFormat lays out journal keys: "text" (sensor_<name>{ts=<ts>})
+	// or the shorter, length-prefixed "binary".
+	KeyFormat string `koanf:"key_format"`
+	// FlushRetry is how a failed flush is retried.
+	FlushRetry FlushRetry `koanf:"flush_retry"`
+	// Canary checks the write path with a synthetic event every Interval.
+	Canary Canary `koanf:"canary"`
+	// Pipeline orders the middleware stages by name, built-in or
+	// registered with sink.RegisterStage; empty means sink.DefaultPipeline.
+	// Stages holds options for the registered ones, keyed by name.
+	Pipeline []string                  `koanf:"pipeline"`
+	Stages   map[string]map[string]any `koanf:"stages"`
+	// StageMetrics times each pipeline stage and counts the events it
+	// fails.
+	StageMetrics bool `koanf:"stage_metrics"`
+}
+
+// FlushRetry retries a failed flush after Base, doubling up to Max, and
+// after DegradeAfter failures in a row rejects events with 503 until a
+// flush succeeds.
+type FlushRetry struct {
+	Base         time.Duration `koanf:"base"`
+	Max          time.Duration `koanf:"max"`
+	DegradeAfter int           `koanf:"degrade_after"`
+}
+
+// Canary appends an event under the "_canary" sensor every Interval and
+// fails /readyz when one isn't in the journal within Timeout.
+type Canary struct {
+	Enabled  bool          `koanf:"enabled"`
+	Interval time.Duration `koanf:"interval"`
+	Timeout  time.Duration `koanf:"timeout"`
+}
+
+// Horizon rejects events older than MaxAge, which should match how long
+// the journal keeps data. Action is "reject" or "tag".
+type Horizon struct {
+	MaxAge time.Duration `koanf:"max_age"`
+	Action string        `koanf:"action"`
+}
+
+// EventSampling keeps a share of the events of high-rate sensors. Rules
+// can be changed at runtime through /admin/sampling while it's enabled.
+type EventSampling struct {
+	Enabled bool         `koanf:"enabled"`
+	Rules   []SampleRule `koanf:"rules"`
+}
+
+type SampleRule struct {
+	Name        string   `koanf:"name"`
+	Patterns    []string `koanf:"patterns"`
+	Every       int      `koanf:"every"`
+	Probability float64  `koanf:"probability"`
+}
+
+type Priority struct {
+	Name       string   `koanf:"name"`
+	Patterns   []string `koanf:"patterns"`
+	BufferSize int      `koanf:"buffer_size"`
+}
+
+// SensorNames normalizes sensor names, lowercasing them and mapping
+// prefixes, and rejects the ones with characters outside Charset, a
+// regexp character class, or longer than MaxLength.
+type SensorNames struct {
+	Enabled   bool           `koanf:"enabled"`
+	Charset   string         `koanf:"charset"`
+	MaxLength int            `koanf:"max_length"`
+	Lowercase bool           `koanf:"lowercase"`
+	Prefixes  []SensorPrefix `koanf:"prefixes"`
+}
+
+type SensorPrefix struct {
+	From string `koanf:"from"`
+	To   string `koanf:"to"`
+}
+
+type Transform struct {
+	Name   string  `koanf:"name"`
+	Match  string  `koanf:"match"`
+	Rename string  `koanf:"rename"`
+	Scale  float64 `koanf:"scale"`
+	Offset float64 `koanf:"offset"`
+	Min    *int    `koanf:"min"`
+	Max    *int    `koanf:"max"`
 }
 
 type Journal struct {
-	Dir           string `koanf:"dir"`
-	MaxSize       int64  `koanf:"max_size"`
+	Dir     string `koanf:"dir"`
+	MaxSize int64  `koanf:"max_size"`
+	// Layout is "flat", all files in Dir, or "dated", segments in
+	// YYYY/MM/DD subdirectories.
+	Layout string `koanf:"layout"`
+	// Format is how new segments lay out their records, "binary" or
+	// "protobuf", and Checksum their CRC32, "crc32c" or "ieee".
+	Format        string `koanf:"format"`
+	Checksum      string `koanf:"checksum"`
 	EncryptionKey string `koanf:"encryption_key"`
+	// KeyProvider fetches the key from elsewhere, instead of EncryptionKey.
+	KeyProvider     KeyProvider `koanf:"key_provider"`
+	VerifyChecksums bool        `koanf:"verify_checksums"`
+	AtomicBatches   bool        `koanf:"atomic_batches"`
+	// Replicas receive every write synchronously alongside Dir.
+	Replicas    []JournalReplica `koanf:"replicas"`
+	ReplicaMode string           `koanf:"replica_mode"`
+	// Routes send matching sensors' events to journals of their own,
+	// first match wins; everything else goes to Dir and its replicas.
+	Routes []JournalRoute `koanf:"routes"`
+	// DiskGuard rejects events with 507 while the journal in Dir is past
+	// its limits.
+	DiskGuard DiskGuard `koanf:"disk_guard"`
+}
+
+// DiskGuard checks the journal every Interval. Once it takes MaxBytes or
+// its filesystem has less than MinFreeBytes free, Compact rewrites
+// segments without their expired entries, and if that isn't enough
+// events are rejected until it's back under.
+type DiskGuard struct {
+	Enabled      bool          `koanf:"enabled"`
+	MaxBytes     uint64        `koanf:"max_bytes"`
+	MinFreeBytes uint64        `koanf:"min_free_bytes"`
+	Compact      bool          `koanf:"compact"`
+	Interval     time.Duration `koanf:"interval"`
+}
+
+type JournalReplica struct {
+	Dir           string      `koanf:"dir"`
+	EncryptionKey string      `koanf:"encryption_key"`
+	KeyProvider   KeyProvider `koanf:"key_provider"`
+}
+
+// JournalRoute writes events of sensors matching Patterns (path.Match
+// globs) to the journal in Dir, or to the main journal when Dir is empty.
+type JournalRoute struct {
+	Name          string      `koanf:"name"`
+	Patterns      []string    `koanf:"patterns"`
+	Dir           string      `koanf:"dir"`
+	EncryptionKey string      `koanf:"encryption_key"`
+	KeyProvider   KeyProvider `koanf:"key_provider"`
+}
+
+// KeyProvider selects where an encryption key comes from. Type is "file",
+// "env", "vault" or "aws_kms"; empty means none.
+type KeyProvider struct {
+	Type   string `koanf:"type"`
+	Path   string `koanf:"path"`
+	Env    string `koanf:"env"`
+	Vault  Vault  `koanf:"vault"`
+	AWSKMS AWSKMS `koanf:"aws_kms"`
+}
+
+type Vault struct {
+	Addr  string `koanf:"addr"`
+	Token string `koanf:"token"`
+	Path  string `koanf:"path"`
+	Field string `koanf:"field"`
+}
+
+type AWSKMS struct {
+	Region     string `koanf:"region"`
+	Endpoint   string `koanf:"endpoint"`
+	Ciphertext string `koanf:"ciphertext"`
+}
+
+// RemoteWrite names each sample's sensor after its metric plus the values
+// of Labels, and multiplies values by Scale before rounding them.
+type RemoteWrite struct {
+	Enabled bool     `koanf:"enabled"`
+	Labels  []string `koanf:"labels"`
+	Scale   float64  `koanf:"scale"`
 }
 
 type Dedup struct {
 	Enabled          bool          `koanf:"enabled"`
 	CleaningInterval time.Duration `koanf:"cleaning_interval"`
+	BatchTTL         time.Duration `koanf:"batch_ttl"`
+	// Shards splits the ID set into this many independently locked maps.
+	Shards int `koanf:"shards"`
+	// ContentHash rejects an ID seen again on different content rather
+	// than dropping it as a duplicate.
+	ContentHash bool `koanf:"content_hash"`
+	// Recent is how many of the latest duplicates /admin/dedup lists.
+	Recent int `koanf:"recent"`
+}
+
+// Stats tracks per-sensor counts, last seen times and min/max/mean over a
+// sliding Window, served on /sensors and rebuilt from the journal on start.
+type Stats struct {
+	Enabled  bool          `koanf:"enabled"`
+	Window   time.Duration `koanf:"window"`
+	Liveness Liveness      `koanf:"liveness"`
+	Health   Health        `koanf:"health"`
+}
+
+// Health classifies silent sensors by the DeviceInfo reports of their
+// device, on /sensors and in sensor_health.
+type Health struct {
+	Enabled          bool          `koanf:"enabled"`
+	SilentAfter      time.Duration `koanf:"silent_after"`
+	HeartbeatTimeout time.Duration `koanf:"heartbeat_timeout"`
+	LowBattery       float64       `koanf:"low_battery"`
+	CheckInterval    time.Duration `koanf:"check_interval"`
+}
+
+// Liveness alerts when a sensor matching a rule hasn't reported for the
+// rule's Every. Alerts are logged, counted and, with Webhook set, POSTed.
+type Liveness struct {
+	Rules         []LivenessRule `koanf:"rules"`
+	CheckInterval time.Duration  `koanf:"check_interval"`
+	Webhook       string         `koanf:"webhook"`
+	// WebhookHeaders are "Name: value" lines, e.g. for an Authorization.
+	WebhookHeaders []string `koanf:"webhook_headers"`
+}
+
+type LivenessRule struct {
+	Name   string        `koanf:"name"`
+	Sensor string        `koanf:"sensor"`
+	Every  time.Duration `koanf:"every"`
 }
 
 type RateLimit struct {
-	Enabled     bool    `koanf:"enabled"`
-	BytesPerSec float64 `koanf:"bytes_per_sec"`
+	Enabled      bool          `koanf:"enabled"`
+	BytesPerSec  float64       `koanf:"bytes_per_sec"`
+	EventsPerSec float64       `koanf:"events_per_sec"`
+	MaxWait      time.Duration `koanf:"max_wait"`
 }
 
-func Load(path string) (*Config, error) {
-	k := koanf.New(".")
+type Quota struct {
+	Enabled      bool          `koanf:"enabled"`
+	EventsPerDay int64         `koanf:"events_per_day"`
+	BytesPerDay  int64         `koanf:"bytes_per_day"`
+	StateFile    string        `koanf:"state_file"`
+	SaveInterval time.Duration `koanf:"save_interval"`
+}
+
+// Backfill takes historical events on /ingest/backfill past rate limiting
+// and dedup, under a daily per-sensor quota of their own; a zero limit is
+// unlimited. Counters are saved every quota.save_interval.
+type Backfill struct {
+	Enabled      bool   `koanf:"enabled"`
+	EventsPerDay int64  `koanf:"events_per_day"`
+	BytesPerDay  int64  `koanf:"bytes_per_day"`
+	StateFile    string `koanf:"state_file"`
+}
+
+// Replication streams the journal to standby sinks listed in Peers, and
+// with Receive enabled takes a primary's entries into this one's. Offsets
+// are kept in StateDir.
+type Replication struct {
+	// Name tells this sink apart on its peers; defaults to the hostname.
+	Name      string            `koanf:"name"`
+	Peers     []ReplicationPeer `koanf:"peers"`
+	Interval  time.Duration     `koanf:"interval"`
+	BatchSize int               `koanf:"batch_size"`
+	StateDir  string            `koanf:"state_dir"`
+	Receive   ReplicaReceive    `koanf:"receive"`
+	Follow    ReplicaFollow     `koanf:"follow"`
+}
+
+type ReplicationPeer struct {
+	URL   string `koanf:"url"`
+	Token string `koanf:"token"`
+}
+
+type ReplicaReceive struct {
+	Enabled bool   `koanf:"enabled"`
+	Token   string `koanf:"token"`
+}
+
+// ReplicaFollow makes the sink a read-only follower, refusing ingest and
+// pointing writers to Leader.
+type ReplicaFollow struct {
+	Enabled bool   `koanf:"enabled"`
+	Leader  string `koanf:"leader"`
+}
+
+// Election picks the leader of an HA pair; the other one follows as with
+// Replication.Follow.
+type Election struct {
+	Enabled bool   `koanf:"enabled"`
+	Backend string `koanf:"backend"`
+	// ID tells the candidates apart; defaults to the hostname.
+	ID string `koanf:"id"`
+	// URL is where this sink takes writes, the followers' leader hint.
+	URL  string        `koanf:"url"`
+	TTL  time.Duration `koanf:"ttl"`
+	File ElectionFile  `koanf:"file"`
+}
+
+type ElectionFile struct {
+	Path string `koanf:"path"`
+}
+
+// Audit records admin API actions in a hash-chained journal in Dir. With
+// HMACKey, or a key from KeyProvider, the hashes are keyed.
+type Audit struct {
+	Enabled     bool        `koanf:"enabled"`
+	Dir         string      `koanf:"dir"`
+	HMACKey     string      `koanf:"hmac_key"`
+	KeyProvider KeyProvider `koanf:"key_provider"`
+}
+
+// Watchdog checks the memory the process holds every Interval. Above
+// SoftLimit bytes it shrinks the dedup set and flushes early; above
+// HardLimit it also rejects events with 503 until usage drops back.
+type Watchdog struct {
+	Enabled   bool          `koanf:"enabled"`
+	SoftLimit uint64        `koanf:"soft_limit"`
+	HardLimit uint64        `koanf:"hard_limit"`
+	Interval  time.Duration `koanf:"interval"`
+}
+
+type CoAP struct {
+	Enabled bool   `koanf:"enabled"`
+	Addr    string `koanf:"addr"`
+}
+
+type Debug struct {
+	Enabled              bool   `koanf:"enabled"`
+	Addr                 string `koanf:"addr"`
+	Token                string `koanf:"token"`
+	BlockProfileRate     int    `koanf:"block_profile_rate"`
+	MutexProfileFraction int    `koanf:"mutex_profile_fraction"`
+}
 
-	cfg := &Config{
+type Metrics struct {
+	PushURL                string        `koanf:"push_url"`
+	PushInterval           time.Duration `koanf:"push_interval"`
+	PushMethod             string        `koanf:"push_method"`
+	PushHeaders            []string      `koanf:"push_headers"`
+	ExtraLabels            string        `koanf:"extra_labels"`
+	PushDisableCompression bool          `koanf:"push_disable_compression"`
+}
+
+type Logging struct {
+	Level      string   `koanf:"level"`
+	Format     string   `koanf:"format"`
+	Output     string   `koanf:"output"`
+	AddSource  bool     `koanf:"add_source"`
+	MaxSize    int64    `koanf:"max_size"`
+	MaxBackups int      `koanf:"max_backups"`
+	Sampling   Sampling `koanf:"sampling"`
+	// Ship also sends logs to a remote syslog or GELF endpoint.
+	Ship LogShip `koanf:"ship"`
+}
+
+// LogShip sends logs to Address over Network, udp or tcp, as Protocol,
+// syslog or gelf; empty Protocol disables it. Up to Buffer messages wait
+// to be sent, the rest are dropped.
+type LogShip struct {
+	Protocol string `koanf:"protocol"`
+	Network  string `koanf:"network"`
+	Address  string `koanf:"address"`
+	Buffer   int    `koanf:"buffer"`
+}
+
+type Sampling struct {
+	Initial  int           `koanf:"initial"`
+	Interval time.Duration `koanf:"interval"`
+}
+
+// Default returns the configuration Load starts from.
+func Default() *Config {
+	return &Config{
 		Server: Server{
 			Addr:         ":8080",
 			ReadTimeout:  10 * time.Second,
 			WriteTimeout: 10 * time.Second,
+			MaxBodySize:  4 << 20,
+			JSONDecoder:  "fast",
+			BatchLimit: BatchLimit{
+				Queue: 64,
+				Wait:  5 * time.Second,
+			},
+			Compression: Compression{
+				Enabled: true,
+				MinSize: 1024,
+			},
+			TenantLabel: TenantLabel{Max: 32},
 		},
 		Sink: Sink{
 			BufferSize:    128,
 			FlushInterval: time.Second,
+			Horizon: Horizon{
+				Action: "reject",
+			},
+			SensorNames: SensorNames{
+				Charset:   "A-Za-z0-9_.:-",
+				MaxLength: 128,
+			},
+			Overflow:     "evict",
+			OverflowWait: time.Second,
+			KeyFormat:    "text",
+			FlushRetry: FlushRetry{
+				Base:         100 * time.Millisecond,
+				Max:          5 * time.Second,
+				DegradeAfter: 5,
+			},
+			Canary: Canary{
+				Interval: 30 * time.Second,
+				Timeout:  5 * time.Second,
+			},
 		},
 		Journal: Journal{
-			Dir:     "./data/journal",
-			MaxSize: 64 * 1024 * 1024,
+			Dir:         "./data/journal",
+			MaxSize:     64 * 1024 * 1024,
+			Layout:      "flat",
+			Format:      "binary",
+			Checksum:    "crc32c",
+			ReplicaMode: "all",
+			DiskGuard: DiskGuard{
+				Compact:  true,
+				Interval: 10 * time.Second,
+			},
+		},
+		Replication: Replication{
+			Interval:  time.Second,
+			BatchSize: 1000,
+			StateDir:  "./data/replication",
+		},
+		Election: Election{
+			Backend: "file",
+			TTL:     10 * time.Second,
+		},
+		Audit: Audit{
+			Dir: "./data/audit",
 		},
 		Dedup: Dedup{
 			Enabled:          true,
 			CleaningInterval: 10 * time.Minute,
+			BatchTTL:         10 * time.Minute,
+			Shards:           64,
+			Recent:           100,
 		},
 		RateLimit: RateLimit{
 			Enabled:     true,
 			BytesPerSec: 1024 * 1024,
 		},
+		Quota: Quota{
+			StateFile:    "./data/quota.json",
+			SaveInterval: 30 * time.Second,
+		},
+		Backfill: Backfill{
+			StateFile: "./data/backfill_quota.json",
+		},
+		Watchdog: Watchdog{
+			SoftLimit: 320 << 20,
+			HardLimit: 400 << 20,
+			Interval:  5 * time.Second,
+		},
+		Stats: Stats{
+			Window: time.Hour,
+			Liveness: Liveness{
+				CheckInterval: 10 * time.Second,
+			},
+			Health: Health{
+				SilentAfter:      10 * time.Minute,
+				HeartbeatTimeout: 5 * time.Minute,
+				LowBattery:       10,
+				CheckInterval:    10 * time.Second,
+			},
+		},
+		CoAP: CoAP{
+			Addr: ":5683",
+		},
+		RemoteWrite: RemoteWrite{
+			Scale: 1,
+		},
+		Debug: Debug{
+			Addr: "127.0.0.1:6060",
+		},
+		Metrics: Metrics{
+			PushInterval: 15 * time.Second,
+			PushMethod:   "POST",
+		},
+		Logging: Logging{
+			Level:      "debug",
+			Format:     "text",
+			Output:     "stdout",
+			AddSource:  true,
+			MaxBackups: 5,
+			Sampling: Sampling{
+				Interval: time.Second,
+			},
+			Ship: LogShip{
+				Network: "udp",
+				Buffer:  1024,
+			},
+		},
 	}
+}
+
+func Load(path string) (*Config, error) {
+	k := koanf.New(".")
+	cfg := Default()
 
 	if path != "" {
 		if err := k.Load(file.Provider(path), yaml.Parser()); err != nil {
@@ -0,0 +1,167 @@
+// Package election decides which sink of an HA pair takes writes.
+// Candidates contend for a Lease that expires unless renewed: the holder
+// leads and renews it every third of its TTL, the others follow and take
+// over once it lapses, so a failed leader is replaced within about one TTL.
+//
+// Expiry is compared against each candidate's own clock, so the hosts'
+// clocks have to agree to well within the TTL.
+package election
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is the state of a lease.
+type Record struct {
+	Holder string `json:"holder"`
+	// URL is where the holder takes writes, handed to clients of the
+	// others as the leader hint.
+	URL     string    `json:"url,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lease is what candidates contend for. FileLease keeps it in a file on
+// storage both sinks mount; a lease kept in etcd or Consul would implement
+// the same two methods.
+type Lease interface {
+	// Acquire takes the lease for rec.Holder until rec.Expires if it's
+	// free, expired or already theirs, and returns the lease as it stands
+	// afterwards.
+	Acquire(ctx context.Context, rec Record) (Record, error)
+	// Release gives up holder's lease, so the others needn't wait for it
+	// to expire. It does nothing if someone else holds it.
+	Release(ctx context.Context, holder string) error
+}
+
+// Elector runs one candidate's side of the election.
+type Elector struct {
+	lease    Lease
+	id       string
+	url      string
+	ttl      time.Duration
+	onChange func(leading bool, leader string)
+	now      func() time.Time
+
+	mu        sync.Mutex
+	decided   bool
+	leading   bool
+	leader    string
+	heldUntil time.Time
+}
+
+type Option func(*Elector)
+
+// WithTTL sets how long a lease lasts without renewal, 10s by default.
+func WithTTL(ttl time.Duration) Option {
+	return func(e *Elector) { e.ttl = ttl }
+}
+
+// WithURL is this sink's address as writers reach it, e.g.
+// "http://gw-01:8080".
+func WithURL(url string) Option {
+	return func(e *Elector) { e.url = url }
+}
+
+// WithOnChange calls fn once the first round is decided and whenever the
+// role or the leader changes after that; leader is the leader's URL.
+func WithOnChange(fn func(leading bool, leader string)) Option {
+	return func(e *Elector) { e.onChange = fn }
+}
+
+// New makes a candidate named id, which defaults to the hostname and must
+// differ between the sinks of a pair.
+func New(lease Lease, id string, opts ...Option) *Elector {
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+	e := &Elector{lease: lease, id: id, ttl: 10 * time.Second, now: time.Now}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Leader reports the leader's URL and whether this candidate is it.
+func (e *Elector) Leader() (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.leading
+}
+
+// Run contends for the lease until ctx is done, then releases it if held.
+func (e *Elector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	e.Step(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			if _, leading := e.Leader(); leading {
+				rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := e.lease.Release(rctx, e.id); err != nil {
+					slog.Warn("releasing leader lease failed", "error", err)
+				}
+				cancel()
+				e.set(false, "")
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			e.Step(ctx)
+		}
+	}
+}
+
+// Step runs one round: take or renew the lease, or learn who holds it.
+// A leader that can't reach the lease steps down a renewal interval
+// before its lease runs out, ahead of the others taking over.
+func (e *Elector) Step(ctx context.Context) {
+	now := e.now()
+	rec, err := e.lease.Acquire(ctx, Record{Holder: e.id, URL: e.url, Expires: now.Add(e.ttl)})
+	if err != nil {
+		electionErrors.Inc()
+		slog.Warn("leader lease unavailable", "error", err)
+		e.mu.Lock()
+		lapsed := e.leading && !now.Before(e.heldUntil.Add(-e.ttl/3))
+		e.mu.Unlock()
+		if lapsed {
+			e.set(false, "")
+		}
+		return
+	}
+	if rec.Holder == e.id {
+		e.mu.Lock()
+		e.heldUntil = rec.Expires
+		e.mu.Unlock()
+		e.set(true, e.url)
+		return
+	}
+	e.set(false, rec.URL)
+}
+
+func (e *Elector) set(leading bool, leader string) {
+	e.mu.Lock()
+	changed := !e.decided || e.leading != leading || e.leader != leader
+	flipped := e.decided && e.leading != leading
+	e.decided, e.leading, e.leader = true, leading, leader
+	e.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	if leading {
+		isLeader.Set(1)
+	} else {
+		isLeader.Set(0)
+	}
+	if flipped {
+		roleChanges.Inc()
+	}
+	slog.Info("leader election", "id", e.id, "leading", leading, "leader", leader)
+	if e.onChange != nil {
+		e.onChange(leading, leader)
+	}
+}
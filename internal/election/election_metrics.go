@@ -0,0 +1,9 @@
+package election
+
+import "github.com/VictoriaMetrics/metrics"
+
+var (
+	isLeader       = metrics.NewGauge("election_is_leader", nil)
+	roleChanges    = metrics.NewCounter("election_role_changes_total")
+	electionErrors = metrics.NewCounter("election_errors_total")
+)
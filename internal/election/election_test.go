@@ -0,0 +1,137 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+type roleLog struct {
+	leading bool
+	leader  string
+	changes int
+}
+
+func (r *roleLog) onChange(leading bool, leader string) {
+	r.leading, r.leader = leading, leader
+	r.changes++
+}
+
+func candidate(lease Lease, c *clock, id string, log *roleLog) *Elector {
+	e := New(lease, id, WithTTL(9*time.Second), WithURL("http://"+id+":8080"), WithOnChange(log.onChange))
+	e.now = c.now
+	return e
+}
+
+func TestElection(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	lease := NewFileLease(filepath.Join(t.TempDir(), "lease.json"))
+	lease.now = c.now
+
+	var logA, logB roleLog
+	a := candidate(lease, c, "gw-01", &logA)
+	b := candidate(lease, c, "gw-02", &logB)
+
+	a.Step(ctx)
+	b.Step(ctx)
+	assert.True(t, logA.leading)
+	assert.False(t, logB.leading)
+	assert.Equal(t, "http://gw-01:8080", logB.leader)
+	assert.Equal(t, 1, logB.changes, "the first round is reported")
+
+	// renewed in time, the lease stays with gw-01
+	for range 5 {
+		c.advance(3 * time.Second)
+		a.Step(ctx)
+		b.Step(ctx)
+	}
+	assert.True(t, logA.leading)
+	assert.False(t, logB.leading)
+	assert.Equal(t, 1, logB.changes)
+
+	// gw-01 stops renewing; gw-02 takes over once the lease has expired
+	c.advance(6 * time.Second)
+	b.Step(ctx)
+	assert.False(t, logB.leading)
+	c.advance(3 * time.Second)
+	b.Step(ctx)
+	assert.True(t, logB.leading)
+	assert.Equal(t, "http://gw-02:8080", logB.leader)
+
+	a.Step(ctx)
+	assert.False(t, logA.leading)
+	assert.Equal(t, "http://gw-02:8080", logA.leader)
+	leader, leading := a.Leader()
+	assert.False(t, leading)
+	assert.Equal(t, "http://gw-02:8080", leader)
+
+	// a released lease is free right away
+	require.NoError(t, lease.Release(ctx, "gw-01"), "releasing someone else's lease does nothing")
+	a.Step(ctx)
+	assert.False(t, logA.leading)
+	require.NoError(t, lease.Release(ctx, "gw-02"))
+	a.Step(ctx)
+	assert.True(t, logA.leading)
+}
+
+type brokenLease struct {
+	Lease
+	err error
+}
+
+func (l *brokenLease) Acquire(ctx context.Context, rec Record) (Record, error) {
+	if l.err != nil {
+		return Record{}, l.err
+	}
+	return l.Lease.Acquire(ctx, rec)
+}
+
+func TestElectionLeaseUnavailable(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	fl := NewFileLease(filepath.Join(t.TempDir(), "lease.json"))
+	fl.now = c.now
+	lease := &brokenLease{Lease: fl}
+
+	var log roleLog
+	e := candidate(lease, c, "gw-01", &log)
+	e.Step(ctx)
+	require.True(t, log.leading)
+
+	lease.err = errors.New("nfs: server not responding")
+	c.advance(3 * time.Second)
+	e.Step(ctx)
+	assert.True(t, log.leading, "the lease still has 6s left")
+	c.advance(3 * time.Second)
+	e.Step(ctx)
+	assert.False(t, log.leading, "steps down a renewal interval before the lease runs out")
+	assert.Equal(t, 2, log.changes)
+}
+
+func TestElectorRunReleases(t *testing.T) {
+	lease := NewFileLease(filepath.Join(t.TempDir(), "lease.json"))
+	var log roleLog
+	e := New(lease, "gw-01", WithTTL(time.Hour), WithOnChange(log.onChange))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+	require.Eventually(t, func() bool { _, leading := e.Leader(); return leading }, time.Second, time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	rec, err := lease.Acquire(context.Background(), Record{Holder: "gw-02", Expires: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	assert.Equal(t, "gw-02", rec.Holder, "released on shutdown")
+}
@@ -0,0 +1,79 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileLease keeps the lease as JSON in a file on storage every candidate
+// mounts, such as NFS, with a lock file next to it serializing updates.
+type FileLease struct {
+	path string
+	now  func() time.Time
+}
+
+func NewFileLease(path string) *FileLease {
+	return &FileLease{path: path, now: time.Now}
+}
+
+func (l *FileLease) Acquire(_ context.Context, rec Record) (Record, error) {
+	unlock, err := lockFile(l.path + ".lock")
+	if err != nil {
+		return Record{}, err
+	}
+	defer unlock()
+
+	cur, err := l.read()
+	if err != nil {
+		return Record{}, err
+	}
+	if cur.Holder != "" && cur.Holder != rec.Holder && l.now().Before(cur.Expires) {
+		return cur, nil
+	}
+	return rec, l.write(rec)
+}
+
+func (l *FileLease) Release(_ context.Context, holder string) error {
+	unlock, err := lockFile(l.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cur, err := l.read()
+	if err != nil || cur.Holder != holder {
+		return err
+	}
+	return l.write(Record{})
+}
+
+func (l *FileLease) read() (Record, error) {
+	var rec Record
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rec, nil
+	}
+	if err != nil {
+		return rec, err
+	}
+	return rec, json.Unmarshal(data, &rec)
+}
+
+func (l *FileLease) write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
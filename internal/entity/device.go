@@ -0,0 +1,21 @@
+package entity
+
+//go:generate msgp
+
+// DeviceInfo is what a device reports about itself rather than about what
+// it measures: the firmware it runs, its battery and its link, for
+// watching the health of a fleet. It's journaled apart from sensor events,
+// under keys of its own.
+type DeviceInfo struct {
+	Device   string `msg:"device" json:"device"`
+	Firmware string `msg:"firmware,omitempty" json:"firmware,omitempty"`
+	// Battery is the charge left in percent, nil for mains-powered
+	// devices or ones that can't tell.
+	Battery *float64 `msg:"battery,omitempty" json:"battery,omitempty"`
+	// RSSI is the received signal strength in dBm, nil if unknown.
+	RSSI *int `msg:"rssi,omitempty" json:"rssi,omitempty"`
+	// Sensors are the names of the sensors the device reads, which go
+	// offline with it.
+	Sensors       []string `msg:"sensors,omitempty" json:"sensors,omitempty"`
+	UnixTimestamp int64    `msg:"ts" json:"ts"`
+}
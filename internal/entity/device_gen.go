@@ -0,0 +1,424 @@
+// Code generated by github.com/tinylib/msgp DO NOT EDIT.
+
+package entity
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *DeviceInfo) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		err = msgp.WrapError(err)
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			err = msgp.WrapError(err)
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "device":
+			z.Device, err = dc.ReadString()
+			if err != nil {
+				err = msgp.WrapError(err, "Device")
+				return
+			}
+		case "firmware":
+			z.Firmware, err = dc.ReadString()
+			if err != nil {
+				err = msgp.WrapError(err, "Firmware")
+				return
+			}
+		case "battery":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					err = msgp.WrapError(err, "Battery")
+					return
+				}
+				z.Battery = nil
+			} else {
+				if z.Battery == nil {
+					z.Battery = new(float64)
+				}
+				*z.Battery, err = dc.ReadFloat64()
+				if err != nil {
+					err = msgp.WrapError(err, "Battery")
+					return
+				}
+			}
+		case "rssi":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					err = msgp.WrapError(err, "RSSI")
+					return
+				}
+				z.RSSI = nil
+			} else {
+				if z.RSSI == nil {
+					z.RSSI = new(int)
+				}
+				*z.RSSI, err = dc.ReadInt()
+				if err != nil {
+					err = msgp.WrapError(err, "RSSI")
+					return
+				}
+			}
+		case "sensors":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				err = msgp.WrapError(err, "Sensors")
+				return
+			}
+			if cap(z.Sensors) >= int(zb0002) {
+				z.Sensors = (z.Sensors)[:zb0002]
+			} else {
+				z.Sensors = make([]string, zb0002)
+			}
+			for za0001 := range z.Sensors {
+				z.Sensors[za0001], err = dc.ReadString()
+				if err != nil {
+					err = msgp.WrapError(err, "Sensors", za0001)
+					return
+				}
+			}
+		case "ts":
+			z.UnixTimestamp, err = dc.ReadInt64()
+			if err != nil {
+				err = msgp.WrapError(err, "UnixTimestamp")
+				return
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				err = msgp.WrapError(err)
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *DeviceInfo) EncodeMsg(en *msgp.Writer) (err error) {
+	// check for omitted fields
+	zb0001Len := uint32(6)
+	var zb0001Mask uint8 /* 6 bits */
+	_ = zb0001Mask
+	if z.Firmware == "" {
+		zb0001Len--
+		zb0001Mask |= 0x2
+	}
+	if z.Battery == nil {
+		zb0001Len--
+		zb0001Mask |= 0x4
+	}
+	if z.RSSI == nil {
+		zb0001Len--
+		zb0001Mask |= 0x8
+	}
+	if z.Sensors == nil {
+		zb0001Len--
+		zb0001Mask |= 0x10
+	}
+	// variable map header, size zb0001Len
+	err = en.Append(0x80 | uint8(zb0001Len))
+	if err != nil {
+		return
+	}
+
+	// skip if no fields are to be emitted
+	if zb0001Len != 0 {
+		// write "device"
+		err = en.Append(0xa6, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65)
+		if err != nil {
+			return
+		}
+		err = en.WriteString(z.Device)
+		if err != nil {
+			err = msgp.WrapError(err, "Device")
+			return
+		}
+		if (zb0001Mask & 0x2) == 0 { // if not omitted
+			// write "firmware"
+			err = en.Append(0xa8, 0x66, 0x69, 0x72, 0x6d, 0x77, 0x61, 0x72, 0x65)
+			if err != nil {
+				return
+			}
+			err = en.WriteString(z.Firmware)
+			if err != nil {
+				err = msgp.WrapError(err, "Firmware")
+				return
+			}
+		}
+		if (zb0001Mask & 0x4) == 0 { // if not omitted
+			// write "battery"
+			err = en.Append(0xa7, 0x62, 0x61, 0x74, 0x74, 0x65, 0x72, 0x79)
+			if err != nil {
+				return
+			}
+			if z.Battery == nil {
+				err = en.WriteNil()
+				if err != nil {
+					return
+				}
+			} else {
+				err = en.WriteFloat64(*z.Battery)
+				if err != nil {
+					err = msgp.WrapError(err, "Battery")
+					return
+				}
+			}
+		}
+		if (zb0001Mask & 0x8) == 0 { // if not omitted
+			// write "rssi"
+			err = en.Append(0xa4, 0x72, 0x73, 0x73, 0x69)
+			if err != nil {
+				return
+			}
+			if z.RSSI == nil {
+				err = en.WriteNil()
+				if err != nil {
+					return
+				}
+			} else {
+				err = en.WriteInt(*z.RSSI)
+				if err != nil {
+					err = msgp.WrapError(err, "RSSI")
+					return
+				}
+			}
+		}
+		if (zb0001Mask & 0x10) == 0 { // if not omitted
+			// write "sensors"
+			err = en.Append(0xa7, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x73)
+			if err != nil {
+				return
+			}
+			err = en.WriteArrayHeader(uint32(len(z.Sensors)))
+			if err != nil {
+				err = msgp.WrapError(err, "Sensors")
+				return
+			}
+			for za0001 := range z.Sensors {
+				err = en.WriteString(z.Sensors[za0001])
+				if err != nil {
+					err = msgp.WrapError(err, "Sensors", za0001)
+					return
+				}
+			}
+		}
+		// write "ts"
+		err = en.Append(0xa2, 0x74, 0x73)
+		if err != nil {
+			return
+		}
+		err = en.WriteInt64(z.UnixTimestamp)
+		if err != nil {
+			err = msgp.WrapError(err, "UnixTimestamp")
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *DeviceInfo) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// check for omitted fields
+	zb0001Len := uint32(6)
+	var zb0001Mask uint8 /* 6 bits */
+	_ = zb0001Mask
+	if z.Firmware == "" {
+		zb0001Len--
+		zb0001Mask |= 0x2
+	}
+	if z.Battery == nil {
+		zb0001Len--
+		zb0001Mask |= 0x4
+	}
+	if z.RSSI == nil {
+		zb0001Len--
+		zb0001Mask |= 0x8
+	}
+	if z.Sensors == nil {
+		zb0001Len--
+		zb0001Mask |= 0x10
+	}
+	// variable map header, size zb0001Len
+	o = append(o, 0x80|uint8(zb0001Len))
+
+	// skip if no fields are to be emitted
+	if zb0001Len != 0 {
+		// string "device"
+		o = append(o, 0xa6, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65)
+		o = msgp.AppendString(o, z.Device)
+		if (zb0001Mask & 0x2) == 0 { // if not omitted
+			// string "firmware"
+			o = append(o, 0xa8, 0x66, 0x69, 0x72, 0x6d, 0x77, 0x61, 0x72, 0x65)
+			o = msgp.AppendString(o, z.Firmware)
+		}
+		if (zb0001Mask & 0x4) == 0 { // if not omitted
+			// string "battery"
+			o = append(o, 0xa7, 0x62, 0x61, 0x74, 0x74, 0x65, 0x72, 0x79)
+			if z.Battery == nil {
+				o = msgp.AppendNil(o)
+			} else {
+				o = msgp.AppendFloat64(o, *z.Battery)
+			}
+		}
+		if (zb0001Mask & 0x8) == 0 { // if not omitted
+			// string "rssi"
+			o = append(o, 0xa4, 0x72, 0x73, 0x73, 0x69)
+			if z.RSSI == nil {
+				o = msgp.AppendNil(o)
+			} else {
+				o = msgp.AppendInt(o, *z.RSSI)
+			}
+		}
+		if (zb0001Mask & 0x10) == 0 { // if not omitted
+			// string "sensors"
+			o = append(o, 0xa7, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x73)
+			o = msgp.AppendArrayHeader(o, uint32(len(z.Sensors)))
+			for za0001 := range z.Sensors {
+				o = msgp.AppendString(o, z.Sensors[za0001])
+			}
+		}
+		// string "ts"
+		o = append(o, 0xa2, 0x74, 0x73)
+		o = msgp.AppendInt64(o, z.UnixTimestamp)
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *DeviceInfo) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		err = msgp.WrapError(err)
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			err = msgp.WrapError(err)
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "device":
+			z.Device, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Device")
+				return
+			}
+		case "firmware":
+			z.Firmware, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Firmware")
+				return
+			}
+		case "battery":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.Battery = nil
+			} else {
+				if z.Battery == nil {
+					z.Battery = new(float64)
+				}
+				*z.Battery, bts, err = msgp.ReadFloat64Bytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Battery")
+					return
+				}
+			}
+		case "rssi":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.RSSI = nil
+			} else {
+				if z.RSSI == nil {
+					z.RSSI = new(int)
+				}
+				*z.RSSI, bts, err = msgp.ReadIntBytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "RSSI")
+					return
+				}
+			}
+		case "sensors":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadArrayHeaderBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Sensors")
+				return
+			}
+			if cap(z.Sensors) >= int(zb0002) {
+				z.Sensors = (z.Sensors)[:zb0002]
+			} else {
+				z.Sensors = make([]string, zb0002)
+			}
+			for za0001 := range z.Sensors {
+				z.Sensors[za0001], bts, err = msgp.ReadStringBytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Sensors", za0001)
+					return
+				}
+			}
+		case "ts":
+			z.UnixTimestamp, bts, err = msgp.ReadInt64Bytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "UnixTimestamp")
+				return
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				err = msgp.WrapError(err)
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *DeviceInfo) Msgsize() (s int) {
+	s = 1 + 7 + msgp.StringPrefixSize + len(z.Device) + 9 + msgp.StringPrefixSize + len(z.Firmware) + 8
+	if z.Battery == nil {
+		s += msgp.NilSize
+	} else {
+		s += msgp.Float64Size
+	}
+	s += 5
+	if z.RSSI == nil {
+		s += msgp.NilSize
+	} else {
+		s += msgp.IntSize
+	}
+	s += 8 + msgp.ArrayHeaderSize
+	for za0001 := range z.Sensors {
+		s += msgp.StringPrefixSize + len(z.Sensors[za0001])
+	}
+	s += 3 + msgp.Int64Size
+	return
+}
@@ -1,9 +1,24 @@
 package entity
 
 //go:generate msgp
+
+// MaxFields bounds Event.Fields when decoding msgpack, so a forged map
+// header can't make the decoder allocate for billions of entries; the
+// msgp:limit directive below has to match it.
+const MaxFields = 64
+
+//msgp:limit maps:64
+
 type Event struct {
 	IdempotencyID string `msg:"idempotency_id" json:"idempotency_id"`
 	Sensor        string `msg:"sensor" json:"sensor"`
 	Value         int    `msg:"val" json:"val"`
 	UnixTimestamp int64  `msg:"ts" json:"ts"`
+	// Fields holds the readings of a sensor that reports several channels
+	// at once, e.g. {"temp": 22.5, "hum": 48}, journaled together as one
+	// entry. Value is then usually left at 0.
+	Fields map[string]float64 `msg:"fields,omitempty" json:"fields,omitempty"`
+	// Backfill marks events loaded through the backfill endpoint rather
+	// than sent live. The sink sets it; whatever a client sends is ignored.
+	Backfill bool `msg:"backfill,omitempty" json:"backfill,omitempty"`
 }
@@ -6,6 +6,11 @@ import (
 	"github.com/tinylib/msgp/msgp"
 )
 
+// Size limits for msgp deserialization
+const (
+	zd9e37b5dlimitMaps = 64
+)
+
 // DecodeMsg implements msgp.Decodable
 func (z *Event) DecodeMsg(dc *msgp.Reader) (err error) {
 	var field []byte
@@ -16,6 +21,10 @@ func (z *Event) DecodeMsg(dc *msgp.Reader) (err error) {
 		err = msgp.WrapError(err)
 		return
 	}
+	if zb0001 > zd9e37b5dlimitMaps {
+		err = msgp.ErrLimitExceeded
+		return
+	}
 	for zb0001 > 0 {
 		zb0001--
 		field, err = dc.ReadMapKeyPtr()
@@ -48,6 +57,44 @@ func (z *Event) DecodeMsg(dc *msgp.Reader) (err error) {
 				err = msgp.WrapError(err, "UnixTimestamp")
 				return
 			}
+		case "fields":
+			var zb0002 uint32
+			zb0002, err = dc.ReadMapHeader()
+			if err != nil {
+				err = msgp.WrapError(err, "Fields")
+				return
+			}
+			if zb0002 > zd9e37b5dlimitMaps {
+				err = msgp.ErrLimitExceeded
+				return
+			}
+			if z.Fields == nil {
+				z.Fields = make(map[string]float64, zb0002)
+			} else if len(z.Fields) > 0 {
+				clear(z.Fields)
+			}
+			for zb0002 > 0 {
+				zb0002--
+				var za0001 string
+				za0001, err = dc.ReadString()
+				if err != nil {
+					err = msgp.WrapError(err, "Fields")
+					return
+				}
+				var za0002 float64
+				za0002, err = dc.ReadFloat64()
+				if err != nil {
+					err = msgp.WrapError(err, "Fields", za0001)
+					return
+				}
+				z.Fields[za0001] = za0002
+			}
+		case "backfill":
+			z.Backfill, err = dc.ReadBool()
+			if err != nil {
+				err = msgp.WrapError(err, "Backfill")
+				return
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -61,46 +108,102 @@ func (z *Event) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *Event) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 4
-	// write "idempotency_id"
-	err = en.Append(0x84, 0xae, 0x69, 0x64, 0x65, 0x6d, 0x70, 0x6f, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x69, 0x64)
-	if err != nil {
-		return
-	}
-	err = en.WriteString(z.IdempotencyID)
-	if err != nil {
-		err = msgp.WrapError(err, "IdempotencyID")
-		return
-	}
-	// write "sensor"
-	err = en.Append(0xa6, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72)
-	if err != nil {
-		return
+	// check for omitted fields
+	zb0001Len := uint32(6)
+	var zb0001Mask uint8 /* 6 bits */
+	_ = zb0001Mask
+	if z.Fields == nil {
+		zb0001Len--
+		zb0001Mask |= 0x10
 	}
-	err = en.WriteString(z.Sensor)
-	if err != nil {
-		err = msgp.WrapError(err, "Sensor")
-		return
+	if z.Backfill == false {
+		zb0001Len--
+		zb0001Mask |= 0x20
 	}
-	// write "val"
-	err = en.Append(0xa3, 0x76, 0x61, 0x6c)
+	// variable map header, size zb0001Len
+	err = en.Append(0x80 | uint8(zb0001Len))
 	if err != nil {
 		return
 	}
-	err = en.WriteInt(z.Value)
-	if err != nil {
-		err = msgp.WrapError(err, "Value")
-		return
-	}
-	// write "ts"
-	err = en.Append(0xa2, 0x74, 0x73)
-	if err != nil {
-		return
-	}
-	err = en.WriteInt64(z.UnixTimestamp)
-	if err != nil {
-		err = msgp.WrapError(err, "UnixTimestamp")
-		return
+
+	// skip if no fields are to be emitted
+	if zb0001Len != 0 {
+		// write "idempotency_id"
+		err = en.Append(0xae, 0x69, 0x64, 0x65, 0x6d, 0x70, 0x6f, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x69, 0x64)
+		if err != nil {
+			return
+		}
+		err = en.WriteString(z.IdempotencyID)
+		if err != nil {
+			err = msgp.WrapError(err, "IdempotencyID")
+			return
+		}
+		// write "sensor"
+		err = en.Append(0xa6, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72)
+		if err != nil {
+			return
+		}
+		err = en.WriteString(z.Sensor)
+		if err != nil {
+			err = msgp.WrapError(err, "Sensor")
+			return
+		}
+		// write "val"
+		err = en.Append(0xa3, 0x76, 0x61, 0x6c)
+		if err != nil {
+			return
+		}
+		err = en.WriteInt(z.Value)
+		if err != nil {
+			err = msgp.WrapError(err, "Value")
+			return
+		}
+		// write "ts"
+		err = en.Append(0xa2, 0x74, 0x73)
+		if err != nil {
+			return
+		}
+		err = en.WriteInt64(z.UnixTimestamp)
+		if err != nil {
+			err = msgp.WrapError(err, "UnixTimestamp")
+			return
+		}
+		if (zb0001Mask & 0x10) == 0 { // if not omitted
+			// write "fields"
+			err = en.Append(0xa6, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73)
+			if err != nil {
+				return
+			}
+			err = en.WriteMapHeader(uint32(len(z.Fields)))
+			if err != nil {
+				err = msgp.WrapError(err, "Fields")
+				return
+			}
+			for za0001, za0002 := range z.Fields {
+				err = en.WriteString(za0001)
+				if err != nil {
+					err = msgp.WrapError(err, "Fields")
+					return
+				}
+				err = en.WriteFloat64(za0002)
+				if err != nil {
+					err = msgp.WrapError(err, "Fields", za0001)
+					return
+				}
+			}
+		}
+		if (zb0001Mask & 0x20) == 0 { // if not omitted
+			// write "backfill"
+			err = en.Append(0xa8, 0x62, 0x61, 0x63, 0x6b, 0x66, 0x69, 0x6c, 0x6c)
+			if err != nil {
+				return
+			}
+			err = en.WriteBool(z.Backfill)
+			if err != nil {
+				err = msgp.WrapError(err, "Backfill")
+				return
+			}
+		}
 	}
 	return
 }
@@ -108,19 +211,50 @@ func (z *Event) EncodeMsg(en *msgp.Writer) (err error) {
 // MarshalMsg implements msgp.Marshaler
 func (z *Event) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 4
-	// string "idempotency_id"
-	o = append(o, 0x84, 0xae, 0x69, 0x64, 0x65, 0x6d, 0x70, 0x6f, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x69, 0x64)
-	o = msgp.AppendString(o, z.IdempotencyID)
-	// string "sensor"
-	o = append(o, 0xa6, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72)
-	o = msgp.AppendString(o, z.Sensor)
-	// string "val"
-	o = append(o, 0xa3, 0x76, 0x61, 0x6c)
-	o = msgp.AppendInt(o, z.Value)
-	// string "ts"
-	o = append(o, 0xa2, 0x74, 0x73)
-	o = msgp.AppendInt64(o, z.UnixTimestamp)
+	// check for omitted fields
+	zb0001Len := uint32(6)
+	var zb0001Mask uint8 /* 6 bits */
+	_ = zb0001Mask
+	if z.Fields == nil {
+		zb0001Len--
+		zb0001Mask |= 0x10
+	}
+	if z.Backfill == false {
+		zb0001Len--
+		zb0001Mask |= 0x20
+	}
+	// variable map header, size zb0001Len
+	o = append(o, 0x80|uint8(zb0001Len))
+
+	// skip if no fields are to be emitted
+	if zb0001Len != 0 {
+		// string "idempotency_id"
+		o = append(o, 0xae, 0x69, 0x64, 0x65, 0x6d, 0x70, 0x6f, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x69, 0x64)
+		o = msgp.AppendString(o, z.IdempotencyID)
+		// string "sensor"
+		o = append(o, 0xa6, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72)
+		o = msgp.AppendString(o, z.Sensor)
+		// string "val"
+		o = append(o, 0xa3, 0x76, 0x61, 0x6c)
+		o = msgp.AppendInt(o, z.Value)
+		// string "ts"
+		o = append(o, 0xa2, 0x74, 0x73)
+		o = msgp.AppendInt64(o, z.UnixTimestamp)
+		if (zb0001Mask & 0x10) == 0 { // if not omitted
+			// string "fields"
+			o = append(o, 0xa6, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73)
+			o = msgp.AppendMapHeader(o, uint32(len(z.Fields)))
+			for za0001, za0002 := range z.Fields {
+				o = msgp.AppendString(o, za0001)
+				o = msgp.AppendFloat64(o, za0002)
+			}
+		}
+		if (zb0001Mask & 0x20) == 0 { // if not omitted
+			// string "backfill"
+			o = append(o, 0xa8, 0x62, 0x61, 0x63, 0x6b, 0x66, 0x69, 0x6c, 0x6c)
+			o = msgp.AppendBool(o, z.Backfill)
+		}
+	}
 	return
 }
 
@@ -134,6 +268,10 @@ func (z *Event) UnmarshalMsg(bts []byte) (o []byte, err error) {
 		err = msgp.WrapError(err)
 		return
 	}
+	if zb0001 > zd9e37b5dlimitMaps {
+		err = msgp.ErrLimitExceeded
+		return
+	}
 	for zb0001 > 0 {
 		zb0001--
 		field, bts, err = msgp.ReadMapKeyZC(bts)
@@ -166,6 +304,44 @@ func (z *Event) UnmarshalMsg(bts []byte) (o []byte, err error) {
 				err = msgp.WrapError(err, "UnixTimestamp")
 				return
 			}
+		case "fields":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadMapHeaderBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Fields")
+				return
+			}
+			if zb0002 > zd9e37b5dlimitMaps {
+				err = msgp.ErrLimitExceeded
+				return
+			}
+			if z.Fields == nil {
+				z.Fields = make(map[string]float64, zb0002)
+			} else if len(z.Fields) > 0 {
+				clear(z.Fields)
+			}
+			for zb0002 > 0 {
+				var za0002 float64
+				zb0002--
+				var za0001 string
+				za0001, bts, err = msgp.ReadStringBytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Fields")
+					return
+				}
+				za0002, bts, err = msgp.ReadFloat64Bytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Fields", za0001)
+					return
+				}
+				z.Fields[za0001] = za0002
+			}
+		case "backfill":
+			z.Backfill, bts, err = msgp.ReadBoolBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Backfill")
+				return
+			}
 		default:
 			bts, err = msgp.Skip(bts)
 			if err != nil {
@@ -180,6 +356,13 @@ func (z *Event) UnmarshalMsg(bts []byte) (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *Event) Msgsize() (s int) {
-	s = 1 + 15 + msgp.StringPrefixSize + len(z.IdempotencyID) + 7 + msgp.StringPrefixSize + len(z.Sensor) + 4 + msgp.IntSize + 3 + msgp.Int64Size
+	s = 1 + 15 + msgp.StringPrefixSize + len(z.IdempotencyID) + 7 + msgp.StringPrefixSize + len(z.Sensor) + 4 + msgp.IntSize + 3 + msgp.Int64Size + 7 + msgp.MapHeaderSize
+	if z.Fields != nil {
+		for za0001, za0002 := range z.Fields {
+			_ = za0002
+			s += msgp.StringPrefixSize + len(za0001) + msgp.Float64Size
+		}
+	}
+	s += 9 + msgp.BoolSize
 	return
 }
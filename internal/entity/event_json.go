@@ -0,0 +1,265 @@
+package entity
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+// errFallback sends DecodeJSON to encoding/json. The fast path never
+// reports its own errors, so malformed input gets encoding/json's.
+var errFallback = errors.New("fall back to encoding/json")
+
+// DecodeJSON decodes an event from JSON into ev, replacing what it held.
+// Objects that use exactly the Event keys, with strings free of escapes
+// and integer values, are parsed by hand in well under half the time
+// encoding/json takes; anything else, malformed input included, goes through
+// encoding/json, so the result is always what json.Unmarshal into a zero
+// Event would give.
+func DecodeJSON(data []byte, ev *Event) error {
+	*ev = Event{}
+	d := jsonDecoder{data: data}
+	if d.event(ev) == nil {
+		return nil
+	}
+	*ev = Event{}
+	return json.Unmarshal(data, ev)
+}
+
+type jsonDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *jsonDecoder) event(ev *Event) error {
+	if !d.consume('{') {
+		return errFallback
+	}
+	if d.consume('}') {
+		return d.end()
+	}
+	for {
+		key, err := d.string()
+		if err != nil || !d.consume(':') {
+			return errFallback
+		}
+		switch string(key) {
+		case "idempotency_id":
+			err = d.stringValue(&ev.IdempotencyID)
+		case "sensor":
+			err = d.stringValue(&ev.Sensor)
+		case "val":
+			var n int64
+			n, err = d.intValue(strconv.IntSize, int64(ev.Value))
+			ev.Value = int(n)
+		case "ts":
+			ev.UnixTimestamp, err = d.intValue(64, ev.UnixTimestamp)
+		case "fields":
+			err = d.fields(ev)
+		case "backfill":
+			err = d.boolValue(&ev.Backfill)
+		default:
+			// encoding/json matches keys case-insensitively and skips
+			// unknown ones; leave both to it
+			return errFallback
+		}
+		if err != nil {
+			return err
+		}
+		if d.consume('}') {
+			return d.end()
+		}
+		if !d.consume(',') {
+			return errFallback
+		}
+	}
+}
+
+func (d *jsonDecoder) fields(ev *Event) error {
+	if d.null() {
+		ev.Fields = nil
+		return nil
+	}
+	if !d.consume('{') {
+		return errFallback
+	}
+	if ev.Fields == nil {
+		ev.Fields = make(map[string]float64)
+	}
+	if d.consume('}') {
+		return nil
+	}
+	for {
+		key, err := d.string()
+		if err != nil || !d.consume(':') {
+			return errFallback
+		}
+		lit, ok := d.number()
+		if !ok {
+			return errFallback
+		}
+		f, err := strconv.ParseFloat(string(lit), 64)
+		if err != nil {
+			return errFallback
+		}
+		ev.Fields[string(key)] = f
+		if d.consume('}') {
+			return nil
+		}
+		if !d.consume(',') {
+			return errFallback
+		}
+	}
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips whitespace and then c, if it's next.
+func (d *jsonDecoder) consume(c byte) bool {
+	d.skipSpace()
+	if d.pos < len(d.data) && d.data[d.pos] == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *jsonDecoder) literal(lit string) bool {
+	d.skipSpace()
+	if len(d.data)-d.pos >= len(lit) && string(d.data[d.pos:d.pos+len(lit)]) == lit {
+		d.pos += len(lit)
+		return true
+	}
+	return false
+}
+
+func (d *jsonDecoder) null() bool { return d.literal("null") }
+
+func (d *jsonDecoder) end() error {
+	d.skipSpace()
+	if d.pos != len(d.data) {
+		return errFallback
+	}
+	return nil
+}
+
+// string reads a string without escapes, returning its bytes within the
+// input. Invalid UTF-8, which encoding/json replaces with U+FFFD, falls
+// back too.
+func (d *jsonDecoder) string() ([]byte, error) {
+	if !d.consume('"') {
+		return nil, errFallback
+	}
+	start := d.pos
+	ascii := true
+	for ; d.pos < len(d.data); d.pos++ {
+		c := d.data[d.pos]
+		switch {
+		case c == '"':
+			s := d.data[start:d.pos]
+			d.pos++
+			if !ascii && !utf8.Valid(s) {
+				return nil, errFallback
+			}
+			return s, nil
+		case c == '\\' || c < 0x20:
+			return nil, errFallback
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	return nil, errFallback
+}
+
+func (d *jsonDecoder) stringValue(dst *string) error {
+	if d.null() {
+		return nil
+	}
+	s, err := d.string()
+	if err != nil {
+		return err
+	}
+	*dst = string(s)
+	return nil
+}
+
+func (d *jsonDecoder) boolValue(dst *bool) error {
+	switch {
+	case d.null():
+	case d.literal("true"):
+		*dst = true
+	case d.literal("false"):
+		*dst = false
+	default:
+		return errFallback
+	}
+	return nil
+}
+
+// intValue reads an integer of bitSize bits; null leaves cur as it is.
+func (d *jsonDecoder) intValue(bitSize int, cur int64) (int64, error) {
+	if d.null() {
+		return cur, nil
+	}
+	lit, ok := d.number()
+	if !ok {
+		return 0, errFallback
+	}
+	n, err := strconv.ParseInt(string(lit), 10, bitSize)
+	if err != nil {
+		// a fraction, an exponent or out of range
+		return 0, errFallback
+	}
+	return n, nil
+}
+
+// number reads a JSON number literal, per the grammar in RFC 8259.
+func (d *jsonDecoder) number() ([]byte, bool) {
+	d.skipSpace()
+	start := d.pos
+	if d.pos < len(d.data) && d.data[d.pos] == '-' {
+		d.pos++
+	}
+	switch {
+	case d.pos < len(d.data) && d.data[d.pos] == '0':
+		d.pos++
+	case d.pos < len(d.data) && d.data[d.pos] >= '1' && d.data[d.pos] <= '9':
+		d.digits()
+	default:
+		return nil, false
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == '.' {
+		d.pos++
+		if d.digits() == 0 {
+			return nil, false
+		}
+	}
+	if d.pos < len(d.data) && (d.data[d.pos] == 'e' || d.data[d.pos] == 'E') {
+		d.pos++
+		if d.pos < len(d.data) && (d.data[d.pos] == '+' || d.data[d.pos] == '-') {
+			d.pos++
+		}
+		if d.digits() == 0 {
+			return nil, false
+		}
+	}
+	return d.data[start:d.pos], true
+}
+
+func (d *jsonDecoder) digits() int {
+	start := d.pos
+	for d.pos < len(d.data) && d.data[d.pos] >= '0' && d.data[d.pos] <= '9' {
+		d.pos++
+	}
+	return d.pos - start
+}
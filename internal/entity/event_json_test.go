@@ -0,0 +1,110 @@
+package entity
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var jsonConformance = []string{
+	`{"idempotency_id":"716e5e1f","sensor":"temp-01","val":42,"ts":1717243200000}`,
+	` { "sensor" : "temp-01" , "val" : -7 , "ts" : 0 } `,
+	`{"sensor":"env-03","ts":12,"fields":{"temp":22.5,"hum":48,"p":-1.5e3,"z":0}}`,
+	`{"sensor":"a","fields":{}}`,
+	`{"sensor":"a","fields":null}`,
+	`{"sensor":"a","fields":{"x":1},"fields":{"y":2}}`,
+	`{"sensor":"a","fields":{"x":1},"fields":null}`,
+	`{"sensor":"a","val":null,"ts":null,"idempotency_id":null,"backfill":null}`,
+	`{"sensor":"a","backfill":true}`,
+	`{"sensor":"a","sensor":"b"}`,
+	`{"sensor":"héllo-温度"}`,
+	`{}`,
+	// handled by encoding/json
+	`{"Sensor":"case-folded"}`,
+	`{"sensor":"a","extra":{"nested":[1,2,3]}}`,
+	`{"sensor":"esc\"apedé"}`,
+	"{\"sensor\":\"bad utf8 \xff\"}",
+	`{"sensor":"a","fields":{"x":null}}`,
+	`{"sensor":"a","val":-0}`,
+	// malformed, so both fail
+	``,
+	`null`,
+	`[]`,
+	`{"sensor":"a"`,
+	`{"sensor":"a",}`,
+	`{"sensor":"a"} x`,
+	`{"sensor":"a","val":1.5}`,
+	`{"sensor":"a","val":1e3}`,
+	`{"sensor":"a","val":01}`,
+	`{"sensor":"a","val":"42"}`,
+	`{"sensor":"a","val":99999999999999999999}`,
+	`{"sensor":"a","ts":-}`,
+	`{"sensor":42}`,
+	`{"sensor":"a","fields":{"x":1e999}}`,
+	`{"sensor":"a","fields":{"x":.5}}`,
+	`{"sensor":"a","backfill":"yes"}`,
+	`{"sensor":"a","backfill":tru}`,
+	`{"sensor":"a","val":nul}`,
+	"{\"sensor\":\"tab\there\"}",
+}
+
+// checkConformance fails unless DecodeJSON and json.Unmarshal into a zero
+// Event agree on data.
+func checkConformance(t *testing.T, data []byte) {
+	t.Helper()
+	var want, got Event
+	wantErr := json.Unmarshal(data, &want)
+	err := DecodeJSON(data, &got)
+	if wantErr != nil {
+		require.Error(t, err, "%q", data)
+		assert.Equal(t, wantErr.Error(), err.Error(), "%q", data)
+		return
+	}
+	require.NoError(t, err, "%q", data)
+	assert.Equal(t, want, got, "%q", data)
+}
+
+func TestDecodeJSONConformance(t *testing.T) {
+	for _, data := range jsonConformance {
+		checkConformance(t, []byte(data))
+	}
+}
+
+func TestDecodeJSONReplaces(t *testing.T) {
+	ev := Event{Sensor: "old", Value: 9, Fields: map[string]float64{"x": 1}}
+	require.NoError(t, DecodeJSON([]byte(`{"ts":5}`), &ev))
+	assert.Equal(t, Event{UnixTimestamp: 5}, ev)
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	for _, data := range jsonConformance {
+		f.Add([]byte(data))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkConformance(t, data)
+	})
+}
+
+func BenchmarkDecodeJSON(b *testing.B) {
+	data := []byte(`{"idempotency_id":"716e5e1f-123e-48c2-95b6-02d4cf83b7e0","sensor":"temp-01","val":42,"ts":1717243200000}`)
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		var ev Event
+		for b.Loop() {
+			if err := DecodeJSON(data, &ev); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			var ev Event
+			if err := json.Unmarshal(data, &ev); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
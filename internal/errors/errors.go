@@ -1,8 +1,61 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
-	ErrRateLimited = errors.New("rate limited")
-	ErrDuplicate   = errors.New("duplicate event")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrDuplicate     = errors.New("duplicate event")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrTooOld rejects an event timestamped beyond the retention horizon.
+	ErrTooOld = errors.New("event older than retention horizon")
+	// ErrIdempotencyConflict rejects an event whose IdempotencyID was seen
+	// on an event with different content: a client reusing IDs, not
+	// retrying.
+	ErrIdempotencyConflict = errors.New("idempotency id reused for a different event")
+	// ErrBufferFull rejects an event a full, non-evicting buffer has no
+	// room for; retrying after the next flush can succeed.
+	ErrBufferFull = errors.New("buffer full")
+	// ErrOverloaded rejects an event while the process is close to its
+	// memory limit, so the sink sheds load instead of being OOM killed.
+	ErrOverloaded = errors.New("sink overloaded")
+	// ErrDegraded rejects an event while flushes to the journal keep
+	// failing. It is an ErrOverloaded, so transports answer it the same.
+	ErrDegraded = fmt.Errorf("%w: journal writes failing", ErrOverloaded)
+	// ErrInsufficientStorage rejects an event while the journal's disk is
+	// past its limits, before the filesystem is actually full.
+	ErrInsufficientStorage = errors.New("insufficient storage")
+	// ErrInvalidSensorName rejects an event whose sensor name breaks the
+	// configured naming rules, e.g. characters journal keys can't hold.
+	ErrInvalidSensorName = errors.New("invalid sensor name")
+	// ErrEventTooLarge rejects an event bigger than a rate limit's burst,
+	// which no amount of waiting would let through.
+	ErrEventTooLarge = errors.New("event larger than the rate limit burst")
 )
+
+// LimitError wraps ErrRateLimited or ErrQuotaExceeded with the limiter
+// state, so transports can tell clients when to come back.
+type LimitError struct {
+	Err        error
+	RetryAfter time.Duration
+	Remaining  int64
+	Reset      time.Duration
+}
+
+func (e *LimitError) Error() string { return e.Err.Error() }
+
+func (e *LimitError) Unwrap() error { return e.Err }
+
+// DuplicateError wraps ErrDuplicate with what became of the first copy:
+// the journal sequence number it was written with, or 0 while it is still
+// buffered.
+type DuplicateError struct {
+	Seq uint64
+}
+
+func (e *DuplicateError) Error() string { return ErrDuplicate.Error() }
+
+func (e *DuplicateError) Unwrap() error { return ErrDuplicate }
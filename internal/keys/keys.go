@@ -0,0 +1,104 @@
+// Package keys fetches journal encryption keys from where they are kept,
+// so the raw key doesn't have to sit in the config file.
+package keys
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown key provider")
+	ErrNoKey           = errors.New("key provider returned no key")
+)
+
+// Provider fetches an encryption key.
+type Provider interface {
+	Key(ctx context.Context) ([]byte, error)
+}
+
+type ProviderFunc func(ctx context.Context) ([]byte, error)
+
+func (f ProviderFunc) Key(ctx context.Context) ([]byte, error) { return f(ctx) }
+
+// Source configures a Provider. Type picks one of:
+//   - "inline": Key holds the base64 key itself
+//   - "file": the key is read from Path, raw or base64
+//   - "env": the key is read from the Env variable, base64
+//   - "vault": a field of a HashiCorp Vault secret, see Vault
+//   - "aws_kms": an AWS KMS encrypted data key, see KMS
+type Source struct {
+	Type  string
+	Key   string
+	Path  string
+	Env   string
+	Vault Vault
+	KMS   KMS
+}
+
+func New(src Source) (Provider, error) {
+	switch src.Type {
+	case "inline":
+		return Static(src.Key), nil
+	case "file":
+		return File(src.Path), nil
+	case "env":
+		return Env(src.Env), nil
+	case "vault":
+		return src.Vault, nil
+	case "aws_kms":
+		return src.KMS, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, src.Type)
+}
+
+// Static returns a Provider for a base64 key given inline.
+func Static(b64 string) Provider {
+	return ProviderFunc(func(context.Context) ([]byte, error) {
+		return decode([]byte(b64), false)
+	})
+}
+
+// File returns a Provider reading the key from path, as 32 raw bytes or
+// base64 text. Suits keys mounted as Kubernetes or Docker secrets.
+func File(path string) Provider {
+	return ProviderFunc(func(context.Context) ([]byte, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return decode(data, true)
+	})
+}
+
+// Env returns a Provider reading a base64 key from the variable name.
+func Env(name string) Provider {
+	return ProviderFunc(func(context.Context) ([]byte, error) {
+		return decode([]byte(os.Getenv(name)), false)
+	})
+}
+
+// decode reads base64 key material, ignoring surrounding whitespace. With
+// raw set, exactly 32 bytes or data that isn't base64 is taken as the key
+// bytes themselves.
+func decode(data []byte, raw bool) ([]byte, error) {
+	if raw && len(data) == 32 {
+		return data, nil
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, ErrNoKey
+	}
+	key, err := base64.StdEncoding.DecodeString(string(trimmed))
+	if err != nil {
+		if raw {
+			return data, nil
+		}
+		return nil, fmt.Errorf("invalid base64 key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,134 @@
+package keys
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestProviders(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString(testKey)
+	dir := t.TempDir()
+	f := func(src Source) {
+		t.Helper()
+		p, err := New(src)
+		require.NoError(t, err)
+		key, err := p.Key(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, testKey, key)
+	}
+
+	f(Source{Type: "inline", Key: b64})
+
+	b64File := filepath.Join(dir, "b64")
+	require.NoError(t, os.WriteFile(b64File, []byte(b64+"\n"), 0600))
+	f(Source{Type: "file", Path: b64File})
+
+	rawFile := filepath.Join(dir, "raw")
+	require.NoError(t, os.WriteFile(rawFile, testKey, 0600))
+	f(Source{Type: "file", Path: rawFile})
+
+	t.Setenv("TEST_JOURNAL_KEY", b64)
+	f(Source{Type: "env", Env: "TEST_JOURNAL_KEY"})
+
+	_, err := New(Source{Type: "carrier-pigeon"})
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	_, err = Env("TEST_UNSET_JOURNAL_KEY").Key(context.Background())
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	_, err = Static("not base64!").Key(context.Background())
+	assert.Error(t, err)
+}
+
+func TestVault(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString(testKey)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/journal": // KV v2
+			_, _ = w.Write([]byte(`{"data":{"data":{"key":"` + b64 + `"},"metadata":{"version":3}}}`))
+		case "/v1/kv/journal": // KV v1
+			_, _ = w.Write([]byte(`{"data":{"aes":"` + b64 + `"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	key, err := Vault{Addr: srv.URL, Token: "s.token", Path: "secret/data/journal"}.Key(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testKey, key)
+
+	key, err = Vault{Addr: srv.URL, Token: "s.token", Path: "kv/journal", Field: "aes"}.Key(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testKey, key)
+
+	_, err = Vault{Addr: srv.URL, Token: "s.token", Path: "kv/journal"}.Key(context.Background())
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	_, err = Vault{Addr: srv.URL, Token: "wrong", Path: "kv/journal"}.Key(context.Background())
+	assert.ErrorContains(t, err, "403")
+}
+
+func TestKMS(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "TrentService.Decrypt", r.Header.Get("X-Amz-Target"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240601/eu-west-1/kms/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-target, Signature="))
+
+		var in struct{ CiphertextBlob string }
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		assert.Equal(t, "c2VhbGVk", in.CiphertextBlob)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"KeyId":     "arn:aws:kms:eu-west-1:111122223333:key/test",
+			"Plaintext": base64.StdEncoding.EncodeToString(testKey),
+		})
+	}))
+	defer srv.Close()
+
+	k := KMS{
+		Region:     "eu-west-1",
+		Endpoint:   srv.URL,
+		Ciphertext: "c2VhbGVk",
+		now:        func() time.Time { return time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC) },
+	}
+	key, err := k.Key(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testKey, key)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err = k.Key(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+// The get-vanilla case from the AWS Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service",
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
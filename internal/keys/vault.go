@@ -0,0 +1,87 @@
+package keys
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Vault reads the key from a field of a HashiCorp Vault secret, base64
+// encoded. Both KV engine versions work: for KV v2, Path includes the
+// "data/" segment, e.g. "secret/data/iotdemo/journal".
+type Vault struct {
+	// Addr defaults to $VAULT_ADDR.
+	Addr string
+	// Token defaults to $VAULT_TOKEN.
+	Token string
+	Path  string
+	// Field defaults to "key".
+	Field string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (v Vault) Key(ctx context.Context) ([]byte, error) {
+	addr := v.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	token := v.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	field := v.Field
+	if field == "" {
+		field = "key"
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	url := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(v.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// KV v2 nests the secret one level deeper than v1
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	data := secret.Data
+	if nested, ok := data["data"]; ok {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return nil, fmt.Errorf("vault: %w", err)
+		}
+	}
+
+	var value string
+	if raw, ok := data[field]; !ok {
+		return nil, fmt.Errorf("vault: %w: no field %q at %s", ErrNoKey, field, v.Path)
+	} else if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("vault: field %q: %w", field, err)
+	}
+	return decode([]byte(value), false)
+}
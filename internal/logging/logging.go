@@ -0,0 +1,206 @@
+// Package logging builds the process-wide slog logger from configuration.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrUnknownFormat = errors.New("unknown log format")
+
+type options struct {
+	level      slog.Level
+	format     string
+	output     string
+	addSource  bool
+	maxSize    int64
+	maxBackups int
+
+	sampleFirst    int
+	sampleInterval time.Duration
+
+	shipProtocol string
+	shipNetwork  string
+	shipAddr     string
+	shipBuffer   int
+}
+
+type Option func(*options) error
+
+// WithLevel sets the minimum level: debug, info, warn or error.
+func WithLevel(level string) Option {
+	return func(o *options) error {
+		return o.level.UnmarshalText([]byte(level))
+	}
+}
+
+// WithFormat selects the text or json handler.
+func WithFormat(format string) Option {
+	return func(o *options) error {
+		switch strings.ToLower(format) {
+		case "text", "json":
+			o.format = strings.ToLower(format)
+			return nil
+		}
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// WithOutput sets where logs go: stdout, stderr or a file path.
+func WithOutput(output string) Option {
+	return func(o *options) error {
+		o.output = output
+		return nil
+	}
+}
+
+func WithSource(enabled bool) Option {
+	return func(o *options) error {
+		o.addSource = enabled
+		return nil
+	}
+}
+
+// WithRotation rotates a file output once it reaches maxSize bytes,
+// keeping maxBackups old files. Zero maxSize disables rotation.
+func WithRotation(maxSize int64, maxBackups int) Option {
+	return func(o *options) error {
+		o.maxSize = maxSize
+		o.maxBackups = maxBackups
+		return nil
+	}
+}
+
+// WithSampling lets through the first n identical warnings or errors per
+// interval and drops the rest, so a failure repeated on every request
+// doesn't drown out everything else. Zero n disables sampling.
+func WithSampling(n int, interval time.Duration) Option {
+	return func(o *options) error {
+		o.sampleFirst = n
+		o.sampleInterval = interval
+		return nil
+	}
+}
+
+// WithShipping also sends logs to addr over network, udp or tcp, as
+// protocol, syslog (RFC 5424) or gelf (GELF 1.1), for devices without
+// the disk to keep them. Up to buffer messages are queued for sending;
+// beyond that, and while addr can't be reached, they are dropped and
+// counted. Empty protocol disables shipping.
+func WithShipping(protocol, network, addr string, buffer int) Option {
+	return func(o *options) error {
+		o.shipProtocol = protocol
+		o.shipNetwork = network
+		o.shipAddr = addr
+		o.shipBuffer = buffer
+		return nil
+	}
+}
+
+// New builds a logger. The returned closer releases the log file, if any,
+// and sends what's still queued for shipping.
+func New(opts ...Option) (*slog.Logger, io.Closer, error) {
+	o := &options{
+		level:  slog.LevelInfo,
+		format: "text",
+		output: "stdout",
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var (
+		w      io.Writer
+		closer io.Closer = nopCloser{}
+	)
+	switch o.output {
+	case "", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := openRotatingFile(o.output, o.maxSize, o.maxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = f, f
+	}
+
+	hopts := &slog.HandlerOptions{
+		Level:     o.level,
+		AddSource: o.addSource,
+	}
+
+	var h slog.Handler
+	if o.format == "json" {
+		h = slog.NewJSONHandler(w, hopts)
+	} else {
+		h = slog.NewTextHandler(w, hopts)
+	}
+
+	if o.shipProtocol != "" {
+		sh, err := newShipper(o.shipProtocol, o.shipNetwork, o.shipAddr, o.shipBuffer, h)
+		if err != nil {
+			_ = closer.Close()
+			return nil, nil, err
+		}
+		h = teeHandler{h, &shipHandler{s: sh, level: o.level}}
+		closer = multiCloser{sh, closer}
+	}
+
+	if o.sampleFirst > 0 && o.sampleInterval > 0 {
+		h = newSamplingHandler(h, o.sampleFirst, o.sampleInterval)
+	}
+
+	return slog.New(h), closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// multiCloser closes each in order, returning the first error.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// teeHandler passes every record to both of its handlers.
+type teeHandler [2]slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return t[0].Enabled(ctx, level) || t[1].Enabled(ctx, level)
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			err = errors.Join(err, h.Handle(ctx, r))
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return teeHandler{t[0].WithAttrs(attrs), t[1].WithAttrs(attrs)}
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	return teeHandler{t[0].WithGroup(name), t[1].WithGroup(name)}
+}
@@ -0,0 +1,9 @@
+package logging
+
+import "github.com/VictoriaMetrics/metrics"
+
+var (
+	shipped         = metrics.NewCounter("log_shipped_total")
+	shipDroppedFull = metrics.NewCounter(`log_ship_dropped_total{reason="full"}`)
+	shipDroppedSend = metrics.NewCounter(`log_ship_dropped_total{reason="send"}`)
+)
@@ -0,0 +1,184 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("json to file at level", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "logs", "sink.log")
+		logger, closer, err := New(WithLevel("warn"), WithFormat("json"), WithOutput(path))
+		require.NoError(t, err)
+
+		logger.Info("hidden")
+		logger.Warn("shown", "sensor", "temp")
+		require.NoError(t, closer.Close())
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		require.Len(t, lines, 1)
+
+		var rec map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+		assert.Equal(t, "shown", rec["msg"])
+		assert.Equal(t, "temp", rec["sensor"])
+	})
+
+	t.Run("rejects bad config", func(t *testing.T) {
+		_, _, err := New(WithLevel("loud"))
+		assert.Error(t, err)
+
+		_, _, err = New(WithFormat("xml"))
+		assert.ErrorIs(t, err, ErrUnknownFormat)
+	})
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sink.log")
+	f, err := openRotatingFile(path, 100, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for range 7 {
+		_, err := f.Write(line)
+		require.NoError(t, err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, line, data, name)
+	}
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	h := newSamplingHandler(slog.NewJSONHandler(&buf, nil), 2, time.Minute)
+	now := time.Now()
+	h.state.now = func() time.Time { return now }
+	logger := slog.New(h)
+
+	for range 5 {
+		logger.Error("journal write failed")
+		logger.Info("chatty but fine")
+	}
+	logger.Error("something else")
+
+	now = now.Add(time.Minute)
+	logger.Error("journal write failed")
+
+	var got []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		got = append(got, rec)
+	}
+
+	count := func(msg string) int {
+		n := 0
+		for _, rec := range got {
+			if rec["msg"] == msg {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, 3, count("journal write failed"))
+	assert.Equal(t, 5, count("chatty but fine"), "info is never sampled")
+	assert.Equal(t, 1, count("something else"))
+
+	last := got[len(got)-1]
+	assert.Equal(t, float64(3), last["suppressed"])
+	assert.True(t, h.Enabled(context.Background(), slog.LevelInfo))
+}
+
+func TestShipping(t *testing.T) {
+	t.Run("gelf over udp", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer pc.Close()
+
+		logger, closer, err := New(WithOutput("stderr"), WithShipping("gelf", "udp", pc.LocalAddr().String(), 16))
+		require.NoError(t, err)
+		logger.With("route", "/ingest").WithGroup("req").Warn("slow request", "took", 2*time.Second, "bytes", 512, "id", "r-1")
+		require.NoError(t, closer.Close())
+
+		buf := make([]byte, 64<<10)
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+
+		var msg map[string]any
+		require.NoError(t, json.Unmarshal(buf[:n], &msg))
+		assert.Equal(t, "1.1", msg["version"])
+		assert.Equal(t, "slow request", msg["short_message"])
+		assert.Equal(t, float64(4), msg["level"])
+		assert.Equal(t, "/ingest", msg["_route"])
+		assert.Equal(t, "2s", msg["_req.took"])
+		assert.Equal(t, float64(512), msg["_req.bytes"])
+		assert.Equal(t, "r-1", msg["_req.id"])
+	})
+
+	t.Run("syslog over tcp", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		logger, closer, err := New(WithOutput("stderr"), WithLevel("info"), WithShipping("syslog", "tcp", ln.Addr().String(), 16))
+		require.NoError(t, err)
+		logger.Debug("not shipped")
+		logger.Error("journal write failed", "error", "no space left on device")
+		require.NoError(t, closer.Close())
+
+		conn, err := ln.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		r := bufio.NewReader(conn)
+		size, err := r.ReadString(' ')
+		require.NoError(t, err)
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		require.NoError(t, err)
+		frame := make([]byte, n)
+		_, err = io.ReadFull(r, frame)
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(string(frame), "<11>1 "), string(frame))
+		assert.True(t, strings.HasSuffix(string(frame), ` - - journal write failed error="no space left on device"`), string(frame))
+		_, err = r.ReadByte()
+		assert.ErrorIs(t, err, io.EOF, "only one message")
+	})
+
+	t.Run("drops what doesn't fit the queue", func(t *testing.T) {
+		s := &shipper{queue: make(chan []byte, 1)} // no sender draining it
+		before := shipDroppedFull.Get()
+		s.enqueue([]byte("a"))
+		s.enqueue([]byte("b"))
+		assert.Equal(t, before+1, shipDroppedFull.Get())
+	})
+
+	t.Run("rejects bad config", func(t *testing.T) {
+		_, _, err := New(WithShipping("journald", "udp", "127.0.0.1:514", 16))
+		assert.ErrorIs(t, err, ErrUnknownShipProtocol)
+		_, _, err = New(WithShipping("syslog", "unix", "/dev/log", 16))
+		assert.ErrorIs(t, err, ErrUnknownShipProtocol)
+	})
+}
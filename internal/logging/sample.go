@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// samplingHandler drops repeats of the same warning or error message
+// beyond the first n per interval. The first record of the next interval
+// carries a "suppressed" count for what was dropped.
+type samplingHandler struct {
+	next     slog.Handler
+	first    int
+	interval time.Duration
+	state    *sampleState
+}
+
+type sampleState struct {
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+	// dropped in the previous interval, reported once
+	suppressed map[string]int
+	now        func() time.Time
+}
+
+func newSamplingHandler(next slog.Handler, first int, interval time.Duration) *samplingHandler {
+	return &samplingHandler{
+		next:     next,
+		first:    first,
+		interval: interval,
+		state: &sampleState{
+			counts:     make(map[string]int),
+			suppressed: make(map[string]int),
+			now:        time.Now,
+		},
+	}
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.next.Handle(ctx, r)
+	}
+
+	key := r.Level.String() + "\x00" + r.Message
+	pass, suppressed := h.state.take(key, h.first, h.interval)
+	if !pass {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (s *sampleState) take(key string, first int, interval time.Duration) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.start) >= interval {
+		s.suppressed = make(map[string]int)
+		for k, n := range s.counts {
+			if n > first {
+				s.suppressed[k] = n - first
+			}
+		}
+		s.counts = make(map[string]int)
+		s.start = now
+	}
+
+	s.counts[key]++
+	if s.counts[key] > first {
+		return false, 0
+	}
+	suppressed := s.suppressed[key]
+	delete(s.suppressed, key)
+	return true, suppressed
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), first: h.first, interval: h.interval, state: h.state}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), first: h.first, interval: h.interval, state: h.state}
+}
@@ -0,0 +1,324 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownShipProtocol = errors.New("unknown log shipping protocol")
+
+const (
+	shipDialTimeout  = 5 * time.Second
+	shipWriteTimeout = 5 * time.Second
+	// shipRedial is how long messages are dropped after a failed dial
+	// before dialing again.
+	shipRedial = 5 * time.Second
+	// shipDrain bounds how long Close waits for queued messages to go out.
+	shipDrain = 2 * time.Second
+)
+
+// shipper sends formatted log messages to a remote syslog or GELF
+// endpoint from a queue, so a slow or unreachable endpoint never blocks
+// logging. Messages that don't fit the queue, or can't be sent, are
+// dropped and counted.
+type shipper struct {
+	protocol string
+	network  string
+	addr     string
+	host     string
+	app      string
+	queue    chan []byte
+	done     chan struct{}
+	// local reports shipping failures, since logging them to the default
+	// logger would ship them too
+	local *slog.Logger
+
+	conn     net.Conn
+	failed   bool
+	redialAt time.Time
+	closeMu  sync.RWMutex
+	closed   bool
+}
+
+func newShipper(protocol, network, addr string, buffer int, local slog.Handler) (*shipper, error) {
+	switch protocol {
+	case "syslog", "gelf":
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownShipProtocol, protocol)
+	}
+	switch network {
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("%w: network %q", ErrUnknownShipProtocol, network)
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	s := &shipper{
+		protocol: protocol,
+		network:  network,
+		addr:     addr,
+		host:     host,
+		app:      filepath.Base(os.Args[0]),
+		queue:    make(chan []byte, max(buffer, 1)),
+		done:     make(chan struct{}),
+		local:    slog.New(local),
+	}
+	go s.run()
+	return s, nil
+}
+
+// enqueue hands msg to the sender, dropping it if the queue is full.
+func (s *shipper) enqueue(msg []byte) {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- msg:
+	default:
+		shipDroppedFull.Inc()
+	}
+}
+
+func (s *shipper) run() {
+	defer close(s.done)
+	for msg := range s.queue {
+		if err := s.send(msg); err != nil {
+			shipDroppedSend.Inc()
+			if !s.failed {
+				s.failed = true
+				s.local.Warn("log shipping failed, dropping logs until it recovers", "addr", s.addr, "error", err)
+			}
+			continue
+		}
+		shipped.Inc()
+		if s.failed {
+			s.failed = false
+			s.local.Info("log shipping recovered", "addr", s.addr)
+		}
+	}
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+}
+
+func (s *shipper) send(msg []byte) error {
+	if s.conn == nil {
+		if time.Now().Before(s.redialAt) {
+			return errors.New("endpoint unreachable")
+		}
+		conn, err := net.DialTimeout(s.network, s.addr, shipDialTimeout)
+		if err != nil {
+			s.redialAt = time.Now().Add(shipRedial)
+			return err
+		}
+		s.conn = conn
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(shipWriteTimeout))
+	if _, err := s.conn.Write(s.frame(msg)); err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// frame delimits msg on a stream: syslog with its length (RFC 6587
+// octet counting), GELF with a trailing NUL. Datagrams carry one
+// message each as is.
+func (s *shipper) frame(msg []byte) []byte {
+	if s.network != "tcp" {
+		return msg
+	}
+	if s.protocol == "gelf" {
+		return append(msg, 0)
+	}
+	return append(strconv.AppendInt(nil, int64(len(msg)), 10), append([]byte{' '}, msg...)...)
+}
+
+// Close sends what's queued, waiting up to shipDrain, and closes the
+// connection.
+func (s *shipper) Close() error {
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.closeMu.Unlock()
+	select {
+	case <-s.done:
+	case <-time.After(shipDrain):
+	}
+	return nil
+}
+
+// shipHandler formats records for a shipper. Attributes are flattened,
+// with groups joined into their keys by dots.
+type shipHandler struct {
+	s      *shipper
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *shipHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *shipHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, len(h.attrs), len(h.attrs)+r.NumAttrs())
+	copy(attrs, h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = flatten(attrs, h.prefix, a)
+		return true
+	})
+	var msg []byte
+	if h.s.protocol == "gelf" {
+		msg = h.s.gelf(r, attrs)
+	} else {
+		msg = h.s.syslog(r, attrs)
+	}
+	h.s.enqueue(msg)
+	return nil
+}
+
+func (h *shipHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = make([]slog.Attr, len(h.attrs), len(h.attrs)+len(attrs))
+	copy(c.attrs, h.attrs)
+	for _, a := range attrs {
+		c.attrs = flatten(c.attrs, h.prefix, a)
+	}
+	return &c
+}
+
+func (h *shipHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// flatten appends a to attrs with prefix on its key, and the attributes
+// of a group one level further down.
+func flatten(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range v.Group() {
+			attrs = flatten(attrs, prefix, g)
+		}
+		return attrs
+	}
+	if a.Key == "" {
+		return attrs
+	}
+	return append(attrs, slog.Attr{Key: prefix + a.Key, Value: v})
+}
+
+// severity maps a level to its syslog severity, which GELF uses too.
+func severity(l slog.Level) int {
+	switch {
+	case l >= slog.LevelError:
+		return 3
+	case l >= slog.LevelWarn:
+		return 4
+	case l >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// syslogFacility is the "user" facility.
+const syslogFacility = 1
+
+// syslog formats an RFC 5424 message, with the attributes as key=value
+// pairs after the message text.
+func (s *shipper) syslog(r slog.Record, attrs []slog.Attr) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - %s",
+		syslogFacility*8+severity(r.Level),
+		r.Time.UTC().Format(time.RFC3339Nano),
+		s.host, s.app, os.Getpid(),
+		r.Message,
+	)
+	for _, a := range attrs {
+		b.WriteByte(' ')
+		b.WriteString(a.Key)
+		b.WriteByte('=')
+		val := a.Value.String()
+		if val == "" || strings.ContainsAny(val, " \"=\n") {
+			val = strconv.Quote(val)
+		}
+		b.WriteString(val)
+	}
+	return []byte(b.String())
+}
+
+// gelf formats a GELF 1.1 message, with the attributes as additional
+// fields. Numbers stay numbers; everything else is sent as a string.
+func (s *shipper) gelf(r slog.Record, attrs []slog.Attr) []byte {
+	m := map[string]any{
+		"version":       "1.1",
+		"host":          s.host,
+		"short_message": r.Message,
+		"timestamp":     float64(r.Time.UnixMicro()) / 1e6,
+		"level":         severity(r.Level),
+		"_app":          s.app,
+	}
+	for _, a := range attrs {
+		key := "_" + gelfKey(a.Key)
+		if key == "_id" {
+			key = "_id_" // reserved by GELF
+		}
+		switch a.Value.Kind() {
+		case slog.KindInt64:
+			m[key] = a.Value.Int64()
+		case slog.KindUint64:
+			m[key] = a.Value.Uint64()
+		case slog.KindFloat64:
+			m[key] = a.Value.Float64()
+		default:
+			m[key] = a.Value.String()
+		}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		// a NaN or infinite float; send the message without fields
+		data, _ = json.Marshal(map[string]any{
+			"version":       "1.1",
+			"host":          s.host,
+			"short_message": r.Message,
+			"level":         severity(r.Level),
+		})
+	}
+	return data
+}
+
+// gelfKey replaces the characters GELF field names can't have.
+func gelfKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+}
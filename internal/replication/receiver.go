@@ -0,0 +1,147 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// Target is the journal a Receiver writes to; *journal.Journal implements
+// it.
+type Target interface {
+	WriteBatch(entries []journal.Entry) ([]uint64, error)
+	Sync() error
+}
+
+// Receiver takes batches from primaries into a peer's journal.
+type Receiver struct {
+	mu        sync.Mutex
+	target    Target
+	statePath string
+	applied   map[string]uint64 // per source, the highest seq written
+	written   uint64            // the target's seq of the last entry written
+	onApply   func(entries []journal.Entry)
+}
+
+type receiverState struct {
+	Applied map[string]uint64 `json:"applied"`
+	Written uint64            `json:"written"`
+}
+
+type ReceiverOption func(*Receiver)
+
+// WithOnApply calls fn with the entries of each batch once they're durably
+// written, e.g. to keep a follower's stats current.
+func WithOnApply(fn func(entries []journal.Entry)) ReceiverOption {
+	return func(r *Receiver) { r.onApply = fn }
+}
+
+// NewReceiver writes replicated entries to target, keeping the offset of
+// each source in statePath; empty keeps them in memory only, so a
+// restarted receiver takes resent entries a second time.
+func NewReceiver(target Target, statePath string, opts ...ReceiverOption) *Receiver {
+	r := &Receiver{target: target, statePath: statePath, applied: make(map[string]uint64)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Load restores the offsets from the state file, if any.
+func (r *Receiver) Load() error {
+	if r.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st := receiverState{Applied: make(map[string]uint64)}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.applied, r.written = st.Applied, st.Written
+	return nil
+}
+
+// Written is the sequence number, in the target journal, of the newest
+// entry a Receiver has written there. A follower taking over as leader
+// needn't send anything up to it back.
+func (r *Receiver) Written() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.written
+}
+
+// Apply writes the entries of b its source hasn't sent before and returns
+// the highest sequence number of the source now durably written.
+func (r *Receiver) Apply(b *Batch) (uint64, error) {
+	if b.Source == "" {
+		return 0, ErrNoSource
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := r.applied[b.Source]
+	entries := make([]journal.Entry, 0, len(b.Entries))
+	for _, e := range b.Entries {
+		if e.Seq <= last {
+			continue // resent after a lost ack
+		}
+		je := journal.Entry{Key: e.Key, Value: e.Value}
+		if e.Expires != 0 {
+			je.Expires = time.Unix(0, e.Expires)
+		}
+		entries = append(entries, je)
+		last = e.Seq
+	}
+	receivedEntries(b.Source).Add(len(b.Entries))
+	if len(entries) == 0 {
+		return last, nil
+	}
+
+	seqs, err := r.target.WriteBatch(entries)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.target.Sync(); err != nil {
+		return 0, err
+	}
+	r.applied[b.Source] = last
+	if len(seqs) > 0 {
+		r.written = max(r.written, seqs[len(seqs)-1])
+	}
+	appliedSeq(b.Source).Set(float64(last))
+	if r.onApply != nil {
+		r.onApply(entries)
+	}
+	return last, r.save()
+}
+
+func (r *Receiver) save() error {
+	if r.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(receiverState{Applied: r.applied, Written: r.written})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.statePath), 0755); err != nil {
+		return err
+	}
+	tmp := r.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.statePath)
+}
@@ -0,0 +1,52 @@
+// Package replication streams journal entries from a primary sink to peer
+// sinks, so a standby gateway holds near-complete data when the primary's
+// hardware fails. It is write-behind: the primary acknowledges devices once
+// its own journal has an event, and peers catch up within an interval.
+//
+// A Sender on the primary reads entries past the sequence number the peer
+// last acknowledged and POSTs them to the peer, whose Receiver writes them
+// to its own journal, fsyncs, and acknowledges the highest one. Both ends
+// persist their offsets, so either can restart without resending
+// everything; a crash between a peer's fsync and saving its offset means
+// that batch is written twice.
+package replication
+
+//go:generate msgp
+
+import "errors"
+
+var ErrNoSource = errors.New("replication: batch has no source")
+
+// MaxBatch bounds the entries in one batch. The msgp:limit directive below
+// has to match it, so a forged array header can't make the receiver
+// allocate for more.
+const MaxBatch = 10000
+
+//msgp:limit arrays:10000
+
+// ContentType is the media type of an encoded Batch.
+const ContentType = "application/msgpack"
+
+// Batch is what a Sender POSTs to a peer.
+type Batch struct {
+	// Source names the sending sink; a Receiver keeps an offset per source.
+	Source  string  `msg:"source"`
+	Entries []Entry `msg:"entries"`
+}
+
+// Entry is a journal entry as the primary wrote it. Seq is the primary's
+// sequence number; the peer's journal numbers it anew.
+type Entry struct {
+	Seq   uint64 `msg:"seq"`
+	Key   []byte `msg:"key"`
+	Value []byte `msg:"value"`
+	// Expires is a Unix time in nanoseconds, 0 for entries that don't.
+	Expires int64 `msg:"expires,omitempty"`
+}
+
+// Ack is a Receiver's JSON answer to a batch: the highest sequence number
+// of the source it has durably written, which may be past the batch if an
+// earlier, unacknowledged copy already got through.
+type Ack struct {
+	Acked uint64 `json:"acked"`
+}
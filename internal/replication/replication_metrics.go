@@ -0,0 +1,30 @@
+package replication
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func ackedSeq(peer string) *metrics.Gauge {
+	return metrics.GetOrCreateGauge(fmt.Sprintf(`replication_acked_seq{peer=%q}`, peer), nil)
+}
+
+func sentEntries(peer string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`replication_sent_entries_total{peer=%q}`, peer))
+}
+
+func replicationErrors(peer string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`replication_errors_total{peer=%q}`, peer))
+}
+
+func receivedEntries(source string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`replication_received_entries_total{source=%q}`, source))
+}
+
+func appliedSeq(source string) *metrics.Gauge {
+	return metrics.GetOrCreateGauge(fmt.Sprintf(`replication_applied_seq{source=%q}`, source), nil)
+}
+
+// following is 1 while the sink is a read-only follower.
+var following = metrics.NewGauge("replication_following", nil)
@@ -0,0 +1,189 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// peer stands in for the transport endpoint in front of a Receiver.
+func peer(t *testing.T, recv *Receiver, fail *bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *fail {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var b Batch
+		if _, err := b.UnmarshalMsg(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		acked, err := recv.Apply(&b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Ack{Acked: acked})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newJournal(t *testing.T) *journal.Journal {
+	j, err := journal.New(journal.NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+	return j
+}
+
+func write(t *testing.T, j *journal.Journal, from, to int) {
+	for i := from; i < to; i++ {
+		_, err := j.Write(fmt.Appendf(nil, "k%03d", i), []byte("v"))
+		require.NoError(t, err)
+	}
+}
+
+func keys(t *testing.T, j *journal.Journal) []string {
+	var got []string
+	require.NoError(t, j.Replay(func(e *journal.Entry) error {
+		got = append(got, string(e.Key))
+		return nil
+	}))
+	return got
+}
+
+func TestReplicate(t *testing.T) {
+	dir := t.TempDir()
+	primary, standby := newJournal(t), newJournal(t)
+	applied := 0
+	recv := NewReceiver(standby, filepath.Join(dir, "received.json"),
+		WithOnApply(func(entries []journal.Entry) { applied += len(entries) }))
+	fail := false
+	srv := peer(t, recv, &fail)
+
+	write(t, primary, 0, 25)
+	s := NewSender(primary, srv.URL, WithToken("secret"), WithSourceName("gw-01"),
+		WithBatchSize(10), WithStateFile(filepath.Join(dir, "peer.json")))
+	require.NoError(t, s.Replicate(context.Background()))
+	assert.Equal(t, uint64(25), s.Acked())
+	assert.Len(t, keys(t, standby), 25)
+
+	fail = true
+	write(t, primary, 25, 30)
+	require.Error(t, s.Replicate(context.Background()))
+	assert.Equal(t, uint64(25), s.Acked(), "a failed batch isn't acknowledged")
+
+	fail = false
+	restarted := NewSender(primary, srv.URL, WithToken("secret"), WithSourceName("gw-01"),
+		WithStateFile(filepath.Join(dir, "peer.json")))
+	require.NoError(t, restarted.Load())
+	assert.Equal(t, uint64(25), restarted.Acked())
+	require.NoError(t, restarted.Replicate(context.Background()))
+	assert.Equal(t, uint64(30), restarted.Acked())
+
+	got := keys(t, standby)
+	require.Len(t, got, 30)
+	assert.Equal(t, "k029", got[29])
+	assert.Equal(t, 30, applied)
+}
+
+func TestSenderGateAndSkip(t *testing.T) {
+	primary, standby := newJournal(t), newJournal(t)
+	fail := false
+	srv := peer(t, NewReceiver(standby, ""), &fail)
+	write(t, primary, 0, 10)
+
+	open := false
+	s := NewSender(primary, srv.URL, WithToken("secret"), WithGate(func() bool { return open }))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_ = s.Run(ctx, time.Millisecond)
+	assert.Empty(t, keys(t, standby), "nothing is sent while the gate is closed")
+
+	require.NoError(t, s.SkipTo(6))
+	require.NoError(t, s.SkipTo(2), "skipping backwards does nothing")
+	open = true
+	require.NoError(t, s.Replicate(context.Background()))
+	assert.Equal(t, []string{"k006", "k007", "k008", "k009"}, keys(t, standby))
+}
+
+func TestRole(t *testing.T) {
+	var r Role
+	_, following := r.Leader()
+	assert.False(t, following, "the zero value leads")
+
+	r.Follow("http://gw-01:8080")
+	leader, following := r.Leader()
+	assert.True(t, following)
+	assert.Equal(t, "http://gw-01:8080", leader)
+
+	r.Lead()
+	_, following = r.Leader()
+	assert.False(t, following)
+}
+
+func TestReplicateUnauthorized(t *testing.T) {
+	fail := false
+	srv := peer(t, NewReceiver(newJournal(t), ""), &fail)
+	primary := newJournal(t)
+	write(t, primary, 0, 1)
+
+	s := NewSender(primary, srv.URL, WithToken("wrong"))
+	err := s.Replicate(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+	assert.Zero(t, s.Acked())
+}
+
+func TestReceiverSkipsResent(t *testing.T) {
+	dir := t.TempDir()
+	standby := newJournal(t)
+	recv := NewReceiver(standby, filepath.Join(dir, "received.json"))
+
+	batch := &Batch{Source: "gw-01", Entries: []Entry{
+		{Seq: 1, Key: []byte("a"), Value: []byte("1")},
+		{Seq: 2, Key: []byte("b"), Value: []byte("2"), Expires: time.Now().Add(time.Hour).UnixNano()},
+	}}
+	acked, err := recv.Apply(batch)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), acked)
+
+	// the ack got lost, so the primary sends the same entries again
+	batch.Entries = append(batch.Entries, Entry{Seq: 3, Key: []byte("c"), Value: []byte("3")})
+	acked, err = recv.Apply(batch)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), acked)
+	assert.Equal(t, []string{"a", "b", "c"}, keys(t, standby))
+
+	// another primary's offsets are its own
+	acked, err = recv.Apply(&Batch{Source: "gw-02", Entries: []Entry{{Seq: 1, Key: []byte("d")}}})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), acked)
+
+	reloaded := NewReceiver(standby, filepath.Join(dir, "received.json"))
+	require.NoError(t, reloaded.Load())
+	acked, err = reloaded.Apply(&Batch{Source: "gw-01", Entries: batch.Entries})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), acked)
+	assert.Len(t, keys(t, standby), 4)
+	assert.Equal(t, uint64(4), reloaded.Written(), "the standby's seq of d")
+
+	_, err = recv.Apply(&Batch{})
+	assert.ErrorIs(t, err, ErrNoSource)
+}
@@ -0,0 +1,31 @@
+package replication
+
+import "sync/atomic"
+
+// Role is whether a sink takes ingest itself or follows a leader, taking
+// its data from the leader's replication stream and serving reads only.
+// The zero value leads.
+type Role struct {
+	leader atomic.Pointer[string]
+}
+
+// Follow makes the sink a follower of the sink at leader, a base URL
+// writers are pointed to; it may be empty when the leader isn't known.
+func (r *Role) Follow(leader string) {
+	r.leader.Store(&leader)
+	following.Set(1)
+}
+
+// Lead makes the sink take ingest itself.
+func (r *Role) Lead() {
+	r.leader.Store(nil)
+	following.Set(0)
+}
+
+// Leader reports whether the sink is following, and which leader.
+func (r *Role) Leader() (string, bool) {
+	if l := r.leader.Load(); l != nil {
+		return *l, true
+	}
+	return "", false
+}
@@ -0,0 +1,261 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// Source is the journal a Sender replicates; *journal.Journal implements
+// it.
+type Source interface {
+	// Sync makes everything written so far visible to ReplayAfter.
+	Sync() error
+	ReplayAfter(seq uint64, fn func(*journal.Entry) error) error
+}
+
+// errBatchFull stops a replay once a batch has been collected.
+var errBatchFull = errors.New("batch full")
+
+// Sender replicates a journal to one peer.
+type Sender struct {
+	src       Source
+	url       string
+	name      string
+	token     string
+	batchSize int
+	statePath string
+	client    *http.Client
+	gate      func() bool
+	acked     atomic.Uint64
+}
+
+type SenderOption func(*Sender)
+
+// WithToken sends "Authorization: Bearer <token>" with every batch.
+func WithToken(token string) SenderOption {
+	return func(s *Sender) { s.token = token }
+}
+
+// WithSourceName is how the peer tells this sink apart from other primaries
+// replicating to it; it defaults to the hostname.
+func WithSourceName(name string) SenderOption {
+	return func(s *Sender) { s.name = name }
+}
+
+// WithBatchSize caps the entries per request, up to MaxBatch.
+func WithBatchSize(n int) SenderOption {
+	return func(s *Sender) { s.batchSize = min(max(n, 1), MaxBatch) }
+}
+
+// WithStateFile keeps the acknowledged offset in path across restarts.
+// Without one a restarted sender starts over from the beginning of the
+// journal, which the peer skips through but still has to receive.
+func WithStateFile(path string) SenderOption {
+	return func(s *Sender) { s.statePath = path }
+}
+
+// WithHTTPClient replaces the default client, which times out after 30s.
+func WithHTTPClient(c *http.Client) SenderOption {
+	return func(s *Sender) { s.client = c }
+}
+
+// WithGate makes Run send only while gate reports true, e.g. while this
+// sink leads an HA pair.
+func WithGate(gate func() bool) SenderOption {
+	return func(s *Sender) { s.gate = gate }
+}
+
+// NewSender replicates src to the sink at peer, a base URL such as
+// "http://gw-02:8080".
+func NewSender(src Source, peer string, opts ...SenderOption) *Sender {
+	s := &Sender{
+		src:       src,
+		url:       strings.TrimSuffix(peer, "/") + "/replication/entries",
+		batchSize: 1000,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+	s.name, _ = os.Hostname()
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type senderState struct {
+	Acked uint64 `json:"acked"`
+}
+
+// Load restores the acknowledged offset from the state file, if any.
+func (s *Sender) Load() error {
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st senderState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	s.setAcked(st.Acked)
+	return nil
+}
+
+func (s *Sender) save() error {
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(senderState{Acked: s.acked.Load()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0755); err != nil {
+		return err
+	}
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statePath)
+}
+
+// Acked is the highest sequence number the peer has acknowledged.
+func (s *Sender) Acked() uint64 {
+	return s.acked.Load()
+}
+
+// SkipTo treats everything up to seq as acknowledged without sending it.
+// A sink taking over leadership skips what it received from the previous
+// leader, which already has it.
+func (s *Sender) SkipTo(seq uint64) error {
+	if seq <= s.acked.Load() {
+		return nil
+	}
+	s.setAcked(seq)
+	return s.save()
+}
+
+func (s *Sender) setAcked(seq uint64) {
+	s.acked.Store(seq)
+	ackedSeq(s.url).Set(float64(seq))
+}
+
+// Run sends new entries every interval until ctx is done. A failed batch
+// is logged and retried on the next tick; the peer just falls behind
+// meanwhile.
+func (s *Sender) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if s.gate != nil && !s.gate() {
+				continue
+			}
+			if err := s.Replicate(ctx); err != nil && ctx.Err() == nil {
+				replicationErrors(s.url).Inc()
+				slog.Warn("replication failed", "peer", s.url, "acked", s.Acked(), "error", err)
+			}
+		}
+	}
+}
+
+// Replicate sends batches until the peer has acknowledged everything
+// written so far.
+func (s *Sender) Replicate(ctx context.Context) error {
+	if err := s.src.Sync(); err != nil {
+		return err
+	}
+	for {
+		batch, err := s.collect()
+		if err != nil {
+			return err
+		}
+		if len(batch.Entries) == 0 {
+			return nil
+		}
+		acked, err := s.send(ctx, batch)
+		if err != nil {
+			return err
+		}
+		sentEntries(s.url).Add(len(batch.Entries))
+		s.setAcked(acked)
+		if err := s.save(); err != nil {
+			return err
+		}
+		if len(batch.Entries) < s.batchSize {
+			return nil
+		}
+	}
+}
+
+func (s *Sender) collect() (*Batch, error) {
+	b := &Batch{Source: s.name}
+	err := s.src.ReplayAfter(s.acked.Load(), func(e *journal.Entry) error {
+		re := Entry{Seq: e.Seq, Key: e.Key, Value: e.Value}
+		if !e.Expires.IsZero() {
+			re.Expires = e.Expires.UnixNano()
+		}
+		b.Entries = append(b.Entries, re)
+		if len(b.Entries) >= s.batchSize {
+			return errBatchFull
+		}
+		return nil
+	})
+	if err != nil && err != errBatchFull {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *Sender) send(ctx context.Context, b *Batch) (uint64, error) {
+	body, err := b.MarshalMsg(nil)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("peer: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var ack Ack
+	if err := json.Unmarshal(data, &ack); err != nil {
+		return 0, fmt.Errorf("peer: bad ack: %w", err)
+	}
+	return ack.Acked, nil
+}
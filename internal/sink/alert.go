@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// LogAlerter logs alerts, silent sensors at warn level.
+type LogAlerter struct{}
+
+func (LogAlerter) Alert(ctx context.Context, a Alert) error {
+	level := slog.LevelInfo
+	msg := "sensor reporting again"
+	if a.State == AlertSilent {
+		level = slog.LevelWarn
+		msg = "sensor went silent"
+	}
+	slog.Log(ctx, level, msg,
+		"rule", a.Rule,
+		"sensor", a.Sensor,
+		"every", a.Every,
+		"last_seen", a.LastSeen,
+	)
+	return nil
+}
+
+// WebhookAlerter POSTs each alert as JSON to URL, e.g. a Slack or
+// Alertmanager relay. Any non-2xx response is an error.
+type WebhookAlerter struct {
+	URL     string
+	Headers http.Header
+	// Client defaults to one with a 10s timeout.
+	Client *http.Client
+}
+
+var defaultWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func (w WebhookAlerter) Alert(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
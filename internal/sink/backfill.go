@@ -0,0 +1,44 @@
+package sink
+
+import (
+	"errors"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+var ErrBackfillDisabled = errors.New("backfill not enabled")
+
+// WithBackfill sets the middlewares events appended with AppendBackfill go
+// through instead of the live ones, typically the live pipeline without
+// rate limiting and dedup and with a quota of its own.
+func WithBackfill(middlewares ...Middleware) Option {
+	return func(s *Sink) {
+		s.backfillMws = middlewares
+		s.backfillOn = true
+	}
+}
+
+// AppendBackfill appends a historical event, e.g. one migrated from an old
+// datastore, through the backfill middlewares so bulk loads don't fight the
+// protections meant for live traffic. The event is stored with Backfill
+// set.
+func (s *Sink) AppendBackfill(ev entity.Event) error {
+	if !s.backfillOn {
+		return ErrBackfillDisabled
+	}
+	if s.closed.Load() {
+		return ErrSinkClosed
+	}
+	if s.journal == nil {
+		return ErrJournalIsNil
+	}
+	if s.degraded.Load() {
+		return s.degradedErr()
+	}
+	ev.Backfill = true
+	if err := s.backfill(ev); err != nil {
+		return err
+	}
+	eventsBackfilled.Inc()
+	return nil
+}
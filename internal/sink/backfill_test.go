@@ -0,0 +1,52 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+func TestAppendBackfill(t *testing.T) {
+	reject := func(err error) Middleware {
+		return func(Handler) Handler {
+			return func(entity.Event) error { return err }
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s, _ := newSink(t, 4)
+		assert.ErrorIs(t, s.AppendBackfill(event("temp", 1, 1)), ErrBackfillDisabled)
+	})
+
+	t.Run("own middlewares, tagged", func(t *testing.T) {
+		s := New(NewMockJournal(gomock.NewController(t)), WithBufSize(4),
+			WithMiddleware(reject(apperr.ErrRateLimited)),
+			WithBackfill(),
+		)
+		assert.ErrorIs(t, s.Append(event("temp", 1, 1)), apperr.ErrRateLimited)
+		require.NoError(t, s.AppendBackfill(event("temp", 2, 2)))
+
+		evs := s.buf.Drain()
+		require.Len(t, evs, 1)
+		assert.True(t, evs[0].Backfill)
+	})
+
+	t.Run("backfill quota", func(t *testing.T) {
+		s := New(NewMockJournal(gomock.NewController(t)), WithBufSize(4), WithBackfill(reject(apperr.ErrQuotaExceeded)))
+		require.NoError(t, s.Append(event("temp", 1, 1)))
+		assert.ErrorIs(t, s.AppendBackfill(event("temp", 2, 2)), apperr.ErrQuotaExceeded)
+	})
+
+	t.Run("live events can't claim the tag", func(t *testing.T) {
+		s := New(NewMockJournal(gomock.NewController(t)), WithBufSize(4))
+		ev := event("temp", 1, 1)
+		ev.Backfill = true
+		require.NoError(t, s.Append(ev))
+		assert.False(t, s.buf.Drain()[0].Backfill)
+	})
+}
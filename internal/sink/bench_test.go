@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// BenchmarkAppend measures Append through the default stages, flushing to
+// an in-memory journal every flushEvery events as the ticker would.
+func BenchmarkAppend(b *testing.B) {
+	const flushEvery = 1024
+
+	chains := []struct {
+		name string
+		mws  func() []Middleware
+	}{
+		{"bare", func() []Middleware { return nil }},
+		{"default", func() []Middleware {
+			return []Middleware{
+				NewDeduplicator(0).Middleware(),
+				NewRateLimiter(1<<40, WithEventsPerSec(1<<40)).Middleware(),
+				NewQuota(0, 0, "", 0).Middleware(),
+				NewStats(time.Hour).Middleware(),
+			}
+		}},
+	}
+	for _, c := range chains {
+		b.Run(c.name, func(b *testing.B) {
+			j, err := journal.New(journal.NewMemStorage(), 64<<20)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() { j.Close() })
+			s := New(j, WithBufSize(flushEvery), WithMiddleware(c.mws()...))
+
+			ev := entity.Event{Sensor: "temp-north", Value: 42}
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				ev.IdempotencyID = strconv.Itoa(i)
+				ev.UnixTimestamp = int64(i)
+				if err := s.Append(ev); err != nil {
+					b.Fatal(err)
+				}
+				if i++; i%flushEvery == 0 {
+					if err := s.flush(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDeduplicator measures concurrent appends of unique IDs through
+// dedup, with a single lock against the default shards. Run it with -cpu
+// set to the cores ingest runs on; contention only shows with several.
+func BenchmarkDeduplicator(b *testing.B) {
+	for _, shards := range []int{1, defaultDedupShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			d := NewDeduplicator(0, WithDedupShards(shards))
+			h := d.Middleware()(func(entity.Event) error { return nil })
+			var next atomic.Int64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				ev := entity.Event{Sensor: "temp-north", Value: 42}
+				for pb.Next() {
+					ev.IdempotencyID = strconv.FormatInt(next.Add(1), 10)
+					if err := h(ev); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
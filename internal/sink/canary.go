@@ -0,0 +1,145 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// CanarySensor is the sensor canary events are written under. Readers of
+// the journal that hand events on, such as /events and the sensor stats,
+// leave it out.
+const CanarySensor = "_canary"
+
+// IsCanary reports whether ev was written by a Canary.
+func IsCanary(ev entity.Event) bool {
+	return ev.Sensor == CanarySensor
+}
+
+// CanaryStatus is the outcome of the latest canary round.
+type CanaryStatus struct {
+	// LastSuccess is when a canary event last made it to the journal, and
+	// Latency how long that took.
+	LastSuccess time.Time
+	Latency     time.Duration
+	Seq         uint64
+	// Failures counts the rounds failed since the last success, and Error
+	// is the latest one's.
+	Failures int
+	Error    string
+	// Paused is set while the gate holds the canary back.
+	Paused bool
+}
+
+// Healthy reports whether the latest round made it to the journal, or the
+// canary is paused.
+func (st CanaryStatus) Healthy() bool {
+	return st.Paused || st.Failures == 0 && !st.LastSuccess.IsZero()
+}
+
+// Canary checks the write path by appending a synthetic event every
+// interval, through the middleware and the buffers to the journal, and
+// timing how long it takes to be written. A write path that silently
+// stopped, say a flush stuck on a hung disk, shows up as failing rounds
+// while ingest still looks fine.
+type Canary struct {
+	sink    *Sink
+	timeout time.Duration
+	gate    func() bool
+	now     func() time.Time
+
+	mu     sync.Mutex
+	status CanaryStatus
+	round  uint64
+}
+
+type CanaryOption func(*Canary)
+
+// WithCanaryGate skips rounds while gate returns false, e.g. on a follower
+// that takes no writes.
+func WithCanaryGate(gate func() bool) CanaryOption {
+	return func(c *Canary) {
+		c.gate = gate
+	}
+}
+
+// NewCanary checks s, failing a round that isn't written within timeout.
+func NewCanary(s *Sink, timeout time.Duration, opts ...CanaryOption) *Canary {
+	c := &Canary{sink: s, timeout: timeout, now: time.Now}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run does a round now and every interval after until ctx is done.
+func (c *Canary) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check does one round and returns its outcome.
+func (c *Canary) Check(ctx context.Context) CanaryStatus {
+	if c.gate != nil && !c.gate() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.status.Paused = true
+		return c.status
+	}
+
+	c.mu.Lock()
+	c.round++
+	round := c.round
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := c.now()
+	seq, err := c.sink.AppendSeq(ctx, entity.Event{
+		IdempotencyID: "canary-" + strconv.FormatUint(round, 10) + "-" + uuid.NewString(),
+		Sensor:        CanarySensor,
+		Value:         int(round),
+		UnixTimestamp: start.UnixMilli(),
+	})
+	latency := c.now().Sub(start)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.Paused = false
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errors.New("not written within " + c.timeout.String())
+		}
+		c.status.Failures++
+		c.status.Error = err.Error()
+		canaryFailures.Inc()
+		slog.Warn("canary event failed", "failures", c.status.Failures, "error", err)
+		return c.status
+	}
+	c.status = CanaryStatus{LastSuccess: start.Add(latency), Latency: latency, Seq: seq}
+	canaryLatency.Update(latency.Seconds())
+	canaryLastSuccess.Set(float64(c.status.LastSuccess.Unix()))
+	return c.status
+}
+
+// Status returns the outcome of the latest round.
+func (c *Canary) Status() CanaryStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
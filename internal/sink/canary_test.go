@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestCanary(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 0)
+	require.NoError(t, err)
+	defer j.Close()
+	s := New(j, WithBufSize(8))
+	stats := NewStats(time.Hour)
+
+	c := NewCanary(s, time.Minute)
+	assert.False(t, c.Status().Healthy(), "healthy before the first round")
+
+	done := make(chan CanaryStatus, 1)
+	go func() { done <- c.Check(context.Background()) }()
+	var st CanaryStatus
+	require.Eventually(t, func() bool {
+		require.NoError(t, s.Flush())
+		select {
+		case st = <-done:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.True(t, st.Healthy())
+	assert.Equal(t, uint64(1), st.Seq)
+	assert.Equal(t, st, c.Status())
+	require.NoError(t, j.Sync())
+	require.NoError(t, j.Replay(func(e *journal.Entry) error {
+		ev, err := DecodeValue(e.Value)
+		require.NoError(t, err)
+		assert.True(t, IsCanary(ev))
+		return stats.ObserveEntry(e)
+	}))
+	assert.Empty(t, stats.Report().Sensors, "canary counted in stats")
+
+	t.Run("not written in time", func(t *testing.T) {
+		st := NewCanary(s, 10*time.Millisecond).Check(context.Background())
+		assert.False(t, st.Healthy())
+		assert.Equal(t, 1, st.Failures)
+		assert.Equal(t, "not written within 10ms", st.Error)
+	})
+
+	t.Run("paused", func(t *testing.T) {
+		leading := false
+		c := NewCanary(s, 10*time.Millisecond, WithCanaryGate(func() bool { return leading }))
+		st := c.Check(context.Background())
+		assert.True(t, st.Paused)
+		assert.True(t, st.Healthy())
+
+		leading = true
+		st = c.Check(context.Background())
+		assert.False(t, st.Paused)
+		assert.False(t, st.Healthy())
+	})
+}
@@ -1,7 +1,12 @@
 package sink
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/maphash"
 	"log/slog"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,18 +20,94 @@ import (
 var (
 	dedupTotal   = metrics.NewCounter("sink_dedup_total")
 	dedupDropped = metrics.NewCounter("sink_dedup_dropped_total")
+	// dedupConflicts counts IDs seen again on different content, with
+	// WithContentHash.
+	dedupConflicts = metrics.NewCounter("sink_dedup_conflicts_total")
 )
 
+// dedupEntry remembers the journal sequence number the first copy of an
+// event was written with, once it's flushed, and with WithContentHash a
+// hash of its content.
+type dedupEntry struct {
+	seq  atomic.Uint64
+	hash uint64
+}
+
+// defaultDedupShards spreads IDs over enough locks that appends on every
+// core rarely contend.
+const defaultDedupShards = 64
+
+// Deduplicator drops events whose IdempotencyID it has seen since the last
+// cleaning. IDs are spread over shards by hash, each a map under its own
+// lock, so concurrent appends don't all contend on one.
 type Deduplicator struct {
-	m        sync.Map
-	count    atomic.Uint64
+	shards   []dedupShard
+	seed     maphash.Seed
 	interval time.Duration
+	hashes   bool
+
+	// counted since start, for Report
+	checks     atomic.Uint64
+	duplicates atomic.Uint64
+	conflicts  atomic.Uint64
+	recent     *dedupRing
+}
+
+type dedupShard struct {
+	mu sync.Mutex
+	m  map[string]*dedupEntry
+	_  [48]byte // pads a shard to 64 bytes, so no two locks share a cache line
+}
+
+type DedupOption func(*Deduplicator)
+
+// WithDedupShards sets the number of shards, 64 by default. One makes a
+// single map under a single lock.
+func WithDedupShards(n int) DedupOption {
+	return func(d *Deduplicator) {
+		if n > 0 {
+			d.shards = make([]dedupShard, n)
+		}
+	}
+}
+
+// WithContentHash remembers a hash of each event's content along with its
+// ID, and fails an event whose ID was seen on different content with
+// ErrIdempotencyConflict instead of dropping it as a duplicate. It costs
+// a hash per event and 8 bytes per remembered ID.
+func WithContentHash() DedupOption {
+	return func(d *Deduplicator) { d.hashes = true }
+}
+
+// WithDedupRecent keeps the last n duplicates and conflicts, reported by
+// Report, to tell which IDs a client keeps resending.
+func WithDedupRecent(n int) DedupOption {
+	return func(d *Deduplicator) {
+		if n > 0 {
+			d.recent = &dedupRing{hits: make([]DedupHit, 0, n)}
+		}
+	}
 }
 
-func NewDeduplicator(interval time.Duration) *Deduplicator {
-	return &Deduplicator{
+func NewDeduplicator(interval time.Duration, opts ...DedupOption) *Deduplicator {
+	d := &Deduplicator{
 		interval: interval,
+		seed:     maphash.MakeSeed(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.shards == nil {
+		d.shards = make([]dedupShard, defaultDedupShards)
 	}
+	for i := range d.shards {
+		d.shards[i].m = make(map[string]*dedupEntry)
+	}
+	return d
+}
+
+func (d *Deduplicator) shard(id string) *dedupShard {
+	return &d.shards[maphash.String(d.seed, id)%uint64(len(d.shards))]
 }
 
 func (d *Deduplicator) Start() {
@@ -38,11 +119,12 @@ func (d *Deduplicator) Start() {
 		ticker := time.NewTicker(d.interval)
 		defer ticker.Stop()
 		for range ticker.C {
-			d.m.Range(func(key, value interface{}) bool {
-				d.m.Delete(key)
-				return true
-			})
-			d.count.Store(0)
+			for i := range d.shards {
+				sh := &d.shards[i]
+				sh.mu.Lock()
+				sh.m = make(map[string]*dedupEntry)
+				sh.mu.Unlock()
+			}
 		}
 	}()
 }
@@ -55,20 +137,193 @@ func (d *Deduplicator) Middleware() Middleware {
 			}
 
 			dedupTotal.Inc()
+			d.checks.Add(1)
 
-			if _, loaded := d.m.LoadOrStore(ev.IdempotencyID, struct{}{}); loaded {
+			var hash uint64
+			if d.hashes {
+				hash = d.contentHash(&ev)
+			}
+
+			sh := d.shard(ev.IdempotencyID)
+			sh.mu.Lock()
+			if e, ok := sh.m[ev.IdempotencyID]; ok {
+				sh.mu.Unlock()
+				if e.hash != hash {
+					dedupConflicts.Inc()
+					d.conflicts.Add(1)
+					d.recent.add(DedupHit{ID: ev.IdempotencyID, Sensor: ev.Sensor, At: time.Now(), Seq: e.seq.Load(), Conflict: true})
+					slog.Debug("idempotency id reused for different content", "idempotency_id", ev.IdempotencyID)
+					return fmt.Errorf("%w: %q", apperr.ErrIdempotencyConflict, ev.IdempotencyID)
+				}
 				dedupDropped.Inc()
+				d.duplicates.Add(1)
+				d.recent.add(DedupHit{ID: ev.IdempotencyID, Sensor: ev.Sensor, At: time.Now(), Seq: e.seq.Load()})
 				slog.Debug("duplicate event dropped", "idempotency_id", ev.IdempotencyID)
-				return apperr.ErrDuplicate
+				return &apperr.DuplicateError{Seq: e.seq.Load()}
 			}
+			e := &dedupEntry{hash: hash}
+			sh.m[ev.IdempotencyID] = e
+			sh.mu.Unlock()
 
-			d.count.Add(1)
+			// a later stage turning the event away, say to be retried after
+			// a 429, mustn't make the retry look like a duplicate
+			err := next(ev)
+			var dup *apperr.DuplicateError
+			if err != nil && !errors.As(err, &dup) {
+				sh.mu.Lock()
+				if sh.m[ev.IdempotencyID] == e {
+					delete(sh.m, ev.IdempotencyID)
+				}
+				sh.mu.Unlock()
+			}
+			return err
+		}
+	}
+}
 
-			return next(ev)
+// contentHash hashes everything an event says but its ID. Fields are
+// summed per field, so the order a map yields them in doesn't matter.
+func (d *Deduplicator) contentHash(ev *entity.Event) uint64 {
+	var h maphash.Hash
+	h.SetSeed(d.seed)
+	h.WriteString(ev.Sensor)
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], uint64(ev.Value))
+	binary.LittleEndian.PutUint64(b[8:], uint64(ev.UnixTimestamp))
+	_, _ = h.Write(b[:])
+	sum := h.Sum64()
+	for name, v := range ev.Fields {
+		h.Reset()
+		h.WriteString(name)
+		binary.LittleEndian.PutUint64(b[:8], math.Float64bits(v))
+		_, _ = h.Write(b[:8])
+		sum += h.Sum64()
+	}
+	return sum
+}
+
+// Written records the sequence number the event with id was written with,
+// for duplicates of it to report. Pass it to WithWrittenHook.
+func (d *Deduplicator) Written(id string, seq uint64) {
+	sh := d.shard(id)
+	sh.mu.Lock()
+	e := sh.m[id]
+	sh.mu.Unlock()
+	if e != nil {
+		e.seq.Store(seq)
+	}
+}
+
+// Shrink forgets about half the remembered IDs to free memory, trading
+// some duplicates getting through for the process staying up.
+func (d *Deduplicator) Shrink() {
+	var n int
+	for i := range d.shards {
+		sh := &d.shards[i]
+		sh.mu.Lock()
+		for id := range sh.m {
+			if n%2 == 0 {
+				delete(sh.m, id)
+			}
+			n++
 		}
+		sh.mu.Unlock()
 	}
 }
 
+// Count is the number of IDs remembered, summed over the shards.
 func (d *Deduplicator) Count() uint {
-	return uint(d.count.Load())
+	var n uint
+	for i := range d.shards {
+		sh := &d.shards[i]
+		sh.mu.Lock()
+		n += uint(len(sh.m))
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// Purge forgets id, so the next event carrying it is let through, and
+// reports whether it was remembered.
+func (d *Deduplicator) Purge(id string) bool {
+	sh := d.shard(id)
+	sh.mu.Lock()
+	_, ok := sh.m[id]
+	delete(sh.m, id)
+	sh.mu.Unlock()
+	return ok
+}
+
+// DedupReport is what the deduplicator remembers and has done since
+// start, served on /admin/dedup.
+type DedupReport struct {
+	IDs        uint   `json:"ids"`
+	Checks     uint64 `json:"checks"`
+	Duplicates uint64 `json:"duplicates"`
+	Conflicts  uint64 `json:"conflicts"`
+	// HitRatio is the share of checked events dropped as duplicates.
+	HitRatio float64 `json:"hit_ratio"`
+	// Recent lists the last duplicates and conflicts, newest first.
+	Recent []DedupHit `json:"recent"`
+}
+
+// DedupHit is an event dedup turned away.
+type DedupHit struct {
+	ID     string    `json:"idempotency_id"`
+	Sensor string    `json:"sensor"`
+	At     time.Time `json:"at"`
+	// Seq is the sequence number of the first copy, 0 while it's
+	// buffered.
+	Seq      uint64 `json:"seq,omitempty"`
+	Conflict bool   `json:"conflict,omitempty"`
+}
+
+func (d *Deduplicator) Report() DedupReport {
+	r := DedupReport{
+		IDs:        d.Count(),
+		Checks:     d.checks.Load(),
+		Duplicates: d.duplicates.Load(),
+		Conflicts:  d.conflicts.Load(),
+		Recent:     d.recent.list(),
+	}
+	if r.Checks > 0 {
+		r.HitRatio = float64(r.Duplicates) / float64(r.Checks)
+	}
+	return r
+}
+
+// dedupRing holds the last cap(hits) hits; a nil ring keeps none.
+type dedupRing struct {
+	mu   sync.Mutex
+	hits []DedupHit
+	next int
+}
+
+func (r *dedupRing) add(h DedupHit) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.hits) < cap(r.hits) {
+		r.hits = append(r.hits, h)
+		return
+	}
+	r.hits[r.next] = h
+	r.next = (r.next + 1) % len(r.hits)
+}
+
+func (r *dedupRing) list() []DedupHit {
+	if r == nil {
+		return []DedupHit{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]DedupHit, 0, len(r.hits))
+	for i := range r.hits {
+		// newest is just before next, or last while the ring fills up
+		j := (r.next - 1 - i + 2*len(r.hits)) % len(r.hits)
+		out = append(out, r.hits[j])
+	}
+	return out
 }
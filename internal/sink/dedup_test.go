@@ -1,6 +1,8 @@
 package sink
 
 import (
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -52,6 +54,54 @@ func TestDeduplicator(t *testing.T) {
 		assert.Len(t, received, 1)
 	})
 
+	t.Run("content hash tells reused ids from duplicates", func(t *testing.T) {
+		var received []entity.Event
+		handler := func(ev entity.Event) error {
+			received = append(received, ev)
+			return nil
+		}
+
+		d := NewDeduplicator(time.Hour, WithContentHash())
+		mw := d.Middleware()(handler)
+
+		first := entity.Event{IdempotencyID: "same", Sensor: "env", UnixTimestamp: 1, Fields: map[string]float64{"temp": 21.5, "hum": 40, "co2": 410}}
+		require.NoError(t, mw(first))
+		again := first
+		again.Fields = map[string]float64{"co2": 410, "hum": 40, "temp": 21.5}
+		assert.ErrorIs(t, mw(again), apperr.ErrDuplicate)
+
+		for _, changed := range []entity.Event{
+			{IdempotencyID: "same", Sensor: "env", UnixTimestamp: 2, Fields: first.Fields},
+			{IdempotencyID: "same", Sensor: "env2", UnixTimestamp: 1, Fields: first.Fields},
+			{IdempotencyID: "same", Sensor: "env", UnixTimestamp: 1, Fields: map[string]float64{"temp": 21.5, "hum": 41, "co2": 410}},
+			{IdempotencyID: "same", Sensor: "env", Value: 1, UnixTimestamp: 1, Fields: first.Fields},
+		} {
+			err := mw(changed)
+			assert.ErrorIs(t, err, apperr.ErrIdempotencyConflict)
+			assert.NotErrorIs(t, err, apperr.ErrDuplicate)
+		}
+		assert.Len(t, received, 1)
+	})
+}
+
+func TestDeduplicatorForgetsRejected(t *testing.T) {
+	d := NewDeduplicator(time.Hour)
+	limited := true
+	mw := d.Middleware()(func(entity.Event) error {
+		if limited {
+			return apperr.ErrRateLimited
+		}
+		return nil
+	})
+
+	ev := entity.Event{IdempotencyID: "x", Sensor: "temp", Value: 1}
+	assert.ErrorIs(t, mw(ev), apperr.ErrRateLimited)
+	assert.Zero(t, d.Count())
+
+	// retried after Retry-After
+	limited = false
+	require.NoError(t, mw(ev))
+	assert.ErrorIs(t, mw(ev), apperr.ErrDuplicate)
 }
 
 func TestDeduplicatorWithSink(t *testing.T) {
@@ -72,6 +122,27 @@ func TestDeduplicatorWithSink(t *testing.T) {
 	assert.Equal(t, uint(2), d.Count())
 }
 
+func TestDuplicateReportsFirstSeq(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	j := NewMockJournal(ctrl)
+	j.EXPECT().WriteBatch(gomock.Any()).Return([]uint64{41, 42}, nil)
+
+	d := NewDeduplicator(time.Hour)
+	s := New(j, WithBufSize(10), WithMiddleware(d.Middleware()), WithWrittenHook(d.Written))
+
+	require.NoError(t, s.Append(entity.Event{IdempotencyID: "x", Sensor: "temp", Value: 1}))
+	require.NoError(t, s.Append(entity.Event{Sensor: "temp", Value: 2}))
+
+	var de *apperr.DuplicateError
+	require.ErrorAs(t, s.Append(entity.Event{IdempotencyID: "x", Sensor: "temp", Value: 1}), &de)
+	assert.Zero(t, de.Seq, "still buffered")
+
+	require.NoError(t, s.flush())
+	// buffers drain newest first, so "x" is the second entry
+	require.ErrorAs(t, s.Append(entity.Event{IdempotencyID: "x", Sensor: "temp", Value: 1}), &de)
+	assert.Equal(t, uint64(42), de.Seq)
+}
+
 func TestDeduplicatorCleaning(t *testing.T) {
 	d := NewDeduplicator(10 * time.Millisecond)
 	d.Start()
@@ -93,3 +164,87 @@ func TestDeduplicatorCleaning(t *testing.T) {
 	assert.NoError(t, err3, "should be able to insert again after cleaning")
 	assert.Equal(t, uint(1), d.Count())
 }
+
+func TestDeduplicatorShrink(t *testing.T) {
+	d := NewDeduplicator(0)
+	mw := d.Middleware()(func(ev entity.Event) error { return nil })
+	for i := range 10 {
+		require.NoError(t, mw(entity.Event{IdempotencyID: strconv.Itoa(i)}))
+	}
+
+	d.Shrink()
+	assert.Equal(t, uint(5), d.Count())
+
+	var forgotten int
+	for i := range 10 {
+		if mw(entity.Event{IdempotencyID: strconv.Itoa(i)}) == nil {
+			forgotten++
+		}
+	}
+	assert.Equal(t, 5, forgotten)
+}
+
+func TestDeduplicatorShards(t *testing.T) {
+	for _, shards := range []int{1, 7, defaultDedupShards} {
+		t.Run(strconv.Itoa(shards), func(t *testing.T) {
+			d := NewDeduplicator(0, WithDedupShards(shards))
+			mw := d.Middleware()(func(ev entity.Event) error { return nil })
+
+			var wg sync.WaitGroup
+			for w := range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// every worker sends its own ids and half of its neighbour's
+					for i := range 500 {
+						_ = mw(entity.Event{IdempotencyID: strconv.Itoa(w*500 + i)})
+						_ = mw(entity.Event{IdempotencyID: strconv.Itoa((w+1)%8*500 + i/2)})
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, uint(4000), d.Count())
+
+			err := mw(entity.Event{IdempotencyID: "1234"})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDeduplicatorReport(t *testing.T) {
+	d := NewDeduplicator(time.Hour, WithDedupRecent(2), WithContentHash())
+	mw := d.Middleware()(func(entity.Event) error { return nil })
+
+	require.NoError(t, mw(entity.Event{IdempotencyID: "a", Sensor: "temp", Value: 1}))
+	require.NoError(t, mw(entity.Event{IdempotencyID: "b", Sensor: "temp", Value: 1}))
+	d.Written("a", 7)
+	assert.ErrorIs(t, mw(entity.Event{IdempotencyID: "a", Sensor: "temp", Value: 1}), apperr.ErrDuplicate)
+	assert.ErrorIs(t, mw(entity.Event{IdempotencyID: "b", Sensor: "temp", Value: 1}), apperr.ErrDuplicate)
+	assert.ErrorIs(t, mw(entity.Event{IdempotencyID: "b", Sensor: "temp", Value: 2}), apperr.ErrIdempotencyConflict)
+
+	r := d.Report()
+	assert.EqualValues(t, 2, r.IDs)
+	assert.EqualValues(t, 5, r.Checks)
+	assert.EqualValues(t, 2, r.Duplicates)
+	assert.EqualValues(t, 1, r.Conflicts)
+	assert.InDelta(t, 0.4, r.HitRatio, 1e-9)
+
+	// the ring keeps the last two, newest first
+	require.Len(t, r.Recent, 2)
+	assert.Equal(t, "b", r.Recent[0].ID)
+	assert.True(t, r.Recent[0].Conflict)
+	assert.Equal(t, "b", r.Recent[1].ID)
+	assert.False(t, r.Recent[1].Conflict)
+
+	t.Run("purge lets the id through again", func(t *testing.T) {
+		assert.True(t, d.Purge("a"))
+		assert.False(t, d.Purge("a"))
+		assert.NoError(t, mw(entity.Event{IdempotencyID: "a", Sensor: "temp", Value: 1}))
+	})
+
+	t.Run("no ring", func(t *testing.T) {
+		r := NewDeduplicator(time.Hour).Report()
+		assert.Empty(t, r.Recent)
+		assert.Zero(t, r.HitRatio)
+	})
+}
@@ -5,5 +5,7 @@ import "github.com/andriibeee/iotdemo/pkg/journal"
 //go:generate mockgen -source=dependencies.go -destination=mock_journal_test.go -package=sink
 type Journal interface {
 	Write(k, v []byte) (uint64, error)
+	// WriteBatch may return seqs along with an error, for the entries it
+	// wrote before failing; those with seq 0 weren't.
 	WriteBatch(entries []journal.Entry) ([]uint64, error)
 }
@@ -0,0 +1,137 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+var ErrInvalidDeviceInfo = errors.New("invalid device info")
+
+// DeviceStatus is what the /devices endpoint reports for one device: its
+// latest DeviceInfo and how many it has sent.
+type DeviceStatus struct {
+	entity.DeviceInfo
+	Reports int64 `json:"reports"`
+}
+
+// Devices journals the DeviceInfo reports of devices and keeps the latest
+// of each, by its own timestamp, for the /devices endpoint and the
+// device_* gauges. Reports skip the event pipeline: they're few, and
+// dedup, rate limits and transforms are about sensor readings.
+type Devices struct {
+	journal Journal
+	keys    KeyCodec
+	now     func() time.Time
+
+	mu      sync.Mutex
+	devices map[string]*DeviceStatus
+}
+
+func NewDevices(j Journal, keys KeyCodec) *Devices {
+	if keys == nil {
+		keys = TextKeys
+	}
+	return &Devices{
+		journal: j,
+		keys:    keys,
+		now:     time.Now,
+		devices: make(map[string]*DeviceStatus),
+	}
+}
+
+// Register writes info to the journal straight away and returns its
+// sequence number. A report without a timestamp gets the current time.
+func (d *Devices) Register(info entity.DeviceInfo) (uint64, error) {
+	if d.journal == nil {
+		return 0, ErrJournalIsNil
+	}
+	switch {
+	case info.Device == "":
+		return 0, fmt.Errorf("%w: no device", ErrInvalidDeviceInfo)
+	case info.Battery != nil && (*info.Battery < 0 || *info.Battery > 100):
+		return 0, fmt.Errorf("%w: battery %v%% is not within 0-100", ErrInvalidDeviceInfo, *info.Battery)
+	}
+	if info.UnixTimestamp == 0 {
+		info.UnixTimestamp = d.now().UnixMilli()
+	}
+
+	val, err := EncodeDeviceValue(nil, &info)
+	if err != nil {
+		return 0, err
+	}
+	seq, err := d.journal.Write(d.keys.EncodeDevice(info.Device, info.UnixTimestamp), val)
+	if err != nil {
+		return 0, err
+	}
+	deviceReports.Inc()
+	d.Observe(info)
+	return seq, nil
+}
+
+// Observe records info as the device's latest, unless it already has a
+// newer one.
+func (d *Devices) Observe(info entity.DeviceInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st, ok := d.devices[info.Device]
+	if !ok {
+		st = &DeviceStatus{}
+		d.devices[info.Device] = st
+		registerDeviceMetrics(d, info.Device)
+	}
+	st.Reports++
+	if info.UnixTimestamp >= st.UnixTimestamp {
+		st.DeviceInfo = info
+	}
+}
+
+// Rebuild replays the journal's device reports, whatever their key
+// layout, until ctx is done.
+func (d *Devices) Rebuild(ctx context.Context, j *journal.Journal) error {
+	return rebuild(ctx, j, "device reports", d.ObserveEntry)
+}
+
+// ObserveEntry records the report journaled as e, such as one replicated
+// from another sink. Entries whose key isn't a device key are ignored.
+func (d *Devices) ObserveEntry(e *journal.Entry) error {
+	if _, _, err := DecodeDeviceKey(e.Key); err != nil {
+		return nil
+	}
+	info, err := DecodeDeviceValue(e.Value)
+	if err != nil {
+		return err
+	}
+	d.Observe(info)
+	return nil
+}
+
+// Report returns every device's status, by name.
+func (d *Devices) Report() []DeviceStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make([]DeviceStatus, 0, len(d.devices))
+	for _, st := range d.devices {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Device < out[j].Device })
+	return out
+}
+
+// latest returns a copy of the device's latest report.
+func (d *Devices) latest(device string) entity.DeviceInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if st, ok := d.devices[device]; ok {
+		return st.DeviceInfo
+	}
+	return entity.DeviceInfo{}
+}
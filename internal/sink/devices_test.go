@@ -0,0 +1,73 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestDevices(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+
+	battery := func(v float64) *float64 { return &v }
+	rssi := -67
+
+	d := NewDevices(j, BinaryKeys)
+	d.now = func() time.Time { return time.UnixMilli(5000) }
+	seq, err := d.Register(entity.DeviceInfo{Device: "gw-1", Firmware: "1.2.0", Battery: battery(80), RSSI: &rssi, UnixTimestamp: 2000})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+	_, err = d.Register(entity.DeviceInfo{Device: "gw-1", Firmware: "1.1.0", UnixTimestamp: 1000})
+	require.NoError(t, err)
+	_, err = d.Register(entity.DeviceInfo{Device: "a-0"})
+	require.NoError(t, err)
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := d.Register(entity.DeviceInfo{})
+		assert.ErrorIs(t, err, ErrInvalidDeviceInfo)
+		_, err = d.Register(entity.DeviceInfo{Device: "gw-2", Battery: battery(101)})
+		assert.ErrorIs(t, err, ErrInvalidDeviceInfo)
+	})
+
+	want := []DeviceStatus{
+		{DeviceInfo: entity.DeviceInfo{Device: "a-0", UnixTimestamp: 5000}, Reports: 1},
+		{DeviceInfo: entity.DeviceInfo{Device: "gw-1", Firmware: "1.2.0", Battery: battery(80), RSSI: &rssi, UnixTimestamp: 2000}, Reports: 2},
+	}
+	assert.Equal(t, want, d.Report(), "an older report doesn't replace a newer one")
+
+	t.Run("rebuild", func(t *testing.T) {
+		ev := entity.Event{Sensor: "temp", Value: 1, UnixTimestamp: 1}
+		value, err := EncodeValue(nil, &ev)
+		require.NoError(t, err)
+		_, err = j.Write(BinaryKeys.Encode("temp", 1), value)
+		require.NoError(t, err)
+		require.NoError(t, j.Sync())
+
+		rebuilt := NewDevices(j, BinaryKeys)
+		require.NoError(t, rebuilt.Rebuild(context.Background(), j))
+		assert.Equal(t, want, rebuilt.Report())
+	})
+}
+
+func TestDeviceValue(t *testing.T) {
+	battery := 12.5
+	info := entity.DeviceInfo{Device: "gw-1", Firmware: "2.0.1", Battery: &battery, UnixTimestamp: 7}
+	v, err := EncodeDeviceValue(nil, &info)
+	require.NoError(t, err)
+	got, err := DecodeDeviceValue(v)
+	require.NoError(t, err)
+	assert.Equal(t, info, got)
+
+	_, err = DecodeDeviceValue(nil)
+	assert.ErrorIs(t, err, ErrBadValue)
+	_, err = DecodeDeviceValue([]byte{9})
+	assert.ErrorIs(t, err, ErrValueVersion)
+}
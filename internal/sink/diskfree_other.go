@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package sink
+
+import "errors"
+
+// diskFree is only implemented on linux and darwin; a DiskGuard elsewhere
+// goes by the journal's size alone.
+func diskFree(string) (uint64, error) {
+	return 0, errors.New("free disk space not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package sink
+
+import "syscall"
+
+// diskFree is the bytes an unprivileged process can still write to the
+// filesystem holding dir.
+func diskFree(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+var ErrInvalidDiskGuard = errors.New("invalid disk guard limits")
+
+// DiskGuard turns events away with apperr.ErrInsufficientStorage while the
+// journal is past its limits, so clients hear about a filling disk and
+// keep their spools, instead of the sink failing flushes once the
+// filesystem is already full. Every check past a limit first runs the
+// relief hooks, e.g. compacting expired entries away, and only rejects if
+// that didn't bring usage back under.
+type DiskGuard struct {
+	maxBytes uint64 // journal size, 0 = no limit
+	minFree  uint64 // free space on its filesystem, 0 = no limit
+	shed     shedder
+	size     func() int64
+	free     func() (uint64, error)
+}
+
+// NewDiskGuard watches the journal whose segments take size() bytes in
+// dir against maxBytes, and the filesystem holding dir against keeping
+// minFree bytes free. At least one of the two must be set.
+func NewDiskGuard(size func() int64, dir string, maxBytes, minFree uint64, hooks ...func()) (*DiskGuard, error) {
+	if size == nil || (maxBytes == 0 && minFree == 0) {
+		return nil, ErrInvalidDiskGuard
+	}
+	return &DiskGuard{
+		maxBytes: maxBytes,
+		minFree:  minFree,
+		shed:     shedder{hooks: hooks, err: apperr.ErrInsufficientStorage, gauge: diskRejecting, rejected: diskRejected},
+		size:     size,
+		free:     func() (uint64, error) { return diskFree(dir) },
+	}, nil
+}
+
+// Run checks the disk every interval until ctx is done.
+func (g *DiskGuard) Run(ctx context.Context, interval time.Duration) error {
+	return g.shed.run(ctx, interval, g.Check)
+}
+
+// Check reads the journal size and free space and acts on them.
+func (g *DiskGuard) Check() {
+	used, free, over := g.read()
+	if !over {
+		g.setRejecting(false, used, free)
+		return
+	}
+
+	diskPressure.Inc()
+	slog.Warn("journal disk over limit, compacting", "journal_bytes", used, "free_bytes", free)
+	g.shed.relieve()
+
+	used, free, over = g.read()
+	g.setRejecting(over, used, free)
+}
+
+// read returns the journal size, the free space (0 if it isn't limited or
+// can't be read) and whether either is past its limit.
+func (g *DiskGuard) read() (used, free uint64, over bool) {
+	used = uint64(max(g.size(), 0))
+	diskJournalBytes.Set(float64(used))
+	over = g.maxBytes > 0 && used >= g.maxBytes
+	if g.minFree == 0 {
+		return used, 0, over
+	}
+	free, err := g.free()
+	if err != nil {
+		slog.Warn("reading free disk space failed", "error", err)
+		return used, 0, over
+	}
+	diskFreeBytes.Set(float64(free))
+	return used, free, over || free < g.minFree
+}
+
+func (g *DiskGuard) setRejecting(on bool, used, free uint64) {
+	switch {
+	case !g.shed.setRejecting(on):
+	case on:
+		slog.Error("journal disk over limit, rejecting events",
+			"journal_bytes", used,
+			"max_bytes", g.maxBytes,
+			"free_bytes", free,
+			"min_free_bytes", g.minFree,
+		)
+	default:
+		slog.Info("journal disk back under limits, accepting events", "journal_bytes", used, "free_bytes", free)
+	}
+}
+
+// Middleware rejects events while the disk is over its limits.
+func (g *DiskGuard) Middleware() Middleware {
+	return g.shed.middleware()
+}
@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+func TestDiskGuard(t *testing.T) {
+	var (
+		used      int64
+		reclaimed int64 // what the hooks give back
+		calls     int
+	)
+	g, err := NewDiskGuard(func() int64 { return used }, t.TempDir(), 1000, 0, func() {
+		calls++
+		used -= reclaimed
+	})
+	require.NoError(t, err)
+
+	h := g.Middleware()(func(entity.Event) error { return nil })
+
+	used = 500
+	g.Check()
+	assert.Zero(t, calls)
+	assert.NoError(t, h(event("temp", 1, 1)))
+
+	t.Run("compaction that frees enough keeps accepting", func(t *testing.T) {
+		used, reclaimed = 1200, 400
+		g.Check()
+		assert.Equal(t, 1, calls)
+		assert.NoError(t, h(event("temp", 1, 1)))
+	})
+
+	t.Run("rejects until back under", func(t *testing.T) {
+		used, reclaimed = 1500, 0
+		g.Check()
+		assert.ErrorIs(t, h(event("temp", 1, 1)), apperr.ErrInsufficientStorage)
+
+		used = 900
+		g.Check()
+		assert.NoError(t, h(event("temp", 1, 1)))
+	})
+
+	t.Run("invalid limits", func(t *testing.T) {
+		_, err := NewDiskGuard(func() int64 { return 0 }, t.TempDir(), 0, 0)
+		assert.ErrorIs(t, err, ErrInvalidDiskGuard)
+		_, err = NewDiskGuard(nil, t.TempDir(), 1000, 0)
+		assert.ErrorIs(t, err, ErrInvalidDiskGuard)
+	})
+}
+
+func TestDiskGuardFreeSpace(t *testing.T) {
+	var (
+		free    uint64
+		freeErr error
+	)
+	g, err := NewDiskGuard(func() int64 { return 100 }, t.TempDir(), 0, 1000)
+	require.NoError(t, err)
+	g.free = func() (uint64, error) { return free, freeErr }
+
+	h := g.Middleware()(func(entity.Event) error { return nil })
+
+	free = 5000
+	g.Check()
+	assert.NoError(t, h(event("temp", 1, 1)))
+
+	free = 800
+	g.Check()
+	assert.ErrorIs(t, h(event("temp", 1, 1)), apperr.ErrInsufficientStorage)
+
+	// an unreadable filesystem isn't taken as a full one
+	freeErr = errors.New("statfs failed")
+	g.Check()
+	assert.NoError(t, h(event("temp", 1, 1)))
+}
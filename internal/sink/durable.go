@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// ErrNoDurability means AppendDurable can't tell when an event is on disk:
+// there is no WithSyncInterval, or the journal doesn't track a
+// DurableSeq, as with routes, whose journals number events apart.
+var ErrNoDurability = errors.New("journal durability not tracked")
+
+// durableJournal is a Journal that reports the sequence number it has
+// fsynced up to; *journal.Journal and *journal.MultiWriter are.
+type durableJournal interface {
+	syncer
+	DurableSeq() uint64
+}
+
+// WithSyncInterval has Run fsync the journal every d, apart from the
+// flushes, when something was written since the last fsync. Flushes then
+// only wait for the write, and AppendDurable for the next fsync. 0, the
+// default, leaves fsyncs to rotation, atomic batches and Sync.
+func WithSyncInterval(d time.Duration) Option {
+	return func(s *Sink) {
+		s.syncInterval = d
+	}
+}
+
+// durability is the sink's side of the background fsync: the last
+// sequence number written, and a channel closed after each fsync for
+// AppendDurable to wait on.
+type durability struct {
+	syncInterval time.Duration
+	lastWritten  atomic.Uint64
+
+	syncedMu sync.Mutex
+	synced   chan struct{}
+}
+
+func (s *Sink) durable() (durableJournal, bool) {
+	d, ok := s.journal.(durableJournal)
+	return d, ok && s.syncInterval > 0
+}
+
+// AppendDurable is AppendSeq that also waits for the background fsync to
+// cover ev, so that success means it survives a power cut. If ctx ends
+// first, it returns the sequence number with ctx's error once the event
+// is written but not yet synced, and the fsync still follows.
+func (s *Sink) AppendDurable(ctx context.Context, ev entity.Event) (uint64, error) {
+	d, ok := s.durable()
+	if !ok {
+		return 0, ErrNoDurability
+	}
+	seq, err := s.AppendSeq(ctx, ev)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		ch := s.syncedSignal()
+		if d.DurableSeq() >= seq {
+			return seq, nil
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return seq, ctx.Err()
+		}
+	}
+}
+
+func (s *Sink) syncedSignal() <-chan struct{} {
+	s.syncedMu.Lock()
+	defer s.syncedMu.Unlock()
+	if s.synced == nil {
+		s.synced = make(chan struct{})
+	}
+	return s.synced
+}
+
+// wakeDurable wakes AppendDurable calls to check DurableSeq again.
+func (s *Sink) wakeDurable() {
+	s.syncedMu.Lock()
+	defer s.syncedMu.Unlock()
+	if s.synced != nil {
+		close(s.synced)
+		s.synced = nil
+	}
+}
+
+// noteWritten records the last sequence number of a flushed batch.
+func (s *Sink) noteWritten(seqs []uint64) {
+	if len(seqs) == 0 {
+		return
+	}
+	last := seqs[len(seqs)-1]
+	for {
+		cur := s.lastWritten.Load()
+		if last <= cur || s.lastWritten.CompareAndSwap(cur, last) {
+			return
+		}
+	}
+}
+
+// syncLoop fsyncs the journal every sync interval until ctx is done.
+func (s *Sink) syncLoop(ctx context.Context, d durableJournal) {
+	t := time.NewTicker(s.syncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.syncDurable(d)
+		}
+	}
+}
+
+// syncDurable fsyncs the journal if it has entries past its DurableSeq,
+// then wakes the AppendDurable calls waiting; some may have been covered
+// by an fsync of rotation or Sync in between.
+func (s *Sink) syncDurable(d durableJournal) {
+	defer s.wakeDurable()
+	if s.lastWritten.Load() <= d.DurableSeq() {
+		return
+	}
+	start := time.Now()
+	if err := d.Sync(); err != nil {
+		syncErrors.Inc()
+		slog.Warn("background journal fsync failed", "error", err)
+		return
+	}
+	syncDuration.UpdateDuration(start)
+	durableSeq.Set(float64(d.DurableSeq()))
+}
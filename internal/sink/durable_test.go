@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestAppendDurable(t *testing.T) {
+	run := func(t *testing.T, opts ...Option) (*Sink, *journal.Journal) {
+		t.Helper()
+		j, err := journal.New(journal.NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = j.Close() })
+		s := New(j, append([]Option{WithFlushInterval(5 * time.Millisecond)}, opts...)...)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = s.Run(ctx)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		return s, j
+	}
+	t.Run("needs a sync interval", func(t *testing.T) {
+		s, _ := run(t)
+		ev := event("temp", 1, 1)
+		ev.IdempotencyID = "a"
+		_, err := s.AppendDurable(context.Background(), ev)
+		assert.ErrorIs(t, err, ErrNoDurability)
+	})
+
+	t.Run("returns once fsynced", func(t *testing.T) {
+		s, j := run(t, WithSyncInterval(20*time.Millisecond))
+		for i, id := range []string{"a", "b", "c"} {
+			ev := event("temp", 1, int64(i+1))
+			ev.IdempotencyID = id
+			seq, err := s.AppendDurable(context.Background(), ev)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(i+1), seq)
+			assert.GreaterOrEqual(t, j.DurableSeq(), seq)
+		}
+	})
+
+	t.Run("written but not yet fsynced", func(t *testing.T) {
+		s, j := run(t, WithSyncInterval(time.Hour))
+		ev := event("temp", 1, 1)
+		ev.IdempotencyID = "a"
+		// the first entry is fsynced as its segment is committed
+		_, err := s.AppendDurable(context.Background(), ev)
+		require.NoError(t, err)
+
+		ev = event("temp", 1, 2)
+		ev.IdempotencyID = "b"
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		seq, err := s.AppendDurable(ctx, ev)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, uint64(2), seq)
+		assert.Equal(t, uint64(1), j.DurableSeq())
+
+		// a Sync from elsewhere covers it
+		require.NoError(t, s.Sync())
+		assert.Equal(t, uint64(2), j.DurableSeq())
+	})
+}
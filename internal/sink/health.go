@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Sensor health states, from the sensor's own events and the DeviceInfo
+// reports of the device it's on.
+const (
+	HealthOK = "ok"
+	// HealthBroken is a silent sensor whose device still reports: the
+	// device is up, so the sensor or its wiring has failed.
+	HealthBroken = "broken"
+	// HealthBattery is a silent sensor whose device is silent too and
+	// last reported its battery at or below the low mark.
+	HealthBattery = "battery"
+	// HealthOffline is a silent sensor whose device is silent too, with
+	// its battery fine or unknown: power or link trouble.
+	HealthOffline = "offline"
+	// HealthUnknown is a silent sensor no device has claimed.
+	HealthUnknown = "unknown"
+)
+
+var healthStates = []string{HealthOK, HealthBroken, HealthBattery, HealthOffline, HealthUnknown}
+
+// HealthPolicy sets when sensors and devices count as silent.
+type HealthPolicy struct {
+	// SilentAfter is how long a sensor may go without an event.
+	SilentAfter time.Duration
+	// HeartbeatTimeout is how long a device may go without a report.
+	HeartbeatTimeout time.Duration
+	// LowBattery is the battery percent at or below which a silent
+	// device is taken to be flat.
+	LowBattery float64
+}
+
+// Health tells apart sensors gone silent with their device, which need a
+// battery or a trip to restore power or link, from sensors gone silent on
+// a device that still reports, which need a replacement. A sensor is on
+// the device whose latest report lists it, or else on the device of the
+// same name. It's a StatsReporter, adding each sensor's state and device
+// to the Stats report.
+type Health struct {
+	stats   *Stats
+	devices *Devices
+	policy  HealthPolicy
+	now     func() time.Time
+
+	mu    sync.Mutex
+	state map[string]string // as of the last Check, for the metrics
+}
+
+func NewHealth(stats *Stats, devices *Devices, policy HealthPolicy) *Health {
+	return &Health{
+		stats:   stats,
+		devices: devices,
+		policy:  policy,
+		now:     time.Now,
+		state:   make(map[string]string),
+	}
+}
+
+// Report is the Stats report with every sensor's health.
+func (h *Health) Report() StatsReport {
+	r := h.stats.Report()
+	onDevice := h.sensorDevices()
+	now := h.now()
+	for i := range r.Sensors {
+		s := &r.Sensors[i]
+		dev, ok := onDevice[s.Sensor]
+		if ok {
+			s.Device = dev.Device
+		}
+		s.Health = h.classify(now, s.LastSeen, dev, ok)
+	}
+	return r
+}
+
+// Run checks every interval until ctx is done.
+func (h *Health) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			h.Check()
+		}
+	}
+}
+
+// Check classifies every sensor for the sensor_health gauges.
+func (h *Health) Check() {
+	r := h.Report()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range r.Sensors {
+		if _, ok := h.state[s.Sensor]; !ok {
+			registerHealthMetrics(h, s.Sensor)
+		}
+		h.state[s.Sensor] = s.Health
+	}
+}
+
+func (h *Health) classify(now time.Time, lastSeen int64, dev DeviceStatus, known bool) string {
+	switch {
+	case now.Sub(time.UnixMilli(lastSeen)) <= h.policy.SilentAfter:
+		return HealthOK
+	case !known:
+		return HealthUnknown
+	case now.Sub(time.UnixMilli(dev.UnixTimestamp)) <= h.policy.HeartbeatTimeout:
+		return HealthBroken
+	case dev.Battery != nil && *dev.Battery <= h.policy.LowBattery:
+		return HealthBattery
+	default:
+		return HealthOffline
+	}
+}
+
+// sensorDevices maps sensors to the latest report of the device they're
+// on.
+func (h *Health) sensorDevices() map[string]DeviceStatus {
+	report := h.devices.Report()
+	out := make(map[string]DeviceStatus, len(report))
+	for _, dev := range report {
+		if _, ok := out[dev.Device]; !ok {
+			out[dev.Device] = dev
+		}
+		for _, sensor := range dev.Sensors {
+			out[sensor] = dev
+		}
+	}
+	return out
+}
+
+func (h *Health) is(sensor, state string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state[sensor] == state
+}
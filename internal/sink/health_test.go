@@ -0,0 +1,66 @@
+package sink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestHealth(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+
+	now := time.UnixMilli(1_700_000_000_000)
+	ago := func(d time.Duration) int64 { return now.Add(-d).UnixMilli() }
+	battery := func(v float64) *float64 { return &v }
+
+	st := NewStats(time.Hour)
+	st.now = func() time.Time { return now }
+	for sensor, seen := range map[string]int64{
+		"temp-1":   ago(time.Minute),
+		"temp-2":   ago(time.Hour),
+		"hum-1":    ago(time.Hour),
+		"door-1":   ago(time.Hour),
+		"orphan":   ago(time.Hour),
+		"meter-07": ago(time.Hour),
+	} {
+		st.Observe(entity.Event{Sensor: sensor, UnixTimestamp: seen})
+	}
+
+	d := NewDevices(j, TextKeys)
+	for _, info := range []entity.DeviceInfo{
+		{Device: "gw-up", Sensors: []string{"temp-1", "temp-2"}, Battery: battery(5), UnixTimestamp: ago(time.Minute)},
+		{Device: "gw-flat", Sensors: []string{"hum-1"}, Battery: battery(4), UnixTimestamp: ago(time.Hour)},
+		{Device: "gw-gone", Sensors: []string{"door-1"}, Battery: battery(90), UnixTimestamp: ago(time.Hour)},
+		{Device: "meter-07", UnixTimestamp: ago(time.Hour)},
+	} {
+		_, err := d.Register(info)
+		require.NoError(t, err)
+	}
+
+	h := NewHealth(st, d, HealthPolicy{SilentAfter: 10 * time.Minute, HeartbeatTimeout: 5 * time.Minute, LowBattery: 10})
+	h.now = func() time.Time { return now }
+
+	got := make(map[string][2]string)
+	for _, s := range h.Report().Sensors {
+		got[s.Sensor] = [2]string{s.Health, s.Device}
+	}
+	assert.Equal(t, map[string][2]string{
+		"temp-1":   {HealthOK, "gw-up"},
+		"temp-2":   {HealthBroken, "gw-up"},
+		"hum-1":    {HealthBattery, "gw-flat"},
+		"door-1":   {HealthOffline, "gw-gone"},
+		"orphan":   {HealthUnknown, ""},
+		"meter-07": {HealthOffline, "meter-07"},
+	}, got)
+
+	h.Check()
+	assert.True(t, h.is("temp-2", HealthBroken))
+	assert.False(t, h.is("temp-2", HealthOK))
+}
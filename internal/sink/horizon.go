@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+// Horizon turns away events timestamped further back than the retention
+// window, so a device flushing a months-old backlog doesn't fill the
+// journal with data compaction is about to delete. In tag mode stale events
+// are let through and only counted, to size the problem before enforcing.
+type Horizon struct {
+	maxAge  time.Duration
+	tagOnly bool
+	now     func() time.Time
+}
+
+func NewHorizon(maxAge time.Duration, tagOnly bool) *Horizon {
+	return &Horizon{
+		maxAge:  maxAge,
+		tagOnly: tagOnly,
+		now:     time.Now,
+	}
+}
+
+func (h *Horizon) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			if h.maxAge > 0 && h.stale(ev) {
+				if !h.tagOnly {
+					horizonRejected.Inc()
+					return apperr.ErrTooOld
+				}
+				horizonTagged.Inc()
+			}
+			return next(ev)
+		}
+	}
+}
+
+// stale reports whether ev, timestamped in Unix milliseconds, is older
+// than the horizon.
+func (h *Horizon) stale(ev entity.Event) bool {
+	return ev.UnixTimestamp < h.now().Add(-h.maxAge).UnixMilli()
+}
@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+func TestHorizon(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	fresh := entity.Event{Sensor: "temp", UnixTimestamp: now.Add(-time.Hour).UnixMilli()}
+	stale := entity.Event{Sensor: "temp", UnixTimestamp: now.Add(-49 * time.Hour).UnixMilli()}
+
+	run := func(h *Horizon, ev entity.Event) (error, bool) {
+		h.now = func() time.Time { return now }
+		passed := false
+		err := h.Middleware()(func(entity.Event) error {
+			passed = true
+			return nil
+		})(ev)
+		return err, passed
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		h := NewHorizon(48*time.Hour, false)
+
+		err, passed := run(h, fresh)
+		require.NoError(t, err)
+		assert.True(t, passed)
+
+		err, passed = run(h, stale)
+		assert.ErrorIs(t, err, apperr.ErrTooOld)
+		assert.False(t, passed)
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		before := horizonTagged.Get()
+		err, passed := run(NewHorizon(48*time.Hour, true), stale)
+		require.NoError(t, err)
+		assert.True(t, passed)
+		assert.Equal(t, before+1, horizonTagged.Get())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		err, passed := run(NewHorizon(0, false), entity.Event{Sensor: "temp"})
+		require.NoError(t, err)
+		assert.True(t, passed)
+	})
+}
@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// BufferStage is the stage name stage metrics report the buffer append
+// under, after the last middleware.
+const BufferStage = "buffer"
+
+// PipelineOption changes how BuildPipeline builds stages.
+type PipelineOption func(*pipelineConfig)
+
+type pipelineConfig struct {
+	metrics  bool
+	pipeline string
+}
+
+// WithStageMetrics times every stage of the pipeline and counts the events
+// each fails, labelled with pipeline (e.g. "live" or "backfill") and the
+// stage name. The buffer append after the last stage is timed as
+// BufferStage.
+func WithStageMetrics(pipeline string) PipelineOption {
+	return func(c *pipelineConfig) {
+		c.metrics = true
+		c.pipeline = pipeline
+	}
+}
+
+// Instrument wraps the stage mw of pipeline the way WithStageMetrics does,
+// for middlewares set up outside BuildPipeline.
+//
+// A stage's latency is the time from entering it until it returns, so it
+// includes the stages after it; its own share is the difference to the
+// next stage's. Its errors are only the events it failed itself, not the
+// failures of later stages it passed on.
+func Instrument(pipeline, stage string, mw Middleware) Middleware {
+	latency := stageLatency(pipeline, stage)
+	errs := stageErrors(pipeline, stage)
+	return func(next Handler) Handler {
+		h := mw(func(ev entity.Event) error {
+			if err := next(ev); err != nil {
+				return downstreamError{err}
+			}
+			return nil
+		})
+		return func(ev entity.Event) error {
+			start := time.Now()
+			err := h(ev)
+			latency.UpdateDuration(start)
+			if de, ok := err.(downstreamError); ok {
+				return de.err
+			}
+			if err != nil {
+				errs.Inc()
+			}
+			return err
+		}
+	}
+}
+
+// bufferStage times the handler after the last middleware: the buffer
+// append, or whatever Sink runs in its place.
+func bufferStage(pipeline string) Middleware {
+	latency := stageLatency(pipeline, BufferStage)
+	errs := stageErrors(pipeline, BufferStage)
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			start := time.Now()
+			err := next(ev)
+			latency.UpdateDuration(start)
+			if err != nil {
+				errs.Inc()
+			}
+			return err
+		}
+	}
+}
+
+// downstreamError marks a failure an instrumented stage got from the
+// stages after it, so it isn't counted against the stage too. It unwraps,
+// so stages that look at the error see the original.
+type downstreamError struct{ err error }
+
+func (e downstreamError) Error() string { return e.err.Error() }
+func (e downstreamError) Unwrap() error { return e.err }
@@ -0,0 +1,144 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var ErrBadKey = errors.New("not an event key")
+
+// KeyCodec lays out the journal keys of events. Either codec's keys can
+// be read back with DecodeKey, so a journal switched from one to the
+// other stays readable.
+type KeyCodec interface {
+	Encode(sensor string, ts int64) []byte
+	// Prefix is what the keys of every event of sensor start with, or of
+	// every event at all for an empty sensor; for ReplayPrefix.
+	Prefix(sensor string) []byte
+	// EncodeDevice and DevicePrefix are Encode and Prefix for the
+	// DeviceInfo reports of devices, whose keys never match an event's.
+	EncodeDevice(device string, ts int64) []byte
+	DevicePrefix(device string) []byte
+}
+
+var (
+	// TextKeys are the original "sensor_<name>{ts=<ts>}" keys.
+	TextKeys KeyCodec = textKeys{}
+	// BinaryKeys are a version byte, the uvarint length of the sensor name,
+	// the name and the big-endian timestamp with its sign bit flipped, so
+	// keys of a sensor sort by time. About 10 bytes shorter than text keys
+	// at millisecond timestamps.
+	BinaryKeys KeyCodec = binaryKeys{}
+)
+
+const (
+	textKeyPrefix       = "sensor_"
+	textDeviceKeyPrefix = "device_"
+	// binaryKeyV1 can't start a text key, or the journal's marker keys
+	binaryKeyV1 = 0x01
+	// binaryDeviceKeyV1 is binaryKeyV1 for device keys
+	binaryDeviceKeyV1 = 0x02
+)
+
+type textKeys struct{}
+
+func (textKeys) Encode(sensor string, ts int64) []byte {
+	return encodeTextKey(textKeyPrefix, sensor, ts)
+}
+
+// Prefix of a named sensor also matches sensors whose name it prefixes,
+// e.g. "temp" matches "temp-2"; text keys don't delimit the name.
+func (textKeys) Prefix(sensor string) []byte {
+	return []byte(textKeyPrefix + sensor)
+}
+
+func (textKeys) EncodeDevice(device string, ts int64) []byte {
+	return encodeTextKey(textDeviceKeyPrefix, device, ts)
+}
+
+func (textKeys) DevicePrefix(device string) []byte {
+	return []byte(textDeviceKeyPrefix + device)
+}
+
+func encodeTextKey(prefix, name string, ts int64) []byte {
+	var b bytes.Buffer
+	b.WriteString(prefix)
+	b.WriteString(name)
+	b.WriteString("{ts=")
+	b.WriteString(strconv.FormatInt(ts, 10))
+	b.WriteString("}")
+	return b.Bytes()
+}
+
+type binaryKeys struct{}
+
+func (binaryKeys) Encode(sensor string, ts int64) []byte {
+	return encodeBinaryKey(binaryKeyV1, sensor, ts)
+}
+
+func (binaryKeys) Prefix(sensor string) []byte {
+	return binaryKeyPrefix(binaryKeyV1, sensor)
+}
+
+func (binaryKeys) EncodeDevice(device string, ts int64) []byte {
+	return encodeBinaryKey(binaryDeviceKeyV1, device, ts)
+}
+
+func (binaryKeys) DevicePrefix(device string) []byte {
+	return binaryKeyPrefix(binaryDeviceKeyV1, device)
+}
+
+func encodeBinaryKey(version byte, name string, ts int64) []byte {
+	b := make([]byte, 0, 1+binary.MaxVarintLen64+len(name)+8)
+	b = append(b, version)
+	b = binary.AppendUvarint(b, uint64(len(name)))
+	b = append(b, name...)
+	return binary.BigEndian.AppendUint64(b, uint64(ts)^1<<63)
+}
+
+func binaryKeyPrefix(version byte, name string) []byte {
+	if name == "" {
+		return []byte{version}
+	}
+	b := binary.AppendUvarint([]byte{version}, uint64(len(name)))
+	return append(b, name...)
+}
+
+// DecodeKey returns the sensor and timestamp of an event key in either
+// layout.
+func DecodeKey(key []byte) (string, int64, error) {
+	return decodeKey(key, binaryKeyV1, textKeyPrefix)
+}
+
+// DecodeDeviceKey returns the device and timestamp of a DeviceInfo key in
+// either layout.
+func DecodeDeviceKey(key []byte) (string, int64, error) {
+	return decodeKey(key, binaryDeviceKeyV1, textDeviceKeyPrefix)
+}
+
+func decodeKey(key []byte, version byte, prefix string) (string, int64, error) {
+	if len(key) > 0 && key[0] == version {
+		n, size := binary.Uvarint(key[1:])
+		rest := key[1+max(size, 0):]
+		if size <= 0 || uint64(len(rest)) != n+8 {
+			return "", 0, ErrBadKey
+		}
+		return string(rest[:n]), int64(binary.BigEndian.Uint64(rest[n:]) ^ 1<<63), nil
+	}
+
+	rest, ok := bytes.CutPrefix(key, []byte(prefix))
+	if !ok {
+		return "", 0, ErrBadKey
+	}
+	i := bytes.LastIndex(rest, []byte("{ts="))
+	if i < 0 || !bytes.HasSuffix(rest, []byte("}")) {
+		return "", 0, ErrBadKey
+	}
+	ts, err := strconv.ParseInt(string(rest[i+4:len(rest)-1]), 10, 64)
+	if err != nil {
+		return "", 0, ErrBadKey
+	}
+	return string(rest[:i]), ts, nil
+}
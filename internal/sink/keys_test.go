@@ -0,0 +1,76 @@
+package sink
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCodecs(t *testing.T) {
+	for name, c := range map[string]KeyCodec{"text": TextKeys, "binary": BinaryKeys} {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range []struct {
+				sensor string
+				ts     int64
+			}{
+				{"temp-01", 1_700_000_000_000},
+				{"weird{ts=1}", 5},
+				{"", 0},
+				{"neg", -1},
+			} {
+				key := c.Encode(tc.sensor, tc.ts)
+				sensor, ts, err := DecodeKey(key)
+				require.NoError(t, err, "%q", key)
+				assert.Equal(t, tc.sensor, sensor)
+				assert.Equal(t, tc.ts, ts)
+				assert.True(t, bytes.HasPrefix(key, c.Prefix(tc.sensor)))
+				assert.True(t, bytes.HasPrefix(key, c.Prefix("")))
+			}
+		})
+	}
+
+	t.Run("binary keys are shorter", func(t *testing.T) {
+		assert.Less(t, len(BinaryKeys.Encode("temp-01", 1_700_000_000_000)), len(TextKeys.Encode("temp-01", 1_700_000_000_000)))
+	})
+
+	t.Run("binary prefixes delimit the sensor", func(t *testing.T) {
+		assert.False(t, bytes.HasPrefix(BinaryKeys.Encode("temp-2", 1), BinaryKeys.Prefix("temp")))
+	})
+
+	t.Run("binary keys sort by time", func(t *testing.T) {
+		keys := [][]byte{BinaryKeys.Encode("a", 10), BinaryKeys.Encode("a", -5), BinaryKeys.Encode("a", 3)}
+		slices.SortFunc(keys, bytes.Compare)
+		var got []int64
+		for _, k := range keys {
+			_, ts, _ := DecodeKey(k)
+			got = append(got, ts)
+		}
+		assert.Equal(t, []int64{-5, 3, 10}, got)
+	})
+
+	t.Run("device keys", func(t *testing.T) {
+		for name, c := range map[string]KeyCodec{"text": TextKeys, "binary": BinaryKeys} {
+			key := c.EncodeDevice("gw-1", 42)
+			device, ts, err := DecodeDeviceKey(key)
+			require.NoError(t, err, name)
+			assert.Equal(t, "gw-1", device)
+			assert.Equal(t, int64(42), ts)
+			assert.True(t, bytes.HasPrefix(key, c.DevicePrefix("gw-1")), name)
+			assert.False(t, bytes.HasPrefix(key, c.Prefix("")), name)
+			_, _, err = DecodeKey(key)
+			assert.ErrorIs(t, err, ErrBadKey, name)
+			_, _, err = DecodeDeviceKey(c.Encode("gw-1", 42))
+			assert.ErrorIs(t, err, ErrBadKey, name)
+		}
+	})
+
+	t.Run("not event keys", func(t *testing.T) {
+		for _, key := range []string{"", "\x00batch", "sensor_x", "sensor_x{ts=y}", "\x01\x05ab"} {
+			_, _, err := DecodeKey([]byte(key))
+			assert.ErrorIs(t, err, ErrBadKey, "%q", key)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidLivenessRule = errors.New("invalid liveness rule")
+
+const (
+	AlertSilent   = "silent"
+	AlertResolved = "resolved"
+)
+
+// LivenessRule requires sensors matching Sensor to report at least once
+// every Every.
+type LivenessRule struct {
+	// Name labels the rule in alerts and metrics; defaults to Sensor.
+	Name string
+	// Sensor is a path.Match pattern on the sensor name. A plain name is
+	// watched from startup even if the sensor has never reported; a
+	// pattern only covers sensors that have.
+	Sensor string
+	Every  time.Duration
+}
+
+// Alert is sent when a sensor goes silent and again when it reports.
+type Alert struct {
+	Rule   string `json:"rule"`
+	Sensor string `json:"sensor"`
+	State  string `json:"state"`
+	Every  string `json:"every"`
+	// LastSeen is the newest event timestamp in Unix milliseconds, 0 if
+	// the sensor has never reported.
+	LastSeen int64 `json:"last_seen"`
+	Time     int64 `json:"time"`
+}
+
+// Alerter delivers liveness alerts.
+type Alerter interface {
+	Alert(ctx context.Context, a Alert) error
+}
+
+type livenessKey struct {
+	rule, sensor string
+}
+
+// Liveness watches the last seen times kept by Stats and alerts when a
+// sensor stops reporting, so a dead sensor is noticed before someone goes
+// looking for its data. Each sensor is checked against every rule it
+// matches.
+type Liveness struct {
+	stats    *Stats
+	rules    []LivenessRule
+	alerters []Alerter
+	started  time.Time
+
+	mu     sync.Mutex
+	silent map[livenessKey]bool
+	now    func() time.Time
+}
+
+func NewLiveness(stats *Stats, rules []LivenessRule, alerters ...Alerter) (*Liveness, error) {
+	l := &Liveness{
+		stats:    stats,
+		rules:    make([]LivenessRule, 0, len(rules)),
+		alerters: alerters,
+		started:  time.Now(),
+		silent:   make(map[livenessKey]bool),
+		now:      time.Now,
+	}
+	for i, r := range rules {
+		if _, err := path.Match(r.Sensor, ""); err != nil || r.Sensor == "" {
+			return nil, fmt.Errorf("%w: rule %d: bad sensor pattern %q", ErrInvalidLivenessRule, i, r.Sensor)
+		}
+		if r.Every <= 0 {
+			return nil, fmt.Errorf("%w: rule %d: every must be positive", ErrInvalidLivenessRule, i)
+		}
+		if r.Name == "" {
+			r.Name = r.Sensor
+		}
+		l.rules = append(l.rules, r)
+	}
+	return l, nil
+}
+
+// Run checks the rules every interval until ctx is done.
+func (l *Liveness) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			l.Check(ctx)
+		}
+	}
+}
+
+// Check compares every watched sensor against its rules and sends alerts
+// for the ones that changed state since the last check.
+func (l *Liveness) Check(ctx context.Context) {
+	now := l.now()
+	seen := l.stats.LastSeenTimes()
+
+	var alerts []Alert
+	l.mu.Lock()
+	for _, r := range l.rules {
+		sensors := make(map[string]int64)
+		if !isPattern(r.Sensor) {
+			sensors[r.Sensor] = seen[r.Sensor]
+		} else {
+			for sensor, ts := range seen {
+				if ok, _ := path.Match(r.Sensor, sensor); ok {
+					sensors[sensor] = ts
+				}
+			}
+		}
+
+		for sensor, ts := range sensors {
+			since := l.started
+			if ts > 0 {
+				since = time.UnixMilli(ts)
+			}
+			silent := now.Sub(since) > r.Every
+
+			key := livenessKey{r.Name, sensor}
+			was, known := l.silent[key]
+			if !known {
+				registerLivenessMetrics(l, key)
+			}
+			l.silent[key] = silent
+			if silent == was {
+				continue
+			}
+
+			a := Alert{Rule: r.Name, Sensor: sensor, State: AlertResolved, Every: r.Every.String(), LastSeen: ts, Time: now.UnixMilli()}
+			if silent {
+				a.State = AlertSilent
+			}
+			alerts = append(alerts, a)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, a := range alerts {
+		livenessAlerts(a.State).Inc()
+		for _, al := range l.alerters {
+			if err := al.Alert(ctx, a); err != nil {
+				livenessAlertErrors.Inc()
+				slog.Warn("failed to send liveness alert", "rule", a.Rule, "sensor", a.Sensor, "error", err)
+			}
+		}
+	}
+}
+
+func (l *Liveness) isSilent(key livenessKey) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.silent[key]
+}
+
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
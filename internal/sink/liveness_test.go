@@ -0,0 +1,134 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+type recordingAlerter struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recordingAlerter) Alert(_ context.Context, a Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func (r *recordingAlerter) take() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.alerts
+	r.alerts = nil
+	return out
+}
+
+func TestLiveness(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	setup := func(t *testing.T, rules ...LivenessRule) (*Liveness, *Stats, *recordingAlerter, *time.Time) {
+		t.Helper()
+		st := NewStats(time.Hour)
+		rec := &recordingAlerter{}
+		l, err := NewLiveness(st, rules, rec)
+		require.NoError(t, err)
+		now := start
+		l.started = start
+		l.now = func() time.Time { return now }
+		return l, st, rec, &now
+	}
+
+	t.Run("silent and resolved", func(t *testing.T) {
+		l, st, rec, now := setup(t, LivenessRule{Name: "freezers", Sensor: "freezer-*", Every: 5 * time.Minute})
+		st.Observe(entity.Event{Sensor: "freezer-1", UnixTimestamp: start.UnixMilli()})
+		st.Observe(entity.Event{Sensor: "freezer-2", UnixTimestamp: start.UnixMilli()})
+		st.Observe(entity.Event{Sensor: "door", UnixTimestamp: start.UnixMilli()})
+
+		l.Check(context.Background())
+		assert.Empty(t, rec.take())
+
+		*now = start.Add(6 * time.Minute)
+		st.Observe(entity.Event{Sensor: "freezer-2", UnixTimestamp: now.UnixMilli()})
+		l.Check(context.Background())
+		assert.Equal(t, []Alert{{
+			Rule:     "freezers",
+			Sensor:   "freezer-1",
+			State:    AlertSilent,
+			Every:    "5m0s",
+			LastSeen: start.UnixMilli(),
+			Time:     now.UnixMilli(),
+		}}, rec.take())
+		assert.True(t, l.isSilent(livenessKey{"freezers", "freezer-1"}))
+
+		// no repeat while it stays silent
+		*now = start.Add(7 * time.Minute)
+		l.Check(context.Background())
+		assert.Empty(t, rec.take())
+
+		st.Observe(entity.Event{Sensor: "freezer-1", UnixTimestamp: now.UnixMilli()})
+		l.Check(context.Background())
+		alerts := rec.take()
+		require.Len(t, alerts, 1)
+		assert.Equal(t, AlertResolved, alerts[0].State)
+		assert.Equal(t, "freezer-1", alerts[0].Sensor)
+	})
+
+	t.Run("named sensor that never reported", func(t *testing.T) {
+		l, _, rec, now := setup(t, LivenessRule{Sensor: "pump", Every: time.Minute})
+
+		l.Check(context.Background())
+		assert.Empty(t, rec.take())
+
+		*now = start.Add(2 * time.Minute)
+		l.Check(context.Background())
+		assert.Equal(t, []Alert{{
+			Rule:   "pump",
+			Sensor: "pump",
+			State:  AlertSilent,
+			Every:  "1m0s",
+			Time:   now.UnixMilli(),
+		}}, rec.take())
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		_, err := NewLiveness(NewStats(time.Hour), []LivenessRule{{Sensor: "[", Every: time.Minute}})
+		assert.ErrorIs(t, err, ErrInvalidLivenessRule)
+		_, err = NewLiveness(NewStats(time.Hour), []LivenessRule{{Sensor: "temp"}})
+		assert.ErrorIs(t, err, ErrInvalidLivenessRule)
+	})
+}
+
+func TestWebhookAlerter(t *testing.T) {
+	var got Alert
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if got.Sensor == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	w := WebhookAlerter{URL: srv.URL, Headers: http.Header{"Authorization": {"Bearer t"}}}
+	a := Alert{Rule: "r", Sensor: "temp", State: AlertSilent, Every: "1m0s"}
+	require.NoError(t, w.Alert(context.Background(), a))
+	assert.Equal(t, a, got)
+	assert.Equal(t, "Bearer t", auth)
+
+	assert.Error(t, w.Alert(context.Background(), Alert{Sensor: "fail"}))
+}
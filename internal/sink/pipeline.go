@@ -0,0 +1,110 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownStage   = errors.New("unknown pipeline stage")
+	ErrDuplicateStage = errors.New("duplicate pipeline stage")
+	ErrStageNotListed = errors.New("enabled stage missing from pipeline")
+)
+
+// DefaultPipeline is the stage order used when none is configured:
+// normalize sensors first and check their names once transforms have
+// renamed them, drop stale events before they use up dedup entries or
+// limits, sample after dedup so retransmits don't count towards
+// 1-in-N, and count only what every other stage let through. Validation
+// happens as events are decoded and routing as batches are written, so
+// neither is a stage; nothing aggregates events.
+var DefaultPipeline = []string{"transform", "sensorname", "horizon", "dedup", "sample", "ratelimit", "quota", "stats"}
+
+// StageFactory builds a custom stage from its options under sink.stages in
+// the config, nil when there are none.
+type StageFactory func(opts map[string]any) (Middleware, error)
+
+var (
+	stagesMu sync.RWMutex
+	stages   = make(map[string]StageFactory)
+)
+
+// RegisterStage makes a custom stage available to pipelines under name.
+// Call it from an init function; it panics if name is taken, as a second
+// registration is always a programming error.
+func RegisterStage(name string, f StageFactory) {
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+	if _, ok := stages[name]; ok {
+		panic(fmt.Sprintf("sink: stage %q registered twice", name))
+	}
+	stages[name] = f
+}
+
+// Stages returns the names of the registered custom stages, sorted.
+func Stages() []string {
+	stagesMu.RLock()
+	defer stagesMu.RUnlock()
+	names := make([]string, 0, len(stages))
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuildPipeline returns the middlewares for the stages in order, ready for
+// WithMiddleware. builtin holds the stages set up from their own config
+// sections, with a nil Middleware for those that are disabled; listing a
+// disabled one is fine, but an enabled one left out of order is an error
+// rather than silently dropped. Any other name must be a registered stage,
+// built with its entry from opts. popts can add metrics around the stages.
+func BuildPipeline(order []string, builtin map[string]Middleware, opts map[string]map[string]any, popts ...PipelineOption) ([]Middleware, error) {
+	var cfg pipelineConfig
+	for _, opt := range popts {
+		opt(&cfg)
+	}
+	var mws []Middleware
+	add := func(name string, mw Middleware) {
+		if cfg.metrics {
+			mw = Instrument(cfg.pipeline, name, mw)
+		}
+		mws = append(mws, mw)
+	}
+	for i, name := range order {
+		if slices.Contains(order[:i], name) {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateStage, name)
+		}
+		if mw, ok := builtin[name]; ok {
+			if mw != nil {
+				add(name, mw)
+			}
+			continue
+		}
+
+		stagesMu.RLock()
+		f, ok := stages[name]
+		stagesMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownStage, name)
+		}
+		mw, err := f(opts[name])
+		if err != nil {
+			return nil, fmt.Errorf("stage %q: %w", name, err)
+		}
+		add(name, mw)
+	}
+
+	for name, mw := range builtin {
+		if mw != nil && !slices.Contains(order, name) {
+			return nil, fmt.Errorf("%w: %q", ErrStageNotListed, name)
+		}
+	}
+	if cfg.metrics {
+		mws = append(mws, bufferStage(cfg.pipeline))
+	}
+	return mws, nil
+}
@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+func TestBuildPipeline(t *testing.T) {
+	var trace []string
+	stage := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ev entity.Event) error {
+				trace = append(trace, name)
+				return next(ev)
+			}
+		}
+	}
+
+	RegisterStage("test-tag", func(opts map[string]any) (Middleware, error) {
+		if opts["fail"] == true {
+			return nil, errors.New("bad options")
+		}
+		return stage("tag:" + opts["label"].(string)), nil
+	})
+	assert.Contains(t, Stages(), "test-tag")
+	assert.Panics(t, func() { RegisterStage("test-tag", nil) })
+
+	builtin := map[string]Middleware{
+		"dedup":     stage("dedup"),
+		"ratelimit": stage("ratelimit"),
+		"quota":     nil,
+	}
+
+	t.Run("ordered as configured", func(t *testing.T) {
+		trace = nil
+		mws, err := BuildPipeline(
+			[]string{"ratelimit", "test-tag", "quota", "dedup"},
+			builtin,
+			map[string]map[string]any{"test-tag": {"label": "x"}},
+		)
+		require.NoError(t, err)
+
+		h := Handler(func(entity.Event) error { return nil })
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		require.NoError(t, h(entity.Event{Sensor: "a", Value: 1}))
+		assert.Equal(t, []string{"ratelimit", "tag:x", "dedup"}, trace)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := BuildPipeline([]string{"dedup", "ratelimit", "nope"}, builtin, nil)
+		assert.ErrorIs(t, err, ErrUnknownStage)
+
+		_, err = BuildPipeline([]string{"dedup", "ratelimit", "dedup"}, builtin, nil)
+		assert.ErrorIs(t, err, ErrDuplicateStage)
+
+		_, err = BuildPipeline([]string{"dedup"}, builtin, nil)
+		assert.ErrorIs(t, err, ErrStageNotListed)
+
+		_, err = BuildPipeline([]string{"dedup", "ratelimit", "test-tag"}, builtin,
+			map[string]map[string]any{"test-tag": {"fail": true}})
+		assert.ErrorContains(t, err, `stage "test-tag": bad options`)
+	})
+}
+
+func TestStageMetrics(t *testing.T) {
+	errFull, errLimited := errors.New("full"), errors.New("limited")
+	var seen error
+	builtin := map[string]Middleware{
+		// passes on what the later stages returned, as stages do
+		"dedup": func(next Handler) Handler {
+			return func(ev entity.Event) error {
+				seen = next(ev)
+				return seen
+			}
+		},
+		"ratelimit": func(next Handler) Handler {
+			return func(ev entity.Event) error {
+				if ev.Sensor == "noisy" {
+					return errLimited
+				}
+				return next(ev)
+			}
+		},
+	}
+	mws, err := BuildPipeline([]string{"dedup", "ratelimit"}, builtin, nil, WithStageMetrics("stage-test"))
+	require.NoError(t, err)
+	require.Len(t, mws, 3, "buffer stage added")
+
+	h := Handler(func(ev entity.Event) error {
+		if ev.Sensor == "full" {
+			return errFull
+		}
+		return nil
+	})
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	require.NoError(t, h(entity.Event{Sensor: "a"}))
+	assert.Same(t, errLimited, h(entity.Event{Sensor: "noisy"}))
+	assert.Same(t, errFull, h(entity.Event{Sensor: "full"}), "original error returned")
+	assert.ErrorIs(t, seen, errFull, "stages see the original error")
+
+	for stage, want := range map[string]uint64{"dedup": 0, "ratelimit": 1, BufferStage: 1} {
+		assert.Equal(t, want, stageErrors("stage-test", stage).Get(), stage)
+	}
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf, false)
+	for stage, want := range map[string]int{"dedup": 3, "ratelimit": 3, BufferStage: 2} {
+		assert.Contains(t, buf.String(), fmt.Sprintf(`sink_stage_duration_seconds_count{pipeline="stage-test",stage=%q} %d`, stage, want))
+	}
+}
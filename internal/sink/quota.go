@@ -0,0 +1,218 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+const quotaDayLayout = "2006-01-02"
+
+// QuotaUsage is the consumption of a single sensor for the current day.
+type QuotaUsage struct {
+	Sensor string `json:"sensor"`
+	Events int64  `json:"events"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// QuotaReport is what the admin API exposes.
+type QuotaReport struct {
+	Day          string       `json:"day"`
+	EventsPerDay int64        `json:"events_per_day"`
+	BytesPerDay  int64        `json:"bytes_per_day"`
+	Sensors      []QuotaUsage `json:"sensors"`
+}
+
+type quotaState struct {
+	Day     string                 `json:"day"`
+	Sensors map[string]*QuotaUsage `json:"sensors"`
+}
+
+// Quota enforces daily per-sensor limits on event count and payload bytes.
+// Counters reset at UTC midnight and are persisted to a small state file
+// so a restart doesn't hand every sensor a fresh allowance. Events carry
+// no tenant, so there is no per-tenant limit.
+type Quota struct {
+	mu           sync.Mutex
+	eventsPerDay int64
+	bytesPerDay  int64
+	path         string
+	interval     time.Duration
+	state        quotaState
+	dirty        bool
+	now          func() time.Time
+}
+
+func NewQuota(eventsPerDay, bytesPerDay int64, path string, saveInterval time.Duration) *Quota {
+	return &Quota{
+		eventsPerDay: eventsPerDay,
+		bytesPerDay:  bytesPerDay,
+		path:         path,
+		interval:     saveInterval,
+		state:        quotaState{Sensors: make(map[string]*QuotaUsage)},
+		now:          time.Now,
+	}
+}
+
+// Load restores counters from the state file. A missing file or a state
+// from a previous day leaves the counters empty.
+func (q *Quota) Load() error {
+	if q.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st quotaState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if st.Day == q.today() && st.Sensors != nil {
+		q.state = st
+	}
+	return nil
+}
+
+// Save writes the counters to the state file via a temp file and rename.
+// After a failed write the counters stay dirty, so the next Save retries.
+func (q *Quota) Save() (err error) {
+	if q.path == "" {
+		return nil
+	}
+
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(q.state)
+	// counts taken while writing make it dirty again
+	q.dirty = false
+	q.mu.Unlock()
+	defer func() {
+		if err != nil {
+			q.mu.Lock()
+			q.dirty = true
+			q.mu.Unlock()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+func (q *Quota) Start() {
+	if q.interval <= 0 || q.path == "" {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := q.Save(); err != nil {
+				slog.Warn("failed to save quota state", "path", q.path, "error", err)
+			}
+		}
+	}()
+}
+
+func (q *Quota) today() string {
+	return q.now().UTC().Format(quotaDayLayout)
+}
+
+func (q *Quota) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			n := int64(ev.Msgsize())
+			if !q.take(ev.Sensor, n) {
+				quotaRejected.Inc()
+				// allowance comes back at UTC midnight
+				now := q.now().UTC()
+				reset := now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+				return &apperr.LimitError{
+					Err:        apperr.ErrQuotaExceeded,
+					RetryAfter: reset,
+					Reset:      reset,
+				}
+			}
+			return next(ev)
+		}
+	}
+}
+
+func (q *Quota) take(sensor string, n int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if day := q.today(); day != q.state.Day {
+		q.state = quotaState{Day: day, Sensors: make(map[string]*QuotaUsage)}
+		q.dirty = true
+	}
+
+	u, ok := q.state.Sensors[sensor]
+	if !ok {
+		u = &QuotaUsage{Sensor: sensor}
+		q.state.Sensors[sensor] = u
+	}
+
+	if q.eventsPerDay > 0 && u.Events+1 > q.eventsPerDay {
+		return false
+	}
+	if q.bytesPerDay > 0 && u.Bytes+n > q.bytesPerDay {
+		return false
+	}
+
+	u.Events++
+	u.Bytes += n
+	q.dirty = true
+	return true
+}
+
+// Report returns current usage sorted by sensor name.
+func (q *Quota) Report() QuotaReport {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	r := QuotaReport{
+		Day:          q.today(),
+		EventsPerDay: q.eventsPerDay,
+		BytesPerDay:  q.bytesPerDay,
+		Sensors:      []QuotaUsage{},
+	}
+	if q.state.Day != r.Day {
+		return r
+	}
+	for _, u := range q.state.Sensors {
+		r.Sensors = append(r.Sensors, *u)
+	}
+	sort.Slice(r.Sensors, func(i, j int) bool { return r.Sensors[i].Sensor < r.Sensors[j].Sensor })
+	return r
+}
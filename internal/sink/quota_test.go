@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+func TestQuota(t *testing.T) {
+	pass := func(ev entity.Event) error { return nil }
+
+	t.Run("limits events per sensor", func(t *testing.T) {
+		q := NewQuota(2, 0, "", 0)
+		mw := q.Middleware()(pass)
+
+		assert.NoError(t, mw(event("temp", 1, 1000)))
+		assert.NoError(t, mw(event("temp", 2, 2000)))
+		assert.ErrorIs(t, mw(event("temp", 3, 3000)), apperr.ErrQuotaExceeded)
+		assert.NoError(t, mw(event("humidity", 1, 1000)), "other sensors have their own allowance")
+	})
+
+	t.Run("limits bytes per sensor", func(t *testing.T) {
+		ev := event("temp", 1, 1000)
+		q := NewQuota(0, int64(ev.Msgsize())*3, "", 0)
+		mw := q.Middleware()(pass)
+
+		for range 3 {
+			require.NoError(t, mw(ev))
+		}
+		assert.ErrorIs(t, mw(ev), apperr.ErrQuotaExceeded)
+	})
+
+	t.Run("resets on new day", func(t *testing.T) {
+		now := time.Date(2025, 1, 1, 23, 59, 0, 0, time.UTC)
+		q := NewQuota(1, 0, "", 0)
+		q.now = func() time.Time { return now }
+		mw := q.Middleware()(pass)
+
+		require.NoError(t, mw(event("temp", 1, 1000)))
+		assert.ErrorIs(t, mw(event("temp", 2, 2000)), apperr.ErrQuotaExceeded)
+
+		now = now.Add(2 * time.Minute)
+		assert.NoError(t, mw(event("temp", 3, 3000)))
+	})
+
+	t.Run("report", func(t *testing.T) {
+		q := NewQuota(10, 0, "", 0)
+		mw := q.Middleware()(pass)
+		mw(event("b", 1, 1000))
+		mw(event("a", 1, 1000))
+		mw(event("a", 2, 2000))
+
+		r := q.Report()
+		assert.Equal(t, int64(10), r.EventsPerDay)
+		require.Len(t, r.Sensors, 2)
+		assert.Equal(t, "a", r.Sensors[0].Sensor)
+		assert.Equal(t, int64(2), r.Sensors[0].Events)
+		assert.Equal(t, "b", r.Sensors[1].Sensor)
+	})
+}
+
+func TestQuotaPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	pass := func(ev entity.Event) error { return nil }
+
+	q := NewQuota(2, 0, path, 0)
+	mw := q.Middleware()(pass)
+	require.NoError(t, mw(event("temp", 1, 1000)))
+	require.NoError(t, mw(event("temp", 2, 2000)))
+	require.NoError(t, q.Save())
+
+	restored := NewQuota(2, 0, path, 0)
+	require.NoError(t, restored.Load())
+	assert.ErrorIs(t, restored.Middleware()(pass)(event("temp", 3, 3000)), apperr.ErrQuotaExceeded)
+
+	t.Run("stale day is ignored", func(t *testing.T) {
+		fresh := NewQuota(2, 0, path, 0)
+		fresh.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+		require.NoError(t, fresh.Load())
+		assert.NoError(t, fresh.Middleware()(pass)(event("temp", 3, 3000)))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		q := NewQuota(1, 0, filepath.Join(t.TempDir(), "nope.json"), 0)
+		assert.NoError(t, q.Load())
+	})
+
+	t.Run("failed save is retried", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "state")
+		require.NoError(t, os.WriteFile(dir, nil, 0644)) // a file where the directory goes
+		path := filepath.Join(dir, "quota.json")
+		q := NewQuota(2, 0, path, 0)
+		require.NoError(t, q.Middleware()(pass)(event("temp", 1, 1000)))
+		require.Error(t, q.Save())
+
+		require.NoError(t, os.Remove(dir))
+		require.NoError(t, q.Save())
+		assert.FileExists(t, path)
+	})
+}
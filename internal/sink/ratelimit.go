@@ -1,6 +1,7 @@
 package sink
 
 import (
+	"fmt"
 	"sync/atomic"
 	"time"
 
@@ -10,23 +11,59 @@ import (
 	"github.com/andriibeee/iotdemo/internal/entity"
 )
 
+// RateLimiter limits ingestion by payload bytes, by event count, or both.
+// An event must fit in every enabled bucket; when one rejects it no tokens
+// are taken from the other.
 type RateLimiter struct {
-	limiter        *rate.Limiter
+	limiter        *rate.Limiter // bytes, nil when disabled
+	events         *rate.Limiter // events, nil when disabled
+	maxWait        time.Duration
 	DroppedCounter atomic.Uint64
 }
 
-func NewRateLimiter(bytesPerSec float64) *RateLimiter {
-	return &RateLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))}
+type RateLimiterOption func(*RateLimiter)
+
+// WithMaxWait makes the limiter wait up to d for tokens instead of
+// rejecting right away, smoothing short bursts. Events that would have to
+// wait longer are still rejected immediately.
+func WithMaxWait(d time.Duration) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.maxWait = d
+	}
+}
+
+// WithEventsPerSec adds an event count limit with a burst of one second's
+// worth of events, so a flood of tiny events can't slip under the byte
+// limit.
+func WithEventsPerSec(eventsPerSec float64) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		if eventsPerSec > 0 {
+			rl.events = rate.NewLimiter(rate.Limit(eventsPerSec), max(int(eventsPerSec), 1))
+		}
+	}
+}
+
+// NewRateLimiter limits payload bytes to bytesPerSec; zero disables the
+// byte limit, leaving only what the options add.
+func NewRateLimiter(bytesPerSec float64, opts ...RateLimiterOption) *RateLimiter {
+	rl := &RateLimiter{}
+	if bytesPerSec > 0 {
+		rl.limiter = rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+	}
+	for _, opt := range opts {
+		opt(rl)
+	}
+	return rl
 }
 
 func (rl *RateLimiter) Middleware() Middleware {
 	return func(next Handler) Handler {
 		return func(ev entity.Event) error {
 			n := ev.Msgsize()
-			if !rl.limiter.AllowN(time.Now(), n) {
+			if err := rl.allow(n); err != nil {
 				rl.DroppedCounter.Add(1)
 				rateLimitDropped.Inc()
-				return apperr.ErrRateLimited
+				return err
 			}
 			rateLimitAllowed.Inc()
 			rateLimitBytes.Add(n)
@@ -34,3 +71,95 @@ func (rl *RateLimiter) Middleware() Middleware {
 		}
 	}
 }
+
+type bucket struct {
+	name    string
+	limiter *rate.Limiter
+	cost    int
+}
+
+func (rl *RateLimiter) buckets(n int) []bucket {
+	var bs []bucket
+	if rl.limiter != nil {
+		bs = append(bs, bucket{name: "bytes", limiter: rl.limiter, cost: n})
+	}
+	if rl.events != nil {
+		bs = append(bs, bucket{name: "events", limiter: rl.events, cost: 1})
+	}
+	return bs
+}
+
+// allow reserves tokens from every bucket and waits for the slowest one if
+// that's within maxWait. Otherwise all reservations are returned and the
+// error describes the bucket that held the event back.
+func (rl *RateLimiter) allow(n int) error {
+	now := time.Now()
+	bs := rl.buckets(n)
+
+	var (
+		reservations []*rate.Reservation
+		delay        time.Duration
+		blocker      *bucket
+	)
+	cancel := func() {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+
+	for _, b := range bs {
+		if burst := b.limiter.Burst(); b.cost > burst {
+			rateLimitRejectedBy(b.name).Inc()
+			return fmt.Errorf("%w: costs %d %s, burst is %d", apperr.ErrEventTooLarge, b.cost, b.name, burst)
+		}
+	}
+
+	for i := range bs {
+		r := bs[i].limiter.ReserveN(now, bs[i].cost)
+		if !r.OK() {
+			cancel()
+			return rl.reject(bs[i])
+		}
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay, blocker = d, &bs[i]
+		}
+	}
+
+	if delay == 0 {
+		return nil
+	}
+	if delay > rl.maxWait {
+		cancel()
+		return rl.reject(*blocker)
+	}
+
+	time.Sleep(delay)
+	rateLimitWaited.Inc()
+	rateLimitWait.Update(delay.Seconds())
+	return nil
+}
+
+func (rl *RateLimiter) reject(b bucket) error {
+	rateLimitRejectedBy(b.name).Inc()
+	return limitError(b.limiter, b.cost)
+}
+
+// limitError describes the bucket state after a rejection: how long until
+// n tokens, at most the burst, are available, what's left now and when the
+// bucket is full again.
+func limitError(l *rate.Limiter, n int) error {
+	limit := float64(l.Limit())
+	burst := float64(l.Burst())
+	tokens := max(l.TokensAt(time.Now()), 0)
+
+	untilFull := time.Duration((burst - tokens) / limit * float64(time.Second))
+	retryAfter := time.Duration((float64(n) - tokens) / limit * float64(time.Second))
+
+	return &apperr.LimitError{
+		Err:        apperr.ErrRateLimited,
+		RetryAfter: max(retryAfter, 0),
+		Remaining:  int64(tokens),
+		Reset:      max(untilFull, 0),
+	}
+}
@@ -1,9 +1,21 @@
 package sink
 
-import "github.com/VictoriaMetrics/metrics"
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/metrics"
+)
 
 var (
 	rateLimitAllowed = metrics.NewCounter("ratelimiter_events_allowed_total")
 	rateLimitDropped = metrics.NewCounter("ratelimiter_events_dropped_total")
 	rateLimitBytes   = metrics.NewCounter("ratelimiter_bytes_total")
+	rateLimitWaited  = metrics.NewCounter("ratelimiter_events_waited_total")
+	rateLimitWait    = metrics.NewSummary("ratelimiter_wait_seconds")
+
+	quotaRejected = metrics.NewCounter("quota_events_rejected_total")
 )
+
+func rateLimitRejectedBy(limit string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`ratelimiter_events_rejected_total{limit=%q}`, limit))
+}
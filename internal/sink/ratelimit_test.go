@@ -1,12 +1,16 @@
 package sink
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/time/rate"
 
+	"github.com/andriibeee/iotdemo/internal/entity"
 	apperr "github.com/andriibeee/iotdemo/internal/errors"
 )
 
@@ -15,12 +19,14 @@ func TestRateLimiterMiddleware(t *testing.T) {
 	j := NewMockJournal(ctrl)
 	j.EXPECT().WriteBatch(gomock.Any()).Return(nil, nil)
 
-	rl := NewRateLimiter(30)
+	// a burst of one event, so the limit is what turns the rest away
+	ev := event("temp", 0, 0)
+	rl := NewRateLimiter(float64(ev.Msgsize()))
 	s := New(j, WithBufSize(10), WithMiddleware(rl.Middleware()))
 
 	gotLimited := false
 	for i := range 20 {
-		if s.Append(event("temp", i, int64(i*1000))) == apperr.ErrRateLimited {
+		if errors.Is(s.Append(event("temp", i, int64(i*1000))), apperr.ErrRateLimited) {
 			gotLimited = true
 		}
 	}
@@ -54,3 +60,108 @@ func TestRateLimiterRefills(t *testing.T) {
 		assert.NoError(t, err, "refilled bucket should accept event")
 	}
 }
+
+func TestRateLimiterWaitMode(t *testing.T) {
+	pass := func(ev entity.Event) error { return nil }
+	ev := event("temp", 1, 1000)
+	n := float64(ev.Msgsize())
+
+	t.Run("smooths short bursts", func(t *testing.T) {
+		// bucket holds one event and refills one event per 20ms
+		rl := NewRateLimiter(n*50, WithMaxWait(100*time.Millisecond))
+		rl.limiter.SetBurst(int(n))
+		mw := rl.Middleware()(pass)
+
+		start := time.Now()
+		for range 3 {
+			assert.NoError(t, mw(ev))
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+		assert.Zero(t, rl.DroppedCounter.Load())
+	})
+
+	t.Run("rejects beyond max wait", func(t *testing.T) {
+		rl := NewRateLimiter(n, WithMaxWait(10*time.Millisecond))
+		rl.limiter.SetBurst(int(n))
+		mw := rl.Middleware()(pass)
+
+		assert.NoError(t, mw(ev))
+
+		start := time.Now()
+		assert.ErrorIs(t, mw(ev), apperr.ErrRateLimited)
+		assert.Less(t, time.Since(start), 10*time.Millisecond, "should not wait when it can't succeed")
+		assert.Equal(t, uint64(1), rl.DroppedCounter.Load())
+	})
+}
+
+func TestRateLimiterLimitError(t *testing.T) {
+	ev := event("temp", 1, 1000)
+	n := ev.Msgsize()
+
+	// one event per second, bucket holds two
+	rl := NewRateLimiter(float64(2 * n))
+	rl.limiter.SetLimit(rate.Limit(n))
+	mw := rl.Middleware()(func(ev entity.Event) error { return nil })
+
+	require.NoError(t, mw(ev))
+	require.NoError(t, mw(ev))
+
+	var le *apperr.LimitError
+	require.ErrorAs(t, mw(ev), &le)
+	assert.ErrorIs(t, le, apperr.ErrRateLimited)
+	assert.Zero(t, le.Remaining)
+	assert.InDelta(t, time.Second, le.RetryAfter, float64(50*time.Millisecond))
+	assert.InDelta(t, 2*time.Second, le.Reset, float64(50*time.Millisecond))
+}
+
+func TestRateLimiterEventTooLarge(t *testing.T) {
+	ev := event("temp", 1, 1000)
+	rl := NewRateLimiter(float64(ev.Msgsize()-1), WithMaxWait(time.Second))
+	mw := rl.Middleware()(func(ev entity.Event) error { return nil })
+
+	err := mw(ev)
+	assert.ErrorIs(t, err, apperr.ErrEventTooLarge)
+	assert.NotErrorIs(t, err, apperr.ErrRateLimited, "waiting wouldn't help")
+	assert.Equal(t, uint64(1), rl.DroppedCounter.Load())
+}
+
+func TestRateLimiterEventsPerSec(t *testing.T) {
+	pass := func(ev entity.Event) error { return nil }
+	ev := event("temp", 1, 1000)
+
+	t.Run("limits tiny events", func(t *testing.T) {
+		rl := NewRateLimiter(1024*1024, WithEventsPerSec(5))
+		mw := rl.Middleware()(pass)
+
+		for range 5 {
+			require.NoError(t, mw(ev))
+		}
+
+		var le *apperr.LimitError
+		require.ErrorAs(t, mw(ev), &le)
+		assert.ErrorIs(t, le, apperr.ErrRateLimited)
+		assert.Zero(t, le.Remaining, "remaining events, not bytes")
+		assert.InDelta(t, 200*time.Millisecond, le.RetryAfter, float64(20*time.Millisecond))
+	})
+
+	t.Run("events only", func(t *testing.T) {
+		rl := NewRateLimiter(0, WithEventsPerSec(2))
+		assert.Nil(t, rl.limiter)
+		mw := rl.Middleware()(pass)
+
+		require.NoError(t, mw(ev))
+		require.NoError(t, mw(ev))
+		assert.ErrorIs(t, mw(ev), apperr.ErrRateLimited)
+	})
+
+	t.Run("rejection by one bucket leaves the other untouched", func(t *testing.T) {
+		n := ev.Msgsize()
+		rl := NewRateLimiter(float64(10*n), WithEventsPerSec(1))
+		mw := rl.Middleware()(pass)
+
+		require.NoError(t, mw(ev))
+		before := rl.limiter.TokensAt(time.Now())
+		assert.ErrorIs(t, mw(ev), apperr.ErrRateLimited)
+		assert.InDelta(t, before, rl.limiter.TokensAt(time.Now()), 1)
+	})
+}
@@ -0,0 +1,33 @@
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// rebuildLogEvery spaces out the progress logs of a rebuild.
+const rebuildLogEvery = 10 * time.Second
+
+// rebuild replays j into fn until ctx is done, logging how far it has got
+// every rebuildLogEvery, so a start held up replaying a large journal says
+// so.
+func rebuild(ctx context.Context, j *journal.Journal, what string, fn func(*journal.Entry) error) error {
+	last := time.Now()
+	return j.ReplayCtx(ctx, fn, journal.WithReplayProgress(func(p journal.ReplayProgress) {
+		if time.Since(last) < rebuildLogEvery {
+			return
+		}
+		last = time.Now()
+		slog.Info("rebuilding from journal",
+			"what", what,
+			"entries", p.Entries,
+			"bytes", p.Bytes,
+			"segment", p.Segment,
+			"segments_done", p.Done,
+			"segments", p.Segments,
+		)
+	}))
+}
@@ -0,0 +1,130 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// Route sends events of sensors matching any of Patterns (path.Match
+// syntax) to Journal.
+type Route struct {
+	Name     string
+	Patterns []string
+	Journal  Journal
+}
+
+func (r *Route) matches(sensor string) bool {
+	for _, p := range r.Patterns {
+		if ok, _ := path.Match(p, sensor); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Router is a Journal that writes each event to the first route its
+// sensor matches, and the rest to the fallback, e.g. high-frequency
+// vibration data to a local-only journal and billing meters to the
+// replicated one. It's the last stop of the pipeline, so routing sees the
+// sensor names after every transform.
+//
+// A batch is split per journal and written to each in turn. If one
+// fails the sink retries the whole batch, so journals that already took
+// their part get those entries twice.
+type Router struct {
+	routes   []Route
+	fallback Journal
+}
+
+func NewRouter(fallback Journal, routes ...Route) *Router {
+	return &Router{routes: routes, fallback: fallback}
+}
+
+func (r *Router) route(key []byte) (string, Journal) {
+	sensor, ok := routeSensor(key)
+	for i := range r.routes {
+		if ok && r.routes[i].matches(sensor) {
+			return r.routes[i].Name, r.routes[i].Journal
+		}
+	}
+	return "default", r.fallback
+}
+
+func (r *Router) Write(key, value []byte) (uint64, error) {
+	name, j := r.route(key)
+	routedEvents(name).Inc()
+	return j.Write(key, value)
+}
+
+// WriteBatch returns each entry's sequence number in the journal it went
+// to. When a route fails, its entries that weren't written get 0, and the
+// others keep theirs.
+func (r *Router) WriteBatch(entries []journal.Entry) ([]uint64, error) {
+	type part struct {
+		name    string
+		j       Journal
+		idx     []int
+		entries []journal.Entry
+	}
+	var parts []*part
+	byRoute := make(map[string]*part)
+	for i, e := range entries {
+		name, j := r.route(e.Key)
+		p, ok := byRoute[name]
+		if !ok {
+			p = &part{name: name, j: j}
+			byRoute[name] = p
+			parts = append(parts, p)
+		}
+		p.idx = append(p.idx, i)
+		p.entries = append(p.entries, e)
+	}
+
+	seqs := make([]uint64, len(entries))
+	var errs []error
+	for _, p := range parts {
+		s, err := p.j.WriteBatch(p.entries)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", p.name, err))
+		}
+		routedEvents(p.name).Add(len(s))
+		for k, i := range p.idx[:min(len(s), len(p.idx))] {
+			entries[i].Seq = p.entries[k].Seq
+			seqs[i] = s[k]
+		}
+	}
+	return seqs, errors.Join(errs...)
+}
+
+// routeSensor takes the sensor back out of an event key. Anything else,
+// device reports among them, takes the default route whatever the
+// patterns.
+func routeSensor(key []byte) (string, bool) {
+	sensor, _, err := DecodeKey(key)
+	return sensor, err == nil
+}
+
+// Sync fsyncs every journal the router writes to that can be synced, each
+// once however many routes share it.
+func (r *Router) Sync() error {
+	journals := []Journal{r.fallback}
+	for _, route := range r.routes {
+		journals = append(journals, route.Journal)
+	}
+	var errs []error
+	seen := make(map[Journal]bool, len(journals))
+	for _, j := range journals {
+		sj, ok := j.(syncer)
+		if !ok || seen[j] {
+			continue
+		}
+		seen[j] = true
+		if err := sj.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
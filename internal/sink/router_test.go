@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestRouter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	main, local := NewMockJournal(ctrl), NewMockJournal(ctrl)
+
+	keys := func(entries []journal.Entry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, string(e.Key))
+		}
+		return out
+	}
+
+	r := NewRouter(main, Route{Name: "vibration", Patterns: []string{"vib-*"}, Journal: local})
+	s := New(r, WithBufSize(8))
+	require.NoError(t, s.Append(event("vib-1", 1, 1)))
+	require.NoError(t, s.Append(event("meter-1", 2, 2)))
+	require.NoError(t, s.Append(event("vib-2", 3, 3)))
+
+	local.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(func(entries []journal.Entry) ([]uint64, error) {
+		assert.Equal(t, []string{"sensor_vib-2{ts=3}", "sensor_vib-1{ts=1}"}, keys(entries))
+		return []uint64{10, 11}, nil
+	})
+	main.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(func(entries []journal.Entry) ([]uint64, error) {
+		assert.Equal(t, []string{"sensor_meter-1{ts=2}"}, keys(entries))
+		return []uint64{7}, nil
+	})
+	require.NoError(t, s.flush())
+
+	t.Run("seqs in entry order", func(t *testing.T) {
+		local.EXPECT().WriteBatch(gomock.Any()).Return([]uint64{20}, nil)
+		main.EXPECT().WriteBatch(gomock.Any()).Return([]uint64{30}, nil)
+		seqs, err := r.WriteBatch([]journal.Entry{{Key: []byte("sensor_a{ts=1}")}, {Key: []byte("sensor_vib-9{ts=1}")}})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{30, 20}, seqs)
+	})
+
+	t.Run("failed route", func(t *testing.T) {
+		local.EXPECT().WriteBatch(gomock.Any()).Return(nil, errors.New("disk full"))
+		main.EXPECT().WriteBatch(gomock.Any()).Return([]uint64{31}, nil)
+		_, err := r.WriteBatch([]journal.Entry{{Key: []byte("sensor_vib-1{ts=1}")}, {Key: []byte("sensor_a{ts=1}")}})
+		assert.ErrorContains(t, err, "route vibration: disk full")
+	})
+
+	t.Run("device reports take the default route", func(t *testing.T) {
+		all := NewRouter(main, Route{Name: "all", Patterns: []string{"*"}, Journal: local})
+		main.EXPECT().Write([]byte("device_gw-1{ts=1}"), nil).Return(uint64(8), nil)
+		_, err := all.Write([]byte("device_gw-1{ts=1}"), nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("single writes", func(t *testing.T) {
+		local.EXPECT().Write([]byte("sensor_vib-{ts}{ts=5}"), nil).Return(uint64(1), nil)
+		_, err := r.Write([]byte("sensor_vib-{ts}{ts=5}"), nil)
+		require.NoError(t, err)
+	})
+}
+
+type syncingJournal struct {
+	Journal
+	syncs int
+	err   error
+}
+
+func (j *syncingJournal) Sync() error {
+	j.syncs++
+	return j.err
+}
+
+func TestRouterSync(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	main := &syncingJournal{Journal: NewMockJournal(ctrl)}
+	local := &syncingJournal{Journal: NewMockJournal(ctrl), err: errors.New("disk gone")}
+	r := NewRouter(main,
+		Route{Name: "vibration", Patterns: []string{"vib-*"}, Journal: local},
+		Route{Name: "billing", Patterns: []string{"meter-*"}, Journal: main},
+		Route{Name: "unsynced", Patterns: []string{"x-*"}, Journal: NewMockJournal(ctrl)},
+	)
+
+	assert.ErrorContains(t, r.Sync(), "disk gone")
+	assert.Equal(t, 1, main.syncs)
+	assert.Equal(t, 1, local.syncs)
+}
@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+var ErrInvalidSampling = errors.New("invalid sampling rule")
+
+// SampleRule thins out events from sensors matching any of Patterns
+// (path.Match syntax), for sensors sending far more often than anyone
+// needs. Set one of Every, keeping the first of every N events of each
+// sensor, or Probability, keeping each event with that chance.
+type SampleRule struct {
+	// Name labels the rule in metrics; defaults to its first pattern.
+	Name        string   `json:"name"`
+	Patterns    []string `json:"patterns"`
+	Every       int      `json:"every,omitempty"`
+	Probability float64  `json:"probability,omitempty"`
+}
+
+type sampleRule struct {
+	SampleRule
+	seen sync.Map // sensor -> *atomic.Uint64, for Every
+}
+
+func (r *sampleRule) matches(sensor string) bool {
+	for _, p := range r.Patterns {
+		if ok, _ := path.Match(p, sensor); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Sampler drops a share of the events of the sensors its rules match,
+// before they take up dedup entries, limits or journal space. The first
+// rule matching a sensor applies; other sensors pass untouched. Sampled
+// out events are acknowledged as if written. Rules can be replaced while
+// running; the 1-in-N counts start over when they are.
+type Sampler struct {
+	rules atomic.Pointer[[]*sampleRule]
+	rand  func() float64
+}
+
+func NewSampler(rules []SampleRule) (*Sampler, error) {
+	s := &Sampler{rand: rand.Float64}
+	if err := s.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetRules validates rules and swaps them in for the current ones.
+func (s *Sampler) SetRules(rules []SampleRule) error {
+	compiled := make([]*sampleRule, 0, len(rules))
+	for i, r := range rules {
+		if len(r.Patterns) == 0 {
+			return fmt.Errorf("%w: rule %d: no patterns", ErrInvalidSampling, i)
+		}
+		for _, p := range r.Patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("%w: rule %d: pattern %q: %w", ErrInvalidSampling, i, p, err)
+			}
+		}
+		if (r.Every > 0) == (r.Probability > 0) {
+			return fmt.Errorf("%w: rule %d: set one of every or probability", ErrInvalidSampling, i)
+		}
+		if r.Every < 0 || r.Probability < 0 || r.Probability > 1 {
+			return fmt.Errorf("%w: rule %d: every must be positive and probability within (0, 1]", ErrInvalidSampling, i)
+		}
+		if r.Name == "" {
+			r.Name = r.Patterns[0]
+		}
+		compiled = append(compiled, &sampleRule{SampleRule: r})
+	}
+	s.rules.Store(&compiled)
+	return nil
+}
+
+// Rules returns the rules in effect, names filled in.
+func (s *Sampler) Rules() []SampleRule {
+	rules := *s.rules.Load()
+	out := make([]SampleRule, 0, len(rules))
+	for _, r := range rules {
+		out = append(out, r.SampleRule)
+	}
+	return out
+}
+
+func (s *Sampler) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			if !s.keep(ev.Sensor) {
+				return nil
+			}
+			return next(ev)
+		}
+	}
+}
+
+func (s *Sampler) keep(sensor string) bool {
+	for _, r := range *s.rules.Load() {
+		if !r.matches(sensor) {
+			continue
+		}
+		var keep bool
+		if r.Every > 0 {
+			n, ok := r.seen.Load(sensor)
+			if !ok {
+				n, _ = r.seen.LoadOrStore(sensor, new(atomic.Uint64))
+			}
+			keep = (n.(*atomic.Uint64).Add(1)-1)%uint64(r.Every) == 0
+		} else {
+			keep = s.rand() < r.Probability
+		}
+		if !keep {
+			sampledOut(r.Name).Inc()
+		}
+		return keep
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+func TestSampler(t *testing.T) {
+	s, err := NewSampler([]SampleRule{
+		{Name: "vibration", Patterns: []string{"vib-*"}, Every: 3},
+		{Patterns: []string{"mic-*", "audio-*"}, Probability: 0.5},
+	})
+	require.NoError(t, err)
+	rolls := []float64{0.1, 0.7, 0.49, 0.5}
+	s.rand = func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}
+
+	var got []string
+	h := s.Middleware()(func(ev entity.Event) error {
+		got = append(got, ev.Sensor)
+		return nil
+	})
+	before := metrics.GetOrCreateCounter(`sink_sampled_out_events_total{rule="vibration"}`).Get()
+
+	for _, sensor := range []string{
+		"vib-1", "vib-2", "vib-1", "vib-1", "vib-1", // every 3rd per sensor
+		"mic-1", "mic-1", "audio-1", "audio-1", // by the rolls
+		"temp",
+	} {
+		require.NoError(t, h(entity.Event{Sensor: sensor}))
+	}
+
+	assert.Equal(t, []string{"vib-1", "vib-2", "vib-1", "mic-1", "audio-1", "temp"}, got)
+	assert.Equal(t, before+2, metrics.GetOrCreateCounter(`sink_sampled_out_events_total{rule="vibration"}`).Get())
+	assert.Equal(t, "mic-*", s.Rules()[1].Name, "name defaults to the first pattern")
+}
+
+func TestSamplerSetRules(t *testing.T) {
+	s, err := NewSampler(nil)
+	require.NoError(t, err)
+	assert.Empty(t, s.Rules())
+
+	for _, r := range []SampleRule{
+		{Every: 2},
+		{Patterns: []string{"["}, Every: 2},
+		{Patterns: []string{"vib-*"}},
+		{Patterns: []string{"vib-*"}, Every: 2, Probability: 0.5},
+		{Patterns: []string{"vib-*"}, Probability: 1.5},
+		{Patterns: []string{"vib-*"}, Every: -1},
+	} {
+		assert.ErrorIs(t, s.SetRules([]SampleRule{r}), ErrInvalidSampling, "%+v", r)
+	}
+	assert.Empty(t, s.Rules(), "rejected rules aren't applied")
+
+	require.NoError(t, s.SetRules([]SampleRule{{Patterns: []string{"vib-*"}, Every: 2}}))
+	var kept int
+	h := s.Middleware()(func(entity.Event) error { kept++; return nil })
+	for range 4 {
+		require.NoError(t, h(entity.Event{Sensor: "vib-1"}))
+	}
+	assert.Equal(t, 2, kept)
+}
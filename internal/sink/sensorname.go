@@ -0,0 +1,104 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+var ErrInvalidSensorNameRules = errors.New("invalid sensor name rules")
+
+// SensorNameRules normalize sensor names and say which ones are accepted.
+// Normalization runs first: lowercasing, then the first matching prefix
+// mapping. The result must be non-empty, within MaxLength and made only of
+// Charset characters.
+type SensorNameRules struct {
+	// Charset is the body of a regexp character class, e.g. "a-z0-9_.-";
+	// empty allows any character.
+	Charset string
+	// MaxLength caps names in bytes, 0 for no limit.
+	MaxLength int
+	Lowercase bool
+	// Prefixes replace a leading From with To. With Lowercase set, From
+	// is matched against the lowercased name.
+	Prefixes []SensorPrefix
+}
+
+type SensorPrefix struct {
+	From string
+	To   string
+}
+
+// SensorNames enforces SensorNameRules, so names with characters journal
+// keys can't hold, like the braces and newlines that break text key
+// parsing, are turned away with apperr.ErrInvalidSensorName instead of
+// stored.
+type SensorNames struct {
+	SensorNameRules
+	charset *regexp.Regexp
+}
+
+func NewSensorNames(rules SensorNameRules) (*SensorNames, error) {
+	if rules.MaxLength < 0 {
+		return nil, fmt.Errorf("%w: negative max length %d", ErrInvalidSensorNameRules, rules.MaxLength)
+	}
+	for i, p := range rules.Prefixes {
+		if p.From == "" {
+			return nil, fmt.Errorf("%w: prefix %d: empty from", ErrInvalidSensorNameRules, i)
+		}
+	}
+	n := &SensorNames{SensorNameRules: rules}
+	if rules.Charset != "" {
+		re, err := regexp.Compile("^[" + rules.Charset + "]+$")
+		if err != nil {
+			return nil, fmt.Errorf("%w: charset: %w", ErrInvalidSensorNameRules, err)
+		}
+		n.charset = re
+	}
+	return n, nil
+}
+
+func (n *SensorNames) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			name := n.normalize(ev.Sensor)
+			if err := n.check(name); err != nil {
+				sensorNamesRejected.Inc()
+				return err
+			}
+			if name != ev.Sensor {
+				sensorNamesNormalized.Inc()
+				ev.Sensor = name
+			}
+			return next(ev)
+		}
+	}
+}
+
+func (n *SensorNames) normalize(name string) string {
+	if n.Lowercase {
+		name = strings.ToLower(name)
+	}
+	for _, p := range n.Prefixes {
+		if rest, ok := strings.CutPrefix(name, p.From); ok {
+			return p.To + rest
+		}
+	}
+	return name
+}
+
+func (n *SensorNames) check(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: empty", apperr.ErrInvalidSensorName)
+	case n.MaxLength > 0 && len(name) > n.MaxLength:
+		return fmt.Errorf("%w: %d bytes, longer than %d", apperr.ErrInvalidSensorName, len(name), n.MaxLength)
+	case n.charset != nil && !n.charset.MatchString(name):
+		return fmt.Errorf("%w: %q has characters outside [%s]", apperr.ErrInvalidSensorName, name, n.Charset)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+func TestSensorNames(t *testing.T) {
+	n, err := NewSensorNames(SensorNameRules{
+		Charset:   "a-z0-9_.:-",
+		MaxLength: 16,
+		Lowercase: true,
+		Prefixes: []SensorPrefix{
+			{From: "thermo-", To: "temp-"},
+			{From: "thermo", To: "unused-"},
+		},
+	})
+	require.NoError(t, err)
+
+	var got entity.Event
+	h := n.Middleware()(func(ev entity.Event) error {
+		got = ev
+		return nil
+	})
+
+	for in, want := range map[string]string{
+		"temp-1":      "temp-1",
+		"Temp-1":      "temp-1",
+		"THERMO-7":    "temp-7",
+		"_canary":     "_canary",
+		"hall:door.2": "hall:door.2",
+	} {
+		require.NoError(t, h(entity.Event{Sensor: in, Value: 1}), in)
+		assert.Equal(t, want, got.Sensor, in)
+	}
+
+	for _, in := range []string{
+		"",
+		"temp{ts=1}",
+		"temp\n1",
+		"temp 1",
+		"a-very-long-sensor-name",
+	} {
+		assert.ErrorIs(t, h(entity.Event{Sensor: in, Value: 1}), apperr.ErrInvalidSensorName, in)
+	}
+
+	t.Run("no charset allows anything but empty", func(t *testing.T) {
+		n, err := NewSensorNames(SensorNameRules{})
+		require.NoError(t, err)
+		h := n.Middleware()(func(entity.Event) error { return nil })
+		assert.NoError(t, h(entity.Event{Sensor: "temp{1}"}))
+		assert.ErrorIs(t, h(entity.Event{}), apperr.ErrInvalidSensorName)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		_, err := NewSensorNames(SensorNameRules{Charset: "z-a"})
+		assert.ErrorIs(t, err, ErrInvalidSensorNameRules)
+		_, err = NewSensorNames(SensorNameRules{MaxLength: -1})
+		assert.ErrorIs(t, err, ErrInvalidSensorNameRules)
+		_, err = NewSensorNames(SensorNameRules{Prefixes: []SensorPrefix{{To: "x"}}})
+		assert.ErrorIs(t, err, ErrInvalidSensorNameRules)
+	})
+}
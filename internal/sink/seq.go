@@ -0,0 +1,123 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+var (
+	ErrNoIdempotencyID = errors.New("event has no idempotency id")
+	// ErrDropped means a middleware let the event through without error
+	// but it never reached a buffer, so it won't get a sequence number.
+	ErrDropped = errors.New("event dropped by the pipeline")
+)
+
+type seqWaiter struct {
+	buffered bool
+	seq      chan uint64
+}
+
+// seqWaiters follows events appended with AppendSeq by idempotency ID,
+// which middlewares leave alone while they may rewrite everything else.
+type seqWaiters struct {
+	waitMu  sync.Mutex
+	waiting atomic.Int64 // len of all waiter lists, to skip the lock
+	waiters map[string][]*seqWaiter
+}
+
+// AppendSeq is Append that waits for the flush writing ev and returns the
+// journal sequence number it was given, for clients keying exactly-once
+// delivery on it. ev needs an IdempotencyID. If ctx ends first, AppendSeq
+// returns its error, but the event stays buffered and is still written.
+func (s *Sink) AppendSeq(ctx context.Context, ev entity.Event) (uint64, error) {
+	if ev.IdempotencyID == "" {
+		return 0, ErrNoIdempotencyID
+	}
+
+	w := &seqWaiter{seq: make(chan uint64, 1)}
+	s.waitMu.Lock()
+	if s.waiters == nil {
+		s.waiters = make(map[string][]*seqWaiter)
+	}
+	s.waiters[ev.IdempotencyID] = append(s.waiters[ev.IdempotencyID], w)
+	s.waiting.Add(1)
+	s.waitMu.Unlock()
+
+	if err := s.Append(ev); err != nil {
+		s.forget(ev.IdempotencyID, w)
+		return 0, err
+	}
+	s.waitMu.Lock()
+	buffered := w.buffered
+	s.waitMu.Unlock()
+	if !buffered {
+		s.forget(ev.IdempotencyID, w)
+		return 0, ErrDropped
+	}
+
+	select {
+	case seq := <-w.seq:
+		return seq, nil
+	case <-ctx.Done():
+		s.forget(ev.IdempotencyID, w)
+		return 0, ctx.Err()
+	}
+}
+
+// buffered marks the oldest waiter for id whose event hasn't reached a
+// buffer yet as about to. If the buffer then refuses the event, Append
+// fails and the waiter is forgotten anyway.
+func (s *Sink) buffered(id string) {
+	if s.waiting.Load() == 0 || id == "" {
+		return
+	}
+	s.waitMu.Lock()
+	defer s.waitMu.Unlock()
+	for _, w := range s.waiters[id] {
+		if !w.buffered {
+			w.buffered = true
+			return
+		}
+	}
+}
+
+// resolve hands seq to the oldest buffered waiter for id.
+func (s *Sink) resolve(id string, seq uint64) {
+	if s.waiting.Load() == 0 || id == "" {
+		return
+	}
+	s.waitMu.Lock()
+	defer s.waitMu.Unlock()
+	for _, w := range s.waiters[id] {
+		if w.buffered {
+			w.seq <- seq
+			s.removeLocked(id, w)
+			return
+		}
+	}
+}
+
+func (s *Sink) forget(id string, w *seqWaiter) {
+	s.waitMu.Lock()
+	defer s.waitMu.Unlock()
+	s.removeLocked(id, w)
+}
+
+func (s *Sink) removeLocked(id string, w *seqWaiter) {
+	ws := s.waiters[id]
+	i := slices.Index(ws, w)
+	if i < 0 {
+		return
+	}
+	s.waiting.Add(-1)
+	if len(ws) == 1 {
+		delete(s.waiters, id)
+		return
+	}
+	s.waiters[id] = slices.Delete(ws, i, i+1)
+}
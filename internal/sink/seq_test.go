@@ -0,0 +1,79 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestAppendSeq(t *testing.T) {
+	newJournalSink := func(t *testing.T, opts ...Option) *Sink {
+		t.Helper()
+		j, err := journal.New(journal.NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = j.Close() })
+		return New(j, opts...)
+	}
+	withID := func(id, sensor string, ts int64) entity.Event {
+		ev := event(sensor, 1, ts)
+		ev.IdempotencyID = id
+		return ev
+	}
+	appendSeq := func(s *Sink, ev entity.Event) <-chan uint64 {
+		ch := make(chan uint64, 1)
+		go func() {
+			seq, err := s.AppendSeq(context.Background(), ev)
+			assert.NoError(t, err)
+			ch <- seq
+		}()
+		return ch
+	}
+
+	t.Run("resolved by the flush", func(t *testing.T) {
+		s := newJournalSink(t, WithBufSize(8))
+		require.NoError(t, s.Append(withID("a", "temp", 1)))
+		b := appendSeq(s, withID("b", "temp", 2))
+		require.Eventually(t, func() bool { return s.buf.Len() == 2 }, time.Second, time.Millisecond)
+
+		require.NoError(t, s.flush())
+		select {
+		case seq := <-b:
+			assert.Positive(t, seq)
+		case <-time.After(time.Second):
+			t.Fatal("AppendSeq didn't return after the flush")
+		}
+		assert.Zero(t, s.waiting.Load())
+	})
+
+	t.Run("evicted events get theirs at once", func(t *testing.T) {
+		s := newJournalSink(t, WithBufSize(1))
+		first := appendSeq(s, withID("a", "temp", 1))
+		require.Eventually(t, func() bool { return s.buf.Len() == 1 }, time.Second, time.Millisecond)
+		require.NoError(t, s.Append(withID("b", "temp", 2)))
+		assert.Equal(t, uint64(1), <-first)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		drop := func(Handler) Handler { return func(entity.Event) error { return nil } }
+		s := newJournalSink(t, WithMiddleware(drop))
+		_, err := s.AppendSeq(context.Background(), withID("a", "temp", 1))
+		assert.ErrorIs(t, err, ErrDropped)
+
+		_, err = s.AppendSeq(context.Background(), event("temp", 1, 1))
+		assert.ErrorIs(t, err, ErrNoIdempotencyID)
+
+		s = newJournalSink(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = s.AppendSeq(ctx, withID("a", "temp", 1))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, s.waiting.Load())
+		assert.Equal(t, 1, s.buf.Len(), "still buffered")
+	})
+}
@@ -0,0 +1,71 @@
+package sink
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// shedder is the load shedding the memory watchdog and the disk guard
+// share: relief hooks to run once a check finds a resource past its
+// limit, the periodic check, and a middleware that turns events away with
+// err while the last check left it there.
+type shedder struct {
+	hooks     []func()
+	err       error
+	rejecting atomic.Bool
+	gauge     *metrics.Gauge   // 1 while rejecting
+	rejected  *metrics.Counter // events turned away
+}
+
+// run calls check every interval until ctx is done.
+func (s *shedder) run(ctx context.Context, interval time.Duration, check func()) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+func (s *shedder) relieve() {
+	for _, h := range s.hooks {
+		h()
+	}
+}
+
+// setRejecting turns rejection on or off and reports whether it changed,
+// for the caller to log.
+func (s *shedder) setRejecting(on bool) bool {
+	if s.rejecting.Swap(on) == on {
+		return false
+	}
+	if on {
+		s.gauge.Set(1)
+	} else {
+		s.gauge.Set(0)
+	}
+	return true
+}
+
+// middleware rejects events while rejection is on. It belongs first in a
+// pipeline, so they're turned away before any stage holds on to them.
+func (s *shedder) middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			if s.rejecting.Load() {
+				s.rejected.Inc()
+				return s.err
+			}
+			return next(ev)
+		}
+	}
+}
@@ -1,16 +1,20 @@
 package sink
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"strconv"
+	"fmt"
+	"log/slog"
+	"path"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
 	"github.com/andriibeee/iotdemo/pkg/journal"
 	"github.com/andriibeee/iotdemo/pkg/rb"
+	"github.com/andriibeee/iotdemo/pkg/retry"
 )
 
 var (
@@ -36,27 +40,193 @@ func WithMiddleware(middlewares ...Middleware) Option {
 	}
 }
 
-const defaultBufSize = 128
+// WithPriority gives sensors matching any of patterns (path.Match syntax,
+// e.g. "alarm-*") a buffer of their own, so bulk telemetry overflowing the
+// default buffer can't push their events out. Lanes are matched and
+// flushed in the order they're added, ahead of the default buffer.
+func WithPriority(name string, bufSize int, patterns ...string) Option {
+	return func(s *Sink) {
+		s.lanes = append(s.lanes, &lane{
+			name:     name,
+			patterns: patterns,
+			bufSize:  bufSize,
+		})
+	}
+}
+
+// WithOverflow sets what a full buffer does with a new event. rb.Evict,
+// the default, pushes the oldest one out to the spill file or journal.
+// rb.Reject fails the event with apperr.ErrBufferFull, and rb.Block waits
+// up to maxWait for a flush to make room first, so producers get
+// backpressure instead of events being written out of band.
+func WithOverflow(mode rb.Mode, maxWait time.Duration) Option {
+	return func(s *Sink) {
+		s.overflow = mode
+		s.maxWait = maxWait
+	}
+}
+
+// WithKeyCodec sets the layout of the journal keys events are written
+// under; TextKeys by default.
+func WithKeyCodec(c KeyCodec) Option {
+	return func(s *Sink) {
+		s.keys = c
+	}
+}
+
+// WithWrittenHook calls fn with the idempotency ID and journal sequence
+// number of every event written that has an ID, once the write succeeds.
+func WithWrittenHook(fn func(id string, seq uint64)) Option {
+	return func(s *Sink) {
+		s.onWritten = fn
+	}
+}
+
+// WithSpill sends events pushed out of a full buffer to sp instead of
+// writing each to the journal as it is evicted. The caller closes sp after
+// the sink.
+func WithSpill(sp *Spill) Option {
+	return func(s *Sink) {
+		s.spill = sp
+	}
+}
+
+// WithFlushRetry sets how Run handles a failed flush: it retries after
+// base, doubling the wait up to max, and after degradeAfter failures in a
+// row marks the sink degraded, rejecting events with apperr.ErrDegraded
+// until a flush succeeds. Degraded, it tries once per tick. Only
+// journal.ClassTransient errors are retried; any other class degrades the
+// sink on the spot, and a full disk has events rejected with
+// apperr.ErrInsufficientStorage instead.
+func WithFlushRetry(base, max time.Duration, degradeAfter int) Option {
+	return func(s *Sink) {
+		s.retryBase = base
+		s.retryMax = max
+		s.degradeAfter = degradeAfter
+	}
+}
+
+// WithFlushInterval sets how often Run flushes the buffers, a second by
+// default.
+func WithFlushInterval(d time.Duration) Option {
+	return func(s *Sink) {
+		if d > 0 {
+			s.flushInterval = d
+		}
+	}
+}
+
+// WithFlushBytes has Run flush as soon as the events buffered since the
+// last flush take about n bytes in the journal, instead of waiting for
+// the next tick. It bounds what one WriteBatch writes, so a batch doesn't
+// run far past the end of a small journal segment. Sizes are estimated
+// from the encoded value and the key, not measured. 0 turns it off.
+func WithFlushBytes(n int64) Option {
+	return func(s *Sink) {
+		s.flushBytes = n
+	}
+}
+
+const (
+	defaultBufSize       = 128
+	defaultFlushInterval = time.Second
+
+	defaultRetryBase    = 100 * time.Millisecond
+	defaultRetryMax     = 5 * time.Second
+	defaultDegradeAfter = 5
+)
+
+type lane struct {
+	name     string
+	patterns []string
+	bufSize  int
+	buf      *rb.RingBuffer[entity.Event]
+}
+
+func (l *lane) matches(sensor string) bool {
+	for _, p := range l.patterns {
+		if ok, _ := path.Match(p, sensor); ok {
+			return true
+		}
+	}
+	return false
+}
 
 type Sink struct {
 	journal     Journal
 	buf         *rb.RingBuffer[entity.Event]
+	lanes       []*lane
 	handler     Handler
 	bufSize     int
 	middlewares []Middleware
+	backfill    Handler
+	backfillMws []Middleware
+	backfillOn  bool
+	spill       *Spill
+	onWritten   func(id string, seq uint64)
+	keys        KeyCodec
+	overflow    rb.Mode
+	maxWait     time.Duration
 	closed      atomic.Bool
+
+	// highWater is the most events each lane's buffer has held, by lane
+	// name; set up in New and only read after.
+	highWater map[string]*atomic.Int64
+
+	flushInterval time.Duration
+	flushBytes    int64
+	// bufferedBytes estimates the journal bytes of the events buffered
+	// since the last flush; past flushBytes it sends on flushNow
+	bufferedBytes atomic.Int64
+	flushNow      chan struct{}
+
+	flushMu sync.Mutex
+	// pending holds buffered events of a failed flush, written ahead of
+	// the buffers by the next one, and pendingIDs their idempotency IDs.
+	pending    []journal.Entry
+	pendingIDs []string
+
+	retryBase    time.Duration
+	retryMax     time.Duration
+	degradeAfter int
+	// failures counts flushes failed in a row, under flushMu
+	failures  int
+	degraded  atomic.Bool
+	diskFull  atomic.Bool // degraded by journal.ClassDiskFull
+	flushErrs chan error
+	// errsClosed is set under flushMu once flushErrs is closed, as
+	// flushes can still fail after Run returns
+	errsClosed bool
+
+	seqWaiters
+	durability
 }
 
 func New(j Journal, opts ...Option) *Sink {
 	s := &Sink{
-		journal: j,
-		bufSize: defaultBufSize,
+		journal:       j,
+		bufSize:       defaultBufSize,
+		keys:          TextKeys,
+		flushInterval: defaultFlushInterval,
+		flushNow:      make(chan struct{}, 1),
+		retryBase:     defaultRetryBase,
+		retryMax:      defaultRetryMax,
+		degradeAfter:  defaultDegradeAfter,
+		flushErrs:     make(chan error, flushErrBuffer),
 	}
 	for _, opt := range opts {
 		opt(s)
 	}
-	s.buf = rb.New[entity.Event](s.bufSize)
+	s.buf = rb.New[entity.Event](s.bufSize, rb.WithMode(s.overflow))
+	s.highWater = map[string]*atomic.Int64{"default": new(atomic.Int64)}
+	for _, l := range s.lanes {
+		l.buf = rb.New[entity.Event](l.bufSize, rb.WithMode(s.overflow))
+		s.highWater[l.name] = new(atomic.Int64)
+	}
 	s.handler = s.buildChain(s.middlewares)
+	if s.backfillOn {
+		s.backfill = s.buildChain(s.backfillMws)
+	}
 	return s
 }
 
@@ -70,31 +240,106 @@ func (s *Sink) buildChain(middlewares []Middleware) Handler {
 
 func (s *Sink) appendToBuffer(ev entity.Event) error {
 	eventsReceived.Inc()
-	loot, isDropped := s.buf.Add(ev)
+	// before the event is in a buffer, where a flush may take it at once
+	s.buffered(ev.IdempotencyID)
+	buf, laneName := s.buf, "default"
+	for _, l := range s.lanes {
+		if l.matches(ev.Sensor) {
+			buf, laneName = l.buf, l.name
+			break
+		}
+	}
+	if s.overflow != rb.Evict {
+		if err := s.putBuffer(buf, laneName, ev); err != nil {
+			return err
+		}
+		s.noteBytes(&ev)
+		return nil
+	}
+	loot, isDropped := buf.Add(ev)
 	eventsBuffered.Inc()
+	s.noteFill(laneName, buf.Len())
+	s.noteBytes(&ev)
 	if isDropped {
-		val, err := loot.MarshalMsg(nil)
+		laneOverflows(laneName).Inc()
+		if s.spill != nil {
+			if err := s.spill.add(loot); err != nil {
+				spillErrors.Inc()
+				return err
+			}
+			return nil
+		}
+		val, err := EncodeValue(nil, &loot)
 		if err != nil {
 			return err
 		}
-		if _, err = s.journal.Write(
+		laneDirectWrites(laneName).Inc()
+		seq, err := s.journal.Write(
 			s.fmtKey(loot.Sensor, loot.UnixTimestamp),
 			val,
-		); err != nil {
+		)
+		if err != nil {
+			laneDirectWriteErrors(laneName).Inc()
 			return err
 		}
+		s.written(loot.IdempotencyID, seq)
 	}
 	return nil
 }
 
+func (s *Sink) putBuffer(buf *rb.RingBuffer[entity.Event], laneName string, ev entity.Event) error {
+	ctx := context.Background()
+	if s.overflow == rb.Block {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.maxWait)
+		defer cancel()
+	}
+	if err := buf.Put(ctx, ev); err != nil {
+		laneRejected(laneName).Inc()
+		return fmt.Errorf("%w: lane %s: %w", apperr.ErrBufferFull, laneName, err)
+	}
+	eventsBuffered.Inc()
+	s.noteFill(laneName, buf.Len())
+	return nil
+}
+
+// noteFill raises the lane's high-water mark to n buffered events.
+func (s *Sink) noteFill(laneName string, n int) {
+	hw := s.highWater[laneName]
+	for {
+		cur := hw.Load()
+		if int64(n) <= cur {
+			return
+		}
+		if hw.CompareAndSwap(cur, int64(n)) {
+			laneHighWater(laneName).Set(float64(n))
+			return
+		}
+	}
+}
+
+// recordOverhead is roughly what a journal entry takes besides the event
+// value and the sensor name in its key: the rest of the key, the record
+// frame and the value's version byte.
+const recordOverhead = 40
+
+// noteBytes adds ev to the buffered bytes, asking Run for a flush once
+// they reach flushBytes.
+func (s *Sink) noteBytes(ev *entity.Event) {
+	if s.flushBytes <= 0 {
+		return
+	}
+	if s.bufferedBytes.Add(int64(ev.Msgsize()+len(ev.Sensor)+recordOverhead)) < s.flushBytes {
+		return
+	}
+	select {
+	case s.flushNow <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Sink) fmtKey(sensor string, ts int64) []byte {
-	var b bytes.Buffer
-	b.WriteString("sensor_")
-	b.WriteString(sensor)
-	b.WriteString("{ts=")
-	b.WriteString(strconv.FormatInt(ts, 10))
-	b.WriteString("}")
-	return b.Bytes()
+	return s.keys.Encode(sensor, ts)
 }
 
 func (s *Sink) Append(ev entity.Event) error {
@@ -104,12 +349,27 @@ func (s *Sink) Append(ev entity.Event) error {
 	if s.journal == nil {
 		return ErrJournalIsNil
 	}
+	if s.degraded.Load() {
+		return s.degradedErr()
+	}
+	ev.Backfill = false
 	return s.handler(ev)
 }
 
+// Run flushes the buffers every flush interval, and early when
+// WithFlushBytes asks for it, until ctx is done, then once more. A failed
+// flush is retried as set by WithFlushRetry rather than ending Run, so the
+// buffers keep draining once the journal recovers. With WithSyncInterval
+// it fsyncs the journal alongside.
 func (s *Sink) Run(ctx context.Context) error {
-	t := time.NewTicker(1 * time.Second)
+	defer s.closeFlushErrs()
+	t := time.NewTicker(s.flushInterval)
 	defer t.Stop()
+	if d, ok := s.durable(); ok {
+		syncCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		go s.syncLoop(syncCtx, d)
+	}
 
 	for {
 		select {
@@ -120,21 +380,163 @@ func (s *Sink) Run(ctx context.Context) error {
 			}
 			return ctx.Err()
 		case <-t.C:
-			if err := s.flush(); err != nil {
+			if err := s.flushRetrying(ctx); errors.Is(err, ErrJournalIsNil) {
+				return err
+			}
+		case <-s.flushNow:
+			sizeFlushes.Inc()
+			if err := s.flushRetrying(ctx); errors.Is(err, ErrJournalIsNil) {
 				return err
 			}
 		}
 	}
 }
 
+// flushRetrying flushes, retrying with backoff until the sink is degraded;
+// from then on it tries once.
+func (s *Sink) flushRetrying(ctx context.Context) error {
+	attempts := 1
+	if !s.degraded.Load() {
+		attempts = max(s.degradeAfter-s.consecutiveFailures(), 1)
+	}
+	r := retry.New(
+		retry.MaxAttempts(uint(attempts)),
+		retry.Delay(retry.DelayOptions{Delay: s.retryBase, Func: retry.DoubleDelay, Max: s.retryMax}),
+	)
+	first := true
+	return r(ctx, func(context.Context) error {
+		if !first {
+			flushRetries.Inc()
+		}
+		first = false
+		err := s.flush()
+		if errors.Is(err, ErrJournalIsNil) || (err != nil && journal.Classify(err) != journal.ClassTransient) {
+			return fmt.Errorf("%w: %w", retry.ErrStop, err)
+		}
+		return err
+	})
+}
+
+func (s *Sink) consecutiveFailures() int {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	return s.failures
+}
+
+// flushErrBuffer is how many flush errors FlushErrors holds for a slow
+// reader; later ones are dropped until it catches up.
+const flushErrBuffer = 16
+
+// FlushErrors delivers the errors of failed flushes, for alerting; the
+// channel is closed when Run returns, and errors of flushes after that
+// aren't delivered. Errors nobody reads are dropped.
+func (s *Sink) FlushErrors() <-chan error {
+	return s.flushErrs
+}
+
+func (s *Sink) closeFlushErrs() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.errsClosed = true
+	close(s.flushErrs)
+}
+
+// Degraded reports whether flushes have failed often enough in a row for
+// the sink to reject events.
+func (s *Sink) Degraded() bool {
+	return s.degraded.Load()
+}
+
+// noteFlush tracks flush outcomes for degraded mode, under flushMu.
+func (s *Sink) noteFlush(err error) {
+	if err == nil {
+		s.failures = 0
+		if s.degraded.CompareAndSwap(true, false) {
+			degradedGauge.Set(0)
+			slog.Info("journal flushes recovered, accepting events again")
+		}
+		s.diskFull.Store(false)
+		return
+	}
+
+	s.failures++
+	class := journal.Classify(err)
+	flushFailures(class).Inc()
+	if !s.errsClosed {
+		select {
+		case s.flushErrs <- err:
+		default:
+		}
+	}
+	if class == journal.ClassTransient && s.failures < s.degradeAfter {
+		return
+	}
+	s.diskFull.Store(class == journal.ClassDiskFull)
+	if s.degraded.CompareAndSwap(false, true) {
+		degradedGauge.Set(1)
+		slog.Error("journal flushes failing, rejecting events", "failures", s.failures, "class", class, "error", err)
+	}
+}
+
+// degradedErr is what Append rejects events with while degraded.
+func (s *Sink) degradedErr() error {
+	if s.diskFull.Load() {
+		return apperr.ErrInsufficientStorage
+	}
+	return apperr.ErrDegraded
+}
+
+// Flush writes the buffered events to the journal now rather than at the
+// next tick, e.g. to free memory.
+func (s *Sink) Flush() error {
+	return s.flush()
+}
+
+// syncer is a Journal that can fsync what it was given; *journal.Journal,
+// *journal.MultiWriter and *Router are.
+type syncer interface {
+	Sync() error
+}
+
+// Sync flushes the buffered events and fsyncs the journal, so everything
+// the sink has accepted survives a power cut from here on.
+func (s *Sink) Sync() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	if sj, ok := s.journal.(syncer); ok {
+		return sj.Sync()
+	}
+	return nil
+}
+
+// flush drains the buffers into one journal batch. Events that arrive
+// while it runs stay buffered for the next flush.
 func (s *Sink) flush() error {
 	if s.journal == nil {
 		return ErrJournalIsNil
 	}
 
-	var batch []journal.Entry
-	for ev := range s.buf.All() {
-		val, err := ev.MarshalMsg(nil)
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	err := s.flushLocked()
+	s.noteFlush(err)
+	return err
+}
+
+func (s *Sink) flushLocked() error {
+	bufs := make([]*rb.RingBuffer[entity.Event], 0, len(s.lanes)+1)
+	for _, l := range s.lanes {
+		bufs = append(bufs, l.buf)
+	}
+	bufs = append(bufs, s.buf)
+
+	var (
+		batch []journal.Entry
+		ids   []string // idempotency IDs, for resolving AppendSeq
+	)
+	add := func(ev entity.Event) error {
+		val, err := EncodeValue(nil, &ev)
 		if err != nil {
 			flushErrors.Inc()
 			return err
@@ -143,16 +545,110 @@ func (s *Sink) flush() error {
 			Key:   s.fmtKey(ev.Sensor, ev.UnixTimestamp),
 			Value: val,
 		})
+		ids = append(ids, ev.IdempotencyID)
+		return nil
+	}
+
+	// spilled events were evicted, so they are older than anything buffered
+	var spilled []entity.Event
+	if s.spill != nil {
+		var err error
+		spilled, err = s.spill.drain()
+		if err != nil {
+			spillErrors.Inc()
+			return err
+		}
+		for _, ev := range spilled {
+			if err := add(ev); err != nil {
+				return err
+			}
+		}
+	}
+	// spilled events stay on disk until committed, so only buffered ones
+	// need keeping if the write fails
+	fromSpill := len(batch)
+	// what arrives from here on counts toward the next flush
+	s.bufferedBytes.Store(0)
+	batch = append(batch, s.pending...)
+	ids = append(ids, s.pendingIDs...)
+	for _, buf := range bufs {
+		for _, ev := range buf.Drain() {
+			if err := add(ev); err != nil {
+				// it never will encode; keep the rest of the batch
+				slog.Error("dropping event that failed to encode", "sensor", ev.Sensor, "error", err)
+			}
+		}
 	}
 
 	flushTotal.Inc()
-	if _, err := s.journal.WriteBatch(batch); err != nil {
+	seqs, err := s.journal.WriteBatch(batch)
+	if err != nil {
 		flushErrors.Inc()
-		return err
+		return s.keepUnwritten(batch, ids, seqs, fromSpill, err)
+	}
+	s.pending, s.pendingIDs = nil, nil
+	s.noteWritten(seqs)
+	for i, seq := range seqs {
+		s.written(ids[i], seq)
+	}
+	if s.spill != nil {
+		if err := s.spill.commit(); err != nil {
+			spillErrors.Inc()
+			return err
+		}
+		spillDrained.Add(len(spilled))
 	}
 	return nil
 }
 
+// keepUnwritten holds on to the entries of a failed batch that didn't make
+// it into the journal, for the next flush to retry. A batch can fail
+// partway, and the entries written before must not be written twice; if
+// any of them came from the spill, the rest of it is kept in memory and
+// the spill committed, as draining it again would repeat them.
+func (s *Sink) keepUnwritten(batch []journal.Entry, ids []string, seqs []uint64, fromSpill int, err error) error {
+	isWritten := func(i int) bool { return i < len(seqs) && seqs[i] != 0 }
+	var spillWritten int
+	for i := range fromSpill {
+		if isWritten(i) {
+			spillWritten++
+		}
+	}
+	keepFrom := fromSpill
+	if spillWritten > 0 {
+		keepFrom = 0
+	}
+
+	s.pending, s.pendingIDs = nil, nil
+	for i := range batch {
+		if isWritten(i) {
+			s.written(ids[i], seqs[i])
+		} else if i >= keepFrom {
+			s.pending = append(s.pending, batch[i])
+			s.pendingIDs = append(s.pendingIDs, ids[i])
+		}
+	}
+	if spillWritten > 0 {
+		if cerr := s.spill.commit(); cerr != nil {
+			spillErrors.Inc()
+			return errors.Join(err, cerr)
+		}
+		spillDrained.Add(spillWritten)
+	}
+	return err
+}
+
+// written reports an event's sequence number to whoever is waiting for it.
+func (s *Sink) written(id string, seq uint64) {
+	if id == "" {
+		return
+	}
+	s.resolve(id, seq)
+	if s.onWritten != nil {
+		s.onWritten(id, seq)
+	}
+}
+
 func (s *Sink) Close() error {
 	s.closed.Store(true)
 	return s.flush()
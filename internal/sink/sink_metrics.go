@@ -1,10 +1,171 @@
 package sink
 
-import "github.com/VictoriaMetrics/metrics"
+import (
+	"fmt"
+	"math"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
 
 var (
 	eventsReceived = metrics.NewCounter("sink_events_received_total")
 	eventsBuffered = metrics.NewCounter("sink_events_buffered_total")
 	flushTotal     = metrics.NewCounter("sink_flush_total")
 	flushErrors    = metrics.NewCounter("sink_flush_errors_total")
+	flushRetries   = metrics.NewCounter("sink_flush_retries_total")
+	// sizeFlushes counts flushes WithFlushBytes brought forward.
+	sizeFlushes = metrics.NewCounter("sink_size_flushes_total")
+	// degradedGauge is 1 while failing flushes have the sink rejecting
+	// events.
+	degradedGauge = metrics.NewGauge("sink_degraded", nil)
+
+	syncDuration = metrics.NewHistogram("sink_journal_sync_duration_seconds")
+	syncErrors   = metrics.NewCounter("sink_journal_sync_errors_total")
+	// durableSeq is the journal's DurableSeq after the last background
+	// fsync.
+	durableSeq = metrics.NewGauge("sink_journal_durable_seq", nil)
+
+	eventsBackfilled = metrics.NewCounter("sink_backfilled_events_total")
+
+	eventsSpilled = metrics.NewCounter("sink_spilled_events_total")
+	spillDrained  = metrics.NewCounter("sink_spill_drained_events_total")
+	spillErrors   = metrics.NewCounter("sink_spill_errors_total")
+	spillCorrupt  = metrics.NewCounter("sink_spill_corrupt_files_total")
+
+	horizonRejected = metrics.NewCounter(`sink_horizon_events_total{action="rejected"}`)
+	horizonTagged   = metrics.NewCounter(`sink_horizon_events_total{action="tagged"}`)
+
+	sensorNamesNormalized = metrics.NewCounter(`sink_sensor_names_total{action="normalized"}`)
+	sensorNamesRejected   = metrics.NewCounter(`sink_sensor_names_total{action="rejected"}`)
+
+	livenessAlertErrors = metrics.NewCounter("sensor_liveness_alert_errors_total")
+
+	memoryUsage     = metrics.NewGauge("sink_memory_bytes", nil)
+	memoryRejecting = metrics.NewGauge("sink_memory_rejecting", nil)
+	memoryPressure  = metrics.NewCounter("sink_memory_pressure_total")
+	memoryRejected  = metrics.NewCounter("sink_memory_rejected_events_total")
+
+	diskJournalBytes = metrics.NewGauge("sink_disk_journal_bytes", nil)
+	diskFreeBytes    = metrics.NewGauge("sink_disk_free_bytes", nil)
+	diskRejecting    = metrics.NewGauge("sink_disk_rejecting", nil)
+	diskPressure     = metrics.NewCounter("sink_disk_pressure_total")
+	diskRejected     = metrics.NewCounter("sink_disk_rejected_events_total")
+
+	canaryLatency     = metrics.NewHistogram("sink_canary_latency_seconds")
+	canaryFailures    = metrics.NewCounter("sink_canary_failures_total")
+	canaryLastSuccess = metrics.NewGauge("sink_canary_last_success_timestamp_seconds", nil)
+
+	deviceReports = metrics.NewCounter("sink_device_reports_total")
 )
+
+// flushFailures counts failed flushes by the class of their error.
+func flushFailures(class journal.ErrorClass) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_flush_failures_total{class=%q}`, class))
+}
+
+func laneOverflows(lane string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_buffer_overflows_total{lane=%q}`, lane))
+}
+
+func laneRejected(lane string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_buffer_rejected_total{lane=%q}`, lane))
+}
+
+// laneDirectWrites counts events evicted from a full buffer and written to
+// the journal on their own, without a spill file; laneDirectWriteErrors
+// those writes that failed, losing the event.
+func laneDirectWrites(lane string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_evicted_direct_writes_total{lane=%q}`, lane))
+}
+
+func laneDirectWriteErrors(lane string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_evicted_direct_write_errors_total{lane=%q}`, lane))
+}
+
+func laneHighWater(lane string) *metrics.Gauge {
+	return metrics.GetOrCreateGauge(fmt.Sprintf(`sink_buffer_high_water{lane=%q}`, lane), nil)
+}
+
+func transformApplied(rule string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_transform_applied_total{rule=%q}`, rule))
+}
+
+func transformClamped(rule string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_transform_clamped_total{rule=%q}`, rule))
+}
+
+func sampledOut(rule string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_sampled_out_events_total{rule=%q}`, rule))
+}
+
+// registerSensorMetrics exposes when sensor was last heard from, for
+// alerting on sensors gone quiet.
+func registerSensorMetrics(st *Stats, sensor string) {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`sensor_last_seen_timestamp_seconds{sensor=%q}`, sensor), func() float64 {
+		return st.lastSeen(sensor)
+	})
+}
+
+// registerDeviceMetrics exposes the latest report of device. Values it
+// didn't report are NaN.
+func registerDeviceMetrics(d *Devices, device string) {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`device_last_seen_timestamp_seconds{device=%q}`, device), func() float64 {
+		return float64(d.latest(device).UnixTimestamp) / 1000
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf(`device_battery_percent{device=%q}`, device), func() float64 {
+		if b := d.latest(device).Battery; b != nil {
+			return *b
+		}
+		return math.NaN()
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf(`device_rssi_dbm{device=%q}`, device), func() float64 {
+		if r := d.latest(device).RSSI; r != nil {
+			return float64(*r)
+		}
+		return math.NaN()
+	})
+}
+
+// registerHealthMetrics exposes sensor's health as of the last check, 1
+// for its state and 0 for the others.
+func registerHealthMetrics(h *Health, sensor string) {
+	for _, state := range healthStates {
+		metrics.GetOrCreateGauge(fmt.Sprintf(`sensor_health{sensor=%q,state=%q}`, sensor, state), func() float64 {
+			if h.is(sensor, state) {
+				return 1
+			}
+			return 0
+		})
+	}
+}
+
+func livenessAlerts(state string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sensor_liveness_alerts_total{state=%q}`, state))
+}
+
+// registerLivenessMetrics exposes whether a sensor is silent under a rule,
+// for alerting from the metrics side instead.
+func registerLivenessMetrics(l *Liveness, key livenessKey) {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`sensor_silent{rule=%q,sensor=%q}`, key.rule, key.sensor), func() float64 {
+		if l.isSilent(key) {
+			return 1
+		}
+		return 0
+	})
+}
+
+// stageLatency and stageErrors are the per-stage metrics of
+// WithStageMetrics.
+func stageLatency(pipeline, stage string) *metrics.Summary {
+	return metrics.GetOrCreateSummary(fmt.Sprintf(`sink_stage_duration_seconds{pipeline=%q,stage=%q}`, pipeline, stage))
+}
+
+func stageErrors(pipeline, stage string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_stage_errors_total{pipeline=%q,stage=%q}`, pipeline, stage))
+}
+
+func routedEvents(route string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`sink_routed_events_total{route=%q}`, route))
+}
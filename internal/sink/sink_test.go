@@ -2,6 +2,9 @@ package sink
 
 import (
 	"context"
+	"errors"
+	"io/fs"
+	"syscall"
 	"testing"
 	"time"
 
@@ -10,7 +13,9 @@ import (
 	"go.uber.org/mock/gomock"
 
 	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
 	"github.com/andriibeee/iotdemo/pkg/journal"
+	"github.com/andriibeee/iotdemo/pkg/rb"
 )
 
 func newSink(t *testing.T, bufSize int, mw ...Middleware) (*Sink, *MockJournal) {
@@ -66,6 +71,21 @@ func TestFlush(t *testing.T) {
 	})
 }
 
+func TestSync(t *testing.T) {
+	j := &syncingJournal{Journal: NewMockJournal(gomock.NewController(t))}
+	s := New(j, WithBufSize(5))
+	require.NoError(t, s.Append(event("temp", 20, 1000)))
+
+	j.Journal.(*MockJournal).EXPECT().WriteBatch(gomock.Len(1)).Return([]uint64{1}, nil)
+	require.NoError(t, s.Sync())
+	assert.Equal(t, 1, j.syncs)
+
+	// a failed flush isn't followed by a sync
+	j.Journal.(*MockJournal).EXPECT().WriteBatch(gomock.Any()).Return(nil, errors.New("disk full"))
+	assert.Error(t, s.Sync())
+	assert.Equal(t, 1, j.syncs)
+}
+
 func TestRun(t *testing.T) {
 	t.Run("stops on cancel", func(t *testing.T) {
 		s, j := newSink(t, 5)
@@ -101,6 +121,51 @@ func TestRun(t *testing.T) {
 	})
 }
 
+func TestFlushBytes(t *testing.T) {
+	ev := event("temp", 42, 1000)
+	size := int64(ev.Msgsize() + len(ev.Sensor) + recordOverhead)
+	j := NewMockJournal(gomock.NewController(t))
+	s := New(j, WithBufSize(100), WithFlushInterval(time.Hour), WithFlushBytes(3*size))
+
+	batches := make(chan int, 10)
+	j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(func(entries []journal.Entry) ([]uint64, error) {
+		batches <- len(entries)
+		return make([]uint64, len(entries)), nil
+	}).AnyTimes()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	for range 2 {
+		require.NoError(t, s.Append(ev))
+	}
+	select {
+	case n := <-batches:
+		t.Fatalf("flushed %d events under the threshold", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, s.Append(ev))
+	select {
+	case n := <-batches:
+		assert.Equal(t, 3, n)
+	case <-time.After(time.Second):
+		t.Fatal("no flush at the threshold")
+	}
+
+	// counted from zero again
+	require.NoError(t, s.Append(ev))
+	select {
+	case n := <-batches:
+		t.Fatalf("flushed %d events under the threshold", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	<-done
+	assert.Equal(t, 1, <-batches, "final flush")
+}
+
 func TestClose(t *testing.T) {
 	s, j := newSink(t, 5)
 	s.Append(event("temp", 42, 1000))
@@ -119,6 +184,65 @@ func TestBufferOverflow(t *testing.T) {
 	}
 }
 
+func TestEvictionMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	j := NewMockJournal(ctrl)
+	s := New(j, WithBufSize(8), WithPriority("evict-test", 2, "ev-*"))
+
+	j.EXPECT().Write(gomock.Any(), gomock.Any()).Return(uint64(1), nil)
+	j.EXPECT().Write(gomock.Any(), gomock.Any()).Return(uint64(0), errors.New("disk full"))
+
+	for i := range 3 {
+		require.NoError(t, s.Append(event("ev-1", i, int64(i))))
+	}
+	assert.Error(t, s.Append(event("ev-1", 3, 3)))
+	require.NoError(t, s.Append(event("temp", 1, 1)))
+
+	assert.Equal(t, uint64(2), laneOverflows("evict-test").Get())
+	assert.Equal(t, uint64(2), laneDirectWrites("evict-test").Get())
+	assert.Equal(t, uint64(1), laneDirectWriteErrors("evict-test").Get())
+	assert.Equal(t, float64(2), laneHighWater("evict-test").Get())
+	assert.Equal(t, int64(1), s.highWater["default"].Load())
+}
+
+func TestOverflowModes(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		s := New(j, WithBufSize(1), WithOverflow(rb.Reject, 0))
+
+		require.NoError(t, s.Append(event("temp", 1, 1)))
+		// no journal write for an evicted event
+		assert.ErrorIs(t, s.Append(event("temp", 2, 2)), apperr.ErrBufferFull)
+
+		j.EXPECT().WriteBatch(gomock.Len(1)).Return([]uint64{1}, nil)
+		require.NoError(t, s.flush())
+		assert.NoError(t, s.Append(event("temp", 2, 2)))
+	})
+
+	t.Run("block until flushed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		j.EXPECT().WriteBatch(gomock.Any()).Return(nil, nil).AnyTimes()
+		s := New(j, WithBufSize(1), WithOverflow(rb.Block, time.Second))
+
+		require.NoError(t, s.Append(event("temp", 1, 1)))
+		done := make(chan error)
+		go func() { done <- s.Append(event("temp", 2, 2)) }()
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, s.flush())
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("block times out", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		s := New(NewMockJournal(ctrl), WithBufSize(1), WithOverflow(rb.Block, 10*time.Millisecond))
+
+		require.NoError(t, s.Append(event("temp", 1, 1)))
+		assert.ErrorIs(t, s.Append(event("temp", 2, 2)), apperr.ErrBufferFull)
+	})
+}
+
 func TestFlushData(t *testing.T) {
 	s, j := newSink(t, 5)
 
@@ -140,6 +264,180 @@ func TestFlushData(t *testing.T) {
 	s.flush()
 }
 
+func TestFlushClearsBuffer(t *testing.T) {
+	s, j := newSink(t, 5)
+	s.Append(event("temp", 1, 1000))
+	s.Append(event("temp", 2, 2000))
+
+	j.EXPECT().WriteBatch(gomock.Len(2)).Return([]uint64{1, 2}, nil)
+	require.NoError(t, s.flush())
+
+	s.Append(event("temp", 3, 3000))
+	j.EXPECT().WriteBatch(gomock.Len(1)).Return([]uint64{3}, nil)
+	require.NoError(t, s.flush())
+}
+
+func TestFailedFlushRetried(t *testing.T) {
+	s, j := newSink(t, 5)
+	s.Append(event("temp", 1, 1000))
+
+	j.EXPECT().WriteBatch(gomock.Len(1)).Return(nil, errors.New("disk full"))
+	require.Error(t, s.flush())
+
+	s.Append(event("temp", 2, 2000))
+	var keys []string
+	j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(func(entries []journal.Entry) ([]uint64, error) {
+		for _, e := range entries {
+			keys = append(keys, string(e.Key))
+		}
+		return nil, nil
+	})
+	require.NoError(t, s.flush())
+	assert.Equal(t, []string{"sensor_temp{ts=1000}", "sensor_temp{ts=2000}"}, keys)
+}
+
+func TestPartlyWrittenFlushRetriesTheRest(t *testing.T) {
+	s, j := newSink(t, 5)
+	s.Append(event("temp", 1, 1000))
+	s.Append(event("temp", 2, 2000))
+	s.Append(event("temp", 3, 3000))
+
+	var first []string
+	j.EXPECT().WriteBatch(gomock.Len(3)).DoAndReturn(func(entries []journal.Entry) ([]uint64, error) {
+		first = append(first, string(entries[0].Key))
+		return []uint64{7}, errors.New("rotation failed")
+	})
+	require.Error(t, s.flush())
+
+	var keys []string
+	j.EXPECT().WriteBatch(gomock.Len(2)).DoAndReturn(func(entries []journal.Entry) ([]uint64, error) {
+		for _, e := range entries {
+			keys = append(keys, string(e.Key))
+		}
+		return []uint64{8, 9}, nil
+	})
+	require.NoError(t, s.flush())
+	assert.NotContains(t, keys, first[0], "written before the failure")
+}
+
+func TestDegraded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	j := NewMockJournal(ctrl)
+	s := New(j, WithBufSize(5), WithFlushRetry(time.Millisecond, time.Millisecond, 2))
+	require.NoError(t, s.Append(event("temp", 1, 1000)))
+
+	j.EXPECT().WriteBatch(gomock.Any()).Return(nil, errors.New("disk full")).Times(2)
+	require.Error(t, s.flush())
+	assert.False(t, s.Degraded())
+	require.Error(t, s.flush())
+	assert.True(t, s.Degraded())
+	assert.EqualError(t, <-s.FlushErrors(), "disk full")
+
+	err := s.Append(event("temp", 2, 2000))
+	assert.ErrorIs(t, err, apperr.ErrDegraded)
+	assert.ErrorIs(t, err, apperr.ErrOverloaded, "transports answer it like overload")
+
+	// the buffered event is still written once the journal recovers
+	j.EXPECT().WriteBatch(gomock.Len(1)).Return([]uint64{1}, nil)
+	require.NoError(t, s.flush())
+	assert.False(t, s.Degraded())
+	require.NoError(t, s.Append(event("temp", 3, 3000)))
+}
+
+func TestRunRetriesFailedFlush(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	j := NewMockJournal(ctrl)
+	s := New(j, WithBufSize(5), WithFlushRetry(time.Millisecond, time.Millisecond, 5))
+	require.NoError(t, s.Append(event("temp", 1, 1000)))
+
+	written := make(chan struct{})
+	gomock.InOrder(
+		j.EXPECT().WriteBatch(gomock.Len(1)).Return(nil, errors.New("disk full")).Times(2),
+		j.EXPECT().WriteBatch(gomock.Len(1)).DoAndReturn(func([]journal.Entry) ([]uint64, error) {
+			close(written)
+			return []uint64{1}, nil
+		}),
+	)
+	j.EXPECT().WriteBatch(gomock.Len(0)).Return(nil, nil).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	select {
+	case <-written:
+	case err := <-done:
+		t.Fatalf("Run returned: %v", err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("flush wasn't retried")
+	}
+	assert.False(t, s.Degraded())
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestFlushAfterRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	j := NewMockJournal(ctrl)
+	j.EXPECT().WriteBatch(gomock.Any()).Return(nil, errors.New("disk full")).AnyTimes()
+	s := New(j, WithBufSize(5))
+	require.NoError(t, s.Append(event("temp", 1, 1000)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, s.Run(ctx))
+	for range s.FlushErrors() {
+	}
+
+	assert.NotPanics(t, func() { assert.Error(t, s.Flush()) })
+}
+
+func TestFlushErrorClasses(t *testing.T) {
+	t.Run("disk full degrades at once", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		s := New(j, WithBufSize(5), WithFlushRetry(time.Millisecond, time.Millisecond, 5))
+		require.NoError(t, s.Append(event("temp", 1, 1000)))
+
+		full := &fs.PathError{Op: "write", Path: "00000001.wal", Err: syscall.ENOSPC}
+		j.EXPECT().WriteBatch(gomock.Len(1)).Return(nil, full)
+		require.Error(t, s.flush())
+		assert.True(t, s.Degraded())
+		assert.ErrorIs(t, s.Append(event("temp", 2, 2000)), apperr.ErrInsufficientStorage)
+
+		j.EXPECT().WriteBatch(gomock.Len(1)).Return([]uint64{1}, nil)
+		require.NoError(t, s.flush())
+		assert.False(t, s.Degraded())
+		require.NoError(t, s.Append(event("temp", 3, 3000)))
+	})
+
+	t.Run("corruption isn't retried", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		s := New(j, WithBufSize(5), WithFlushRetry(time.Millisecond, time.Millisecond, 5))
+		require.NoError(t, s.Append(event("temp", 1, 1000)))
+
+		j.EXPECT().WriteBatch(gomock.Len(1)).Return(nil, journal.ErrSealedSegment).Times(1)
+		require.Error(t, s.flushRetrying(context.Background()))
+		assert.True(t, s.Degraded())
+		assert.ErrorIs(t, s.Append(event("temp", 2, 2000)), apperr.ErrDegraded)
+	})
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		s := New(j, WithBufSize(5), WithFlushRetry(time.Millisecond, time.Millisecond, 5))
+		require.NoError(t, s.Append(event("temp", 1, 1000)))
+
+		gomock.InOrder(
+			j.EXPECT().WriteBatch(gomock.Len(1)).Return(nil, &fs.PathError{Op: "write", Path: "00000001.wal", Err: syscall.EIO}),
+			j.EXPECT().WriteBatch(gomock.Len(1)).Return([]uint64{1}, nil),
+		)
+		require.NoError(t, s.flushRetrying(context.Background()))
+		assert.False(t, s.Degraded())
+	})
+}
+
 func TestMiddleware(t *testing.T) {
 	t.Run("filter drops", func(t *testing.T) {
 		dropNegative := func(next Handler) Handler {
@@ -178,3 +476,35 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, []string{"first", "second", "third"}, order)
 	})
 }
+
+func TestPriorityLanes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	j := NewMockJournal(ctrl)
+	s := New(j, WithBufSize(2), WithPriority("critical", 2, "alarm-*", "safety"))
+
+	// bulk telemetry overflows the default buffer only
+	j.EXPECT().Write([]byte("sensor_temp{ts=1}"), gomock.Any()).Return(uint64(1), nil)
+	j.EXPECT().Write([]byte("sensor_temp{ts=2}"), gomock.Any()).Return(uint64(2), nil)
+
+	require.NoError(t, s.Append(event("alarm-door", 1, 100)))
+	require.NoError(t, s.Append(event("safety", 1, 200)))
+	for ts := range 4 {
+		require.NoError(t, s.Append(event("temp", 1, int64(ts+1))))
+	}
+
+	var keys []string
+	j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(func(entries []journal.Entry) ([]uint64, error) {
+		for _, e := range entries {
+			keys = append(keys, string(e.Key))
+		}
+		return nil, nil
+	})
+	require.NoError(t, s.flush())
+
+	assert.Equal(t, []string{
+		"sensor_safety{ts=200}",
+		"sensor_alarm-door{ts=100}",
+		"sensor_temp{ts=4}",
+		"sensor_temp{ts=3}",
+	}, keys, "priority lane flushed first")
+}
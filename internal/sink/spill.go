@@ -0,0 +1,194 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+const (
+	drainingSuffix = ".draining"
+	corruptSuffix  = ".corrupt"
+
+	spillHeaderSize = 8
+	// maxSpillRecord caps the length a record header can claim, so a
+	// corrupt one doesn't allocate gigabytes.
+	maxSpillRecord = 1 << 20
+)
+
+var (
+	errSpillCorrupt = errors.New("corrupt spill record")
+	spillCRCTable   = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Spill is an append-only overflow file for events pushed out of a full
+// buffer. Appending to it costs a buffered write to the page cache, where
+// writing the journal directly would wait out an fsync, so Append latency
+// stays bounded while the journal stalls. Flush drains it into the journal
+// ahead of the buffered events.
+//
+// Records are a 4-byte length and a CRC-32C of the msgpack event that
+// follows. Draining renames the file aside and removes it once the journal
+// has the events, so a failed flush or a crash leaves them to be drained
+// again, at the cost of possible duplicates. A draining file with a
+// corrupt record is renamed to <path>.<unix nanos>.corrupt and skipped,
+// rather than failing every flush after it.
+type Spill struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+func OpenSpill(path string) (*Spill, error) {
+	s := &Spill{path: path}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spill) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	return nil
+}
+
+func (s *Spill) add(ev entity.Event) error {
+	rec, err := ev.MarshalMsg(make([]byte, spillHeaderSize, spillHeaderSize+ev.Msgsize()))
+	if err != nil {
+		return err
+	}
+	if len(rec)-spillHeaderSize > maxSpillRecord {
+		return fmt.Errorf("spill: event of %d bytes is over %d", len(rec)-spillHeaderSize, maxSpillRecord)
+	}
+	binary.BigEndian.PutUint32(rec, uint32(len(rec)-spillHeaderSize))
+	binary.BigEndian.PutUint32(rec[4:], crc32.Checksum(rec[spillHeaderSize:], spillCRCTable))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(rec); err != nil {
+		return err
+	}
+	eventsSpilled.Inc()
+	return nil
+}
+
+// drain returns the spilled events, oldest first. The events stay on disk
+// until commit; until then, drain keeps returning them.
+func (s *Spill) drain() ([]entity.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	draining := s.path + drainingSuffix
+	if _, err := os.Stat(draining); errors.Is(err, os.ErrNotExist) {
+		if err := s.w.Flush(); err != nil {
+			return nil, err
+		}
+		if fi, err := s.f.Stat(); err != nil || fi.Size() == 0 {
+			return nil, err
+		}
+		if err := s.f.Close(); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(s.path, draining); err != nil {
+			return nil, err
+		}
+		if err := s.open(); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(draining)
+	if err != nil {
+		return nil, err
+	}
+	events, err := readSpill(bufio.NewReader(f))
+	_ = f.Close()
+	if errors.Is(err, errSpillCorrupt) {
+		return nil, s.quarantine(draining, err)
+	}
+	return events, err
+}
+
+// quarantine moves a draining file with a corrupt record out of the way,
+// keeping it for inspection.
+func (s *Spill) quarantine(draining string, cause error) error {
+	corrupt := s.path + "." + strconv.FormatInt(time.Now().UnixNano(), 10) + corruptSuffix
+	if err := os.Rename(draining, corrupt); err != nil {
+		return err
+	}
+	spillCorrupt.Inc()
+	slog.Error("spill file corrupt, set aside undrained", "file", corrupt, "error", cause)
+	return nil
+}
+
+// commit drops the events the last drain returned.
+func (s *Spill) commit() error {
+	err := os.Remove(s.path + drainingSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *Spill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		_ = s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// readSpill decodes records up to the end of r. A torn record at the end,
+// left by a crash mid-write, is dropped; any other bad record fails with
+// errSpillCorrupt.
+func readSpill(r *bufio.Reader) ([]entity.Event, error) {
+	var events []entity.Event
+	var header [spillHeaderSize]byte
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return events, nil
+			}
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		if n > maxSpillRecord {
+			return nil, fmt.Errorf("%w %d: length %d", errSpillCorrupt, i, n)
+		}
+		rec := make([]byte, n)
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return events, nil
+			}
+			return nil, err
+		}
+		if crc32.Checksum(rec, spillCRCTable) != binary.BigEndian.Uint32(header[4:]) {
+			return nil, fmt.Errorf("%w %d: checksum mismatch", errSpillCorrupt, i)
+		}
+		var ev entity.Event
+		if _, err := ev.UnmarshalMsg(rec); err != nil {
+			return nil, fmt.Errorf("%w %d: %w", errSpillCorrupt, i, err)
+		}
+		events = append(events, ev)
+	}
+}
@@ -0,0 +1,166 @@
+package sink
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func batchKeys(keys *[]string) func([]journal.Entry) ([]uint64, error) {
+	return func(entries []journal.Entry) ([]uint64, error) {
+		*keys = (*keys)[:0]
+		for _, e := range entries {
+			*keys = append(*keys, string(e.Key))
+		}
+		return nil, nil
+	}
+}
+
+func TestSpill(t *testing.T) {
+	t.Run("overflow is drained on flush", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "spill")
+		sp, err := OpenSpill(path)
+		require.NoError(t, err)
+		defer sp.Close()
+
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		s := New(j, WithBufSize(2), WithSpill(sp))
+
+		// no journal.Write on overflow
+		for ts := range 4 {
+			require.NoError(t, s.Append(event("temp", 1, int64(ts+1))))
+		}
+
+		var keys []string
+		j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(batchKeys(&keys))
+		require.NoError(t, s.flush())
+		assert.Equal(t, []string{
+			"sensor_temp{ts=1}", "sensor_temp{ts=2}", // spilled, oldest first
+			"sensor_temp{ts=4}", "sensor_temp{ts=3}",
+		}, keys)
+
+		_, err = os.Stat(path + drainingSuffix)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		spilled, err := sp.drain()
+		require.NoError(t, err)
+		assert.Empty(t, spilled)
+	})
+
+	t.Run("failed flush keeps spilled and buffered events", func(t *testing.T) {
+		sp, err := OpenSpill(filepath.Join(t.TempDir(), "spill"))
+		require.NoError(t, err)
+		defer sp.Close()
+
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		s := New(j, WithBufSize(1), WithSpill(sp))
+		require.NoError(t, s.Append(event("temp", 1, 1)))
+		require.NoError(t, s.Append(event("temp", 1, 2)))
+
+		j.EXPECT().WriteBatch(gomock.Any()).Return(nil, errors.New("fsync stalled"))
+		assert.Error(t, s.flush())
+
+		// buffered while the failed batch was pending
+		require.NoError(t, s.Append(event("temp", 1, 3)))
+
+		var keys []string
+		j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(batchKeys(&keys))
+		require.NoError(t, s.flush())
+		assert.Equal(t, []string{"sensor_temp{ts=1}", "sensor_temp{ts=2}", "sensor_temp{ts=3}"}, keys)
+
+		j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(batchKeys(&keys))
+		require.NoError(t, s.flush())
+		assert.Empty(t, keys)
+	})
+
+	t.Run("partly written flush doesn't drain the spill again", func(t *testing.T) {
+		sp, err := OpenSpill(filepath.Join(t.TempDir(), "spill"))
+		require.NoError(t, err)
+		defer sp.Close()
+
+		ctrl := gomock.NewController(t)
+		j := NewMockJournal(ctrl)
+		s := New(j, WithBufSize(1), WithSpill(sp))
+		require.NoError(t, s.Append(event("temp", 1, 1)))
+		require.NoError(t, s.Append(event("temp", 1, 2)))
+		require.NoError(t, s.Append(event("temp", 1, 3)))
+
+		// the oldest spilled event made it, then the batch failed
+		j.EXPECT().WriteBatch(gomock.Len(3)).Return([]uint64{1}, errors.New("rotation failed"))
+		assert.Error(t, s.flush())
+
+		var keys []string
+		j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(batchKeys(&keys))
+		require.NoError(t, s.flush())
+		assert.Equal(t, []string{"sensor_temp{ts=2}", "sensor_temp{ts=3}"}, keys)
+	})
+
+	t.Run("survives restart and torn tail", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "spill")
+		sp, err := OpenSpill(path)
+		require.NoError(t, err)
+		require.NoError(t, sp.add(event("temp", 1, 1)))
+		require.NoError(t, sp.add(event("temp", 1, 2)))
+		require.NoError(t, sp.Close())
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.Write([]byte{0, 0, 0, 42, 1, 2})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		sp, err = OpenSpill(path)
+		require.NoError(t, err)
+		defer sp.Close()
+		spilled, err := sp.drain()
+		require.NoError(t, err)
+		require.Len(t, spilled, 2)
+		assert.Equal(t, []int64{1, 2}, []int64{spilled[0].UnixTimestamp, spilled[1].UnixTimestamp})
+	})
+
+	t.Run("corrupt file is set aside", func(t *testing.T) {
+		for name, corrupt := range map[string]func(b []byte){
+			"checksum": func(b []byte) { b[len(b)-1] ^= 0xff },
+			"length":   func(b []byte) { b[0] = 0xff },
+		} {
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				path := filepath.Join(dir, "spill")
+				sp, err := OpenSpill(path)
+				require.NoError(t, err)
+				defer sp.Close()
+				require.NoError(t, sp.add(event("temp", 1, 1)))
+				_, err = sp.drain()
+				require.NoError(t, err)
+				b, err := os.ReadFile(path + drainingSuffix)
+				require.NoError(t, err)
+				corrupt(b)
+				require.NoError(t, os.WriteFile(path+drainingSuffix, b, 0644))
+
+				ctrl := gomock.NewController(t)
+				j := NewMockJournal(ctrl)
+				s := New(j, WithBufSize(2), WithSpill(sp))
+				require.NoError(t, s.Append(event("temp", 1, 2)))
+
+				var keys []string
+				j.EXPECT().WriteBatch(gomock.Any()).DoAndReturn(batchKeys(&keys))
+				require.NoError(t, s.flush(), "buffered events still flush")
+				assert.Equal(t, []string{"sensor_temp{ts=2}"}, keys)
+
+				quarantined, err := filepath.Glob(filepath.Join(dir, "spill.*"+corruptSuffix))
+				require.NoError(t, err)
+				assert.Len(t, quarantined, 1)
+				_, err = os.Stat(path + drainingSuffix)
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			})
+		}
+	})
+}
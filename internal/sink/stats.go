@@ -0,0 +1,207 @@
+package sink
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// The sliding window is kept as this many buckets, so it moves in steps of
+// window/statsBuckets.
+const statsBuckets = 60
+
+// SensorStats is what the /sensors endpoint reports for one sensor.
+type SensorStats struct {
+	Sensor string `json:"sensor"`
+	// Count is every event recorded for the sensor, LastSeen the newest
+	// event timestamp in Unix milliseconds.
+	Count    int64       `json:"count"`
+	LastSeen int64       `json:"last_seen"`
+	Window   WindowStats `json:"window"`
+	// Health and Device are filled in by Health, when it's enabled.
+	Health string `json:"health,omitempty"`
+	Device string `json:"device,omitempty"`
+}
+
+// WindowStats summarizes the values of events within the sliding window.
+// Min, Max and Mean are zero when Count is. Multi-field events count
+// towards SensorStats but not here.
+type WindowStats struct {
+	Count int64   `json:"count"`
+	Min   int     `json:"min"`
+	Max   int     `json:"max"`
+	Mean  float64 `json:"mean"`
+}
+
+type StatsReport struct {
+	Window  string        `json:"window"`
+	Sensors []SensorStats `json:"sensors"`
+}
+
+type statsBucket struct {
+	idx   int64 // which window/statsBuckets interval since the epoch
+	count int64
+	sum   int64
+	min   int
+	max   int
+}
+
+type sensorStats struct {
+	count    int64
+	lastSeen int64
+	buckets  [statsBuckets]statsBucket
+}
+
+// Stats keeps running per-sensor counts, the last time each sensor was
+// heard from and min/max/mean over a sliding window, answering "is sensor
+// X alive?" at a glance. Events are placed in the window by their own
+// timestamp, so a rebuild from the journal after a restart lands them
+// where they were.
+type Stats struct {
+	mu       sync.Mutex
+	window   time.Duration
+	bucketMs int64
+	sensors  map[string]*sensorStats
+	now      func() time.Time
+}
+
+func NewStats(window time.Duration) *Stats {
+	return &Stats{
+		window:   window,
+		bucketMs: max(window.Milliseconds()/statsBuckets, 1),
+		sensors:  make(map[string]*sensorStats),
+		now:      time.Now,
+	}
+}
+
+// Middleware records events the rest of the chain accepted; put it last.
+func (st *Stats) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			if err := next(ev); err != nil {
+				return err
+			}
+			st.Observe(ev)
+			return nil
+		}
+	}
+}
+
+func (st *Stats) Observe(ev entity.Event) {
+	if IsCanary(ev) {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sensors[ev.Sensor]
+	if !ok {
+		s = &sensorStats{}
+		st.sensors[ev.Sensor] = s
+		registerSensorMetrics(st, ev.Sensor)
+	}
+	s.count++
+	s.lastSeen = max(s.lastSeen, ev.UnixTimestamp)
+	if len(ev.Fields) > 0 {
+		return // no single value to summarize
+	}
+
+	idx := ev.UnixTimestamp / st.bucketMs
+	b := &s.buckets[idx%statsBuckets]
+	if b.idx != idx || b.count == 0 {
+		if b.idx > idx && b.count > 0 {
+			return // older than what the slot holds now
+		}
+		*b = statsBucket{idx: idx, min: ev.Value, max: ev.Value}
+	}
+	b.count++
+	b.sum += int64(ev.Value)
+	b.min = min(b.min, ev.Value)
+	b.max = max(b.max, ev.Value)
+}
+
+// Rebuild replays the journal's events into the stats, whatever their key
+// layout, until ctx is done.
+func (st *Stats) Rebuild(ctx context.Context, j *journal.Journal) error {
+	return rebuild(ctx, j, "sensor stats", st.ObserveEntry)
+}
+
+// ObserveEntry records the event journaled as e, such as one replicated
+// from another sink. Entries whose key isn't an event key are ignored.
+func (st *Stats) ObserveEntry(e *journal.Entry) error {
+	if _, _, err := DecodeKey(e.Key); err != nil {
+		return nil
+	}
+	ev, err := DecodeValue(e.Value)
+	if err != nil {
+		return err
+	}
+	st.Observe(ev)
+	return nil
+}
+
+// Report returns the stats of every sensor, sorted by name.
+func (st *Stats) Report() StatsReport {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	r := StatsReport{
+		Window:  st.window.String(),
+		Sensors: make([]SensorStats, 0, len(st.sensors)),
+	}
+	for name, s := range st.sensors {
+		r.Sensors = append(r.Sensors, st.sensorReport(name, s))
+	}
+	sort.Slice(r.Sensors, func(i, j int) bool { return r.Sensors[i].Sensor < r.Sensors[j].Sensor })
+	return r
+}
+
+func (st *Stats) sensorReport(name string, s *sensorStats) SensorStats {
+	out := SensorStats{Sensor: name, Count: s.count, LastSeen: s.lastSeen}
+
+	oldest := st.now().UnixMilli()/st.bucketMs - statsBuckets + 1
+	w := &out.Window
+	var sum int64
+	w.Min, w.Max = math.MaxInt, math.MinInt
+	for _, b := range s.buckets {
+		if b.count == 0 || b.idx < oldest {
+			continue
+		}
+		w.Count += b.count
+		sum += b.sum
+		w.Min = min(w.Min, b.min)
+		w.Max = max(w.Max, b.max)
+	}
+	if w.Count == 0 {
+		w.Min, w.Max = 0, 0
+		return out
+	}
+	w.Mean = float64(sum) / float64(w.Count)
+	return out
+}
+
+// LastSeenTimes returns the newest event timestamp of every sensor, in
+// Unix milliseconds.
+func (st *Stats) LastSeenTimes() map[string]int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	seen := make(map[string]int64, len(st.sensors))
+	for name, s := range st.sensors {
+		seen[name] = s.lastSeen
+	}
+	return seen
+}
+
+func (st *Stats) lastSeen(sensor string) float64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if s, ok := st.sensors[sensor]; ok {
+		return float64(s.lastSeen) / 1000
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestStats(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ev := func(sensor string, ago time.Duration, val int) entity.Event {
+		return entity.Event{Sensor: sensor, Value: val, UnixTimestamp: now.Add(-ago).UnixMilli()}
+	}
+
+	t.Run("window", func(t *testing.T) {
+		st := NewStats(time.Hour)
+		st.now = func() time.Time { return now }
+
+		st.Observe(ev("temp", 2*time.Hour, 100)) // outside the window
+		st.Observe(ev("temp", 30*time.Minute, 10))
+		st.Observe(ev("temp", time.Minute, 20))
+		st.Observe(ev("temp", 0, 60))
+		st.Observe(ev("door", 5*time.Minute, 1))
+		// counted and seen, but without a value for the window
+		st.Observe(entity.Event{Sensor: "door", Fields: map[string]float64{"open": 1}, UnixTimestamp: now.Add(-10 * time.Minute).UnixMilli()})
+
+		r := st.Report()
+		assert.Equal(t, "1h0m0s", r.Window)
+		require.Len(t, r.Sensors, 2)
+		assert.Equal(t, SensorStats{
+			Sensor:   "door",
+			Count:    2,
+			LastSeen: now.Add(-5 * time.Minute).UnixMilli(),
+			Window:   WindowStats{Count: 1, Min: 1, Max: 1, Mean: 1},
+		}, r.Sensors[0])
+		assert.Equal(t, SensorStats{
+			Sensor:   "temp",
+			Count:    4,
+			LastSeen: now.UnixMilli(),
+			Window:   WindowStats{Count: 3, Min: 10, Max: 60, Mean: 30},
+		}, r.Sensors[1])
+	})
+
+	t.Run("window slides", func(t *testing.T) {
+		st := NewStats(time.Hour)
+		st.now = func() time.Time { return now }
+		st.Observe(ev("temp", 0, 5))
+
+		st.now = func() time.Time { return now.Add(2 * time.Hour) }
+		s := st.Report().Sensors[0]
+		assert.Equal(t, int64(1), s.Count)
+		assert.Equal(t, WindowStats{}, s.Window)
+
+		// a newer event reusing the slot replaces what it held
+		st.Observe(entity.Event{Sensor: "temp", Value: 7, UnixTimestamp: now.Add(2 * time.Hour).UnixMilli()})
+		assert.Equal(t, WindowStats{Count: 1, Min: 7, Max: 7, Mean: 7}, st.Report().Sensors[0].Window)
+	})
+
+	t.Run("middleware skips rejected events", func(t *testing.T) {
+		st := NewStats(time.Hour)
+		errRejected := errors.New("rejected")
+		h := st.Middleware()(func(ev entity.Event) error {
+			if ev.Value < 0 {
+				return errRejected
+			}
+			return nil
+		})
+
+		require.NoError(t, h(ev("temp", 0, 1)))
+		require.ErrorIs(t, h(ev("temp", 0, -1)), errRejected)
+		assert.Equal(t, int64(1), st.Report().Sensors[0].Count)
+	})
+}
+
+func TestStatsRebuild(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i, val := range []int{3, 9, 6} {
+		e := entity.Event{Sensor: "temp", Value: val, UnixTimestamp: now.Add(time.Duration(i-3) * time.Second).UnixMilli()}
+		data, err := e.MarshalMsg(nil)
+		require.NoError(t, err)
+		_, err = j.Write(fmt.Appendf(nil, "sensor_%s{ts=%d}", e.Sensor, e.UnixTimestamp), data)
+		require.NoError(t, err)
+	}
+	_, err = j.Write([]byte("other"), []byte("not an event"))
+	require.NoError(t, err)
+	require.NoError(t, j.Sync())
+
+	st := NewStats(time.Hour)
+	require.NoError(t, st.Rebuild(context.Background(), j))
+
+	r := st.Report()
+	require.Len(t, r.Sensors, 1)
+	assert.Equal(t, int64(3), r.Sensors[0].Count)
+	assert.Equal(t, WindowStats{Count: 3, Min: 3, Max: 9, Mean: 6}, r.Sensors[0].Window)
+}
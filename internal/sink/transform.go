@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+var ErrInvalidTransform = errors.New("invalid transform rule")
+
+// TransformRule rewrites events from sensors whose name matches Match.
+// Steps run in order: rename, scale, clamp. Zero values leave the
+// corresponding step out.
+type TransformRule struct {
+	// Name labels the rule in metrics; defaults to Match.
+	Name string
+	// Match is a regular expression on the sensor name.
+	Match string
+	// Rename replaces the matched part of the sensor name and may refer
+	// to capture groups, e.g. "temp_$1".
+	Rename string
+	// Scale and Offset convert the value to Value*Scale + Offset, rounded
+	// to the nearest integer. Scale 0 is treated as 1. °F to °C is
+	// Scale 0.5556, Offset -17.78.
+	Scale  float64
+	Offset float64
+	// Min and Max clamp the value after scaling.
+	Min *int
+	Max *int
+}
+
+type transform struct {
+	TransformRule
+	re *regexp.Regexp
+}
+
+// Transformer normalizes events before they're buffered, so a fleet with
+// a misnamed sensor or the wrong unit can be fixed in config instead of
+// firmware. Every matching rule applies, in order, each one seeing the
+// result of the previous.
+type Transformer struct {
+	rules []transform
+}
+
+func NewTransformer(rules []TransformRule) (*Transformer, error) {
+	t := &Transformer{rules: make([]transform, 0, len(rules))}
+	for i, r := range rules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rule %d: %w", ErrInvalidTransform, i, err)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return nil, fmt.Errorf("%w: rule %d: min %d is above max %d", ErrInvalidTransform, i, *r.Min, *r.Max)
+		}
+		if r.Name == "" {
+			r.Name = r.Match
+		}
+		t.rules = append(t.rules, transform{TransformRule: r, re: re})
+	}
+	return t, nil
+}
+
+func (t *Transformer) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ev entity.Event) error {
+			for i := range t.rules {
+				t.rules[i].apply(&ev)
+			}
+			return next(ev)
+		}
+	}
+}
+
+func (r *transform) apply(ev *entity.Event) {
+	if !r.re.MatchString(ev.Sensor) {
+		return
+	}
+	transformApplied(r.Name).Inc()
+
+	if r.Rename != "" {
+		ev.Sensor = r.re.ReplaceAllString(ev.Sensor, r.Rename)
+	}
+	if r.Scale != 0 || r.Offset != 0 {
+		scale := r.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		ev.Value = int(math.Round(float64(ev.Value)*scale + r.Offset))
+	}
+	if r.Min != nil && ev.Value < *r.Min {
+		ev.Value = *r.Min
+		transformClamped(r.Name).Inc()
+	}
+	if r.Max != nil && ev.Value > *r.Max {
+		ev.Value = *r.Max
+		transformClamped(r.Name).Inc()
+	}
+}
@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+func TestTransformer(t *testing.T) {
+	intp := func(n int) *int { return &n }
+
+	tr, err := NewTransformer([]TransformRule{
+		{Match: `^thermo-(\d+)$`, Rename: "temp-$1"},
+		{Name: "fahrenheit", Match: `^temp-`, Scale: 0.5556, Offset: -17.78},
+		{Match: `^humidity`, Min: intp(0), Max: intp(100)},
+	})
+	require.NoError(t, err)
+
+	var got entity.Event
+	h := tr.Middleware()(func(ev entity.Event) error {
+		got = ev
+		return nil
+	})
+
+	f := func(in entity.Event, sensor string, value int) {
+		t.Helper()
+		require.NoError(t, h(in))
+		assert.Equal(t, sensor, got.Sensor)
+		assert.Equal(t, value, got.Value)
+	}
+
+	f(entity.Event{Sensor: "thermo-7", Value: 212}, "temp-7", 100)   // renamed, then converted
+	f(entity.Event{Sensor: "temp-1", Value: 32}, "temp-1", 0)        // converted only
+	f(entity.Event{Sensor: "humidity", Value: 140}, "humidity", 100) // clamped
+	f(entity.Event{Sensor: "humidity", Value: -3}, "humidity", 0)
+	f(entity.Event{Sensor: "pressure", Value: 1013}, "pressure", 1013) // untouched
+}
+
+func TestTransformerRejectsBadRules(t *testing.T) {
+	lo, hi := 10, 5
+
+	_, err := NewTransformer([]TransformRule{{Match: `(`}})
+	assert.ErrorIs(t, err, ErrInvalidTransform)
+
+	_, err = NewTransformer([]TransformRule{{Match: `.`, Min: &lo, Max: &hi}})
+	assert.ErrorIs(t, err, ErrInvalidTransform)
+}
@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+var (
+	ErrBadValue = errors.New("not an event value")
+	// ErrValueVersion means an event was journaled by a newer sink than
+	// the one reading it.
+	ErrValueVersion = errors.New("unsupported event value version")
+)
+
+// Journaled event values start with a version byte saying how the rest is
+// encoded, so a change to entity.Event that msgpack's skipping of unknown
+// fields can't absorb, such as a field changing type, gets a new version
+// and a decoder for it while old segments stay readable. Values written
+// before versioning are a bare msgpack map, whose header no version byte
+// collides with.
+const (
+	// valueV1 is the event as msgpack.
+	valueV1 = 0x01
+
+	valueCurrent = valueV1
+)
+
+// valueDecoders decode the body of a value of each version into the
+// current entity.Event, migrating whatever changed since.
+var valueDecoders = map[byte]func(body []byte, ev *entity.Event) error{
+	valueV1: decodeMsgpackValue,
+}
+
+// EncodeValue appends the journaled form of ev to b.
+func EncodeValue(b []byte, ev *entity.Event) ([]byte, error) {
+	return ev.MarshalMsg(append(b, valueCurrent))
+}
+
+// DecodeValue reads an event value journaled by this or any earlier
+// version of the sink, versioned or not.
+func DecodeValue(v []byte) (entity.Event, error) {
+	var ev entity.Event
+	if len(v) == 0 {
+		return ev, ErrBadValue
+	}
+	if isMsgpackMap(v[0]) {
+		return ev, decodeMsgpackValue(v, &ev)
+	}
+	dec, ok := valueDecoders[v[0]]
+	if !ok {
+		return ev, fmt.Errorf("%w: %d", ErrValueVersion, v[0])
+	}
+	return ev, dec(v[1:], &ev)
+}
+
+func decodeMsgpackValue(body []byte, ev *entity.Event) error {
+	if _, err := ev.UnmarshalMsg(body); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadValue, err)
+	}
+	return nil
+}
+
+// isMsgpackMap reports whether b starts a msgpack fixmap, map16 or map32,
+// as unversioned values do.
+func isMsgpackMap(b byte) bool {
+	return b&0xf0 == 0x80 || b == 0xde || b == 0xdf
+}
+
+// EncodeDeviceValue appends the journaled form of info to b, versioned
+// like an event value.
+func EncodeDeviceValue(b []byte, info *entity.DeviceInfo) ([]byte, error) {
+	return info.MarshalMsg(append(b, valueCurrent))
+}
+
+// DecodeDeviceValue reads a DeviceInfo value. There are no unversioned
+// ones.
+func DecodeDeviceValue(v []byte) (entity.DeviceInfo, error) {
+	var info entity.DeviceInfo
+	if len(v) == 0 {
+		return info, ErrBadValue
+	}
+	if v[0] != valueV1 {
+		return info, fmt.Errorf("%w: %d", ErrValueVersion, v[0])
+	}
+	if _, err := info.UnmarshalMsg(v[1:]); err != nil {
+		return info, fmt.Errorf("%w: %w", ErrBadValue, err)
+	}
+	return info, nil
+}
@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+func TestValueCodec(t *testing.T) {
+	ev := entity.Event{
+		IdempotencyID: "0b1c",
+		Sensor:        "env-3",
+		UnixTimestamp: 1717243200000,
+		Fields:        map[string]float64{"temp": 22.5, "hum": 48},
+	}
+
+	v, err := EncodeValue(nil, &ev)
+	require.NoError(t, err)
+	assert.Equal(t, byte(valueV1), v[0])
+	got, err := DecodeValue(v)
+	require.NoError(t, err)
+	assert.Equal(t, ev, got)
+
+	t.Run("unversioned", func(t *testing.T) {
+		old := entity.Event{Sensor: "temp", Value: 42, UnixTimestamp: 1000}
+		v, err := old.MarshalMsg(nil)
+		require.NoError(t, err)
+		got, err := DecodeValue(v)
+		require.NoError(t, err)
+		assert.Equal(t, old, got)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		_, err := DecodeValue([]byte{0x7f, 0x80})
+		assert.ErrorIs(t, err, ErrValueVersion)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, v := range [][]byte{nil, {valueV1}, {valueV1, 0x81}, {0x81, 0xa1}} {
+			_, err := DecodeValue(v)
+			assert.ErrorIs(t, err, ErrBadValue, "%x", v)
+		}
+	})
+}
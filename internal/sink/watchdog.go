@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"time"
+
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+var ErrInvalidWatchdog = errors.New("invalid memory watchdog limits")
+
+// Watchdog keeps a sink on a small gateway ahead of the OOM killer. Every
+// check past the soft limit runs the relief hooks, e.g. shrinking the dedup
+// set and flushing early, then returns freed memory to the OS; past the
+// hard limit its middleware also rejects events with apperr.ErrOverloaded
+// until usage drops back under it.
+type Watchdog struct {
+	soft, hard uint64
+	shed       shedder
+	read       func() uint64
+}
+
+// NewWatchdog watches the memory held by the process against soft and
+// hard, in bytes. A zero soft limit leaves only the hard one.
+func NewWatchdog(soft, hard uint64, hooks ...func()) (*Watchdog, error) {
+	if hard == 0 || soft > hard {
+		return nil, ErrInvalidWatchdog
+	}
+	w := &Watchdog{
+		soft: soft,
+		hard: hard,
+		shed: shedder{hooks: hooks, err: apperr.ErrOverloaded, gauge: memoryRejecting, rejected: memoryRejected},
+		read: memoryInUse,
+	}
+	if w.soft == 0 {
+		w.soft = hard
+	}
+	return w, nil
+}
+
+// memoryInUse is what the Go runtime has mapped from the OS and not given
+// back, which for this process is close to its RSS.
+func memoryInUse() uint64 {
+	s := []rtmetrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	rtmetrics.Read(s)
+	return s[0].Value.Uint64() - s[1].Value.Uint64()
+}
+
+// Run checks memory every interval until ctx is done.
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) error {
+	return w.shed.run(ctx, interval, w.Check)
+}
+
+// Check reads memory usage and acts on it.
+func (w *Watchdog) Check() {
+	used := w.read()
+	memoryUsage.Set(float64(used))
+	if used < w.soft {
+		w.setRejecting(false, used)
+		return
+	}
+
+	memoryPressure.Inc()
+	slog.Warn("memory above soft limit, shedding", "used", used, "soft_limit", w.soft)
+	w.shed.relieve()
+	debug.FreeOSMemory()
+
+	used = w.read()
+	memoryUsage.Set(float64(used))
+	w.setRejecting(used >= w.hard, used)
+}
+
+func (w *Watchdog) setRejecting(on bool, used uint64) {
+	switch {
+	case !w.shed.setRejecting(on):
+	case on:
+		slog.Error("memory above hard limit, rejecting events", "used", used, "hard_limit", w.hard)
+	default:
+		slog.Info("memory back under hard limit, accepting events", "used", used)
+	}
+}
+
+// Middleware rejects events while memory is over the hard limit.
+func (w *Watchdog) Middleware() Middleware {
+	return w.shed.middleware()
+}
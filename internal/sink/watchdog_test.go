@@ -0,0 +1,56 @@
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+func TestWatchdog(t *testing.T) {
+	var (
+		used  uint64
+		freed uint64 // what the hooks give back
+		calls int
+	)
+	w, err := NewWatchdog(300, 400, func() {
+		calls++
+		used -= freed
+	})
+	require.NoError(t, err)
+	w.read = func() uint64 { return used }
+
+	h := w.Middleware()(func(entity.Event) error { return nil })
+
+	used = 200
+	w.Check()
+	assert.Zero(t, calls)
+	assert.NoError(t, h(event("temp", 1, 1)))
+
+	t.Run("soft limit runs hooks", func(t *testing.T) {
+		used, freed = 350, 100
+		w.Check()
+		assert.Equal(t, 1, calls)
+		assert.NoError(t, h(event("temp", 1, 1)))
+	})
+
+	t.Run("hard limit rejects until relieved", func(t *testing.T) {
+		used, freed = 500, 0
+		w.Check()
+		assert.ErrorIs(t, h(event("temp", 1, 1)), apperr.ErrOverloaded)
+
+		used, freed = 450, 200
+		w.Check()
+		assert.NoError(t, h(event("temp", 1, 1)))
+	})
+
+	t.Run("invalid limits", func(t *testing.T) {
+		_, err := NewWatchdog(500, 400)
+		assert.ErrorIs(t, err, ErrInvalidWatchdog)
+		_, err = NewWatchdog(0, 0)
+		assert.ErrorIs(t, err, ErrInvalidWatchdog)
+	})
+}
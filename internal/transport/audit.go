@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/audit"
+)
+
+// maxAuditBody caps the request body kept in an audit record.
+const maxAuditBody = 4 << 10
+
+// audited records every request h serves that can change something, so
+// not GET or HEAD, in the audit log as action, with who sent it, its
+// parameters and the status it got, failed ones included.
+// Recording is best-effort: a failed write is logged, and counted by the
+// audit package, but doesn't change the response.
+func (s *Server) audited(action string, h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+		if s.audit == nil || ctx.IsGet() || ctx.IsHead() {
+			return
+		}
+
+		rec := audit.Record{
+			Action: action,
+			Actor:  audit.Actor{RequestID: RequestID(ctx)},
+			Status: ctx.Response.StatusCode(),
+		}
+		if ip := ClientIP(ctx); ip.IsValid() {
+			rec.Actor.Addr = ip.String()
+		}
+		if cs := ctx.TLSConnectionState(); cs != nil && len(cs.PeerCertificates) > 0 {
+			rec.Actor.Cert = cs.PeerCertificates[0].Subject.String()
+		}
+		details := map[string]string{}
+		if q := ctx.QueryArgs().QueryString(); len(q) > 0 {
+			details["query"] = string(q)
+		}
+		if body := ctx.PostBody(); len(body) > 0 {
+			details["body"] = string(body[:min(len(body), maxAuditBody)])
+		}
+		if len(details) > 0 {
+			rec.Details = details
+		}
+
+		if _, err := s.audit.Append(rec); err != nil {
+			reqLog(ctx).Error("audit record failed", "action", action, "error", err)
+		}
+	}
+}
+
+// handleAudit pages through the audit log: ?after=<seq> skips records up
+// to seq and ?limit=<n> caps the page at n, 100 by default.
+func (s *Server) handleAudit(ctx *fasthttp.RequestCtx) {
+	if s.audit == nil {
+		ctx.Error("audit log not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	var after uint64
+	if v := ctx.QueryArgs().Peek("after"); v != nil {
+		n, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			ctx.Error("after must be a sequence number", fasthttp.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	limit := 100
+	if v := ctx.QueryArgs().Peek("limit"); v != nil {
+		n, err := strconv.Atoi(string(v))
+		if err != nil || n < 1 || n > 1000 {
+			ctx.Error("limit must be between 1 and 1000", fasthttp.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	records, intact, err := s.audit.Records(after, limit)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []audit.Record{}
+	}
+	body, err := json.Marshal(struct {
+		Records []audit.Record `json:"records"`
+		Intact  bool           `json:"intact"`
+	}{records, intact})
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
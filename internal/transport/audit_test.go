@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/audit"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestAudit(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+	log, err := audit.New(j, []byte("key"))
+	require.NoError(t, err)
+	srv := New(&mockSink{}, WithJournal(&truncateRecorder{}), WithAudit(log), WithAdminToken(testAdminToken))
+
+	do := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		ctx.Request.Header.Set(RequestIDHeader, "req-1")
+		srv.handle(ctx)
+		return ctx
+	}
+
+	assert.Equal(t, fasthttp.StatusOK, do("POST", "/admin/journal/truncate?before=42").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusBadRequest, do("POST", "/admin/journal/truncate?before=nope").Response.StatusCode())
+	// reads aren't recorded
+	do("GET", "/admin/journal/gaps")
+	do("GET", "/admin/sampling")
+
+	ctx := do("GET", "/admin/audit")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	var page struct {
+		Records []audit.Record `json:"records"`
+		Intact  bool           `json:"intact"`
+	}
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &page))
+	assert.True(t, page.Intact)
+	require.Len(t, page.Records, 2)
+	assert.Equal(t, "journal.truncate", page.Records[0].Action)
+	assert.Equal(t, map[string]string{"query": "before=42"}, page.Records[0].Details)
+	assert.Equal(t, fasthttp.StatusOK, page.Records[0].Status)
+	assert.Equal(t, "req-1", page.Records[0].Actor.RequestID)
+	assert.Equal(t, fasthttp.StatusBadRequest, page.Records[1].Status)
+
+	ctx = do("GET", "/admin/audit?after=1&limit=10")
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &page))
+	require.Len(t, page.Records, 1)
+	assert.Equal(t, uint64(2), page.Records[0].Seq)
+
+	assert.Equal(t, fasthttp.StatusBadRequest, do("GET", "/admin/audit?limit=0").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusBadRequest, do("GET", "/admin/audit?after=x").Response.StatusCode())
+}
+
+func TestAuditNotEnabled(t *testing.T) {
+	srv := New(&mockSink{}, WithAdminToken(testAdminToken))
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/admin/audit")
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+// batchCache remembers recently accepted batches so a gateway that
+// retransmits the same NDJSON file after a connection reset gets its 202
+// back without the events going through dedup, rate limiting and quotas
+// a second time.
+type batchCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]*seenBatch
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+type seenBatch struct {
+	// sum is the body's hash, to tell a replay from a reused key
+	sum [sha256.Size]byte
+	// done is closed once the first copy is processed; result, accepted
+	// and expires are set by then
+	done     chan struct{}
+	result   BatchResult
+	accepted bool
+	expires  time.Time
+}
+
+func newBatchCache(ttl time.Duration) *batchCache {
+	return &batchCache{
+		ttl:  ttl,
+		seen: make(map[string]*seenBatch),
+		now:  time.Now,
+	}
+}
+
+// batchKey prefers the client's Idempotency-Key and falls back to the hash
+// of the body, so byte-identical replays are caught either way.
+func batchKey(idempotencyKey []byte, sum [sha256.Size]byte) string {
+	if len(idempotencyKey) > 0 {
+		return "key:" + string(idempotencyKey)
+	}
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// begin returns the result an earlier copy of the batch got, waiting for
+// one still being processed; replayed is false when there's none, or it
+// failed, and key is then reserved for the caller, who must call finish.
+// A key that came with a different body fails with
+// apperr.ErrIdempotencyConflict.
+func (c *batchCache) begin(key string, sum [sha256.Size]byte) (res BatchResult, replayed bool, err error) {
+	for {
+		c.mu.Lock()
+		b, ok := c.seen[key]
+		if !ok || (b.accepted && !c.now().Before(b.expires)) {
+			c.seen[key] = &seenBatch{sum: sum, done: make(chan struct{})}
+			c.mu.Unlock()
+			return BatchResult{}, false, nil
+		}
+		c.mu.Unlock()
+
+		if b.sum != sum {
+			return BatchResult{}, false, fmt.Errorf("%w: Idempotency-Key was sent with a different batch", apperr.ErrIdempotencyConflict)
+		}
+		<-b.done
+		if b.accepted {
+			return b.result, true, nil
+		}
+		// the first copy failed, so this one gets its turn
+	}
+}
+
+// finish settles the reservation begin made for key: an accepted batch is
+// remembered for the TTL, a failed one forgotten so a retry is processed.
+func (c *batchCache) finish(key string, result BatchResult, accepted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastPurge) > time.Second {
+		for k, b := range c.seen {
+			if b.accepted && now.After(b.expires) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPurge = now
+	}
+	b := c.seen[key]
+	b.result, b.accepted, b.expires = result, accepted, now.Add(c.ttl)
+	if !accepted {
+		delete(c.seen, key)
+	}
+	close(b.done)
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	errBatchQueueFull    = errors.New("too many batches waiting")
+	errBatchQueueTimeout = errors.New("timed out waiting for a batch slot")
+)
+
+// batchLimiter caps how many batches are processed at once, so a burst of
+// large uploads is parsed a few at a time instead of all together. The
+// rest wait in line, up to queue of them and for at most wait each.
+type batchLimiter struct {
+	slots   chan struct{}
+	queue   int64 // 0 = unbounded
+	wait    time.Duration
+	waiting atomic.Int64
+}
+
+func newBatchLimiter(concurrency, queue int, wait time.Duration) *batchLimiter {
+	return &batchLimiter{
+		slots: make(chan struct{}, concurrency),
+		queue: int64(queue),
+		wait:  wait,
+	}
+}
+
+// acquire takes a slot, waiting in line if none is free; release gives it
+// back.
+func (l *batchLimiter) acquire() error {
+	select {
+	case l.slots <- struct{}{}:
+		batchInFlight.Inc()
+		return nil
+	default:
+	}
+
+	if n := l.waiting.Add(1); l.queue > 0 && n > l.queue {
+		l.waiting.Add(-1)
+		batchQueueRejected("full").Inc()
+		return errBatchQueueFull
+	}
+	batchQueueDepth.Inc()
+	defer func() {
+		l.waiting.Add(-1)
+		batchQueueDepth.Dec()
+	}()
+
+	start := time.Now()
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		batchQueueWait.UpdateDuration(start)
+		batchInFlight.Inc()
+		return nil
+	case <-timer.C:
+		batchQueueRejected("timeout").Inc()
+		return errBatchQueueTimeout
+	}
+}
+
+func (l *batchLimiter) release() {
+	<-l.slots
+	batchInFlight.Dec()
+}
+
+// limitBatches runs next within the batch limiter, when there is one,
+// answering 503 to batches that can't get a slot in time.
+func (s *Server) limitBatches(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if s.batchLimit == nil {
+			next(ctx)
+			return
+		}
+		if err := s.batchLimit.acquire(); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+			ctx.Response.Header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(s.batchLimit.wait), 1)))
+			return
+		}
+		defer s.batchLimit.release()
+		next(ctx)
+	}
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestBatchLimiter(t *testing.T) {
+	l := newBatchLimiter(1, 1, 20*time.Millisecond)
+	require.NoError(t, l.acquire())
+
+	// the one waiting in line gets the slot once it's released
+	got := make(chan error, 1)
+	go func() { got <- l.acquire() }()
+	require.Eventually(t, func() bool { return l.waiting.Load() == 1 }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, l.acquire(), errBatchQueueFull)
+	l.release()
+	require.NoError(t, <-got)
+
+	// and times out otherwise
+	assert.ErrorIs(t, l.acquire(), errBatchQueueTimeout)
+	l.release()
+	require.NoError(t, l.acquire())
+}
+
+func TestLimitBatches(t *testing.T) {
+	ms := &mockSink{}
+	srv := New(ms, WithBatchLimit(1, 0, 10*time.Millisecond))
+	post := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetRequestURI("/ingest/batch")
+		ctx.Request.Header.SetContentType("application/x-ndjson")
+		ctx.Request.SetBodyString(`{"sensor":"a","val":1,"ts":1}` + "\n")
+		srv.handle(ctx)
+		return ctx
+	}
+
+	require.NoError(t, srv.batchLimit.acquire())
+	ctx := post()
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.Equal(t, "1", string(ctx.Response.Header.Peek("Retry-After")))
+	assert.Empty(t, ms.events)
+
+	srv.batchLimit.release()
+	assert.Equal(t, fasthttp.StatusAccepted, post().Response.StatusCode())
+	assert.Len(t, ms.events, 1)
+}
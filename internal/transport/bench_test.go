@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// nopSink accepts everything and keeps nothing, so the benchmarks measure
+// the transport alone.
+type nopSink struct{}
+
+func (nopSink) Append(entity.Event) error { return nil }
+
+func benchRequest(b *testing.B, srv *Server, path, contentType string, body []byte) {
+	b.Helper()
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	var ctx fasthttp.RequestCtx
+	for b.Loop() {
+		ctx.Request.Reset()
+		ctx.Response.Reset()
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(contentType)
+		ctx.Request.SetBody(body)
+		srv.handle(&ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+			b.Fatalf("status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+	}
+}
+
+func BenchmarkIngest(b *testing.B) {
+	ev := entity.Event{IdempotencyID: "0b1c", Sensor: "temp-north", Value: 42, UnixTimestamp: 1717243200000}
+	srv := New(nopSink{})
+
+	b.Run("msgpack", func(b *testing.B) {
+		body, _ := ev.MarshalMsg(nil)
+		benchRequest(b, srv, "/ingest", "application/msgpack", body)
+	})
+	b.Run("json", func(b *testing.B) {
+		body, _ := json.Marshal(ev)
+		benchRequest(b, srv, "/ingest", "application/json", body)
+	})
+}
+
+func BenchmarkBatch(b *testing.B) {
+	const size = 256
+	events := make([]entity.Event, size)
+	for i := range events {
+		events[i] = entity.Event{Sensor: "temp-north", Value: i, UnixTimestamp: int64(i)}
+	}
+	srv := New(nopSink{})
+
+	b.Run("ndjson", func(b *testing.B) {
+		var sb strings.Builder
+		for _, ev := range events {
+			line, _ := json.Marshal(ev)
+			sb.Write(line)
+			sb.WriteByte('\n')
+		}
+		benchRequest(b, srv, "/ingest/batch", "application/x-ndjson", []byte(sb.String()))
+	})
+	b.Run("msgpack", func(b *testing.B) {
+		body := msgp.AppendArrayHeader(nil, size)
+		for _, ev := range events {
+			body, _ = ev.MarshalMsg(body)
+		}
+		benchRequest(b, srv, "/ingest/batch", "application/msgpack", body)
+	})
+}
+
+// BenchmarkDecodeNDJSON decodes a 100k-line upload with one worker and
+// with GOMAXPROCS of them.
+func BenchmarkDecodeNDJSON(b *testing.B) {
+	body := ndjsonBody(100_000)
+	for _, workers := range []int{1, 0} {
+		name := "workers=" + strconv.Itoa(workers)
+		if workers == 0 {
+			name = "workers=gomaxprocs"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := decodeNDJSON(body, workers, entity.DecodeJSON); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
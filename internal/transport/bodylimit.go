@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"errors"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// defaultMaxBodySize is fasthttp's own limit, used without WithMaxBodySize.
+const defaultMaxBodySize = fasthttp.DefaultMaxRequestBodySize
+
+// WithMaxBodySize caps request bodies at n bytes, compressed size for
+// compressed bodies. A Content-Length over it is refused before a byte of
+// the body is read, and a chunked body once its chunks add up to more.
+func WithMaxBodySize(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.srv.MaxRequestBodySize = n
+		}
+	}
+}
+
+// continueBody tells a client sending Expect: 100-continue whether to
+// send the body at all. fasthttp answers a refused one with 417.
+func (s *Server) continueBody(h *fasthttp.RequestHeader) bool {
+	if n := h.ContentLength(); n > s.maxBodySize() {
+		bodyTooLarge.Inc()
+		return false
+	}
+	return true
+}
+
+func (s *Server) maxBodySize() int {
+	if s.srv.MaxRequestBodySize > 0 {
+		return s.srv.MaxRequestBodySize
+	}
+	return defaultMaxBodySize
+}
+
+// readError answers requests fasthttp failed to read, before any handler
+// or middleware runs. It's fasthttp's default but for bodies over the
+// limit, which get 413 rather than 400.
+func readError(ctx *fasthttp.RequestCtx, err error) {
+	var small *fasthttp.ErrSmallBuffer
+	var netErr *net.OpError
+	switch {
+	case errors.Is(err, fasthttp.ErrBodyTooLarge):
+		bodyTooLarge.Inc()
+		ctx.Error("request body too large", fasthttp.StatusRequestEntityTooLarge)
+	case errors.As(err, &small):
+		ctx.Error("Too big request header", fasthttp.StatusRequestHeaderFieldsTooLarge)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		ctx.Error("Request timeout", fasthttp.StatusRequestTimeout)
+	default:
+		ctx.Error("Error when parsing request", fasthttp.StatusBadRequest)
+	}
+}
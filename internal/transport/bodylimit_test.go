@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	srv := New(&mockSink{}, WithMaxBodySize(100))
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() { _ = srv.srv.Serve(ln) }()
+
+	// send writes req on a new connection and reads the first response,
+	// without sending more than req: a body the server waits for fails
+	// the test with a timeout rather than getting an answer
+	send := func(t *testing.T, req string) *fasthttp.Response {
+		t.Helper()
+		conn, err := ln.Dial()
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		_, err = conn.Write([]byte(req))
+		require.NoError(t, err)
+		resp := &fasthttp.Response{}
+		require.NoError(t, resp.Read(bufio.NewReader(conn)))
+		return resp
+	}
+
+	t.Run("within the limit", func(t *testing.T) {
+		resp := send(t, "GET /healthz HTTP/1.1\r\nHost: sink\r\nContent-Length: 5\r\n\r\nhello")
+		assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	})
+
+	t.Run("Content-Length over it, body never sent", func(t *testing.T) {
+		before := bodyTooLarge.Get()
+		resp := send(t, "POST /ingest HTTP/1.1\r\nHost: sink\r\nContent-Length: 1000000\r\n\r\n")
+		assert.Equal(t, fasthttp.StatusRequestEntityTooLarge, resp.StatusCode())
+		assert.True(t, resp.ConnectionClose())
+		assert.Equal(t, before+1, bodyTooLarge.Get())
+	})
+
+	t.Run("chunked body running over it", func(t *testing.T) {
+		chunk := strings.Repeat("x", 60)
+		resp := send(t, "POST /ingest HTTP/1.1\r\nHost: sink\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"3c\r\n"+chunk+"\r\n3c\r\n"+chunk+"\r\n")
+		assert.Equal(t, fasthttp.StatusRequestEntityTooLarge, resp.StatusCode())
+	})
+
+	t.Run("Expect: 100-continue", func(t *testing.T) {
+		resp := send(t, "POST /ingest HTTP/1.1\r\nHost: sink\r\nExpect: 100-continue\r\nContent-Length: 1000000\r\n\r\n")
+		assert.Equal(t, fasthttp.StatusExpectationFailed, resp.StatusCode())
+	})
+}
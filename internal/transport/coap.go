@@ -0,0 +1,388 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+// Minimal CoAP (RFC 7252) server for constrained devices that cannot run an
+// HTTP client. Only what ingestion needs is implemented: POST /ingest with a
+// CBOR or msgpack payload, piggybacked ACKs and retransmission handling.
+
+var (
+	ErrCoAPShort   = errors.New("coap: message too short")
+	ErrCoAPVersion = errors.New("coap: unsupported version")
+	ErrCoAPFormat  = errors.New("coap: malformed message")
+)
+
+const (
+	coapVersion = 1
+
+	coapCON = 0
+	coapNON = 1
+	coapACK = 2
+	coapRST = 3
+
+	coapOptionURIPath       = 11
+	coapOptionContentFormat = 12
+
+	coapFormatCBOR = 60
+	// msgpack has no registered content-format, so it lives in the
+	// experimental range (RFC 7252 section 12.3).
+	coapFormatMsgpack = 65000
+
+	coapPayloadMarker = 0xFF
+
+	// EXCHANGE_LIFETIME with default transmission parameters.
+	coapExchangeLifetime = 247 * time.Second
+	coapMaxMessageSize   = 1152
+)
+
+// CoAP response codes, encoded as class<<5 | detail.
+const (
+	coapEmpty               = 0
+	coapPost                = 0<<5 | 2
+	coapCreated             = 2<<5 | 1
+	coapBadRequest          = 4<<5 | 0
+	coapNotFound            = 4<<5 | 4
+	coapMethodNotAllowed    = 4<<5 | 5
+	coapConflict            = 4<<5 | 9
+	coapTooLarge            = 4<<5 | 13
+	coapUnsupportedFormat   = 4<<5 | 15
+	coapUnprocessable       = 4<<5 | 22
+	coapTooManyRequests     = 4<<5 | 29
+	coapInternalServerError = 5<<5 | 0
+	coapServiceUnavailable  = 5<<5 | 3
+)
+
+type coapMessage struct {
+	typ     uint8
+	code    uint8
+	id      uint16
+	token   []byte
+	path    []string
+	format  int // -1 when absent
+	payload []byte
+}
+
+func parseCoAP(b []byte) (*coapMessage, error) {
+	if len(b) < 4 {
+		return nil, ErrCoAPShort
+	}
+	if b[0]>>6 != coapVersion {
+		return nil, ErrCoAPVersion
+	}
+
+	m := &coapMessage{
+		typ:    (b[0] >> 4) & 0x3,
+		code:   b[1],
+		id:     binary.BigEndian.Uint16(b[2:]),
+		format: -1,
+	}
+
+	tkl := int(b[0] & 0xF)
+	if tkl > 8 || len(b) < 4+tkl {
+		return nil, ErrCoAPFormat
+	}
+	m.token = b[4 : 4+tkl]
+
+	pos := 4 + tkl
+	opt := 0
+	for pos < len(b) {
+		if b[pos] == coapPayloadMarker {
+			pos++
+			if pos == len(b) {
+				// marker followed by zero-length payload is a format error
+				return nil, ErrCoAPFormat
+			}
+			m.payload = b[pos:]
+			break
+		}
+
+		delta := int(b[pos] >> 4)
+		length := int(b[pos] & 0xF)
+		pos++
+
+		var err error
+		if delta, pos, err = coapOptionExt(b, pos, delta); err != nil {
+			return nil, err
+		}
+		if length, pos, err = coapOptionExt(b, pos, length); err != nil {
+			return nil, err
+		}
+		if pos+length > len(b) {
+			return nil, ErrCoAPFormat
+		}
+
+		opt += delta
+		val := b[pos : pos+length]
+		pos += length
+
+		switch opt {
+		case coapOptionURIPath:
+			m.path = append(m.path, string(val))
+		case coapOptionContentFormat:
+			m.format = coapUint(val)
+		}
+	}
+
+	return m, nil
+}
+
+func coapOptionExt(b []byte, pos, v int) (int, int, error) {
+	switch v {
+	case 13:
+		if pos >= len(b) {
+			return 0, 0, ErrCoAPFormat
+		}
+		return int(b[pos]) + 13, pos + 1, nil
+	case 14:
+		if pos+1 >= len(b) {
+			return 0, 0, ErrCoAPFormat
+		}
+		return int(binary.BigEndian.Uint16(b[pos:])) + 269, pos + 2, nil
+	case 15:
+		return 0, 0, ErrCoAPFormat
+	}
+	return v, pos, nil
+}
+
+func coapUint(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+// marshal encodes the message. Only the payload is written after the
+// header and token; responses never carry options.
+func (m *coapMessage) marshal() []byte {
+	buf := make([]byte, 4, 4+len(m.token)+1+len(m.payload))
+	buf[0] = coapVersion<<6 | m.typ<<4 | uint8(len(m.token))
+	buf[1] = m.code
+	binary.BigEndian.PutUint16(buf[2:], m.id)
+	buf = append(buf, m.token...)
+	if len(m.payload) > 0 {
+		buf = append(buf, coapPayloadMarker)
+		buf = append(buf, m.payload...)
+	}
+	return buf
+}
+
+type coapResponse struct {
+	data    []byte
+	expires time.Time
+}
+
+type CoAPServer struct {
+	sink     Sink
+	addr     string
+	follower Follower
+	ipFilter *IPFilter
+	nextID   uint16
+	// responses to confirmable requests, replayed on retransmission so a
+	// lost ACK doesn't turn into a duplicate-event rejection
+	recent    map[string]coapResponse
+	lastPurge time.Time
+}
+
+type CoAPOption func(*CoAPServer)
+
+func WithCoAPAddr(addr string) CoAPOption {
+	return func(s *CoAPServer) { s.addr = addr }
+}
+
+// WithCoAPFollower answers 5.03 while f is following, with the leader's
+// URL as diagnostic payload.
+func WithCoAPFollower(f Follower) CoAPOption {
+	return func(s *CoAPServer) { s.follower = f }
+}
+
+// WithCoAPIPFilter drops datagrams from clients f doesn't allow, unparsed
+// and unanswered.
+func WithCoAPIPFilter(f *IPFilter) CoAPOption {
+	return func(s *CoAPServer) { s.ipFilter = f }
+}
+
+func NewCoAP(sink Sink, opts ...CoAPOption) *CoAPServer {
+	s := &CoAPServer{
+		sink:   sink,
+		addr:   ":5683",
+		recent: make(map[string]coapResponse),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *CoAPServer) Run(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("starting coap server", "addr", s.addr)
+
+	go func() {
+		<-ctx.Done()
+		slog.Info("shutting down coap server")
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, coapMaxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if s.ipFilter != nil && !s.ipFilter.Allowed(addrOf(addr)) {
+			coapIPDenied.Inc()
+			continue
+		}
+		if resp := s.handlePacket(addr.String(), buf[:n]); resp != nil {
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				slog.Warn("coap write failed", "remote", addr.String(), "error", err)
+			}
+		}
+	}
+}
+
+func (s *CoAPServer) handlePacket(remote string, b []byte) []byte {
+	coapRequestsTotal.Inc()
+
+	req, err := parseCoAP(b)
+	if err != nil {
+		coapMalformed.Inc()
+		slog.Debug("coap parse error", "remote", remote, "error", err)
+		return nil
+	}
+
+	switch {
+	case req.typ == coapACK || req.typ == coapRST:
+		return nil
+	case req.code == coapEmpty:
+		// CoAP ping
+		if req.typ == coapCON {
+			return (&coapMessage{typ: coapRST, id: req.id}).marshal()
+		}
+		return nil
+	}
+
+	key := remote + "/" + string(binary.BigEndian.AppendUint16(nil, req.id))
+	now := time.Now()
+	if cached, ok := s.recent[key]; ok && now.Before(cached.expires) {
+		coapRetransmits.Inc()
+		return cached.data
+	}
+
+	code, diag := s.serve(req)
+	coapResponsesByCode(code).Inc()
+
+	resp := &coapMessage{
+		code:    code,
+		token:   req.token,
+		payload: []byte(diag),
+	}
+	if req.typ == coapCON {
+		resp.typ = coapACK
+		resp.id = req.id
+	} else {
+		resp.typ = coapNON
+		s.nextID++
+		resp.id = s.nextID
+	}
+
+	data := resp.marshal()
+	s.remember(key, data, now)
+	return data
+}
+
+func (s *CoAPServer) remember(key string, data []byte, now time.Time) {
+	if now.Sub(s.lastPurge) > time.Second {
+		for k, v := range s.recent {
+			if now.After(v.expires) {
+				delete(s.recent, k)
+			}
+		}
+		s.lastPurge = now
+	}
+	s.recent[key] = coapResponse{data: data, expires: now.Add(coapExchangeLifetime)}
+}
+
+func (s *CoAPServer) serve(req *coapMessage) (uint8, string) {
+	if strings.Join(req.path, "/") != "ingest" {
+		return coapNotFound, "not found"
+	}
+	if req.code != coapPost {
+		return coapMethodNotAllowed, "method not allowed"
+	}
+	if len(req.payload) == 0 {
+		return coapBadRequest, "empty body"
+	}
+	if s.sink == nil {
+		slog.Error("sink not configured")
+		return coapInternalServerError, ErrNilSink.Error()
+	}
+	if s.follower != nil {
+		if leader, ok := s.follower.Leader(); ok {
+			followerRejected.Inc()
+			return coapServiceUnavailable, "follower, leader: " + leader
+		}
+	}
+
+	var ev entity.Event
+	switch req.format {
+	case coapFormatCBOR:
+		if err := cbor.Unmarshal(req.payload, &ev); err != nil {
+			return coapBadRequest, err.Error()
+		}
+	case coapFormatMsgpack:
+		if _, err := ev.UnmarshalMsg(req.payload); err != nil {
+			return coapBadRequest, err.Error()
+		}
+	default:
+		return coapUnsupportedFormat, "unsupported content-format"
+	}
+	if len(ev.Fields) > entity.MaxFields {
+		return coapBadRequest, errTooManyFields
+	}
+
+	if err := s.sink.Append(ev); err != nil {
+		switch {
+		case errors.Is(err, apperr.ErrRateLimited), errors.Is(err, apperr.ErrQuotaExceeded):
+			return coapTooManyRequests, ""
+		case errors.Is(err, apperr.ErrDuplicate):
+			return coapConflict, ""
+		case errors.Is(err, apperr.ErrEventTooLarge):
+			return coapTooLarge, err.Error()
+		case errors.Is(err, apperr.ErrTooOld), errors.Is(err, apperr.ErrIdempotencyConflict),
+			errors.Is(err, apperr.ErrInvalidSensorName):
+			return coapUnprocessable, err.Error()
+		case errors.Is(err, apperr.ErrBufferFull), errors.Is(err, apperr.ErrOverloaded):
+			return coapServiceUnavailable, ""
+		case errors.Is(err, apperr.ErrInsufficientStorage):
+			return coapServiceUnavailable, err.Error()
+		default:
+			slog.Error("sink.Append failed", "error", err, "sensor", ev.Sensor)
+			return coapInternalServerError, err.Error()
+		}
+	}
+
+	return coapCreated, ""
+}
+
+func (s *CoAPServer) Addr() string { return s.addr }
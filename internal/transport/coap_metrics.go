@@ -0,0 +1,18 @@
+package transport
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	coapRequestsTotal = metrics.NewCounter("coap_requests_total")
+	coapMalformed     = metrics.NewCounter("coap_malformed_total")
+	coapRetransmits   = metrics.NewCounter("coap_retransmits_total")
+	coapIPDenied      = metrics.NewCounter("coap_ip_denied_total")
+)
+
+func coapResponsesByCode(code uint8) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`coap_responses_total{code="%d.%02d"}`, code>>5, code&0x1F))
+}
@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+	"github.com/andriibeee/iotdemo/internal/replication"
+)
+
+func coapRequest(typ, code uint8, id uint16, path string, format int, payload []byte) []byte {
+	b := []byte{coapVersion<<6 | typ<<4 | 2, code, 0, 0, 0xCA, 0xFE}
+	binary.BigEndian.PutUint16(b[2:], id)
+
+	prev := 0
+	if path != "" {
+		b = append(b, byte((coapOptionURIPath-prev)<<4|len(path)))
+		b = append(b, path...)
+		prev = coapOptionURIPath
+	}
+	if format >= 0 {
+		val := binary.BigEndian.AppendUint16(nil, uint16(format))
+		if format < 256 {
+			val = val[1:]
+		}
+		b = append(b, byte((coapOptionContentFormat-prev)<<4|len(val)))
+		b = append(b, val...)
+	}
+	if len(payload) > 0 {
+		b = append(b, coapPayloadMarker)
+		b = append(b, payload...)
+	}
+	return b
+}
+
+func cborEvent(t *testing.T) []byte {
+	t.Helper()
+	b, err := cbor.Marshal(entity.Event{IdempotencyID: "abc", Sensor: "temp", Value: 42, UnixTimestamp: 1000})
+	require.NoError(t, err)
+	return b
+}
+
+func TestParseCoAP(t *testing.T) {
+	t.Run("options and payload", func(t *testing.T) {
+		m, err := parseCoAP(coapRequest(coapCON, coapPost, 7, "ingest", coapFormatMsgpack, []byte("hi")))
+		require.NoError(t, err)
+
+		assert.Equal(t, uint8(coapCON), m.typ)
+		assert.Equal(t, uint8(coapPost), m.code)
+		assert.Equal(t, uint16(7), m.id)
+		assert.Equal(t, []byte{0xCA, 0xFE}, m.token)
+		assert.Equal(t, []string{"ingest"}, m.path)
+		assert.Equal(t, coapFormatMsgpack, m.format)
+		assert.Equal(t, []byte("hi"), m.payload)
+	})
+
+	t.Run("rejects malformed", func(t *testing.T) {
+		f := func(b []byte, want error) {
+			t.Helper()
+			_, err := parseCoAP(b)
+			assert.ErrorIs(t, err, want)
+		}
+
+		f([]byte{0x40, 0x02}, ErrCoAPShort)
+		f([]byte{0x80, 0x02, 0, 1}, ErrCoAPVersion)
+		f([]byte{0x49, 0x02, 0, 1}, ErrCoAPFormat)                    // tkl > 8
+		f([]byte{0x40, 0x02, 0, 1, 0xB5, 'a'}, ErrCoAPFormat)         // option overruns
+		f([]byte{0x40, 0x02, 0, 1, coapPayloadMarker}, ErrCoAPFormat) // empty payload
+	})
+}
+
+func TestCoAPServe(t *testing.T) {
+	t.Run("cbor event gets created", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := NewCoAP(sink)
+
+		resp, err := parseCoAP(srv.handlePacket("a", coapRequest(coapCON, coapPost, 1, "ingest", coapFormatCBOR, cborEvent(t))))
+		require.NoError(t, err)
+
+		assert.Equal(t, uint8(coapACK), resp.typ)
+		assert.Equal(t, uint8(coapCreated), resp.code)
+		assert.Equal(t, uint16(1), resp.id)
+		assert.Equal(t, []byte{0xCA, 0xFE}, resp.token)
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, "temp", sink.events[0].Sensor)
+		assert.Equal(t, 42, sink.events[0].Value)
+	})
+
+	t.Run("msgpack event gets created", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := NewCoAP(sink)
+		_, body := sampleEvent()
+
+		resp, err := parseCoAP(srv.handlePacket("a", coapRequest(coapNON, coapPost, 1, "ingest", coapFormatMsgpack, body)))
+		require.NoError(t, err)
+
+		assert.Equal(t, uint8(coapNON), resp.typ)
+		assert.Equal(t, uint8(coapCreated), resp.code)
+		assert.Len(t, sink.events, 1)
+	})
+
+	t.Run("retransmission replays response", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := NewCoAP(sink)
+		req := coapRequest(coapCON, coapPost, 9, "ingest", coapFormatCBOR, cborEvent(t))
+
+		first := srv.handlePacket("a", req)
+		second := srv.handlePacket("a", req)
+
+		assert.Equal(t, first, second)
+		assert.Len(t, sink.events, 1)
+	})
+
+	t.Run("maps errors to codes", func(t *testing.T) {
+		f := func(sink Sink, req []byte, want uint8) {
+			t.Helper()
+			resp, err := parseCoAP(NewCoAP(sink).handlePacket("a", req))
+			require.NoError(t, err)
+			assert.Equal(t, want, resp.code)
+		}
+
+		body := cborEvent(t)
+		f(&mockSink{}, coapRequest(coapCON, coapPost, 1, "nope", coapFormatCBOR, body), coapNotFound)
+		f(&mockSink{}, coapRequest(coapCON, 1, 1, "ingest", coapFormatCBOR, body), coapMethodNotAllowed)
+		f(&mockSink{}, coapRequest(coapCON, coapPost, 1, "ingest", coapFormatCBOR, nil), coapBadRequest)
+		f(&mockSink{}, coapRequest(coapCON, coapPost, 1, "ingest", 50, body), coapUnsupportedFormat)
+		f(&mockSink{}, coapRequest(coapCON, coapPost, 1, "ingest", coapFormatCBOR, []byte{0xFF, 0x00}), coapBadRequest)
+		f(&mockSink{err: apperr.ErrDuplicate}, coapRequest(coapCON, coapPost, 1, "ingest", coapFormatCBOR, body), coapConflict)
+		f(&mockSink{err: apperr.ErrRateLimited}, coapRequest(coapCON, coapPost, 1, "ingest", coapFormatCBOR, body), coapTooManyRequests)
+	})
+
+	t.Run("follower refuses ingest", func(t *testing.T) {
+		sink := &mockSink{}
+		var role replication.Role
+		role.Follow("http://gw-01:8080")
+		srv := NewCoAP(sink, WithCoAPFollower(&role))
+
+		resp, err := parseCoAP(srv.handlePacket("a", coapRequest(coapCON, coapPost, 1, "ingest", coapFormatCBOR, cborEvent(t))))
+		require.NoError(t, err)
+		assert.Equal(t, uint8(coapServiceUnavailable), resp.code)
+		assert.Contains(t, string(resp.payload), "http://gw-01:8080")
+		assert.Empty(t, sink.events)
+	})
+
+	t.Run("ping gets reset", func(t *testing.T) {
+		resp, err := parseCoAP(NewCoAP(&mockSink{}).handlePacket("a", []byte{coapVersion << 6, coapEmpty, 0, 5}))
+		require.NoError(t, err)
+		assert.Equal(t, uint8(coapRST), resp.typ)
+		assert.Equal(t, uint16(5), resp.id)
+	})
+}
+
+func TestCoAPIntegration(t *testing.T) {
+	// grab a free port, then hand it to the server
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := pc.LocalAddr().String()
+	require.NoError(t, pc.Close())
+
+	sink := &mockSink{}
+	srv := NewCoAP(sink, WithCoAPAddr(addr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Run(ctx) }()
+
+	conn, err := net.Dial("udp", srv.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := coapRequest(coapCON, coapPost, 3, "ingest", coapFormatCBOR, cborEvent(t))
+	buf := make([]byte, coapMaxMessageSize)
+
+	// the server may not be listening yet, so retransmit like a real client
+	var n int
+	for range 20 {
+		_, err = conn.Write(req)
+		require.NoError(t, err)
+		_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if n, err = conn.Read(buf); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+
+	resp, err := parseCoAP(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, uint8(coapCreated), resp.code)
+	assert.Len(t, sink.received(), 1)
+}
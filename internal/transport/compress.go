@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+// Bodies smaller than this go out as they are; compressing them costs more
+// than it saves.
+const defaultMinCompressSize = 1024
+
+// WithCompression compresses responses of at least minSize bytes (1KiB if
+// zero) with zstd or gzip, whichever the client prefers in Accept-Encoding,
+// zstd on a tie. Streamed bodies are left to setBodyStreamWriter.
+func WithCompression(minSize int) Option {
+	return func(s *Server) {
+		if minSize <= 0 {
+			minSize = defaultMinCompressSize
+		}
+		s.compressMin = minSize
+	}
+}
+
+// compress is the middleware WithCompression installs.
+func (s *Server) compress(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+
+		enc := negotiateEncoding(string(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)))
+		resp := &ctx.Response
+		if enc == "" || ctx.IsHead() || resp.IsBodyStream() || len(resp.Header.ContentEncoding()) > 0 || !compressible(resp.Header.ContentType()) {
+			return
+		}
+
+		body := resp.Body()
+		if len(body) < s.compressMin {
+			return
+		}
+		var out []byte
+		switch enc {
+		case "zstd":
+			out = fasthttp.AppendZstdBytesLevel(nil, body, fasthttp.CompressZstdDefault)
+		case "gzip":
+			out = fasthttp.AppendGzipBytesLevel(nil, body, fasthttp.CompressDefaultCompression)
+		}
+		compressedBytes(enc).Add(len(body) - len(out))
+		resp.SetBodyRaw(out)
+
+		resp.Header.SetContentEncoding(enc)
+		resp.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+		compressedResponses(enc).Inc()
+	}
+}
+
+// setBodyStreamWriter is what streaming handlers use instead of
+// ctx.SetBodyStreamWriter. fasthttp closes a body stream when it is
+// replaced, so the compress middleware cannot wrap one after the fact;
+// instead the stream is compressed here as it is written. Every Flush by sw
+// pushes a compressed chunk to the client, so a long-running stream keeps
+// delivering as it goes. Call it after setting the content type.
+func (s *Server) setBodyStreamWriter(ctx *fasthttp.RequestCtx, sw fasthttp.StreamWriter) {
+	enc := ""
+	if s.compressMin > 0 && compressible(ctx.Response.Header.ContentType()) {
+		enc = negotiateEncoding(string(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)))
+	}
+	if enc == "" {
+		ctx.SetBodyStreamWriter(sw)
+		return
+	}
+
+	ctx.Response.Header.SetContentEncoding(enc)
+	ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+	compressedResponses(enc).Inc()
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		zw, err := newStreamCompressor(w, enc)
+		if err != nil {
+			compressionErrors.Inc()
+			return
+		}
+		bw := bufio.NewWriter(&flushWriter{zw: zw, w: w})
+		sw(bw)
+		if err := bw.Flush(); err != nil {
+			compressionErrors.Inc()
+		}
+		if err := zw.Close(); err != nil {
+			compressionErrors.Inc()
+		}
+		_ = w.Flush()
+	})
+}
+
+type streamCompressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+func newStreamCompressor(w io.Writer, enc string) (streamCompressor, error) {
+	if enc == "zstd" {
+		return zstd.NewWriter(w)
+	}
+	return gzip.NewWriter(w), nil
+}
+
+// flushWriter hands every write to the compressor and flushes it through to
+// the connection, turning a handler's Flush into a compressed chunk on the
+// wire.
+type flushWriter struct {
+	zw streamCompressor
+	w  *bufio.Writer
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.zw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := f.zw.Flush(); err != nil {
+		return n, err
+	}
+	return n, f.w.Flush()
+}
+
+// negotiateEncoding picks zstd or gzip from an Accept-Encoding header,
+// honouring q-values; "" means send the body as is.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if name == "*" {
+			name = "zstd"
+		}
+		if name != "zstd" && name != "gzip" {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "zstd") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+func compressible(contentType []byte) bool {
+	return bytes.HasPrefix(contentType, []byte("text/")) ||
+		bytes.HasPrefix(contentType, []byte("application/json")) ||
+		bytes.HasPrefix(contentType, []byte("application/x-ndjson")) ||
+		bytes.HasPrefix(contentType, []byte("application/jsonl"))
+}
@@ -0,0 +1,115 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	f := func(header, want string) {
+		t.Helper()
+		assert.Equal(t, want, negotiateEncoding(header), header)
+	}
+
+	f("", "")
+	f("identity", "")
+	f("br, deflate", "")
+	f("gzip", "gzip")
+	f("gzip, zstd", "zstd")
+	f("gzip;q=1.0, zstd;q=0.5", "gzip")
+	f("zstd;q=0, gzip", "gzip")
+	f("ZSTD", "zstd")
+	f("*", "zstd")
+	f("gzip;q=nope", "")
+}
+
+func decode(t *testing.T, enc string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch enc {
+	case "zstd":
+		d, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		defer d.Close()
+		r = d
+	case "gzip":
+		g, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = g
+	default:
+		return string(body)
+	}
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestCompression(t *testing.T) {
+	ndjson := strings.Repeat(`{"sensor":"temp","val":21,"ts":1700000000}`+"\n", 100)
+
+	serve := func(accept string, h fasthttp.RequestHandler) *fasthttp.RequestCtx {
+		srv := New(&mockSink{}, WithCompression(0))
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.Header.Set("Accept-Encoding", accept)
+		srv.compress(h)(ctx)
+		return ctx
+	}
+	body := func(ct, b string) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.SetContentType(ct)
+			ctx.SetBodyString(b)
+		}
+	}
+
+	for _, enc := range []string{"zstd", "gzip"} {
+		t.Run(enc, func(t *testing.T) {
+			ctx := serve(enc, body("application/x-ndjson", ndjson))
+			assert.Equal(t, enc, string(ctx.Response.Header.ContentEncoding()))
+			assert.Equal(t, "Accept-Encoding", string(ctx.Response.Header.Peek("Vary")))
+			assert.Less(t, len(ctx.Response.Body()), len(ndjson))
+			assert.Equal(t, ndjson, decode(t, enc, ctx.Response.Body()))
+		})
+	}
+
+	t.Run("left alone", func(t *testing.T) {
+		f := func(accept string, h fasthttp.RequestHandler) {
+			t.Helper()
+			ctx := serve(accept, h)
+			assert.Empty(t, ctx.Response.Header.ContentEncoding())
+		}
+		f("", body("application/x-ndjson", ndjson))
+		f("gzip", body("application/x-ndjson", "{}"))  // too small
+		f("gzip", body("application/msgpack", ndjson)) // not compressible
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		srv := New(&mockSink{}, WithCompression(0))
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.Header.Set("Accept-Encoding", "zstd")
+		srv.compress(func(ctx *fasthttp.RequestCtx) {
+			ctx.SetContentType("application/x-ndjson")
+			srv.setBodyStreamWriter(ctx, func(w *bufio.Writer) {
+				for range 3 {
+					_, _ = w.WriteString(ndjson)
+					_ = w.Flush()
+				}
+			})
+		})(ctx)
+		assert.Equal(t, "zstd", string(ctx.Response.Header.ContentEncoding()))
+
+		raw, err := io.ReadAll(ctx.Response.BodyStream())
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat(ndjson, 3), decode(t, "zstd", raw))
+	})
+}
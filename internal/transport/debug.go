@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/expvarhandler"
+	"github.com/valyala/fasthttp/pprofhandler"
+)
+
+// ErrDebugToken is returned by DebugServer.Run when no token is configured;
+// profiling endpoints are never served unauthenticated.
+var ErrDebugToken = errors.New("debug server requires a token")
+
+var publishRuntime sync.Once
+
+// DebugServer serves net/http/pprof profiles under /debug/pprof/ and expvar
+// runtime stats under /debug/vars on a listener of its own, so it can be
+// bound to a management interface and kept off the ingest port. Every
+// request needs "Authorization: Bearer <token>".
+type DebugServer struct {
+	srv           *fasthttp.Server
+	addr          string
+	token         []byte
+	blockRate     int
+	mutexFraction int
+}
+
+type DebugOption func(*DebugServer)
+
+func WithDebugAddr(addr string) DebugOption {
+	return func(s *DebugServer) { s.addr = addr }
+}
+
+// WithBlockProfileRate turns on the block profile, sampling one blocking
+// event per rate nanoseconds spent blocked. See runtime.SetBlockProfileRate.
+func WithBlockProfileRate(rate int) DebugOption {
+	return func(s *DebugServer) { s.blockRate = rate }
+}
+
+// WithMutexProfileFraction turns on the mutex profile, sampling one in
+// fraction contention events. See runtime.SetMutexProfileFraction.
+func WithMutexProfileFraction(fraction int) DebugOption {
+	return func(s *DebugServer) { s.mutexFraction = fraction }
+}
+
+func NewDebug(token string, opts ...DebugOption) *DebugServer {
+	s := &DebugServer{
+		addr:  "127.0.0.1:6060",
+		token: []byte(token),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.srv = &fasthttp.Server{
+		Handler: s.handle,
+		// a CPU profile or trace runs for ?seconds= before anything is written
+		WriteTimeout: 5 * time.Minute,
+	}
+
+	publishRuntime.Do(func() {
+		start := time.Now()
+		expvar.Publish("runtime", expvar.Func(func() any {
+			return map[string]any{
+				"go_version":     runtime.Version(),
+				"goroutines":     runtime.NumGoroutine(),
+				"gomaxprocs":     runtime.GOMAXPROCS(0),
+				"num_cpu":        runtime.NumCPU(),
+				"cgo_calls":      runtime.NumCgoCall(),
+				"uptime_seconds": int64(time.Since(start).Seconds()),
+			}
+		}))
+	})
+	return s
+}
+
+func (s *DebugServer) handle(ctx *fasthttp.RequestCtx) {
+	debugRequests.Inc()
+
+	if !s.authorized(ctx) {
+		debugUnauthorized.Inc()
+		ctx.Error("unauthorized", fasthttp.StatusUnauthorized)
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer realm="debug"`)
+		return
+	}
+
+	path := string(ctx.Path())
+	switch {
+	case path == "/debug/vars":
+		expvarhandler.ExpvarHandler(ctx)
+	case strings.HasPrefix(path, "/debug/pprof/"):
+		pprofhandler.PprofHandler(ctx)
+	default:
+		ctx.Error("not found", fasthttp.StatusNotFound)
+	}
+}
+
+func (s *DebugServer) authorized(ctx *fasthttp.RequestCtx) bool {
+	got, ok := strings.CutPrefix(string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)), "Bearer ")
+	if !ok || len(s.token) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), s.token) == 1
+}
+
+func (s *DebugServer) Run(ctx context.Context) error {
+	if len(s.token) == 0 {
+		return ErrDebugToken
+	}
+	if s.blockRate > 0 {
+		runtime.SetBlockProfileRate(s.blockRate)
+	}
+	if s.mutexFraction > 0 {
+		runtime.SetMutexProfileFraction(s.mutexFraction)
+	}
+
+	slog.Info("starting debug server", "addr", s.addr)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.srv.ListenAndServe(s.addr) }()
+
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down debug server")
+		if err := s.srv.Shutdown(); err != nil {
+			slog.Warn("debug server shutdown error", "error", err)
+		}
+		return ctx.Err()
+	case err := <-errc:
+		return err
+	}
+}
+
+func (s *DebugServer) Addr() string { return s.addr }
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func TestDebugServer(t *testing.T) {
+	srv := NewDebug("s3cret")
+	get := func(path, auth string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI(path)
+		if auth != "" {
+			ctx.Request.Header.Set("Authorization", auth)
+		}
+		srv.handle(ctx)
+		return ctx
+	}
+
+	t.Run("requires token", func(t *testing.T) {
+		f := func(auth string) {
+			t.Helper()
+			ctx := get("/debug/vars", auth)
+			assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+			assert.NotEmpty(t, ctx.Response.Header.Peek("WWW-Authenticate"))
+		}
+		f("")
+		f("Bearer wrong")
+		f("s3cret")
+		f("Basic czNjcmV0")
+	})
+
+	t.Run("vars", func(t *testing.T) {
+		ctx := get("/debug/vars", "Bearer s3cret")
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), `"goroutines"`)
+		assert.Contains(t, string(ctx.Response.Body()), `"memstats"`)
+	})
+
+	t.Run("pprof", func(t *testing.T) {
+		ctx := get("/debug/pprof/goroutine?debug=1", "Bearer s3cret")
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), "goroutine profile")
+	})
+
+	t.Run("unknown path", func(t *testing.T) {
+		assert.Equal(t, fasthttp.StatusNotFound, get("/nope", "Bearer s3cret").Response.StatusCode())
+	})
+
+	t.Run("run without token", func(t *testing.T) {
+		assert.ErrorIs(t, NewDebug("").Run(t.Context()), ErrDebugToken)
+	})
+}
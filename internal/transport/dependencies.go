@@ -1,7 +1,125 @@
 package transport
 
-import "github.com/andriibeee/iotdemo/internal/entity"
+import (
+	"context"
+
+	"github.com/andriibeee/iotdemo/internal/audit"
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/internal/replication"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
 
 type Sink interface {
 	Append(ev entity.Event) error
 }
+
+// SeqSink is a Sink that can report the journal sequence number an event
+// was written with; *sink.Sink implements it.
+type SeqSink interface {
+	AppendSeq(ctx context.Context, ev entity.Event) (uint64, error)
+}
+
+// DurableSink is a SeqSink that can also wait for the event to be
+// fsynced; *sink.Sink implements it.
+type DurableSink interface {
+	AppendDurable(ctx context.Context, ev entity.Event) (uint64, error)
+}
+
+// BackfillSink is a Sink that can take historical events past the live
+// traffic protections; *sink.Sink implements it.
+type BackfillSink interface {
+	AppendBackfill(ev entity.Event) error
+}
+
+// Syncer is a Sink that can write out what it buffers and fsync it;
+// *sink.Sink implements it.
+type Syncer interface {
+	Sync() error
+}
+
+// Degrader is a Sink that can say its journal writes keep failing;
+// *sink.Sink implements it.
+type Degrader interface {
+	Degraded() bool
+}
+
+// Canary reports the latest round of a write path self-test;
+// *sink.Canary implements it.
+type Canary interface {
+	Status() sink.CanaryStatus
+}
+
+type QuotaReporter interface {
+	Report() sink.QuotaReport
+}
+
+type StatsReporter interface {
+	Report() sink.StatsReport
+}
+
+// DeviceRegistry journals what devices report about themselves and keeps
+// the latest of each; *sink.Devices implements it.
+type DeviceRegistry interface {
+	Register(info entity.DeviceInfo) (uint64, error)
+	Report() []sink.DeviceStatus
+}
+
+// SamplingAdmin reads and replaces sampling rules at runtime;
+// *sink.Sampler implements it.
+type SamplingAdmin interface {
+	Rules() []sink.SampleRule
+	SetRules(rules []sink.SampleRule) error
+}
+
+// DedupAdmin reports on the idempotency IDs dedup remembers and forgets
+// them one at a time; *sink.Deduplicator implements it.
+type DedupAdmin interface {
+	Report() sink.DedupReport
+	Purge(id string) bool
+}
+
+// ReplicationReceiver takes batches from a primary sink;
+// *replication.Receiver implements it.
+type ReplicationReceiver interface {
+	Apply(b *replication.Batch) (uint64, error)
+}
+
+// Follower says whether the sink is following a leader and so refuses
+// ingest; *replication.Role implements it.
+type Follower interface {
+	Leader() (leader string, following bool)
+}
+
+// AuditLog keeps a record of admin actions; *audit.Log implements it.
+type AuditLog interface {
+	Append(rec audit.Record) (audit.Record, error)
+	Records(after uint64, limit int) ([]audit.Record, bool, error)
+}
+
+// EventPager reads the journal a page at a time; *journal.Journal
+// implements it.
+type EventPager interface {
+	Page(c journal.Cursor, prefix []byte, limit int, fn func(*journal.Entry) error) (journal.Cursor, bool, error)
+}
+
+// EventLookup reads a single entry of the journal by sequence number;
+// *journal.Journal implements it.
+type EventLookup interface {
+	Lookup(seq uint64) (*journal.Entry, error)
+}
+
+// EventTailer follows the journal as it's written; *journal.Journal
+// implements it.
+type EventTailer interface {
+	Tail(ctx context.Context, fromSeq uint64, fn func(*journal.Entry) error) error
+	LastSeq() uint64
+}
+
+type JournalAdmin interface {
+	TruncateBefore(seq uint64) (int64, error)
+	Compact() (int64, error)
+	Rotate() (journal.SegmentInfo, error)
+	Gaps() []journal.SeqGap
+	Segments() []journal.SegmentStats
+}
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/valyala/fasthttp"
+)
+
+// WithDevices takes DeviceInfo reports on POST /device and lists the
+// latest of each device on /devices.
+func WithDevices(d DeviceRegistry) Option {
+	return func(s *Server) { s.devices = d }
+}
+
+// handleDevice journals the DeviceInfo in the body and answers with the
+// sequence number it was written with.
+func (s *Server) handleDevice(ctx *fasthttp.RequestCtx) {
+	if s.devices == nil {
+		ctx.Error("device reports not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	body := ctx.PostBody()
+	if len(body) == 0 {
+		ctx.Error("empty body", fasthttp.StatusBadRequest)
+		return
+	}
+
+	var info entity.DeviceInfo
+	switch ct := ctx.Request.Header.ContentType(); {
+	case bytes.Equal(ct, []byte("application/json")):
+		if err := json.Unmarshal(body, &info); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	case bytes.Equal(ct, []byte("application/msgpack")):
+		if _, err := info.UnmarshalMsg(body); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	default:
+		ctx.Error("unsupported content-type", fasthttp.StatusUnsupportedMediaType)
+		return
+	}
+
+	seq, err := s.devices.Register(info)
+	switch {
+	case err == nil:
+	case errors.Is(err, sink.ErrInvalidDeviceInfo):
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	default:
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	body, err = json.Marshal(AppendResult{Seq: seq})
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// handleDevices lists the latest report of every device, by name.
+func (s *Server) handleDevices(ctx *fasthttp.RequestCtx) {
+	if s.devices == nil {
+		ctx.Error("device reports not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(s.devices.Report())
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestHandleDevice(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+
+	srv := New(&mockSink{}, WithDevices(sink.NewDevices(j, sink.TextKeys)), WithEvents(j, sink.TextKeys))
+	do := func(method, uri, ct string, body []byte) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.Header.SetContentType(ct)
+		ctx.Request.SetBody(body)
+		srv.handle(ctx)
+		return ctx
+	}
+
+	ctx := do(fasthttp.MethodPost, "/device", "application/json", []byte(`{"device":"gw-1","firmware":"1.4.2","battery":71.5,"rssi":-80,"ts":1000}`))
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), string(ctx.Response.Body()))
+	assert.JSONEq(t, `{"seq":1}`, string(ctx.Response.Body()))
+
+	packed, err := (&entity.DeviceInfo{Device: "gw-2", UnixTimestamp: 2000}).MarshalMsg(nil)
+	require.NoError(t, err)
+	ctx = do(fasthttp.MethodPost, "/device", "application/msgpack", packed)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), string(ctx.Response.Body()))
+
+	for _, tc := range []struct {
+		name, ct, body string
+		code           int
+	}{
+		{"no device", "application/json", `{"firmware":"1.0"}`, fasthttp.StatusBadRequest},
+		{"battery out of range", "application/json", `{"device":"gw-1","battery":140}`, fasthttp.StatusBadRequest},
+		{"bad json", "application/json", `{`, fasthttp.StatusBadRequest},
+		{"empty", "application/json", ``, fasthttp.StatusBadRequest},
+		{"content type", "text/plain", `gw-1`, fasthttp.StatusUnsupportedMediaType},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := do(fasthttp.MethodPost, "/device", tc.ct, []byte(tc.body))
+			assert.Equal(t, tc.code, ctx.Response.StatusCode())
+		})
+	}
+
+	ctx = do(fasthttp.MethodGet, "/devices", "", nil)
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	var report []sink.DeviceStatus
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &report))
+	require.Len(t, report, 2)
+	assert.Equal(t, "gw-1", report[0].Device)
+	assert.Equal(t, "1.4.2", report[0].Firmware)
+	assert.Equal(t, -80, *report[0].RSSI)
+	assert.Equal(t, int64(1), report[0].Reports)
+	assert.Equal(t, "gw-2", report[1].Device)
+	assert.Nil(t, report[1].Battery)
+
+	t.Run("not events", func(t *testing.T) {
+		require.NoError(t, j.Sync())
+		ctx := do(fasthttp.MethodGet, "/events/1", "", nil)
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+		ctx = do(fasthttp.MethodGet, "/events", "", nil)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		var page EventsPage
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &page))
+		assert.Empty(t, page.Events)
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		srv := New(&mockSink{})
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/devices")
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+}
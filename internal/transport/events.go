@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+const (
+	eventsPageDefault = 100
+	eventsPageMax     = 1000
+)
+
+var errBadCursor = errors.New("malformed cursor")
+
+// JournalEvent is an event read back from the journal by /events.
+type JournalEvent struct {
+	Seq uint64 `json:"seq"`
+	entity.Event
+	// Expires is when the entry drops out of the journal, if it does.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// EventsPage is the body of a 200 from /events.
+type EventsPage struct {
+	Events []JournalEvent `json:"events"`
+	// Next is the cursor of the following page, absent on the last one.
+	Next string `json:"next,omitempty"`
+}
+
+// encodeCursor packs a journal cursor into an opaque URL-safe token.
+func encodeCursor(c journal.Cursor) string {
+	b := binary.AppendUvarint(nil, uint64(c.Offset))
+	b = binary.AppendUvarint(b, c.Seq)
+	b = binary.BigEndian.AppendUint32(b, c.Checksum)
+	b = append(b, c.Segment...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(token string) (journal.Cursor, error) {
+	var c journal.Cursor
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, errBadCursor
+	}
+	offset, n := binary.Uvarint(b)
+	if n <= 0 || offset > 1<<62 {
+		return c, errBadCursor
+	}
+	b = b[n:]
+	seq, n := binary.Uvarint(b)
+	if n <= 0 || len(b[n:]) < 4 {
+		return c, errBadCursor
+	}
+	b = b[n:]
+	c.Offset, c.Seq = int64(offset), seq
+	c.Checksum = binary.BigEndian.Uint32(b)
+	c.Segment = string(b[4:])
+	return c, nil
+}
+
+// handleEvents pages through the events in the journal, oldest first:
+// ?limit=<n> caps the page, ?sensor=<name> keeps one sensor's events and
+// ?cursor=<next> continues where the previous page ended. Expired entries
+// and canary events are left out.
+func (s *Server) handleEvents(ctx *fasthttp.RequestCtx) {
+	if s.events == nil {
+		ctx.Error("event queries not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	limit := eventsPageDefault
+	if v := ctx.QueryArgs().Peek("limit"); v != nil {
+		n, err := strconv.Atoi(string(v))
+		if err != nil || n < 1 || n > eventsPageMax {
+			ctx.Error("limit must be between 1 and "+strconv.Itoa(eventsPageMax), fasthttp.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var cursor journal.Cursor
+	if v := ctx.QueryArgs().Peek("cursor"); len(v) > 0 {
+		c, err := decodeCursor(string(v))
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		cursor = c
+	}
+	sensor, filter := string(ctx.QueryArgs().Peek("sensor")), ctx.QueryArgs().Has("sensor")
+	var prefix []byte
+	if filter {
+		prefix = s.eventKeys.Prefix(sensor)
+	}
+
+	page := EventsPage{Events: []JournalEvent{}}
+	next, more, err := s.events.Page(cursor, prefix, limit, func(e *journal.Entry) error {
+		// text key prefixes also match longer sensor names
+		if name, _, err := sink.DecodeKey(e.Key); err != nil || filter && name != sensor {
+			return nil
+		}
+		if je, ok := journalEvent(e); ok {
+			page.Events = append(page.Events, je)
+		}
+		return nil
+	})
+	if err != nil {
+		reqLog(ctx).Error("journal page failed", "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if more {
+		page.Next = encodeCursor(next)
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// journalEvent decodes e, reporting false for entries that aren't events
+// /events hands out.
+func journalEvent(e *journal.Entry) (JournalEvent, bool) {
+	if _, _, err := sink.DecodeDeviceKey(e.Key); err == nil {
+		return JournalEvent{}, false
+	}
+	ev, err := sink.DecodeValue(e.Value)
+	if err != nil || sink.IsCanary(ev) {
+		return JournalEvent{}, false
+	}
+	je := JournalEvent{Seq: e.Seq, Event: ev}
+	if !e.Expires.IsZero() {
+		exp := e.Expires.UTC()
+		je.Expires = &exp
+	}
+	return je, true
+}
+
+// eventLocation is the path /events/{seq} serves the event written with
+// seq on.
+func eventLocation(seq uint64) string {
+	return "/events/" + strconv.FormatUint(seq, 10)
+}
+
+// handleJournalEvent returns the event written with the sequence number
+// in the path, as it was stored.
+func (s *Server) handleJournalEvent(ctx *fasthttp.RequestCtx) {
+	lookup, ok := s.events.(EventLookup)
+	if !ok {
+		ctx.Error("event queries not enabled", fasthttp.StatusNotFound)
+		return
+	}
+	seq, err := strconv.ParseUint(strings.TrimPrefix(ctx.UserValue(localPathKey{}).(string), "/events/"), 10, 64)
+	if err != nil || seq == 0 {
+		ctx.Error("seq must be a positive integer", fasthttp.StatusBadRequest)
+		return
+	}
+
+	e, err := lookup.Lookup(seq)
+	if errors.Is(err, journal.ErrNotFound) {
+		ctx.Error("no event with seq "+strconv.FormatUint(seq, 10), fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		reqLog(ctx).Error("journal lookup failed", "seq", seq, "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	je, ok := journalEvent(e)
+	if !ok {
+		ctx.Error("no event with seq "+strconv.FormatUint(seq, 10), fasthttp.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(je)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestCursorToken(t *testing.T) {
+	c := journal.Cursor{Segment: "000000000007.wal", Offset: 81920, Seq: 1 << 40, Checksum: 0xdeadbeef}
+	got, err := decodeCursor(encodeCursor(c))
+	require.NoError(t, err)
+	assert.Equal(t, c, got)
+
+	for _, bad := range []string{"!!", "", "AA"} {
+		_, err := decodeCursor(bad)
+		assert.ErrorIs(t, err, errBadCursor, bad)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 256)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	for i, sensor := range []string{"temp", "temp-2", "temp", "hum", "temp", "temp-2", "temp", sink.CanarySensor} {
+		ev := entity.Event{Sensor: sensor, Value: i, UnixTimestamp: int64(i)}
+		value, err := sink.EncodeValue(nil, &ev)
+		require.NoError(t, err)
+		key := sink.TextKeys.Encode(sensor, int64(i))
+		if i == 6 {
+			_, err = j.WriteWithExpiry(key, value, expires)
+		} else {
+			_, err = j.Write(key, value)
+		}
+		require.NoError(t, err)
+	}
+	require.NoError(t, j.Sync())
+
+	srv := New(&mockSink{}, WithEvents(j, sink.TextKeys))
+	get := func(uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		srv.handle(ctx)
+		return ctx
+	}
+	pageAll := func(query string) []JournalEvent {
+		var all []JournalEvent
+		uri := "/events?limit=2" + query
+		for range 20 {
+			ctx := get(uri)
+			require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), string(ctx.Response.Body()))
+			var page EventsPage
+			require.NoError(t, json.Unmarshal(ctx.Response.Body(), &page))
+			assert.LessOrEqual(t, len(page.Events), 2)
+			all = append(all, page.Events...)
+			if page.Next == "" {
+				return all
+			}
+			uri = "/events?limit=2" + query + "&cursor=" + page.Next
+		}
+		t.Fatal("paging didn't end")
+		return nil
+	}
+
+	all := pageAll("")
+	require.Len(t, all, 7, "canary events left out")
+	for i, ev := range all {
+		assert.Equal(t, uint64(i+1), ev.Seq)
+		assert.Equal(t, i, ev.Value)
+	}
+	assert.Nil(t, all[0].Expires)
+	require.NotNil(t, all[6].Expires)
+	assert.True(t, expires.Equal(*all[6].Expires))
+
+	temps := pageAll("&sensor=temp")
+	var seqs []uint64
+	for _, ev := range temps {
+		seqs = append(seqs, ev.Seq)
+	}
+	assert.Equal(t, []uint64{1, 3, 5, 7}, seqs)
+
+	assert.Equal(t, fasthttp.StatusBadRequest, get("/events?limit=0").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusBadRequest, get("/events?limit=1001").Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusBadRequest, get("/events?cursor=!!").Response.StatusCode())
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/events")
+	New(&mockSink{}).handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func TestHandleJournalEvent(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+	for i, sensor := range []string{"temp", sink.CanarySensor} {
+		ev := entity.Event{IdempotencyID: "ev-" + sensor, Sensor: sensor, Value: 21, UnixTimestamp: int64(1000 + i)}
+		value, err := sink.EncodeValue(nil, &ev)
+		require.NoError(t, err)
+		_, err = j.Write(sink.TextKeys.Encode(sensor, ev.UnixTimestamp), value)
+		require.NoError(t, err)
+	}
+
+	get := func(srv *Server, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		srv.handle(ctx)
+		return ctx
+	}
+	srv := New(&mockSink{}, WithEvents(j, sink.TextKeys))
+
+	ctx := get(srv, "/events/1")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), string(ctx.Response.Body()))
+	assert.JSONEq(t, `{"seq":1,"idempotency_id":"ev-temp","sensor":"temp","val":21,"ts":1000}`, string(ctx.Response.Body()))
+
+	for uri, status := range map[string]int{
+		"/events/2":   fasthttp.StatusNotFound, // canary
+		"/events/3":   fasthttp.StatusNotFound,
+		"/events/0":   fasthttp.StatusBadRequest,
+		"/events/abc": fasthttp.StatusBadRequest,
+		"/events/1/x": fasthttp.StatusBadRequest,
+	} {
+		ctx := get(srv, uri)
+		assert.Equal(t, status, ctx.Response.StatusCode(), uri)
+	}
+
+	ctx = get(New(&mockSink{}, WithEvents(j, sink.TextKeys), WithBasePath("/iot/v1")), "/iot/v1/events/1")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), "under a base path")
+
+	ctx = get(New(&mockSink{}), "/events/1")
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode(), "events not enabled")
+}
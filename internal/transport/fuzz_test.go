@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// FuzzBatch posts arbitrary batch bodies, NDJSON and msgpack. Malformed
+// ones must come back as a 400, never a panic, and nothing may be accepted
+// that the body couldn't hold.
+func FuzzBatch(f *testing.F) {
+	ev := entity.Event{IdempotencyID: "0b1c", Sensor: "temp-north", Value: 42, UnixTimestamp: 1717243200000}
+	line, _ := json.Marshal(ev)
+	f.Add(append(line, '\n'), false)
+	f.Add(append(append(line, "\n\n"...), line...), false)
+	f.Add([]byte("{\"sensor\":\"temp\"}\n{"), false)
+	packed, _ := ev.MarshalMsg(msgp.AppendArrayHeader(nil, 2))
+	packed, _ = ev.MarshalMsg(packed)
+	f.Add(packed, true)
+	f.Add(msgp.AppendArrayHeader(nil, 1<<31), true)
+	f.Add([]byte{0x91, 0x81, 0xa6, 's', 'e', 'n', 's', 'o', 'r', 0xdb, 0x7f, 0xff, 0xff, 0xff}, true)
+
+	srv := New(nopSink{})
+	f.Fuzz(func(t *testing.T, body []byte, msgpack bool) {
+		ct := "application/x-ndjson"
+		if msgpack {
+			ct = "application/msgpack"
+		}
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI("/ingest/batch")
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(ct)
+		// a new key every time, so replays of an earlier body aren't
+		// answered from the cache
+		ctx.Request.Header.Set("Idempotency-Key", string(body)+ct)
+		ctx.Request.SetBody(body)
+		srv.handle(&ctx)
+
+		switch code := ctx.Response.StatusCode(); code {
+		case fasthttp.StatusAccepted:
+			var res BatchResult
+			if err := json.Unmarshal(ctx.Response.Body(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Accepted > len(body) {
+				t.Fatalf("accepted %d events from %d bytes", res.Accepted, len(body))
+			}
+		case fasthttp.StatusBadRequest:
+		default:
+			t.Fatalf("status %d: %s", code, ctx.Response.Body())
+		}
+	})
+}
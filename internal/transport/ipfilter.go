@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/valyala/fasthttp"
+)
+
+// IPFilter decides which clients may reach the sink: none in the deny
+// list and, when there is an allow list, only those in it.
+type IPFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func NewIPFilter(allow, deny []netip.Prefix) *IPFilter {
+	return &IPFilter{allow: allow, deny: deny}
+}
+
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range f.deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithIPFilter turns away clients f doesn't allow. Connections from them
+// are closed as soon as they're accepted, before a byte is read; clients
+// behind trusted proxies are only known once the request is read, and get
+// 403.
+func WithIPFilter(f *IPFilter) Option {
+	return func(s *Server) { s.ipFilter = f }
+}
+
+// filterIP answers 403 to clients the filter doesn't allow, for those
+// that got past filterListener behind a trusted proxy.
+func (s *Server) filterIP(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if s.ipFilter == nil {
+		return next
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		if !s.ipFilter.Allowed(ClientIP(ctx)) {
+			ipDenied("request").Inc()
+			ctx.Error("forbidden", fasthttp.StatusForbidden)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// filterListener closes connections from peers the filter doesn't allow
+// right after accepting them. Trusted proxies are let through, to have
+// the clients behind them checked per request.
+type filterListener struct {
+	net.Listener
+	filter  *IPFilter
+	trusted func(netip.Addr) bool
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		addr := addrOf(c.RemoteAddr())
+		if l.trusted(addr) || l.filter.Allowed(addr) {
+			return c, nil
+		}
+		ipDenied("connection").Inc()
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestIPFilterAllowed(t *testing.T) {
+	f := NewIPFilter(
+		[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+		[]netip.Prefix{netip.MustParsePrefix("10.6.6.0/24")},
+	)
+	for addr, want := range map[string]bool{
+		"10.0.0.1":         true,
+		"::ffff:10.0.0.1":  true,
+		"2001:db8::7":      true,
+		"10.6.6.6":         false, // deny wins
+		"203.0.113.7":      false, // not in the allow list
+		"2001:db9::1":      false,
+		"::ffff:10.6.6.10": false,
+	} {
+		assert.Equal(t, want, f.Allowed(netip.MustParseAddr(addr)), addr)
+	}
+
+	denyOnly := NewIPFilter(nil, []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")})
+	assert.True(t, denyOnly.Allowed(netip.MustParseAddr("198.51.100.1")))
+	assert.False(t, denyOnly.Allowed(netip.MustParseAddr("203.0.113.7")))
+}
+
+func TestIPFilterListener(t *testing.T) {
+	get := func(t *testing.T, srv *Server, xff string) (int, error) {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() { _ = srv.srv.Serve(&filterListener{Listener: ln, filter: srv.ipFilter, trusted: srv.isTrusted}) }()
+		defer srv.srv.Shutdown()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		req := "GET /healthz HTTP/1.1\r\nHost: sink\r\n"
+		if xff != "" {
+			req += "X-Forwarded-For: " + xff + "\r\n"
+		}
+		_, err = conn.Write([]byte(req + "\r\n"))
+		require.NoError(t, err)
+
+		var resp fasthttp.Response
+		if err := resp.Read(bufio.NewReader(conn)); err != nil {
+			return 0, err
+		}
+		return resp.StatusCode(), nil
+	}
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+	t.Run("denied peers are closed before a request is read", func(t *testing.T) {
+		srv := New(&mockSink{}, WithIPFilter(NewIPFilter(nil, loopback)))
+		_, err := get(t, srv, "")
+		assert.Error(t, err)
+	})
+
+	t.Run("allowed peers get through", func(t *testing.T) {
+		srv := New(&mockSink{}, WithIPFilter(NewIPFilter(loopback, nil)))
+		code, err := get(t, srv, "")
+		require.NoError(t, err)
+		assert.Equal(t, fasthttp.StatusOK, code)
+	})
+
+	t.Run("clients behind trusted proxies are checked per request", func(t *testing.T) {
+		srv := New(&mockSink{},
+			WithTrustedProxies(loopback...),
+			WithIPFilter(NewIPFilter(nil, []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")})),
+		)
+		code, err := get(t, srv, "203.0.113.7")
+		require.NoError(t, err)
+		assert.Equal(t, fasthttp.StatusForbidden, code)
+
+		srv = New(&mockSink{},
+			WithTrustedProxies(loopback...),
+			WithIPFilter(NewIPFilter(nil, []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")})),
+		)
+		code, err = get(t, srv, "198.51.100.1")
+		require.NoError(t, err)
+		assert.Equal(t, fasthttp.StatusOK, code)
+	})
+}
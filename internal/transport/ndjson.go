@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// ndjsonChunk is how many lines a decode worker takes at a time, and
+// batches with fewer lines than this are decoded without workers.
+const ndjsonChunk = 256
+
+var errLineTooLong = errors.New("line too long")
+
+// ndjsonError says which line of a batch failed to decode.
+type ndjsonError struct {
+	line   int
+	parsed int // events decoded ahead of it
+	err    error
+}
+
+func (e *ndjsonError) Error() string { return e.err.Error() }
+func (e *ndjsonError) Unwrap() error { return e.err }
+
+type ndjsonLine struct {
+	data []byte
+	num  int // 1-based, counting empty lines
+}
+
+// splitNDJSON returns the non-empty lines of body, without their line
+// endings. Lines are limited to bufio.MaxScanTokenSize, as when batches
+// were read with a bufio.Scanner.
+func splitNDJSON(body []byte) ([]ndjsonLine, error) {
+	lines := make([]ndjsonLine, 0, bytes.Count(body, []byte{'\n'})+1)
+	for num := 1; len(body) > 0; num++ {
+		data, rest, _ := bytes.Cut(body, []byte{'\n'})
+		body = rest
+		data = bytes.TrimSuffix(data, []byte{'\r'})
+		if len(data) >= bufio.MaxScanTokenSize {
+			return nil, &ndjsonError{line: num, parsed: len(lines), err: errLineTooLong}
+		}
+		if len(data) > 0 {
+			lines = append(lines, ndjsonLine{data: data, num: num})
+		}
+	}
+	return lines, nil
+}
+
+// decodeNDJSON decodes every line of body into an event with decode, in
+// order. Large batches are decoded by up to workers goroutines, each
+// taking ndjsonChunk lines at a time; 0 means GOMAXPROCS. A failure is
+// reported for the first bad line, as a *ndjsonError.
+func decodeNDJSON(body []byte, workers int, decode JSONDecoder) ([]entity.Event, error) {
+	lines, err := splitNDJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]entity.Event, len(lines))
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	chunks := (len(lines) + ndjsonChunk - 1) / ndjsonChunk
+	workers = min(workers, chunks)
+	if workers <= 1 {
+		if err := decodeLines(lines, events, 0, decode); err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+
+	var (
+		next     atomic.Int64
+		mu       sync.Mutex
+		firstBad *ndjsonError
+		wg       sync.WaitGroup
+	)
+	failedBefore := func(line int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstBad != nil && firstBad.line < line
+	}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				c := int(next.Add(1)) - 1
+				if c >= chunks {
+					return
+				}
+				start, end := c*ndjsonChunk, min((c+1)*ndjsonChunk, len(lines))
+				if failedBefore(lines[start].num) {
+					return // chunks are handed out in order, so the rest are later still
+				}
+				var le *ndjsonError
+				if errors.As(decodeLines(lines[start:end], events[start:end], start, decode), &le) {
+					mu.Lock()
+					if firstBad == nil || le.line < firstBad.line {
+						firstBad = le
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstBad != nil {
+		return nil, firstBad
+	}
+	return events, nil
+}
+
+// decodeLines decodes lines into events; base is the index of lines[0] in
+// the batch.
+func decodeLines(lines []ndjsonLine, events []entity.Event, base int, decode JSONDecoder) error {
+	for i, l := range lines {
+		err := decode(l.data, &events[i])
+		if err == nil && len(events[i].Fields) > entity.MaxFields {
+			err = errors.New(errTooManyFields)
+		}
+		if err != nil {
+			return &ndjsonError{line: l.num, parsed: base + i, err: err}
+		}
+	}
+	return nil
+}
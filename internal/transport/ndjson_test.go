@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+func ndjsonBody(n int) []byte {
+	var b bytes.Buffer
+	for i := range n {
+		fmt.Fprintf(&b, `{"sensor":"s-%d","val":%d,"ts":%d}`+"\n", i%7, i, i)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeNDJSON(t *testing.T) {
+	body := ndjsonBody(10 * ndjsonChunk)
+	for _, workers := range []int{1, 4, 0} {
+		events, err := decodeNDJSON(body, workers, entity.DecodeJSON)
+		require.NoError(t, err)
+		require.Len(t, events, 10*ndjsonChunk)
+		for i, ev := range events {
+			if !assert.Equal(t, i, ev.Value, "workers=%d", workers) {
+				break
+			}
+		}
+	}
+}
+
+func TestDecodeNDJSONLines(t *testing.T) {
+	events, err := decodeNDJSON([]byte("{\"sensor\":\"a\",\"val\":1}\r\n\n\n{\"sensor\":\"b\",\"val\":2}"), 4, entity.DecodeJSON)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "a", events[0].Sensor)
+	assert.Equal(t, "b", events[1].Sensor)
+
+	events, err = decodeNDJSON([]byte("\n\n"), 4, entity.DecodeJSON)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	_, err = decodeNDJSON([]byte(`{"sensor":"`+strings.Repeat("x", 1<<16)+`"}`), 4, entity.DecodeJSON)
+	assert.ErrorIs(t, err, errLineTooLong)
+}
+
+func TestDecodeNDJSONFirstError(t *testing.T) {
+	lines := strings.SplitAfter(string(ndjsonBody(20*ndjsonChunk)), "\n")
+	// bad lines in several chunks; the earliest is reported however the
+	// chunks are scheduled
+	for _, i := range []int{17*ndjsonChunk + 3, 5*ndjsonChunk + 10, 9 * ndjsonChunk} {
+		lines[i] = "{oops\n"
+	}
+	body := []byte(strings.Join(lines, ""))
+
+	for _, workers := range []int{1, 3, 16} {
+		_, err := decodeNDJSON(body, workers, entity.DecodeJSON)
+		var le *ndjsonError
+		require.ErrorAs(t, err, &le)
+		assert.Equal(t, 5*ndjsonChunk+11, le.line, "workers=%d", workers)
+		assert.Equal(t, 5*ndjsonChunk+10, le.parsed)
+	}
+}
@@ -0,0 +1,992 @@
+package transport
+
+import (
+	"encoding/json"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/internal/replication"
+	"github.com/andriibeee/iotdemo/internal/sink"
+)
+
+//go:generate go run ../../cmd/openapi -out ../../api/openapi.json
+
+// The OpenAPI document is built from the tables below rather than kept as
+// a hand-edited file, so adding a route means adding it here as well. The
+// copy in api/openapi.json is what client teams generate SDKs from; a test
+// fails when it drifts from OpenAPI().
+
+type apiObject = map[string]any
+
+func jsonContent(schema apiObject) apiObject {
+	return apiObject{"application/json": apiObject{"schema": schema}}
+}
+
+func textContent() apiObject {
+	return apiObject{"text/plain": apiObject{"schema": apiObject{"type": "string"}}}
+}
+
+func ref(name string) apiObject {
+	return apiObject{"$ref": "#/components/schemas/" + name}
+}
+
+// admin marks the operations of an /admin/ path as needing the admin
+// token.
+func admin(path apiObject) apiObject {
+	for _, op := range path {
+		op := op.(apiObject)
+		op["security"] = []apiObject{{"adminToken": []string{}}}
+		op["responses"].(apiObject)["401"] = response("Missing or wrong admin token.")
+	}
+	return path
+}
+
+func response(description string) apiObject {
+	return apiObject{"description": description, "content": textContent()}
+}
+
+var limitHeaders = apiObject{
+	"Retry-After": apiObject{
+		"description": "Seconds until the request can succeed.",
+		"schema":      apiObject{"type": "integer"},
+	},
+	"X-RateLimit-Remaining": apiObject{
+		"description": "Tokens left in the rate limit bucket that rejected the request, bytes or events; 0 for quotas.",
+		"schema":      apiObject{"type": "integer"},
+	},
+	"X-RateLimit-Reset": apiObject{
+		"description": "Seconds until the bucket is full again or the daily quota resets.",
+		"schema":      apiObject{"type": "integer"},
+	},
+}
+
+// insufficientStorage is the 507 of the ingest routes while the journal
+// disk guard rejects events.
+func insufficientStorage(desc string) apiObject {
+	r := response("Journal over journal.disk_guard.max_bytes, or its filesystem under min_free_bytes, after emergency compaction." + desc)
+	r["headers"] = apiObject{"Retry-After": limitHeaders["Retry-After"]}
+	return r
+}
+
+func bufferFull(desc string) apiObject {
+	r := response(desc + " Also sent by a follower, with an X-Leader header instead.")
+	r["headers"] = apiObject{
+		"Retry-After": limitHeaders["Retry-After"],
+		LeaderHeader: apiObject{
+			"description": "URL of the leader to send writes to, when refused by a follower.",
+			"schema":      apiObject{"type": "string"},
+		},
+	}
+	return r
+}
+
+func notAllowed() apiObject {
+	r := apiObject{"description": "Method not allowed; OPTIONS lists the allowed ones.", "content": jsonContent(ref("MethodNotAllowed"))}
+	r["headers"] = apiObject{"Allow": apiObject{
+		"description": "Methods the path takes, comma-separated.",
+		"schema":      apiObject{"type": "string"},
+	}}
+	return r
+}
+
+func tooManyRequests() apiObject {
+	r := response("Rate limit or daily quota exceeded.")
+	r["headers"] = limitHeaders
+	return r
+}
+
+var samplingRules = apiObject{"type": "array", "items": ref("SampleRule")}
+
+var openAPIPaths = apiObject{
+	"/ingest": apiObject{
+		"post": apiObject{
+			"operationId": "ingestEvent",
+			"summary":     "Ingest a single event.",
+			"parameters": []apiObject{{
+				"name":        "seq",
+				"in":          "query",
+				"required":    false,
+				"description": "Wait for the event to be flushed and respond with its journal sequence number. Needs an idempotency_id.",
+				"schema":      apiObject{"type": "boolean"},
+			}, {
+				"name":        "durable",
+				"in":          "query",
+				"required":    false,
+				"description": "As seq, but wait for the journal's background fsync to cover the event as well, so it survives a power cut. Needs sink.sync_interval.",
+				"schema":      apiObject{"type": "boolean"},
+			}},
+			"requestBody": apiObject{
+				"required": true,
+				"content": apiObject{
+					"application/json":    apiObject{"schema": ref("Event")},
+					"application/msgpack": apiObject{"schema": ref("Event")},
+				},
+			},
+			"responses": apiObject{
+				"200": apiObject{
+					"description": "Event written, with seq=true, or written and fsynced, with durable=true.",
+					"content":     jsonContent(ref("AppendResult")),
+					"headers": apiObject{"Location": apiObject{
+						"description": "Where GET returns the event as stored, when event queries are enabled.",
+						"schema":      apiObject{"type": "string", "example": "/events/42"},
+					}},
+				},
+				"202": apiObject{"description": "Event accepted."},
+				"400": response("Empty or malformed body, or seq=true or durable=true without an idempotency_id."),
+				"405": notAllowed(),
+				"409": apiObject{"description": "Duplicate idempotency_id.", "content": jsonContent(ref("DuplicateResult"))},
+				"413": response("Body larger than server.max_body_size, or the event is bigger than the rate limit's burst and would never be let through."),
+				"415": response("Unsupported content type."),
+				"422": response("Event is older than the retention horizon, its sensor name breaks sink.sensor_names, or with dedup.content_hash, its idempotency_id was seen on an event with different content."),
+				"429": tooManyRequests(),
+				"500": response("Sink error."),
+				"503": bufferFull("Buffer full and sink.overflow is reject or block, memory is over watchdog.hard_limit or journal flushes keep failing."),
+				"501": response("With durable=true, there is no sink.sync_interval or the journal has routes."),
+				"504": response("With seq=true, the event was accepted but not flushed in time; it will still be written. With durable=true, also when it was written but not fsynced in time, which the message says with its seq; it will still be fsynced."),
+				"507": insufficientStorage(""),
+			},
+		},
+	},
+	"/ingest/batch": apiObject{
+		"post": apiObject{
+			"operationId": "ingestBatch",
+			"summary":     "Ingest newline-delimited events.",
+			"description": "Duplicates and events older than the retention horizon are skipped. A replay of an accepted batch, matched by Idempotency-Key or identical body, is acknowledged without being processed again. An Idempotency-Key is bound to the body it first came with.",
+			"parameters": []apiObject{{
+				"name":     "Idempotency-Key",
+				"in":       "header",
+				"required": false,
+				"schema":   apiObject{"type": "string"},
+			}},
+			"requestBody": apiObject{
+				"required": true,
+				"content": apiObject{
+					"application/x-ndjson": apiObject{"schema": apiObject{"type": "string"}},
+					"application/jsonl":    apiObject{"schema": apiObject{"type": "string"}},
+					"application/msgpack":  apiObject{"schema": apiObject{"type": "array", "items": ref("Event")}},
+				},
+			},
+			"responses": apiObject{
+				"202": apiObject{"description": "Batch accepted.", "content": jsonContent(ref("BatchResult"))},
+				"400": response("Empty body or parse error. NDJSON batches are dropped as a whole; msgpack batches are appended as they are decoded, so events ahead of the malformed one are kept."),
+				"405": notAllowed(),
+				"413": response("Body larger than server.max_body_size."),
+				"415": response("Unsupported content type."),
+				"422": response("Idempotency-Key was sent before with a different body."),
+				"429": tooManyRequests(),
+				"500": response("Sink error; events after the failing one are dropped."),
+				"503": bufferFull("Buffer full and sink.overflow is reject or block, memory is over watchdog.hard_limit or journal flushes keep failing; events after the first one refused are dropped. Also sent, before any event is taken, to a batch that found no slot under server.batch_limit in time."),
+				"507": insufficientStorage(" Events after the first one refused are dropped."),
+			},
+		},
+	},
+	"/ingest/backfill": apiObject{
+		"post": apiObject{
+			"operationId": "ingestBackfill",
+			"summary":     "Ingest a batch of historical events.",
+			"description": "Like /ingest/batch, but events skip rate limiting and dedup, count against the backfill quota and are stored tagged as backfilled. Events older than the retention horizon are still skipped.",
+			"parameters": []apiObject{{
+				"name":     "Idempotency-Key",
+				"in":       "header",
+				"required": false,
+				"schema":   apiObject{"type": "string"},
+			}},
+			"requestBody": apiObject{
+				"required": true,
+				"content": apiObject{
+					"application/x-ndjson": apiObject{"schema": apiObject{"type": "string"}},
+					"application/jsonl":    apiObject{"schema": apiObject{"type": "string"}},
+					"application/msgpack":  apiObject{"schema": apiObject{"type": "array", "items": ref("Event")}},
+				},
+			},
+			"responses": apiObject{
+				"202": apiObject{"description": "Batch accepted.", "content": jsonContent(ref("BatchResult"))},
+				"400": response("Empty body or parse error, as for /ingest/batch."),
+				"404": response("Backfill is not enabled."),
+				"405": notAllowed(),
+				"413": response("Body larger than server.max_body_size."),
+				"415": response("Unsupported content type."),
+				"422": response("Idempotency-Key was sent before with a different body."),
+				"429": tooManyRequests(),
+				"500": response("Sink error; events after the failing one are dropped."),
+				"503": bufferFull("Buffer full and sink.overflow is reject or block, memory is over watchdog.hard_limit or journal flushes keep failing; events after the first one refused are dropped. Also sent, before any event is taken, to a batch that found no slot under server.batch_limit in time."),
+				"507": insufficientStorage(" Events after the first one refused are dropped."),
+			},
+		},
+	},
+	"/api/v1/write": apiObject{
+		"post": apiObject{
+			"operationId": "remoteWrite",
+			"summary":     "Ingest a Prometheus remote_write 1.0 request.",
+			"description": "Each sample becomes an event named after its metric, plus the values of remote_write.labels; values are scaled and rounded. NaN samples, including staleness markers, are skipped.",
+			"parameters": []apiObject{{
+				"name":     "Content-Encoding",
+				"in":       "header",
+				"required": true,
+				"schema":   apiObject{"type": "string", "enum": []string{"snappy"}},
+			}},
+			"requestBody": apiObject{
+				"required": true,
+				"content": apiObject{
+					"application/x-protobuf": apiObject{"schema": apiObject{"type": "string", "format": "binary", "description": "Snappy-compressed prometheus.WriteRequest."}},
+				},
+			},
+			"responses": apiObject{
+				"204": apiObject{"description": "Samples accepted."},
+				"400": response("Malformed snappy or protobuf body."),
+				"404": response("Remote write is not enabled."),
+				"405": notAllowed(),
+				"413": response("Body larger than server.max_body_size, or decoded request larger than 32 MiB."),
+				"415": response("Not snappy-encoded, or remote write 2.0."),
+				"429": tooManyRequests(),
+				"500": response("Sink error; samples after the failing one are dropped."),
+				"503": bufferFull("Buffer full and sink.overflow is reject or block, memory is over watchdog.hard_limit or journal flushes keep failing."),
+				"507": insufficientStorage(""),
+			},
+		},
+	},
+	"/healthz": apiObject{
+		"get": apiObject{
+			"operationId": "health",
+			"responses": apiObject{
+				"200": response("Server is up."),
+				"405": notAllowed(),
+			},
+		},
+	},
+	"/readyz": apiObject{
+		"get": apiObject{
+			"operationId": "ready",
+			"summary":     "Whether events accepted now reach the journal, for load balancer readiness checks.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Journal writes succeed and, with sink.canary enabled, so did the latest canary round.", "content": jsonContent(ref("Readiness"))},
+				"405": notAllowed(),
+				"503": apiObject{"description": "Journal flushes keep failing, or canary rounds do.", "content": jsonContent(ref("Readiness"))},
+			},
+		},
+	},
+	"/metrics": apiObject{
+		"get": apiObject{
+			"operationId": "metrics",
+			"summary":     "Prometheus metrics.",
+			"responses": apiObject{
+				"200": response("Metrics in Prometheus text format."),
+				"405": notAllowed(),
+			},
+		},
+	},
+	"/openapi.json": apiObject{
+		"get": apiObject{
+			"operationId": "openapi",
+			"summary":     "This document.",
+			"responses": apiObject{
+				"200": apiObject{"description": "OpenAPI document.", "content": jsonContent(apiObject{"type": "object"})},
+				"405": notAllowed(),
+			},
+		},
+	},
+	"/sensors": apiObject{
+		"get": apiObject{
+			"operationId": "getSensorStats",
+			"summary":     "Per-sensor event counts, last seen time and values over a sliding window.",
+			"parameters": []apiObject{{
+				"name":        "sensor",
+				"in":          "query",
+				"description": "Report only this sensor.",
+				"schema":      apiObject{"type": "string"},
+			}},
+			"responses": apiObject{
+				"200": apiObject{"description": "A StatsReport, or SensorStats when sensor is given.", "content": jsonContent(apiObject{"oneOf": []apiObject{ref("StatsReport"), ref("SensorStats")}})},
+				"404": response("Sensor stats are not enabled, or the sensor is unknown."),
+				"405": notAllowed(),
+			},
+		},
+	},
+	"/device": apiObject{
+		"post": apiObject{
+			"operationId": "reportDevice",
+			"summary":     "Report a device's firmware, battery and signal strength.",
+			"description": "Written to the journal straight away, under device keys of its own, past the event pipeline. A report without ts gets the current time.",
+			"requestBody": apiObject{
+				"required": true,
+				"content": apiObject{
+					"application/json":    apiObject{"schema": ref("DeviceInfo")},
+					"application/msgpack": apiObject{"schema": ref("DeviceInfo")},
+				},
+			},
+			"responses": apiObject{
+				"200": apiObject{"description": "Report written.", "content": jsonContent(ref("AppendResult"))},
+				"400": response("Empty or malformed body, no device, or battery not within 0-100."),
+				"404": response("Device reports are not enabled."),
+				"405": notAllowed(),
+				"413": response("Body larger than server.max_body_size."),
+				"415": response("Unsupported content type."),
+				"500": response("The journal couldn't be written."),
+				"503": apiObject{
+					"description": "This sink follows another.",
+					"headers": apiObject{LeaderHeader: apiObject{
+						"description": "URL of the leader to send writes to.",
+						"schema":      apiObject{"type": "string"},
+					}},
+				},
+			},
+		},
+	},
+	"/devices": apiObject{
+		"get": apiObject{
+			"operationId": "listDevices",
+			"summary":     "The latest report of every device, by name.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Every device that has reported.", "content": jsonContent(apiObject{"type": "array", "items": ref("DeviceStatus")})},
+				"404": response("Device reports are not enabled."),
+				"405": notAllowed(),
+			},
+		},
+	},
+	"/events": apiObject{
+		"get": apiObject{
+			"operationId": "listEvents",
+			"summary":     "Events in the journal, a page at a time, oldest first.",
+			"parameters": []apiObject{
+				{
+					"name":        "sensor",
+					"in":          "query",
+					"description": "Only this sensor's events.",
+					"schema":      apiObject{"type": "string"},
+				},
+				{
+					"name":   "limit",
+					"in":     "query",
+					"schema": apiObject{"type": "integer", "minimum": 1, "maximum": eventsPageMax, "default": eventsPageDefault},
+				},
+				{
+					"name":        "cursor",
+					"in":          "query",
+					"description": "The next of the previous page.",
+					"schema":      apiObject{"type": "string"},
+				},
+			},
+			"responses": apiObject{
+				"200": apiObject{"description": "A page of events; expired ones are left out.", "content": jsonContent(ref("EventsPage"))},
+				"400": response("Invalid limit or cursor."),
+				"404": response("Event queries are not enabled."),
+				"405": notAllowed(),
+				"500": response("The journal couldn't be read."),
+			},
+		},
+	},
+	"/events/{seq}": apiObject{
+		"get": apiObject{
+			"operationId": "getEvent",
+			"summary":     "The event written with a journal sequence number, as stored.",
+			"parameters": []apiObject{
+				{
+					"name":     "seq",
+					"in":       "path",
+					"required": true,
+					"schema":   apiObject{"type": "integer", "format": "uint64", "minimum": 1},
+				},
+			},
+			"responses": apiObject{
+				"200": apiObject{"description": "The event.", "content": jsonContent(ref("JournalEvent"))},
+				"400": response("seq is not a positive integer."),
+				"404": response("No event with seq, e.g. truncated or expired, or event queries are not enabled."),
+				"405": notAllowed(),
+				"500": response("The journal couldn't be read."),
+			},
+		},
+	},
+	"/tail": apiObject{
+		"get": apiObject{
+			"operationId": "tailEvents",
+			"summary":     "Events as they're written to the journal, as server-sent events.",
+			"description": "Each event is sent with its sequence number as id and a JournalEvent as data. The stream stays open until the client goes away; a comment is sent every 15 seconds while no events come.",
+			"parameters": []apiObject{
+				{
+					"name":        "from",
+					"in":          "query",
+					"description": "Start at this sequence number instead of with the next event written.",
+					"schema":      apiObject{"type": "integer", "format": "uint64"},
+				},
+				{
+					"name":        "sensor",
+					"in":          "query",
+					"description": "Only this sensor's events.",
+					"schema":      apiObject{"type": "string"},
+				},
+				{
+					"name":        "Last-Event-ID",
+					"in":          "header",
+					"description": "Resume after this sequence number, as EventSource does on reconnecting.",
+					"schema":      apiObject{"type": "integer", "format": "uint64"},
+				},
+			},
+			"responses": apiObject{
+				"200": apiObject{
+					"description": "The event stream.",
+					"content":     apiObject{"text/event-stream": apiObject{"schema": apiObject{"type": "string"}}},
+				},
+				"400": response("Invalid from or Last-Event-ID."),
+				"404": response("Event queries are not enabled."),
+				"405": notAllowed(),
+			},
+		},
+	},
+	"/role": apiObject{
+		"get": apiObject{
+			"operationId": "getRole",
+			"summary":     "Whether this sink takes writes, for load balancer and VIP health checks.",
+			"responses": apiObject{
+				"200": apiObject{"description": "This sink leads.", "content": jsonContent(ref("Role"))},
+				"405": notAllowed(),
+				"503": apiObject{"description": "This sink follows; leader is its URL, when known.", "content": jsonContent(ref("Role"))},
+			},
+		},
+	},
+	"/admin/quota": admin(apiObject{
+		"get": apiObject{
+			"operationId": "getQuota",
+			"summary":     "Daily quota consumption per sensor.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Usage for the current UTC day.", "content": jsonContent(ref("QuotaReport"))},
+				"404": response("Quotas are not enabled."),
+				"405": notAllowed(),
+			},
+		},
+	}),
+	"/replication/entries": apiObject{
+		"post": apiObject{
+			"operationId": "replicateEntries",
+			"summary":     "Take journal entries replicated from a primary sink.",
+			"description": "Needs replication.receive enabled and its token as a bearer token. Entries at or below the source's acknowledged sequence number are skipped, so a batch can be resent safely.",
+			"parameters": []apiObject{{
+				"name":     "Authorization",
+				"in":       "header",
+				"required": true,
+				"schema":   apiObject{"type": "string", "example": "Bearer <token>"},
+			}},
+			"requestBody": apiObject{
+				"required": true,
+				"content":  apiObject{"application/msgpack": apiObject{"schema": ref("ReplicationBatch")}},
+			},
+			"responses": apiObject{
+				"200": apiObject{"description": "Entries written and fsynced.", "content": jsonContent(ref("ReplicationAck"))},
+				"400": response("Malformed batch or no source."),
+				"401": response("Missing or wrong token."),
+				"404": response("Replication receiving is not enabled."),
+				"405": notAllowed(),
+				"415": response("Content-Type is not application/msgpack."),
+				"500": response("Writing to the journal failed; resend the batch."),
+			},
+		},
+	},
+	"/admin/sampling": admin(apiObject{
+		"get": apiObject{
+			"operationId": "getSampling",
+			"summary":     "Sampling rules in effect.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Rules, in the order they're matched.", "content": jsonContent(samplingRules)},
+				"404": response("Sampling is not enabled."),
+				"405": notAllowed(),
+			},
+		},
+		"put": apiObject{
+			"operationId": "setSampling",
+			"summary":     "Replace the sampling rules.",
+			"requestBody": apiObject{
+				"required": true,
+				"content":  jsonContent(samplingRules),
+			},
+			"responses": apiObject{
+				"200": apiObject{"description": "Rules now in effect.", "content": jsonContent(samplingRules)},
+				"400": response("Malformed or invalid rules; the previous ones stay."),
+				"404": response("Sampling is not enabled."),
+				"405": notAllowed(),
+			},
+		},
+	}),
+	"/admin/dedup": admin(apiObject{
+		"get": apiObject{
+			"operationId": "getDedup",
+			"summary":     "What dedup remembers and has dropped since start.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Remembered IDs, counts and the latest duplicates.", "content": jsonContent(ref("DedupReport"))},
+				"404": response("Dedup is not enabled."),
+				"405": notAllowed(),
+			},
+		},
+		"delete": apiObject{
+			"operationId": "purgeDedupID",
+			"summary":     "Forget an idempotency ID, so its next event is let through.",
+			"parameters": []apiObject{{
+				"name":     "id",
+				"in":       "query",
+				"required": true,
+				"schema":   apiObject{"type": "string"},
+			}},
+			"responses": apiObject{
+				"204": apiObject{"description": "ID forgotten."},
+				"400": response("Missing id parameter."),
+				"404": response("Dedup is not enabled, or the ID isn't remembered."),
+				"405": notAllowed(),
+			},
+		},
+	}),
+	"/admin/journal/truncate": admin(apiObject{
+		"post": apiObject{
+			"operationId": "truncateJournal",
+			"summary":     "Remove sealed segments whose entries all precede a sequence number.",
+			"parameters": []apiObject{{
+				"name":     "before",
+				"in":       "query",
+				"required": true,
+				"schema":   apiObject{"type": "integer", "format": "uint64"},
+			}},
+			"responses": apiObject{
+				"200": apiObject{"description": "Segments removed.", "content": jsonContent(ref("TruncateResult"))},
+				"400": response("Missing or invalid before parameter."),
+				"404": response("Journal not configured."),
+				"405": notAllowed(),
+				"500": response("Truncation failed."),
+			},
+		},
+	}),
+	"/admin/journal/gaps": admin(apiObject{
+		"get": apiObject{
+			"operationId": "getJournalGaps",
+			"summary":     "Sequence gaps and regressions found while opening or replaying the journal.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Gaps found so far, oldest first.", "content": jsonContent(apiObject{"type": "array", "items": ref("SeqGap")})},
+				"404": response("Journal not configured."),
+				"405": notAllowed(),
+			},
+		},
+	}),
+	"/admin/journal/segments": admin(apiObject{
+		"get": apiObject{
+			"operationId": "getJournalSegments",
+			"summary":     "The journal's live segments, for capacity planning and picking what to truncate or compact.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Segments in sequence order, the active one last.", "content": jsonContent(apiObject{"type": "array", "items": ref("SegmentStats")})},
+				"404": response("Journal not configured."),
+				"405": notAllowed(),
+			},
+		},
+	}),
+	"/admin/journal/compact": admin(apiObject{
+		"post": apiObject{
+			"operationId": "compactJournal",
+			"summary":     "Rewrite sealed segments without their expired entries.",
+			"responses": apiObject{
+				"200": apiObject{"description": "Compaction finished.", "content": jsonContent(ref("TruncateResult"))},
+				"404": response("Journal not configured."),
+				"405": notAllowed(),
+				"500": response("Compaction failed."),
+			},
+		},
+	}),
+	"/admin/journal/rotate": admin(apiObject{
+		"post": apiObject{
+			"operationId": "rotateJournal",
+			"summary":     "Flush the sink and seal the active segment, so sealed segments hold everything accepted so far.",
+			"responses": apiObject{
+				"200": apiObject{"description": "The segment sealed, or the last one if nothing was written since.", "content": jsonContent(ref("SegmentInfo"))},
+				"404": response("Journal not configured."),
+				"405": notAllowed(),
+				"500": response("Flush or rotation failed."),
+			},
+		},
+	}),
+	"/admin/flush": admin(apiObject{
+		"post": apiObject{
+			"operationId": "flushSink",
+			"summary":     "Write the buffered events to the journal and fsync it.",
+			"responses": apiObject{
+				"204": apiObject{"description": "Everything accepted so far is on disk."},
+				"404": response("Sink can't be flushed."),
+				"405": notAllowed(),
+				"500": response("Flush failed."),
+			},
+		},
+	}),
+	"/admin/audit": admin(apiObject{
+		"get": apiObject{
+			"operationId": "getAudit",
+			"summary":     "Admin actions recorded in the audit log, oldest first.",
+			"parameters": []apiObject{
+				{
+					"name":        "after",
+					"in":          "query",
+					"description": "Skip records up to this sequence number.",
+					"schema":      apiObject{"type": "integer", "format": "uint64", "default": 0},
+				},
+				{
+					"name":   "limit",
+					"in":     "query",
+					"schema": apiObject{"type": "integer", "minimum": 1, "maximum": 1000, "default": 100},
+				},
+			},
+			"responses": apiObject{
+				"200": apiObject{"description": "A page of records.", "content": jsonContent(ref("AuditPage"))},
+				"400": response("Invalid after or limit parameter."),
+				"404": response("The audit log is not enabled."),
+				"405": notAllowed(),
+				"500": response("The audit journal couldn't be read."),
+			},
+		},
+	}),
+}
+
+var openAPISchemas = apiObject{
+	"Event": apiObject{
+		"type":     "object",
+		"required": []string{"sensor", "ts"},
+		"properties": apiObject{
+			"idempotency_id": apiObject{"type": "string", "description": "Events with an id seen recently are rejected as duplicates."},
+			"sensor":         apiObject{"type": "string"},
+			"val":            apiObject{"type": "integer", "description": "The reading; may be left out when fields is set."},
+			"ts":             apiObject{"type": "integer", "format": "int64", "description": "Unix timestamp."},
+			"fields": apiObject{
+				"type":                 "object",
+				"additionalProperties": apiObject{"type": "number"},
+				"maxProperties":        entity.MaxFields,
+				"description":          "Named readings of a sensor reporting several channels at once, stored together as one event.",
+			},
+		},
+	},
+	"DeviceInfo": apiObject{
+		"type":     "object",
+		"required": []string{"device"},
+		"properties": apiObject{
+			"device":   apiObject{"type": "string"},
+			"firmware": apiObject{"type": "string", "description": "Firmware version."},
+			"battery":  apiObject{"type": "number", "minimum": 0, "maximum": 100, "description": "Charge left in percent; left out for mains-powered devices."},
+			"rssi":     apiObject{"type": "integer", "description": "Received signal strength in dBm."},
+			"sensors":  apiObject{"type": "array", "items": apiObject{"type": "string"}, "description": "The sensors the device reads."},
+			"ts":       apiObject{"type": "integer", "format": "int64", "description": "Unix milliseconds; the time of receipt when left out."},
+		},
+	},
+	"DeviceStatus": apiObject{
+		"allOf": []apiObject{
+			ref("DeviceInfo"),
+			{
+				"type": "object",
+				"properties": apiObject{
+					"reports": apiObject{"type": "integer", "format": "int64", "description": "Reports received since the journal began."},
+				},
+			},
+		},
+	},
+	"APIError": apiObject{
+		"type":        "object",
+		"description": "Body of error responses from API version 2 on, in place of plain text.",
+		"properties": apiObject{
+			"error":      apiObject{"type": "string"},
+			"status":     apiObject{"type": "integer"},
+			"request_id": apiObject{"type": "string"},
+			"batch": apiObject{
+				"allOf":       []apiObject{ref("BatchResult")},
+				"description": "What became of the events of a batch before the one it was cut short at.",
+			},
+		},
+	},
+	"MethodNotAllowed": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"error": apiObject{"type": "string"},
+			"allow": apiObject{"type": "array", "items": apiObject{"type": "string"}},
+		},
+	},
+	"QuotaUsage": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"sensor": apiObject{"type": "string"},
+			"events": apiObject{"type": "integer", "format": "int64"},
+			"bytes":  apiObject{"type": "integer", "format": "int64"},
+		},
+	},
+	"DedupReport": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"ids":        apiObject{"type": "integer", "description": "IDs remembered until the next cleaning."},
+			"checks":     apiObject{"type": "integer", "description": "Events with an idempotency_id checked since start."},
+			"duplicates": apiObject{"type": "integer", "description": "Events dropped as duplicates since start."},
+			"conflicts":  apiObject{"type": "integer", "description": "Events rejected for reusing an ID on different content since start."},
+			"hit_ratio":  apiObject{"type": "number", "description": "duplicates / checks."},
+			"recent":     apiObject{"type": "array", "items": ref("DedupHit"), "description": "The latest duplicates and conflicts, newest first, up to dedup.recent."},
+		},
+	},
+	"DedupHit": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"idempotency_id": apiObject{"type": "string"},
+			"sensor":         apiObject{"type": "string"},
+			"at":             apiObject{"type": "string", "format": "date-time"},
+			"seq":            apiObject{"type": "integer", "format": "uint64", "description": "Journal sequence number of the first copy, once written."},
+			"conflict":       apiObject{"type": "boolean", "description": "The ID was reused on different content."},
+		},
+	},
+	"QuotaReport": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"day":            apiObject{"type": "string", "format": "date"},
+			"events_per_day": apiObject{"type": "integer", "format": "int64"},
+			"bytes_per_day":  apiObject{"type": "integer", "format": "int64"},
+			"sensors":        apiObject{"type": "array", "items": ref("QuotaUsage")},
+		},
+	},
+	"SampleRule": apiObject{
+		"type":        "object",
+		"required":    []string{"patterns"},
+		"description": "Set one of every and probability.",
+		"properties": apiObject{
+			"name":        apiObject{"type": "string", "description": "Metrics label, defaults to the first pattern."},
+			"patterns":    apiObject{"type": "array", "items": apiObject{"type": "string"}, "description": "path.Match globs on the sensor name."},
+			"every":       apiObject{"type": "integer", "description": "Keep the first of every N events of each sensor."},
+			"probability": apiObject{"type": "number", "description": "Keep each event with this chance."},
+		},
+	},
+	"WindowStats": apiObject{
+		"type":        "object",
+		"description": "Values of events within the window; min, max and mean are 0 when count is.",
+		"properties": apiObject{
+			"count": apiObject{"type": "integer", "format": "int64"},
+			"min":   apiObject{"type": "integer"},
+			"max":   apiObject{"type": "integer"},
+			"mean":  apiObject{"type": "number"},
+		},
+	},
+	"SensorStats": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"sensor":    apiObject{"type": "string"},
+			"count":     apiObject{"type": "integer", "format": "int64", "description": "Events recorded since the journal began."},
+			"last_seen": apiObject{"type": "integer", "format": "int64", "description": "Newest event timestamp, Unix milliseconds."},
+			"window":    ref("WindowStats"),
+			"health": apiObject{
+				"type":        "string",
+				"enum":        []string{sink.HealthOK, sink.HealthBroken, sink.HealthBattery, sink.HealthOffline, sink.HealthUnknown},
+				"description": "Why the sensor is silent, from its device's reports, with stats.health.enabled.",
+			},
+			"device": apiObject{"type": "string", "description": "The device the sensor is on, with stats.health.enabled."},
+		},
+	},
+	"StatsReport": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"window":  apiObject{"type": "string", "description": "Sliding window length, e.g. 1h0m0s."},
+			"sensors": apiObject{"type": "array", "items": ref("SensorStats")},
+		},
+	},
+	"AppendResult": apiObject{
+		"type":     "object",
+		"required": []string{"seq"},
+		"properties": apiObject{
+			"seq": apiObject{"type": "integer", "description": "Journal sequence number the event was written with."},
+		},
+	},
+	"DuplicateResult": apiObject{
+		"type":     "object",
+		"required": []string{"status"},
+		"properties": apiObject{
+			"status": apiObject{"type": "string", "enum": []string{"written", "pending"}, "description": "Whether the first copy is in the journal yet."},
+			"seq":    apiObject{"type": "integer", "description": "Journal sequence number of the first copy, once written."},
+		},
+	},
+	"BatchResult": apiObject{
+		"type":     "object",
+		"required": []string{"accepted", "duplicates", "total"},
+		"properties": apiObject{
+			"accepted":   apiObject{"type": "integer", "description": "Events recorded."},
+			"duplicates": apiObject{"type": "integer", "description": "Events skipped as duplicates."},
+			"total":      apiObject{"type": "integer", "description": "Events in the batch."},
+			"expired":    apiObject{"type": "integer", "description": "Events skipped for being older than the retention horizon."},
+			"conflicts":  apiObject{"type": "integer", "description": "Events skipped for reusing the idempotency_id of an event with different content, with dedup.content_hash."},
+			"invalid":    apiObject{"type": "integer", "description": "Events skipped for a sensor name sink.sensor_names rejects."},
+			"too_large":  apiObject{"type": "integer", "description": "Events skipped for being bigger than the rate limit's burst."},
+			"replayed":   apiObject{"type": "boolean", "description": "The batch was accepted before; counts are from that delivery."},
+		},
+	},
+	"SeqGap": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"segment": apiObject{"type": "string"},
+			"after":   apiObject{"type": "integer", "format": "uint64", "description": "Last sequence number accounted for."},
+			"next":    apiObject{"type": "integer", "format": "uint64", "description": "Sequence number found instead of after+1; not above after for a regression."},
+		},
+	},
+	"ReplicationEntry": apiObject{
+		"type":     "object",
+		"required": []string{"seq", "key", "value"},
+		"properties": apiObject{
+			"seq":     apiObject{"type": "integer", "format": "uint64", "description": "Sequence number in the primary's journal."},
+			"key":     apiObject{"type": "string", "format": "binary"},
+			"value":   apiObject{"type": "string", "format": "binary"},
+			"expires": apiObject{"type": "integer", "format": "int64", "description": "Unix nanoseconds; absent for entries that don't expire."},
+		},
+	},
+	"ReplicationBatch": apiObject{
+		"type":     "object",
+		"required": []string{"source", "entries"},
+		"properties": apiObject{
+			"source":  apiObject{"type": "string", "description": "Name of the primary sink."},
+			"entries": apiObject{"type": "array", "items": ref("ReplicationEntry"), "maxItems": replication.MaxBatch},
+		},
+	},
+	"ReplicationAck": apiObject{
+		"type":     "object",
+		"required": []string{"acked"},
+		"properties": apiObject{
+			"acked": apiObject{"type": "integer", "format": "uint64", "description": "Highest sequence number of the source written so far."},
+		},
+	},
+	"Role": apiObject{
+		"type":     "object",
+		"required": []string{"role"},
+		"properties": apiObject{
+			"role":   apiObject{"type": "string", "enum": []string{"leader", "follower"}},
+			"leader": apiObject{"type": "string", "description": "URL of the leader, on a follower that knows it."},
+		},
+	},
+	"Readiness": apiObject{
+		"type":     "object",
+		"required": []string{"ready", "degraded"},
+		"properties": apiObject{
+			"ready":    apiObject{"type": "boolean"},
+			"degraded": apiObject{"type": "boolean", "description": "Journal flushes have failed often enough in a row to reject ingest."},
+			"canary": apiObject{
+				"type":        "object",
+				"description": "Latest canary round; absent unless sink.canary is enabled.",
+				"required":    []string{"latency_ms", "failures"},
+				"properties": apiObject{
+					"last_success": apiObject{"type": "string", "format": "date-time", "description": "When a canary event was last written; absent before the first."},
+					"latency_ms":   apiObject{"type": "number", "description": "How long the last successful round took to reach the journal."},
+					"seq":          apiObject{"type": "integer", "format": "uint64", "description": "Sequence number of the last canary event written."},
+					"failures":     apiObject{"type": "integer", "description": "Rounds failed since the last success."},
+					"error":        apiObject{"type": "string", "description": "Why the latest round failed."},
+					"paused":       apiObject{"type": "boolean", "description": "The sink follows a leader, so no rounds run."},
+				},
+			},
+		},
+	},
+	"JournalEvent": apiObject{
+		"allOf": []apiObject{
+			ref("Event"),
+			{
+				"type":     "object",
+				"required": []string{"seq"},
+				"properties": apiObject{
+					"seq":     apiObject{"type": "integer", "format": "uint64", "description": "Journal sequence number."},
+					"expires": apiObject{"type": "string", "format": "date-time", "description": "When the entry drops out of the journal, if it does."},
+				},
+			},
+		},
+	},
+	"EventsPage": apiObject{
+		"type":     "object",
+		"required": []string{"events"},
+		"properties": apiObject{
+			"events": apiObject{"type": "array", "items": ref("JournalEvent")},
+			"next":   apiObject{"type": "string", "description": "Cursor of the next page; absent on the last one."},
+		},
+	},
+	"AuditRecord": apiObject{
+		"type":     "object",
+		"required": []string{"seq", "time", "actor", "action", "status", "prev", "hash"},
+		"properties": apiObject{
+			"seq":  apiObject{"type": "integer", "format": "uint64"},
+			"time": apiObject{"type": "string", "format": "date-time"},
+			"actor": apiObject{
+				"type": "object",
+				"properties": apiObject{
+					"addr":       apiObject{"type": "string", "description": "Client address, after trusted proxies."},
+					"cert":       apiObject{"type": "string", "description": "Subject of the client certificate, with mutual TLS."},
+					"request_id": apiObject{"type": "string"},
+				},
+			},
+			"action":  apiObject{"type": "string", "example": "journal.truncate"},
+			"details": apiObject{"type": "object", "additionalProperties": apiObject{"type": "string"}, "description": "Query string and body of the request."},
+			"status":  apiObject{"type": "integer", "description": "HTTP status the action was answered with."},
+			"prev":    apiObject{"type": "string", "description": "Hash of the record before; empty on the first."},
+			"hash":    apiObject{"type": "string", "description": "SHA-256, or HMAC-SHA256 with a key, over the record with an empty hash."},
+		},
+	},
+	"AuditPage": apiObject{
+		"type":     "object",
+		"required": []string{"records", "intact"},
+		"properties": apiObject{
+			"records": apiObject{"type": "array", "items": ref("AuditRecord")},
+			"intact":  apiObject{"type": "boolean", "description": "The hash chain verified up to the last record returned."},
+		},
+	},
+	"SegmentInfo": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"name":      apiObject{"type": "string", "example": "0000000000000042.wal"},
+			"size":      apiObject{"type": "integer", "format": "int64"},
+			"first_seq": apiObject{"type": "integer", "format": "uint64"},
+			"last_seq":  apiObject{"type": "integer", "format": "uint64"},
+			"crc32":     apiObject{"type": "integer", "format": "uint32"},
+			"compacted": apiObject{"type": "boolean"},
+			"sealed_at": apiObject{"type": "string", "format": "date-time"},
+			"created":   apiObject{"type": "string", "format": "date-time"},
+			"entries":   apiObject{"type": "integer", "format": "uint32"},
+			"encrypted": apiObject{"type": "boolean"},
+		},
+	},
+	"SegmentStats": apiObject{
+		"type":        "object",
+		"description": "entries, created, sealed and encrypted are left out when unknown: for segments sealed before the manifest recorded them, and created for the segment that was active across a restart.",
+		"properties": apiObject{
+			"name":      apiObject{"type": "string", "example": "0000000000000042.wal"},
+			"bytes":     apiObject{"type": "integer", "format": "int64"},
+			"first_seq": apiObject{"type": "integer", "format": "uint64"},
+			"last_seq":  apiObject{"type": "integer", "format": "uint64"},
+			"entries":   apiObject{"type": "integer", "format": "uint32", "description": "Entries, not counting batch and seal markers."},
+			"created":   apiObject{"type": "string", "format": "date-time"},
+			"sealed":    apiObject{"type": "string", "format": "date-time"},
+			"active":    apiObject{"type": "boolean", "description": "The segment being written to."},
+			"encrypted": apiObject{"type": "boolean"},
+			"compacted": apiObject{"type": "boolean", "description": "Compaction rewrote the segment without its expired entries."},
+		},
+	},
+	"TruncateResult": apiObject{
+		"type": "object",
+		"properties": apiObject{
+			"reclaimed_bytes": apiObject{"type": "integer", "format": "int64"},
+		},
+	},
+}
+
+// OpenAPI returns the OpenAPI 3 document describing the HTTP API.
+func OpenAPI() ([]byte, error) {
+	return openAPI("")
+}
+
+// openAPI is the document for routes mounted under base, which it gives
+// as the server URL the paths are relative to.
+func openAPI(base string) ([]byte, error) {
+	doc := apiObject{
+		"openapi": "3.0.3",
+		"info": apiObject{
+			"title":   "IoT event sink",
+			"version": "1.0.0",
+			"description": "Every path is also served under /v1 and /v2, picking that API version; without one, the API-Version header picks it, and 1 is the default. " +
+				"Responses name the version in API-Version. Version 2 answers errors with an APIError body in place of plain text.",
+		},
+		"paths": openAPIPaths,
+		"components": apiObject{
+			"schemas": openAPISchemas,
+			"securitySchemes": apiObject{
+				"adminToken": apiObject{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "server.admin_token. Without one, the /admin/ paths answer 404.",
+				},
+			},
+		},
+	}
+	if base != "" {
+		doc["servers"] = []apiObject{{"url": base}}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestOpenAPI(t *testing.T) {
+	t.Run("served", func(t *testing.T) {
+		srv := New(&mockSink{})
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/openapi.json")
+		ctx.Request.Header.SetMethod("GET")
+		srv.handle(ctx)
+
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+
+		var doc struct {
+			OpenAPI string                    `json:"openapi"`
+			Paths   map[string]map[string]any `json:"paths"`
+		}
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &doc))
+		assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+		for path, method := range map[string]string{
+			"/ingest":                 "post",
+			"/ingest/batch":           "post",
+			"/healthz":                "get",
+			"/metrics":                "get",
+			"/openapi.json":           "get",
+			"/admin/quota":            "get",
+			"/admin/journal/truncate": "post",
+			"/admin/journal/compact":  "post",
+			"/admin/journal/gaps":     "get",
+		} {
+			assert.Contains(t, doc.Paths[path], method, path)
+		}
+	})
+
+	t.Run("served under a base path", func(t *testing.T) {
+		srv := New(&mockSink{}, WithBasePath("iot/v1/"))
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/iot/v1/openapi.json")
+		ctx.Request.Header.SetMethod("GET")
+		srv.handle(ctx)
+		require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+		var doc struct {
+			Servers []struct {
+				URL string `json:"url"`
+			} `json:"servers"`
+			Paths map[string]any `json:"paths"`
+		}
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &doc))
+		require.Len(t, doc.Servers, 1)
+		assert.Equal(t, "/iot/v1", doc.Servers[0].URL)
+		assert.Contains(t, doc.Paths, "/ingest", "paths stay relative to the server URL")
+	})
+
+	t.Run("committed copy is up to date", func(t *testing.T) {
+		want, err := OpenAPI()
+		require.NoError(t, err)
+
+		got, err := os.ReadFile("../../api/openapi.json")
+		require.NoError(t, err)
+		assert.JSONEq(t, string(want), string(got), "run go generate ./internal/transport")
+	})
+}
@@ -0,0 +1,242 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errBadProxyHeader = errors.New("malformed PROXY protocol header")
+
+// proxyV2Sig starts every PROXY protocol v2 header.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// A v1 header is at most this long, CRLF included.
+const maxProxyV1Len = 107
+
+type clientIPKey struct{}
+
+// WithTrustedProxies names the load balancers and proxies, as CIDRs, whose
+// X-Forwarded-For entries and PROXY protocol headers are believed. Without
+// any the peer address is always the client.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(s *Server) { s.trusted = append(s.trusted, prefixes...) }
+}
+
+// WithProxyProtocol expects connections from trusted proxies to start with
+// a PROXY protocol v1 or v2 header carrying the device's address, as
+// layer 4 load balancers send it. Other peers connect as usual.
+func WithProxyProtocol() Option {
+	return func(s *Server) { s.proxyProtocol = true }
+}
+
+func (s *Server) isTrusted(addr netip.Addr) bool {
+	for _, p := range s.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP records the address of the device behind the request, for
+// handlers, logs and middlewares to get with ClientIP.
+func (s *Server) clientIP(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(clientIPKey{}, s.resolveClientIP(ctx))
+		next(ctx)
+	}
+}
+
+// resolveClientIP walks X-Forwarded-For from the nearest hop back, as long
+// as each hop is a trusted proxy; the first one that isn't is the client.
+// Entries further left were written by whoever the client is, so they
+// can't be believed.
+func (s *Server) resolveClientIP(ctx *fasthttp.RequestCtx) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ctx.RemoteIP())
+	addr = addr.Unmap()
+	if len(s.trusted) == 0 || !s.isTrusted(addr) {
+		return addr
+	}
+
+	var hops []string
+	for _, h := range ctx.Request.Header.PeekAll("X-Forwarded-For") {
+		hops = append(hops, strings.Split(string(h), ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break // stop at the last proxy that spoke sense
+		}
+		addr = hop.Unmap()
+		if !s.isTrusted(addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// ClientIP returns the address of the device that sent the request ctx is
+// serving, past any trusted proxies.
+func ClientIP(ctx *fasthttp.RequestCtx) netip.Addr {
+	addr, _ := ctx.UserValue(clientIPKey{}).(netip.Addr)
+	return addr
+}
+
+// proxyListener reads a PROXY protocol header off connections from trusted
+// peers.
+type proxyListener struct {
+	net.Listener
+	trusted func(netip.Addr) bool
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(addrOf(c.RemoteAddr())) {
+		return c, nil
+	}
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// proxyConn parses its header on first use rather than in Accept, so a
+// slow proxy holds up only its own connection; the server's read timeout
+// bounds the wait.
+type proxyConn struct {
+	net.Conn
+	r    *bufio.Reader
+	once sync.Once
+	src  net.Addr
+	err  error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() { c.src, c.err = readProxyHeader(c.r) })
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr is the source address in the header, or the proxy's own for
+// LOCAL and UNKNOWN headers.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func addrOf(a net.Addr) netip.Addr {
+	if tcp, ok := a.(*net.TCPAddr); ok {
+		addr, _ := netip.AddrFromSlice(tcp.IP)
+		return addr.Unmap()
+	}
+	ap, err := netip.ParseAddrPort(a.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the source address
+// it carries, nil when it carries none.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Sig))
+	if err == nil && bytes.Equal(sig, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+	if p, err := r.Peek(6); err != nil || string(p) != "PROXY " {
+		return nil, errBadProxyHeader
+	}
+	return readProxyV1(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1Len {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errBadProxyHeader
+	}
+
+	f := strings.Split(s, " ")
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, errBadProxyHeader
+	}
+	ip, err := netip.ParseAddr(f[2])
+	if err != nil {
+		return nil, errBadProxyHeader
+	}
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if err != nil {
+		return nil, errBadProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if verCmd>>4 != 2 {
+		return nil, errBadProxyHeader
+	}
+	if verCmd&0xf == 0 { // LOCAL: the proxy's own health check
+		return nil, nil
+	}
+
+	var ip netip.Addr
+	var port []byte
+	switch fam >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, errBadProxyHeader
+		}
+		ip = netip.AddrFrom4([4]byte(body[:4]))
+		port = body[8:10]
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errBadProxyHeader
+		}
+		ip = netip.AddrFrom16([16]byte(body[:16])).Unmap()
+		port = body[32:34]
+	default: // AF_UNSPEC or AF_UNIX
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(port))), nil
+}
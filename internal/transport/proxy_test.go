@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestClientIP(t *testing.T) {
+	srv := New(&mockSink{}, WithTrustedProxies(
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+	))
+
+	f := func(peer string, xff []string, want string) {
+		t.Helper()
+		ctx := &fasthttp.RequestCtx{}
+		ctx.SetRemoteAddr(net.TCPAddrFromAddrPort(netip.MustParseAddrPort(peer)))
+		for _, h := range xff {
+			ctx.Request.Header.Add("X-Forwarded-For", h)
+		}
+		assert.Equal(t, want, srv.resolveClientIP(ctx).String())
+	}
+
+	// untrusted peers can't claim an address
+	f("203.0.113.7:1000", []string{"1.2.3.4"}, "203.0.113.7")
+	// load balancer in front
+	f("10.0.0.5:1000", []string{"203.0.113.7"}, "203.0.113.7")
+	// a chain of trusted proxies, some of it in a second header
+	f("10.0.0.5:1000", []string{"198.51.100.1, 203.0.113.7, 192.168.1.1", "10.1.1.1"}, "203.0.113.7")
+	// everything trusted: the furthest hop
+	f("10.0.0.5:1000", []string{"10.9.9.9"}, "10.9.9.9")
+	// garbage stops the walk at the last proxy that made sense
+	f("10.0.0.5:1000", []string{"203.0.113.7, nonsense"}, "10.0.0.5")
+	f("[::ffff:10.0.0.5]:1000", []string{"2001:db8::1"}, "2001:db8::1")
+	// no header
+	f("10.0.0.5:1000", nil, "10.0.0.5")
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	read := func(hdr string) (net.Addr, string, error) {
+		r := bufio.NewReader(strings.NewReader(hdr + "GET / HTTP/1.1\r\n"))
+		addr, err := readProxyHeader(r)
+		rest, _ := r.ReadString('\n')
+		return addr, rest, err
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		addr, rest, err := read("PROXY TCP4 203.0.113.7 10.0.0.1 5555 443\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, "203.0.113.7:5555", addr.String())
+		assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+		addr, _, err = read("PROXY TCP6 2001:db8::1 2001:db8::2 5555 443\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, "[2001:db8::1]:5555", addr.String())
+
+		addr, _, err = read("PROXY UNKNOWN\r\n")
+		require.NoError(t, err)
+		assert.Nil(t, addr)
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		v2 := func(cmd, fam byte, body []byte) string {
+			hdr := append([]byte{}, proxyV2Sig...)
+			hdr = append(hdr, 0x20|cmd, fam)
+			hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+			return string(append(hdr, body...))
+		}
+		body := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x15, 0xb3, 0x01, 0xbb}
+
+		addr, rest, err := read(v2(1, 0x11, body))
+		require.NoError(t, err)
+		assert.Equal(t, "203.0.113.7:5555", addr.String())
+		assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+		addr, _, err = read(v2(0, 0x00, nil))
+		require.NoError(t, err)
+		assert.Nil(t, addr, "LOCAL keeps the proxy's address")
+
+		_, _, err = read(v2(1, 0x11, body[:4]))
+		assert.ErrorIs(t, err, errBadProxyHeader)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, hdr := range []string{
+			"",
+			"PROXY TCP4 nonsense 10.0.0.1 5555 443\r\n",
+			"PROXY TCP4 203.0.113.7 10.0.0.1 5555\r\n",
+			"PROXY TCP4 203.0.113.7 10.0.0.1 5555 443\n",
+			"PROXY " + strings.Repeat("x", maxProxyV1Len),
+		} {
+			_, _, err := read(hdr)
+			assert.Error(t, err, hdr)
+		}
+	})
+}
+
+func TestProxyProtocol(t *testing.T) {
+	var got netip.Addr
+	capture := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			got = ClientIP(ctx)
+			next(ctx)
+		}
+	}
+	srv := New(&mockSink{},
+		WithTrustedProxies(netip.MustParsePrefix("127.0.0.0/8")),
+		WithProxyProtocol(),
+		WithMiddleware(capture),
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.srv.Serve(&proxyListener{Listener: ln, trusted: srv.isTrusted}) }()
+	defer srv.srv.Shutdown()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 5555 80\r\nGET /healthz HTTP/1.1\r\nHost: sink\r\n\r\n"))
+	require.NoError(t, err)
+
+	var resp fasthttp.Response
+	require.NoError(t, resp.Read(bufio.NewReader(conn)))
+	assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	assert.Equal(t, "203.0.113.7", got.String())
+}
@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+)
+
+// Decoded remote_write requests above this are refused, whatever the
+// compressed size.
+const maxRemoteWriteSize = 32 << 20
+
+var errMalformedProto = errors.New("malformed protobuf")
+
+type remoteWrite struct {
+	labels []string
+	scale  float64
+}
+
+// WithRemoteWrite accepts Prometheus remote_write requests on
+// /api/v1/write. Each sample becomes an event named after its metric,
+// followed by the values of labels, in order and dot-separated, for those
+// the series has, e.g. "node_load1.gw-07" for labels ["instance"]. Values
+// are multiplied by scale (0 means 1) and rounded, as events hold integers.
+func WithRemoteWrite(labels []string, scale float64) Option {
+	if scale == 0 {
+		scale = 1
+	}
+	return func(s *Server) { s.remoteWrite = &remoteWrite{labels: labels, scale: scale} }
+}
+
+func (s *Server) handleRemoteWrite(ctx *fasthttp.RequestCtx) {
+	if s.remoteWrite == nil {
+		ctx.Error("remote write not enabled", fasthttp.StatusNotFound)
+		return
+	}
+	if enc := string(ctx.Request.Header.ContentEncoding()); enc != "snappy" {
+		ctx.Error("use Content-Encoding: snappy", fasthttp.StatusUnsupportedMediaType)
+		return
+	}
+	if strings.Contains(string(ctx.Request.Header.ContentType()), "io.prometheus.write.v2") {
+		ctx.Error("only remote write 1.0 is supported", fasthttp.StatusUnsupportedMediaType)
+		return
+	}
+
+	body := ctx.PostBody()
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		ctx.Error("invalid snappy body", fasthttp.StatusBadRequest)
+		return
+	}
+	if n > maxRemoteWriteSize {
+		ctx.Error("request too large", fasthttp.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		ctx.Error("invalid snappy body", fasthttp.StatusBadRequest)
+		return
+	}
+	series, err := decodeWriteRequest(data)
+	if err != nil {
+		reqLog(ctx).Warn("remote write parse error", "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	var res BatchResult
+	for _, ts := range series {
+		res.Total += len(ts.samples)
+	}
+	i := 0
+	for _, ts := range series {
+		sensor := s.remoteWrite.sensor(ts.labels)
+		for _, smp := range ts.samples {
+			i++
+			// NaN also marks a stale series
+			if sensor == "" || math.IsNaN(smp.value) || math.IsInf(smp.value, 0) {
+				remoteWriteSkipped.Inc()
+				continue
+			}
+			remoteWriteSamples.Inc()
+			ev := entity.Event{
+				// Prometheus resends whole requests after a 5xx; the
+				// sample's identity lets dedup drop the ones already taken
+				IdempotencyID: sensor + "@" + strconv.FormatInt(smp.timestamp, 10),
+				Sensor:        sensor,
+				Value:         int(math.Round(smp.value * s.remoteWrite.scale)),
+				UnixTimestamp: smp.timestamp,
+			}
+			if !s.appendBatchEvent(ctx, &res, ev, i-1, s.sink.Append) {
+				return
+			}
+		}
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// sensor names a series, or returns "" for one without a metric name.
+func (rw *remoteWrite) sensor(labels []promLabel) string {
+	var name string
+	for _, l := range labels {
+		if l.name == "__name__" {
+			name = l.value
+			break
+		}
+	}
+	if name == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, want := range rw.labels {
+		for _, l := range labels {
+			if l.name == want && l.value != "" {
+				b.WriteByte('.')
+				b.WriteString(l.value)
+				break
+			}
+		}
+	}
+	return b.String()
+}
+
+type promLabel struct {
+	name, value string
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64 // Unix milliseconds
+}
+
+type promSeries struct {
+	labels  []promLabel
+	samples []promSample
+}
+
+// decodeWriteRequest reads the timeseries of a prometheus.WriteRequest.
+// Metadata, exemplars and native histograms are skipped.
+func decodeWriteRequest(b []byte) ([]promSeries, error) {
+	var series []promSeries
+	err := protoFields(b, func(num int, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var ts promSeries
+		err := protoFields(v, func(num int, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				var l promLabel
+				err := protoFields(v, func(num int, v []byte, _ uint64) error {
+					switch num {
+					case 1:
+						l.name = string(v)
+					case 2:
+						l.value = string(v)
+					}
+					return nil
+				})
+				ts.labels = append(ts.labels, l)
+				return err
+			case 2:
+				var smp promSample
+				err := protoFields(v, func(num int, _ []byte, x uint64) error {
+					switch num {
+					case 1:
+						smp.value = math.Float64frombits(x)
+					case 2:
+						smp.timestamp = int64(x)
+					}
+					return nil
+				})
+				ts.samples = append(ts.samples, smp)
+				return err
+			}
+			return nil
+		})
+		series = append(series, ts)
+		return err
+	})
+	return series, err
+}
+
+// protoFields calls fn for each field of a protobuf message with the
+// field number and either its bytes, for length-delimited fields, or its
+// value, for varint and fixed-width ones.
+func protoFields(b []byte, fn func(num int, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformedProto
+		}
+		b = b[n:]
+
+		var (
+			v []byte
+			x uint64
+		)
+		switch key & 7 {
+		case 0: // varint
+			if x, n = binary.Uvarint(b); n <= 0 {
+				return errMalformedProto
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errMalformedProto
+			}
+			x, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errMalformedProto
+			}
+			v, b = b[n:n+int(l)], b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errMalformedProto
+			}
+			x, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return errMalformedProto
+		}
+
+		if err := fn(int(key>>3), v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+)
+
+// protobuf encoding helpers for building WriteRequests by hand
+
+func protoBytes(num int, v []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoSample(value float64, ts int64) []byte {
+	b := binary.AppendUvarint(nil, 1<<3|1)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+	b = binary.AppendUvarint(b, 2<<3|0)
+	return binary.AppendUvarint(b, uint64(ts))
+}
+
+func protoSeries(labels map[string]string, samples ...[]byte) []byte {
+	var b []byte
+	for k, v := range labels {
+		b = append(b, protoBytes(1, append(protoBytes(1, []byte(k)), protoBytes(2, []byte(v))...))...)
+	}
+	for _, s := range samples {
+		b = append(b, protoBytes(2, s)...)
+	}
+	return protoBytes(1, b)
+}
+
+func newRemoteWriteRequest(series ...[]byte) *fasthttp.RequestCtx {
+	var body []byte
+	for _, s := range series {
+		body = append(body, s...)
+	}
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/api/v1/write")
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.Header.SetContentType("application/x-protobuf")
+	ctx.Request.Header.Set("Content-Encoding", "snappy")
+	ctx.Request.SetBody(snappy.Encode(nil, body))
+	return ctx
+}
+
+func TestRemoteWrite(t *testing.T) {
+	t.Run("samples become events", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := New(sink, WithRemoteWrite([]string{"instance"}, 10))
+
+		ctx := newRemoteWriteRequest(
+			protoSeries(map[string]string{"__name__": "node_load1", "instance": "gw-07", "job": "node"},
+				protoSample(0.54, 1000), protoSample(math.NaN(), 2000)),
+			protoSeries(map[string]string{"__name__": "up"}, protoSample(1, 1500)),
+			protoSeries(map[string]string{"job": "nameless"}, protoSample(3, 1500)),
+		)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+		assert.Equal(t, []entity.Event{
+			{IdempotencyID: "node_load1.gw-07@1000", Sensor: "node_load1.gw-07", Value: 5, UnixTimestamp: 1000},
+			{IdempotencyID: "up@1500", Sensor: "up", Value: 10, UnixTimestamp: 1500},
+		}, sink.events)
+	})
+
+	t.Run("sink errors are passed on", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrRateLimited}, WithRemoteWrite(nil, 0))
+
+		ctx := newRemoteWriteRequest(protoSeries(map[string]string{"__name__": "up"}, protoSample(1, 1)))
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		srv := New(&mockSink{}, WithRemoteWrite(nil, 0))
+
+		ctx := newRemoteWriteRequest([]byte{0x0a, 0x7f, 0x01})
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+		ctx = newRemoteWriteRequest(protoSeries(map[string]string{"__name__": "up"}))
+		ctx.Request.SetBody([]byte("not snappy"))
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+		ctx = newRemoteWriteRequest(protoSeries(map[string]string{"__name__": "up"}))
+		ctx.Request.Header.Del("Content-Encoding")
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusUnsupportedMediaType, ctx.Response.StatusCode())
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		ctx := newRemoteWriteRequest()
+		New(&mockSink{}).handle(ctx)
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+}
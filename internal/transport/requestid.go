@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"bytes"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// requestID tags every request with an ID, taken from X-Request-ID when the
+// client or a proxy in front already set a usable one, generated
+// otherwise. It is echoed in the X-Request-ID response header, appended to
+// plain text error bodies and logged with every line about the request.
+func (s *Server) requestID(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id := string(ctx.Request.Header.Peek(RequestIDHeader))
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		ctx.SetUserValue(requestIDKey{}, id)
+
+		next(ctx)
+
+		// handlers' ctx.Error drops headers set before it, so set it last
+		ctx.Response.Header.Set(RequestIDHeader, id)
+		if ctx.Response.StatusCode() >= fasthttp.StatusBadRequest && !ctx.Response.IsBodyStream() &&
+			len(ctx.Response.Body()) > 0 && len(ctx.Response.Header.ContentEncoding()) == 0 &&
+			bytes.HasPrefix(ctx.Response.Header.ContentType(), []byte("text/plain")) {
+			ctx.Response.AppendBodyString("\nrequest_id: " + id)
+		}
+	}
+}
+
+// RequestID returns the ID of the request ctx is serving, for middlewares
+// that want to log or forward it.
+func RequestID(ctx *fasthttp.RequestCtx) string {
+	id, _ := ctx.UserValue(requestIDKey{}).(string)
+	return id
+}
+
+// reqLog returns the default logger with the request's ID and client
+// address attached.
+func reqLog(ctx *fasthttp.RequestCtx) *slog.Logger {
+	l := slog.Default()
+	if id := RequestID(ctx); id != "" {
+		l = l.With("request_id", id)
+	}
+	if ip := ClientIP(ctx); ip.IsValid() {
+		l = l.With("client_ip", ip.String())
+	}
+	return l
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so a
+// client can't inject log lines or oversized headers through it.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRequestID(t *testing.T) {
+	do := func(srv *Server, uri, id string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		if id != "" {
+			ctx.Request.Header.Set(RequestIDHeader, id)
+		}
+		srv.handle(ctx)
+		return ctx
+	}
+
+	t.Run("generated", func(t *testing.T) {
+		ctx := do(New(&mockSink{}), "/healthz", "")
+
+		id := string(ctx.Response.Header.Peek(RequestIDHeader))
+		assert.Len(t, id, 36)
+		assert.Equal(t, "ok", string(ctx.Response.Body()))
+	})
+
+	t.Run("accepted from the client", func(t *testing.T) {
+		var seen string
+		mw := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+			return func(ctx *fasthttp.RequestCtx) {
+				seen = RequestID(ctx)
+				next(ctx)
+			}
+		}
+		ctx := do(New(&mockSink{}, WithMiddleware(mw)), "/healthz", "edge-07-1234")
+
+		assert.Equal(t, "edge-07-1234", seen)
+		assert.Equal(t, "edge-07-1234", string(ctx.Response.Header.Peek(RequestIDHeader)))
+	})
+
+	t.Run("unusable id replaced", func(t *testing.T) {
+		for _, id := range []string{"has space", strings.Repeat("x", maxRequestIDLen+1)} {
+			ctx := do(New(&mockSink{}), "/healthz", id)
+			assert.NotEqual(t, id, string(ctx.Response.Header.Peek(RequestIDHeader)))
+		}
+	})
+
+	t.Run("in error bodies", func(t *testing.T) {
+		ctx := do(New(&mockSink{}), "/nope", "abc")
+
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+		assert.Equal(t, "not found\nrequest_id: abc", string(ctx.Response.Body()))
+		assert.Equal(t, "abc", string(ctx.Response.Header.Peek(RequestIDHeader)))
+	})
+}
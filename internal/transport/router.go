@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Middleware wraps a handler to add cross-cutting behaviour such as auth,
+// access logging or CORS without touching the route handlers.
+type Middleware func(next fasthttp.RequestHandler) fasthttp.RequestHandler
+
+// chain composes middlewares so the first one is outermost.
+func chain(h fasthttp.RequestHandler, mws ...Middleware) fasthttp.RequestHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// router dispatches on the exact request path, then on the method. HEAD
+// is served wherever GET is, with the body left out by fasthttp, and
+// OPTIONS everywhere, answered with the route's Allow header. A path
+// ending in a slash also takes every path below it that has no route of
+// its own, the longest such prefix winning. With a base, routes are
+// matched against what follows it, and paths outside it aren't found. A
+// version prefix after the base, e.g. /v2, is cut off too and picks the
+// API version.
+type router struct {
+	base     string
+	routes   map[string]*route
+	subtrees []string // paths ending in a slash, longest first
+}
+
+// routeKey holds the path of the route serving a request, which for a
+// subtree differs from the request's.
+type routeKey struct{}
+
+// localPathKey holds the request's path without the base and version
+// prefix, as matched against the routes.
+type localPathKey struct{}
+
+// routePath returns the path of the route ctx was served by, without the
+// base path, or its own path if none matched.
+func routePath(ctx *fasthttp.RequestCtx) string {
+	if p, ok := ctx.UserValue(routeKey{}).(string); ok {
+		return p
+	}
+	return string(ctx.Path())
+}
+
+// routeLabel is routePath for labels that must stay bounded: requests no
+// route served, unknown paths among them, are all "other".
+func routeLabel(ctx *fasthttp.RequestCtx) string {
+	if p, ok := ctx.UserValue(routeKey{}).(string); ok {
+		return p
+	}
+	return "other"
+}
+
+type route struct {
+	h     fasthttp.RequestHandler
+	allow []string
+}
+
+func newRouter(base string) *router {
+	return &router{base: base, routes: make(map[string]*route)}
+}
+
+func (r *router) handle(path string, h fasthttp.RequestHandler, methods ...string) {
+	allow := slices.Clone(methods)
+	if slices.Contains(allow, fasthttp.MethodGet) && !slices.Contains(allow, fasthttp.MethodHead) {
+		allow = append(allow, fasthttp.MethodHead)
+	}
+	allow = append(allow, fasthttp.MethodOptions)
+	r.routes[path] = &route{h: h, allow: allow}
+	if strings.HasSuffix(path, "/") {
+		r.subtrees = append(r.subtrees, path)
+		slices.SortFunc(r.subtrees, func(a, b string) int { return len(b) - len(a) })
+	}
+}
+
+func (r *router) match(path string) (string, *route) {
+	if rt, ok := r.routes[path]; ok {
+		return path, rt
+	}
+	for _, p := range r.subtrees {
+		if strings.HasPrefix(path, p) {
+			return p, r.routes[p]
+		}
+	}
+	return "", nil
+}
+
+func (r *router) serve(ctx *fasthttp.RequestCtx) {
+	local, ok := strings.CutPrefix(string(ctx.Path()), r.base)
+	var (
+		path string
+		rt   *route
+	)
+	if ok && strings.HasPrefix(local, "/") {
+		if v, rest, ok := cutVersion(local); ok {
+			ctx.SetUserValue(apiVersionKey{}, v)
+			local = rest
+		}
+		path, rt = r.match(local)
+	}
+	if rt == nil {
+		ctx.Error("not found", fasthttp.StatusNotFound)
+		return
+	}
+	ctx.SetUserValue(routeKey{}, path)
+	ctx.SetUserValue(localPathKey{}, local)
+	switch method := string(ctx.Method()); {
+	case method == fasthttp.MethodOptions:
+		ctx.Response.Header.Set("Allow", strings.Join(rt.allow, ", "))
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	case slices.Contains(rt.allow, method):
+		rt.h(ctx)
+	default:
+		methodNotAllowed(ctx, rt.allow)
+	}
+}
+
+// MethodNotAllowed is the body of a 405, listing the methods the path
+// takes, as the Allow header does.
+type MethodNotAllowed struct {
+	Error string   `json:"error"`
+	Allow []string `json:"allow"`
+}
+
+func methodNotAllowed(ctx *fasthttp.RequestCtx, allow []string) {
+	body, err := json.Marshal(MethodNotAllowed{Error: "method not allowed", Allow: allow})
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Allow", strings.Join(allow, ", "))
+	ctx.SetBody(body)
+}
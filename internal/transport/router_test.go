@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Run("runs in order around the route", func(t *testing.T) {
+		var calls []string
+		mw := func(name string) Middleware {
+			return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+				return func(ctx *fasthttp.RequestCtx) {
+					calls = append(calls, name+" in")
+					next(ctx)
+					calls = append(calls, name+" out")
+				}
+			}
+		}
+		srv := New(&mockSink{}, WithMiddleware(mw("outer"), mw("inner")))
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/healthz")
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, []string{"outer in", "inner in", "inner out", "outer out"}, calls)
+	})
+
+	t.Run("can short-circuit", func(t *testing.T) {
+		deny := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+			return func(ctx *fasthttp.RequestCtx) {
+				ctx.Error("forbidden", fasthttp.StatusForbidden)
+			}
+		}
+		sink := &mockSink{}
+		srv := New(sink, WithMiddleware(deny))
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusForbidden, ctx.Response.StatusCode())
+		assert.Empty(t, sink.events)
+	})
+}
+
+func TestRouter(t *testing.T) {
+	r := newRouter("")
+	r.handle("/a", func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusTeapot) }, fasthttp.MethodGet)
+	r.handle("/b", func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusTeapot) }, fasthttp.MethodPost)
+
+	req := func(method, path string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(path)
+		r.serve(ctx)
+		return ctx
+	}
+
+	ctx := req(fasthttp.MethodGet, "/a")
+	assert.Equal(t, fasthttp.StatusTeapot, ctx.Response.StatusCode())
+
+	ctx = req(fasthttp.MethodGet, "/a/b")
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+
+	t.Run("subtree", func(t *testing.T) {
+		r.handle("/a/", func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusAccepted) }, fasthttp.MethodGet)
+		r.handle("/a/c/", func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusCreated) }, fasthttp.MethodGet)
+
+		ctx := req(fasthttp.MethodGet, "/a/b")
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.Equal(t, "/a/", routePath(ctx))
+		ctx = req(fasthttp.MethodGet, "/a/c/d")
+		assert.Equal(t, fasthttp.StatusCreated, ctx.Response.StatusCode(), "longest prefix")
+		ctx = req(fasthttp.MethodGet, "/a")
+		assert.Equal(t, fasthttp.StatusTeapot, ctx.Response.StatusCode())
+		assert.Equal(t, "/a", routePath(ctx))
+		ctx = req(fasthttp.MethodGet, "/ab")
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+
+	t.Run("head served by get", func(t *testing.T) {
+		ctx := req(fasthttp.MethodHead, "/a")
+		assert.Equal(t, fasthttp.StatusTeapot, ctx.Response.StatusCode())
+
+		ctx = req(fasthttp.MethodHead, "/b")
+		assert.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+	})
+
+	t.Run("options", func(t *testing.T) {
+		ctx := req(fasthttp.MethodOptions, "/a")
+		assert.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+		assert.Equal(t, "GET, HEAD, OPTIONS", string(ctx.Response.Header.Peek("Allow")))
+
+		ctx = req(fasthttp.MethodOptions, "/b")
+		assert.Equal(t, "POST, OPTIONS", string(ctx.Response.Header.Peek("Allow")))
+	})
+
+	t.Run("base path", func(t *testing.T) {
+		r := newRouter("/iot/v1")
+		r.handle("/a", func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusTeapot) }, fasthttp.MethodGet)
+		serve := func(path string) *fasthttp.RequestCtx {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.SetMethod(fasthttp.MethodGet)
+			ctx.Request.SetRequestURI(path)
+			r.serve(ctx)
+			return ctx
+		}
+
+		ctx := serve("/iot/v1/a")
+		assert.Equal(t, fasthttp.StatusTeapot, ctx.Response.StatusCode())
+		assert.Equal(t, "/a", routePath(ctx), "labels leave the base out")
+		for _, path := range []string{"/a", "/iot/v1", "/iot/v1a", "/iot/a"} {
+			assert.Equal(t, fasthttp.StatusNotFound, serve(path).Response.StatusCode(), path)
+		}
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		ctx := req(fasthttp.MethodDelete, "/b")
+		assert.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+		assert.Equal(t, "POST, OPTIONS", string(ctx.Response.Header.Peek("Allow")))
+		assert.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+		assert.JSONEq(t, `{"error":"method not allowed","allow":["POST","OPTIONS"]}`, string(ctx.Response.Body()))
+	})
+}
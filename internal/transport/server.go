@@ -1,28 +1,39 @@
 package transport
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
+	"github.com/tinylib/msgp/msgp"
 	"github.com/valyala/fasthttp"
 
 	"github.com/andriibeee/iotdemo/internal/entity"
 	apperr "github.com/andriibeee/iotdemo/internal/errors"
+	"github.com/andriibeee/iotdemo/internal/replication"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
 )
 
 var ErrNilSink = errors.New("sink is nil")
 
+// LeaderHeader carries the leader's URL on ingest refused by a follower.
+const LeaderHeader = "X-Leader"
+
 type TLSConfig struct {
 	CertFile string
 	KeyFile  string
@@ -30,10 +41,45 @@ type TLSConfig struct {
 }
 
 type Server struct {
-	srv  *fasthttp.Server
-	sink Sink
-	addr string
-	tls  *TLSConfig
+	srv     *fasthttp.Server
+	sink    Sink
+	addr    string
+	tls     *TLSConfig
+	quota   QuotaReporter
+	stats   StatsReporter
+	journal JournalAdmin
+	sampler SamplingAdmin
+	dedup   DedupAdmin
+	audit   AuditLog
+	canary  Canary
+	devices DeviceRegistry
+	batches *batchCache
+
+	events    EventPager
+	eventKeys sink.KeyCodec
+
+	remoteWrite *remoteWrite
+	backfill    bool
+
+	replica      ReplicationReceiver
+	replicaToken []byte
+	follower     Follower
+	adminToken   []byte // nil leaves /admin/ unserved
+
+	trusted       []netip.Prefix
+	proxyProtocol bool
+	ipFilter      *IPFilter // nil lets every client in
+
+	batchWorkers int // 0 = GOMAXPROCS
+	batchLimit   *batchLimiter
+	decodeJSON   JSONDecoder
+
+	tenants *tenantLabels // nil leaves durations unlabeled by tenant
+
+	basePath    string // "" serves routes at the root
+	middlewares []Middleware
+	handler     fasthttp.RequestHandler
+	compressMin int // 0 leaves responses uncompressed
 }
 
 type Option func(*Server)
@@ -69,67 +115,345 @@ func WithClientCA(ca string) Option {
 	}
 }
 
+func WithQuota(q QuotaReporter) Option {
+	return func(s *Server) { s.quota = q }
+}
+
+func WithStats(st StatsReporter) Option {
+	return func(s *Server) { s.stats = st }
+}
+
+func WithJournal(j JournalAdmin) Option {
+	return func(s *Server) { s.journal = j }
+}
+
+// WithEvents serves the events in the journal on /events, a page at a
+// time; keys is the codec the journal's keys were written with, for
+// ?sensor.
+func WithEvents(p EventPager, keys sink.KeyCodec) Option {
+	return func(s *Server) {
+		s.events = p
+		s.eventKeys = keys
+	}
+}
+
+// WithReplication takes journal entries from primary sinks on
+// /replication/entries, for a standby gateway. Every request needs
+// "Authorization: Bearer <token>".
+func WithReplication(r ReplicationReceiver, token string) Option {
+	return func(s *Server) {
+		s.replica = r
+		s.replicaToken = []byte(token)
+	}
+}
+
+// WithAdminToken serves the /admin/ routes, which can delete journal
+// segments and change what's accepted, to requests with
+// "Authorization: Bearer <token>". Without a token they answer 404.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		if token != "" {
+			s.adminToken = []byte(token)
+		}
+	}
+}
+
+// WithFollower refuses ingest with 503 while f is following, pointing
+// writers to the leader in an X-Leader header. Reads are served as usual.
+func WithFollower(f Follower) Option {
+	return func(s *Server) { s.follower = f }
+}
+
+// WithSampling serves the sampling rules on /admin/sampling, where PUT
+// replaces them.
+func WithSampling(sa SamplingAdmin) Option {
+	return func(s *Server) { s.sampler = sa }
+}
+
+// WithDedup serves what dedup remembers on /admin/dedup, where DELETE
+// forgets an ID.
+func WithDedup(d DedupAdmin) Option {
+	return func(s *Server) { s.dedup = d }
+}
+
+// WithAudit records admin actions in a, served back on /admin/audit.
+func WithAudit(a AuditLog) Option {
+	return func(s *Server) { s.audit = a }
+}
+
+// WithCanary fails /readyz while the canary's rounds fail.
+func WithCanary(c Canary) Option {
+	return func(s *Server) { s.canary = c }
+}
+
+// WithBatchDedup answers exact replays of an accepted batch with 202 for
+// ttl without processing them again. A replay is a batch with the same
+// Idempotency-Key header or, without one, the same body.
+func WithBatchDedup(ttl time.Duration) Option {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.batches = newBatchCache(ttl)
+		}
+	}
+}
+
+// JSONDecoder decodes a JSON event; entity.DecodeJSON and StdJSON are the
+// two the sink offers.
+type JSONDecoder func(data []byte, ev *entity.Event) error
+
+// StdJSON decodes events with encoding/json alone.
+func StdJSON(data []byte, ev *entity.Event) error {
+	*ev = entity.Event{}
+	return json.Unmarshal(data, ev)
+}
+
+// WithJSONDecoder replaces entity.DecodeJSON for JSON and NDJSON events.
+func WithJSONDecoder(decode JSONDecoder) Option {
+	return func(s *Server) { s.decodeJSON = decode }
+}
+
+// WithBatchWorkers decodes large NDJSON batches with up to n goroutines,
+// GOMAXPROCS by default; 1 decodes them in the request's goroutine.
+func WithBatchWorkers(n int) Option {
+	return func(s *Server) { s.batchWorkers = n }
+}
+
+// WithBatchLimit processes at most concurrency batches at once, across
+// /ingest/batch and /ingest/backfill. Others wait in line, at most queue
+// of them (0 for no limit) and for at most wait each, before getting 503.
+func WithBatchLimit(concurrency, queue int, wait time.Duration) Option {
+	return func(s *Server) {
+		if concurrency > 0 {
+			s.batchLimit = newBatchLimiter(concurrency, queue, wait)
+		}
+	}
+}
+
+// WithBackfill accepts batches of historical events on /ingest/backfill,
+// appended with the sink's AppendBackfill.
+func WithBackfill() Option {
+	return func(s *Server) { s.backfill = true }
+}
+
+// WithBasePath mounts every route under p, e.g. "/iot/v1" for a reverse
+// proxy shared with other services that forwards paths as they are.
+// Requests outside it get 404. Route labels in metrics leave it out.
+func WithBasePath(p string) Option {
+	return func(s *Server) {
+		if p = strings.Trim(p, "/"); p != "" {
+			s.basePath = "/" + p
+		}
+	}
+}
+
+// WithMiddleware adds request middlewares; the first one is outermost.
+// They run inside the built-in request metrics, so rejected requests are
+// still counted.
+func WithMiddleware(mws ...Middleware) Option {
+	return func(s *Server) { s.middlewares = append(s.middlewares, mws...) }
+}
+
 func New(sink Sink, opts ...Option) *Server {
 	s := &Server{
-		sink: sink,
-		addr: ":8080",
-		srv:  &fasthttp.Server{},
+		sink:       sink,
+		addr:       ":8080",
+		srv:        &fasthttp.Server{},
+		decodeJSON: entity.DecodeJSON,
 	}
 	for _, opt := range opts {
 		opt(s)
 	}
+
+	r := newRouter(s.basePath)
+	r.handle("/ingest", s.leaderOnly(s.handleEvent), fasthttp.MethodPost)
+	r.handle("/ingest/batch", s.leaderOnly(s.limitBatches(s.handleBatch)), fasthttp.MethodPost)
+	r.handle("/ingest/backfill", s.leaderOnly(s.limitBatches(s.handleBackfill)), fasthttp.MethodPost)
+	r.handle("/api/v1/write", s.leaderOnly(s.handleRemoteWrite), fasthttp.MethodPost)
+	r.handle("/healthz", s.handleHealth, fasthttp.MethodGet)
+	r.handle("/readyz", s.handleReady, fasthttp.MethodGet)
+	r.handle("/metrics", s.handleMetrics, fasthttp.MethodGet)
+	r.handle("/openapi.json", s.handleOpenAPI, fasthttp.MethodGet)
+	r.handle("/sensors", s.handleSensors, fasthttp.MethodGet)
+	r.handle("/device", s.leaderOnly(s.handleDevice), fasthttp.MethodPost)
+	r.handle("/devices", s.handleDevices, fasthttp.MethodGet)
+	r.handle("/events", s.handleEvents, fasthttp.MethodGet)
+	r.handle("/events/", s.handleJournalEvent, fasthttp.MethodGet)
+	r.handle("/tail", s.handleTail, fasthttp.MethodGet)
+	r.handle("/role", s.handleRole, fasthttp.MethodGet)
+	r.handle("/replication/entries", s.handleReplication, fasthttp.MethodPost)
+	admin := func(path string, h fasthttp.RequestHandler, methods ...string) {
+		r.handle(path, s.adminOnly(h), methods...)
+	}
+	admin("/admin/quota", s.handleQuota, fasthttp.MethodGet)
+	admin("/admin/sampling", s.audited("sampling.replace", s.handleSampling), fasthttp.MethodGet, fasthttp.MethodPut)
+	admin("/admin/dedup", s.audited("dedup.purge", s.handleDedup), fasthttp.MethodGet, fasthttp.MethodDelete)
+	admin("/admin/journal/truncate", s.audited("journal.truncate", s.handleTruncate), fasthttp.MethodPost)
+	admin("/admin/journal/compact", s.audited("journal.compact", s.handleCompact), fasthttp.MethodPost)
+	admin("/admin/journal/gaps", s.handleGaps, fasthttp.MethodGet)
+	admin("/admin/journal/segments", s.handleSegments, fasthttp.MethodGet)
+	admin("/admin/journal/rotate", s.audited("journal.rotate", s.handleRotate), fasthttp.MethodPost)
+	admin("/admin/flush", s.audited("sink.flush", s.handleFlush), fasthttp.MethodPost)
+	admin("/admin/audit", s.handleAudit, fasthttp.MethodGet)
+
+	mws := append([]Middleware{s.instrument, s.clientIP, s.filterIP, s.requestID, s.apiVersion, s.requireSink}, s.middlewares...)
+	if s.compressMin > 0 {
+		mws = append(mws, s.compress)
+	}
+	s.handler = chain(r.serve, mws...)
 	s.srv.Handler = s.handle
+	s.srv.ContinueHandler = s.continueBody
+	s.srv.ErrorHandler = readError
 	return s
 }
 
 func (s *Server) handle(ctx *fasthttp.RequestCtx) {
-	start := time.Now()
-	path := string(ctx.Path())
-
-	requestsTotal.Inc()
-	activeRequests.Inc()
-	defer activeRequests.Dec()
-
-	requestSize.Update(float64(len(ctx.Request.Body())))
-
-	if s.sink == nil {
-		slog.Error("sink not configured")
-		ctx.Error(ErrNilSink.Error(), fasthttp.StatusInternalServerError)
-		s.recordMetrics(path, fasthttp.StatusInternalServerError, start, ctx)
-		return
-	}
-
-	switch path {
-	case "/ingest":
-		s.handleEvent(ctx)
-	case "/ingest/batch":
-		s.handleBatch(ctx)
-	case "/healthz":
-		ctx.SetContentType("text/plain; charset=utf-8")
-		ctx.SetStatusCode(fasthttp.StatusOK)
-		ctx.SetBodyString("ok")
-	case "/metrics":
-		ctx.SetContentType("text/plain; charset=utf-8")
-		metrics.WritePrometheus(ctx, true)
-	default:
-		ctx.Error("not found", fasthttp.StatusNotFound)
+	s.handler(ctx)
+}
+
+func (s *Server) instrument(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+
+		requestsTotal.Inc()
+		activeRequests.Inc()
+		defer activeRequests.Dec()
+
+		requestSize.Update(float64(len(ctx.Request.Body())))
+
+		next(ctx)
+
+		requestsByPathAndStatus(routePath(ctx), ctx.Response.StatusCode()).Inc()
+		requestDuration.UpdateDuration(start)
+		routeDuration(routeLabel(ctx), s.tenants.label(ctx)).UpdateDuration(start)
+		// reading a streamed body here would wait for the stream to end
+		if !ctx.Response.IsBodyStream() {
+			responseSize.Update(float64(len(ctx.Response.Body())))
+		}
 	}
+}
 
-	s.recordMetrics(path, ctx.Response.StatusCode(), start, ctx)
+func (s *Server) requireSink(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if s.sink == nil {
+			reqLog(ctx).Error("sink not configured")
+			ctx.Error(ErrNilSink.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		next(ctx)
+	}
 }
 
-func (s *Server) recordMetrics(path string, status int, start time.Time, ctx *fasthttp.RequestCtx) {
-	requestsByPathAndStatus(path, status).Inc()
-	requestDuration.UpdateDuration(start)
-	responseSize.Update(float64(len(ctx.Response.Body())))
+// leaderOnly wraps an ingest handler to refuse requests while the sink
+// follows another.
+func (s *Server) leaderOnly(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if s.follower != nil {
+			if leader, ok := s.follower.Leader(); ok {
+				followerRejected.Inc()
+				if leader == "" {
+					ctx.Error("follower: leader unknown", fasthttp.StatusServiceUnavailable)
+					return
+				}
+				ctx.Error("follower: send writes to the leader at "+leader, fasthttp.StatusServiceUnavailable)
+				ctx.Response.Header.Set(LeaderHeader, leader)
+				return
+			}
+		}
+		next(ctx)
+	}
 }
 
-func (s *Server) handleEvent(ctx *fasthttp.RequestCtx) {
-	if !ctx.IsPost() {
-		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+// adminOnly wraps an admin handler to refuse requests without the admin
+// token, before they're audited.
+func (s *Server) adminOnly(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if s.adminToken == nil {
+			ctx.Error("admin API not enabled", fasthttp.StatusNotFound)
+			return
+		}
+		if !bearer(ctx, s.adminToken) {
+			adminUnauthorized.Inc()
+			ctx.Error("unauthorized", fasthttp.StatusUnauthorized)
+			ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer realm="admin"`)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// bearer reports whether ctx carries "Authorization: Bearer <token>";
+// never for an empty token.
+func bearer(ctx *fasthttp.RequestCtx, token []byte) bool {
+	got, ok := strings.CutPrefix(string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)), "Bearer ")
+	return ok && len(token) > 0 && subtle.ConstantTimeCompare([]byte(got), token) == 1
+}
+
+func (s *Server) handleHealth(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString("ok")
+}
+
+// Readiness is the body of /readyz.
+type Readiness struct {
+	Ready    bool             `json:"ready"`
+	Degraded bool             `json:"degraded"`
+	Canary   *CanaryReadiness `json:"canary,omitempty"`
+}
+
+// CanaryReadiness is the latest canary round, as /readyz reports it.
+type CanaryReadiness struct {
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LatencyMs   float64    `json:"latency_ms"`
+	Seq         uint64     `json:"seq,omitempty"`
+	Failures    int        `json:"failures"`
+	Error       string     `json:"error,omitempty"`
+	Paused      bool       `json:"paused,omitempty"`
+}
+
+// handleReady answers 503 while events accepted now might not reach the
+// journal: flushes keep failing, or the canary's rounds do. Unlike
+// /healthz it is meant to take the sink out of rotation, not restart it.
+func (s *Server) handleReady(ctx *fasthttp.RequestCtx) {
+	var r Readiness
+	if d, ok := s.sink.(Degrader); ok {
+		r.Degraded = d.Degraded()
+	}
+	r.Ready = !r.Degraded
+	if s.canary != nil {
+		st := s.canary.Status()
+		r.Canary = &CanaryReadiness{
+			LatencyMs: float64(st.Latency) / float64(time.Millisecond),
+			Seq:       st.Seq,
+			Failures:  st.Failures,
+			Error:     st.Error,
+			Paused:    st.Paused,
+		}
+		if !st.LastSuccess.IsZero() {
+			t := st.LastSuccess.UTC()
+			r.Canary.LastSuccess = &t
+		}
+		r.Ready = r.Ready && st.Healthy()
+	}
+	if !r.Ready {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+func (s *Server) handleMetrics(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; charset=utf-8")
+	metrics.WritePrometheus(ctx, true)
+}
 
+func (s *Server) handleEvent(ctx *fasthttp.RequestCtx) {
 	ct := ctx.Request.Header.ContentType()
 
 	body := ctx.PostBody()
@@ -141,7 +465,7 @@ func (s *Server) handleEvent(ctx *fasthttp.RequestCtx) {
 	var ev entity.Event
 	switch {
 	case bytes.Equal(ct, []byte("application/json")):
-		if err := json.Unmarshal(body, &ev); err != nil {
+		if err := s.decodeJSON(body, &ev); err != nil {
 			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
 			return
 		}
@@ -154,32 +478,183 @@ func (s *Server) handleEvent(ctx *fasthttp.RequestCtx) {
 		ctx.Error("unsupported content-type", fasthttp.StatusUnsupportedMediaType)
 		return
 	}
+	if len(ev.Fields) > entity.MaxFields {
+		ctx.Error(errTooManyFields, fasthttp.StatusBadRequest)
+		return
+	}
+
+	if args := ctx.QueryArgs(); args.GetBool("seq") || args.GetBool("durable") {
+		s.appendSeq(ctx, ev, args.GetBool("durable"))
+		return
+	}
 
 	if err := s.sink.Append(ev); err != nil {
-		switch {
-		case errors.Is(err, apperr.ErrRateLimited):
-			ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
-		case errors.Is(err, apperr.ErrDuplicate):
-			ctx.SetStatusCode(fasthttp.StatusConflict)
-		default:
-			slog.Error("sink.Append failed", "error", err, "sensor", ev.Sensor)
-			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
-		}
+		appendError(ctx, ev, err)
 		return
 	}
 
 	ctx.SetStatusCode(fasthttp.StatusAccepted)
 }
 
+// errTooManyFields rejects JSON events with more fields than msgpack
+// decoding takes, so both formats accept the same events.
+var errTooManyFields = "more than " + strconv.Itoa(entity.MaxFields) + " fields"
+
+// overloadRetryAfter is the Retry-After, in seconds, of a 503 for memory
+// pressure, which takes longer to ease than a full buffer.
+const overloadRetryAfter = "5"
+
+// storageRetryAfter is the Retry-After, in seconds, of a 507: disk space
+// comes back with compaction or an operator, slower still.
+const storageRetryAfter = "30"
+
+// appendSeqTimeout bounds how long /ingest?seq=true waits for a flush,
+// and ?durable=true for the fsync after it.
+const appendSeqTimeout = 5 * time.Second
+
+// appendSeq answers with the journal sequence number ev was written with,
+// once it's flushed, or with durable once it's fsynced too, and with
+// events served, where to read it back.
+func (s *Server) appendSeq(ctx *fasthttp.RequestCtx, ev entity.Event, durable bool) {
+	var appendSeq func(context.Context, entity.Event) (uint64, error)
+	if durable {
+		ds, ok := s.sink.(DurableSink)
+		if !ok {
+			ctx.Error("durable acknowledgements not supported", fasthttp.StatusNotImplemented)
+			return
+		}
+		appendSeq = ds.AppendDurable
+	} else {
+		ss, ok := s.sink.(SeqSink)
+		if !ok {
+			ctx.Error("sequence numbers not supported", fasthttp.StatusNotImplemented)
+			return
+		}
+		appendSeq = ss.AppendSeq
+	}
+
+	wait, cancel := context.WithTimeout(context.Background(), appendSeqTimeout)
+	defer cancel()
+	seq, err := appendSeq(wait, ev)
+	switch {
+	case err == nil:
+	case errors.Is(err, sink.ErrNoIdempotencyID):
+		ctx.Error("seq needs an idempotency_id", fasthttp.StatusBadRequest)
+		return
+	case errors.Is(err, sink.ErrNoDurability):
+		ctx.Error("durable acknowledgements need sink.sync_interval and no journal routes", fasthttp.StatusNotImplemented)
+		return
+	case errors.Is(err, sink.ErrDropped):
+		ctx.SetStatusCode(fasthttp.StatusAccepted)
+		return
+	case errors.Is(err, context.DeadlineExceeded) && seq != 0:
+		ctx.Error("written as seq "+strconv.FormatUint(seq, 10)+", but not fsynced yet", fasthttp.StatusGatewayTimeout)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		ctx.Error("accepted, but not flushed yet", fasthttp.StatusGatewayTimeout)
+		return
+	default:
+		appendError(ctx, ev, err)
+		return
+	}
+
+	body, err := json.Marshal(AppendResult{Seq: seq})
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if _, ok := s.events.(EventLookup); ok {
+		ctx.Response.Header.Set(fasthttp.HeaderLocation, mountPath(ctx, eventLocation(seq)))
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// AppendResult is the body of a 200 from /ingest?seq=true or
+// ?durable=true.
+type AppendResult struct {
+	Seq uint64 `json:"seq"`
+}
+
+func appendError(ctx *fasthttp.RequestCtx, ev entity.Event, err error) {
+	switch {
+	case errors.Is(err, apperr.ErrRateLimited), errors.Is(err, apperr.ErrQuotaExceeded):
+		setLimitHeaders(ctx, err)
+		ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+	case errors.Is(err, apperr.ErrDuplicate):
+		writeDuplicate(ctx, err)
+	case errors.Is(err, apperr.ErrEventTooLarge):
+		ctx.Error(err.Error(), fasthttp.StatusRequestEntityTooLarge)
+	case errors.Is(err, apperr.ErrTooOld), errors.Is(err, apperr.ErrIdempotencyConflict),
+		errors.Is(err, apperr.ErrInvalidSensorName):
+		ctx.Error(err.Error(), fasthttp.StatusUnprocessableEntity)
+	case errors.Is(err, apperr.ErrBufferFull):
+		ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+		ctx.Response.Header.Set("Retry-After", "1")
+	case errors.Is(err, apperr.ErrOverloaded):
+		ctx.Error(err.Error(), fasthttp.StatusServiceUnavailable)
+		ctx.Response.Header.Set("Retry-After", overloadRetryAfter)
+	case errors.Is(err, apperr.ErrInsufficientStorage):
+		ctx.Error(err.Error(), fasthttp.StatusInsufficientStorage)
+		ctx.Response.Header.Set("Retry-After", storageRetryAfter)
+	default:
+		reqLog(ctx).Error("sink.Append failed", "error", err, "sensor", ev.Sensor)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// DuplicateResult is the body of a 409 from /ingest, telling the client
+// whether the first copy of the event is durably stored: "written" with
+// its sequence number, or "pending" while it's still buffered.
+type DuplicateResult struct {
+	Status string `json:"status"`
+	Seq    uint64 `json:"seq,omitempty"`
+}
+
+func writeDuplicate(ctx *fasthttp.RequestCtx, err error) {
+	ctx.SetStatusCode(fasthttp.StatusConflict)
+	var de *apperr.DuplicateError
+	if !errors.As(err, &de) {
+		return
+	}
+	res := DuplicateResult{Status: "pending", Seq: de.Seq}
+	if de.Seq > 0 {
+		res.Status = "written"
+	}
+	body, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
 func (s *Server) handleBatch(ctx *fasthttp.RequestCtx) {
-	if !ctx.IsPost() {
-		ctx.Error("method not allowed", fasthttp.StatusMethodNotAllowed)
+	s.batch(ctx, "", s.sink.Append)
+}
+
+// handleBackfill takes a batch like /ingest/batch, but for historical
+// events: they skip rate limiting and dedup and count against the backfill
+// quota instead.
+func (s *Server) handleBackfill(ctx *fasthttp.RequestCtx) {
+	if !s.backfill {
+		ctx.Error("backfill not enabled", fasthttp.StatusNotFound)
+		return
+	}
+	bs, ok := s.sink.(BackfillSink)
+	if !ok {
+		ctx.Error("backfill not supported", fasthttp.StatusNotImplemented)
 		return
 	}
+	s.batch(ctx, "backfill:", bs.AppendBackfill)
+}
 
+// batch appends a batch body with add. Replays are looked up under
+// keyPrefix, so the same body sent to two endpoints isn't taken for one.
+func (s *Server) batch(ctx *fasthttp.RequestCtx, keyPrefix string, add func(entity.Event) error) {
 	ct := string(ctx.Request.Header.ContentType())
-	if ct != "application/x-ndjson" && ct != "application/jsonl" {
-		ctx.Error("use application/x-ndjson or application/jsonl", fasthttp.StatusUnsupportedMediaType)
+	if ct != "application/x-ndjson" && ct != "application/jsonl" && ct != "application/msgpack" {
+		ctx.Error("use application/x-ndjson, application/jsonl or application/msgpack", fasthttp.StatusUnsupportedMediaType)
 		return
 	}
 
@@ -191,70 +666,554 @@ func (s *Server) handleBatch(ctx *fasthttp.RequestCtx) {
 
 	batchTotal.Inc()
 
-	var events []entity.Event
-	scanner := bufio.NewScanner(bytes.NewReader(body))
-	line := 0
-	for scanner.Scan() {
-		line++
-		data := scanner.Bytes()
-		if len(data) == 0 {
-			continue
+	var (
+		res BatchResult
+		ok  bool
+	)
+	if s.batches != nil {
+		sum := sha256.Sum256(body)
+		key := keyPrefix + batchKey(ctx.Request.Header.Peek("Idempotency-Key"), sum)
+		prev, replayed, err := s.batches.begin(key, sum)
+		if err != nil {
+			batchKeyReused.Inc()
+			ctx.Error(err.Error(), fasthttp.StatusUnprocessableEntity)
+			return
+		}
+		if replayed {
+			batchReplays.Inc()
+			reqLog(ctx).Debug("batch replay acknowledged", "bytes", len(body))
+			prev.Replayed = true
+			writeBatchResult(ctx, prev)
+			return
+		}
+		defer func() { s.batches.finish(key, res, ok) }()
+	}
+
+	if ct == "application/msgpack" {
+		res, ok = s.streamMsgpackBatch(ctx, body, add)
+	} else {
+		res, ok = s.ndjsonBatch(ctx, body, add)
+	}
+	if !ok {
+		if res.Total > 0 {
+			ctx.SetUserValue(partialBatchKey{}, res)
+		}
+		return
+	}
+
+	writeBatchResult(ctx, res)
+}
+
+// ndjsonBatch parses the whole batch before appending any of it, so a
+// malformed line drops the batch as a whole.
+func (s *Server) ndjsonBatch(ctx *fasthttp.RequestCtx, body []byte, add func(entity.Event) error) (BatchResult, bool) {
+	events, err := decodeNDJSON(body, s.batchWorkers, s.decodeJSON)
+	if err != nil {
+		batchParseErrors.Inc()
+		batchDropped.Inc()
+		var le *ndjsonError
+		if !errors.As(err, &le) || errors.Is(err, errLineTooLong) {
+			reqLog(ctx).Warn("batch scan error", "error", err)
+			ctx.Error("scan error", fasthttp.StatusBadRequest)
+			return BatchResult{}, false
+		}
+		reqLog(ctx).Warn("batch parse error, dropping batch",
+			"line", le.line,
+			"error", le.err,
+			"events_parsed", le.parsed,
+		)
+		ctx.Error("parse error at line "+strconv.Itoa(le.line), fasthttp.StatusBadRequest)
+		return BatchResult{}, false
+	}
+
+	batchEventsTotal.Add(len(events))
+	reqLog(ctx).Debug("processing batch", "events", len(events), "bytes", len(body))
+
+	res := BatchResult{Total: len(events)}
+	for i, ev := range events {
+		if !s.appendBatchEvent(ctx, &res, ev, i, add) {
+			return res, false
 		}
+	}
+	return res, true
+}
+
+// streamMsgpackBatch appends the events of a msgpack array as they are
+// decoded, without collecting them first. Events ahead of a malformed one
+// have been appended by the time it is found; the 400 says how many.
+func (s *Server) streamMsgpackBatch(ctx *fasthttp.RequestCtx, body []byte, add func(entity.Event) error) (BatchResult, bool) {
+	// No string or event can be longer than the body holding it; without
+	// the limits a short body claiming a huge one makes the decoder
+	// allocate all of it up front.
+	r := msgp.NewReader(bytes.NewReader(body))
+	r.SetMaxStringLength(uint64(len(body)))
+	n, err := r.ReadArrayHeader()
+	if err == nil && int(n) > len(body) {
+		err = msgp.ErrLimitExceeded
+	}
+	if err != nil {
+		batchParseErrors.Inc()
+		batchDropped.Inc()
+		reqLog(ctx).Warn("batch parse error, dropping batch", "error", err)
+		ctx.Error("body must be a msgpack array of events", fasthttp.StatusBadRequest)
+		return BatchResult{}, false
+	}
+
+	reqLog(ctx).Debug("processing batch", "events", n, "bytes", len(body))
 
+	res := BatchResult{Total: int(n)}
+	for i := range int(n) {
 		var ev entity.Event
-		if err := json.Unmarshal(data, &ev); err != nil {
+		if err := ev.DecodeMsg(r); err != nil {
 			batchParseErrors.Inc()
 			batchDropped.Inc()
-			slog.Warn("batch parse error, dropping batch",
-				"line", line,
+			reqLog(ctx).Warn("batch parse error, dropping remaining",
+				"event", i,
 				"error", err,
-				"events_parsed", len(events),
+				"accepted", res.Accepted,
 			)
-			ctx.Error("parse error at line "+strconv.Itoa(line), fasthttp.StatusBadRequest)
+			ctx.Error("parse error at event "+strconv.Itoa(i)+", "+strconv.Itoa(res.Accepted)+" events before it accepted", fasthttp.StatusBadRequest)
+			return res, false
+		}
+		batchEventsTotal.Inc()
+		if !s.appendBatchEvent(ctx, &res, ev, i, add) {
+			return res, false
+		}
+	}
+	return res, true
+}
+
+// appendBatchEvent appends the i-th event of a batch with add and counts it
+// in res.
+// It returns false once the rest of the batch is dropped, with the
+// response already written.
+func (s *Server) appendBatchEvent(ctx *fasthttp.RequestCtx, res *BatchResult, ev entity.Event, i int, add func(entity.Event) error) bool {
+	err := add(ev)
+	switch {
+	case err == nil:
+		res.Accepted++
+		return true
+	case errors.Is(err, apperr.ErrDuplicate):
+		res.Duplicates++
+		return true // skip duplicates in batch
+	case errors.Is(err, apperr.ErrTooOld):
+		res.Expired++
+		return true
+	case errors.Is(err, apperr.ErrIdempotencyConflict):
+		res.Conflicts++
+		return true
+	case errors.Is(err, apperr.ErrInvalidSensorName):
+		res.Invalid++
+		return true
+	case errors.Is(err, apperr.ErrEventTooLarge):
+		res.TooLarge++
+		return true
+	}
+
+	batchDropped.Inc()
+
+	if errors.Is(err, apperr.ErrRateLimited) || errors.Is(err, apperr.ErrQuotaExceeded) {
+		reqLog(ctx).Warn("batch rate limited, dropping remaining",
+			"processed", i,
+			"dropped", res.Total-i,
+		)
+		setLimitHeaders(ctx, err)
+		ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+		return false
+	}
+
+	if errors.Is(err, apperr.ErrBufferFull) {
+		reqLog(ctx).Warn("batch buffer full, dropping remaining",
+			"processed", i,
+			"dropped", res.Total-i,
+		)
+		ctx.Error("buffer full, "+strconv.Itoa(res.Accepted)+" events accepted", fasthttp.StatusServiceUnavailable)
+		ctx.Response.Header.Set("Retry-After", "1")
+		return false
+	}
+
+	if errors.Is(err, apperr.ErrOverloaded) {
+		reqLog(ctx).Warn("sink overloaded, dropping remaining",
+			"processed", i,
+			"dropped", res.Total-i,
+		)
+		ctx.Error("sink overloaded, "+strconv.Itoa(res.Accepted)+" events accepted", fasthttp.StatusServiceUnavailable)
+		ctx.Response.Header.Set("Retry-After", overloadRetryAfter)
+		return false
+	}
+
+	if errors.Is(err, apperr.ErrInsufficientStorage) {
+		reqLog(ctx).Warn("journal disk over limit, dropping remaining",
+			"processed", i,
+			"dropped", res.Total-i,
+		)
+		ctx.Error("insufficient storage, "+strconv.Itoa(res.Accepted)+" events accepted", fasthttp.StatusInsufficientStorage)
+		ctx.Response.Header.Set("Retry-After", storageRetryAfter)
+		return false
+	}
+
+	reqLog(ctx).Error("batch sink error, dropping remaining",
+		"processed", i,
+		"dropped", res.Total-i,
+		"error", err,
+	)
+	ctx.Error("sink error", fasthttp.StatusInternalServerError)
+	return false
+}
+
+// BatchResult is the body of a 202 from /ingest/batch, letting gateways
+// reconcile their spool with what was actually recorded.
+type BatchResult struct {
+	Accepted   int `json:"accepted"`
+	Duplicates int `json:"duplicates"`
+	Total      int `json:"total"`
+	// Expired counts events skipped for being older than the retention
+	// horizon.
+	Expired int `json:"expired,omitempty"`
+	// Conflicts counts events skipped for reusing the idempotency_id of
+	// an event with different content.
+	Conflicts int `json:"conflicts,omitempty"`
+	// Invalid counts events skipped for a sensor name the naming rules
+	// reject.
+	Invalid int `json:"invalid,omitempty"`
+	// TooLarge counts events skipped for being bigger than the rate
+	// limit's burst, so they'd never be let through.
+	TooLarge int `json:"too_large,omitempty"`
+	// Replayed is set when the batch was accepted earlier and the counts
+	// are from that first delivery.
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+func writeBatchResult(ctx *fasthttp.RequestCtx, res BatchResult) {
+	body, err := json.Marshal(res)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// setLimitHeaders tells well-behaved clients when to retry. Durations are
+// rounded up to whole seconds so a client never comes back too early.
+func setLimitHeaders(ctx *fasthttp.RequestCtx, err error) {
+	var le *apperr.LimitError
+	if !errors.As(err, &le) {
+		return
+	}
+	ctx.Response.Header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(le.RetryAfter), 1)))
+	ctx.Response.Header.Set("X-RateLimit-Remaining", strconv.FormatInt(le.Remaining, 10))
+	ctx.Response.Header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(le.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func (s *Server) handleOpenAPI(ctx *fasthttp.RequestCtx) {
+	doc, err := openAPI(s.basePath)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(doc)
+}
+
+func (s *Server) handleQuota(ctx *fasthttp.RequestCtx) {
+	if s.quota == nil {
+		ctx.Error("quota not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(s.quota.Report())
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// handleReplication writes a batch from a primary to the journal and
+// answers with the offset it has reached.
+func (s *Server) handleReplication(ctx *fasthttp.RequestCtx) {
+	if s.replica == nil {
+		ctx.Error("replication not enabled", fasthttp.StatusNotFound)
+		return
+	}
+	if !bearer(ctx, s.replicaToken) {
+		ctx.Error("unauthorized", fasthttp.StatusUnauthorized)
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer realm="replication"`)
+		return
+	}
+	if string(ctx.Request.Header.ContentType()) != replication.ContentType {
+		ctx.Error("use "+replication.ContentType, fasthttp.StatusUnsupportedMediaType)
+		return
+	}
+
+	var b replication.Batch
+	if _, err := b.UnmarshalMsg(ctx.PostBody()); err != nil {
+		ctx.Error("malformed batch: "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	acked, err := s.replica.Apply(&b)
+	switch {
+	case errors.Is(err, replication.ErrNoSource):
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	case err != nil:
+		reqLog(ctx).Error("replicated batch failed", "source", b.Source, "entries", len(b.Entries), "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(replication.Ack{Acked: acked})
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// handleRole says whether this sink takes writes: 200 on the leader and
+// 503 on a follower, for load balancer and VIP health checks.
+func (s *Server) handleRole(ctx *fasthttp.RequestCtx) {
+	role := struct {
+		Role   string `json:"role"`
+		Leader string `json:"leader,omitempty"`
+	}{Role: "leader"}
+	if s.follower != nil {
+		if leader, ok := s.follower.Leader(); ok {
+			role.Role, role.Leader = "follower", leader
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		}
+	}
+	body, err := json.Marshal(role)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// handleSampling returns the sampling rules, or on PUT replaces them with
+// the JSON array in the body.
+func (s *Server) handleSampling(ctx *fasthttp.RequestCtx) {
+	if s.sampler == nil {
+		ctx.Error("sampling not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	if ctx.IsPut() {
+		var rules []sink.SampleRule
+		if err := json.Unmarshal(ctx.PostBody(), &rules); err != nil {
+			ctx.Error("body must be a JSON array of sampling rules", fasthttp.StatusBadRequest)
+			return
+		}
+		if err := s.sampler.SetRules(rules); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
 			return
 		}
-		events = append(events, ev)
+		reqLog(ctx).Info("sampling rules replaced", "rules", len(rules))
 	}
 
-	if err := scanner.Err(); err != nil {
-		batchParseErrors.Inc()
-		batchDropped.Inc()
-		slog.Warn("batch scan error", "error", err)
-		ctx.Error("scan error", fasthttp.StatusBadRequest)
+	body, err := json.Marshal(s.sampler.Rules())
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
 
-	batchEventsTotal.Add(len(events))
-	slog.Debug("processing batch", "events", len(events), "bytes", len(body))
+// handleDedup reports on the deduplicator, or on DELETE forgets the
+// idempotency ID in ?id=, letting its next event through.
+func (s *Server) handleDedup(ctx *fasthttp.RequestCtx) {
+	if s.dedup == nil {
+		ctx.Error("dedup not enabled", fasthttp.StatusNotFound)
+		return
+	}
 
-	for i, ev := range events {
-		if err := s.sink.Append(ev); err != nil {
-			if errors.Is(err, apperr.ErrDuplicate) {
-				continue // skip duplicates in batch
-			}
+	if ctx.IsDelete() {
+		id := string(ctx.QueryArgs().Peek("id"))
+		if id == "" {
+			ctx.Error("id is required", fasthttp.StatusBadRequest)
+			return
+		}
+		if !s.dedup.Purge(id) {
+			ctx.Error("idempotency id not remembered", fasthttp.StatusNotFound)
+			return
+		}
+		reqLog(ctx).Info("dedup id purged", "idempotency_id", id)
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
 
-			batchDropped.Inc()
+	body, err := json.Marshal(s.dedup.Report())
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
 
-			if errors.Is(err, apperr.ErrRateLimited) {
-				slog.Warn("batch rate limited, dropping remaining",
-					"processed", i,
-					"dropped", len(events)-i,
-				)
-				ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
-				return
-			}
+// handleSensors reports per-sensor stats, or a single sensor's with
+// ?sensor=<name>.
+func (s *Server) handleSensors(ctx *fasthttp.RequestCtx) {
+	if s.stats == nil {
+		ctx.Error("sensor stats not enabled", fasthttp.StatusNotFound)
+		return
+	}
 
-			slog.Error("batch sink error, dropping remaining",
-				"processed", i,
-				"dropped", len(events)-i,
-				"error", err,
-			)
-			ctx.Error("sink error", fasthttp.StatusInternalServerError)
+	report := s.stats.Report()
+	var v any = report
+	if name := ctx.QueryArgs().Peek("sensor"); name != nil {
+		i := slices.IndexFunc(report.Sensors, func(st sink.SensorStats) bool { return st.Sensor == string(name) })
+		if i < 0 {
+			ctx.Error("unknown sensor", fasthttp.StatusNotFound)
 			return
 		}
+		v = report.Sensors[i]
 	}
 
-	ctx.SetStatusCode(fasthttp.StatusAccepted)
+	body, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+func (s *Server) handleGaps(ctx *fasthttp.RequestCtx) {
+	if s.journal == nil {
+		ctx.Error("journal not configured", fasthttp.StatusNotFound)
+		return
+	}
+
+	gaps := s.journal.Gaps()
+	if gaps == nil {
+		gaps = []journal.SeqGap{}
+	}
+	body, err := json.Marshal(gaps)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+func (s *Server) handleSegments(ctx *fasthttp.RequestCtx) {
+	if s.journal == nil {
+		ctx.Error("journal not configured", fasthttp.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(s.journal.Segments())
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+func (s *Server) handleTruncate(ctx *fasthttp.RequestCtx) {
+	if s.journal == nil {
+		ctx.Error("journal not configured", fasthttp.StatusNotFound)
+		return
+	}
+
+	before, err := strconv.ParseUint(string(ctx.QueryArgs().Peek("before")), 10, 64)
+	if err != nil {
+		ctx.Error("before must be a sequence number", fasthttp.StatusBadRequest)
+		return
+	}
+
+	reclaimed, err := s.journal.TruncateBefore(before)
+	if err != nil {
+		reqLog(ctx).Error("journal truncate failed", "before", before, "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	reqLog(ctx).Info("journal truncated", "before", before, "reclaimed_bytes", reclaimed)
+
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(`{"reclaimed_bytes":` + strconv.FormatInt(reclaimed, 10) + `}`)
+}
+
+func (s *Server) handleCompact(ctx *fasthttp.RequestCtx) {
+	if s.journal == nil {
+		ctx.Error("journal not configured", fasthttp.StatusNotFound)
+		return
+	}
+
+	reclaimed, err := s.journal.Compact()
+	if err != nil {
+		reqLog(ctx).Error("journal compaction failed", "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	reqLog(ctx).Info("journal compacted", "reclaimed_bytes", reclaimed)
+
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(`{"reclaimed_bytes":` + strconv.FormatInt(reclaimed, 10) + `}`)
+}
+
+// handleFlush writes out the buffered events and fsyncs the journal, e.g.
+// ahead of a planned power cut.
+func (s *Server) handleFlush(ctx *fasthttp.RequestCtx) {
+	sy, ok := s.sink.(Syncer)
+	if !ok {
+		ctx.Error("sink can't be flushed", fasthttp.StatusNotFound)
+		return
+	}
+	if err := sy.Sync(); err != nil {
+		reqLog(ctx).Error("manual flush failed", "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	reqLog(ctx).Info("sink flushed")
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// handleRotate flushes the sink, then seals the active segment, so the
+// sealed segments hold everything accepted so far and the directory can
+// be copied at that boundary.
+func (s *Server) handleRotate(ctx *fasthttp.RequestCtx) {
+	if s.journal == nil {
+		ctx.Error("journal not configured", fasthttp.StatusNotFound)
+		return
+	}
+	if sy, ok := s.sink.(Syncer); ok {
+		if err := sy.Sync(); err != nil {
+			reqLog(ctx).Error("flush before rotation failed", "error", err)
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+	}
+
+	info, err := s.journal.Rotate()
+	if err != nil {
+		reqLog(ctx).Error("journal rotation failed", "error", err)
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	reqLog(ctx).Info("journal rotated", "segment", info.Name, "last_seq", info.LastSeq)
+
+	body, err := json.Marshal(info)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
 }
 
 func (s *Server) Run(ctx context.Context) error {
@@ -265,13 +1224,7 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 
 	errc := make(chan error, 1)
-	go func() {
-		if s.tls != nil && s.tls.CertFile != "" {
-			errc <- s.serveTLS()
-		} else {
-			errc <- s.srv.ListenAndServe(s.addr)
-		}
-	}()
+	go func() { errc <- s.serve() }()
 
 	select {
 	case <-ctx.Done():
@@ -285,13 +1238,42 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 }
 
-func (s *Server) serveTLS() error {
+func (s *Server) serve() error {
+	useTLS := s.tls != nil && s.tls.CertFile != ""
+	if !useTLS && !s.proxyProtocol && s.ipFilter == nil {
+		return s.srv.ListenAndServe(s.addr)
+	}
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.ipFilter != nil {
+		// on the peer's address, before anything is read off the connection
+		ln = &filterListener{Listener: ln, filter: s.ipFilter, trusted: s.isTrusted}
+	}
+	if s.proxyProtocol {
+		// the PROXY header comes ahead of the TLS handshake
+		ln = &proxyListener{Listener: ln, trusted: s.isTrusted}
+	}
+	if useTLS {
+		cfg, err := s.tlsConfig()
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, cfg)
+	}
+	return s.srv.Serve(ln)
+}
+
+func (s *Server) tlsConfig() (*tls.Config, error) {
 	slog.Debug("loading tls cert", "cert", s.tls.CertFile, "key", s.tls.KeyFile)
 
 	cert, err := tls.LoadX509KeyPair(s.tls.CertFile, s.tls.KeyFile)
 	if err != nil {
 		slog.Error("failed to load tls keypair", "error", err)
-		return err
+		return nil, err
 	}
 
 	cfg := &tls.Config{
@@ -304,7 +1286,7 @@ func (s *Server) serveTLS() error {
 		pem, err := os.ReadFile(s.tls.ClientCA)
 		if err != nil {
 			slog.Error("failed to read client ca", "error", err)
-			return err
+			return nil, err
 		}
 		pool := x509.NewCertPool()
 		pool.AppendCertsFromPEM(pem)
@@ -312,12 +1294,7 @@ func (s *Server) serveTLS() error {
 		cfg.ClientAuth = tls.RequireAndVerifyClientCert
 		slog.Info("mtls enabled")
 	}
-
-	ln, err := net.Listen("tcp", s.addr)
-	if err != nil {
-		return err
-	}
-	return s.srv.Serve(tls.NewListener(ln, cfg))
+	return cfg, nil
 }
 
 func (s *Server) Addr() string { return s.addr }
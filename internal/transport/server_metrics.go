@@ -12,13 +12,69 @@ var (
 	requestSize     = metrics.NewSummary("http_request_size_bytes")
 	responseSize    = metrics.NewSummary("http_response_size_bytes")
 	activeRequests  = metrics.NewGauge("http_active_requests", nil)
+	bodyTooLarge    = metrics.NewCounter("http_body_too_large_total")
 
 	batchTotal       = metrics.NewCounter("http_batch_total")
 	batchEventsTotal = metrics.NewCounter("http_batch_events_total")
 	batchDropped     = metrics.NewCounter("http_batch_dropped_total")
 	batchParseErrors = metrics.NewCounter("http_batch_parse_errors_total")
+	batchReplays     = metrics.NewCounter("http_batch_replays_total")
+	batchKeyReused   = metrics.NewCounter("http_batch_key_reused_total")
+	batchInFlight    = metrics.NewGauge("http_batch_in_flight", nil)
+	batchQueueDepth  = metrics.NewGauge("http_batch_queue_depth", nil)
+	batchQueueWait   = metrics.NewSummary("http_batch_queue_wait_seconds")
+
+	remoteWriteSamples = metrics.NewCounter("http_remote_write_samples_total")
+	remoteWriteSkipped = metrics.NewCounter("http_remote_write_skipped_samples_total")
+
+	compressionErrors = metrics.NewCounter("http_compression_errors_total")
+
+	followerRejected = metrics.NewCounter("http_follower_rejected_total")
+
+	tailStreams = metrics.NewGauge("http_tail_streams", nil)
+	tailEvents  = metrics.NewCounter("http_tail_events_total")
+
+	debugRequests     = metrics.NewCounter("debug_requests_total")
+	debugUnauthorized = metrics.NewCounter("debug_unauthorized_total")
+
+	adminUnauthorized = metrics.NewCounter("http_admin_unauthorized_total")
 )
 
+// apiRequests counts requests by the API version they were served with,
+// to tell when no client speaks an old one anymore.
+func apiRequests(v APIVersion) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`http_api_requests_total{version="%d"}`, v))
+}
+
+// ipDenied counts clients the IP filter turned away, as connections or,
+// behind trusted proxies, as requests.
+func ipDenied(stage string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`http_ip_denied_total{stage=%q}`, stage))
+}
+
+// batchQueueRejected counts batches turned away by the batch limiter, for
+// a full queue or a timed out wait.
+func batchQueueRejected(reason string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`http_batch_queue_rejected_total{reason=%q}`, reason))
+}
+
+func compressedResponses(encoding string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`http_compressed_responses_total{encoding=%q}`, encoding))
+}
+
+func compressedBytes(encoding string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`http_compression_saved_bytes_total{encoding=%q}`, encoding))
+}
+
+// routeDuration times requests by route and, with WithTenantLabel, by
+// tenant, so slow batch uploads don't hide a slower /ingest.
+func routeDuration(path, tenant string) *metrics.Histogram {
+	if tenant == "" {
+		return metrics.GetOrCreateHistogram(fmt.Sprintf(`http_route_duration_seconds{path=%q}`, path))
+	}
+	return metrics.GetOrCreateHistogram(fmt.Sprintf(`http_route_duration_seconds{path=%q,tenant=%q}`, path, tenant))
+}
+
 func requestsByPathAndStatus(path string, status int) *metrics.Counter {
 	return metrics.GetOrCreateCounter(fmt.Sprintf(`http_requests_total{path=%q,status="%d"}`, path, status))
 }
@@ -1,21 +1,33 @@
 package transport
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttputil"
 
 	"github.com/andriibeee/iotdemo/internal/entity"
 	apperr "github.com/andriibeee/iotdemo/internal/errors"
+	"github.com/andriibeee/iotdemo/internal/replication"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
 )
 
 type mockSink struct {
+	mu     sync.Mutex
 	events []entity.Event
 	err    error
 }
@@ -24,7 +36,71 @@ func (m *mockSink) Append(ev entity.Event) error {
 	if m.err != nil {
 		return m.err
 	}
+	m.mu.Lock()
 	m.events = append(m.events, ev)
+	m.mu.Unlock()
+	return nil
+}
+
+// received is what was appended, for tests whose server runs in a
+// goroutine of its own.
+func (m *mockSink) received() []entity.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.events)
+}
+
+// seqSink answers AppendSeq with seq, or err.
+type seqSink struct {
+	mockSink
+	seq uint64
+}
+
+func (m *seqSink) AppendSeq(_ context.Context, ev entity.Event) (uint64, error) {
+	if err := m.Append(ev); err != nil {
+		return 0, err
+	}
+	return m.seq, nil
+}
+
+// durableSink answers AppendDurable with seq and synced, or err.
+type durableSink struct {
+	seqSink
+	synced error
+}
+
+func (m *durableSink) AppendDurable(ctx context.Context, ev entity.Event) (uint64, error) {
+	seq, err := m.AppendSeq(ctx, ev)
+	if err != nil {
+		return 0, err
+	}
+	return seq, m.synced
+}
+
+// backfillSink records backfilled events apart from live ones.
+type backfillSink struct {
+	mockSink
+	backfilled []entity.Event
+}
+
+func (m *backfillSink) AppendBackfill(ev entity.Event) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.backfilled = append(m.backfilled, ev)
+	return nil
+}
+
+// dedupSink rejects events whose idempotency id it has seen.
+type dedupSink struct {
+	seen map[string]bool
+}
+
+func (d *dedupSink) Append(ev entity.Event) error {
+	if d.seen[ev.IdempotencyID] {
+		return apperr.ErrDuplicate
+	}
+	d.seen[ev.IdempotencyID] = true
 	return nil
 }
 
@@ -76,6 +152,38 @@ func TestHandleEvent(t *testing.T) {
 		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
 	})
 
+	t.Run("multi-field event", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := New(sink)
+
+		ctx := newEventRequest([]byte(`{"sensor":"env-3","ts":1717243200000,"fields":{"temp":22.5,"hum":48}}`))
+		ctx.Request.Header.SetContentType("application/json")
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, map[string]float64{"temp": 22.5, "hum": 48}, sink.events[0].Fields)
+
+		fields := make(map[string]float64, entity.MaxFields+1)
+		for i := range entity.MaxFields + 1 {
+			fields["ch"+strconv.Itoa(i)] = float64(i)
+		}
+		body, err := json.Marshal(entity.Event{Sensor: "env-3", Fields: fields})
+		require.NoError(t, err)
+		ctx = newEventRequest(body)
+		ctx.Request.Header.SetContentType("application/json")
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+		wide := entity.Event{Sensor: "env-3", Fields: fields}
+		body, err = wide.MarshalMsg(nil)
+		require.NoError(t, err)
+		ctx = newEventRequest(body)
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+		assert.Len(t, sink.events, 1)
+	})
+
 	t.Run("sink failure returns 500", func(t *testing.T) {
 		srv := New(&mockSink{err: errors.New("db down")})
 		_, body := sampleEvent()
@@ -85,6 +193,155 @@ func TestHandleEvent(t *testing.T) {
 
 		assert.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
 	})
+
+	t.Run("duplicate reports the first copy", func(t *testing.T) {
+		srv := New(&mockSink{err: &apperr.DuplicateError{}})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusConflict, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"status": "pending"}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("event past the retention horizon returns 422", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrTooOld})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusUnprocessableEntity, ctx.Response.StatusCode())
+	})
+
+	t.Run("invalid sensor name returns 422", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrInvalidSensorName})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusUnprocessableEntity, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), "invalid sensor name")
+	})
+
+	t.Run("event larger than the rate limit burst returns 413", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrEventTooLarge})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusRequestEntityTooLarge, ctx.Response.StatusCode())
+		assert.Empty(t, ctx.Response.Header.Peek("Retry-After"))
+	})
+
+	t.Run("idempotency id reused for different content returns 422", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrIdempotencyConflict})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusUnprocessableEntity, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), "idempotency id reused")
+	})
+
+	t.Run("full buffer returns 503", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrBufferFull})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, "1", string(ctx.Response.Header.Peek("Retry-After")))
+	})
+
+	t.Run("memory pressure returns 503", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrOverloaded})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, overloadRetryAfter, string(ctx.Response.Header.Peek("Retry-After")))
+	})
+
+	t.Run("journal disk over limit returns 507", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrInsufficientStorage})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusInsufficientStorage, ctx.Response.StatusCode())
+		assert.Equal(t, storageRetryAfter, string(ctx.Response.Header.Peek("Retry-After")))
+	})
+
+	t.Run("seq=true returns the sequence number", func(t *testing.T) {
+		f := func(sink Sink, status int, body string) {
+			t.Helper()
+			_, ev := sampleEvent()
+			ctx := newEventRequest(ev)
+			ctx.Request.SetRequestURI("/ingest?seq=true")
+			New(sink).handle(ctx)
+
+			assert.Equal(t, status, ctx.Response.StatusCode())
+			if body != "" {
+				assert.JSONEq(t, body, string(ctx.Response.Body()))
+			}
+		}
+
+		f(&seqSink{seq: 7}, fasthttp.StatusOK, `{"seq": 7}`)
+		f(&seqSink{mockSink: mockSink{err: sink.ErrNoIdempotencyID}}, fasthttp.StatusBadRequest, "")
+		f(&seqSink{mockSink: mockSink{err: sink.ErrDropped}}, fasthttp.StatusAccepted, "")
+		f(&seqSink{mockSink: mockSink{err: context.DeadlineExceeded}}, fasthttp.StatusGatewayTimeout, "")
+		f(&seqSink{mockSink: mockSink{err: apperr.ErrDuplicate}}, fasthttp.StatusConflict, "")
+		f(&seqSink{mockSink: mockSink{err: &apperr.DuplicateError{Seq: 12}}}, fasthttp.StatusConflict, `{"status": "written", "seq": 12}`)
+		f(&mockSink{}, fasthttp.StatusNotImplemented, "")
+	})
+
+	t.Run("durable=true waits for the fsync", func(t *testing.T) {
+		f := func(sink Sink, status int, body string) {
+			t.Helper()
+			_, ev := sampleEvent()
+			ctx := newEventRequest(ev)
+			ctx.Request.SetRequestURI("/ingest?durable=true")
+			New(sink).handle(ctx)
+
+			assert.Equal(t, status, ctx.Response.StatusCode())
+			if body != "" {
+				assert.Contains(t, string(ctx.Response.Body()), body)
+			}
+		}
+
+		f(&durableSink{seqSink: seqSink{seq: 7}}, fasthttp.StatusOK, `{"seq":7}`)
+		f(&durableSink{seqSink: seqSink{seq: 7}, synced: context.DeadlineExceeded}, fasthttp.StatusGatewayTimeout, "written as seq 7, but not fsynced yet")
+		f(&durableSink{seqSink: seqSink{mockSink: mockSink{err: context.DeadlineExceeded}}}, fasthttp.StatusGatewayTimeout, "not flushed yet")
+		f(&durableSink{seqSink: seqSink{mockSink: mockSink{err: sink.ErrNoDurability}}}, fasthttp.StatusNotImplemented, "sink.sync_interval")
+		f(&seqSink{seq: 7}, fasthttp.StatusNotImplemented, "")
+	})
+
+	t.Run("seq=true points to the stored event", func(t *testing.T) {
+		j, err := journal.New(journal.NewMemStorage(), 0)
+		require.NoError(t, err)
+		defer j.Close()
+		_, ev := sampleEvent()
+		ctx := newEventRequest(ev)
+		ctx.Request.SetRequestURI("/ingest?seq=true")
+		New(&seqSink{seq: 7}, WithEvents(j, sink.TextKeys)).handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, "/events/7", string(ctx.Response.Header.Peek(fasthttp.HeaderLocation)))
+
+		ctx = newEventRequest(ev)
+		ctx.Request.SetRequestURI("/iot/v1/ingest?seq=true")
+		New(&seqSink{seq: 7}, WithEvents(j, sink.TextKeys), WithBasePath("/iot/v1")).handle(ctx)
+		assert.Equal(t, "/iot/v1/events/7", string(ctx.Response.Header.Peek(fasthttp.HeaderLocation)))
+	})
 }
 
 func TestServerIntegration(t *testing.T) {
@@ -115,6 +372,14 @@ func TestServerIntegration(t *testing.T) {
 
 	require.NoError(t, client.Do(req, resp))
 	assert.Equal(t, fasthttp.StatusAccepted, resp.StatusCode())
+
+	// load balancer health checks
+	req.Reset()
+	req.SetRequestURI("http://test/healthz")
+	req.Header.SetMethod("HEAD")
+	require.NoError(t, client.Do(req, resp))
+	assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	assert.Empty(t, resp.Body())
 }
 
 func postEvent(t *testing.T, client *fasthttp.Client, addr string) *fasthttp.Response {
@@ -161,6 +426,66 @@ func TestHandleBatch(t *testing.T) {
 
 		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
 		assert.Len(t, sink.events, 3)
+		assert.JSONEq(t, `{"accepted":3,"duplicates":0,"total":3}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("counts duplicates", func(t *testing.T) {
+		sink := &dedupSink{seen: map[string]bool{"a": true}}
+		srv := New(sink)
+
+		body := `{"idempotency_id":"a","sensor":"temp","val":10,"ts":1000}
+{"idempotency_id":"b","sensor":"temp","val":20,"ts":2000}
+{"idempotency_id":"b","sensor":"temp","val":20,"ts":2000}
+{"idempotency_id":"c","sensor":"temp","val":30,"ts":3000}`
+
+		ctx := newBatchRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+		assert.JSONEq(t, `{"accepted":2,"duplicates":2,"total":4}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("counts expired", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrTooOld})
+
+		ctx := newBatchRequest(`{"sensor":"temp","val":10,"ts":1000}
+{"sensor":"temp","val":20,"ts":2000}`)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"accepted":0,"duplicates":0,"total":2,"expired":2}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("counts invalid sensor names", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrInvalidSensorName})
+
+		ctx := newBatchRequest(`{"sensor":"temp{1}","val":10,"ts":1000}
+{"sensor":"temp{2}","val":20,"ts":2000}`)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"accepted":0,"duplicates":0,"total":2,"invalid":2}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("counts events too large for the rate limit", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrEventTooLarge})
+
+		ctx := newBatchRequest(`{"sensor":"temp","val":10,"ts":1000}`)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"accepted":0,"duplicates":0,"total":1,"too_large":1}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("counts conflicts", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrIdempotencyConflict})
+
+		ctx := newBatchRequest(`{"idempotency_id":"a","sensor":"temp","val":10,"ts":1000}`)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"accepted":0,"duplicates":0,"total":1,"conflicts":1}`, string(ctx.Response.Body()))
 	})
 
 	t.Run("skips empty lines", func(t *testing.T) {
@@ -333,3 +658,941 @@ func TestBatchIntegration(t *testing.T) {
 	assert.Equal(t, fasthttp.StatusAccepted, resp.StatusCode())
 	assert.Len(t, sink.events, 2)
 }
+
+type staticQuota struct{ report sink.QuotaReport }
+
+func (q staticQuota) Report() sink.QuotaReport { return q.report }
+
+const testAdminToken = "admin-secret"
+
+func TestAdminToken(t *testing.T) {
+	req := func(srv *Server, auth string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI("/admin/journal/truncate?before=42")
+		if auth != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderAuthorization, auth)
+		}
+		srv.handle(ctx)
+		return ctx
+	}
+
+	t.Run("not served without a token", func(t *testing.T) {
+		j := &truncateRecorder{}
+		ctx := req(New(&mockSink{}, WithJournal(j)), "Bearer ")
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+		assert.Zero(t, j.before)
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		j := &truncateRecorder{}
+		srv := New(&mockSink{}, WithJournal(j), WithAdminToken(testAdminToken))
+		for _, auth := range []string{"", "Bearer nope", testAdminToken} {
+			ctx := req(srv, auth)
+			assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode(), auth)
+			assert.Equal(t, `Bearer realm="admin"`, string(ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate)))
+		}
+		assert.Zero(t, j.before)
+	})
+
+	t.Run("right token", func(t *testing.T) {
+		j := &truncateRecorder{}
+		ctx := req(New(&mockSink{}, WithJournal(j), WithAdminToken(testAdminToken)), "Bearer "+testAdminToken)
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, uint64(42), j.before)
+	})
+}
+
+func TestHandleQuota(t *testing.T) {
+	t.Run("reports usage", func(t *testing.T) {
+		q := staticQuota{report: sink.QuotaReport{
+			Day:          "2025-01-01",
+			EventsPerDay: 100,
+			Sensors:      []sink.QuotaUsage{{Sensor: "temp", Events: 3, Bytes: 120}},
+		}}
+		srv := New(&mockSink{}, WithQuota(q), WithAdminToken(testAdminToken))
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/admin/quota")
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"day":"2025-01-01","events_per_day":100,"bytes_per_day":0,"sensors":[{"sensor":"temp","events":3,"bytes":120}]}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		srv := New(&mockSink{}, WithAdminToken(testAdminToken))
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/admin/quota")
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+
+	t.Run("quota exceeded maps to 429", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrQuotaExceeded}, WithAdminToken(testAdminToken))
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	})
+}
+
+func TestHandleSampling(t *testing.T) {
+	sampler, err := sink.NewSampler([]sink.SampleRule{{Patterns: []string{"vib-*"}, Every: 10}})
+	require.NoError(t, err)
+	srv := New(&mockSink{}, WithSampling(sampler), WithAdminToken(testAdminToken))
+
+	request := func(method, body string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/admin/sampling")
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetBodyString(body)
+		srv.handle(ctx)
+		return ctx
+	}
+
+	ctx := request(fasthttp.MethodGet, "")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `[{"name":"vib-*","patterns":["vib-*"],"every":10}]`, string(ctx.Response.Body()))
+
+	ctx = request(fasthttp.MethodPut, `[{"name":"audio","patterns":["mic-*"],"probability":0.25}]`)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `[{"name":"audio","patterns":["mic-*"],"probability":0.25}]`, string(ctx.Response.Body()))
+	assert.Equal(t, "audio", sampler.Rules()[0].Name)
+
+	ctx = request(fasthttp.MethodPut, `[{"patterns":["mic-*"],"every":2,"probability":0.5}]`)
+	assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	ctx = request(fasthttp.MethodPut, `{`)
+	assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	assert.Equal(t, "audio", sampler.Rules()[0].Name, "a rejected update keeps the rules")
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/admin/sampling")
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+	New(&mockSink{}, WithAdminToken(testAdminToken)).handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func TestHandleDedup(t *testing.T) {
+	dedup := sink.NewDeduplicator(time.Hour, sink.WithDedupRecent(10))
+	mw := dedup.Middleware()(func(entity.Event) error { return nil })
+	require.NoError(t, mw(entity.Event{IdempotencyID: "a", Sensor: "temp"}))
+	require.Error(t, mw(entity.Event{IdempotencyID: "a", Sensor: "temp"}))
+	srv := New(&mockSink{}, WithDedup(dedup), WithAdminToken(testAdminToken))
+
+	request := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		ctx.Request.Header.SetMethod(method)
+		srv.handle(ctx)
+		return ctx
+	}
+
+	ctx := request(fasthttp.MethodGet, "/admin/dedup")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	var report sink.DedupReport
+	require.NoError(t, json.Unmarshal(ctx.Response.Body(), &report))
+	assert.EqualValues(t, 1, report.IDs)
+	assert.InDelta(t, 0.5, report.HitRatio, 1e-9)
+	require.Len(t, report.Recent, 1)
+	assert.Equal(t, "a", report.Recent[0].ID)
+
+	ctx = request(fasthttp.MethodDelete, "/admin/dedup?id=a")
+	assert.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+	assert.NoError(t, mw(entity.Event{IdempotencyID: "a", Sensor: "temp"}), "a purged id is let through")
+
+	ctx = request(fasthttp.MethodDelete, "/admin/dedup?id=unknown")
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	ctx = request(fasthttp.MethodDelete, "/admin/dedup")
+	assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/admin/dedup")
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+	New(&mockSink{}, WithAdminToken(testAdminToken)).handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+type stubReceiver struct{ batches []*replication.Batch }
+
+func (r *stubReceiver) Apply(b *replication.Batch) (uint64, error) {
+	if b.Source == "" {
+		return 0, replication.ErrNoSource
+	}
+	r.batches = append(r.batches, b)
+	return b.Entries[len(b.Entries)-1].Seq, nil
+}
+
+func TestHandleReplication(t *testing.T) {
+	recv := &stubReceiver{}
+	srv := New(&mockSink{}, WithReplication(recv, "secret"))
+	batch := &replication.Batch{Source: "gw-01", Entries: []replication.Entry{{Seq: 7, Key: []byte("k"), Value: []byte("v")}}}
+	body, err := batch.MarshalMsg(nil)
+	require.NoError(t, err)
+
+	request := func(srv *Server, token, contentType string, body []byte) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/replication/entries")
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(contentType)
+		if token != "" {
+			ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+		}
+		ctx.Request.SetBody(body)
+		srv.handle(ctx)
+		return ctx
+	}
+
+	ctx := request(srv, "secret", replication.ContentType, body)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"acked":7}`, string(ctx.Response.Body()))
+	require.Len(t, recv.batches, 1)
+	assert.Equal(t, "gw-01", recv.batches[0].Source)
+
+	ctx = request(srv, "", replication.ContentType, body)
+	assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+	assert.NotEmpty(t, ctx.Response.Header.Peek(fasthttp.HeaderWWWAuthenticate))
+	ctx = request(srv, "wrong", replication.ContentType, body)
+	assert.Equal(t, fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+
+	ctx = request(srv, "secret", "application/json", body)
+	assert.Equal(t, fasthttp.StatusUnsupportedMediaType, ctx.Response.StatusCode())
+	ctx = request(srv, "secret", replication.ContentType, []byte{0xc1})
+	assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	anonymous, err := (&replication.Batch{}).MarshalMsg(nil)
+	require.NoError(t, err)
+	ctx = request(srv, "secret", replication.ContentType, anonymous)
+	assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	assert.Len(t, recv.batches, 1)
+
+	ctx = request(New(&mockSink{}), "secret", replication.ContentType, body)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func TestFollowerRefusesIngest(t *testing.T) {
+	sink := &mockSink{}
+	var role replication.Role
+	srv := New(sink, WithFollower(&role), WithStats(staticStats{}))
+	_, body := sampleEvent()
+
+	request := func(path, contentType string, body []byte) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType(contentType)
+		ctx.Request.SetBody(body)
+		srv.handle(ctx)
+		return ctx
+	}
+
+	ctx := request("/ingest", "application/msgpack", body)
+	assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode(), "a leader takes ingest")
+
+	role.Follow("http://gw-01:8080")
+	for _, path := range []string{"/ingest", "/ingest/batch", "/ingest/backfill", "/api/v1/write"} {
+		ctx = request(path, "application/msgpack", body)
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode(), path)
+		assert.Equal(t, "http://gw-01:8080", string(ctx.Response.Header.Peek(LeaderHeader)), path)
+	}
+	assert.Len(t, sink.events, 1)
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/sensors")
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), "reads are still served")
+
+	role.Follow("")
+	ctx = request("/ingest", "application/msgpack", body)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.Empty(t, ctx.Response.Header.Peek(LeaderHeader))
+
+	role.Lead()
+	ctx = request("/ingest", "application/msgpack", body)
+	assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+}
+
+func TestHandleRole(t *testing.T) {
+	var role replication.Role
+	srv := New(&mockSink{}, WithFollower(&role))
+	get := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/role")
+		srv.handle(ctx)
+		return ctx
+	}
+
+	ctx := get()
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"role":"leader"}`, string(ctx.Response.Body()))
+
+	role.Follow("http://gw-01:8080")
+	ctx = get()
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"role":"follower","leader":"http://gw-01:8080"}`, string(ctx.Response.Body()))
+}
+
+type degradedSink struct {
+	mockSink
+	degraded bool
+}
+
+func (s *degradedSink) Degraded() bool { return s.degraded }
+
+type staticCanary struct{ status sink.CanaryStatus }
+
+func (c *staticCanary) Status() sink.CanaryStatus { return c.status }
+
+func TestHandleReady(t *testing.T) {
+	get := func(srv *Server) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/readyz")
+		srv.handle(ctx)
+		return ctx
+	}
+
+	snk := &degradedSink{}
+	ctx := get(New(snk))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"ready":true,"degraded":false}`, string(ctx.Response.Body()))
+
+	snk.degraded = true
+	ctx = get(New(snk))
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"ready":false,"degraded":true}`, string(ctx.Response.Body()))
+
+	snk.degraded = false
+	canary := &staticCanary{}
+	srv := New(snk, WithCanary(canary))
+	ctx = get(srv)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode(), "no round yet")
+
+	canary.status = sink.CanaryStatus{
+		LastSuccess: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Latency:     1500 * time.Microsecond,
+		Seq:         42,
+	}
+	ctx = get(srv)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"ready":true,"degraded":false,"canary":{"last_success":"2024-03-01T12:00:00Z","latency_ms":1.5,"seq":42,"failures":0}}`, string(ctx.Response.Body()))
+
+	canary.status.Failures, canary.status.Error = 2, "not written within 5s"
+	ctx = get(srv)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"ready":false,"degraded":false,"canary":{"last_success":"2024-03-01T12:00:00Z","latency_ms":1.5,"seq":42,"failures":2,"error":"not written within 5s"}}`, string(ctx.Response.Body()))
+
+	canary.status.Paused = true
+	ctx = get(srv)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode(), "paused on a follower")
+}
+
+type staticStats struct{ report sink.StatsReport }
+
+func (st staticStats) Report() sink.StatsReport { return st.report }
+
+func TestHandleSensors(t *testing.T) {
+	st := staticStats{report: sink.StatsReport{
+		Window: "1h0m0s",
+		Sensors: []sink.SensorStats{
+			{Sensor: "door", Count: 1, LastSeen: 1000, Window: sink.WindowStats{Count: 1, Min: 1, Max: 1, Mean: 1}},
+			{Sensor: "temp", Count: 5, LastSeen: 2000, Window: sink.WindowStats{Count: 2, Min: 10, Max: 30, Mean: 20}},
+		},
+	}}
+
+	get := func(srv *Server, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(uri)
+		srv.handle(ctx)
+		return ctx
+	}
+
+	t.Run("all sensors", func(t *testing.T) {
+		ctx := get(New(&mockSink{}, WithStats(st)), "/sensors")
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"window":"1h0m0s","sensors":[
+			{"sensor":"door","count":1,"last_seen":1000,"window":{"count":1,"min":1,"max":1,"mean":1}},
+			{"sensor":"temp","count":5,"last_seen":2000,"window":{"count":2,"min":10,"max":30,"mean":20}}
+		]}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("one sensor", func(t *testing.T) {
+		ctx := get(New(&mockSink{}, WithStats(st)), "/sensors?sensor=temp")
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"sensor":"temp","count":5,"last_seen":2000,"window":{"count":2,"min":10,"max":30,"mean":20}}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("unknown sensor", func(t *testing.T) {
+		ctx := get(New(&mockSink{}, WithStats(st)), "/sensors?sensor=gone")
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		ctx := get(New(&mockSink{}), "/sensors")
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+}
+
+type truncateRecorder struct {
+	before    uint64
+	compacted bool
+	gaps      []journal.SeqGap
+	err       error
+}
+
+func (r *truncateRecorder) TruncateBefore(seq uint64) (int64, error) {
+	r.before = seq
+	return 4096, r.err
+}
+
+func (r *truncateRecorder) Compact() (int64, error) {
+	r.compacted = true
+	return 512, r.err
+}
+
+func (r *truncateRecorder) Gaps() []journal.SeqGap {
+	return r.gaps
+}
+
+func (r *truncateRecorder) Segments() []journal.SegmentStats {
+	entries := uint32(10)
+	return []journal.SegmentStats{
+		{Name: "0000000000000003.wal", Bytes: 2048, FirstSeq: 11, LastSeq: 20, Entries: &entries},
+		{Name: "0000000000000004.wal", Bytes: 16, Active: true},
+	}
+}
+
+func (r *truncateRecorder) Rotate() (journal.SegmentInfo, error) {
+	return journal.SegmentInfo{Name: "0000000000000003.wal", Size: 2048, FirstSeq: 11, LastSeq: 20}, r.err
+}
+
+// syncSink records Sync calls, answering them with err.
+type syncSink struct {
+	mockSink
+	calls *[]string
+	err   error
+}
+
+func (s *syncSink) Sync() error {
+	*s.calls = append(*s.calls, "sync")
+	return s.err
+}
+
+// orderRecorder is a truncateRecorder that notes rotations in calls.
+type orderRecorder struct {
+	truncateRecorder
+	calls *[]string
+}
+
+func (r *orderRecorder) Rotate() (journal.SegmentInfo, error) {
+	*r.calls = append(*r.calls, "rotate")
+	return r.truncateRecorder.Rotate()
+}
+
+func TestHandleFlush(t *testing.T) {
+	req := func(method string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("/admin/flush")
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		return ctx
+	}
+
+	var calls []string
+	srv := New(&syncSink{calls: &calls}, WithAdminToken(testAdminToken))
+	ctx := req("POST")
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusNoContent, ctx.Response.StatusCode())
+	assert.Equal(t, []string{"sync"}, calls)
+
+	ctx = req("GET")
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+
+	ctx = req("POST")
+	New(&syncSink{calls: &calls, err: errors.New("disk full")}, WithAdminToken(testAdminToken)).handle(ctx)
+	assert.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+
+	ctx = req("POST")
+	New(&mockSink{}, WithAdminToken(testAdminToken)).handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func TestHandleRotate(t *testing.T) {
+	req := func() *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetRequestURI("/admin/journal/rotate")
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		return ctx
+	}
+
+	t.Run("flushes then rotates", func(t *testing.T) {
+		var calls []string
+		srv := New(&syncSink{calls: &calls}, WithJournal(&orderRecorder{calls: &calls}), WithAdminToken(testAdminToken))
+		ctx := req()
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, []string{"sync", "rotate"}, calls)
+		assert.JSONEq(t, `{"name":"0000000000000003.wal","size":2048,"first_seq":11,"last_seq":20,"crc32":0}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("failed flush", func(t *testing.T) {
+		var calls []string
+		srv := New(&syncSink{calls: &calls, err: errors.New("disk full")}, WithJournal(&orderRecorder{calls: &calls}), WithAdminToken(testAdminToken))
+		ctx := req()
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+		assert.Equal(t, []string{"sync"}, calls)
+	})
+
+	t.Run("journal error", func(t *testing.T) {
+		srv := New(&mockSink{}, WithJournal(&truncateRecorder{err: errors.New("disk gone")}), WithAdminToken(testAdminToken))
+		ctx := req()
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	})
+
+	t.Run("no journal", func(t *testing.T) {
+		ctx := req()
+		New(&mockSink{}, WithAdminToken(testAdminToken)).handle(ctx)
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+}
+
+func TestHandleTruncate(t *testing.T) {
+	req := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		return ctx
+	}
+
+	t.Run("truncates", func(t *testing.T) {
+		j := &truncateRecorder{}
+		srv := New(&mockSink{}, WithJournal(j), WithAdminToken(testAdminToken))
+
+		ctx := req("POST", "/admin/journal/truncate?before=42")
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, uint64(42), j.before)
+		assert.JSONEq(t, `{"reclaimed_bytes":4096}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("bad seq", func(t *testing.T) {
+		srv := New(&mockSink{}, WithJournal(&truncateRecorder{}), WithAdminToken(testAdminToken))
+
+		ctx := req("POST", "/admin/journal/truncate?before=nope")
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+	})
+
+	t.Run("get not allowed", func(t *testing.T) {
+		srv := New(&mockSink{}, WithJournal(&truncateRecorder{}), WithAdminToken(testAdminToken))
+
+		ctx := req("GET", "/admin/journal/truncate?before=1")
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+	})
+
+	t.Run("journal error", func(t *testing.T) {
+		srv := New(&mockSink{}, WithJournal(&truncateRecorder{err: errors.New("disk gone")}), WithAdminToken(testAdminToken))
+
+		ctx := req("POST", "/admin/journal/truncate?before=1")
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	})
+}
+
+func TestHandleGaps(t *testing.T) {
+	req := func(method string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("/admin/journal/gaps")
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		return ctx
+	}
+
+	j := &truncateRecorder{}
+	srv := New(&mockSink{}, WithJournal(j), WithAdminToken(testAdminToken))
+
+	ctx := req("GET")
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `[]`, string(ctx.Response.Body()))
+
+	j.gaps = []journal.SeqGap{{Segment: "000003.wal", After: 10, Next: 15}}
+	ctx = req("GET")
+	srv.handle(ctx)
+	assert.JSONEq(t, `[{"segment":"000003.wal","after":10,"next":15}]`, string(ctx.Response.Body()))
+
+	ctx = req("POST")
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+}
+
+func TestHandleSegments(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/admin/journal/segments")
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+	New(&mockSink{}, WithJournal(&truncateRecorder{}), WithAdminToken(testAdminToken)).handle(ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `[
+		{"name":"0000000000000003.wal","bytes":2048,"first_seq":11,"last_seq":20,"entries":10},
+		{"name":"0000000000000004.wal","bytes":16,"first_seq":0,"last_seq":0,"active":true}
+	]`, string(ctx.Response.Body()))
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/admin/journal/segments")
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+	New(&mockSink{}, WithAdminToken(testAdminToken)).handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func TestHandleCompact(t *testing.T) {
+	req := func(method string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("/admin/journal/compact")
+		ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+testAdminToken)
+		return ctx
+	}
+
+	j := &truncateRecorder{}
+	srv := New(&mockSink{}, WithJournal(j), WithAdminToken(testAdminToken))
+
+	ctx := req("POST")
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.True(t, j.compacted)
+	assert.JSONEq(t, `{"reclaimed_bytes":512}`, string(ctx.Response.Body()))
+
+	ctx = req("GET")
+	srv.handle(ctx)
+	assert.Equal(t, fasthttp.StatusMethodNotAllowed, ctx.Response.StatusCode())
+
+	ctx = req("POST")
+	New(&mockSink{}, WithAdminToken(testAdminToken)).handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+}
+
+func TestLimitHeaders(t *testing.T) {
+	limited := &apperr.LimitError{
+		Err:        apperr.ErrRateLimited,
+		RetryAfter: 1500 * time.Millisecond,
+		Remaining:  12,
+		Reset:      3 * time.Second,
+	}
+
+	t.Run("single event", func(t *testing.T) {
+		srv := New(&mockSink{err: limited})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+		assert.Equal(t, "2", string(ctx.Response.Header.Peek("Retry-After")))
+		assert.Equal(t, "12", string(ctx.Response.Header.Peek("X-RateLimit-Remaining")))
+		assert.Equal(t, "3", string(ctx.Response.Header.Peek("X-RateLimit-Reset")))
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		srv := New(&mockSink{err: limited})
+
+		ctx := newBatchRequest(`{"sensor":"temp","val":1,"ts":1000}`)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+		assert.Equal(t, "2", string(ctx.Response.Header.Peek("Retry-After")))
+	})
+
+	t.Run("retry after is at least a second", func(t *testing.T) {
+		srv := New(&mockSink{err: &apperr.LimitError{Err: apperr.ErrRateLimited}})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, "1", string(ctx.Response.Header.Peek("Retry-After")))
+	})
+
+	t.Run("bare sentinel has no headers", func(t *testing.T) {
+		srv := New(&mockSink{err: apperr.ErrRateLimited})
+		_, body := sampleEvent()
+
+		ctx := newEventRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+		assert.Empty(t, ctx.Response.Header.Peek("Retry-After"))
+	})
+}
+
+// gatedSink holds every Append until gate is closed.
+type gatedSink struct {
+	mu      sync.Mutex
+	events  []entity.Event
+	gate    chan struct{}
+	waiting atomic.Int32
+}
+
+func (g *gatedSink) Append(ev entity.Event) error {
+	g.waiting.Add(1)
+	<-g.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.events = append(g.events, ev)
+	return nil
+}
+
+func TestBatchReplay(t *testing.T) {
+	body := `{"sensor":"temp","val":10,"ts":1000}
+{"sensor":"temp","val":20,"ts":2000}`
+
+	t.Run("identical body is acknowledged once", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := New(sink, WithBatchDedup(time.Minute))
+
+		for i := range 3 {
+			ctx := newBatchRequest(body)
+			srv.handle(ctx)
+			assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+			if i > 0 {
+				assert.JSONEq(t, `{"accepted":2,"duplicates":0,"total":2,"replayed":true}`, string(ctx.Response.Body()))
+			}
+		}
+		assert.Len(t, sink.events, 2)
+	})
+
+	t.Run("idempotency key wins over body", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := New(sink, WithBatchDedup(time.Minute))
+
+		first := newBatchRequest(body)
+		first.Request.Header.Set("Idempotency-Key", "upload-1")
+		srv.handle(first)
+
+		// same key and body: a replay
+		second := newBatchRequest(body)
+		second.Request.Header.Set("Idempotency-Key", "upload-1")
+		srv.handle(second)
+
+		// same body, new key: a new batch
+		third := newBatchRequest(body)
+		third.Request.Header.Set("Idempotency-Key", "upload-2")
+		srv.handle(third)
+
+		assert.Equal(t, fasthttp.StatusAccepted, second.Response.StatusCode())
+		assert.JSONEq(t, `{"accepted":2,"duplicates":0,"total":2,"replayed":true}`, string(second.Response.Body()))
+		assert.Len(t, sink.events, 4)
+	})
+
+	t.Run("key reused for a different body", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := New(sink, WithBatchDedup(time.Minute))
+
+		first := newBatchRequest(body)
+		first.Request.Header.Set("Idempotency-Key", "upload-1")
+		srv.handle(first)
+
+		second := newBatchRequest(`{"sensor":"temp","val":30,"ts":3000}`)
+		second.Request.Header.Set("Idempotency-Key", "upload-1")
+		srv.handle(second)
+
+		assert.Equal(t, fasthttp.StatusUnprocessableEntity, second.Response.StatusCode())
+		assert.Len(t, sink.events, 2)
+	})
+
+	t.Run("concurrent copies are processed once", func(t *testing.T) {
+		release := make(chan struct{})
+		sink := &gatedSink{gate: release}
+		srv := New(sink, WithBatchDedup(time.Minute))
+
+		ctxs := make([]*fasthttp.RequestCtx, 3)
+		var wg sync.WaitGroup
+		for i := range ctxs {
+			ctxs[i] = newBatchRequest(body)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				srv.handle(ctxs[i])
+			}()
+		}
+		require.Eventually(t, func() bool { return sink.waiting.Load() > 0 }, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		for _, ctx := range ctxs {
+			assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		}
+		assert.Len(t, sink.events, 2)
+	})
+
+	t.Run("rejected batch is not remembered", func(t *testing.T) {
+		sink := &mockSink{err: apperr.ErrRateLimited}
+		srv := New(sink, WithBatchDedup(time.Minute))
+
+		ctx := newBatchRequest(body)
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+
+		sink.err = nil
+		ctx = newBatchRequest(body)
+		srv.handle(ctx)
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.Len(t, sink.events, 2)
+	})
+
+	t.Run("entries expire", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := New(sink, WithBatchDedup(time.Minute))
+		now := time.Now()
+		srv.batches.now = func() time.Time { return now }
+
+		srv.handle(newBatchRequest(body))
+		now = now.Add(2 * time.Minute)
+		srv.handle(newBatchRequest(body))
+
+		assert.Len(t, sink.events, 4)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		sink := &mockSink{}
+		srv := New(sink)
+
+		srv.handle(newBatchRequest(body))
+		srv.handle(newBatchRequest(body))
+
+		assert.Len(t, sink.events, 4)
+	})
+}
+
+func newMsgpackBatchRequest(t *testing.T, events ...entity.Event) *fasthttp.RequestCtx {
+	t.Helper()
+	body := msgp.AppendArrayHeader(nil, uint32(len(events)))
+	for _, ev := range events {
+		var err error
+		body, err = ev.MarshalMsg(body)
+		require.NoError(t, err)
+	}
+	ctx := newBatchRequest(string(body))
+	ctx.Request.Header.SetContentType("application/msgpack")
+	return ctx
+}
+
+func TestMsgpackBatch(t *testing.T) {
+	events := []entity.Event{
+		{IdempotencyID: "a", Sensor: "temp", Value: 10, UnixTimestamp: 1000},
+		{IdempotencyID: "b", Sensor: "temp", Value: 20, UnixTimestamp: 2000},
+		{IdempotencyID: "a", Sensor: "temp", Value: 10, UnixTimestamp: 1000},
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		sink := &dedupSink{seen: map[string]bool{}}
+		ctx := newMsgpackBatchRequest(t, events...)
+		New(sink).handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"accepted":2,"duplicates":1,"total":3}`, string(ctx.Response.Body()))
+	})
+
+	t.Run("not an array", func(t *testing.T) {
+		sink := &mockSink{}
+		_, body := sampleEvent()
+		ctx := newBatchRequest(string(body))
+		ctx.Request.Header.SetContentType("application/msgpack")
+		New(sink).handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+		assert.Empty(t, sink.events)
+	})
+
+	t.Run("malformed event keeps the ones before it", func(t *testing.T) {
+		sink := &mockSink{}
+		ctx := newMsgpackBatchRequest(t, events[:2]...)
+		body := append(msgp.AppendArrayHeader(nil, 3), ctx.Request.Body()[1:]...)
+		ctx.Request.SetBody(append(body, 0xc1)) // never used
+		New(sink).handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Body()), "parse error at event 2, 2 events before it accepted")
+		assert.Len(t, sink.events, 2)
+	})
+}
+
+func TestHandleBackfill(t *testing.T) {
+	body := `{"sensor":"temp","val":10,"ts":1000}
+{"sensor":"temp","val":20,"ts":2000}`
+	newBackfillRequest := func(body string) *fasthttp.RequestCtx {
+		ctx := newBatchRequest(body)
+		ctx.Request.SetRequestURI("/ingest/backfill")
+		return ctx
+	}
+
+	t.Run("appends with AppendBackfill", func(t *testing.T) {
+		sink := &backfillSink{}
+		srv := New(sink, WithBackfill())
+
+		ctx := newBackfillRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"accepted":2,"duplicates":0,"total":2}`, string(ctx.Response.Body()))
+		assert.Len(t, sink.backfilled, 2)
+		assert.Empty(t, sink.events)
+	})
+
+	t.Run("replays kept apart from live batches", func(t *testing.T) {
+		sink := &backfillSink{}
+		srv := New(sink, WithBackfill(), WithBatchDedup(time.Minute))
+
+		srv.handle(newBatchRequest(body))
+		ctx := newBackfillRequest(body)
+		srv.handle(ctx)
+		replay := newBackfillRequest(body)
+		srv.handle(replay)
+
+		assert.NotContains(t, string(ctx.Response.Body()), "replayed")
+		assert.Contains(t, string(replay.Response.Body()), `"replayed":true`)
+		assert.Len(t, sink.events, 2)
+		assert.Len(t, sink.backfilled, 2)
+	})
+
+	t.Run("quota exceeded", func(t *testing.T) {
+		srv := New(&backfillSink{mockSink: mockSink{err: apperr.ErrQuotaExceeded}}, WithBackfill())
+
+		ctx := newBackfillRequest(body)
+		srv.handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		ctx := newBackfillRequest(body)
+		New(&backfillSink{}).handle(ctx)
+		assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	})
+
+	t.Run("sink without backfill", func(t *testing.T) {
+		ctx := newBackfillRequest(body)
+		New(&mockSink{}, WithBackfill()).handle(ctx)
+		assert.Equal(t, fasthttp.StatusNotImplemented, ctx.Response.StatusCode())
+	})
+}
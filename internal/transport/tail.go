@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// tailKeepalive is how often /tail sends a comment while no events come,
+// so proxies don't close an idle stream and a client gone away is noticed.
+const tailKeepalive = 15 * time.Second
+
+// handleTail streams events as they're written to the journal, as
+// server-sent events with the sequence number as id: from ?from=<seq> on,
+// from the one after Last-Event-ID when an EventSource reconnects, or
+// only new ones. ?sensor=<name> keeps one sensor's events. The stream
+// runs until the client goes away or the server shuts down.
+func (s *Server) handleTail(ctx *fasthttp.RequestCtx) {
+	tailer, ok := s.events.(EventTailer)
+	if !ok {
+		ctx.Error("event queries not enabled", fasthttp.StatusNotFound)
+		return
+	}
+
+	from := tailer.LastSeq() + 1
+	if v := ctx.Request.Header.Peek("Last-Event-ID"); len(v) > 0 {
+		seq, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			ctx.Error("Last-Event-ID must be a sequence number", fasthttp.StatusBadRequest)
+			return
+		}
+		from = seq + 1
+	}
+	if v := ctx.QueryArgs().Peek("from"); v != nil {
+		seq, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			ctx.Error("from must be a sequence number", fasthttp.StatusBadRequest)
+			return
+		}
+		from = seq
+	}
+	sensor, filter := string(ctx.QueryArgs().Peek("sensor")), ctx.QueryArgs().Has("sensor")
+	var prefix []byte
+	if filter {
+		prefix = s.eventKeys.Prefix(sensor)
+	}
+
+	// the stream outlives the handler, so take what it needs now
+	log := reqLog(ctx)
+	conn, timeout := ctx.Conn(), s.srv.WriteTimeout
+	shutdown := ctx.Done()
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-cache")
+	s.setBodyStreamWriter(ctx, func(w *bufio.Writer) {
+		tailStreams.Inc()
+		defer tailStreams.Dec()
+
+		var mu sync.Mutex
+		gone := false // the client went away, which is how streams end
+		send := func(b []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			// the write timeout is for each write, not the whole stream
+			if timeout > 0 && conn != nil {
+				_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+			}
+			_, err := w.Write(b)
+			if err == nil {
+				err = w.Flush()
+			}
+			gone = err != nil
+			return err
+		}
+
+		tctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(tailKeepalive)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-tctx.Done():
+					return
+				case <-shutdown:
+					cancel()
+					return
+				case <-ticker.C:
+					if send([]byte(": keepalive\n\n")) != nil {
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+
+		err := tailer.Tail(tctx, from, func(e *journal.Entry) error {
+			if !bytes.HasPrefix(e.Key, prefix) {
+				return nil
+			}
+			// text key prefixes also match longer sensor names
+			if name, _, err := sink.DecodeKey(e.Key); err != nil || filter && name != sensor {
+				return nil
+			}
+			je, ok := journalEvent(e)
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(je)
+			if err != nil {
+				return err
+			}
+			frame := make([]byte, 0, len(data)+32)
+			frame = append(frame, "id: "...)
+			frame = strconv.AppendUint(frame, je.Seq, 10)
+			frame = append(frame, "\ndata: "...)
+			frame = append(frame, data...)
+			frame = append(frame, "\n\n"...)
+			tailEvents.Inc()
+			return send(frame)
+		})
+		cancel()
+		wg.Wait()
+		if err != nil && !gone && !errors.Is(err, context.Canceled) && !errors.Is(err, journal.ErrJournalClosed) {
+			log.Warn("tail ended", "error", err)
+		}
+	})
+}
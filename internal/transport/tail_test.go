@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+func TestHandleTail(t *testing.T) {
+	j, err := journal.New(journal.NewMemStorage(), 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+	write := func(sensor string, ts int64) {
+		t.Helper()
+		ev := entity.Event{Sensor: sensor, Value: 1, UnixTimestamp: ts}
+		value, err := sink.EncodeValue(nil, &ev)
+		require.NoError(t, err)
+		_, err = j.Write(sink.TextKeys.Encode(sensor, ts), value)
+		require.NoError(t, err)
+	}
+	for i, sensor := range []string{"temp", "temp-2", sink.CanarySensor, "temp"} {
+		write(sensor, int64(i))
+	}
+
+	srv := New(&mockSink{}, WithEvents(j, sink.TextKeys))
+	get := func(uri string, header ...string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&fasthttp.Request{}, nil, nil)
+		ctx.Request.SetRequestURI(uri)
+		for i := 0; i+1 < len(header); i += 2 {
+			ctx.Request.Header.Set(header[i], header[i+1])
+		}
+		srv.handle(ctx)
+		return ctx
+	}
+	// ids reads the ids of the next n events off the stream
+	ids := func(r *bufio.Reader, n int) []string {
+		t.Helper()
+		var got []string
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for len(got) < n {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if id, ok := strings.CutPrefix(line, "id: "); ok {
+					got = append(got, strings.TrimSpace(id))
+				}
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no events on the stream")
+		}
+		return got
+	}
+
+	ctx := get("/tail?from=1&sensor=temp")
+	require.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "text/event-stream", string(ctx.Response.Header.ContentType()))
+	filtered := bufio.NewReader(ctx.Response.BodyStream())
+	assert.Equal(t, []string{"1", "4"}, ids(filtered, 2))
+
+	all := bufio.NewReader(get("/tail", "Last-Event-ID", "1").Response.BodyStream())
+	assert.Equal(t, []string{"2", "4"}, ids(all, 2), "canary left out")
+	fresh := bufio.NewReader(get("/tail").Response.BodyStream())
+
+	write("temp", 5)
+	write("hum", 6)
+	assert.Equal(t, []string{"5"}, ids(filtered, 1))
+	assert.Equal(t, []string{"5", "6"}, ids(all, 2))
+	assert.Equal(t, []string{"5", "6"}, ids(fresh, 2))
+
+	line, err := fresh.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: ", line[:6])
+	assert.Contains(t, line, `"sensor":"hum"`)
+
+	for uri, status := range map[string]int{
+		"/tail?from=abc": fasthttp.StatusBadRequest,
+		"/tail?from=-1":  fasthttp.StatusBadRequest,
+	} {
+		assert.Equal(t, status, get(uri).Response.StatusCode(), uri)
+	}
+	assert.Equal(t, fasthttp.StatusBadRequest, get("/tail", "Last-Event-ID", "x").Response.StatusCode())
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/tail")
+	New(&mockSink{}).handle(ctx)
+	assert.Equal(t, fasthttp.StatusNotFound, ctx.Response.StatusCode(), "events not enabled")
+}
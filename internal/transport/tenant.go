@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Tenant labels for requests without the header, and for the tenants past
+// the ones that get a label of their own.
+const (
+	tenantNone  = "none"
+	tenantOther = "other"
+)
+
+// maxTenantLen is the longest header value taken as a tenant name.
+const maxTenantLen = 64
+
+// WithTenantLabel labels request durations with the tenant named by the
+// header, as set by devices or the gateway in front. Listed tenants get a
+// label each and every other value "other"; with none listed the first
+// max distinct values seen do, so an unknown client can't grow the
+// series without bound.
+func WithTenantLabel(header string, tenants []string, max int) Option {
+	return func(s *Server) {
+		if header == "" {
+			return
+		}
+		t := &tenantLabels{header: header, max: max, seen: make(map[string]struct{})}
+		for _, name := range tenants {
+			t.seen[name] = struct{}{}
+		}
+		t.fixed = len(tenants) > 0
+		s.tenants = t
+	}
+}
+
+type tenantLabels struct {
+	header string
+	fixed  bool // only the configured tenants are labeled
+	max    int
+
+	mu   sync.RWMutex
+	seen map[string]struct{}
+}
+
+// label returns the tenant label for ctx, "" without WithTenantLabel.
+func (t *tenantLabels) label(ctx *fasthttp.RequestCtx) string {
+	if t == nil {
+		return ""
+	}
+	v := ctx.Request.Header.Peek(t.header)
+	if len(v) == 0 {
+		return tenantNone
+	}
+	if len(v) > maxTenantLen {
+		return tenantOther
+	}
+
+	t.mu.RLock()
+	_, ok := t.seen[string(v)]
+	t.mu.RUnlock()
+	if ok {
+		return string(v)
+	}
+	if t.fixed {
+		return tenantOther
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.seen[string(v)]; ok {
+		return string(v)
+	}
+	if len(t.seen) >= t.max {
+		return tenantOther
+	}
+	name := string(v)
+	t.seen[name] = struct{}{}
+	return name
+}
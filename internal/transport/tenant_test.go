@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func TestTenantLabel(t *testing.T) {
+	label := func(srv *Server, tenant string) string {
+		t.Helper()
+		ctx := &fasthttp.RequestCtx{}
+		if tenant != "" {
+			ctx.Request.Header.Set("X-Tenant", tenant)
+		}
+		return srv.tenants.label(ctx)
+	}
+
+	t.Run("off", func(t *testing.T) {
+		srv := New(&mockSink{}, WithTenantLabel("", nil, 10))
+		assert.Equal(t, "", label(srv, "acme"))
+	})
+
+	t.Run("listed tenants", func(t *testing.T) {
+		srv := New(&mockSink{}, WithTenantLabel("X-Tenant", []string{"acme", "globex"}, 10))
+		assert.Equal(t, "acme", label(srv, "acme"))
+		assert.Equal(t, "globex", label(srv, "globex"))
+		assert.Equal(t, "other", label(srv, "initech"))
+		assert.Equal(t, "none", label(srv, ""))
+	})
+
+	t.Run("first seen up to max", func(t *testing.T) {
+		srv := New(&mockSink{}, WithTenantLabel("X-Tenant", nil, 2))
+		assert.Equal(t, "acme", label(srv, "acme"))
+		assert.Equal(t, "globex", label(srv, "globex"))
+		assert.Equal(t, "other", label(srv, "initech"))
+		assert.Equal(t, "acme", label(srv, "acme"))
+		assert.Equal(t, "other", label(srv, strings.Repeat("x", maxTenantLen+1)))
+	})
+}
+
+func TestRouteDuration(t *testing.T) {
+	srv := New(&mockSink{}, WithTenantLabel("X-Tenant", []string{"acme"}, 0))
+
+	observed := func(path, tenant string) int {
+		n := 0
+		routeDuration(path, tenant).VisitNonZeroBuckets(func(_ string, count uint64) {
+			n += int(count)
+		})
+		return n
+	}
+	before, beforeOther := observed("/healthz", "acme"), observed("other", "none")
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/healthz")
+	ctx.Request.Header.Set("X-Tenant", "acme")
+	srv.handle(ctx)
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/no/such/path")
+	srv.handle(ctx)
+
+	assert.Equal(t, before+1, observed("/healthz", "acme"))
+	assert.Equal(t, beforeOther+1, observed("other", "none"))
+}
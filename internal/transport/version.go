@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// APIVersionHeader picks the API version of a request whose path doesn't,
+// and tells in every response which version answered.
+const APIVersionHeader = "API-Version"
+
+// APIVersion is a version of the wire format. Routes are the same in all
+// of them; what changes between versions is how requests and responses
+// look, so breaking changes go to a new version while firmware in the
+// field keeps the one it was built for.
+type APIVersion int
+
+const (
+	// V1 is the original API: plain text errors, and a batch cut short
+	// says how many events were accepted in its error text.
+	V1 APIVersion = 1
+	// V2 answers errors with an APIError, which for a batch cut short
+	// holds the counts of what became of the events before the cut.
+	V2 APIVersion = 2
+
+	latestVersion = V2
+)
+
+type apiVersionKey struct{}
+
+// Version returns the API version ctx is served with: the one its path
+// starts with, e.g. /v2/ingest, or else the one in its API-Version
+// header, or V1.
+func Version(ctx *fasthttp.RequestCtx) APIVersion {
+	if v, ok := ctx.UserValue(apiVersionKey{}).(APIVersion); ok {
+		return v
+	}
+	return V1
+}
+
+// parseVersion takes "2" or "v2".
+func parseVersion(s string) (APIVersion, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	if err != nil || n < int(V1) || n > int(latestVersion) {
+		return 0, false
+	}
+	return APIVersion(n), true
+}
+
+// cutVersion cuts a leading /v1, /v2... off path.
+func cutVersion(path string) (APIVersion, string, bool) {
+	rest, ok := strings.CutPrefix(path, "/v")
+	if !ok {
+		return 0, path, false
+	}
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		return 0, path, false
+	}
+	v, ok := parseVersion(rest[:i])
+	if !ok {
+		return 0, path, false
+	}
+	return v, rest[i:], true
+}
+
+// APIError is the body of an error response from V2 on.
+type APIError struct {
+	Error     string `json:"error"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+	// Batch says what became of the events of a batch before the one it
+	// was cut short at.
+	Batch *BatchResult `json:"batch,omitempty"`
+}
+
+type partialBatchKey struct{}
+
+// apiVersion takes the version from the API-Version header, the router
+// overriding it with the one in the path, and shapes the response the way
+// that version answers. Unknown versions get 400.
+func (s *Server) apiVersion(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if h := ctx.Request.Header.Peek(APIVersionHeader); len(h) > 0 {
+			v, ok := parseVersion(string(h))
+			if !ok {
+				ctx.Error("unsupported "+APIVersionHeader+" "+strconv.Quote(string(h))+", use 1 to "+strconv.Itoa(int(latestVersion)), fasthttp.StatusBadRequest)
+				ctx.Response.Header.Set(APIVersionHeader, strconv.Itoa(int(latestVersion)))
+				return
+			}
+			ctx.SetUserValue(apiVersionKey{}, v)
+		}
+
+		next(ctx)
+
+		v := Version(ctx)
+		apiRequests(v).Inc()
+		if v >= V2 {
+			structuredError(ctx)
+		}
+		ctx.Response.Header.Set(APIVersionHeader, strconv.Itoa(int(v)))
+	}
+}
+
+// structuredError turns a plain text error response into an APIError.
+func structuredError(ctx *fasthttp.RequestCtx) {
+	code := ctx.Response.StatusCode()
+	if code < fasthttp.StatusBadRequest || ctx.Response.IsBodyStream() || len(ctx.Response.Header.ContentEncoding()) > 0 {
+		return
+	}
+	msg := ctx.Response.Body()
+	if len(msg) > 0 && !bytes.HasPrefix(ctx.Response.Header.ContentType(), []byte("text/plain")) {
+		return // already structured, like a 405
+	}
+	e := APIError{Error: string(msg), Status: code, RequestID: RequestID(ctx)}
+	if len(msg) == 0 {
+		e.Error = strings.ToLower(fasthttp.StatusMessage(code))
+	}
+	if res, ok := ctx.UserValue(partialBatchKey{}).(BatchResult); ok {
+		e.Batch = &res
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// mountPath is p as a client reaches it on the path ctx came in on, under
+// the base path and the version prefix, if any.
+func mountPath(ctx *fasthttp.RequestCtx, p string) string {
+	full := string(ctx.Path())
+	local, ok := ctx.UserValue(localPathKey{}).(string)
+	if !ok {
+		return p
+	}
+	return full[:len(full)-len(local)] + p
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	apperr "github.com/andriibeee/iotdemo/internal/errors"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// fillingSink takes room events, then reports a full buffer.
+type fillingSink struct {
+	mockSink
+	room int
+}
+
+func (s *fillingSink) Append(ev entity.Event) error {
+	if len(s.events) >= s.room {
+		return apperr.ErrBufferFull
+	}
+	return s.mockSink.Append(ev)
+}
+
+func TestAPIVersion(t *testing.T) {
+	_, body := sampleEvent()
+	post := func(srv *Server, uri string, header string) *fasthttp.RequestCtx {
+		ctx := newEventRequest(body)
+		ctx.Request.SetRequestURI(uri)
+		if header != "" {
+			ctx.Request.Header.Set(APIVersionHeader, header)
+		}
+		srv.handle(ctx)
+		return ctx
+	}
+
+	t.Run("v1 by default, by path or by header", func(t *testing.T) {
+		srv := New(&mockSink{})
+		for _, tc := range []struct {
+			uri, header string
+			want        string
+		}{
+			{"/ingest", "", "1"},
+			{"/v1/ingest", "", "1"},
+			{"/v2/ingest", "", "2"},
+			{"/ingest", "2", "2"},
+			{"/ingest", "v2", "2"},
+			{"/v1/ingest", "2", "1"}, // the path wins
+		} {
+			ctx := post(srv, tc.uri, tc.header)
+			assert.Equal(t, fasthttp.StatusAccepted, ctx.Response.StatusCode(), tc)
+			assert.Equal(t, tc.want, string(ctx.Response.Header.Peek(APIVersionHeader)), tc)
+		}
+	})
+
+	t.Run("unknown versions", func(t *testing.T) {
+		srv := New(&mockSink{})
+		assert.Equal(t, fasthttp.StatusBadRequest, post(srv, "/ingest", "3").Response.StatusCode())
+		assert.Equal(t, fasthttp.StatusBadRequest, post(srv, "/ingest", "latest").Response.StatusCode())
+		assert.Equal(t, fasthttp.StatusNotFound, post(srv, "/v3/ingest", "").Response.StatusCode())
+	})
+
+	t.Run("v1 errors stay plain text", func(t *testing.T) {
+		ctx := post(New(&mockSink{err: apperr.ErrBufferFull}), "/v1/ingest", "")
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Contains(t, string(ctx.Response.Header.ContentType()), "text/plain")
+		assert.Contains(t, string(ctx.Response.Body()), "request_id: ")
+	})
+
+	t.Run("v2 errors are structured", func(t *testing.T) {
+		ctx := post(New(&mockSink{err: apperr.ErrBufferFull}), "/v2/ingest", "")
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		assert.Equal(t, "application/json", string(ctx.Response.Header.ContentType()))
+
+		var e APIError
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, e.Status)
+		assert.NotEmpty(t, e.Error)
+		assert.Equal(t, string(ctx.Response.Header.Peek(RequestIDHeader)), e.RequestID)
+		assert.Nil(t, e.Batch)
+	})
+
+	t.Run("v2 says what became of a batch cut short", func(t *testing.T) {
+		snk := &fillingSink{room: 2}
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/v2/ingest/batch")
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.Header.SetContentType("application/x-ndjson")
+		ctx.Request.SetBodyString(`{"sensor":"a","val":1,"ts":1}
+{"sensor":"b","val":2,"ts":2}
+{"sensor":"c","val":3,"ts":3}
+`)
+		New(snk).handle(ctx)
+
+		assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+		var e APIError
+		require.NoError(t, json.Unmarshal(ctx.Response.Body(), &e))
+		require.NotNil(t, e.Batch)
+		assert.Equal(t, BatchResult{Accepted: 2, Total: 3}, *e.Batch)
+	})
+
+	t.Run("Location keeps the prefixes", func(t *testing.T) {
+		j, err := journal.New(journal.NewMemStorage(), 0)
+		require.NoError(t, err)
+		defer j.Close()
+		srv := New(&seqSink{seq: 7}, WithBasePath("/iot"), WithEvents(j, sink.TextKeys))
+		ctx := post(srv, "/iot/v2/ingest?seq=true", "")
+		assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+		assert.Equal(t, "/iot/v2/events/7", string(ctx.Response.Header.Peek(fasthttp.HeaderLocation)))
+	})
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Batcher buffers events and sends them with SendBatch when size events
+// have accumulated or interval has passed since the last flush, whichever
+// comes first.
+type Batcher struct {
+	c        *Client
+	size     int
+	interval time.Duration
+
+	mu  sync.Mutex
+	buf []Event
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (c *Client) NewBatcher(size int, interval time.Duration) *Batcher {
+	b := &Batcher{
+		c:        c,
+		size:     size,
+		interval: interval,
+		buf:      make([]Event, 0, size),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	if b.interval <= 0 {
+		<-b.stop
+		return
+	}
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Flush(context.Background()); err != nil {
+				slog.Warn("batch flush failed", "error", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Add buffers ev, flushing synchronously when the batch is full.
+func (b *Batcher) Add(ctx context.Context, ev Event) error {
+	b.mu.Lock()
+	b.buf = append(b.buf, ev)
+	if len(b.buf) < b.size {
+		b.mu.Unlock()
+		return nil
+	}
+	events := b.take()
+	b.mu.Unlock()
+
+	return b.c.SendBatch(ctx, events)
+}
+
+// Flush sends whatever is buffered.
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	events := b.take()
+	b.mu.Unlock()
+
+	return b.c.SendBatch(ctx, events)
+}
+
+func (b *Batcher) take() []Event {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	events := b.buf
+	b.buf = make([]Event, 0, b.size)
+	return events
+}
+
+// Close stops the auto-flush and sends what's left.
+func (b *Batcher) Close(ctx context.Context) error {
+	close(b.stop)
+	<-b.done
+	return b.Flush(ctx)
+}
@@ -0,0 +1,406 @@
+// Package client is a Go SDK for the sink's ingest API: single and batch
+// sends, retries with jitter that honour Retry-After, batching with
+// auto-flush, offline spooling to a local journal and mTLS.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/entity"
+	"github.com/andriibeee/iotdemo/pkg/retry"
+)
+
+// Event is the ingest payload.
+type Event = entity.Event
+
+// DeviceInfo is what a device reports about itself on POST /device.
+type DeviceInfo = entity.DeviceInfo
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrRejected    = errors.New("rejected")
+	ErrServer      = errors.New("server error")
+)
+
+// StatusError is returned for a response the client doesn't treat as
+// success. It unwraps to ErrRateLimited, ErrRejected or ErrServer.
+type StatusError struct {
+	Err  error
+	Code int
+	// RetryAfter is how long the sink asked to wait, from Retry-After or,
+	// on a 429 without one, X-RateLimit-Reset; 0 if it didn't say.
+	RetryAfter time.Duration
+	// RequestID is the server's X-Request-ID for the response, to quote
+	// when asking what happened to the request.
+	RequestID string
+}
+
+func (e *StatusError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s: status %d (request id %s)", e.Err, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("%s: status %d", e.Err, e.Code)
+}
+
+func (e *StatusError) Unwrap() error { return e.Err }
+
+// RetryAfter reports how long the sink asked to wait before retrying the
+// request that failed with err. It's a retry.DelayFromError, so calls
+// wrapped in a retryer of their own wait as the sink says rather than for
+// their backoff.
+func RetryAfter(err error) (time.Duration, bool) {
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		return se.RetryAfter, true
+	}
+	return 0, false
+}
+
+var _ retry.DelayFromError = RetryAfter
+
+// Retry-After values above this end the retry loop instead of sleeping.
+const maxRetryAfter = 10 * time.Second
+
+type Client struct {
+	addr    string
+	http    *fasthttp.Client
+	codec   Codec
+	timeout time.Duration
+
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	budget      *retry.Budget
+	breaker     *retry.Breaker
+
+	tls   *tls.Config
+	spool *spool
+
+	retries   atomic.Int64
+	throttled atomic.Int64
+	spooled   atomic.Int64
+}
+
+type Option func(*Client) error
+
+func WithCodec(c Codec) Option {
+	return func(cl *Client) error {
+		cl.codec = c
+		return nil
+	}
+}
+
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithRetry sets how many times a request is attempted and the bounds of
+// the exponential backoff between attempts. Each delay is drawn uniformly
+// from [0, backoff) so clients recovering from the same outage spread out.
+func WithRetry(attempts int, base, max time.Duration) Option {
+	return func(c *Client) error {
+		c.maxAttempts = attempts
+		c.baseDelay = base
+		c.maxDelay = max
+		return nil
+	}
+}
+
+// WithRetryBudget draws every retry from b, which can be shared by many
+// clients so together they add at most a fixed number of retries per
+// window while the sink is down. An empty budget ends the retry loop.
+func WithRetryBudget(b *retry.Budget) Option {
+	return func(c *Client) error {
+		c.budget = b
+		return nil
+	}
+}
+
+// WithBreaker stops sending while b is open, returning an error wrapping
+// retry.ErrCircuitOpen instead. Network errors and 5xx responses count as
+// failures; rejections and 429s show the sink is up and count as
+// successes.
+func WithBreaker(b *retry.Breaker) Option {
+	return func(c *Client) error {
+		c.breaker = b
+		return nil
+	}
+}
+
+// WithTLS enables mTLS with a client certificate. caFile verifies the
+// server; empty uses the system roots.
+func WithTLS(certFile, keyFile, caFile string) Option {
+	return func(c *Client) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		cfg := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates in %s", caFile)
+			}
+			cfg.RootCAs = pool
+		}
+		c.tls = cfg
+		return nil
+	}
+}
+
+// WithSpool keeps events that could not be delivered after all retries in
+// a journal under dir, to be resent with Drain once the sink is reachable.
+func WithSpool(dir string) Option {
+	return func(c *Client) error {
+		sp, err := openSpool(dir)
+		if err != nil {
+			return err
+		}
+		c.spool = sp
+		return nil
+	}
+}
+
+// New creates a client for the sink at addr, e.g. "http://localhost:8080".
+func New(addr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		addr:        addr,
+		codec:       Msgpack,
+		timeout:     5 * time.Second,
+		maxAttempts: 3,
+		baseDelay:   100 * time.Millisecond,
+		maxDelay:    time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			if c.spool != nil {
+				_ = c.spool.close()
+			}
+			return nil, err
+		}
+	}
+	c.http = &fasthttp.Client{TLSConfig: c.tls}
+	return c, nil
+}
+
+// Close releases the spool, if any.
+func (c *Client) Close() error {
+	if c.spool == nil {
+		return nil
+	}
+	return c.spool.close()
+}
+
+// Retries is the number of attempts beyond the first made so far.
+func (c *Client) Retries() int64 { return c.retries.Load() }
+
+// Throttled is the number of retries that waited as long as the sink
+// asked instead of for the backoff.
+func (c *Client) Throttled() int64 { return c.throttled.Load() }
+
+// Spooled is the number of events written to the spool so far.
+func (c *Client) Spooled() int64 { return c.spooled.Load() }
+
+// Send delivers a single event. An event without an IdempotencyID gets one
+// so retries can't create duplicates. Duplicates reported by the sink count
+// as delivered. With a spool configured, an event that still fails after
+// all retries for a reason other than being rejected is spooled and Send
+// returns nil.
+func (c *Client) Send(ctx context.Context, ev Event) error {
+	if ev.IdempotencyID == "" {
+		ev.IdempotencyID = uuid.NewString()
+	}
+
+	body, err := c.codec.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	err = c.do(ctx, "/ingest", c.codec.ContentType(), nil, body)
+	return c.spoolOnFailure(err, []Event{ev})
+}
+
+// SendDevice reports info about the device the client runs on, e.g. as a
+// periodic heartbeat. It's retried like an event but never spooled: a
+// report that's stale by the time the sink is back is better left out.
+func (c *Client) SendDevice(ctx context.Context, info DeviceInfo) error {
+	body, err := c.codec.MarshalDevice(info)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, "/device", c.codec.ContentType(), nil, body)
+}
+
+// SendBatch delivers events as one NDJSON batch. The batch carries an
+// Idempotency-Key so a retransmission after a lost response is
+// acknowledged by the sink without being processed twice.
+func (c *Client) SendBatch(ctx context.Context, events []Event) error {
+	err := c.sendBatch(ctx, events)
+	return c.spoolOnFailure(err, events)
+}
+
+func (c *Client) sendBatch(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range events {
+		if events[i].IdempotencyID == "" {
+			events[i].IdempotencyID = uuid.NewString()
+		}
+		if err := enc.Encode(events[i]); err != nil {
+			return err
+		}
+	}
+
+	headers := map[string]string{"Idempotency-Key": uuid.NewString()}
+	return c.do(ctx, "/ingest/batch", "application/x-ndjson", headers, buf.Bytes())
+}
+
+func (c *Client) spoolOnFailure(err error, events []Event) error {
+	if err == nil || c.spool == nil || errors.Is(err, ErrRejected) {
+		return err
+	}
+	if serr := c.spool.write(events); serr != nil {
+		return errors.Join(err, serr)
+	}
+	c.spooled.Add(int64(len(events)))
+	slog.Debug("events spooled", "count", len(events), "error", err)
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, path, contentType string, headers map[string]string, body []byte) error {
+	delay := c.baseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		if c.breaker != nil && !c.breaker.Allow() {
+			if err != nil {
+				return errors.Join(err, retry.ErrCircuitOpen)
+			}
+			return retry.ErrCircuitOpen
+		}
+		err = c.post(path, contentType, headers, body)
+		if c.breaker != nil {
+			if err == nil || errors.Is(err, ErrRejected) || errors.Is(err, ErrRateLimited) {
+				c.breaker.Success()
+			} else {
+				c.breaker.Failure()
+			}
+		}
+		if err == nil || errors.Is(err, ErrRejected) || attempt >= c.maxAttempts {
+			return err
+		}
+		if c.budget != nil && !c.budget.Allow() {
+			return errors.Join(err, retry.ErrBudgetExhausted)
+		}
+		c.retries.Add(1)
+
+		wait := time.Duration(0)
+		if delay > 0 {
+			wait = rand.N(delay)
+		}
+		if d, ok := RetryAfter(err); ok {
+			if d > maxRetryAfter {
+				// e.g. a daily quota; not worth blocking the caller for
+				return err
+			}
+			if d > wait {
+				wait = d
+				c.throttled.Add(1)
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+
+		delay = min(delay*2, c.maxDelay)
+	}
+}
+
+func (c *Client) post(path, contentType string, headers map[string]string, body []byte) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(c.addr + path)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType(contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.SetBody(body)
+
+	if err := c.http.DoTimeout(req, resp, c.timeout); err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	code := resp.StatusCode()
+	reqID := string(resp.Header.Peek("X-Request-ID"))
+	switch {
+	case code == fasthttp.StatusOK, code == fasthttp.StatusAccepted, code == fasthttp.StatusConflict:
+		return nil
+	case code == fasthttp.StatusTooManyRequests:
+		se := &StatusError{Err: ErrRateLimited, Code: code, RequestID: reqID, RetryAfter: retryAfter(&resp.Header)}
+		if se.RetryAfter == 0 {
+			se.RetryAfter = seconds(resp.Header.Peek("X-RateLimit-Reset"))
+		}
+		return se
+	case code >= fasthttp.StatusInternalServerError:
+		// a full buffer, memory pressure or a full disk say when to
+		// come back too
+		return &StatusError{Err: ErrServer, Code: code, RequestID: reqID, RetryAfter: retryAfter(&resp.Header)}
+	default:
+		return &StatusError{Err: ErrRejected, Code: code, RequestID: reqID}
+	}
+}
+
+// retryAfter reads Retry-After, in seconds or as an HTTP date.
+func retryAfter(h *fasthttp.ResponseHeader) time.Duration {
+	v := h.Peek("Retry-After")
+	if d := seconds(v); d > 0 {
+		return d
+	}
+	if at, err := fasthttp.ParseHTTPDate(v); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+func seconds(v []byte) time.Duration {
+	secs, err := strconv.Atoi(string(v))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
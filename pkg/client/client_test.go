@@ -0,0 +1,396 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/pkg/retry"
+)
+
+// fakeSink answers with the queued status codes, then 202 forever.
+type fakeSink struct {
+	mu       sync.Mutex
+	statuses []int
+	headers  map[string]string
+	events   []Event
+	devices  []DeviceInfo
+	requests []*fasthttp.Request
+}
+
+func (f *fakeSink) handle(ctx *fasthttp.RequestCtx) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	req := &fasthttp.Request{}
+	ctx.Request.CopyTo(req)
+	f.requests = append(f.requests, req)
+
+	if len(f.statuses) > 0 {
+		code := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		if code != fasthttp.StatusAccepted {
+			for k, v := range f.headers {
+				ctx.Response.Header.Set(k, v)
+			}
+			ctx.SetStatusCode(code)
+			return
+		}
+	}
+
+	if string(ctx.Path()) == "/device" {
+		var info DeviceInfo
+		var err error
+		if string(ctx.Request.Header.ContentType()) == "application/json" {
+			err = json.Unmarshal(ctx.PostBody(), &info)
+		} else {
+			_, err = info.UnmarshalMsg(ctx.PostBody())
+		}
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		f.devices = append(f.devices, info)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		return
+	}
+
+	switch string(ctx.Request.Header.ContentType()) {
+	case "application/msgpack":
+		var ev Event
+		if _, err := ev.UnmarshalMsg(ctx.PostBody()); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		f.events = append(f.events, ev)
+	case "application/json":
+		var ev Event
+		if err := json.Unmarshal(ctx.PostBody(), &ev); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		f.events = append(f.events, ev)
+	case "application/x-ndjson":
+		sc := bufio.NewScanner(bytes.NewReader(ctx.PostBody()))
+		for sc.Scan() {
+			var ev Event
+			if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+				ctx.SetStatusCode(fasthttp.StatusBadRequest)
+				return
+			}
+			f.events = append(f.events, ev)
+		}
+	}
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+}
+
+func (f *fakeSink) received() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Event(nil), f.events...)
+}
+
+func startSink(t *testing.T, f *fakeSink) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &fasthttp.Server{Handler: f.handle}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return "http://" + ln.Addr().String()
+}
+
+func newClient(t *testing.T, addr string, opts ...Option) *Client {
+	t.Helper()
+	opts = append([]Option{WithRetry(3, time.Millisecond, 5*time.Millisecond)}, opts...)
+	c, err := New(addr, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestSend(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("codecs", func(t *testing.T) {
+		for _, codec := range []Codec{Msgpack, JSON} {
+			f := &fakeSink{}
+			c := newClient(t, startSink(t, f), WithCodec(codec))
+
+			require.NoError(t, c.Send(ctx, Event{Sensor: "temp", Value: 42, UnixTimestamp: 1000}))
+
+			got := f.received()
+			require.Len(t, got, 1, codec.ContentType())
+			assert.Equal(t, "temp", got[0].Sensor)
+			assert.Equal(t, 42, got[0].Value)
+			assert.NotEmpty(t, got[0].IdempotencyID, "filled in for retries")
+		}
+	})
+
+	t.Run("retries server errors with the same id", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{500, 503}}
+		c := newClient(t, startSink(t, f))
+
+		require.NoError(t, c.Send(ctx, Event{Sensor: "temp"}))
+		assert.Equal(t, int64(2), c.Retries())
+
+		var ids []string
+		for _, req := range f.requests {
+			var ev Event
+			_, err := ev.UnmarshalMsg(req.Body())
+			require.NoError(t, err)
+			ids = append(ids, ev.IdempotencyID)
+		}
+		assert.Len(t, ids, 3)
+		assert.Equal(t, ids[0], ids[2])
+	})
+
+	t.Run("duplicate counts as delivered", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{409}}
+		c := newClient(t, startSink(t, f))
+
+		assert.NoError(t, c.Send(ctx, Event{Sensor: "temp"}))
+		assert.Zero(t, c.Retries())
+	})
+
+	t.Run("rejection is not retried", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{400}}
+		c := newClient(t, startSink(t, f))
+
+		err := c.Send(ctx, Event{Sensor: "temp"})
+		assert.ErrorIs(t, err, ErrRejected)
+		assert.Zero(t, c.Retries())
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{500, 500, 500}}
+		c := newClient(t, startSink(t, f))
+
+		err := c.Send(ctx, Event{Sensor: "temp"})
+		var se *StatusError
+		require.ErrorAs(t, err, &se)
+		assert.Equal(t, 500, se.Code)
+		assert.ErrorIs(t, err, ErrServer)
+	})
+
+	t.Run("error carries the request id", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{400}, headers: map[string]string{"X-Request-ID": "req-42"}}
+		c := newClient(t, startSink(t, f))
+
+		err := c.Send(ctx, Event{Sensor: "temp"})
+		var se *StatusError
+		require.ErrorAs(t, err, &se)
+		assert.Equal(t, "req-42", se.RequestID)
+		assert.Contains(t, err.Error(), "request id req-42")
+	})
+
+	t.Run("long Retry-After ends the loop", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{429}, headers: map[string]string{"Retry-After": "3600"}}
+		c := newClient(t, startSink(t, f))
+
+		err := c.Send(ctx, Event{Sensor: "temp"})
+		var se *StatusError
+		require.ErrorAs(t, err, &se)
+		assert.ErrorIs(t, err, ErrRateLimited)
+		assert.Equal(t, time.Hour, se.RetryAfter)
+		assert.Len(t, f.requests, 1)
+	})
+
+	t.Run("waits as long as the sink asks", func(t *testing.T) {
+		for name, headers := range map[string]func() map[string]string{
+			"Retry-After": func() map[string]string { return map[string]string{"Retry-After": "1"} },
+			"HTTP date": func() map[string]string {
+				return map[string]string{"Retry-After": time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)}
+			},
+			"X-RateLimit-Reset": func() map[string]string { return map[string]string{"X-RateLimit-Reset": "1"} },
+		} {
+			f := &fakeSink{statuses: []int{429}, headers: headers()}
+			c := newClient(t, startSink(t, f))
+
+			start := time.Now()
+			require.NoError(t, c.Send(ctx, Event{Sensor: "temp"}), name)
+			assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond, name)
+			assert.Equal(t, int64(1), c.Throttled(), name)
+		}
+	})
+
+	t.Run("Retry-After on a server error", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{507}, headers: map[string]string{"Retry-After": "30"}}
+		c := newClient(t, startSink(t, f))
+
+		err := c.Send(ctx, Event{Sensor: "temp"})
+		assert.ErrorIs(t, err, ErrServer)
+		d, ok := RetryAfter(err)
+		assert.True(t, ok)
+		assert.Equal(t, 30*time.Second, d)
+		assert.Len(t, f.requests, 1)
+	})
+}
+
+func TestRetryAfterWithRetryer(t *testing.T) {
+	f := &fakeSink{statuses: []int{429, 429}, headers: map[string]string{"Retry-After": "1"}}
+	c := newClient(t, startSink(t, f), WithRetry(1, 0, 0))
+
+	r := retry.New(retry.MaxAttempts(3), retry.Delay(retry.DelayOptions{
+		Delay:     time.Millisecond,
+		FromError: RetryAfter,
+	}))
+	start := time.Now()
+	require.NoError(t, r(context.Background(), func(ctx context.Context) error {
+		return c.Send(ctx, Event{Sensor: "temp"})
+	}))
+	assert.GreaterOrEqual(t, time.Since(start), 1900*time.Millisecond)
+	assert.Len(t, f.requests, 3)
+}
+
+func TestRetryLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("budget shared between clients", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{500, 500, 500, 500, 500}}
+		addr := startSink(t, f)
+		budget := retry.NewBudget(1, time.Hour)
+		a := newClient(t, addr, WithRetryBudget(budget))
+		b := newClient(t, addr, WithRetryBudget(budget))
+
+		err := a.Send(ctx, Event{Sensor: "temp"})
+		assert.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		assert.Equal(t, int64(1), a.Retries())
+
+		err = b.Send(ctx, Event{Sensor: "temp"})
+		assert.ErrorIs(t, err, retry.ErrBudgetExhausted)
+		assert.Zero(t, b.Retries())
+		assert.Len(t, f.requests, 3)
+	})
+
+	t.Run("breaker stops sending", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{500, 500, 500}}
+		c := newClient(t, startSink(t, f), WithBreaker(retry.NewBreaker(2, time.Hour)))
+
+		err := c.Send(ctx, Event{Sensor: "temp"})
+		assert.ErrorIs(t, err, ErrServer)
+		assert.ErrorIs(t, err, retry.ErrCircuitOpen)
+		assert.Len(t, f.requests, 2)
+
+		err = c.Send(ctx, Event{Sensor: "temp"})
+		assert.ErrorIs(t, err, retry.ErrCircuitOpen)
+		assert.Len(t, f.requests, 2)
+	})
+
+	t.Run("rejections don't trip the breaker", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{400, 400, 400}}
+		br := retry.NewBreaker(2, time.Hour)
+		c := newClient(t, startSink(t, f), WithBreaker(br))
+
+		for range 3 {
+			assert.ErrorIs(t, c.Send(ctx, Event{Sensor: "temp"}), ErrRejected)
+		}
+		assert.Equal(t, retry.Closed, br.State())
+	})
+}
+
+func TestSendBatch(t *testing.T) {
+	f := &fakeSink{statuses: []int{500}}
+	c := newClient(t, startSink(t, f))
+
+	events := []Event{{Sensor: "a", Value: 1}, {Sensor: "b", Value: 2}}
+	require.NoError(t, c.SendBatch(context.Background(), events))
+
+	assert.Len(t, f.received(), 2)
+	require.Len(t, f.requests, 2)
+	key := string(f.requests[0].Header.Peek("Idempotency-Key"))
+	assert.NotEmpty(t, key)
+	assert.Equal(t, key, string(f.requests[1].Header.Peek("Idempotency-Key")), "retry reuses the key")
+}
+
+func TestSendDevice(t *testing.T) {
+	battery := 87.5
+	info := DeviceInfo{Device: "gw-1", Firmware: "1.0.0", Battery: &battery, Sensors: []string{"temp-1"}, UnixTimestamp: 1000}
+	for _, codec := range []Codec{Msgpack, JSON} {
+		f := &fakeSink{statuses: []int{503}}
+		c := newClient(t, startSink(t, f), WithCodec(codec), WithSpool(t.TempDir()))
+		require.NoError(t, c.SendDevice(context.Background(), info), codec.ContentType())
+		assert.Equal(t, []DeviceInfo{info}, f.devices, codec.ContentType())
+		assert.Equal(t, int64(1), c.Retries())
+	}
+
+	t.Run("never spooled", func(t *testing.T) {
+		f := &fakeSink{statuses: []int{503, 503, 503}}
+		c := newClient(t, startSink(t, f), WithSpool(t.TempDir()))
+		assert.ErrorIs(t, c.SendDevice(context.Background(), info), ErrServer)
+		assert.Zero(t, c.Spooled())
+	})
+}
+
+func TestBatcher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("flushes when full", func(t *testing.T) {
+		f := &fakeSink{}
+		c := newClient(t, startSink(t, f))
+		b := c.NewBatcher(3, 0)
+
+		for i := range 7 {
+			require.NoError(t, b.Add(ctx, Event{Sensor: "temp", Value: i}))
+		}
+		assert.Len(t, f.received(), 6)
+		assert.Len(t, f.requests, 2)
+
+		require.NoError(t, b.Close(ctx))
+		assert.Len(t, f.received(), 7)
+	})
+
+	t.Run("flushes on interval", func(t *testing.T) {
+		f := &fakeSink{}
+		c := newClient(t, startSink(t, f))
+		b := c.NewBatcher(100, 10*time.Millisecond)
+		defer b.Close(ctx)
+
+		require.NoError(t, b.Add(ctx, Event{Sensor: "temp"}))
+		assert.Eventually(t, func() bool { return len(f.received()) == 1 }, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	f := &fakeSink{statuses: []int{500, 500, 500, 500, 500, 500}}
+	addr := startSink(t, f)
+
+	c, err := New(addr, WithRetry(3, time.Millisecond, time.Millisecond), WithSpool(dir))
+	require.NoError(t, err)
+
+	require.NoError(t, c.Send(ctx, Event{Sensor: "a", Value: 1}), "spooled instead of failing")
+	require.NoError(t, c.SendBatch(ctx, []Event{{Sensor: "b", Value: 2}, {Sensor: "c", Value: 3}}))
+	assert.Equal(t, int64(3), c.Spooled())
+	assert.Empty(t, f.received())
+
+	// spool survives a restart
+	require.NoError(t, c.Close())
+	c, err = New(addr, WithSpool(dir))
+	require.NoError(t, err)
+	defer c.Close()
+
+	n, err := c.Drain(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	got := f.received()
+	require.Len(t, got, 3)
+	assert.Equal(t, []string{"a", "b", "c"}, []string{got[0].Sensor, got[1].Sensor, got[2].Sensor})
+
+	n, err = c.Drain(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "delivered events are not resent")
+}
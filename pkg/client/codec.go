@@ -0,0 +1,34 @@
+package client
+
+import (
+	"encoding/json"
+)
+
+// Codec encodes a single event for POST /ingest, and a device report for
+// POST /device.
+type Codec interface {
+	ContentType() string
+	Marshal(ev Event) ([]byte, error)
+	MarshalDevice(info DeviceInfo) ([]byte, error)
+}
+
+var (
+	Msgpack Codec = msgpackCodec{}
+	JSON    Codec = jsonCodec{}
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(ev Event) ([]byte, error) { return ev.MarshalMsg(nil) }
+
+func (msgpackCodec) MarshalDevice(info DeviceInfo) ([]byte, error) { return info.MarshalMsg(nil) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(ev Event) ([]byte, error) { return json.Marshal(ev) }
+
+func (jsonCodec) MarshalDevice(info DeviceInfo) ([]byte, error) { return json.Marshal(info) }
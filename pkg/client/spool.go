@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+const (
+	spoolSegmentSize = 4 * 1024 * 1024
+	spoolCursorName  = "CURSOR"
+	drainBatchSize   = 500
+)
+
+// spool is an on-disk queue of undelivered events: a journal plus a cursor
+// file holding the last sequence number delivered by Drain.
+type spool struct {
+	mu      sync.Mutex
+	dir     string
+	storage *journal.FileStorage
+	j       *journal.Journal
+	cursor  uint64
+}
+
+func openSpool(dir string) (*spool, error) {
+	storage, err := journal.NewFileStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+	j, err := journal.New(storage, spoolSegmentSize)
+	if err != nil {
+		_ = storage.Close()
+		return nil, err
+	}
+
+	sp := &spool{dir: dir, storage: storage, j: j}
+	data, err := os.ReadFile(filepath.Join(dir, spoolCursorName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		_ = sp.close()
+		return nil, err
+	default:
+		if sp.cursor, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			_ = sp.close()
+			return nil, err
+		}
+	}
+	return sp, nil
+}
+
+func (sp *spool) write(events []Event) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	entries := make([]journal.Entry, 0, len(events))
+	for _, ev := range events {
+		val, err := ev.MarshalMsg(nil)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, journal.Entry{Key: []byte(ev.Sensor), Value: val})
+	}
+	if _, err := sp.j.WriteBatch(entries); err != nil {
+		return err
+	}
+	return sp.j.Sync()
+}
+
+// pending returns up to n spooled events after the cursor and the sequence
+// number of the last one.
+func (sp *spool) pending(n int) ([]Event, uint64, error) {
+	var (
+		events []Event
+		last   uint64
+	)
+	errFull := errors.New("full")
+	err := sp.j.Replay(func(e *journal.Entry) error {
+		if e.Seq <= sp.cursor {
+			return nil
+		}
+		var ev Event
+		if _, err := ev.UnmarshalMsg(e.Value); err != nil {
+			return err
+		}
+		events = append(events, ev)
+		last = e.Seq
+		if len(events) == n {
+			return errFull
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errFull) {
+		return nil, 0, err
+	}
+	return events, last, nil
+}
+
+func (sp *spool) advance(seq uint64) error {
+	tmp := filepath.Join(sp.dir, spoolCursorName+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(seq, 10)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(sp.dir, spoolCursorName)); err != nil {
+		return err
+	}
+	sp.cursor = seq
+	_, err := sp.j.TruncateBefore(seq + 1)
+	return err
+}
+
+func (sp *spool) close() error {
+	return errors.Join(sp.j.Close(), sp.storage.Close())
+}
+
+// Drain resends spooled events in batches, oldest first, and returns how
+// many were delivered. It stops at the first batch that fails; what's left
+// stays spooled for the next call.
+func (c *Client) Drain(ctx context.Context) (int, error) {
+	if c.spool == nil {
+		return 0, nil
+	}
+
+	sp := c.spool
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	delivered := 0
+	for {
+		events, last, err := sp.pending(drainBatchSize)
+		if err != nil || len(events) == 0 {
+			return delivered, err
+		}
+		if err := c.sendBatch(ctx, events); err != nil {
+			return delivered, err
+		}
+		if err := sp.advance(last); err != nil {
+			return delivered, err
+		}
+		delivered += len(events)
+	}
+}
@@ -0,0 +1,194 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An atomic batch is framed by a marker record with sequence number 0
+// (real entries start at 1) whose value holds the entry count. Readers hold
+// the batch back until every entry has been read, so a crash halfway
+// through the write loses the whole batch instead of leaving a prefix.
+var batchMarkerKey = []byte("\x00batch")
+
+// errTornBatch reports a segment ending in the middle of an atomic batch.
+var errTornBatch = errors.New("segment ends inside an atomic batch")
+
+// WithAtomicBatches makes WriteBatch all-or-nothing: the batch is encoded
+// up front, written with a single write and fsynced before returning, and
+// segments rotate only between batches.
+func WithAtomicBatches() Option {
+	return func(j *Journal) {
+		j.atomicBatches = true
+	}
+}
+
+func (w *Journal) writeAtomicBatch(ctx context.Context, entries []Entry) ([]uint64, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	if w.size >= w.maxSize {
+		if err := w.newSegment(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// roll back to here if anything fails before the batch reaches the
+	// segment
+	seq, segFirst, segLast, segCRC, segCount := w.seq, w.segFirst, w.segLast, w.segCRC, w.segCount
+	rollback := func() {
+		w.seq, w.segFirst, w.segLast, w.segCRC, w.segCount = seq, segFirst, segLast, segCRC, segCount
+		for i := range entries {
+			entries[i].Seq = 0
+		}
+	}
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+
+	count := binary.BigEndian.AppendUint32(nil, uint32(len(entries)))
+	if _, err := w.write(bw, &Entry{Key: batchMarkerKey, Value: count}); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	seqs := make([]uint64, len(entries))
+	for i := range entries {
+		w.seq++
+		entries[i].Seq = w.seq
+		seqs[i] = w.seq
+		if _, err := w.write(bw, &entries[i]); err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	// from here on the batch may be on disk, so its seqs are spent: the
+	// next batch reusing them would number two records alike
+	n, err := w.writer.Write(buf.Bytes())
+	w.size += int64(n)
+	if err == nil {
+		err = w.writer.Flush()
+	}
+	if err == nil {
+		err = w.storage.Sync(ctx, w.currentFile())
+	}
+	if err != nil {
+		for i := range entries {
+			entries[i].Seq = 0
+		}
+		return nil, err
+	}
+	w.markDurable(w.segLast)
+
+	if err := w.commitSegment(ctx); err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// segmentReader yields the entries of one segment, withholding the entries
+// of an atomic batch until all of them are read. Batch and seal markers
+// are not returned.
+type segmentReader struct {
+	j       *Journal
+	r       *bufio.Reader
+	name    string        // segment name, bound into encrypted records
+	filter  *replayFilter // nil reads everything
+	seqs    *seqTracker   // optional, sees filtered out entries too
+	pending []pendingEntry
+	want    int
+	seal    *segmentSeal // set once the seal marker is read
+	format  Format
+	started bool // the header has been read
+}
+
+// readHeader reads the segment's header, when the reader starts at the
+// beginning of the segment.
+func (s *segmentReader) readHeader() error {
+	f, err := readSegmentHeader(s.r)
+	if err != nil {
+		return fmt.Errorf("segment %s: %w", s.name, err)
+	}
+	s.format, s.started = f, true
+	return nil
+}
+
+type pendingEntry struct {
+	e       *Entry
+	matched bool
+}
+
+func (s *segmentReader) next() (*Entry, error) {
+	for {
+		for s.want == 0 && len(s.pending) > 0 {
+			p := s.pending[0]
+			s.pending = s.pending[1:]
+			if s.seqs != nil {
+				s.seqs.seen(p.e.Seq)
+			}
+			if p.matched {
+				return p.e, nil
+			}
+		}
+
+		if !s.started {
+			if err := s.readHeader(); err != nil {
+				return nil, err
+			}
+		}
+		e, matched, err := s.j.readEntry(s.r, s.filter, s.name, s.format)
+		if err != nil {
+			if s.want > 0 && (err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF)) {
+				return nil, errTornBatch
+			}
+			return nil, err
+		}
+		if s.seal != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSealedSegment, s.name)
+		}
+
+		if e.Seq == 0 && bytes.Equal(e.Key, sealMarkerKey) {
+			seal, err := parseSeal(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			s.seal = &seal
+			continue
+		}
+
+		if e.Seq == 0 && bytes.Equal(e.Key, batchMarkerKey) {
+			if len(e.Value) != 4 {
+				return nil, ErrBadChecksum
+			}
+			s.want = int(binary.BigEndian.Uint32(e.Value))
+			continue
+		}
+		if s.want > 0 {
+			s.pending = append(s.pending, pendingEntry{e: e, matched: matched})
+			s.want--
+			continue
+		}
+		if s.seqs != nil {
+			s.seqs.seen(e.Seq)
+		}
+		if matched {
+			return e, nil
+		}
+	}
+}
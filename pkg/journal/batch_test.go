@@ -0,0 +1,153 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchOf(n int) []Entry {
+	entries := make([]Entry, n)
+	for i := range entries {
+		entries[i] = Entry{Key: []byte("k"), Value: []byte(fmt.Sprintf("value-%d", i))}
+	}
+	return entries
+}
+
+func replayAll(t *testing.T, w *Journal) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	require.NoError(t, w.Replay(func(e *Entry) error {
+		seqs = append(seqs, e.Seq)
+		return nil
+	}))
+	return seqs
+}
+
+func TestAtomicBatch(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		s := NewMemStorage()
+		w, err := New(s, 1024, WithAtomicBatches())
+		require.NoError(t, err)
+		defer w.Close()
+
+		seqs, err := w.WriteBatch(batchOf(3))
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2, 3}, seqs)
+		assert.Equal(t, []uint64{1, 2, 3}, replayAll(t, w), "durable without Sync")
+
+		seq, err := w.Write([]byte("k"), []byte("single"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(4), seq)
+	})
+
+	t.Run("rotates only between batches", func(t *testing.T) {
+		s := NewMemStorage()
+		w, err := New(s, 100, WithAtomicBatches())
+		require.NoError(t, err)
+
+		for range 5 {
+			_, err := w.WriteBatch(batchOf(10))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		segs := segmentNames(mustList(t, s))
+		assert.Len(t, segs, 5)
+		for _, name := range segs {
+			info, err := w.inspect(context.Background(), name)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(10), info.LastSeq-info.FirstSeq+1, name)
+		}
+	})
+
+	t.Run("torn batch is dropped on reopen", func(t *testing.T) {
+		s := NewMemStorage()
+		w, err := New(s, 1<<20, WithAtomicBatches())
+		require.NoError(t, err)
+		_, err = w.WriteBatch(batchOf(3))
+		require.NoError(t, err)
+		_, err = w.WriteBatch(batchOf(3))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		// crash halfway through writing the second batch
+		seg := s.files[segmentName(1)].data
+		seg.Truncate(seg.Len() - 20)
+
+		w, err = New(s, 1<<20, WithAtomicBatches())
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2, 3}, replayAll(t, w))
+
+		seqs, err := w.WriteBatch(batchOf(2))
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{4, 5}, seqs)
+		assert.NotEqual(t, segmentName(1), w.current, "no appends after the torn tail")
+		require.NoError(t, w.Close())
+
+		w, err = New(s, 1<<20, WithAtomicBatches(), WithChecksumVerification())
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Equal(t, []uint64{1, 2, 3, 4, 5}, replayAll(t, w))
+	})
+
+	t.Run("failed write returns no seqs", func(t *testing.T) {
+		s := &failingSync{MemStorage: NewMemStorage()}
+		w, err := New(s, 1<<20, WithAtomicBatches())
+		require.NoError(t, err)
+
+		s.fail = true
+		entries := batchOf(3)
+		seqs, err := w.WriteBatch(entries)
+		assert.Error(t, err)
+		assert.Nil(t, seqs)
+		assert.Zero(t, entries[0].Seq)
+
+		// the batch made it to the segment before the sync failed
+		s.fail = false
+		seqs, err = w.WriteBatch(batchOf(1))
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{4}, seqs, "sequence numbers on disk are not reused")
+		require.NoError(t, w.Close())
+
+		w, err = New(s, 1<<20, WithAtomicBatches())
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Equal(t, []uint64{1, 2, 3, 4}, replayAll(t, w))
+	})
+
+	t.Run("failed encode rolls back", func(t *testing.T) {
+		aes, err := NewAESGCMEncryptor(randomKey(t))
+		require.NoError(t, err)
+		enc := &failingEncryptor{Encryptor: aes, failKey: []byte("bad")}
+		w, err := New(NewMemStorage(), 1<<20, WithAtomicBatches(), WithEncryptor(enc))
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.WriteBatch([]Entry{{Key: []byte("a")}, {Key: []byte("bad")}})
+		require.Error(t, err)
+
+		seqs, err := w.WriteBatch(batchOf(1))
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1}, seqs, "sequence numbers are not burned")
+		_, err = w.Rotate()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), w.sealed[0].Entries)
+	})
+}
+
+type failingSync struct {
+	*MemStorage
+	fail bool
+}
+
+func (f *failingSync) Sync(ctx context.Context, name string) error {
+	if f.fail {
+		return errors.New("disk on fire")
+	}
+	return f.MemStorage.Sync(ctx, name)
+}
@@ -0,0 +1,103 @@
+package journal
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The benchmarks run on MemStorage, so they measure framing, checksums and
+// encryption rather than the disk.
+
+func benchJournal(b *testing.B, encrypted bool, checksum Checksum) *Journal {
+	b.Helper()
+	opts := []Option{WithChecksum(checksum)}
+	if encrypted {
+		enc, err := NewAESGCMEncryptor(make([]byte, 32))
+		if err != nil {
+			b.Fatal(err)
+		}
+		opts = append(opts, WithEncryptor(enc))
+	}
+	w, err := New(NewMemStorage(), 64<<20, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { w.Close() })
+	return w
+}
+
+var benchModes = []struct {
+	name      string
+	encrypted bool
+	checksum  Checksum
+}{{"plain", false, ChecksumCRC32C}, {"plain-ieee", false, ChecksumIEEE}, {"aes-gcm", true, ChecksumCRC32C}}
+
+// benchValue is about the size of a msgpack event.
+var benchValue = []byte(`{"idempotency_id":"0b1c","sensor":"temp-north","val":42,"ts":1717243200000}`)
+
+func BenchmarkWrite(b *testing.B) {
+	for _, m := range benchModes {
+		b.Run(m.name, func(b *testing.B) {
+			w := benchJournal(b, m.encrypted, m.checksum)
+			key := []byte("sensor_temp-north{ts=1717243200000}")
+			b.SetBytes(int64(len(key) + len(benchValue)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := w.Write(key, benchValue); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	for _, m := range benchModes {
+		for _, size := range []int{16, 256} {
+			b.Run(fmt.Sprintf("%s/%d", m.name, size), func(b *testing.B) {
+				w := benchJournal(b, m.encrypted, m.checksum)
+				entries := make([]Entry, size)
+				var n int
+				for i := range entries {
+					entries[i] = Entry{Key: fmt.Appendf(nil, "sensor_temp-north{ts=%d}", i), Value: benchValue}
+					n += len(entries[i].Key) + len(benchValue)
+				}
+				b.SetBytes(int64(n))
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := w.WriteBatch(entries); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkReplay(b *testing.B) {
+	const entries = 10000
+	for _, m := range benchModes {
+		b.Run(m.name, func(b *testing.B) {
+			w := benchJournal(b, m.encrypted, m.checksum)
+			for i := range entries {
+				if _, err := w.Write(fmt.Appendf(nil, "sensor_temp-north{ts=%d}", i), benchValue); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := w.Sync(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ReportMetric(entries, "entries/op")
+			for b.Loop() {
+				var n int
+				if err := w.Replay(func(*Entry) error { n++; return nil }); err != nil {
+					b.Fatal(err)
+				}
+				if n != entries {
+					b.Fatalf("replayed %d entries, want %d", n, entries)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package journal
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ErrorClass sorts the errors journal calls return by what a writer can
+// do about them.
+type ErrorClass int
+
+const (
+	// ClassTransient errors may well not happen again: I/O errors,
+	// timeouts and anything not recognized as one of the others.
+	ClassTransient ErrorClass = iota
+	// ClassDiskFull errors happen again until space is freed on the
+	// journal's filesystem.
+	ClassDiskFull
+	// ClassCorruption errors mean the journal's files are damaged or
+	// aren't what they claim to be, which takes an operator to sort out.
+	ClassCorruption
+	// ClassPermanent errors happen on every try: a read-only or
+	// inaccessible filesystem, a closed journal, an entry too large.
+	ClassPermanent
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassTransient:
+		return "transient"
+	case ClassDiskFull:
+		return "disk_full"
+	case ClassCorruption:
+		return "corruption"
+	case ClassPermanent:
+		return "permanent"
+	}
+	return "unknown"
+}
+
+// Classify tells which class err is in; nil is ClassTransient.
+func Classify(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ClassTransient
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return ClassDiskFull
+	case errors.Is(err, ErrBadChecksum),
+		errors.Is(err, ErrCorruptRecord),
+		errors.Is(err, ErrSealedSegment),
+		errors.Is(err, ErrSealMismatch),
+		errors.Is(err, ErrManifestMismatch),
+		errors.Is(err, ErrRecordAuth),
+		errors.Is(err, ErrRecordVersion),
+		errors.Is(err, ErrUnknownFormat),
+		errors.Is(err, ErrUnknownSegment):
+		return ClassCorruption
+	case errors.Is(err, syscall.EROFS),
+		errors.Is(err, syscall.EFBIG),
+		errors.Is(err, fs.ErrPermission),
+		errors.Is(err, os.ErrClosed),
+		errors.Is(err, ErrJournalClosed),
+		errors.Is(err, ErrRecordTooLarge),
+		errors.Is(err, ErrSegmentIDsExhausted):
+		return ClassPermanent
+	}
+	return ClassTransient
+}
@@ -0,0 +1,30 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want ErrorClass
+	}{
+		{errors.New("timeout"), ClassTransient},
+		{&fs.PathError{Op: "write", Path: "00000001.wal", Err: syscall.EIO}, ClassTransient},
+		{&fs.PathError{Op: "write", Path: "00000001.wal", Err: syscall.ENOSPC}, ClassDiskFull},
+		{fmt.Errorf("segment 00000001.wal: %w", ErrBadChecksum), ClassCorruption},
+		{fmt.Errorf("%w: length 12", ErrCorruptRecord), ClassCorruption},
+		{&fs.PathError{Op: "open", Path: "00000002.wal", Err: syscall.EROFS}, ClassPermanent},
+		{&fs.PathError{Op: "open", Path: "00000002.wal", Err: fs.ErrPermission}, ClassPermanent},
+		{ErrRecordTooLarge, ClassPermanent},
+	} {
+		assert.Equal(t, tc.want, Classify(tc.err), tc.err.Error())
+	}
+	assert.Equal(t, "disk_full", ClassDiskFull.String())
+}
@@ -0,0 +1,193 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Compacted segments are written next to the original under this suffix
+// and renamed over it once the manifest describes the new contents.
+const compactSuffix = ".compact"
+
+// Compact rewrites sealed segments that hold expired entries without them,
+// removing segments left with nothing. The active segment is not touched.
+// Returns the number of bytes reclaimed.
+func (w *Journal) Compact() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ctx := context.Background()
+	now := w.now()
+	var reclaimed int64
+	for i := 0; i < len(w.sealed); i++ {
+		info := w.sealed[i]
+
+		data, expired, err := w.compactSegment(ctx, info.Name, now)
+		if err != nil {
+			return reclaimed, err
+		}
+		if expired == 0 {
+			continue
+		}
+
+		// the newest sealed segment is kept even when empty: its LastSeq
+		// is what a reopen resumes numbering from
+		if len(data) == 0 && i < len(w.sealed)-1 {
+			tomb := info
+			tomb.Removed = true
+			if err := w.writeManifest(ctx, tomb); err != nil {
+				return reclaimed, err
+			}
+			w.sealed = slices.Delete(w.sealed, i, i+1)
+			w.removed = append(w.removed, info)
+			i--
+
+			if err := w.storage.Remove(ctx, info.Name); err != nil {
+				return reclaimed, err
+			}
+		} else {
+			// the sequence range stays as sealed, so TruncateBefore and
+			// reopen see the same history
+			compacted := info
+			compacted.Size = int64(len(data))
+			compacted.Checksum = segmentChecksum(data)
+			compacted.Compacted = true
+			if !compacted.SealedAt.IsZero() {
+				compacted.Entries -= uint32(expired)
+			}
+			if err := w.replaceSegment(ctx, compacted, data); err != nil {
+				return reclaimed, err
+			}
+			w.sealed[i] = compacted
+		}
+
+		reclaimed += info.Size - int64(len(data))
+		compactedSegments.Inc()
+		expiredEntries.Add(expired)
+		reclaimedBytes.AddInt64(info.Size - int64(len(data)))
+	}
+
+	return reclaimed, nil
+}
+
+// compactSegment returns the records of a segment still live at now and
+// how many expired ones were left out. Batch markers are dropped: a sealed
+// segment's batches are complete, and a torn tail is dropped with them.
+// Unless nothing is left, the result is sealed anew.
+func (w *Journal) compactSegment(ctx context.Context, name string, now time.Time) ([]byte, int, error) {
+	rc, err := w.storage.Open(ctx, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	expired := 0
+	var seal segmentSeal
+	r := &segmentReader{j: w, r: bufio.NewReader(rc), name: name}
+	if err := r.readHeader(); err != nil {
+		return nil, 0, err
+	}
+	// the result keeps the segment's format
+	buf.Write(segmentHeader(r.format))
+	for {
+		e, err := r.next()
+		if err == io.EOF || err == errTornBatch {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if !e.Expires.IsZero() && !now.Before(e.Expires) {
+			expired++
+			continue
+		}
+		rec, err := w.encode(e, name, r.format)
+		if err != nil {
+			return nil, 0, err
+		}
+		buf.Write(rec)
+		seal.lastSeq = e.Seq
+		seal.count++
+	}
+	if seal.count == 0 {
+		// a header alone would be an empty segment
+		return nil, expired, nil
+	}
+
+	seal.checksum = crc32.Checksum(buf.Bytes(), r.format.crcTable())
+	rec, err := w.encode(&Entry{Key: sealMarkerKey, Value: seal.marshal()}, name, r.format)
+	if err != nil {
+		return nil, 0, err
+	}
+	buf.Write(rec)
+	return buf.Bytes(), expired, nil
+}
+
+// segmentChecksum is the checksum of a whole segment, with the polynomial
+// its header names.
+func segmentChecksum(data []byte) uint32 {
+	f, _, _ := peekSegmentHeader(bufio.NewReader(bytes.NewReader(data)))
+	return crc32.Checksum(data, f.crcTable())
+}
+
+// replaceSegment writes data under the compaction name, records info in
+// the manifest and renames the result into place. A crash in between is
+// sorted out by recoverCompacted.
+func (w *Journal) replaceSegment(ctx context.Context, info SegmentInfo, data []byte) error {
+	tmp := info.Name + compactSuffix
+	wc, err := w.storage.Create(ctx, tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(data); err != nil {
+		_ = wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	if err := w.storage.Sync(ctx, tmp); err != nil {
+		return err
+	}
+	if err := w.writeManifest(ctx, info); err != nil {
+		return err
+	}
+	return w.storage.Rename(ctx, tmp, info.Name)
+}
+
+// recoverCompacted finishes or discards compactions interrupted by a
+// crash. A compacted file whose size matches the manifest made it that
+// far and is renamed into place; any other is removed.
+func (w *Journal) recoverCompacted(ctx context.Context, names []string, sealed []SegmentInfo) error {
+	for _, name := range names {
+		seg, ok := strings.CutSuffix(name, compactSuffix)
+		if !ok {
+			continue
+		}
+
+		i := slices.IndexFunc(sealed, func(s SegmentInfo) bool { return s.Name == seg })
+		if i >= 0 {
+			size, err := w.storage.Size(ctx, name)
+			if err != nil {
+				return err
+			}
+			if size == sealed[i].Size {
+				if err := w.storage.Rename(ctx, name, seg); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := w.storage.Remove(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
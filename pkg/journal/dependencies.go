@@ -1,6 +1,8 @@
 package journal
 
+// Encryptor seals records at rest. aad is authenticated but not encrypted;
+// Decrypt must fail unless it gets the aad the record was sealed with.
 type Encryptor interface {
-	Encrypt(plaintext []byte) ([]byte, error)
-	Decrypt(ciphertext []byte) ([]byte, error)
+	Encrypt(plaintext, aad []byte) ([]byte, error)
+	Decrypt(ciphertext, aad []byte) ([]byte, error)
 }
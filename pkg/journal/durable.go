@@ -0,0 +1,35 @@
+package journal
+
+// DurableSeq is the highest sequence number known to be fsynced: the
+// entries up to it survive a power cut, while those after it may only be
+// in the page cache. Every successful fsync advances it, whether from
+// Sync, an atomic batch, a segment's commit or its rotation. A reopened
+// journal starts from the end of its sealed segments, as nothing says the
+// active one was synced before the restart.
+func (w *Journal) DurableSeq() uint64 {
+	return w.durable.Load()
+}
+
+// markDurable advances DurableSeq to seq, unless it's past it already.
+func (w *Journal) markDurable(seq uint64) {
+	for {
+		cur := w.durable.Load()
+		if seq <= cur || w.durable.CompareAndSwap(cur, seq) {
+			return
+		}
+	}
+}
+
+// durableSeqer is a Writer that tracks its DurableSeq.
+type durableSeqer interface {
+	DurableSeq() uint64
+}
+
+// DurableSeq is the primary's DurableSeq, in the numbering WriteBatch
+// returns, or 0 if the primary doesn't track one.
+func (m *MultiWriter) DurableSeq() uint64 {
+	if d, ok := m.writers[0].(durableSeqer); ok {
+		return d.DurableSeq()
+	}
+	return 0
+}
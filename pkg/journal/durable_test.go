@@ -0,0 +1,75 @@
+package journal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurableSeq(t *testing.T) {
+	t.Run("advances with fsyncs", func(t *testing.T) {
+		s := NewMemStorage()
+		w, err := New(s, 1<<20)
+		require.NoError(t, err)
+
+		// the first entry commits the segment, with an fsync
+		_, err = w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), w.DurableSeq())
+
+		for range 3 {
+			_, err := w.Write([]byte("k"), []byte("v"))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, uint64(1), w.DurableSeq(), "written, not synced")
+
+		require.NoError(t, w.Sync())
+		assert.Equal(t, uint64(4), w.DurableSeq())
+
+		_, err = w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		_, err = w.Rotate()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(5), w.DurableSeq(), "rotation seals with an fsync")
+
+		_, err = w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		// nothing says the active segment made it to disk before a restart
+		w, err = New(s, 1<<20)
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Equal(t, uint64(5), w.DurableSeq())
+		require.NoError(t, w.Sync())
+		assert.Equal(t, uint64(7), w.DurableSeq())
+	})
+
+	t.Run("atomic batches are durable when written", func(t *testing.T) {
+		w, err := New(NewMemStorage(), 1<<20, WithAtomicBatches())
+		require.NoError(t, err)
+		defer w.Close()
+		seqs, err := w.WriteBatch([]Entry{{Key: []byte("a")}, {Key: []byte("b")}})
+		require.NoError(t, err)
+		assert.Equal(t, seqs[1], w.DurableSeq())
+	})
+
+	t.Run("multi writer follows the primary", func(t *testing.T) {
+		primary, err := New(NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		replica, err := New(NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		m := NewMultiWriter(primary, []Writer{replica})
+		defer m.Close()
+
+		for range 3 {
+			_, err := m.Write([]byte("k"), []byte("v"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, m.Sync())
+		assert.Equal(t, uint64(3), m.DurableSeq())
+	})
+}
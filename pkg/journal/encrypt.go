@@ -28,21 +28,21 @@ func NewAESGCMEncryptor(key []byte) (*AESGCMEncryptor, error) {
 	return &AESGCMEncryptor{aead: aead}, nil
 }
 
-func (e *AESGCMEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+func (e *AESGCMEncryptor) Encrypt(plaintext, aad []byte) ([]byte, error) {
 	nonce := make([]byte, e.aead.NonceSize())
 	if _, err := rand.Read(nonce); err != nil {
 		return nil, err
 	}
 
-	return e.aead.Seal(nonce, nonce, plaintext, nil), nil
+	return e.aead.Seal(nonce, nonce, plaintext, aad), nil
 }
 
-func (e *AESGCMEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+func (e *AESGCMEncryptor) Decrypt(ciphertext, aad []byte) ([]byte, error) {
 	nonceSize := e.aead.NonceSize()
 	if len(ciphertext) < nonceSize {
 		return nil, ErrCiphertextShort
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return e.aead.Open(nil, nonce, ciphertext, nil)
+	return e.aead.Open(nil, nonce, ciphertext, aad)
 }
@@ -2,6 +2,8 @@ package journal
 
 import (
 	"crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,11 +22,11 @@ func TestEncryptDecrypt(t *testing.T) {
 	require.NoError(t, err)
 
 	plaintext := []byte("never gonna give you up!")
-	ciphertext, err := enc.Encrypt(plaintext)
+	ciphertext, err := enc.Encrypt(plaintext, nil)
 	require.NoError(t, err)
 	assert.NotEqual(t, plaintext, ciphertext)
 
-	decrypted, err := enc.Decrypt(ciphertext)
+	decrypted, err := enc.Decrypt(ciphertext, nil)
 	require.NoError(t, err)
 	assert.Equal(t, plaintext, decrypted)
 }
@@ -39,10 +41,10 @@ func TestEncryptDecryptVariousSizes(t *testing.T) {
 			rand.Read(plaintext)
 		}
 
-		ciphertext, err := enc.Encrypt(plaintext)
+		ciphertext, err := enc.Encrypt(plaintext, nil)
 		require.NoError(t, err)
 
-		decrypted, err := enc.Decrypt(ciphertext)
+		decrypted, err := enc.Decrypt(ciphertext, nil)
 		require.NoError(t, err)
 		assert.Len(t, decrypted, size)
 		if size > 0 {
@@ -67,7 +69,7 @@ func TestUniqueNonces(t *testing.T) {
 
 	seen := make(map[string]struct{})
 	for i := 0; i < 100; i++ {
-		ct, _ := enc.Encrypt([]byte("same"))
+		ct, _ := enc.Encrypt([]byte("same"), nil)
 		nonce := string(ct[:12])
 		assert.NotContains(t, seen, nonce, "duplicate nonce")
 		seen[nonce] = struct{}{}
@@ -78,7 +80,7 @@ func TestDecryptTooShort(t *testing.T) {
 	enc, err := NewAESGCMEncryptor(randomKey(t))
 	require.NoError(t, err)
 
-	_, err = enc.Decrypt([]byte("short"))
+	_, err = enc.Decrypt([]byte("short"), nil)
 	assert.ErrorIs(t, err, ErrCiphertextShort)
 }
 
@@ -86,9 +88,96 @@ func TestDecryptTampered(t *testing.T) {
 	enc, err := NewAESGCMEncryptor(randomKey(t))
 	require.NoError(t, err)
 
-	ct, _ := enc.Encrypt([]byte("secret"))
+	ct, _ := enc.Encrypt([]byte("secret"), nil)
 	ct[len(ct)-1] ^= 0xff
 
-	_, err = enc.Decrypt(ct)
+	_, err = enc.Decrypt(ct, nil)
 	assert.Error(t, err)
 }
+
+func TestDecryptWrongAAD(t *testing.T) {
+	enc, err := NewAESGCMEncryptor(randomKey(t))
+	require.NoError(t, err)
+
+	ct, err := enc.Encrypt([]byte("secret"), []byte("here"))
+	require.NoError(t, err)
+
+	_, err = enc.Decrypt(ct, []byte("there"))
+	assert.Error(t, err)
+	pt, err := enc.Decrypt(ct, []byte("here"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), pt)
+}
+
+func encryptedJournal(t *testing.T, s *MemStorage, key []byte, opts ...Option) *Journal {
+	t.Helper()
+	enc, err := NewAESGCMEncryptor(key)
+	require.NoError(t, err)
+	w, err := New(s, 1<<20, append([]Option{WithEncryptor(enc)}, opts...)...)
+	require.NoError(t, err)
+	return w
+}
+
+func TestEncryptedRecordBinding(t *testing.T) {
+	key := randomKey(t)
+
+	t.Run("renumbered record", func(t *testing.T) {
+		s := NewMemStorage()
+		w := encryptedJournal(t, s, key)
+		defer w.Close()
+		_, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+		assert.Equal(t, []uint64{1}, replayAll(t, w))
+
+		// pass the record off as seq 7, with a checksum to match
+		b := s.files[w.current].data.Bytes()[len(segmentHeader(w.segFormat)):]
+		binary.BigEndian.PutUint64(b[9:], 7)
+		binary.BigEndian.PutUint32(b[4:], crc32.Checksum(b[8:], w.segFormat.crcTable()))
+
+		err = w.Replay(func(*Entry) error { return nil })
+		assert.ErrorIs(t, err, ErrRecordAuth)
+	})
+
+	t.Run("record from another segment", func(t *testing.T) {
+		s := NewMemStorage()
+		w := encryptedJournal(t, s, key)
+		defer w.Close()
+		_, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+
+		rec, err := w.encode(&Entry{Seq: 2, Key: []byte("k"), Value: []byte("v")}, "000042.wal", w.segFormat)
+		require.NoError(t, err)
+		s.files[w.current].data.Write(rec)
+
+		err = w.Replay(func(*Entry) error { return nil })
+		assert.ErrorIs(t, err, ErrRecordAuth)
+	})
+
+	t.Run("records from before versioning stay readable", func(t *testing.T) {
+		// written before CRC32C as well
+		s := NewMemStorage()
+		w := encryptedJournal(t, s, key, WithChecksum(ChecksumIEEE))
+		defer w.Close()
+		_, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+
+		// the pre-versioning format: the whole body sealed without aad
+		plain, err := (&Journal{}).encode(&Entry{Seq: 2, Key: []byte("old"), Value: []byte("v")}, "", FormatBinary)
+		require.NoError(t, err)
+		sealed, err := w.encryptor.Encrypt(plain[8:], nil)
+		require.NoError(t, err)
+		frame := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+		frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(sealed))
+		s.files[w.current].data.Write(append(frame, sealed...))
+
+		var keys []string
+		require.NoError(t, w.Replay(func(e *Entry) error {
+			keys = append(keys, string(e.Key))
+			return nil
+		}))
+		assert.Equal(t, []string{"k", "old"}, keys)
+	})
+}
@@ -6,4 +6,32 @@ var (
 	ErrBadChecksum      = errors.New("bad checksum")
 	ErrInvalidKeySize   = errors.New("key must be 32 bytes")
 	ErrCiphertextShort  = errors.New("ciphertext too short")
+	ErrLocked           = errors.New("journal directory is locked")
+	ErrManifestMismatch = errors.New("segments do not match manifest")
+	// ErrRecordAuth means an encrypted record failed to authenticate: the
+	// key is wrong, or it was tampered with or moved from elsewhere.
+	ErrRecordAuth    = errors.New("encrypted record failed authentication")
+	ErrRecordVersion = errors.New("unsupported record version")
+	// ErrSealedSegment means records follow a segment's seal marker, so
+	// something appended to it after it was rotated out.
+	ErrSealedSegment = errors.New("records after segment seal")
+	ErrSealMismatch  = errors.New("segment does not match its seal")
+	// ErrCorruptRecord means a record's lengths don't fit its frame: the
+	// segment is damaged in a way its checksum didn't catch, or it isn't
+	// a segment.
+	ErrCorruptRecord  = errors.New("corrupt record")
+	ErrRecordTooLarge = errors.New("record too large")
+	// ErrUnknownSegment means a file in the journal is named like a
+	// segment but isn't numbered like one, say a copy kept by hand.
+	ErrUnknownSegment      = errors.New("unrecognized segment file")
+	ErrSegmentIDsExhausted = errors.New("segment IDs exhausted")
+	// ErrUnknownFormat means a segment's header names a record format
+	// this version can't read, or a format name isn't known.
+	ErrUnknownFormat = errors.New("unknown journal format")
+	// ErrUnknownChecksum means a checksum name isn't known.
+	ErrUnknownChecksum = errors.New("unknown journal checksum")
+	// ErrJournalClosed is what Tail returns once the journal is closed.
+	ErrJournalClosed = errors.New("journal closed")
+	// ErrNotFound means Lookup found no entry with the sequence number.
+	ErrNotFound = errors.New("entry not found")
 )
@@ -0,0 +1,198 @@
+package journal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func replayedSeqs(t *testing.T, w *Journal) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	require.NoError(t, w.Replay(func(e *Entry) error {
+		seqs = append(seqs, e.Seq)
+		return nil
+	}))
+	return seqs
+}
+
+func TestEntryExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	w, err := New(NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	defer w.Close()
+	w.now = func() time.Time { return now }
+
+	_, err = w.Write([]byte("forever"), []byte("v"))
+	require.NoError(t, err)
+	_, err = w.WriteWithExpiry([]byte("short"), []byte("v"), now.Add(time.Minute))
+	require.NoError(t, err)
+	_, err = w.WriteBatch([]Entry{
+		{Key: []byte("batched"), Value: []byte("v"), Expires: now.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, w.Sync())
+
+	var got []*Entry
+	require.NoError(t, w.Replay(func(e *Entry) error {
+		got = append(got, e)
+		return nil
+	}))
+	require.Len(t, got, 3)
+	assert.True(t, got[0].Expires.IsZero())
+	assert.True(t, got[1].Expires.Equal(now.Add(time.Minute)))
+	assert.Equal(t, []byte("short"), got[1].Key, "key survives the expiry field")
+	assert.Equal(t, []byte("v"), got[1].Value)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, []uint64{1, 3}, replayedSeqs(t, w))
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, []uint64{1}, replayedSeqs(t, w))
+
+	seq, err := w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq, "expired entries still count for numbering")
+}
+
+func TestCompact(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewMemStorage()
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+
+	// odd entries expire, and a run in the middle fills whole segments
+	for i := range 30 {
+		expires := time.Time{}
+		if i%2 == 1 || (i >= 10 && i < 20) {
+			expires = now.Add(time.Minute)
+		}
+		_, err := w.WriteWithExpiry([]byte("never"), []byte("gonna give you up"), expires)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Sync())
+
+	reclaimed, err := w.Compact()
+	require.NoError(t, err)
+	assert.Zero(t, reclaimed, "nothing expired yet")
+
+	before := segmentNames(mustList(t, s))
+	var sizeBefore int64
+	for _, name := range before {
+		n, _ := s.Size(context.Background(), name)
+		sizeBefore += n
+	}
+	live := replayedSeqs(t, w)
+
+	now = now.Add(time.Minute)
+	want := make([]uint64, 0, len(live))
+	for _, seq := range live {
+		i := seq - 1
+		if i%2 == 0 && (i < 10 || i >= 20) {
+			want = append(want, seq)
+		}
+	}
+	require.Equal(t, want, replayedSeqs(t, w), "replay already hides expired entries")
+
+	reclaimed, err = w.Compact()
+	require.NoError(t, err)
+	assert.Positive(t, reclaimed)
+
+	after := segmentNames(mustList(t, s))
+	var sizeAfter int64
+	for _, name := range after {
+		n, _ := s.Size(context.Background(), name)
+		sizeAfter += n
+	}
+	assert.Less(t, len(after), len(before), "fully expired segments are removed")
+	assert.Equal(t, sizeBefore-reclaimed, sizeAfter)
+
+	// with expiry out of the picture, sealed segments only hold what was
+	// live; the active one is left alone
+	now = time.Time{}
+	lastSealed := w.sealed[len(w.sealed)-1].LastSeq
+	var sealed []uint64
+	for _, seq := range replayedSeqs(t, w) {
+		if seq <= lastSealed {
+			sealed = append(sealed, seq)
+		}
+	}
+	var wantSealed []uint64
+	for _, seq := range want {
+		if seq <= lastSealed {
+			wantSealed = append(wantSealed, seq)
+		}
+	}
+	assert.Equal(t, wantSealed, sealed)
+	require.NoError(t, w.Close())
+
+	w, err = New(s, 100, WithChecksumVerification())
+	require.NoError(t, err, "manifest describes the compacted segments")
+	defer w.Close()
+	assert.Equal(t, want, replayedSeqs(t, w))
+
+	seq, err := w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(31), seq)
+}
+
+func TestCompactCrashRecovery(t *testing.T) {
+	setup := func(t *testing.T) (*MemStorage, SegmentInfo, []byte) {
+		t.Helper()
+		now := time.Now()
+		s := NewMemStorage()
+		w, err := New(s, 100)
+		require.NoError(t, err)
+		for i := range 20 {
+			expires := time.Time{}
+			if i%2 == 1 {
+				expires = now.Add(time.Hour)
+			}
+			_, err := w.WriteWithExpiry([]byte("never"), []byte("gonna give you up"), expires)
+			require.NoError(t, err)
+		}
+		info := w.sealed[0]
+		data, expired, err := w.compactSegment(context.Background(), info.Name, now.Add(2*time.Hour))
+		require.NoError(t, err)
+		require.Positive(t, expired)
+		require.NoError(t, w.Close())
+
+		info.Size = int64(len(data))
+		return s, info, data
+	}
+
+	t.Run("before the manifest record", func(t *testing.T) {
+		s, info, data := setup(t)
+		wc, err := s.Create(context.Background(), info.Name+compactSuffix)
+		require.NoError(t, err)
+		_, _ = wc.Write(data)
+		require.NoError(t, wc.Close())
+
+		w, err := New(s, 100)
+		require.NoError(t, err)
+		defer w.Close()
+		assert.NotContains(t, mustList(t, s), info.Name+compactSuffix)
+		assert.Len(t, replayedSeqs(t, w), 20, "original segment kept")
+	})
+
+	t.Run("before the rename", func(t *testing.T) {
+		s, info, data := setup(t)
+		wc, err := s.Create(context.Background(), info.Name+compactSuffix)
+		require.NoError(t, err)
+		_, _ = wc.Write(data)
+		require.NoError(t, wc.Close())
+		s.files[manifestName].data.WriteString(mustJSONLine(t, info))
+
+		w, err := New(s, 100)
+		require.NoError(t, err)
+		defer w.Close()
+		assert.NotContains(t, mustList(t, s), info.Name+compactSuffix)
+		size, err := s.Size(context.Background(), info.Name)
+		require.NoError(t, err)
+		assert.Equal(t, info.Size, size)
+	})
+}
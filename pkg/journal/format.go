@@ -0,0 +1,299 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Format is how the data of a segment's records is laid out. It's chosen
+// per segment: a segment in any format but FormatBinary with IEEE
+// checksums starts with segmentMagic and the format byte, and readers go
+// by that, so a journal can hold segments of both and the format can be
+// switched at any time. The frame around each record's data, its length,
+// CRC32 and encryption, is the same in every format.
+type Format byte
+
+const (
+	// FormatBinary is the journal's own layout: big-endian sequence
+	// number, key and value lengths, and the expiry flagged in the key
+	// length. With IEEE checksums its segments have no header, like those
+	// written before formats existed.
+	FormatBinary Format = 0
+	// FormatProtobuf stores each record's data as a Record message of
+	// record.proto, for consumers that read segments with generated code
+	// instead of this package.
+	FormatProtobuf Format = 1
+)
+
+func (f Format) String() string {
+	if f&formatCRC32C != 0 {
+		return f.layout().String() + "+crc32c"
+	}
+	switch f {
+	case FormatBinary:
+		return "binary"
+	case FormatProtobuf:
+		return "protobuf"
+	}
+	return fmt.Sprintf("format(%d)", byte(f))
+}
+
+// ParseFormat returns the format named by Format.String.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "binary":
+		return FormatBinary, nil
+	case "protobuf":
+		return FormatProtobuf, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// Checksum is the CRC32 polynomial a segment's checksums use: those of
+// its records, its seal and its manifest record. Like the format it's
+// chosen per segment and read from the segment's header.
+type Checksum byte
+
+const (
+	// ChecksumCRC32C is the Castagnoli polynomial, which hash/crc32
+	// computes with the SSE4.2 and ARMv8 CRC32 instructions, several
+	// times faster than IEEE on the short records of the write path.
+	ChecksumCRC32C Checksum = 0
+	// ChecksumIEEE is the polynomial of segments written before CRC32C,
+	// for readers that can't take the header it needs.
+	ChecksumIEEE Checksum = 1
+)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumIEEE:
+		return "ieee"
+	}
+	return fmt.Sprintf("checksum(%d)", byte(c))
+}
+
+// ParseChecksum returns the checksum named by Checksum.String.
+func ParseChecksum(name string) (Checksum, error) {
+	switch name {
+	case "crc32c":
+		return ChecksumCRC32C, nil
+	case "ieee":
+		return ChecksumIEEE, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownChecksum, name)
+}
+
+// WithChecksum checksums new segments with c, CRC32C by default. The
+// active segment of a reopened journal keeps the checksum it was started
+// with.
+func WithChecksum(c Checksum) Option {
+	return func(j *Journal) {
+		j.checksum = c
+	}
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// formatCRC32C flags a CRC32C segment in the format byte of its header.
+// Formats only take the low bits, so the format and the checksum share
+// the byte, and a segment in FormatBinary gets a header for it.
+const formatCRC32C Format = 0x80
+
+// withChecksum is f flagged for c, as a segment's header records it.
+func (f Format) withChecksum(c Checksum) Format {
+	if c == ChecksumCRC32C {
+		return f | formatCRC32C
+	}
+	return f
+}
+
+// layout is f without its checksum flag.
+func (f Format) layout() Format {
+	return f &^ formatCRC32C
+}
+
+// crcTable is the table of the checksum f is flagged for.
+func (f Format) crcTable() *crc32.Table {
+	if f&formatCRC32C != 0 {
+		return castagnoliTable
+	}
+	return crc32.IEEETable
+}
+
+// segmentMagic opens the header of a segment with a format byte. A frame
+// length starts with 0x00-0x3f, or 0x80-0xbf with the versioned flag, so
+// a segment starting with it can't be an older headerless one.
+const segmentMagic = 0xc0
+
+// WithFormat writes new segments in format. The active segment of a
+// reopened journal is appended to in the format it was started with.
+func WithFormat(f Format) Option {
+	return func(j *Journal) {
+		j.format = f
+	}
+}
+
+// segmentHeader is what a segment in format, flagged with its checksum,
+// starts with.
+func segmentHeader(f Format) []byte {
+	if f == FormatBinary {
+		return nil
+	}
+	return []byte{segmentMagic, byte(f)}
+}
+
+// readSegmentHeader reads the header of a segment, if it has one, and
+// returns its format. An empty segment is FormatBinary, and reading on
+// hits its end.
+func readSegmentHeader(r *bufio.Reader) (Format, error) {
+	f, n, err := peekSegmentHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = r.Discard(n)
+	return f, nil
+}
+
+// peekSegmentHeader returns the format of the segment r starts at and the
+// length of its header, without reading past it.
+func peekSegmentHeader(r *bufio.Reader) (Format, int, error) {
+	b, err := r.Peek(1)
+	if err != nil || b[0] != segmentMagic {
+		return FormatBinary, 0, nil
+	}
+	if b, err = r.Peek(2); err != nil {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	f := Format(b[1])
+	switch f {
+	case FormatProtobuf, FormatBinary | formatCRC32C, FormatProtobuf | formatCRC32C:
+	default:
+		return 0, 0, fmt.Errorf("%w: %s", ErrUnknownFormat, f)
+	}
+	return f, 2, nil
+}
+
+// Field numbers and wire types of the Record message in record.proto.
+const (
+	recordFieldSeq     = 1
+	recordFieldKey     = 2
+	recordFieldValue   = 3
+	recordFieldExpires = 4
+
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// marshalRecord encodes e as a Record message. Like proto3 it leaves out
+// fields at their zero value, such as the sequence number of markers.
+func marshalRecord(e *Entry) []byte {
+	b := make([]byte, 0, 1+binary.MaxVarintLen64+2*(1+binary.MaxVarintLen32)+len(e.Key)+len(e.Value)+1+8)
+	if e.Seq != 0 {
+		b = binary.AppendUvarint(b, recordFieldSeq<<3|wireVarint)
+		b = binary.AppendUvarint(b, e.Seq)
+	}
+	if len(e.Key) > 0 {
+		b = binary.AppendUvarint(b, recordFieldKey<<3|wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(e.Key)))
+		b = append(b, e.Key...)
+	}
+	if len(e.Value) > 0 {
+		b = binary.AppendUvarint(b, recordFieldValue<<3|wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(e.Value)))
+		b = append(b, e.Value...)
+	}
+	if !e.Expires.IsZero() {
+		b = binary.AppendUvarint(b, recordFieldExpires<<3|wireFixed64)
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.Expires.UnixNano()))
+	}
+	return b
+}
+
+// unmarshalRecord decodes a Record message the way generated code would:
+// fields in any order, the last of a repeated one winning, and unknown
+// ones skipped, so fields added later don't break older readers. Key and
+// value are copied out of data.
+func unmarshalRecord(data []byte) (*Entry, error) {
+	e := &Entry{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 || tag>>3 == 0 {
+			return nil, fmt.Errorf("%w: bad field tag", ErrCorruptRecord)
+		}
+		data = data[n:]
+
+		field, wire := tag>>3, tag&7
+		var v uint64
+		var bytes []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return nil, fmt.Errorf("%w: field %d", ErrCorruptRecord, field)
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("%w: field %d", ErrCorruptRecord, field)
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return nil, fmt.Errorf("%w: field %d", ErrCorruptRecord, field)
+			}
+			bytes, data = data[n:n+int(l)], data[n+int(l):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("%w: field %d", ErrCorruptRecord, field)
+			}
+			data = data[4:]
+		default:
+			return nil, fmt.Errorf("%w: field %d has wire type %d", ErrCorruptRecord, field, wire)
+		}
+
+		switch {
+		case field == recordFieldSeq && wire == wireVarint:
+			e.Seq = v
+		case field == recordFieldKey && wire == wireBytes:
+			e.Key = append([]byte(nil), bytes...)
+		case field == recordFieldValue && wire == wireBytes:
+			e.Value = append([]byte(nil), bytes...)
+		case field == recordFieldExpires && wire == wireFixed64:
+			e.Expires = time.Unix(0, int64(v))
+		case field <= recordFieldExpires:
+			return nil, fmt.Errorf("%w: field %d has wire type %d", ErrCorruptRecord, field, wire)
+		}
+	}
+	if e.Key == nil {
+		e.Key = []byte{}
+	}
+	if e.Value == nil {
+		e.Value = []byte{}
+	}
+	return e, nil
+}
+
+// recordSeq is the sequence number at the start of a record's data,
+// without decoding the rest.
+func recordSeq(data []byte, f Format) (uint64, bool) {
+	if f.layout() == FormatBinary {
+		if len(data) < 8 {
+			return 0, false
+		}
+		return binary.BigEndian.Uint64(data), true
+	}
+	if len(data) == 0 || data[0] != recordFieldSeq<<3|wireVarint {
+		// proto3 leaves out a zero sequence number, as on markers
+		return 0, true
+	}
+	seq, n := binary.Uvarint(data[1:])
+	return seq, n > 0
+}
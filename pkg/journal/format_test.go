@@ -0,0 +1,281 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func segmentStart(t *testing.T, s Storage, name string) []byte {
+	t.Helper()
+	rc, err := s.Open(context.Background(), name)
+	require.NoError(t, err)
+	defer rc.Close()
+	b := make([]byte, 2)
+	_, err = io.ReadFull(rc, b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestFormatProtobuf(t *testing.T) {
+	t.Run("segments start with the format and read back", func(t *testing.T) {
+		s := NewMemStorage()
+		w, err := New(s, 100, WithFormat(FormatProtobuf))
+		require.NoError(t, err)
+		exp := time.Now().Add(time.Hour).Truncate(0)
+		for i := range 20 {
+			_, err := w.WriteWithExpiry([]byte("k"), []byte{byte(i)}, exp)
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		segs := segmentFiles(t, s)
+		require.Greater(t, len(segs), 1)
+		for _, name := range segs {
+			assert.Equal(t, []byte{segmentMagic, byte(FormatProtobuf | formatCRC32C)}, segmentStart(t, s, name), name)
+		}
+
+		w, err = New(s, 100, WithFormat(FormatProtobuf), WithChecksumVerification())
+		require.NoError(t, err)
+		defer w.Close()
+		var got []*Entry
+		require.NoError(t, w.Replay(func(e *Entry) error {
+			got = append(got, e)
+			return nil
+		}))
+		require.Len(t, got, 20)
+		for i, e := range got {
+			assert.Equal(t, uint64(i+1), e.Seq)
+			assert.Equal(t, []byte("k"), e.Key)
+			assert.Equal(t, []byte{byte(i)}, e.Value)
+			assert.True(t, exp.Equal(e.Expires))
+		}
+
+		var paged []uint64
+		c, more, err := w.Page(Cursor{}, nil, 7, func(e *Entry) error {
+			paged = append(paged, e.Seq)
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, more)
+		_, _, err = w.Page(c, nil, 7, func(e *Entry) error {
+			paged = append(paged, e.Seq)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}, paged)
+
+		e, err := w.Lookup(17)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{16}, e.Value)
+	})
+
+	t.Run("atomic batches and encryption", func(t *testing.T) {
+		enc, err := NewAESGCMEncryptor(bytes.Repeat([]byte{1}, 32))
+		require.NoError(t, err)
+		s := NewMemStorage()
+		opts := []Option{WithFormat(FormatProtobuf), WithEncryptor(enc), WithAtomicBatches()}
+		w, err := New(s, 1<<20, opts...)
+		require.NoError(t, err)
+		_, err = w.WriteBatch([]Entry{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("2")}})
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		w, err = New(s, 1<<20, opts...)
+		require.NoError(t, err)
+		defer w.Close()
+		_, err = w.Write([]byte("c"), []byte("3"))
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+		assert.Equal(t, []uint64{1, 2, 3}, replayedSeqs(t, w))
+	})
+
+	t.Run("formats mix across segments", func(t *testing.T) {
+		s := NewMemStorage()
+		w, err := New(s, 100, WithChecksum(ChecksumIEEE))
+		require.NoError(t, err)
+		for range 10 {
+			_, err := w.Write([]byte("never"), []byte("gonna give you up"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		w, err = New(s, 100, WithFormat(FormatProtobuf))
+		require.NoError(t, err)
+		for range 10 {
+			_, err := w.Write([]byte("never"), []byte("gonna let you down"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		segs := segmentFiles(t, s)
+		slices.Sort(segs)
+		assert.NotEqual(t, byte(segmentMagic), segmentStart(t, s, segs[0])[0])
+		assert.Equal(t, byte(segmentMagic), segmentStart(t, s, segs[len(segs)-1])[0])
+
+		w, err = New(s, 100)
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Len(t, replayedSeqs(t, w), 20)
+	})
+
+	t.Run("compaction keeps the format", func(t *testing.T) {
+		s := NewMemStorage()
+		now := time.Unix(1_700_000_000, 0)
+		w, err := New(s, 100, WithFormat(FormatProtobuf))
+		require.NoError(t, err)
+		w.now = func() time.Time { return now }
+		for i := range 20 {
+			exp := time.Time{}
+			if i%2 == 0 {
+				exp = now.Add(time.Minute)
+			}
+			_, err := w.WriteWithExpiry([]byte("k"), []byte("v"), exp)
+			require.NoError(t, err)
+		}
+		now = now.Add(time.Hour)
+		_, err = w.Compact()
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+		assert.Len(t, replayedSeqs(t, w), 10)
+		require.NoError(t, w.Close())
+
+		for _, name := range segmentFiles(t, s) {
+			assert.Equal(t, byte(segmentMagic), segmentStart(t, s, name)[0], name)
+		}
+		w, err = New(s, 100, WithChecksumVerification())
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Len(t, replayedSeqs(t, w), 10)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		s := NewMemStorage()
+		wc, err := s.Create(context.Background(), segmentName(1))
+		require.NoError(t, err)
+		_, err = wc.Write([]byte{segmentMagic, 7, 0, 0, 0, 0})
+		require.NoError(t, err)
+		require.NoError(t, wc.Close())
+
+		_, err = New(s, 100)
+		assert.ErrorIs(t, err, ErrUnknownFormat)
+
+		_, err = ParseFormat("flatbuffers")
+		assert.ErrorIs(t, err, ErrUnknownFormat)
+	})
+}
+
+func TestUnmarshalRecord(t *testing.T) {
+	// Record{seq: 300, key: "k", value: "v", expires: 1} as protoc encodes
+	// it, plus a string field 9 and a fixed32 field 10 from some later
+	// version of the message
+	data := []byte{
+		0x08, 0xac, 0x02,
+		0x12, 0x01, 'k',
+		0x1a, 0x01, 'v',
+		0x21, 1, 0, 0, 0, 0, 0, 0, 0,
+		0x4a, 0x02, 'h', 'i',
+		0x55, 1, 2, 3, 4,
+	}
+	e, err := unmarshalRecord(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(300), e.Seq)
+	assert.Equal(t, []byte("k"), e.Key)
+	assert.Equal(t, []byte("v"), e.Value)
+	assert.Equal(t, int64(1), e.Expires.UnixNano())
+	assert.Equal(t, data[:18], marshalRecord(e))
+
+	for _, bad := range [][]byte{
+		{0x08},             // varint cut off
+		{0x12, 0x05, 'k'},  // length past the end
+		{0x0a, 0x01, 'x'},  // seq as bytes
+		{0x00, 0x01},       // field 0
+		{0x0b, 0x01, 0x02}, // group wire type
+	} {
+		_, err := unmarshalRecord(bad)
+		assert.ErrorIs(t, err, ErrCorruptRecord, "% x", bad)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	t.Run("crc32c segments get a header, ieee ones keep none", func(t *testing.T) {
+		s := NewMemStorage()
+		w, err := New(s, 1<<20)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		segs := segmentFiles(t, s)
+		require.Len(t, segs, 1)
+		assert.Equal(t, []byte{segmentMagic, byte(FormatBinary | formatCRC32C)}, segmentStart(t, s, segs[0]))
+
+		s = NewMemStorage()
+		w, err = New(s, 1<<20, WithChecksum(ChecksumIEEE))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		segs = segmentFiles(t, s)
+		require.Len(t, segs, 1)
+		assert.NotEqual(t, byte(segmentMagic), segmentStart(t, s, segs[0])[0])
+	})
+
+	t.Run("checksums mix across segments", func(t *testing.T) {
+		s := NewMemStorage()
+		for _, c := range []Checksum{ChecksumIEEE, ChecksumCRC32C, ChecksumIEEE} {
+			w, err := New(s, 100, WithChecksum(c), WithChecksumVerification())
+			require.NoError(t, err)
+			for range 10 {
+				_, err := w.Write([]byte("never"), []byte("gonna run around"))
+				require.NoError(t, err)
+			}
+			_, err = w.Rotate()
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}
+
+		w, err := New(s, 100, WithChecksumVerification())
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Len(t, replayedSeqs(t, w), 30)
+
+		// compaction keeps each segment's checksum
+		_, err = w.Compact()
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		w, err = New(s, 100, WithChecksumVerification())
+		require.NoError(t, err)
+		assert.Len(t, replayedSeqs(t, w), 30)
+	})
+
+	t.Run("a flipped bit fails either", func(t *testing.T) {
+		for _, c := range []Checksum{ChecksumIEEE, ChecksumCRC32C} {
+			s := NewMemStorage()
+			w, err := New(s, 1<<20, WithChecksum(c))
+			require.NoError(t, err)
+			defer w.Close()
+			_, err = w.Write([]byte("k"), []byte("v"))
+			require.NoError(t, err)
+			require.NoError(t, w.Sync())
+
+			b := s.files[w.current].data.Bytes()
+			b[len(b)-1] ^= 1
+			assert.ErrorIs(t, w.Replay(func(*Entry) error { return nil }), ErrBadChecksum, c)
+		}
+	})
+
+	t.Run("names", func(t *testing.T) {
+		for _, c := range []Checksum{ChecksumIEEE, ChecksumCRC32C} {
+			got, err := ParseChecksum(c.String())
+			require.NoError(t, err)
+			assert.Equal(t, c, got)
+		}
+		_, err := ParseChecksum("md5")
+		assert.ErrorIs(t, err, ErrUnknownChecksum)
+	})
+}
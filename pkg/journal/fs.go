@@ -1,34 +1,197 @@
 package journal
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
+// FileStorage keeps journal files in a directory. Files it has open for
+// writing are synced through the handle they're written with, instead of
+// a second one opened per Sync. Its operations are local and can't be
+// interrupted once started; a done context only keeps them from starting.
 type FileStorage struct {
-	dir string
+	dir           string
+	lock          *os.File
+	forceTakeover bool
+	dated         bool
+	now           func() time.Time
+
+	mu sync.Mutex
+	// open maps file names to the handles Create and OpenAppend returned,
+	// until they're closed
+	open map[string]*storageFile
+	// subdirs maps the names of segment files to the directory under dir
+	// they're in, with the dated layout; "" for those from before it
+	subdirs map[string]string
+}
+
+// storageFile deregisters itself from its FileStorage when closed.
+type storageFile struct {
+	*os.File
+	fs *FileStorage
+}
+
+func (f *storageFile) Close() error {
+	f.fs.mu.Lock()
+	for name, open := range f.fs.open {
+		if open == f {
+			delete(f.fs.open, name)
+		}
+	}
+	f.fs.mu.Unlock()
+	return f.File.Close()
 }
 
-func NewFileStorage(dir string) (*FileStorage, error) {
+func (fs *FileStorage) track(name string, f *os.File) *storageFile {
+	sf := &storageFile{File: f, fs: fs}
+	fs.mu.Lock()
+	fs.open[name] = sf
+	fs.mu.Unlock()
+	return sf
+}
+
+// FileOption configures a FileStorage.
+type FileOption func(*FileStorage)
+
+// WithForceTakeover lets NewFileStorage break a directory lock whose
+// holder process is no longer running.
+func WithForceTakeover() FileOption {
+	return func(fs *FileStorage) {
+		fs.forceTakeover = true
+	}
+}
+
+// WithDatedLayout puts segments in YYYY/MM/DD subdirectories for the UTC
+// day they were created, so a day's segments can be copied or deleted as
+// a directory. Names stay flat: the journal doesn't see the layout.
+// Segments already at the top, from before the layout was turned on, are
+// still found there. Temporary and compacted copies of a segment are kept
+// next to it.
+func WithDatedLayout() FileOption {
+	return func(fs *FileStorage) {
+		fs.dated = true
+	}
+}
+
+// NewFileStorage opens dir and takes an exclusive advisory lock on it, so a
+// second process can't append to the same journal concurrently.
+func NewFileStorage(dir string, opts ...FileOption) (*FileStorage, error) {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
-	return &FileStorage{dir: dir}, nil
+	fs := &FileStorage{
+		dir:     dir,
+		now:     time.Now,
+		open:    make(map[string]*storageFile),
+		subdirs: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(fs)
+	}
+	if err := fs.acquireLock(); err != nil {
+		return nil, err
+	}
+	if fs.dated {
+		// index the segments, which names alone don't locate
+		if _, err := fs.List(context.Background()); err != nil {
+			_ = fs.releaseLock()
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// Close releases the directory lock.
+func (fs *FileStorage) Close() error {
+	return fs.releaseLock()
+}
+
+// isSegmentFile reports whether name is a segment or a temporary or
+// compacted copy of one, which the dated layout places in subdirectories.
+func isSegmentFile(name string) bool {
+	return strings.Contains(name, ".wal")
+}
+
+// path returns where name is, or would be created.
+func (fs *FileStorage) path(name string) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return filepath.Join(fs.dir, fs.subdirs[name], name)
+}
+
+// createDir picks the directory for a new file: a segment's copies go
+// next to it and new segments under today's date. Missing directories are
+// created and fsynced into their parents.
+func (fs *FileStorage) createDir(name string) (string, error) {
+	if !fs.dated || !isSegmentFile(name) {
+		return "", nil
+	}
+	base := name[:strings.Index(name, ".wal")+len(".wal")]
+	fs.mu.Lock()
+	sub, ok := fs.subdirs[base]
+	fs.mu.Unlock()
+	if ok {
+		return sub, nil
+	}
+
+	sub = fs.now().UTC().Format(filepath.Join("2006", "01", "02"))
+	parent := ""
+	for _, part := range strings.Split(sub, string(filepath.Separator)) {
+		dir := filepath.Join(parent, part)
+		if err := os.Mkdir(filepath.Join(fs.dir, dir), 0755); err == nil {
+			if err := fs.syncDir(parent); err != nil {
+				return "", err
+			}
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+		parent = dir
+	}
+	return sub, nil
 }
 
-func (fs *FileStorage) Create(name string) (io.WriteCloser, error) {
-	path := filepath.Join(fs.dir, name)
-	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+func (fs *FileStorage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sub, err := fs.createDir(name)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(fs.dir, sub, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.syncDir(sub); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if fs.dated && isSegmentFile(name) {
+		fs.mu.Lock()
+		fs.subdirs[name] = sub
+		fs.mu.Unlock()
+	}
+	return fs.track(name, f), nil
 }
 
-func (fs *FileStorage) Open(name string) (io.ReadCloser, error) {
-	path := filepath.Join(fs.dir, name)
-	return os.Open(path)
+func (fs *FileStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return os.Open(fs.path(name))
 }
 
-func (fs *FileStorage) OpenAppend(name string) (io.WriteCloser, int64, error) {
-	path := filepath.Join(fs.dir, name)
+func (fs *FileStorage) OpenAppend(ctx context.Context, name string) (io.WriteCloser, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	path := fs.path(name)
 	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, 0, err
@@ -38,27 +201,158 @@ func (fs *FileStorage) OpenAppend(name string) (io.WriteCloser, int64, error) {
 		_ = f.Close()
 		return nil, 0, err
 	}
-	return f, stat.Size(), nil
+	return fs.track(name, f), stat.Size(), nil
 }
 
-func (fs *FileStorage) List() ([]string, error) {
-	files, err := filepath.Glob(filepath.Join(fs.dir, "*.wal"))
+func (fs *FileStorage) List(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(fs.dir)
 	if err != nil {
 		return nil, err
 	}
-	names := make([]string, len(files))
-	for i, f := range files {
-		names[i] = filepath.Base(f)
+	names := make([]string, 0, len(entries))
+	// segments from before the dated layout stay at the top level
+	subdirs := make(map[string]string)
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+			if isSegmentFile(e.Name()) {
+				subdirs[e.Name()] = ""
+			}
+		}
+	}
+	if !fs.dated {
+		return names, nil
 	}
+
+	days, err := fs.days()
+	if err != nil {
+		return nil, err
+	}
+	for _, day := range days {
+		entries, err := os.ReadDir(filepath.Join(fs.dir, day))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() && isSegmentFile(e.Name()) {
+				if _, dup := subdirs[e.Name()]; !dup {
+					names = append(names, e.Name())
+				}
+				subdirs[e.Name()] = day
+			}
+		}
+	}
+	fs.mu.Lock()
+	fs.subdirs = subdirs
+	fs.mu.Unlock()
 	return names, nil
 }
 
-func (fs *FileStorage) Sync(name string) error {
-	path := filepath.Join(fs.dir, name)
-	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+// days lists the YYYY/MM/DD directories of the dated layout, oldest first.
+func (fs *FileStorage) days() ([]string, error) {
+	days := []string{""}
+	for _, digits := range []int{4, 2, 2} {
+		var next []string
+		for _, parent := range days {
+			entries, err := os.ReadDir(filepath.Join(fs.dir, parent))
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.IsDir() && len(e.Name()) == digits && strings.Trim(e.Name(), "0123456789") == "" {
+					next = append(next, filepath.Join(parent, e.Name()))
+				}
+			}
+		}
+		days = next
+	}
+	return days, nil
+}
+
+func (fs *FileStorage) Size(ctx context.Context, name string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	stat, err := os.Stat(fs.path(name))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// Rename moves a file, open or not, and fsyncs the directory. An open
+// handle keeps serving Sync under the new name.
+func (fs *FileStorage) Rename(ctx context.Context, oldName, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// a file stays in its directory, which with the dated layout is also
+	// where the segment it replaces, if any, is
+	sub := fs.subdirs[oldName]
+	if err := os.Rename(filepath.Join(fs.dir, sub, oldName), filepath.Join(fs.dir, sub, newName)); err != nil {
+		return err
+	}
+	if f, ok := fs.open[oldName]; ok {
+		delete(fs.open, oldName)
+		fs.open[newName] = f
+	}
+	if _, ok := fs.subdirs[oldName]; ok {
+		delete(fs.subdirs, oldName)
+		fs.subdirs[newName] = sub
+	}
+	return fs.syncDir(sub)
+}
+
+// Remove deletes a file. With the dated layout, directories it leaves
+// empty are removed too, so deleting a day's segments leaves no trace.
+func (fs *FileStorage) Remove(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	sub, ok := fs.subdirs[name]
+	fs.mu.Unlock()
+	if err := os.Remove(filepath.Join(fs.dir, sub, name)); err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	fs.mu.Lock()
+	delete(fs.subdirs, name)
+	fs.mu.Unlock()
+	for ; sub != "." && sub != ""; sub = filepath.Dir(sub) {
+		// fails on a directory that isn't empty, which is the point
+		if os.Remove(filepath.Join(fs.dir, sub)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Sync flushes name to stable storage, through its open handle if it has
+// one. On macOS (*os.File).Sync issues F_FULLFSYNC, so the data reaches
+// the platter rather than just the drive's cache.
+func (fs *FileStorage) Sync(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	f, ok := fs.open[name]
+	fs.mu.Unlock()
+	if ok {
+		return f.Sync()
+	}
+
+	sf, err := openForSync(fs.path(name))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return f.Sync()
+	defer sf.Close()
+	return sf.Sync()
 }
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package journal
+
+// Directories can't be fsynced portably; other platforms rely on the
+// filesystem to persist metadata.
+
+func (fs *FileStorage) syncDir(string) error { return nil }
@@ -0,0 +1,19 @@
+//go:build unix
+
+package journal
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// syncDir fsyncs sub, a directory under the storage's, so a created or
+// renamed entry survives a crash, not just the file's contents.
+func (fs *FileStorage) syncDir(sub string) error {
+	d, err := os.Open(filepath.Join(fs.dir, sub))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package journal
+
+// Directory locking relies on flock(2); other platforms run unlocked.
+
+func (fs *FileStorage) acquireLock() error { return nil }
+
+func (fs *FileStorage) releaseLock() error { return nil }
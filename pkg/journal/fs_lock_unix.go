@@ -0,0 +1,96 @@
+//go:build unix
+
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const lockFile = "LOCK"
+
+func (fs *FileStorage) acquireLock() error {
+	path := filepath.Join(fs.dir, lockFile)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		holder := readLockPID(f)
+		_ = f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return err
+		}
+
+		// The lock outlives its writer when it was inherited by an orphaned
+		// child or left behind on a network filesystem.
+		if holder == 0 || processAlive(holder) {
+			return fmt.Errorf("%w: %s held by pid %d", ErrLocked, fs.dir, holder)
+		}
+		if !fs.forceTakeover {
+			return fmt.Errorf("%w: %s held by pid %d which is not running, use force takeover to break it", ErrLocked, fs.dir, holder)
+		}
+
+		// Replace the lock file so the stale lock stays on an unlinked inode.
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fs.forceTakeover = false
+		return fs.acquireLock()
+	}
+
+	if err := f.Truncate(0); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	fs.lock = f
+	return nil
+}
+
+func (fs *FileStorage) releaseLock() error {
+	if fs.lock == nil {
+		return nil
+	}
+	f := fs.lock
+	fs.lock = nil
+	// the file itself stays; removing it would race with the next locker
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readLockPID(f *os.File) int {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 32))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
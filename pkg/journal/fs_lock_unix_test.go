@@ -0,0 +1,80 @@
+//go:build unix
+
+package journal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageLock(t *testing.T) {
+	t.Run("second open fails", func(t *testing.T) {
+		dir := t.TempDir()
+
+		fs, err := NewFileStorage(dir)
+		require.NoError(t, err)
+
+		_, err = NewFileStorage(dir)
+		assert.ErrorIs(t, err, ErrLocked)
+		assert.ErrorContains(t, err, strconv.Itoa(os.Getpid()))
+
+		require.NoError(t, fs.Close())
+
+		fs2, err := NewFileStorage(dir)
+		require.NoError(t, err, "lock is released on close")
+		require.NoError(t, fs2.Close())
+	})
+
+	t.Run("force takeover does not break a live lock", func(t *testing.T) {
+		dir := t.TempDir()
+
+		fs, err := NewFileStorage(dir)
+		require.NoError(t, err)
+		defer fs.Close()
+
+		_, err = NewFileStorage(dir, WithForceTakeover())
+		assert.ErrorIs(t, err, ErrLocked)
+	})
+
+	t.Run("force takeover breaks a stale lock", func(t *testing.T) {
+		dir := t.TempDir()
+		holder := lockWithDeadPID(t, dir)
+		defer holder.Close()
+
+		_, err := NewFileStorage(dir)
+		require.ErrorIs(t, err, ErrLocked)
+		assert.ErrorContains(t, err, "not running")
+
+		fs, err := NewFileStorage(dir, WithForceTakeover())
+		require.NoError(t, err)
+		defer fs.Close()
+
+		data, err := os.ReadFile(filepath.Join(dir, lockFile))
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+	})
+}
+
+// lockWithDeadPID holds the directory lock while recording the PID of a
+// process that has already exited, like a lock inherited by an orphan.
+func lockWithDeadPID(t *testing.T, dir string) *os.File {
+	t.Helper()
+
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	pid := cmd.Process.Pid
+
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	require.NoError(t, err)
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB))
+	_, err = f.WriteString(strconv.Itoa(pid) + "\n")
+	require.NoError(t, err)
+	return f
+}
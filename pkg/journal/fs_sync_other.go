@@ -0,0 +1,11 @@
+//go:build !unix
+
+package journal
+
+import "os"
+
+// openForSync opens a file that has no handle open for writing just to
+// fsync it. Windows' FlushFileBuffers needs write access.
+func openForSync(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY, 0)
+}
@@ -0,0 +1,156 @@
+package journal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageSync(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	wc, err := fs.Create(context.Background(), "000001.wal.tmp")
+	require.NoError(t, err)
+	_, err = wc.Write([]byte("entry"))
+	require.NoError(t, err)
+	require.NoError(t, fs.Sync(context.Background(), "000001.wal.tmp"))
+
+	// the handle follows the rename, as it does when a segment is sealed
+	require.NoError(t, fs.Rename(context.Background(), "000001.wal.tmp", "000001.wal"))
+	assert.Contains(t, fs.open, "000001.wal")
+	assert.NotContains(t, fs.open, "000001.wal.tmp")
+	require.NoError(t, fs.Sync(context.Background(), "000001.wal"))
+
+	require.NoError(t, wc.Close())
+	assert.Empty(t, fs.open)
+
+	// without an open handle, even a read-only file can be synced, except
+	// on Windows
+	if runtime.GOOS != "windows" {
+		require.NoError(t, os.Chmod(filepath.Join(dir, "000001.wal"), 0444))
+	}
+	assert.NoError(t, fs.Sync(context.Background(), "000001.wal"))
+
+	assert.ErrorIs(t, fs.Sync(context.Background(), "missing.wal"), os.ErrNotExist)
+}
+
+func TestFileStorageDatedLayout(t *testing.T) {
+	dir := t.TempDir()
+	// a segment from before the layout was turned on
+	flat, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	w, err := New(flat, 1<<20)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("k"), []byte("flat"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, flat.Close())
+
+	day := time.Date(2024, 5, 17, 23, 0, 0, 0, time.UTC)
+	open := func() (*FileStorage, *Journal) {
+		fs, err := NewFileStorage(dir, WithDatedLayout())
+		require.NoError(t, err)
+		fs.now = func() time.Time { return day }
+		w, err := New(fs, 64)
+		require.NoError(t, err)
+		return fs, w
+	}
+	fs, w := open()
+	for range 3 {
+		_, err = w.Write([]byte("k"), []byte("day one"))
+		require.NoError(t, err)
+	}
+	day = day.Add(2 * time.Hour)
+	for range 3 {
+		_, err = w.Write([]byte("k"), []byte("day two"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, fs.Close())
+
+	assert.FileExists(t, filepath.Join(dir, segmentName(1)))
+	assert.FileExists(t, filepath.Join(dir, "MANIFEST"))
+	dayOne, err := filepath.Glob(filepath.Join(dir, "2024", "05", "17", "*.wal"))
+	require.NoError(t, err)
+	dayTwo, err := filepath.Glob(filepath.Join(dir, "2024", "05", "18", "*.wal"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, dayOne)
+	assert.NotEmpty(t, dayTwo)
+
+	fs, w = open()
+	defer fs.Close()
+	defer w.Close()
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7}, replayedSeqs(t, w))
+
+	// truncating the first day's segments removes its directory
+	require.Len(t, w.sealed, 2)
+	_, err = w.TruncateBefore(w.sealed[1].LastSeq + 1)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, segmentName(1)))
+	assert.NoDirExists(t, filepath.Join(dir, "2024", "05", "17"))
+	assert.DirExists(t, filepath.Join(dir, "2024", "05", "18"))
+}
+
+func TestFileStorageDatedLayoutCompactsInPlace(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, segmentName(1)), []byte("old"), 0644))
+	fs, err := NewFileStorage(dir, WithDatedLayout())
+	require.NoError(t, err)
+	defer fs.Close()
+	ctx := context.Background()
+	_, err = fs.List(ctx)
+	require.NoError(t, err)
+
+	// a compacted copy of a top-level segment replaces it where it is
+	f, err := fs.Create(ctx, segmentName(1)+".compact")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("new"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.Rename(ctx, segmentName(1)+".compact", segmentName(1)))
+
+	names, err := fs.List(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"LOCK", segmentName(1)}, names)
+	b, err := os.ReadFile(filepath.Join(dir, segmentName(1)))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(b))
+	days, err := fs.days()
+	require.NoError(t, err)
+	assert.Empty(t, days)
+}
+
+func TestFileStorageBatchAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	w, err := New(fs, 100)
+	require.NoError(t, err)
+
+	// the first segment fills up before the batch commits it
+	entries := make([]Entry, 10)
+	for i := range entries {
+		entries[i] = Entry{Key: []byte("spam"), Value: []byte("eggs and spam")}
+	}
+	_, err = w.WriteBatch(entries)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer fs.Close()
+	w, err = New(fs, 100)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, replayedSeqs(t, w))
+}
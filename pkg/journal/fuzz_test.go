@@ -0,0 +1,73 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// FuzzReadEntry feeds arbitrary bytes to the segment reader as a segment,
+// plain and encrypted. Whatever they hold, reading has to end in an error
+// or EOF without panicking or allocating past what the input could hold.
+func FuzzReadEntry(f *testing.F) {
+	enc, err := NewAESGCMEncryptor(make([]byte, 32))
+	if err != nil {
+		f.Fatal(err)
+	}
+	plain := &Journal{}
+	sealed := &Journal{encryptor: enc}
+	name := segmentName(1)
+
+	count := binary.BigEndian.AppendUint32(nil, 2)
+	entries := []Entry{
+		{Seq: 1, Key: []byte("sensor_temp{ts=1}"), Value: []byte("42")},
+		{Seq: 0, Key: batchMarkerKey, Value: count},
+		{Seq: 2, Key: []byte("sensor_temp{ts=2}"), Value: []byte("43")},
+		{Seq: 3, Key: []byte("sensor_temp{ts=3}"), Expires: time.Unix(0, 1)},
+	}
+	for _, j := range []*Journal{plain, sealed} {
+		var seg []byte
+		for i := range entries {
+			rec, err := j.encode(&entries[i], name, FormatBinary)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(rec, j.encryptor != nil)
+			seg = append(seg, rec...)
+		}
+		f.Add(seg, j.encryptor != nil)
+	}
+	// a length past anything the input holds
+	f.Add([]byte{0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 0}, false)
+	// checksums that match data too short for its own lengths
+	f.Add(frame([]byte{0, 0, 0, 1}), false)
+	f.Add(frame([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0x80, 0, 0, 0, 0, 0, 0, 0}), false)
+	f.Add(frame([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 0}), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, encrypted bool) {
+		j := plain
+		if encrypted {
+			j = sealed
+		}
+		r := &segmentReader{j: j, r: bufio.NewReader(bytes.NewReader(data)), name: name}
+		for {
+			e, err := r.next()
+			if err != nil {
+				return
+			}
+			if len(e.Key)+len(e.Value) > len(data) {
+				t.Fatalf("entry of %d bytes out of %d bytes of input", len(e.Key)+len(e.Value), len(data))
+			}
+		}
+	})
+}
+
+// frame wraps data as a record with a valid checksum.
+func frame(data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(data))
+	return append(b, data...)
+}
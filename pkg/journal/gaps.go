@@ -0,0 +1,125 @@
+package journal
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// SeqGap reports sequence numbers that don't follow on from the ones
+// before them: After is the last number accounted for and Next the one
+// found instead of After+1. Next <= After is a regression.
+type SeqGap struct {
+	Segment string `json:"segment"`
+	After   uint64 `json:"after"`
+	Next    uint64 `json:"next"`
+}
+
+func (g SeqGap) Regression() bool { return g.Next <= g.After }
+
+// maxGaps bounds how many gaps Gaps remembers; the oldest go first.
+const maxGaps = 100
+
+// WithGapHandler calls fn for every gap or regression in sequence numbers
+// found while opening or replaying the journal, e.g. after a segment was
+// deleted by hand. Each one is reported once.
+func WithGapHandler(fn func(SeqGap)) Option {
+	return func(j *Journal) {
+		j.onGap = fn
+	}
+}
+
+type gapLog struct {
+	mu   sync.Mutex
+	gaps []SeqGap
+}
+
+// Gaps returns the sequence gaps found so far, oldest first.
+func (w *Journal) Gaps() []SeqGap {
+	w.gapLog.mu.Lock()
+	defer w.gapLog.mu.Unlock()
+	return slices.Clone(w.gapLog.gaps)
+}
+
+func (w *Journal) reportGap(g SeqGap) {
+	w.gapLog.mu.Lock()
+	if slices.Contains(w.gapLog.gaps, g) {
+		w.gapLog.mu.Unlock()
+		return
+	}
+	if len(w.gapLog.gaps) == maxGaps {
+		w.gapLog.gaps = w.gapLog.gaps[1:]
+	}
+	w.gapLog.gaps = append(w.gapLog.gaps, g)
+	w.gapLog.mu.Unlock()
+
+	if g.Regression() {
+		seqRegressions.Inc()
+	} else {
+		seqGaps.Inc()
+	}
+	if w.onGap != nil {
+		w.onGap(g)
+	}
+}
+
+// checkSegmentSeqs checks that each segment's range picks up where the
+// previous one ended. Ranges of segments removed by TruncateBefore or
+// Compact account for the numbers between.
+func (w *Journal) checkSegmentSeqs(active SegmentInfo) {
+	var last uint64
+	for _, info := range append(slices.Clone(w.sealed), active) {
+		if info.LastSeq == 0 {
+			continue // nothing in it
+		}
+		if last != 0 && info.FirstSeq != last+1 && !w.removedCovers(last+1, info.FirstSeq-1) {
+			w.reportGap(SeqGap{Segment: info.Name, After: last, Next: info.FirstSeq})
+		}
+		last = max(last, info.LastSeq)
+	}
+}
+
+// removedCovers reports whether removed segments account for every
+// sequence number from first to last.
+func (w *Journal) removedCovers(first, last uint64) bool {
+	if first > last {
+		return false
+	}
+	removed := slices.Clone(w.removed)
+	slices.SortFunc(removed, func(a, b SegmentInfo) int { return cmp.Compare(a.FirstSeq, b.FirstSeq) })
+	next := first
+	for _, r := range removed {
+		if r.FirstSeq <= next && r.LastSeq >= next {
+			next = r.LastSeq + 1
+		}
+	}
+	return next > last
+}
+
+// seqTracker checks sequence numbers within one segment as Replay reads
+// them: consecutive from the segment's first, or just increasing in a
+// compacted segment, which has had expired entries taken out.
+type seqTracker struct {
+	w         *Journal
+	segment   string
+	next      uint64 // 0 until the first entry is seen
+	compacted bool
+}
+
+func (w *Journal) newSeqTracker(name string) *seqTracker {
+	t := &seqTracker{w: w, segment: name}
+	if i := slices.IndexFunc(w.sealed, func(s SegmentInfo) bool { return s.Name == name }); i >= 0 {
+		t.next = w.sealed[i].FirstSeq
+		t.compacted = w.sealed[i].Compacted
+	} else if name == w.current {
+		t.next = w.segFirst
+	}
+	return t
+}
+
+func (t *seqTracker) seen(seq uint64) {
+	if t.next != 0 && (seq < t.next || (seq != t.next && !t.compacted)) {
+		t.w.reportGap(SeqGap{Segment: t.segment, After: t.next - 1, Next: seq})
+	}
+	t.next = seq + 1
+}
@@ -0,0 +1,145 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoGapsAfterTruncateAndCompact(t *testing.T) {
+	now := time.Now()
+	s := NewMemStorage()
+	w, err := New(s, 100, WithAtomicBatches())
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+
+	for i := range 40 {
+		expires := time.Time{}
+		if i >= 15 && i < 30 {
+			expires = now.Add(time.Minute)
+		}
+		_, err := w.WriteWithExpiry([]byte("never"), []byte("gonna give you up"), expires)
+		require.NoError(t, err)
+	}
+	_, err = w.WriteBatch([]Entry{{Key: []byte("a")}, {Key: []byte("b")}})
+	require.NoError(t, err)
+
+	_, err = w.TruncateBefore(w.sealed[1].LastSeq + 1)
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = w.Compact()
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var gaps []SeqGap
+	w, err = New(s, 100, WithGapHandler(func(g SeqGap) { gaps = append(gaps, g) }))
+	require.NoError(t, err)
+	defer w.Close()
+	replayedSeqs(t, w)
+
+	assert.Empty(t, gaps)
+	assert.Empty(t, w.Gaps())
+}
+
+func TestGapFromDeletedSegment(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+
+	// a segment deleted along with the manifest that would have caught it
+	segs := segmentNames(mustList(t, s))
+	lost, err := (&Journal{storage: s}).inspect(context.Background(), segs[1])
+	require.NoError(t, err)
+	delete(s.files, segs[1])
+	delete(s.files, manifestName)
+
+	var gaps []SeqGap
+	w, err := New(s, 100, WithGapHandler(func(g SeqGap) { gaps = append(gaps, g) }))
+	require.NoError(t, err)
+	defer w.Close()
+
+	want := SeqGap{Segment: segs[2], After: lost.FirstSeq - 1, Next: lost.LastSeq + 1}
+	assert.Equal(t, []SeqGap{want}, gaps)
+	assert.False(t, want.Regression())
+
+	replayedSeqs(t, w)
+	assert.Equal(t, []SeqGap{want}, w.Gaps(), "reported once")
+}
+
+func TestRegressionOnReplay(t *testing.T) {
+	s := NewMemStorage()
+	w, err := New(s, 1<<20)
+	require.NoError(t, err)
+	defer w.Close()
+	for range 5 {
+		_, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Sync())
+
+	// a record numbered as if the journal had restarted from scratch
+	rec, err := w.encode(&Entry{Seq: 2, Key: []byte("k"), Value: []byte("v")}, w.current, w.segFormat)
+	require.NoError(t, err)
+	s.files[w.current].data.Write(rec)
+
+	replayedSeqs(t, w)
+	gaps := w.Gaps()
+	require.Len(t, gaps, 1)
+	assert.Equal(t, SeqGap{Segment: w.current, After: 5, Next: 2}, gaps[0])
+	assert.True(t, gaps[0].Regression())
+}
+
+func TestFailedWriteBurnsNoSeq(t *testing.T) {
+	aes, err := NewAESGCMEncryptor(randomKey(t))
+	require.NoError(t, err)
+	enc := &failingEncryptor{Encryptor: aes}
+	s := NewMemStorage()
+	w, err := New(s, 1<<20, WithEncryptor(enc))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+
+	enc.fail = true
+	_, err = w.Write([]byte("k"), []byte("v"))
+	require.Error(t, err)
+	enc.fail = false
+
+	seq, err := w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), seq)
+
+	// a batch that fails halfway keeps what it wrote and no more
+	enc.failKey = []byte("bad")
+	seqs, err := w.WriteBatch([]Entry{{Key: []byte("a")}, {Key: []byte("bad")}, {Key: []byte("b")}})
+	require.Error(t, err)
+	assert.Equal(t, []uint64{3}, seqs, "what was written")
+	enc.failKey = nil
+
+	seq, err = w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq)
+	require.NoError(t, w.Close())
+
+	w, err = New(s, 1<<20, WithEncryptor(aes))
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, []uint64{1, 2, 3, 4}, replayedSeqs(t, w))
+	assert.Empty(t, w.Gaps())
+}
+
+type failingEncryptor struct {
+	Encryptor
+	fail    bool
+	failKey []byte
+}
+
+func (f *failingEncryptor) Encrypt(plaintext, aad []byte) ([]byte, error) {
+	if f.fail || (f.failKey != nil && bytes.Contains(plaintext, f.failKey)) {
+		return nil, errors.New("out of entropy")
+	}
+	return f.Encryptor.Encrypt(plaintext, aad)
+}
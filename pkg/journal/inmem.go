@@ -2,9 +2,11 @@ package journal
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"sync"
 )
 
@@ -14,8 +16,7 @@ type MemStorage struct {
 }
 
 type memFile struct {
-	data   *bytes.Buffer
-	closed bool
+	data *bytes.Buffer
 }
 
 func NewMemStorage() *MemStorage {
@@ -24,12 +25,12 @@ func NewMemStorage() *MemStorage {
 	}
 }
 
-func (ms *MemStorage) Create(name string) (io.WriteCloser, error) {
+func (ms *MemStorage) Create(_ context.Context, name string) (io.WriteCloser, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
 	if _, exists := ms.files[name]; exists {
-		return nil, fmt.Errorf("file exists")
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrExist)
 	}
 
 	mf := &memFile{data: &bytes.Buffer{}}
@@ -37,32 +38,32 @@ func (ms *MemStorage) Create(name string) (io.WriteCloser, error) {
 	return &memWriter{ms: ms, name: name, mf: mf}, nil
 }
 
-func (ms *MemStorage) Open(name string) (io.ReadCloser, error) {
+func (ms *MemStorage) Open(_ context.Context, name string) (io.ReadCloser, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
 	mf, exists := ms.files[name]
 	if !exists {
-		return nil, fmt.Errorf("file not found")
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
 	}
 
 	return io.NopCloser(bytes.NewReader(mf.data.Bytes())), nil
 }
 
-func (ms *MemStorage) OpenAppend(name string) (io.WriteCloser, int64, error) {
+func (ms *MemStorage) OpenAppend(_ context.Context, name string) (io.WriteCloser, int64, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
 	mf, exists := ms.files[name]
 	if !exists {
-		return nil, 0, fmt.Errorf("file not found")
+		return nil, 0, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
 	}
 
 	size := int64(mf.data.Len())
 	return &memWriter{ms: ms, name: name, mf: mf}, size, nil
 }
 
-func (ms *MemStorage) List() ([]string, error) {
+func (ms *MemStorage) List(_ context.Context) ([]string, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
@@ -73,26 +74,62 @@ func (ms *MemStorage) List() ([]string, error) {
 	return names, nil
 }
 
-func (ms *MemStorage) Sync(name string) error {
+func (ms *MemStorage) Size(_ context.Context, name string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	mf, exists := ms.files[name]
+	if !exists {
+		return 0, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return int64(mf.data.Len()), nil
+}
+
+func (ms *MemStorage) Remove(_ context.Context, name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, exists := ms.files[name]; !exists {
+		return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	delete(ms.files, name)
+	return nil
+}
+
+func (ms *MemStorage) Rename(_ context.Context, oldName, newName string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	mf, exists := ms.files[oldName]
+	if !exists {
+		return fmt.Errorf("%s: %w", oldName, fs.ErrNotExist)
+	}
+	delete(ms.files, oldName)
+	ms.files[newName] = mf
+	return nil
+}
+
+func (ms *MemStorage) Sync(_ context.Context, name string) error {
 	return nil
 }
 
 type memWriter struct {
-	ms   *MemStorage
-	name string
-	mf   *memFile
+	ms     *MemStorage
+	name   string
+	mf     *memFile
+	closed bool
 }
 
 var ErrClosed = errors.New("memWriter: closed")
 
 func (mw *memWriter) Write(p []byte) (int, error) {
-	if mw.mf.closed {
+	if mw.closed {
 		return 0, ErrClosed
 	}
 	return mw.mf.data.Write(p)
 }
 
 func (mw *memWriter) Close() error {
-	mw.mf.closed = true
+	mw.closed = true
 	return nil
 }
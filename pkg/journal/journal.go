@@ -2,26 +2,42 @@ package journal
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 	"io"
-	"sort"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Entry struct {
 	Key   []byte
 	Value []byte
 	Seq   uint64
+	// Expires, when set, hides the entry from Replay after that time and
+	// lets Compact drop it.
+	Expires time.Time
 }
 
+// Storage holds the journal's files. Each operation gets the context of
+// the Journal call it's made for, so a backend on NFS or an object store
+// can give up on a hung request once its caller does. Writes go through
+// the io.WriteCloser Create or OpenAppend returned and aren't bounded by
+// a context; a backend that uploads on Sync should do it there.
 type Storage interface {
-	Create(name string) (io.WriteCloser, error)
-	Open(name string) (io.ReadCloser, error)
-	OpenAppend(name string) (io.WriteCloser, int64, error)
-	List() ([]string, error)
-	Sync(name string) error
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	OpenAppend(ctx context.Context, name string) (io.WriteCloser, int64, error)
+	List(ctx context.Context) ([]string, error)
+	Size(ctx context.Context, name string) (int64, error)
+	Sync(ctx context.Context, name string) error
+	Rename(ctx context.Context, oldName, newName string) error
+	Remove(ctx context.Context, name string) error
 }
 
 type Journal struct {
@@ -33,8 +49,41 @@ type Journal struct {
 	seq       uint64
 	size      int64
 	maxSize   int64
-	segment   int
+	segment   uint64
 	encryptor Encryptor
+	format    Format
+	checksum  Checksum
+
+	// active segment bookkeeping for its manifest record
+	segFirst uint64
+	segLast  uint64
+	segCRC   uint32
+	segCount uint32
+	// zero if the segment was opened for append rather than created
+	segCreated time.Time
+	// the format the active segment was started with, flagged with its
+	// checksum
+	segFormat Format
+	// the active segment is still named current+tmpSuffix
+	uncommitted bool
+
+	manifest        io.WriteCloser
+	sealed          []SegmentInfo
+	removed         []SegmentInfo // tombstoned, for their sequence ranges
+	verifyChecksums bool
+	atomicBatches   bool
+
+	onGap  func(SeqGap)
+	gapLog gapLog
+
+	// closed by the next write, for Tail; nil while nobody waits
+	written chan struct{}
+	closed  bool
+
+	// highest sequence number fsynced, for DurableSeq
+	durable atomic.Uint64
+
+	now func() time.Time
 }
 
 // Option configures a Journal.
@@ -47,6 +96,14 @@ func WithEncryptor(enc Encryptor) Option {
 	}
 }
 
+// WithChecksumVerification makes New re-read every sealed segment and
+// compare its checksum with the manifest, not just its size.
+func WithChecksumVerification() Option {
+	return func(j *Journal) {
+		j.verifyChecksums = true
+	}
+}
+
 func New(storage Storage, maxSize int64, opts ...Option) (*Journal, error) {
 	if maxSize == 0 {
 		maxSize = 64 * 1024 * 1024
@@ -55,50 +112,85 @@ func New(storage Storage, maxSize int64, opts ...Option) (*Journal, error) {
 	w := &Journal{
 		storage: storage,
 		maxSize: maxSize,
+		now:     time.Now,
 	}
 
 	for _, opt := range opts {
 		opt(w)
 	}
 
-	if err := w.openLatest(); err != nil {
+	if err := w.openLatest(context.Background()); err != nil {
 		return nil, err
 	}
 
 	return w, nil
 }
 
-func (w *Journal) openLatest() error {
-	names, err := w.storage.List()
+func (w *Journal) openLatest(ctx context.Context) error {
+	names, err := w.storage.List(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkSegmentNames(names); err != nil {
+		return err
+	}
+	if names, err = w.recoverUncommitted(ctx, names); err != nil {
+		return err
+	}
+	segs, err := w.openManifest(ctx, names)
 	if err != nil {
 		return err
 	}
+	// tombstones count too: a journal truncated down to its active
+	// segment must not hand out their seqs or segment IDs again
+	for _, info := range slices.Concat(w.sealed, w.removed) {
+		w.seq = max(w.seq, info.LastSeq)
+		if id, ok := parseSegmentName(info.Name); ok {
+			w.segment = max(w.segment, id)
+		}
+	}
+	// sealed segments were fsynced before the manifest listed them
+	w.markDurable(w.seq)
 
-	if len(names) == 0 {
-		return w.newSegment()
+	if len(segs) == 0 {
+		w.checkSegmentSeqs(SegmentInfo{})
+		return w.newSegment(ctx)
 	}
 
-	// find highest segment number
-	latest := 0
-	for _, name := range names {
-		var n int
-		if _, err := fmt.Sscanf(name, "%d.wal", &n); err == nil {
-			if n > latest {
-				latest = n
-			}
-		}
+	// segs is in ID order
+	name := segs[len(segs)-1]
+	if id, ok := parseSegmentName(name); ok {
+		w.segment = max(w.segment, id)
 	}
 
-	w.segment = latest
-	name := segmentName(latest)
+	// crashed between sealing the segment and creating its successor
+	for _, info := range w.sealed {
+		if info.Name == name {
+			w.checkSegmentSeqs(SegmentInfo{})
+			return w.newSegment(ctx)
+		}
+	}
 
 	// scan to get latest sequence
-	if err := w.scan(name); err != nil {
+	info, err := w.inspect(ctx, name)
+	if err != nil {
 		return err
 	}
+	w.seq = max(w.seq, info.LastSeq)
+	w.checkSegmentSeqs(info)
+
+	if info.torn || info.sealed {
+		// crashed mid atomic batch; readers skip the torn tail, but
+		// appending after it would bury new records behind it. A sealed
+		// segment the manifest doesn't list was restored from elsewhere.
+		if err := w.appendManifest(ctx, info); err != nil {
+			return err
+		}
+		return w.newSegment(ctx)
+	}
 
 	// open for append
-	wc, size, err := w.storage.OpenAppend(name)
+	wc, size, err := w.storage.OpenAppend(ctx, name)
 	if err != nil {
 		return err
 	}
@@ -107,80 +199,181 @@ func (w *Journal) openLatest() error {
 	w.writer = bufio.NewWriter(wc)
 	w.closer = wc
 	w.size = size
+	w.segFirst = info.FirstSeq
+	w.segLast = info.LastSeq
+	w.segCRC = info.Checksum
+	w.segCount = info.count
+	w.segFormat = info.format
 
 	return nil
 }
 
-func (w *Journal) scan(name string) error {
-	rc, err := w.storage.Open(name)
-	if err != nil {
-		return err
-	}
-	defer rc.Close()
-
-	r := bufio.NewReader(rc)
-	for {
-		e, err := w.read(r)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+func (w *Journal) newSegment(ctx context.Context) error {
+	if w.closer != nil {
+		// a batch can fill a segment before its first entry was committed
+		if err := w.commitSegment(ctx); err != nil {
 			return err
 		}
-		if e.Seq > w.seq {
-			w.seq = e.Seq
+		if err := w.seal(); err != nil {
+			return err
 		}
-	}
-
-	return nil
-}
-
-func (w *Journal) newSegment() error {
-	if w.closer != nil {
 		if err := w.writer.Flush(); err != nil {
 			return err
 		}
-		if err := w.storage.Sync(w.current); err != nil {
+		if err := w.storage.Sync(ctx, w.current); err != nil {
 			return err
 		}
+		w.markDurable(w.segLast)
 		if err := w.closer.Close(); err != nil {
 			return err
 		}
+		if err := w.appendManifest(ctx, SegmentInfo{
+			Name:     w.current,
+			Size:     w.size,
+			FirstSeq: w.segFirst,
+			LastSeq:  w.segLast,
+			Checksum: w.segCRC,
+
+			SealedAt:  w.now().UTC(),
+			Created:   w.segCreated,
+			Entries:   w.segCount,
+			Encrypted: w.encryptor != nil,
+		}); err != nil {
+			return err
+		}
 	}
 
-	w.segment++
+	next, err := nextSegment(w.segment)
+	if err != nil {
+		return err
+	}
+	w.segment = next
 	name := segmentName(w.segment)
 
-	wc, err := w.storage.Create(name)
+	wc, err := w.storage.Create(ctx, name+tmpSuffix)
 	if err != nil {
 		return err
 	}
 
 	w.current = name
+	w.uncommitted = true
 	w.writer = bufio.NewWriter(wc)
 	w.closer = wc
 	w.size = 0
+	w.segFirst = 0
+	w.segLast = 0
+	w.segCRC = 0
+	w.segCount = 0
+	w.segCreated = w.now().UTC()
+	w.segFormat = w.format.withChecksum(w.checksum)
+
+	// an uncommitted segment holding nothing but its header is removed
+	// like an empty one
+	if header := segmentHeader(w.segFormat); header != nil {
+		if _, err := w.writer.Write(header); err != nil {
+			return err
+		}
+		w.size = int64(len(header))
+		w.segCRC = crc32.Checksum(header, w.segFormat.crcTable())
+	}
+	return nil
+}
+
+// New segments are created under a temporary name and renamed once their
+// first entry is on disk, so a crash during rotation can't leave an empty
+// segment behind.
+const tmpSuffix = ".tmp"
 
+func (w *Journal) currentFile() string {
+	if w.uncommitted {
+		return w.current + tmpSuffix
+	}
+	return w.current
+}
+
+func (w *Journal) commitSegment(ctx context.Context) error {
+	if !w.uncommitted || w.segLast == 0 {
+		return nil
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	tmp := w.currentFile()
+	if err := w.storage.Sync(ctx, tmp); err != nil {
+		return err
+	}
+	w.markDurable(w.segLast)
+	if err := w.storage.Rename(ctx, tmp, w.current); err != nil {
+		return err
+	}
+	w.uncommitted = false
 	return nil
 }
 
-func segmentName(n int) string {
-	return fmt.Sprintf("%06d.wal", n)
+// recoverUncommitted deals with segments left under their temporary name
+// by a crash: one that made it to disk with intact entries is renamed into
+// place, anything else is removed. Returns names updated accordingly.
+func (w *Journal) recoverUncommitted(ctx context.Context, names []string) ([]string, error) {
+	out := names[:0:0]
+	for _, name := range names {
+		final, ok := strings.CutSuffix(name, tmpSuffix)
+		if _, seg := parseSegmentName(final); !ok || !seg {
+			out = append(out, name)
+			continue
+		}
+
+		info, err := w.inspect(ctx, name)
+		if err != nil || info.LastSeq == 0 || info.torn {
+			if err := w.storage.Remove(ctx, name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := w.storage.Rename(ctx, name, final); err != nil {
+			return nil, err
+		}
+		out = append(out, final)
+	}
+	return out, nil
 }
 
 func (w *Journal) Write(key, value []byte) (uint64, error) {
+	return w.writeEntry(context.Background(), key, value, time.Time{})
+}
+
+// WriteCtx is Write with the storage operations it makes, such as creating
+// the next segment, bounded by ctx. Once ctx is done it fails without
+// writing, with ctx.Err() or what the storage made of it.
+func (w *Journal) WriteCtx(ctx context.Context, key, value []byte) (uint64, error) {
+	return w.writeEntry(ctx, key, value, time.Time{})
+}
+
+// WriteWithExpiry writes an entry that Replay skips once expires has
+// passed. A zero expires never expires.
+func (w *Journal) WriteWithExpiry(key, value []byte, expires time.Time) (uint64, error) {
+	return w.writeEntry(context.Background(), key, value, expires)
+}
+
+func (w *Journal) writeEntry(ctx context.Context, key, value []byte, expires time.Time) (uint64, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	w.seq++
+	// it may have waited for the lock behind a slow write
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// the seq is only taken once the entry is written, so a failed write
+	// doesn't leave a gap
 	e := &Entry{
-		Key:   key,
-		Value: value,
-		Seq:   w.seq,
+		Key:     key,
+		Value:   value,
+		Seq:     w.seq + 1,
+		Expires: expires,
 	}
 
 	if w.size >= w.maxSize {
-		if err := w.newSegment(); err != nil {
+		if err := w.newSegment(ctx); err != nil {
 			return 0, err
 		}
 	}
@@ -190,172 +383,434 @@ func (w *Journal) Write(key, value []byte) (uint64, error) {
 		return 0, err
 	}
 
+	w.seq = e.Seq
 	w.size += int64(n)
+	if err := w.commitSegment(ctx); err != nil {
+		return 0, err
+	}
+	w.wakeTails()
 	return e.Seq, nil
 }
 
+// WriteBatch writes entries in order and returns their sequence numbers.
+// Unless atomic batches are on, a batch that fails partway returns the
+// sequence numbers of the entries written before the failure along with
+// the error, so a retry can leave those out.
 func (w *Journal) WriteBatch(entries []Entry) ([]uint64, error) {
+	return w.WriteBatchCtx(context.Background(), entries)
+}
+
+// WriteBatchCtx is WriteBatch bounded by ctx, as WriteCtx is.
+func (w *Journal) WriteBatchCtx(ctx context.Context, entries []Entry) ([]uint64, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if w.atomicBatches {
+		seqs, err := w.writeAtomicBatch(ctx, entries)
+		if err == nil {
+			w.wakeTails()
+		}
+		return seqs, err
+	}
+
 	seqs := make([]uint64, len(entries))
 
 	for i := range entries {
-		w.seq++
-		entries[i].Seq = w.seq
-		seqs[i] = w.seq
+		entries[i].Seq = w.seq + 1
 
 		if w.size >= w.maxSize {
-			if err := w.newSegment(); err != nil {
-				return nil, err
+			if err := w.newSegment(ctx); err != nil {
+				return seqs[:i], err
 			}
 		}
 
 		n, err := w.write(w.writer, &entries[i])
 		if err != nil {
-			return nil, err
+			return seqs[:i], err
 		}
 
+		w.seq = entries[i].Seq
+		seqs[i] = w.seq
 		w.size += int64(n)
 	}
 
+	if err := w.commitSegment(ctx); err != nil {
+		return seqs, err
+	}
+	w.wakeTails()
 	return seqs, nil
 }
 
 func (w *Journal) Sync() error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	return w.SyncCtx(context.Background())
+}
 
+// SyncCtx is Sync with the storage's fsync bounded by ctx. Only writing
+// out the buffer holds up writers; the fsync runs without the lock, so
+// writes go on while it waits for the disk.
+func (w *Journal) SyncCtx(ctx context.Context) error {
+	w.mu.Lock()
 	if err := w.writer.Flush(); err != nil {
+		w.mu.Unlock()
 		return err
 	}
-	return w.storage.Sync(w.current)
+	name, last := w.currentFile(), w.segLast
+	w.mu.Unlock()
+
+	if err := w.storage.Sync(ctx, name); err != nil {
+		w.mu.RLock()
+		moved := w.currentFile() != name
+		w.mu.RUnlock()
+		// committed or rotated meanwhile, which fsynced it first
+		if !moved {
+			return err
+		}
+	}
+	w.markDurable(last)
+	return nil
 }
 
-// Replay reads all journal entries and calls fn for each.
+// Replay reads all unexpired journal entries and calls fn for each.
+// Sequence gaps it comes across are reported to the WithGapHandler.
 // Uses read lock to allow concurrent writes during replay.
 // Caller should coordinate externally if write exclusion is needed.
 func (w *Journal) Replay(fn func(*Entry) error) error {
+	return w.ReplayCtx(context.Background(), fn)
+}
+
+// ReplayCtx is Replay until ctx is done, when it stops between entries
+// and returns ctx.Err(). WithReplayProgress reports how far it has got,
+// for replays of large journals.
+func (w *Journal) ReplayCtx(ctx context.Context, fn func(*Entry) error, opts ...ReplayOption) error {
+	var o replayOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return w.replay(ctx, &replayFilter{now: w.now()}, fn, o.progress)
+}
+
+// ReplayPrefix is Replay for entries whose key starts with prefix, e.g.
+// "sensor_temp". Values of other entries are never copied out. Records
+// still have to be read and, when encrypted, decrypted in full.
+func (w *Journal) ReplayPrefix(prefix []byte, fn func(*Entry) error) error {
+	if prefix == nil {
+		prefix = []byte{}
+	}
+	return w.replay(context.Background(), &replayFilter{prefix: prefix, now: w.now()}, fn, nil)
+}
+
+// ReplayAfter is Replay for entries with sequence numbers above seq, for
+// consumers picking up where they left off. Sealed segments entirely at or
+// below seq aren't read at all. Entries still in the write buffer aren't
+// seen until Sync.
+func (w *Journal) ReplayAfter(seq uint64, fn func(*Entry) error) error {
+	return w.replay(context.Background(), &replayFilter{after: seq, now: w.now()}, fn, nil)
+}
+
+// replay reads the segments in order, reporting to progress if it's set.
+func (w *Journal) replay(ctx context.Context, f *replayFilter, fn func(*Entry) error, progress func(ReplayProgress)) error {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 
-	names, err := w.storage.List()
+	names, err := w.storage.List(ctx)
 	if err != nil {
 		return err
 	}
 
-	sort.Strings(names)
-
-	for _, name := range names {
-		rc, err := w.storage.Open(name)
+	segments := segmentNames(names)
+	p := ReplayProgress{Segments: len(segments)}
+	for i, name := range segments {
+		p.Segment, p.Done = name, i
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.after > 0 && slices.ContainsFunc(w.sealed, func(s SegmentInfo) bool { return s.Name == name && s.LastSeq <= f.after }) {
+			continue
+		}
+		rc, err := w.storage.Open(ctx, name)
 		if err != nil {
 			continue
 		}
 
-		r := bufio.NewReader(rc)
-		for {
-			e, err := w.read(r)
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				_ = rc.Close()
-				return err
-			}
-			if err := fn(e); err != nil {
-				_ = rc.Close()
-				return err
+		cr := &countingReader{r: rc}
+		start := p.Bytes
+		report := func() {
+			p.Bytes = start + cr.n
+			if progress != nil {
+				progress(p)
 			}
 		}
+		r := &segmentReader{j: w, r: bufio.NewReader(cr), name: name, filter: f, seqs: w.newSeqTracker(name)}
+		err = w.replaySegment(ctx, r, fn, &p, report)
 		rc.Close()
+		replayedBytes.Add(int(cr.n))
+		if err != nil {
+			return err
+		}
+		p.Done = i + 1
+		report()
 	}
-
 	return nil
 }
 
+// replaySegment passes the entries r reads to fn, counting them in p and
+// calling report every replayProgressEvery of them.
+func (w *Journal) replaySegment(ctx context.Context, r *segmentReader, fn func(*Entry) error, p *ReplayProgress, report func()) error {
+	for {
+		e, err := r.next()
+		if err == io.EOF || err == errTornBatch {
+			return nil
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		p.Entries++
+		replayedEntries.Inc()
+		if p.Entries%replayProgressEvery == 0 {
+			report()
+		}
+	}
+}
+
 func (w *Journal) Close() error {
+	return w.CloseCtx(context.Background())
+}
+
+// CloseCtx is Close with the final fsync and cleanup bounded by ctx, for
+// shutting down within a deadline on storage that may hang. Buffered
+// entries are written out either way; a sync given up on leaves them
+// wherever the storage had got to.
+func (w *Journal) CloseCtx(ctx context.Context) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	w.closed = true
+	w.wakeTails()
+
 	var firstErr error
 	if w.writer != nil {
 		firstErr = w.writer.Flush()
 	}
 	if w.closer != nil {
-		w.storage.Sync(w.current)
+		if w.storage.Sync(ctx, w.currentFile()) == nil && firstErr == nil {
+			w.markDurable(w.segLast)
+		}
 		if err := w.closer.Close(); err != nil && firstErr == nil {
 			firstErr = err
 		}
+		// nothing was ever written to it
+		if w.uncommitted {
+			if err := w.storage.Remove(ctx, w.currentFile()); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if w.manifest != nil {
+		if err := w.manifest.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
 	return firstErr
 }
 
-func (j *Journal) write(w *bufio.Writer, e *Entry) (int, error) {
+// Records carrying an expiry set this bit in the key length and store the
+// expiry as unix nanoseconds right after it. Keys never come close to 2GiB,
+// so records written before expiry existed decode unchanged.
+const keyLenExpiresFlag = 1 << 31
+
+// Encrypted records set this bit in the frame length and start with a
+// version byte and the plaintext sequence number, which together with the
+// segment name are bound to the ciphertext as associated data. A record
+// copied to another position or segment then fails to decrypt. Frames
+// without the bit are read the way they were before versioning.
+const (
+	frameVersionedFlag = 1 << 31
+	recordV1           = 1
+)
+
+// maxRecordLen bounds the data of one record, so its frame length never
+// reaches the versioned flag. Lengths read back past it are corruption.
+const maxRecordLen = 1 << 30
+
+// Record data up to readChunk is read in one allocation; longer records
+// are read in growing steps, so a corrupt length can't make the reader
+// allocate more than the segment actually holds.
+const readChunk = 64 << 10
+
+// Every record's data holds at least a sequence number and the key and
+// value lengths.
+const minRecordLen = 8 + 4 + 4
+
+// recordAAD is the associated data sealed into a v1 record: the version,
+// the sequence number and the name of the segment it was written to.
+func recordAAD(seq uint64, segment string) []byte {
+	segment = strings.TrimSuffix(segment, tmpSuffix)
+	segment = strings.TrimSuffix(segment, compactSuffix)
+	aad := make([]byte, 0, 1+8+len(segment))
+	aad = append(aad, recordV1)
+	aad = binary.BigEndian.AppendUint64(aad, seq)
+	return append(aad, segment...)
+}
+
+// encode frames an entry bound for segment, laid out and checksummed as
+// format says, as len|crc|data, encrypting data if configured.
+func (j *Journal) encode(e *Entry, segment string, format Format) ([]byte, error) {
+	var data []byte
+	if format.layout() == FormatProtobuf {
+		data = marshalRecord(e)
+	} else {
+		data = marshalBinary(e)
+	}
+
+	var flags uint32
+	if j.encryptor != nil {
+		sealed, err := j.encryptor.Encrypt(data, recordAAD(e.Seq, segment))
+		if err != nil {
+			return nil, err
+		}
+		data = make([]byte, 9+len(sealed))
+		data[0] = recordV1
+		binary.BigEndian.PutUint64(data[1:], e.Seq)
+		copy(data[9:], sealed)
+		flags = frameVersionedFlag
+	}
+	if len(data) > maxRecordLen {
+		return nil, ErrRecordTooLarge
+	}
+
+	crc := crc32.Checksum(data, format.crcTable())
+
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(data))|flags)
+	binary.BigEndian.PutUint32(buf[4:], crc)
+	copy(buf[8:], data)
+	return buf, nil
+}
+
+// marshalBinary lays e out in FormatBinary.
+func marshalBinary(e *Entry) []byte {
 	keyLen := len(e.Key)
 	valLen := len(e.Value)
 
 	dataSize := 8 + 4 + keyLen + 4 + valLen
+	if !e.Expires.IsZero() {
+		dataSize += 8
+	}
 	data := make([]byte, dataSize)
 
 	pos := 0
 	binary.BigEndian.PutUint64(data[pos:], e.Seq)
 	pos += 8
 
-	binary.BigEndian.PutUint32(data[pos:], uint32(keyLen))
-	pos += 4
+	if e.Expires.IsZero() {
+		binary.BigEndian.PutUint32(data[pos:], uint32(keyLen))
+		pos += 4
+	} else {
+		binary.BigEndian.PutUint32(data[pos:], uint32(keyLen)|keyLenExpiresFlag)
+		pos += 4
+		binary.BigEndian.PutUint64(data[pos:], uint64(e.Expires.UnixNano()))
+		pos += 8
+	}
 	copy(data[pos:], e.Key)
 	pos += keyLen
 
 	binary.BigEndian.PutUint32(data[pos:], uint32(valLen))
 	pos += 4
 	copy(data[pos:], e.Value)
+	return data
+}
 
-	if j.encryptor != nil {
-		var err error
-		data, err = j.encryptor.Encrypt(data)
-		if err != nil {
-			return 0, err
-		}
+func (j *Journal) write(w *bufio.Writer, e *Entry) (int, error) {
+	buf, err := j.encode(e, j.current, j.segFormat)
+	if err != nil {
+		return 0, err
 	}
 
-	crc := crc32.ChecksumIEEE(data)
-
-	buf := make([]byte, 8+len(data))
-	binary.BigEndian.PutUint32(buf[0:], uint32(len(data)))
-	binary.BigEndian.PutUint32(buf[4:], crc)
-	copy(buf[8:], data)
+	n, err := w.Write(buf)
+	j.segCRC = crc32.Update(j.segCRC, j.segFormat.crcTable(), buf[:n])
+	if err == nil && e.Seq != 0 {
+		if j.segFirst == 0 {
+			j.segFirst = e.Seq
+		}
+		j.segLast = e.Seq
+		j.segCount++
+	}
+	return n, err
+}
 
-	return w.Write(buf)
+// replayFilter selects the entries Replay hands out.
+type replayFilter struct {
+	prefix []byte    // nil matches every key
+	now    time.Time // entries expired at now don't match
+	after  uint64    // entries at or below don't match
 }
 
-func (j *Journal) read(r *bufio.Reader) (*Entry, error) {
+// readEntry reads the next record of segment, laid out in format. An entry
+// the filter rejects comes back with only Seq, Key and Expires set and
+// matched false, skipping the copy of its value. Batch and seal markers
+// always match; a nil filter matches all.
+func (j *Journal) readEntry(r *bufio.Reader, f *replayFilter, segment string, format Format) (*Entry, bool, error) {
 	lenBuf := make([]byte, 4)
 	if _, err := io.ReadFull(r, lenBuf); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	length := binary.BigEndian.Uint32(lenBuf)
+	versioned := length&frameVersionedFlag != 0
+	length &^= frameVersionedFlag
 
 	crcBuf := make([]byte, 4)
 	if _, err := io.ReadFull(r, crcBuf); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	expectedCRC := binary.BigEndian.Uint32(crcBuf)
 
-	data := make([]byte, length)
-	if _, err := io.ReadFull(r, data); err != nil {
-		return nil, err
+	if length > maxRecordLen {
+		return nil, false, fmt.Errorf("%w: length %d", ErrCorruptRecord, length)
+	}
+	data, err := readData(r, int(length))
+	if err != nil {
+		return nil, false, err
 	}
 
-	if crc32.ChecksumIEEE(data) != expectedCRC {
-		return nil, ErrBadChecksum
+	if crc32.Checksum(data, format.crcTable()) != expectedCRC {
+		return nil, false, ErrBadChecksum
 	}
 
-	if j.encryptor != nil {
-		var err error
-		data, err = j.encryptor.Decrypt(data)
+	switch {
+	case versioned:
+		if data, err = j.open(data, segment, format); err != nil {
+			return nil, false, err
+		}
+	case j.encryptor != nil:
+		// written before records were versioned, without associated data
+		if data, err = j.encryptor.Decrypt(data, nil); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if format.layout() == FormatProtobuf {
+		e, err := unmarshalRecord(data)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
+		if f != nil && !f.match(e.Seq, e.Key, e.Expires) {
+			e.Value = nil
+			return e, false, nil
+		}
+		return e, true, nil
+	}
+	if len(data) < minRecordLen {
+		return nil, false, fmt.Errorf("%w: %d bytes", ErrCorruptRecord, len(data))
 	}
 
 	pos := 0
@@ -364,18 +819,90 @@ func (j *Journal) read(r *bufio.Reader) (*Entry, error) {
 
 	keyLen := binary.BigEndian.Uint32(data[pos:])
 	pos += 4
+	var expires time.Time
+	if keyLen&keyLenExpiresFlag != 0 {
+		keyLen &^= keyLenExpiresFlag
+		if len(data) < minRecordLen+8 {
+			return nil, false, fmt.Errorf("%w: %d bytes", ErrCorruptRecord, len(data))
+		}
+		expires = time.Unix(0, int64(binary.BigEndian.Uint64(data[pos:])))
+		pos += 8
+	}
+	if int(keyLen) > len(data)-pos-4 {
+		return nil, false, fmt.Errorf("%w: key length %d", ErrCorruptRecord, keyLen)
+	}
 	key := make([]byte, keyLen)
 	copy(key, data[pos:pos+int(keyLen)])
 	pos += int(keyLen)
 
+	if f != nil && !f.match(seq, key, expires) {
+		return &Entry{Key: key, Seq: seq, Expires: expires}, false, nil
+	}
+
 	valLen := binary.BigEndian.Uint32(data[pos:])
 	pos += 4
+	if int(valLen) > len(data)-pos {
+		return nil, false, fmt.Errorf("%w: value length %d", ErrCorruptRecord, valLen)
+	}
 	val := make([]byte, valLen)
 	copy(val, data[pos:])
 
 	return &Entry{
-		Key:   key,
-		Value: val,
-		Seq:   seq,
-	}, nil
+		Key:     key,
+		Value:   val,
+		Seq:     seq,
+		Expires: expires,
+	}, true, nil
+}
+
+// readData reads the n bytes of record data, failing like io.ReadFull
+// when the segment ends first.
+func readData(r io.Reader, n int) ([]byte, error) {
+	if n <= readChunk {
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return data, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	switch {
+	case err != nil:
+		return nil, err
+	case len(data) == 0:
+		return nil, io.EOF
+	case len(data) < n:
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// open decrypts the body of a versioned record read from segment.
+func (j *Journal) open(data []byte, segment string, format Format) ([]byte, error) {
+	if len(data) < 9 || data[0] != recordV1 {
+		return nil, ErrRecordVersion
+	}
+	if j.encryptor == nil {
+		return nil, fmt.Errorf("%w: record is encrypted", ErrRecordVersion)
+	}
+	seq := binary.BigEndian.Uint64(data[1:])
+	plain, err := j.encryptor.Decrypt(data[9:], recordAAD(seq, segment))
+	if err != nil {
+		return nil, fmt.Errorf("%w: seq %d in %s: %w", ErrRecordAuth, seq, segment, err)
+	}
+	if s, ok := recordSeq(plain, format); !ok || s != seq {
+		return nil, fmt.Errorf("%w: seq %d in %s", ErrRecordAuth, seq, segment)
+	}
+	return plain, nil
+}
+
+func (f *replayFilter) match(seq uint64, key []byte, expires time.Time) bool {
+	if seq == 0 && (bytes.Equal(key, batchMarkerKey) || bytes.Equal(key, sealMarkerKey)) {
+		return true
+	}
+	if seq <= f.after {
+		return false
+	}
+	if f.prefix != nil && !bytes.HasPrefix(key, f.prefix) {
+		return false
+	}
+	return f.now.IsZero() || expires.IsZero() || f.now.Before(expires)
 }
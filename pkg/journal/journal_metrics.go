@@ -0,0 +1,23 @@
+package journal
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	truncatedSegments = metrics.NewCounter("journal_truncated_segments_total")
+	reclaimedBytes    = metrics.NewCounter("journal_reclaimed_bytes_total")
+	compactedSegments = metrics.NewCounter("journal_compacted_segments_total")
+	rotatedSegments   = metrics.NewCounter("journal_manual_rotations_total")
+	expiredEntries    = metrics.NewCounter("journal_expired_entries_total")
+	seqGaps           = metrics.NewCounter("journal_seq_gaps_total")
+	seqRegressions    = metrics.NewCounter("journal_seq_regressions_total")
+	replayedEntries   = metrics.NewCounter("journal_replayed_entries_total")
+	replayedBytes     = metrics.NewCounter("journal_replayed_bytes_total")
+)
+
+func replicaErrors(i int) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`journal_multi_write_errors_total{journal="%d"}`, i))
+}
@@ -2,6 +2,7 @@ package journal
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"testing"
 )
@@ -160,7 +161,7 @@ func TestSegmentRotation(t *testing.T) {
 	}
 	w.Sync()
 
-	files, _ := s.List()
+	files, _ := s.List(context.Background())
 	if len(files) < 2 {
 		t.Fatalf("expected multiple segments, got %d", len(files))
 	}
@@ -276,7 +277,7 @@ func TestBatchAcrossSegments(t *testing.T) {
 		t.Fatalf("batch returned %d seqs, want 10", len(seqs))
 	}
 
-	files, _ := s.List()
+	files, _ := s.List(context.Background())
 	if len(files) < 2 {
 		t.Fatalf("batch should span segments, got %d files", len(files))
 	}
@@ -0,0 +1,52 @@
+package journal
+
+import "errors"
+
+// errFound stops the Page of a Lookup once it has read past seq.
+var errFound = errors.New("found")
+
+// Lookup returns the entry written with seq. It fails with ErrNotFound if
+// there is none: seq was truncated, expired, compacted away or not
+// written yet. Unlike Page it sees entries still in the write buffer,
+// writing them out first; it doesn't fsync them.
+func (w *Journal) Lookup(seq uint64) (*Entry, error) {
+	if seq == 0 {
+		return nil, ErrNotFound
+	}
+
+	// start at the last sealed segment that could hold seq, or at the
+	// first segment if none can, and read on from there
+	w.mu.Lock()
+	if w.segLast != 0 && seq >= w.segFirst && seq <= w.segLast {
+		if err := w.writer.Flush(); err != nil {
+			w.mu.Unlock()
+			return nil, err
+		}
+	}
+	c := w.seekCursor(seq)
+	w.mu.Unlock()
+
+	var found *Entry
+	for {
+		next, more, err := w.Page(c, nil, pageScanMax, func(e *Entry) error {
+			if e.Seq < seq {
+				return nil
+			}
+			if e.Seq == seq {
+				found = e
+			}
+			return errFound
+		})
+		if errors.Is(err, errFound) || err == nil && !more {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c = next
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
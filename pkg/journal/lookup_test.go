@@ -0,0 +1,41 @@
+package journal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	w, err := New(NewMemStorage(), 100) // a few entries per segment
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := range 20 {
+		_, err := w.Write(fmt.Appendf(nil, "k%d", i), fmt.Appendf(nil, "v%d", i))
+		require.NoError(t, err)
+	}
+	// no Sync, so the active segment's entries are still buffered
+	require.Greater(t, len(w.sealed), 2)
+
+	for seq := uint64(1); seq <= 20; seq++ {
+		e, err := w.Lookup(seq)
+		require.NoError(t, err, seq)
+		assert.Equal(t, seq, e.Seq)
+		assert.Equal(t, fmt.Sprintf("k%d", seq-1), string(e.Key))
+		assert.Equal(t, fmt.Sprintf("v%d", seq-1), string(e.Value))
+	}
+	for _, seq := range []uint64{0, 21} {
+		_, err := w.Lookup(seq)
+		assert.ErrorIs(t, err, ErrNotFound, seq)
+	}
+
+	_, err = w.TruncateBefore(10)
+	require.NoError(t, err)
+	_, err = w.Lookup(1)
+	assert.ErrorIs(t, err, ErrNotFound, "truncated")
+	_, err = w.Lookup(15)
+	assert.NoError(t, err)
+}
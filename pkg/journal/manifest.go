@@ -0,0 +1,285 @@
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+const manifestName = "MANIFEST"
+
+// SegmentInfo describes a sealed segment as recorded in the manifest.
+type SegmentInfo struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	FirstSeq uint64 `json:"first_seq"`
+	LastSeq  uint64 `json:"last_seq"`
+	Checksum uint32 `json:"crc32"`
+	// Removed marks a tombstone for a segment dropped by TruncateBefore.
+	Removed bool `json:"removed,omitempty"`
+	// Compacted marks a segment Compact has taken expired entries out of.
+	Compacted bool `json:"compacted,omitempty"`
+	// SealedAt, Created, Entries and Encrypted are recorded when the
+	// segment is sealed. A zero SealedAt means it was sealed before they
+	// were, and the others are unknown; Created is also unknown for a
+	// segment that was active across a restart.
+	SealedAt  time.Time `json:"sealed_at,omitzero"`
+	Created   time.Time `json:"created,omitzero"`
+	Entries   uint32    `json:"entries,omitempty"`
+	Encrypted bool      `json:"encrypted,omitempty"`
+
+	// torn is set by inspect when the segment ends inside an atomic batch,
+	// sealed when it ends with a seal marker
+	torn   bool
+	sealed bool
+	count  uint32
+	format Format
+}
+
+// inspect reads a whole segment and returns its size, sequence range and
+// checksum of the raw bytes. Entries of a trailing incomplete atomic batch
+// are not counted.
+func (w *Journal) inspect(ctx context.Context, name string) (SegmentInfo, error) {
+	info := SegmentInfo{Name: name}
+
+	rc, err := w.storage.Open(ctx, name)
+	if err != nil {
+		return info, err
+	}
+	defer rc.Close()
+
+	// the header names the polynomial of the checksum
+	br := bufio.NewReader(rc)
+	f, _, err := peekSegmentHeader(br)
+	if err != nil {
+		return info, fmt.Errorf("segment %s: %w", name, err)
+	}
+	h := crc32.New(f.crcTable())
+	cr := &countingReader{r: io.TeeReader(br, h)}
+	r := &segmentReader{j: w, r: bufio.NewReader(cr), name: name}
+	for {
+		e, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err == errTornBatch {
+			info.torn = true
+			break
+		}
+		if err != nil {
+			return info, err
+		}
+		if info.FirstSeq == 0 {
+			info.FirstSeq = e.Seq
+		}
+		info.LastSeq = e.Seq
+		info.count++
+	}
+
+	if r.seal != nil {
+		if err := checkSeal(info, info.count, r.seal); err != nil {
+			return info, err
+		}
+		info.sealed = true
+	}
+	info.Size = cr.n
+	info.Checksum = h.Sum32()
+	info.format = r.format
+	return info, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// openManifest loads and verifies the manifest against the segments in
+// storage. Every segment but the active (latest) one must be listed with a
+// matching size. Journals written before the manifest existed get one
+// built from their current segments. Returns the segments among names,
+// minus any whose truncation was interrupted and is finished here.
+func (w *Journal) openManifest(ctx context.Context, names []string) ([]string, error) {
+	segs := segmentNames(names)
+
+	rc, err := w.storage.Open(ctx, manifestName)
+	if err != nil {
+		return segs, w.bootstrapManifest(ctx, segs)
+	}
+
+	sealed, removed, torn, err := readManifest(rc)
+	_ = rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool, len(segs))
+	for _, name := range segs {
+		present[name] = true
+	}
+
+	// crashed between writing the tombstone and removing the file
+	for _, info := range removed {
+		if !present[info.Name] {
+			continue
+		}
+		if err := w.storage.Remove(ctx, info.Name); err != nil {
+			return nil, err
+		}
+		delete(present, info.Name)
+	}
+	live := segs[:0:0]
+	for _, name := range segs {
+		if present[name] {
+			live = append(live, name)
+		}
+	}
+	segs = live
+
+	if err := w.recoverCompacted(ctx, names, sealed); err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]bool, len(sealed))
+	for _, info := range sealed {
+		listed[info.Name] = true
+		if !present[info.Name] {
+			return nil, fmt.Errorf("%w: segment %s is missing", ErrManifestMismatch, info.Name)
+		}
+
+		size, err := w.storage.Size(ctx, info.Name)
+		if err != nil {
+			return nil, err
+		}
+		if size != info.Size {
+			return nil, fmt.Errorf("%w: segment %s is %d bytes, manifest says %d", ErrManifestMismatch, info.Name, size, info.Size)
+		}
+
+		if w.verifyChecksums {
+			got, err := w.inspect(ctx, info.Name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: segment %s: %w", ErrManifestMismatch, info.Name, err)
+			}
+			if got.Checksum != info.Checksum {
+				return nil, fmt.Errorf("%w: segment %s checksum %08x, manifest says %08x", ErrManifestMismatch, info.Name, got.Checksum, info.Checksum)
+			}
+		}
+	}
+
+	for i, name := range segs {
+		if i == len(segs)-1 {
+			break // active segment
+		}
+		if !listed[name] {
+			return nil, fmt.Errorf("%w: segment %s is not in the manifest", ErrManifestMismatch, name)
+		}
+	}
+
+	wc, _, err := w.storage.OpenAppend(ctx, manifestName)
+	if err != nil {
+		return nil, err
+	}
+	if torn {
+		// terminate the torn line so the next record starts clean
+		if _, err := wc.Write([]byte{'\n'}); err != nil {
+			_ = wc.Close()
+			return nil, err
+		}
+	}
+	w.manifest = wc
+	w.sealed = sealed
+	w.removed = removed
+	return segs, nil
+}
+
+func (w *Journal) bootstrapManifest(ctx context.Context, segs []string) error {
+	var sealed []SegmentInfo
+	for i, name := range segs {
+		if i == len(segs)-1 {
+			break
+		}
+		info, err := w.inspect(ctx, name)
+		if err != nil {
+			return err
+		}
+		sealed = append(sealed, info)
+	}
+
+	wc, err := w.storage.Create(ctx, manifestName)
+	if err != nil {
+		return err
+	}
+	w.manifest = wc
+
+	for _, info := range sealed {
+		if err := w.appendManifest(ctx, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Journal) appendManifest(ctx context.Context, info SegmentInfo) error {
+	if err := w.writeManifest(ctx, info); err != nil {
+		return err
+	}
+	w.sealed = append(w.sealed, info)
+	return nil
+}
+
+func (w *Journal) writeManifest(ctx context.Context, info SegmentInfo) error {
+	line, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if _, err := w.manifest.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return w.storage.Sync(ctx, manifestName)
+}
+
+// readManifest parses one JSON record per line and applies tombstones,
+// returning the live sealed segments and the removed ones. A later record
+// for the same segment, written by Compact, replaces the earlier one. A crash
+// mid-append leaves a torn line behind, which is skipped: a segment that
+// lost its record that way still fails verification as unlisted. torn
+// reports whether the file ends in the middle of a line.
+func readManifest(r io.Reader) (sealed, removed []SegmentInfo, torn bool, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	torn = len(data) > 0 && data[len(data)-1] != '\n'
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		var info SegmentInfo
+		if err := json.Unmarshal([]byte(line), &info); err != nil {
+			continue
+		}
+		if info.Removed {
+			removed = append(removed, info)
+			sealed = slices.DeleteFunc(sealed, func(s SegmentInfo) bool { return s.Name == info.Name })
+			continue
+		}
+		if i := slices.IndexFunc(sealed, func(s SegmentInfo) bool { return s.Name == info.Name }); i >= 0 {
+			sealed[i] = info
+			continue
+		}
+		sealed = append(sealed, info)
+	}
+	return sealed, removed, torn, nil
+}
@@ -0,0 +1,180 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rotated(t *testing.T, s *MemStorage, n int) {
+	t.Helper()
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	for range n {
+		_, err := w.Write([]byte("never"), []byte("gonna give you up"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+}
+
+func TestManifestRecordsRotation(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+
+	rc, err := s.Open(context.Background(), manifestName)
+	require.NoError(t, err)
+	sealed, _, torn, err := readManifest(rc)
+	require.NoError(t, err)
+	assert.False(t, torn)
+
+	segs, _ := s.List(context.Background())
+	require.Len(t, sealed, len(segmentNames(segs))-1, "all but the active segment are sealed")
+
+	var next uint64 = 1
+	for _, info := range sealed {
+		size, err := s.Size(context.Background(), info.Name)
+		require.NoError(t, err)
+		assert.Equal(t, size, info.Size)
+		assert.Equal(t, next, info.FirstSeq)
+		assert.GreaterOrEqual(t, info.LastSeq, info.FirstSeq)
+		next = info.LastSeq + 1
+
+		w := &Journal{storage: s}
+		got, err := w.inspect(context.Background(), info.Name)
+		require.NoError(t, err)
+		assert.Equal(t, got.Checksum, info.Checksum)
+	}
+}
+
+func TestManifestVerification(t *testing.T) {
+	t.Run("clean reopen", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+
+		w, err := New(s, 100, WithChecksumVerification())
+		require.NoError(t, err)
+		defer w.Close()
+
+		seq, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(21), seq)
+	})
+
+	t.Run("missing segment", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+		delete(s.files, segmentName(1))
+
+		_, err := New(s, 100)
+		assert.ErrorIs(t, err, ErrManifestMismatch)
+		assert.ErrorContains(t, err, "000001.wal is missing")
+	})
+
+	t.Run("renamed segment", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+		s.files["000000.wal"] = s.files[segmentName(1)]
+		delete(s.files, segmentName(1))
+
+		_, err := New(s, 100)
+		assert.ErrorIs(t, err, ErrManifestMismatch)
+	})
+
+	t.Run("truncated segment", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+		s.files[segmentName(2)].data.Truncate(10)
+
+		_, err := New(s, 100)
+		assert.ErrorIs(t, err, ErrManifestMismatch)
+		assert.ErrorContains(t, err, "000002.wal is 10 bytes")
+	})
+
+	t.Run("corrupted segment with checksum verification", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+		b := s.files[segmentName(1)].data.Bytes()
+		b[len(b)-1] ^= 0xff
+
+		w, err := New(s, 100)
+		require.NoError(t, err, "size still matches")
+		w.Close()
+
+		_, err = New(s, 100, WithChecksumVerification())
+		assert.ErrorIs(t, err, ErrManifestMismatch)
+	})
+}
+
+func TestManifestBootstrap(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+	delete(s.files, manifestName)
+
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	segs, _ := s.List(context.Background())
+	assert.Len(t, w.sealed, len(segmentNames(segs))-1)
+
+	w, err = New(s, 100, WithChecksumVerification())
+	require.NoError(t, err)
+	w.Close()
+}
+
+func TestManifestTornTail(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+	s.files[manifestName].data.WriteString(`{"name":"0000`)
+
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	for range 10 {
+		_, err := w.Write([]byte("k"), []byte("rotate me please"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	w, err = New(s, 100)
+	require.NoError(t, err)
+	w.Close()
+}
+
+func TestSealedLatestSegmentStartsNewOne(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+
+	// simulate a crash after sealing but before the next segment exists
+	segs := segmentNames(mustList(t, s))
+	active := segs[len(segs)-1]
+	w := &Journal{storage: s}
+	info, err := w.inspect(context.Background(), active)
+	require.NoError(t, err)
+	s.files[manifestName].data.WriteString(mustJSONLine(t, info))
+
+	j, err := New(s, 100)
+	require.NoError(t, err)
+	defer j.Close()
+
+	seq, err := j.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, info.LastSeq+1, seq)
+	assert.NotEqual(t, active, j.current)
+}
+
+func mustList(t *testing.T, s Storage) []string {
+	t.Helper()
+	names, err := s.List(context.Background())
+	require.NoError(t, err)
+	return names
+}
+
+func mustJSONLine(t *testing.T, info SegmentInfo) string {
+	t.Helper()
+	line, err := json.Marshal(info)
+	require.NoError(t, err)
+	return string(line) + "\n"
+}
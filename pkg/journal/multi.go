@@ -0,0 +1,133 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Writer is the write side of a journal; *Journal implements it.
+type Writer interface {
+	Write(key, value []byte) (uint64, error)
+	WriteBatch(entries []Entry) ([]uint64, error)
+	Sync() error
+	Close() error
+}
+
+type MultiOption func(*MultiWriter)
+
+// WithBestEffort only requires the primary (first) journal to accept a
+// write. Failures of the others are counted and reported to the handler
+// set with WithReplicaErrorHandler instead of being returned.
+func WithBestEffort() MultiOption {
+	return func(m *MultiWriter) {
+		m.bestEffort = true
+	}
+}
+
+// WithReplicaErrorHandler is called with the index of a journal and the
+// error it failed with, for failures WithBestEffort swallows.
+func WithReplicaErrorHandler(fn func(i int, err error)) MultiOption {
+	return func(m *MultiWriter) {
+		m.onError = fn
+	}
+}
+
+// MultiWriter writes each entry to several journals concurrently, e.g. a
+// local disk and an NFS mount, or a plaintext and an encrypted copy. By
+// default a write fails if any journal fails; nothing is rolled back, so
+// the entry may still have reached the others.
+//
+// Sequence numbers are the primary's. Replicas number their entries
+// independently and only agree with it while every write reaches all of
+// them.
+type MultiWriter struct {
+	writers    []Writer
+	bestEffort bool
+	onError    func(i int, err error)
+}
+
+func NewMultiWriter(primary Writer, replicas []Writer, opts ...MultiOption) *MultiWriter {
+	m := &MultiWriter{writers: append([]Writer{primary}, replicas...)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *MultiWriter) Write(key, value []byte) (uint64, error) {
+	var seq uint64
+	err := m.each(func(i int, w Writer) error {
+		s, err := w.Write(key, value)
+		if i == 0 {
+			seq = s
+		}
+		return err
+	})
+	return seq, err
+}
+
+// WriteBatch sets Seq on entries from the primary's numbering.
+func (m *MultiWriter) WriteBatch(entries []Entry) ([]uint64, error) {
+	// each journal stamps its own sequence numbers on the slice
+	copies := make([][]Entry, len(m.writers))
+	copies[0] = entries
+	for i := 1; i < len(copies); i++ {
+		copies[i] = slices.Clone(entries)
+	}
+
+	var seqs []uint64
+	err := m.each(func(i int, w Writer) error {
+		s, err := w.WriteBatch(copies[i])
+		if i == 0 {
+			seqs = s
+		}
+		return err
+	})
+	return seqs, err
+}
+
+func (m *MultiWriter) Sync() error {
+	return m.each(func(_ int, w Writer) error { return w.Sync() })
+}
+
+// Close closes every journal, whatever the failure semantics.
+func (m *MultiWriter) Close() error {
+	errs := make([]error, len(m.writers))
+	for i, w := range m.writers {
+		if err := w.Close(); err != nil {
+			errs[i] = fmt.Errorf("journal %d: %w", i, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MultiWriter) each(fn func(i int, w Writer) error) error {
+	errs := make([]error, len(m.writers))
+	var wg sync.WaitGroup
+	for i, w := range m.writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, w)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		replicaErrors(i).Inc()
+		if m.bestEffort && i > 0 {
+			if m.onError != nil {
+				m.onError(i, err)
+			}
+			errs[i] = nil
+			continue
+		}
+		errs[i] = fmt.Errorf("journal %d: %w", i, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package journal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenWriter fails every call.
+type brokenWriter struct{ err error }
+
+func (b brokenWriter) Write(key, value []byte) (uint64, error)      { return 0, b.err }
+func (b brokenWriter) WriteBatch(entries []Entry) ([]uint64, error) { return nil, b.err }
+func (b brokenWriter) Sync() error                                  { return b.err }
+func (b brokenWriter) Close() error                                 { return nil }
+
+func keys(t *testing.T, w *Journal) []string {
+	t.Helper()
+	var got []string
+	require.NoError(t, w.Replay(func(e *Entry) error {
+		got = append(got, string(e.Key))
+		return nil
+	}))
+	return got
+}
+
+func TestMultiWriter(t *testing.T) {
+	enc, err := NewAESGCMEncryptor(make([]byte, 32))
+	require.NoError(t, err)
+
+	primary, err := New(NewMemStorage(), 1<<20)
+	require.NoError(t, err)
+	replica, err := New(NewMemStorage(), 1<<20, WithEncryptor(enc))
+	require.NoError(t, err)
+	m := NewMultiWriter(primary, []Writer{replica})
+
+	seq, err := m.Write([]byte("a"), []byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+
+	entries := []Entry{{Key: []byte("b"), Value: []byte("2")}, {Key: []byte("c"), Value: []byte("3")}}
+	seqs, err := m.WriteBatch(entries)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2, 3}, seqs)
+	assert.Equal(t, uint64(3), entries[1].Seq)
+	require.NoError(t, m.Sync())
+
+	assert.Equal(t, []string{"a", "b", "c"}, keys(t, primary))
+	assert.Equal(t, []string{"a", "b", "c"}, keys(t, replica))
+	require.NoError(t, m.Close())
+}
+
+func TestMultiWriterFailures(t *testing.T) {
+	disk := errors.New("nfs gone")
+
+	t.Run("all must succeed", func(t *testing.T) {
+		primary, err := New(NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		m := NewMultiWriter(primary, []Writer{brokenWriter{disk}})
+
+		_, err = m.Write([]byte("a"), []byte("1"))
+		assert.ErrorIs(t, err, disk)
+		assert.ErrorContains(t, err, "journal 1")
+		_, err = m.WriteBatch([]Entry{{Key: []byte("b")}})
+		assert.ErrorIs(t, err, disk)
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		primary, err := New(NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		var failed []int
+		m := NewMultiWriter(primary, []Writer{brokenWriter{disk}},
+			WithBestEffort(),
+			WithReplicaErrorHandler(func(i int, err error) {
+				assert.ErrorIs(t, err, disk)
+				failed = append(failed, i)
+			}),
+		)
+
+		seq, err := m.Write([]byte("a"), []byte("1"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), seq)
+		_, err = m.WriteBatch([]Entry{{Key: []byte("b")}})
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 1}, failed)
+	})
+
+	t.Run("best effort still needs the primary", func(t *testing.T) {
+		replica, err := New(NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		m := NewMultiWriter(brokenWriter{disk}, []Writer{replica}, WithBestEffort())
+
+		_, err = m.Write([]byte("a"), []byte("1"))
+		assert.ErrorIs(t, err, disk)
+	})
+}
@@ -0,0 +1,160 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"slices"
+)
+
+// pageScanMax bounds the entries one Page reads, matching or not, so a
+// prefix that matches little can't keep the read lock for a whole replay.
+const pageScanMax = 64 << 10
+
+// Cursor is where Page resumes: a record boundary in a segment and the
+// sequence number of the last entry handed out there, which is skipped if
+// read again. The zero Cursor is the start of the journal.
+type Cursor struct {
+	Segment string
+	Offset  int64
+	Seq     uint64
+	// Checksum is the manifest checksum of Segment if it was sealed when
+	// the cursor was taken, so an offset into a segment compacted since
+	// is recognised as stale.
+	Checksum uint32
+}
+
+// Page calls fn for up to limit unexpired entries from c on whose keys
+// start with prefix, nil for all, in journal order. It returns the cursor
+// of the next page and whether there may be more; a page can come back
+// short of limit with more still to read, when scanning for prefix took
+// too long. The read lock is held for one page only, so writes,
+// truncation and compaction go ahead between pages: a cursor into a
+// segment removed since resumes at the next one, and one into a segment
+// compacted since rereads it from the start, skipping entries up to
+// c.Seq. Entries still in the write buffer aren't seen until Sync.
+func (w *Journal) Page(c Cursor, prefix []byte, limit int, fn func(*Entry) error) (Cursor, bool, error) {
+	return w.page(context.Background(), c, prefix, limit, fn)
+}
+
+func (w *Journal) page(ctx context.Context, c Cursor, prefix []byte, limit int, fn func(*Entry) error) (Cursor, bool, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	names, err := w.storage.List(ctx)
+	if err != nil {
+		return c, false, err
+	}
+
+	p := &pager{prefix: prefix, limit: limit, fn: fn}
+	f := &replayFilter{now: w.now()}
+	for _, name := range segmentNames(names) {
+		if compareSegments(name, c.Segment) < 0 {
+			continue
+		}
+		var checksum uint32
+		if i := slices.IndexFunc(w.sealed, func(s SegmentInfo) bool { return s.Name == name }); i >= 0 {
+			checksum = w.sealed[i].Checksum
+		}
+
+		start := Cursor{Segment: name, Checksum: checksum}
+		if name == c.Segment {
+			start.Seq = c.Seq
+			// an active segment sealed since only gained a seal marker
+			if c.Checksum == checksum || c.Checksum == 0 && !w.compacted(name) {
+				start.Offset = c.Offset
+			}
+		}
+		next, full, err := w.pageSegment(ctx, start, f, p)
+		if err != nil {
+			return c, false, err
+		}
+		c = next
+		if full {
+			return c, true, nil
+		}
+	}
+	return c, false, nil
+}
+
+func (w *Journal) compacted(name string) bool {
+	return slices.ContainsFunc(w.sealed, func(s SegmentInfo) bool { return s.Name == name && s.Compacted })
+}
+
+// pager carries one Page across segments.
+type pager struct {
+	prefix  []byte
+	limit   int
+	fn      func(*Entry) error
+	handed  int
+	scanned int
+}
+
+func (p *pager) full() bool {
+	return p.handed >= p.limit || p.scanned >= pageScanMax
+}
+
+// pageSegment reads the segment of c from its offset, falling back to the
+// start if the offset turns out not to be a record boundary. It returns
+// the cursor after the last entry read and whether the page is full.
+func (w *Journal) pageSegment(ctx context.Context, c Cursor, f *replayFilter, p *pager) (Cursor, bool, error) {
+	rc, err := w.storage.Open(ctx, c.Segment)
+	if err != nil {
+		// removed since List, like Replay
+		return c, false, nil
+	}
+	defer rc.Close()
+
+	cr := &countingReader{r: rc}
+	br := bufio.NewReader(cr)
+	r := &segmentReader{j: w, r: br, name: c.Segment, filter: f}
+	pos := func() int64 { return cr.n - int64(br.Buffered()) }
+	// the format is in the header, however far in the cursor is
+	if err := r.readHeader(); err != nil {
+		return c, false, err
+	}
+	if skip := c.Offset - pos(); skip > 0 {
+		if _, err := io.CopyN(io.Discard, br, skip); err != nil {
+			return w.pageSegmentFromStart(ctx, c, f, p)
+		}
+	}
+
+	// Offset stays at the start of an atomic batch until all of it has
+	// been handed out, since resuming mid-batch isn't possible
+	read := false
+	for !p.full() {
+		e, err := r.next()
+		if err == io.EOF || err == errTornBatch {
+			break
+		}
+		if err != nil {
+			if !read && c.Offset > 0 {
+				return w.pageSegmentFromStart(ctx, c, f, p)
+			}
+			return c, false, err
+		}
+		read = true
+		p.scanned++
+		if e.Seq <= c.Seq {
+			continue
+		}
+		c.Seq = e.Seq
+		if r.want == 0 && len(r.pending) == 0 {
+			c.Offset = pos()
+		}
+		if p.prefix != nil && !bytes.HasPrefix(e.Key, p.prefix) {
+			continue
+		}
+		if err := p.fn(e); err != nil {
+			return c, false, err
+		}
+		p.handed++
+	}
+	return c, p.full(), nil
+}
+
+func (w *Journal) pageSegmentFromStart(ctx context.Context, c Cursor, f *replayFilter, p *pager) (Cursor, bool, error) {
+	c.Offset = 0
+	return w.pageSegment(ctx, c, f, p)
+}
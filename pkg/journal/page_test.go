@@ -0,0 +1,146 @@
+package journal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pageAll pages through w limit entries at a time, calling between after
+// every page.
+func pageAll(t *testing.T, w *Journal, prefix []byte, limit int, between func()) []uint64 {
+	t.Helper()
+	var (
+		seqs []uint64
+		c    Cursor
+	)
+	for range 1000 {
+		n := 0
+		next, more, err := w.Page(c, prefix, limit, func(e *Entry) error {
+			seqs = append(seqs, e.Seq)
+			n++
+			return nil
+		})
+		require.NoError(t, err)
+		assert.LessOrEqual(t, n, limit)
+		c = next
+		if !more {
+			return seqs
+		}
+		if between != nil {
+			between()
+		}
+	}
+	t.Fatal("paging didn't end")
+	return nil
+}
+
+func seqRange(from, to uint64) []uint64 {
+	var seqs []uint64
+	for s := from; s <= to; s++ {
+		seqs = append(seqs, s)
+	}
+	return seqs
+}
+
+func TestPage(t *testing.T) {
+	w, err := New(NewMemStorage(), 200, WithAtomicBatches()) // a few entries per segment
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := range 20 {
+		_, err := w.Write(fmt.Appendf(nil, "k%d", i%2), []byte("v"))
+		require.NoError(t, err)
+	}
+	// an atomic batch, which a page can end inside of
+	batch := make([]Entry, 5)
+	for i := range batch {
+		batch[i] = Entry{Key: []byte("k0"), Value: []byte("b")}
+	}
+	_, err = w.WriteBatch(batch)
+	require.NoError(t, err)
+	require.NoError(t, w.Sync())
+
+	for _, limit := range []int{1, 3, 7, 100} {
+		assert.Equal(t, seqRange(1, 25), pageAll(t, w, nil, limit, nil), "limit %d", limit)
+	}
+	var evens []uint64
+	for s := uint64(1); s <= 20; s += 2 {
+		evens = append(evens, s)
+	}
+	assert.Equal(t, append(evens, seqRange(21, 25)...), pageAll(t, w, []byte("k0"), 4, nil))
+
+	// a cursor at the end picks up later writes
+	c := Cursor{}
+	for more := true; more; {
+		c, more, err = w.Page(c, nil, 10, func(*Entry) error { return nil })
+		require.NoError(t, err)
+	}
+	_, err = w.Write([]byte("k1"), []byte("v"))
+	require.NoError(t, err)
+	require.NoError(t, w.Sync())
+	var got []uint64
+	_, _, err = w.Page(c, nil, 10, func(e *Entry) error {
+		got = append(got, e.Seq)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{26}, got)
+}
+
+func TestPageAcrossTruncateAndCompact(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	w, err := New(NewMemStorage(), 200)
+	require.NoError(t, err)
+	defer w.Close()
+	w.now = func() time.Time { return now }
+
+	for i := range 40 {
+		// every third entry expires
+		var expires time.Time
+		if i%3 == 2 {
+			expires = now.Add(time.Minute)
+		}
+		_, err := w.WriteWithExpiry([]byte("k"), []byte("v"), expires)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Sync())
+	all := replayedSeqs(t, w)
+
+	// entries are expired and compacted away from under the cursor: the
+	// rest are still read once each
+	pages := 0
+	seqs := pageAll(t, w, nil, 5, func() {
+		pages++
+		if pages == 2 {
+			now = now.Add(time.Minute)
+			_, err := w.Compact()
+			require.NoError(t, err)
+		}
+	})
+	var want []uint64
+	for _, s := range all {
+		if s > 10 && s%3 == 0 {
+			continue
+		}
+		want = append(want, s)
+	}
+	assert.Equal(t, want, seqs)
+
+	// segments truncated under the cursor are skipped
+	all = replayedSeqs(t, w)
+	pages = 0
+	seqs = pageAll(t, w, nil, 3, func() {
+		pages++
+		if pages == 1 {
+			_, err := w.TruncateBefore(30)
+			require.NoError(t, err)
+		}
+	})
+	assert.Equal(t, all[:3], seqs[:3])
+	assert.Equal(t, uint64(40), seqs[len(seqs)-1])
+	assert.IsIncreasing(t, seqs)
+}
@@ -0,0 +1,32 @@
+package journal
+
+// ReplayProgress is how far a replay has got.
+type ReplayProgress struct {
+	// Entries counts the entries passed to the replay's callback.
+	Entries uint64
+	// Bytes counts what was read from segments, filtered out entries
+	// included.
+	Bytes int64
+	// Segment is the one being read or just read; Done of Segments are
+	// finished, skipped ones included.
+	Segment  string
+	Done     int
+	Segments int
+}
+
+// replayProgressEvery is how many entries a segment is reported every, on
+// top of once per segment.
+const replayProgressEvery = 1 << 16
+
+type ReplayOption func(*replayOptions)
+
+type replayOptions struct {
+	progress func(ReplayProgress)
+}
+
+// WithReplayProgress calls fn as the replay goes: every 65536 entries and
+// after each segment read, the last call covering the whole replay. fn
+// runs in the replay's goroutine, so a slow one slows the replay down.
+func WithReplayProgress(fn func(ReplayProgress)) ReplayOption {
+	return func(o *replayOptions) { o.progress = fn }
+}
@@ -0,0 +1,116 @@
+package journal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayPrefix(t *testing.T) {
+	write := func(t *testing.T, w *Journal) {
+		t.Helper()
+		for _, key := range []string{"sensor_temp{ts=1}", "sensor_humidity{ts=1}", "sensor_temp{ts=2}"} {
+			_, err := w.Write([]byte(key), []byte("value of "+key))
+			require.NoError(t, err)
+		}
+		_, err := w.WriteBatch([]Entry{
+			{Key: []byte("sensor_humidity{ts=3}"), Value: []byte("h")},
+			{Key: []byte("sensor_temp{ts=3}"), Value: []byte("t")},
+		})
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+	}
+
+	collect := func(t *testing.T, w *Journal, prefix string) []string {
+		t.Helper()
+		var got []string
+		require.NoError(t, w.ReplayPrefix([]byte(prefix), func(e *Entry) error {
+			assert.NotEmpty(t, e.Value)
+			got = append(got, string(e.Key))
+			return nil
+		}))
+		return got
+	}
+
+	for name, opts := range map[string][]Option{
+		"plain":  nil,
+		"atomic": {WithAtomicBatches()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w, err := New(NewMemStorage(), 100, opts...)
+			require.NoError(t, err)
+			defer w.Close()
+			write(t, w)
+
+			assert.Equal(t, []string{"sensor_temp{ts=1}", "sensor_temp{ts=2}", "sensor_temp{ts=3}"}, collect(t, w, "sensor_temp"))
+			assert.Len(t, collect(t, w, ""), 5, "empty prefix matches everything")
+			assert.Empty(t, collect(t, w, "sensor_pressure"))
+		})
+	}
+}
+
+func TestReplayAfter(t *testing.T) {
+	w, err := New(NewMemStorage(), 60) // a few entries per segment
+	require.NoError(t, err)
+	defer w.Close()
+	for i := range 10 {
+		_, err := w.Write([]byte{'k', byte('0' + i)}, []byte("value"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Sync())
+	require.Greater(t, len(w.sealed), 1)
+
+	collect := func(after uint64) []uint64 {
+		var got []uint64
+		require.NoError(t, w.ReplayAfter(after, func(e *Entry) error {
+			got = append(got, e.Seq)
+			return nil
+		}))
+		return got
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, collect(0))
+	assert.Equal(t, []uint64{8, 9, 10}, collect(7))
+	assert.Empty(t, collect(10))
+	assert.Empty(t, w.Gaps(), "skipped segments aren't gaps")
+}
+
+func TestReplayProgress(t *testing.T) {
+	w, err := New(NewMemStorage(), 100)
+	require.NoError(t, err)
+	defer w.Close()
+	for range 20 {
+		_, err := w.Write([]byte("never"), []byte("gonna give you up"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Sync())
+
+	var reports []ReplayProgress
+	require.NoError(t, w.ReplayCtx(context.Background(), func(*Entry) error { return nil },
+		WithReplayProgress(func(p ReplayProgress) { reports = append(reports, p) })))
+
+	require.NotEmpty(t, reports)
+	last := reports[len(reports)-1]
+	assert.Greater(t, last.Segments, 2)
+	assert.Equal(t, last.Segments, last.Done)
+	assert.Len(t, reports, last.Segments, "one report per segment")
+	assert.EqualValues(t, 20, last.Entries)
+	assert.Equal(t, w.Size(), last.Bytes)
+	for i := 1; i < len(reports); i++ {
+		assert.Greater(t, reports[i].Bytes, reports[i-1].Bytes)
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var n int
+		err := w.ReplayCtx(ctx, func(*Entry) error {
+			if n++; n == 5 {
+				cancel()
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 5, n)
+	})
+}
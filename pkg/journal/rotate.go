@@ -0,0 +1,25 @@
+package journal
+
+import "context"
+
+// Rotate seals the active segment and starts a new one, so that everything
+// written so far is in sealed segments, e.g. for a snapshot of the
+// directory. It returns the sealed segment as recorded in the manifest.
+// With nothing written to the active segment it changes nothing and
+// returns the last sealed segment, or the zero SegmentInfo if there's none.
+func (w *Journal) Rotate() (SegmentInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.segLast == 0 {
+		if len(w.sealed) == 0 {
+			return SegmentInfo{}, nil
+		}
+		return w.sealed[len(w.sealed)-1], nil
+	}
+	if err := w.newSegment(context.Background()); err != nil {
+		return SegmentInfo{}, err
+	}
+	rotatedSegments.Inc()
+	return w.sealed[len(w.sealed)-1], nil
+}
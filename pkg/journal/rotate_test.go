@@ -0,0 +1,44 @@
+package journal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotate(t *testing.T) {
+	s := NewMemStorage()
+	w, err := New(s, 1<<20)
+	require.NoError(t, err)
+
+	info, err := w.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, SegmentInfo{}, info)
+
+	for range 3 {
+		_, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+	}
+	info, err = w.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, segmentName(1), info.Name)
+	assert.Equal(t, uint64(1), info.FirstSeq)
+	assert.Equal(t, uint64(3), info.LastSeq)
+	assert.Equal(t, []SegmentInfo{info}, w.sealed)
+
+	// nothing new to seal
+	again, err := w.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, info, again)
+
+	_, err = w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	w, err = New(s, 1<<20)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, []uint64{1, 2, 3, 4}, replayedSeqs(t, w))
+	assert.Equal(t, segmentName(1), w.sealed[0].Name)
+}
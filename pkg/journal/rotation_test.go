@@ -0,0 +1,114 @@
+package journal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentCommittedOnFirstEntry(t *testing.T) {
+	s := NewMemStorage()
+	w, err := New(s, 100)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{segmentName(1) + tmpSuffix}, segmentFiles(t, s), "empty segment keeps its temporary name")
+
+	_, err = w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{segmentName(1)}, segmentFiles(t, s))
+
+	for range 10 {
+		_, err = w.Write([]byte("k"), []byte("rotate me please"))
+		require.NoError(t, err)
+	}
+	for _, name := range segmentFiles(t, s) {
+		assert.NotContains(t, name, tmpSuffix)
+	}
+	require.NoError(t, w.Close())
+}
+
+func TestEmptySegmentRemovedOnClose(t *testing.T) {
+	s := NewMemStorage()
+	w, err := New(s, 1024)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.Empty(t, segmentFiles(t, s))
+}
+
+func TestUncommittedSegmentRecovery(t *testing.T) {
+	t.Run("empty orphan is removed", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+		_, err := s.Create(context.Background(), segmentName(99)+tmpSuffix)
+		require.NoError(t, err)
+
+		w, err := New(s, 100)
+		require.NoError(t, err)
+		defer w.Close()
+
+		seq, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(21), seq)
+		assert.NotContains(t, segmentFiles(t, s), segmentName(99)+tmpSuffix)
+	})
+
+	t.Run("orphan with entries is promoted", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+
+		// crash after fsyncing the first entry but before the rename
+		segs := segmentNames(mustList(t, s))
+		active := segs[len(segs)-1]
+		require.NoError(t, s.Rename(context.Background(), active, active+tmpSuffix))
+
+		w, err := New(s, 100)
+		require.NoError(t, err)
+		defer w.Close()
+
+		assert.Contains(t, segmentFiles(t, s), active)
+		assert.Len(t, replayAll(t, w), 20)
+	})
+}
+
+func TestFileStorageRotation(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	w, err := New(fs, 100)
+	require.NoError(t, err)
+	for range 20 {
+		_, err := w.Write([]byte("never"), []byte("gonna let you down"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	tmps, err := filepath.Glob(filepath.Join(dir, "*"+tmpSuffix))
+	require.NoError(t, err)
+	assert.Empty(t, tmps)
+
+	// leftover from a crash mid-rotation
+	require.NoError(t, os.WriteFile(filepath.Join(dir, segmentName(50)+tmpSuffix), nil, 0644))
+
+	w, err = New(fs, 100)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Len(t, replayAll(t, w), 20)
+}
+
+func segmentFiles(t *testing.T, s Storage) []string {
+	t.Helper()
+	var segs []string
+	for _, name := range mustList(t, s) {
+		if name != manifestName {
+			segs = append(segs, name)
+		}
+	}
+	return segs
+}
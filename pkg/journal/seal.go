@@ -0,0 +1,65 @@
+package journal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// A segment is sealed on rotation by a final marker record with sequence
+// number 0, like batch markers, whose value holds the segment's last
+// sequence number, its entry count and the CRC32 of every byte before the
+// marker. A reopened journal never appends to a sealed segment, even one
+// the manifest doesn't know about, e.g. after restoring segments from a
+// backup; it starts a new one instead. Records after a seal mean something
+// else wrote to the segment, and reading it fails with ErrSealedSegment.
+// Segments sealed before markers existed are recognized by the manifest
+// alone.
+var sealMarkerKey = []byte("\x00seal")
+
+type segmentSeal struct {
+	lastSeq  uint64
+	count    uint32
+	checksum uint32
+}
+
+func (s segmentSeal) marshal() []byte {
+	b := binary.BigEndian.AppendUint64(nil, s.lastSeq)
+	b = binary.BigEndian.AppendUint32(b, s.count)
+	return binary.BigEndian.AppendUint32(b, s.checksum)
+}
+
+func parseSeal(v []byte) (segmentSeal, error) {
+	if len(v) != 16 {
+		return segmentSeal{}, ErrBadChecksum
+	}
+	return segmentSeal{
+		lastSeq:  binary.BigEndian.Uint64(v),
+		count:    binary.BigEndian.Uint32(v[8:]),
+		checksum: binary.BigEndian.Uint32(v[12:]),
+	}, nil
+}
+
+// seal ends the active segment with its seal marker.
+func (w *Journal) seal() error {
+	s := segmentSeal{lastSeq: w.segLast, count: w.segCount, checksum: w.segCRC}
+	rec, err := w.encode(&Entry{Key: sealMarkerKey, Value: s.marshal()}, w.current, w.segFormat)
+	if err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(rec); err != nil {
+		return err
+	}
+	w.size += int64(len(rec))
+	w.segCRC = crc32.Update(w.segCRC, w.segFormat.crcTable(), rec)
+	return nil
+}
+
+// checkSeal compares what inspect read of a segment with its seal.
+func checkSeal(info SegmentInfo, count uint32, s *segmentSeal) error {
+	if s.lastSeq != info.LastSeq || s.count != count {
+		return fmt.Errorf("%w: segment %s sealed at seq %d with %d entries, holds %d up to seq %d",
+			ErrSealMismatch, info.Name, s.lastSeq, s.count, count, info.LastSeq)
+	}
+	return nil
+}
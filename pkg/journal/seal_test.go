@@ -0,0 +1,102 @@
+package journal
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentSeal(t *testing.T) {
+	t.Run("rotation seals the segment", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+
+		w, err := New(s, 100)
+		require.NoError(t, err)
+		defer w.Close()
+		require.NotEmpty(t, w.sealed)
+		for _, want := range w.sealed {
+			info, err := w.inspect(context.Background(), want.Name)
+			require.NoError(t, err)
+			assert.True(t, info.sealed, want.Name)
+			assert.Equal(t, want.Size, info.Size)
+			assert.Equal(t, want.Checksum, info.Checksum)
+			assert.Equal(t, want.LastSeq, info.LastSeq)
+		}
+		assert.Len(t, replayedSeqs(t, w), 20)
+	})
+
+	t.Run("restored sealed segment is not appended to", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+		// a restore that brought back the first two segments only
+		segs := segmentFiles(t, s)
+		slices.Sort(segs)
+		for _, name := range append(segs[2:], manifestName) {
+			require.NoError(t, s.Remove(context.Background(), name))
+		}
+		size, err := s.Size(context.Background(), segs[1])
+		require.NoError(t, err)
+
+		w, err := New(s, 100)
+		require.NoError(t, err)
+		defer w.Close()
+		_, err = w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+
+		after, err := s.Size(context.Background(), segs[1])
+		require.NoError(t, err)
+		assert.Equal(t, size, after)
+		assert.Contains(t, segmentFiles(t, s), segmentName(3))
+	})
+
+	t.Run("records after the seal", func(t *testing.T) {
+		s := NewMemStorage()
+		rotated(t, s, 20)
+		segs := segmentFiles(t, s)
+		slices.Sort(segs)
+		for _, name := range append(segs[2:], manifestName) {
+			require.NoError(t, s.Remove(context.Background(), name))
+		}
+
+		w := &Journal{}
+		rec, err := w.encode(&Entry{Key: []byte("k"), Value: []byte("v"), Seq: 99}, segs[1], FormatBinary.withChecksum(ChecksumCRC32C))
+		require.NoError(t, err)
+		wc, _, err := s.OpenAppend(context.Background(), segs[1])
+		require.NoError(t, err)
+		_, err = wc.Write(rec)
+		require.NoError(t, err)
+		require.NoError(t, wc.Close())
+
+		_, err = New(s, 100)
+		assert.ErrorIs(t, err, ErrSealedSegment)
+	})
+
+	t.Run("compaction reseals", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		w, err := New(NewMemStorage(), 100)
+		require.NoError(t, err)
+		defer w.Close()
+		w.now = func() time.Time { return now }
+		for i := range 20 {
+			expires := time.Time{}
+			if i%2 == 1 {
+				expires = now.Add(-time.Minute)
+			}
+			_, err := w.WriteWithExpiry([]byte("never"), []byte("gonna give you up"), expires)
+			require.NoError(t, err)
+		}
+
+		reclaimed, err := w.Compact()
+		require.NoError(t, err)
+		require.Positive(t, reclaimed)
+		info, err := w.inspect(context.Background(), w.sealed[0].Name)
+		require.NoError(t, err)
+		assert.True(t, info.sealed)
+		assert.Equal(t, w.sealed[0].Checksum, info.Checksum)
+	})
+}
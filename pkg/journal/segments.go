@@ -0,0 +1,59 @@
+package journal
+
+import "time"
+
+// SegmentStats describes a live segment, sealed or active. Fields the
+// manifest doesn't know for segments sealed before it recorded them are
+// nil or zero and left out of JSON.
+type SegmentStats struct {
+	Name     string `json:"name"`
+	Bytes    int64  `json:"bytes"`
+	FirstSeq uint64 `json:"first_seq"`
+	LastSeq  uint64 `json:"last_seq"`
+	// Entries leaves out batch and seal markers.
+	Entries   *uint32   `json:"entries,omitempty"`
+	Created   time.Time `json:"created,omitzero"`
+	Sealed    time.Time `json:"sealed,omitzero"`
+	Active    bool      `json:"active,omitempty"`
+	Encrypted *bool     `json:"encrypted,omitempty"`
+	Compacted bool      `json:"compacted,omitempty"`
+}
+
+// Segments returns the live segments in sequence order, the active one
+// last. Removed segments aren't listed.
+func (w *Journal) Segments() []SegmentStats {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	out := make([]SegmentStats, 0, len(w.sealed)+1)
+	for _, s := range w.sealed {
+		st := SegmentStats{
+			Name:      s.Name,
+			Bytes:     s.Size,
+			FirstSeq:  s.FirstSeq,
+			LastSeq:   s.LastSeq,
+			Created:   s.Created,
+			Sealed:    s.SealedAt,
+			Compacted: s.Compacted,
+		}
+		if !s.SealedAt.IsZero() {
+			entries, encrypted := s.Entries, s.Encrypted
+			st.Entries, st.Encrypted = &entries, &encrypted
+		}
+		out = append(out, st)
+	}
+	if w.current != "" {
+		entries, encrypted := w.segCount, w.encryptor != nil
+		out = append(out, SegmentStats{
+			Name:      w.current,
+			Bytes:     w.size,
+			FirstSeq:  w.segFirst,
+			LastSeq:   w.segLast,
+			Entries:   &entries,
+			Created:   w.segCreated,
+			Active:    true,
+			Encrypted: &encrypted,
+		})
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package journal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegments(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0).UTC()
+	s := NewMemStorage()
+	w, err := New(s, 1<<20)
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+
+	for range 3 {
+		_, err := w.Write([]byte("temp"), []byte("v"))
+		require.NoError(t, err)
+	}
+	_, err = w.Rotate()
+	require.NoError(t, err)
+
+	created := now
+	now = now.Add(time.Minute)
+	_, err = w.Write([]byte("temp"), []byte("v"))
+	require.NoError(t, err)
+	_, err = w.WriteWithExpiry([]byte("temp"), []byte("v"), now.Add(time.Second))
+	require.NoError(t, err)
+	_, err = w.Rotate()
+	require.NoError(t, err)
+	_, err = w.Write([]byte("temp"), []byte("v"))
+	require.NoError(t, err)
+
+	segs := w.Segments()
+	require.Len(t, segs, 3)
+
+	first := segs[0]
+	assert.Equal(t, uint64(1), first.FirstSeq)
+	assert.Equal(t, uint64(3), first.LastSeq)
+	require.NotNil(t, first.Entries)
+	assert.Equal(t, uint32(3), *first.Entries)
+	assert.Equal(t, now.Add(-time.Minute), first.Sealed)
+	assert.False(t, first.Active)
+	require.NotNil(t, first.Encrypted)
+	assert.False(t, *first.Encrypted)
+
+	second := segs[1]
+	assert.Equal(t, created, second.Created)
+	assert.Equal(t, now, second.Sealed)
+	assert.Equal(t, uint32(2), *second.Entries)
+	assert.Equal(t, uint64(4), second.FirstSeq)
+	assert.Equal(t, uint64(5), second.LastSeq)
+
+	active := segs[2]
+	assert.True(t, active.Active)
+	assert.Equal(t, now, active.Created)
+	assert.True(t, active.Sealed.IsZero())
+	assert.Equal(t, uint32(1), *active.Entries)
+	assert.Equal(t, uint64(6), active.LastSeq)
+
+	var total int64
+	for _, seg := range segs {
+		total += seg.Bytes
+	}
+	assert.Equal(t, w.Size(), total)
+
+	t.Run("compaction counts what's left", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		_, err := w.Compact()
+		require.NoError(t, err)
+		seg := w.Segments()[1]
+		assert.True(t, seg.Compacted)
+		assert.Equal(t, uint32(1), *seg.Entries)
+	})
+
+	t.Run("kept across reopen", func(t *testing.T) {
+		want := w.Segments()[:2]
+		require.NoError(t, w.Close())
+		w, err := New(s, 1<<20)
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Equal(t, want, w.Segments()[:2])
+	})
+
+	t.Run("unknown for segments sealed before", func(t *testing.T) {
+		w := &Journal{sealed: []SegmentInfo{{Name: "000001.wal", Size: 100, FirstSeq: 1, LastSeq: 9}}}
+		segs := w.Segments()
+		require.Len(t, segs, 1)
+		assert.Nil(t, segs[0].Entries)
+		assert.Nil(t, segs[0].Encrypted)
+		assert.True(t, segs[0].Created.IsZero())
+	})
+}
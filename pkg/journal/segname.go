@@ -0,0 +1,86 @@
+package journal
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const segmentSuffix = ".wal"
+
+// segmentName is the file name of segment n: 16 zero-padded digits, so
+// names sort like IDs for longer than any journal will run.
+func segmentName(n uint64) string {
+	return fmt.Sprintf("%016d%s", n, segmentSuffix)
+}
+
+// legacyDigits is the width of segment names before they were widened to
+// 64-bit IDs. Such segments keep their names, which encrypted records are
+// bound to, and are ordered by ID with the rest.
+const legacyDigits = 6
+
+// parseSegmentName returns the ID of a segment named by segmentName, or in
+// the legacy 6-digit form. Anything else, including IDs written with other
+// widths or signs, isn't a segment.
+func parseSegmentName(name string) (uint64, bool) {
+	stem, ok := strings.CutSuffix(name, segmentSuffix)
+	if !ok || len(stem) < legacyDigits || strings.Trim(stem, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(stem, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(stem) == legacyDigits || segmentName(n) == name {
+		return n, true
+	}
+	return 0, false
+}
+
+// checkSegmentNames fails on files named like segments that parseSegmentName
+// rejects, rather than leaving them out of replays unnoticed.
+func checkSegmentNames(names []string) error {
+	for _, name := range names {
+		if _, ok := parseSegmentName(name); !ok && strings.HasSuffix(name, segmentSuffix) {
+			return fmt.Errorf("%w: %s", ErrUnknownSegment, name)
+		}
+	}
+	return nil
+}
+
+// segmentNames filters non-segment files out of a storage listing and
+// returns the rest in replay order.
+func segmentNames(names []string) []string {
+	segs := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := parseSegmentName(name); ok {
+			segs = append(segs, name)
+		}
+	}
+	slices.SortFunc(segs, compareSegments)
+	return segs
+}
+
+// compareSegments orders segment names by ID; a name that isn't a segment,
+// such as the empty Cursor's, sorts first.
+func compareSegments(a, b string) int {
+	na, _ := parseSegmentName(a)
+	nb, _ := parseSegmentName(b)
+	switch {
+	case na < nb:
+		return -1
+	case na > nb:
+		return 1
+	}
+	return 0
+}
+
+// nextSegment returns the ID after n.
+func nextSegment(n uint64) (uint64, error) {
+	if n == math.MaxUint64 {
+		return 0, ErrSegmentIDsExhausted
+	}
+	return n + 1, nil
+}
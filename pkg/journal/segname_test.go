@@ -0,0 +1,95 @@
+package journal
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSegmentName(t *testing.T) {
+	for name, want := range map[string]uint64{
+		"0000000000000001.wal":     1,
+		"000042.wal":               42,
+		"999999.wal":               999999,
+		"18446744073709551615.wal": math.MaxUint64,
+	} {
+		n, ok := parseSegmentName(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, n, name)
+	}
+	for _, name := range []string{
+		"1.wal", "0000042.wal", "00000000000000001.wal", "+00042.wal",
+		"18446744073709551616.wal", "backup.wal", "000001.wal.tmp", "MANIFEST", ".wal",
+	} {
+		_, ok := parseSegmentName(name)
+		assert.False(t, ok, name)
+	}
+
+	// IDs sort numerically across both widths
+	assert.Equal(t,
+		[]string{"000009.wal", "000010.wal", segmentName(11), segmentName(100)},
+		segmentNames([]string{segmentName(100), "MANIFEST", "000010.wal", segmentName(11), "000009.wal"}))
+}
+
+func TestLegacySegmentNames(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+	// as written before IDs were widened
+	for _, name := range segmentFiles(t, s) {
+		n, _ := parseSegmentName(name)
+		s.files[fmt.Sprintf("%06d.wal", n)] = s.files[name]
+		delete(s.files, name)
+	}
+	delete(s.files, manifestName)
+	legacy := segmentNames(segmentFiles(t, s))
+
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	for range 20 {
+		_, err := w.Write([]byte("never"), []byte("gonna let you down"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	// new segments carry on from the last legacy ID, which keeps its name
+	files := segmentNames(segmentFiles(t, s))
+	assert.Equal(t, legacy, files[:len(legacy)])
+	last, _ := parseSegmentName(legacy[len(legacy)-1])
+	assert.Equal(t, segmentName(last+1), files[len(legacy)])
+
+	w, err = New(s, 100)
+	require.NoError(t, err)
+	defer w.Close()
+	seqs := replayedSeqs(t, w)
+	require.Len(t, seqs, 40)
+	for i, seq := range seqs {
+		assert.Equal(t, uint64(i+1), seq)
+	}
+}
+
+func TestUnknownSegmentFile(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+	s.files["1.wal"] = s.files[segmentName(1)]
+
+	_, err := New(s, 100)
+	assert.ErrorIs(t, err, ErrUnknownSegment)
+	assert.ErrorContains(t, err, "1.wal")
+}
+
+func TestSegmentIDsExhausted(t *testing.T) {
+	w, err := New(NewMemStorage(), 100)
+	require.NoError(t, err)
+	defer w.Close()
+	w.segment = math.MaxUint64 - 1
+
+	for {
+		if _, err = w.Write([]byte("never"), []byte("gonna run around")); err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, ErrSegmentIDsExhausted)
+}
@@ -0,0 +1,14 @@
+package journal
+
+// Size is the bytes the journal's segments take: the sealed ones as the
+// manifest records them and the active one as written so far, buffered
+// bytes included. The manifest itself isn't counted.
+func (w *Journal) Size() int64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	n := w.size
+	for _, s := range w.sealed {
+		n += s.Size
+	}
+	return n
+}
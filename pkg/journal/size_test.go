@@ -0,0 +1,27 @@
+package journal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSize(t *testing.T) {
+	w, err := New(NewMemStorage(), 100)
+	require.NoError(t, err)
+	defer w.Close()
+
+	empty := w.Size()
+	for range 20 {
+		_, err := w.Write([]byte("never"), []byte("gonna give you up"))
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(w.sealed), 2)
+	full := w.Size()
+	assert.Greater(t, full, empty)
+
+	reclaimed, err := w.TruncateBefore(w.sealed[1].LastSeq + 1)
+	require.NoError(t, err)
+	assert.Equal(t, full-reclaimed, w.Size())
+}
@@ -0,0 +1,163 @@
+package journal
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorageLifecycle runs the file operations compaction, truncation and
+// rotation rely on against both backends.
+func TestStorageLifecycle(t *testing.T) {
+	backends := map[string]func(t *testing.T) Storage{
+		"mem": func(*testing.T) Storage { return NewMemStorage() },
+		"file": func(t *testing.T) Storage {
+			fs, err := NewFileStorage(t.TempDir())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = fs.Close() })
+			return fs
+		},
+	}
+
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := newStorage(t)
+
+			wc, err := s.Create(context.Background(), "a.tmp")
+			require.NoError(t, err)
+			_, err = wc.Write([]byte("hello"))
+			require.NoError(t, err)
+			require.NoError(t, wc.Close())
+
+			_, err = s.Create(context.Background(), "a.tmp")
+			assert.ErrorIs(t, err, fs.ErrExist)
+
+			require.NoError(t, s.Rename(context.Background(), "a.tmp", "a"))
+			names, err := s.List(context.Background())
+			require.NoError(t, err)
+			assert.NotContains(t, names, "a.tmp")
+			assert.Contains(t, names, "a")
+
+			rc, err := s.Open(context.Background(), "a")
+			require.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			assert.Equal(t, "hello", string(data))
+
+			assert.ErrorIs(t, s.Rename(context.Background(), "a.tmp", "b"), fs.ErrNotExist)
+
+			require.NoError(t, s.Remove(context.Background(), "a"))
+			_, err = s.Size(context.Background(), "a")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+			assert.ErrorIs(t, s.Remove(context.Background(), "a"), fs.ErrNotExist)
+			_, err = s.Open(context.Background(), "a")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+			_, _, err = s.OpenAppend(context.Background(), "a")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+}
+
+// hangingSync is storage on a mount that stopped answering: Sync blocks
+// until its context is done.
+type hangingSync struct {
+	*MemStorage
+	hang    bool
+	syncing chan struct{}
+}
+
+func (h *hangingSync) Sync(ctx context.Context, name string) error {
+	if !h.hang {
+		return h.MemStorage.Sync(ctx, name)
+	}
+	h.syncing <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStorageContext(t *testing.T) {
+	t.Run("a hung sync gives up with its caller", func(t *testing.T) {
+		s := &hangingSync{MemStorage: NewMemStorage(), syncing: make(chan struct{}, 1)}
+		w, err := New(s, 1<<20, WithAtomicBatches())
+		require.NoError(t, err)
+		s.hang = true
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			_, err := w.WriteBatchCtx(ctx, []Entry{{Key: []byte("k")}})
+			done <- err
+		}()
+		<-s.syncing
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+
+		// the lock is free again, and closing doesn't wait on the mount
+		// for longer than it's given
+		closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		go func() { <-s.syncing }()
+		require.NoError(t, w.CloseCtx(closeCtx))
+	})
+
+	t.Run("done before the write", func(t *testing.T) {
+		w, err := New(NewMemStorage(), 1<<20)
+		require.NoError(t, err)
+		defer w.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = w.WriteCtx(ctx, []byte("k"), []byte("v"))
+		assert.ErrorIs(t, err, context.Canceled)
+		_, err = w.WriteBatchCtx(ctx, []Entry{{Key: []byte("k")}})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		// no sequence number was used up
+		seq, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), seq)
+	})
+
+	t.Run("replay stops", func(t *testing.T) {
+		w, err := New(NewMemStorage(), 100)
+		require.NoError(t, err)
+		defer w.Close()
+		for range 20 {
+			_, err := w.Write([]byte("k"), []byte("value"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Sync())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var n int
+		err = w.ReplayCtx(ctx, func(e *Entry) error {
+			n++
+			if n == 5 {
+				cancel()
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 5, n)
+	})
+
+	t.Run("file storage doesn't start", func(t *testing.T) {
+		fs, err := NewFileStorage(t.TempDir())
+		require.NoError(t, err)
+		defer fs.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = fs.Create(ctx, "a")
+		assert.ErrorIs(t, err, context.Canceled)
+		_, err = fs.List(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
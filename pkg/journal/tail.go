@@ -0,0 +1,104 @@
+package journal
+
+import (
+	"context"
+)
+
+// tailPage is how many entries Tail reads under the read lock before
+// handing them to fn without it.
+const tailPage = 256
+
+// Tail calls fn for every unexpired entry from fromSeq on, in journal
+// order, then keeps waiting for new ones and calls fn as they're written,
+// until ctx is done, the journal is closed or fn fails. It returns that
+// error: ctx.Err(), ErrJournalClosed or fn's. Entries are delivered once
+// a write returns, before they're fsynced, the same as Lookup sees them;
+// entries already truncated or compacted away are skipped, like in Replay.
+//
+// fn runs without any lock held, so a slow consumer holds up neither
+// writers nor other tails, it only falls behind.
+func (w *Journal) Tail(ctx context.Context, fromSeq uint64, fn func(*Entry) error) error {
+	w.mu.RLock()
+	c := w.seekCursor(fromSeq)
+	w.mu.RUnlock()
+
+	batch := make([]*Entry, 0, tailPage)
+	for {
+		// taken before reading, so a write after the last page wakes it
+		written, err := w.tailSignal()
+		if err != nil {
+			return err
+		}
+		for more := true; more; {
+			batch = batch[:0]
+			c, more, err = w.page(ctx, c, nil, tailPage, func(e *Entry) error {
+				if e.Seq >= fromSeq {
+					batch = append(batch, e)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, e := range batch {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-written:
+		}
+	}
+}
+
+// LastSeq is the sequence number of the latest entry written, 0 if there
+// is none yet. Tail from LastSeq()+1 delivers only what comes after.
+func (w *Journal) LastSeq() uint64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.seq
+}
+
+// tailSignal writes out the write buffer, so Page sees everything written
+// so far, and returns a channel closed by the next write.
+func (w *Journal) tailSignal() (<-chan struct{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrJournalClosed
+	}
+	if err := w.writer.Flush(); err != nil {
+		return nil, err
+	}
+	if w.written == nil {
+		w.written = make(chan struct{})
+	}
+	return w.written, nil
+}
+
+// wakeTails wakes the Tails waiting for a write. Callers hold w.mu.
+func (w *Journal) wakeTails() {
+	if w.written != nil {
+		close(w.written)
+		w.written = nil
+	}
+}
+
+// seekCursor is a cursor on the last sealed segment that could hold seq,
+// or the start of the journal if none can. Callers hold w.mu.
+func (w *Journal) seekCursor(seq uint64) Cursor {
+	var c Cursor
+	for _, s := range w.sealed {
+		if !s.Removed && s.LastSeq != 0 && s.FirstSeq <= seq {
+			c.Segment = s.Name
+		}
+	}
+	return c
+}
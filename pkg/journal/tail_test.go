@@ -0,0 +1,104 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTail(t *testing.T) {
+	write := func(t *testing.T, w *Journal, n int) {
+		t.Helper()
+		for range n {
+			_, err := w.Write([]byte("k"), []byte("value"))
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("existing entries, then new ones", func(t *testing.T) {
+		w, err := New(NewMemStorage(), 100)
+		require.NoError(t, err)
+		defer w.Close()
+		write(t, w, 10)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		seqs := make(chan uint64, 100)
+		done := make(chan error, 1)
+		go func() {
+			done <- w.Tail(ctx, 4, func(e *Entry) error {
+				seqs <- e.Seq
+				return nil
+			})
+		}()
+
+		next := func() uint64 {
+			select {
+			case seq := <-seqs:
+				return seq
+			case <-time.After(5 * time.Second):
+				t.Fatal("no entry from Tail")
+				return 0
+			}
+		}
+		for want := uint64(4); want <= 10; want++ {
+			assert.Equal(t, want, next())
+		}
+		// rotates through a few segments on the way
+		write(t, w, 10)
+		for want := uint64(11); want <= 20; want++ {
+			assert.Equal(t, want, next())
+		}
+		_, err = w.WriteBatch([]Entry{{Key: []byte("a")}, {Key: []byte("b")}})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(21), next())
+		assert.Equal(t, uint64(22), next())
+
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assert.Empty(t, seqs)
+	})
+
+	t.Run("closing the journal ends it", func(t *testing.T) {
+		w, err := New(NewMemStorage(), 0)
+		require.NoError(t, err)
+		write(t, w, 3)
+
+		done := make(chan error, 1)
+		var n int
+		go func() {
+			done <- w.Tail(context.Background(), 0, func(e *Entry) error {
+				n++
+				return nil
+			})
+		}()
+		require.Eventually(t, func() bool {
+			w.mu.RLock()
+			defer w.mu.RUnlock()
+			return w.written != nil
+		}, 5*time.Second, time.Millisecond)
+		require.NoError(t, w.Close())
+		assert.ErrorIs(t, <-done, ErrJournalClosed)
+		assert.Equal(t, 3, n)
+	})
+
+	t.Run("fn fails", func(t *testing.T) {
+		w, err := New(NewMemStorage(), 0)
+		require.NoError(t, err)
+		defer w.Close()
+		write(t, w, 3)
+
+		stop := errors.New("stop")
+		err = w.Tail(context.Background(), 0, func(e *Entry) error {
+			if e.Seq == 2 {
+				return stop
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, stop)
+	})
+}
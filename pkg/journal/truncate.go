@@ -0,0 +1,36 @@
+package journal
+
+import "context"
+
+// TruncateBefore removes sealed segments whose entries all precede seq, for
+// use once downstream consumers have acknowledged everything below it. The
+// active segment is never removed. Returns the number of bytes reclaimed.
+func (w *Journal) TruncateBefore(seq uint64) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ctx := context.Background()
+	var reclaimed int64
+	for len(w.sealed) > 0 && w.sealed[0].LastSeq < seq {
+		info := w.sealed[0]
+
+		// tombstone first, so a crash before Remove is finished on reopen
+		tomb := info
+		tomb.Removed = true
+		if err := w.writeManifest(ctx, tomb); err != nil {
+			return reclaimed, err
+		}
+		w.sealed = w.sealed[1:]
+		w.removed = append(w.removed, info)
+
+		if err := w.storage.Remove(ctx, info.Name); err != nil {
+			return reclaimed, err
+		}
+
+		reclaimed += info.Size
+		truncatedSegments.Inc()
+		reclaimedBytes.AddInt64(info.Size)
+	}
+
+	return reclaimed, nil
+}
@@ -0,0 +1,116 @@
+package journal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateBefore(t *testing.T) {
+	s := NewMemStorage()
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	for range 20 {
+		_, err := w.Write([]byte("never"), []byte("gonna give you up"))
+		require.NoError(t, err)
+	}
+
+	require.Greater(t, len(w.sealed), 2)
+	cut := w.sealed[1].LastSeq + 1
+	want := w.sealed[0].Size + w.sealed[1].Size
+	first, second := w.sealed[0].Name, w.sealed[1].Name
+
+	reclaimed, err := w.TruncateBefore(cut)
+	require.NoError(t, err)
+	assert.Equal(t, want, reclaimed)
+
+	segs := segmentNames(mustList(t, s))
+	assert.NotContains(t, segs, first)
+	assert.NotContains(t, segs, second)
+
+	require.NoError(t, w.Sync())
+	var seqs []uint64
+	require.NoError(t, w.Replay(func(e *Entry) error {
+		seqs = append(seqs, e.Seq)
+		return nil
+	}))
+	assert.Equal(t, cut, seqs[0])
+	assert.Equal(t, uint64(20), seqs[len(seqs)-1])
+	require.NoError(t, w.Close())
+
+	// manifest tombstones keep verification happy
+	w, err = New(s, 100, WithChecksumVerification())
+	require.NoError(t, err)
+	defer w.Close()
+
+	seq, err := w.Write([]byte("k"), []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(21), seq)
+}
+
+func TestTruncateBeforeKeepsActiveSegment(t *testing.T) {
+	s := NewMemStorage()
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	defer w.Close()
+
+	for range 20 {
+		_, err := w.Write([]byte("never"), []byte("gonna give you up"))
+		require.NoError(t, err)
+	}
+
+	_, err = w.TruncateBefore(1000)
+	require.NoError(t, err)
+	assert.Empty(t, w.sealed)
+	assert.Equal(t, []string{w.current}, segmentNames(mustList(t, s)))
+}
+
+func TestTruncateEverythingThenReopen(t *testing.T) {
+	s := NewMemStorage()
+	w, err := New(s, 1<<20)
+	require.NoError(t, err)
+	for range 3 {
+		_, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+	}
+	last, current := uint64(3), w.current
+	_, err = w.Rotate()
+	require.NoError(t, err)
+	_, err = w.TruncateBefore(100)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	for range 2 {
+		w, err = New(s, 1<<20)
+		require.NoError(t, err)
+		seq, err := w.Write([]byte("k"), []byte("v"))
+		require.NoError(t, err)
+		assert.Equal(t, last+1, seq, "seqs of truncated segments aren't reused")
+		assert.Greater(t, w.current, current, "nor are their segment IDs")
+		last, current = seq, w.current
+
+		_, err = w.Rotate()
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+}
+
+func TestTruncateInterruptedIsFinishedOnOpen(t *testing.T) {
+	s := NewMemStorage()
+	rotated(t, s, 20)
+
+	w, err := New(s, 100)
+	require.NoError(t, err)
+	victim := w.sealed[0]
+	tomb := victim
+	tomb.Removed = true
+	require.NoError(t, w.writeManifest(context.Background(), tomb))
+	require.NoError(t, w.Close())
+
+	w, err = New(s, 100)
+	require.NoError(t, err)
+	defer w.Close()
+	assert.NotContains(t, segmentNames(mustList(t, s)), victim.Name)
+}
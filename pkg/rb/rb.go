@@ -1,27 +1,64 @@
 package rb
 
 import (
+	"context"
+	"errors"
 	"iter"
 	"sync"
 )
 
+var ErrFull = errors.New("ring buffer full")
+
+// Mode decides what Put does when the buffer is full.
+type Mode int
+
+const (
+	// Evict overwrites the oldest value, as Add does.
+	Evict Mode = iota
+	// Reject fails with ErrFull.
+	Reject
+	// Block waits for Drain or Reset to make room.
+	Block
+)
+
+type Option func(*config)
+
+type config struct {
+	mode Mode
+}
+
+func WithMode(m Mode) Option {
+	return func(c *config) { c.mode = m }
+}
+
 type RingBuffer[T any] struct {
-	mu  sync.RWMutex
-	buf []T
-	pos int // write pos
-	len int // slots used
+	mu    sync.RWMutex
+	buf   []T
+	pos   int // write pos
+	len   int // slots used
+	mode  Mode
+	freed chan struct{} // closed when Drain or Reset empties the buffer
 }
 
-func New[T any](capacity int) *RingBuffer[T] {
+func New[T any](capacity int, opts ...Option) *RingBuffer[T] {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	return &RingBuffer[T]{
-		buf: make([]T, max(capacity, 1)),
+		buf:   make([]T, max(capacity, 1)),
+		mode:  cfg.mode,
+		freed: make(chan struct{}),
 	}
 }
 
 func (rb *RingBuffer[T]) Add(val T) (T, bool) {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
+	return rb.add(val)
+}
 
+func (rb *RingBuffer[T]) add(val T) (T, bool) {
 	dropped := rb.buf[rb.pos]
 	wasFull := rb.len == len(rb.buf)
 
@@ -35,6 +72,29 @@ func (rb *RingBuffer[T]) Add(val T) (T, bool) {
 	return dropped, wasFull
 }
 
+// Put adds val as the buffer's Mode says. Add evicts whatever the mode.
+func (rb *RingBuffer[T]) Put(ctx context.Context, val T) error {
+	for {
+		rb.mu.Lock()
+		if rb.mode == Evict || rb.len < len(rb.buf) {
+			rb.add(val)
+			rb.mu.Unlock()
+			return nil
+		}
+		freed := rb.freed
+		rb.mu.Unlock()
+
+		if rb.mode == Reject {
+			return ErrFull
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 func (rb *RingBuffer[T]) All() iter.Seq[T] {
 	return func(yield func(T) bool) {
 		rb.mu.RLock()
@@ -49,3 +109,56 @@ func (rb *RingBuffer[T]) All() iter.Seq[T] {
 		}
 	}
 }
+
+// Snapshot copies the buffered values, newest first like All, taken under
+// one lock so concurrent Adds can't interleave with the copy.
+func (rb *RingBuffer[T]) Snapshot() []T {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return rb.snapshot()
+}
+
+// Reset empties the buffer.
+func (rb *RingBuffer[T]) Reset() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.reset()
+}
+
+// Drain is Snapshot and Reset under one lock: every value is returned
+// exactly once, whether it was added before or after the call.
+func (rb *RingBuffer[T]) Drain() []T {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	vals := rb.snapshot()
+	rb.reset()
+	return vals
+}
+
+func (rb *RingBuffer[T]) Len() int {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return rb.len
+}
+
+func (rb *RingBuffer[T]) Cap() int {
+	return len(rb.buf)
+}
+
+func (rb *RingBuffer[T]) snapshot() []T {
+	vals := make([]T, rb.len)
+	for i := range vals {
+		vals[i] = rb.buf[(rb.pos-1-i+len(rb.buf))%len(rb.buf)]
+	}
+	return vals
+}
+
+// reset zeroes the slots too, so the buffer doesn't keep what they
+// pointed to alive.
+func (rb *RingBuffer[T]) reset() {
+	clear(rb.buf)
+	rb.pos = 0
+	rb.len = 0
+	close(rb.freed)
+	rb.freed = make(chan struct{})
+}
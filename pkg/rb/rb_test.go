@@ -1,7 +1,9 @@
 package rb_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/andriibeee/iotdemo/pkg/rb"
 
@@ -113,3 +115,115 @@ func TestEviction(t *testing.T) {
 	require.True(t, evicted)
 	assert.Equal(t, "b", removed)
 }
+
+func TestSnapshot(t *testing.T) {
+	r := rb.New[int](3)
+	assert.Empty(t, r.Snapshot())
+	for i := 1; i <= 4; i++ {
+		r.Add(i)
+	}
+	snap := r.Snapshot()
+	assert.Equal(t, []int{4, 3, 2}, snap)
+
+	// a copy, not a view
+	r.Add(5)
+	assert.Equal(t, []int{4, 3, 2}, snap)
+	assert.Equal(t, 3, r.Len())
+}
+
+func TestReset(t *testing.T) {
+	r := rb.New[int](3)
+	r.Add(1)
+	r.Add(2)
+	r.Reset()
+	assert.Zero(t, r.Len())
+	assert.Equal(t, 3, r.Cap())
+	assert.Empty(t, collect(r))
+
+	_, evicted := r.Add(3)
+	assert.False(t, evicted)
+	assert.Equal(t, []int{3}, collect(r))
+}
+
+func TestDrain(t *testing.T) {
+	r := rb.New[int](4)
+	r.Add(1)
+	r.Add(2)
+	assert.Equal(t, []int{2, 1}, r.Drain())
+	assert.Zero(t, r.Len())
+	assert.Empty(t, r.Drain())
+}
+
+func TestDrainConcurrent(t *testing.T) {
+	const n = 10000
+	r := rb.New[int](n)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range n {
+			r.Add(i)
+		}
+	}()
+
+	seen := make(map[int]bool)
+	for {
+		select {
+		case <-done:
+			for _, v := range r.Drain() {
+				seen[v] = true
+			}
+			assert.Len(t, seen, n, "every value drained exactly once")
+			return
+		default:
+			for _, v := range r.Drain() {
+				require.False(t, seen[v], "value %d drained twice", v)
+				seen[v] = true
+			}
+		}
+	}
+}
+
+func TestRejectMode(t *testing.T) {
+	r := rb.New[int](2, rb.WithMode(rb.Reject))
+	require.NoError(t, r.Put(context.Background(), 1))
+	require.NoError(t, r.Put(context.Background(), 2))
+	assert.ErrorIs(t, r.Put(context.Background(), 3), rb.ErrFull)
+	assert.Equal(t, []int{2, 1}, collect(r))
+
+	r.Drain()
+	assert.NoError(t, r.Put(context.Background(), 3))
+}
+
+func TestBlockMode(t *testing.T) {
+	r := rb.New[int](1, rb.WithMode(rb.Block))
+	require.NoError(t, r.Put(context.Background(), 1))
+
+	t.Run("gives up with the context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, r.Put(ctx, 2), context.DeadlineExceeded)
+		assert.Equal(t, []int{1}, collect(r))
+	})
+
+	t.Run("resumes after drain", func(t *testing.T) {
+		done := make(chan error)
+		go func() { done <- r.Put(context.Background(), 2) }()
+
+		select {
+		case err := <-done:
+			t.Fatalf("Put returned %v on a full buffer", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		assert.Equal(t, []int{1}, r.Drain())
+		require.NoError(t, <-done)
+		assert.Equal(t, []int{2}, collect(r))
+	})
+}
+
+func TestEvictModePut(t *testing.T) {
+	r := rb.New[int](1)
+	require.NoError(t, r.Put(context.Background(), 1))
+	require.NoError(t, r.Put(context.Background(), 2))
+	assert.Equal(t, []int{2}, collect(r))
+}
@@ -0,0 +1,63 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBreaker(3, time.Second)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	b.Failure()
+	b.Success() // resets the streak
+	b.Failure()
+	b.Failure()
+	assert.Equal(t, Closed, b.State())
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+
+	now = now.Add(time.Second)
+	assert.Equal(t, HalfOpen, b.State())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow(), "one trial at a time")
+
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+
+	now = now.Add(time.Second)
+	require.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, Closed, b.State())
+	assert.True(t, b.Allow())
+}
+
+func TestWithBreaker(t *testing.T) {
+	b := NewBreaker(2, time.Hour)
+	r := New(MaxAttempts(5), WithBreaker(b))
+
+	n := 0
+	err := r(context.Background(), func(ctx context.Context) error {
+		n++
+		return errors.New("down")
+	})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, n)
+
+	// another retryer sharing the breaker fails fast
+	n = 0
+	err = New(WithBreaker(b))(context.Background(), func(ctx context.Context) error {
+		n++
+		return nil
+	})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,67 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// Budget caps retries across every retryer sharing it: a token bucket
+// holding up to n retries, refilled at n per window. First attempts never
+// need a token, so an outage costs at most n extra requests per window
+// however many callers are retrying.
+type Budget struct {
+	mu     sync.Mutex
+	max    float64
+	rate   float64 // tokens per nanosecond
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func NewBudget(n int, window time.Duration) *Budget {
+	b := &Budget{
+		max:    float64(n),
+		rate:   float64(n) / float64(window),
+		tokens: float64(n),
+		now:    time.Now,
+	}
+	b.last = b.now()
+	return b
+}
+
+// Allow takes a retry from the budget, reporting false if none is left.
+func (b *Budget) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.max, b.tokens+float64(elapsed)*b.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// WithBudget draws every attempt after the first from b and stops
+// retrying once it is empty.
+func WithBudget(b *Budget) Option {
+	return func(fn Func) Func {
+		first := true
+		return func(ctx context.Context) error {
+			if !first && !b.Allow() {
+				return fmt.Errorf("%w: %w", ErrStop, ErrBudgetExhausted)
+			}
+			first = false
+			return fn(ctx)
+		}
+	}
+}
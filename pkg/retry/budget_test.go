@@ -0,0 +1,54 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(2, time.Second)
+	now := b.last
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	// refills up to n, no further
+	now = now.Add(time.Hour)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+}
+
+func TestWithBudget(t *testing.T) {
+	b := NewBudget(3, time.Hour)
+	r := New(WithBudget(b))
+
+	// shared: the first caller spends the budget, the second gets none
+	n := 0
+	err := r(context.Background(), func(ctx context.Context) error {
+		n++
+		return errors.New("down")
+	})
+	require.ErrorIs(t, err, ErrBudgetExhausted)
+	require.ErrorIs(t, err, ErrStop)
+	assert.Equal(t, 4, n)
+
+	n = 0
+	err = r(context.Background(), func(ctx context.Context) error {
+		n++
+		return errors.New("down")
+	})
+	require.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.Equal(t, 1, n, "first attempts don't need the budget")
+}
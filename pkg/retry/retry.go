@@ -16,8 +16,14 @@ type (
 		Delay time.Duration
 		Func  DelayFunc
 		Max   time.Duration
+		// FromError, if set, can replace the wait after a failed attempt.
+		FromError DelayFromError
 	}
 	StopCondition func(error) bool
+	// DelayFromError reports how long to wait after an attempt that failed
+	// with err, when err says so, e.g. a server's Retry-After. false leaves
+	// the wait to the backoff.
+	DelayFromError func(err error) (time.Duration, bool)
 )
 
 var (
@@ -74,13 +80,30 @@ func MaxAttempts(n uint) Option {
 	}
 }
 
+// Delay waits between attempts, growing the wait with opt.Func up to
+// opt.Max. A wait opt.FromError finds in the error is taken instead, as it
+// is: the backoff keeps growing underneath, and opt.Max doesn't cap it. It
+// doesn't wait after an attempt that stops the retry, and stops it when
+// ctx is done during a wait.
 func Delay(opt DelayOptions) Option {
 	return func(fn Func) Func {
 		delay := opt.Delay
 		return func(ctx context.Context) error {
 			err := fn(ctx)
-			if err != nil {
-				time.Sleep(delay)
+			if err != nil && !errors.Is(err, ErrStop) {
+				wait := delay
+				if opt.FromError != nil {
+					if d, ok := opt.FromError(err); ok {
+						wait = d
+					}
+				}
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					return fmt.Errorf("%w: %w: %w", ErrStop, ctx.Err(), err)
+				}
 				if opt.Func != nil {
 					delay = opt.Func(delay)
 				}
@@ -123,6 +123,34 @@ func TestDelayMax(t *testing.T) {
 	assert.GreaterOrEqual(t, time.Since(start), 19*time.Millisecond)
 }
 
+func TestDelayFromError(t *testing.T) {
+	type retryAfter struct{ error }
+	fromError := func(err error) (time.Duration, bool) {
+		var ra retryAfter
+		if errors.As(err, &ra) {
+			return 30 * time.Millisecond, true
+		}
+		return 0, false
+	}
+	r := New(MaxAttempts(3), Delay(DelayOptions{
+		Delay:     time.Millisecond,
+		Max:       time.Millisecond,
+		FromError: fromError,
+	}))
+
+	start := time.Now()
+	n := 0
+	_ = r(context.Background(), func(ctx context.Context) error {
+		n++
+		if n == 1 {
+			return retryAfter{errors.New("slow down")}
+		}
+		return errors.New("fail")
+	})
+	assert.GreaterOrEqual(t, time.Since(start), 31*time.Millisecond, "the error's wait isn't capped by Max")
+	assert.Equal(t, 3, n)
+}
+
 func TestTimeout(t *testing.T) {
 	r := New(Timeout(30 * time.Millisecond))
 	err := r(context.Background(), func(ctx context.Context) error {
@@ -147,3 +175,17 @@ func TestExponential(t *testing.T) {
 	assert.Equal(t, 20*time.Millisecond, DoubleDelay(10*time.Millisecond))
 	assert.Equal(t, 30*time.Millisecond, Exponential(3)(10*time.Millisecond))
 }
+
+func TestDelayCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := New(Delay(DelayOptions{Delay: time.Hour}))
+	n := 0
+	err := r(ctx, func(ctx context.Context) error {
+		n++
+		cancel()
+		return errors.New("fail")
+	})
+	assert.ErrorIs(t, err, ErrStop)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,1195 @@
+// Package sinkserver runs the whole ingest pipeline in-process: the
+// journal, the sink with its stages, replication and election, and the
+// HTTP, CoAP and debug servers, wired from a Config exactly as cmd/sink
+// wires them. Programs that want the sink without a separate process call
+// Run with a Config of their own:
+//
+//	cfg := sinkserver.DefaultConfig()
+//	cfg.Server.Addr = "127.0.0.1:8080"
+//	cfg.Journal.Dir = "/var/lib/myapp/journal"
+//	err := sinkserver.Run(ctx, cfg)
+//
+// Logs go to slog.Default and metrics to the default VictoriaMetrics set,
+// so only one Run per process makes sense.
+package sinkserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/andriibeee/iotdemo/internal/audit"
+	"github.com/andriibeee/iotdemo/internal/config"
+	"github.com/andriibeee/iotdemo/internal/election"
+	"github.com/andriibeee/iotdemo/internal/keys"
+	"github.com/andriibeee/iotdemo/internal/replication"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/internal/transport"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+	"github.com/andriibeee/iotdemo/pkg/rb"
+)
+
+// Config is the sink's configuration, as cmd/sink reads it from YAML; see
+// the README for the keys and their defaults.
+type Config = config.Config
+
+// The element types of Config's lists, which can't be named otherwise
+// from outside this module.
+type (
+	Priority        = config.Priority
+	Transform       = config.Transform
+	SampleRule      = config.SampleRule
+	JournalReplica  = config.JournalReplica
+	JournalRoute    = config.JournalRoute
+	LivenessRule    = config.LivenessRule
+	ReplicationPeer = config.ReplicationPeer
+)
+
+// DefaultConfig returns the configuration cmd/sink runs with when its
+// config file and environment set nothing.
+func DefaultConfig() Config {
+	return *config.Default()
+}
+
+// LoadConfig reads a YAML config file over the defaults, then environment
+// variables over that, as cmd/sink does; path may be empty.
+func LoadConfig(path string) (Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return *cfg, nil
+}
+
+type options struct {
+	forceTakeover bool
+	flushOn       <-chan os.Signal
+	rotateOn      <-chan os.Signal
+}
+
+type Option func(*options)
+
+// WithForceTakeover breaks a journal directory lock left by a process
+// that is no longer running, like cmd/sink's -force-takeover.
+func WithForceTakeover() Option {
+	return func(o *options) {
+		o.forceTakeover = true
+	}
+}
+
+// WithFlushOn flushes the sink and fsyncs the journal on each value from
+// c, like POST /admin/flush; cmd/sink passes SIGUSR1 here.
+func WithFlushOn(c <-chan os.Signal) Option {
+	return func(o *options) {
+		o.flushOn = c
+	}
+}
+
+// WithRotateOn flushes the sink and seals the active segment of the
+// journal on each value from c, like POST /admin/journal/rotate; cmd/sink
+// passes SIGUSR2 here.
+func WithRotateOn(c <-chan os.Signal) Option {
+	return func(o *options) {
+		o.rotateOn = c
+	}
+}
+
+// Run serves until ctx is canceled, then shuts down, flushing what the
+// sink still buffers, and returns nil. It returns early with an error if
+// cfg is invalid, something can't be opened or a server fails.
+func Run(ctx context.Context, cfg Config, opts ...Option) error {
+	a := &app{cfg: cfg}
+	for _, opt := range opts {
+		opt(&a.opts)
+	}
+
+	if err := a.pushMetrics(ctx); err != nil {
+		return err
+	}
+
+	// deferred in this order, the sink stops before its stages save their
+	// state and the journals close last
+	closeJournals, err := a.openJournals(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeJournals()
+
+	closePipeline, err := a.buildPipeline(ctx)
+	if err != nil {
+		return err
+	}
+	defer closePipeline()
+
+	if err := a.buildGuards(); err != nil {
+		return err
+	}
+
+	closeSink, err := a.buildSink(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeSink()
+
+	stopSink := a.runSink(ctx)
+	defer stopSink()
+
+	if err := a.startReplication(ctx); err != nil {
+		return err
+	}
+	if err := a.startElection(ctx); err != nil {
+		return err
+	}
+
+	srvOpts, closeServer, err := a.serverOptions(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeServer()
+
+	return a.serve(ctx, srvOpts)
+}
+
+// app holds what Run wires together, each part set up by one of its
+// methods from cfg and the parts before it.
+type app struct {
+	cfg  Config
+	opts options
+
+	j           *journal.Journal // the main journal
+	sinkJournal sink.Journal     // what the sink writes to: j, its replicas and routes
+	storageOpts []journal.FileOption
+
+	pipeline    []string
+	builtin     map[string]sink.Middleware // every built-in stage, nil unless enabled
+	middlewares []sink.Middleware          // the live pipeline, guards first
+	dedup       *sink.Deduplicator
+	sampler     *sink.Sampler
+	quota       *sink.Quota
+	stats       *sink.Stats
+
+	watchdog  *sink.Watchdog
+	diskGuard *sink.DiskGuard
+
+	s        *sink.Sink
+	keyCodec sink.KeyCodec
+	devices  *sink.Devices
+	health   *sink.Health
+
+	role    replication.Role
+	senders []*replication.Sender
+	recv    *replication.Receiver
+
+	ipFilter *transport.IPFilter
+}
+
+// cleanups runs funcs in reverse, as defers would, for a setup step that
+// opened several things and fails or is torn down halfway.
+type cleanups []func()
+
+func (c *cleanups) add(f func()) {
+	*c = append(*c, f)
+}
+
+func (c cleanups) run() {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i]()
+	}
+}
+
+func (a *app) pushMetrics(ctx context.Context) error {
+	cfg := a.cfg.Metrics
+	if cfg.PushURL == "" {
+		return nil
+	}
+	// edge sinks behind NAT can't be scraped, so they push instead
+	if err := metrics.InitPushWithOptions(ctx, cfg.PushURL, cfg.PushInterval, true, &metrics.PushOptions{
+		ExtraLabels:        cfg.ExtraLabels,
+		Headers:            cfg.PushHeaders,
+		Method:             cfg.PushMethod,
+		DisableCompression: cfg.PushDisableCompression,
+	}); err != nil {
+		return errors.New("invalid metrics push config: " + err.Error())
+	}
+	slog.Info("metrics push enabled",
+		"url", cfg.PushURL,
+		"interval", cfg.PushInterval,
+	)
+	return nil
+}
+
+// openJournals opens the main journal, its replicas and the journals of
+// routes that have their own.
+func (a *app) openJournals(ctx context.Context) (_ func(), err error) {
+	cfg := a.cfg.Journal
+	var cl cleanups
+	defer func() {
+		if err != nil {
+			cl.run()
+		}
+	}()
+
+	if a.opts.forceTakeover {
+		a.storageOpts = append(a.storageOpts, journal.WithForceTakeover())
+	}
+	switch cfg.Layout {
+	case "flat":
+	case "dated":
+		a.storageOpts = append(a.storageOpts, journal.WithDatedLayout())
+	default:
+		return nil, errors.New("unknown journal layout: " + cfg.Layout)
+	}
+
+	journalOpts := []journal.Option{
+		journal.WithGapHandler(func(g journal.SeqGap) {
+			slog.Error("journal sequence gap", "segment", g.Segment, "after", g.After, "next", g.Next, "regression", g.Regression())
+		}),
+	}
+	if cfg.VerifyChecksums {
+		journalOpts = append(journalOpts, journal.WithChecksumVerification())
+	}
+	if cfg.AtomicBatches {
+		journalOpts = append(journalOpts, journal.WithAtomicBatches())
+	}
+	format, err := journal.ParseFormat(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := journal.ParseChecksum(cfg.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	journalOpts = append(journalOpts, journal.WithFormat(format), journal.WithChecksum(checksum))
+
+	open := func(dir, inlineKey string, kp config.KeyProvider) (*journal.Journal, error) {
+		key, err := journalKey(ctx, inlineKey, kp)
+		if err != nil {
+			return nil, err
+		}
+		j, closeJournal, err := openJournal(dir, key, cfg.MaxSize, a.storageOpts, journalOpts)
+		if err != nil {
+			return nil, err
+		}
+		cl.add(closeJournal)
+		return j, nil
+	}
+
+	if a.j, err = open(cfg.Dir, cfg.EncryptionKey, cfg.KeyProvider); err != nil {
+		return nil, err
+	}
+	a.sinkJournal = a.j
+
+	if len(cfg.Replicas) > 0 {
+		var multiOpts []journal.MultiOption
+		switch cfg.ReplicaMode {
+		case "all":
+		case "best_effort":
+			multiOpts = append(multiOpts,
+				journal.WithBestEffort(),
+				journal.WithReplicaErrorHandler(func(i int, err error) {
+					slog.Warn("journal replica write failed", "dir", cfg.Replicas[i-1].Dir, "error", err)
+				}),
+			)
+		default:
+			return nil, errors.New("unknown journal replica mode: " + cfg.ReplicaMode)
+		}
+
+		replicas := make([]journal.Writer, 0, len(cfg.Replicas))
+		for _, r := range cfg.Replicas {
+			rj, err := open(r.Dir, r.EncryptionKey, r.KeyProvider)
+			if err != nil {
+				return nil, err
+			}
+			replicas = append(replicas, rj)
+			slog.Info("journal replica enabled", "dir", r.Dir, "mode", cfg.ReplicaMode)
+		}
+		a.sinkJournal = journal.NewMultiWriter(a.j, replicas, multiOpts...)
+	}
+
+	if len(cfg.Routes) > 0 {
+		routes := make([]sink.Route, 0, len(cfg.Routes))
+		for _, r := range cfg.Routes {
+			route := sink.Route{Name: r.Name, Patterns: r.Patterns, Journal: a.sinkJournal}
+			if r.Dir != "" {
+				rj, err := open(r.Dir, r.EncryptionKey, r.KeyProvider)
+				if err != nil {
+					return nil, err
+				}
+				route.Journal = rj
+			}
+			routes = append(routes, route)
+			slog.Info("journal route enabled", "name", r.Name, "patterns", r.Patterns, "dir", r.Dir)
+		}
+		a.sinkJournal = sink.NewRouter(a.sinkJournal, routes...)
+	}
+
+	return cl.run, nil
+}
+
+// buildPipeline sets up the enabled stages and orders them by
+// sink.pipeline. The returned func saves the quota state.
+func (a *app) buildPipeline(ctx context.Context) (_ func(), err error) {
+	cfg := a.cfg
+	var cl cleanups
+	defer func() {
+		if err != nil {
+			cl.run()
+		}
+	}()
+
+	// every built-in stage, nil unless enabled; sink.pipeline orders them
+	a.builtin = map[string]sink.Middleware{
+		"transform":  nil,
+		"sensorname": nil,
+		"horizon":    nil,
+		"dedup":      nil,
+		"sample":     nil,
+		"ratelimit":  nil,
+		"quota":      nil,
+		"stats":      nil,
+	}
+
+	if len(cfg.Sink.Transforms) > 0 {
+		rules := make([]sink.TransformRule, 0, len(cfg.Sink.Transforms))
+		for _, t := range cfg.Sink.Transforms {
+			rules = append(rules, sink.TransformRule(t))
+		}
+		tr, err := sink.NewTransformer(rules)
+		if err != nil {
+			return nil, errors.New("invalid sink transforms: " + err.Error())
+		}
+		a.builtin["transform"] = tr.Middleware()
+		slog.Info("sink transforms enabled", "rules", len(rules))
+	}
+
+	if sn := cfg.Sink.SensorNames; sn.Enabled {
+		rules := sink.SensorNameRules{
+			Charset:   sn.Charset,
+			MaxLength: sn.MaxLength,
+			Lowercase: sn.Lowercase,
+		}
+		for _, p := range sn.Prefixes {
+			rules.Prefixes = append(rules.Prefixes, sink.SensorPrefix(p))
+		}
+		names, err := sink.NewSensorNames(rules)
+		if err != nil {
+			return nil, errors.New("invalid sink sensor names: " + err.Error())
+		}
+		a.builtin["sensorname"] = names.Middleware()
+		slog.Info("sink sensor name rules enabled", "charset", sn.Charset, "max_length", sn.MaxLength, "lowercase", sn.Lowercase, "prefixes", len(sn.Prefixes))
+	}
+
+	if h := cfg.Sink.Horizon; h.MaxAge > 0 {
+		if h.Action != "reject" && h.Action != "tag" {
+			return nil, errors.New("invalid sink horizon action: " + h.Action)
+		}
+		a.builtin["horizon"] = sink.NewHorizon(h.MaxAge, h.Action == "tag").Middleware()
+		slog.Info("sink retention horizon enabled", "max_age", h.MaxAge, "action", h.Action)
+	}
+
+	if cfg.Dedup.Enabled {
+		dedupOpts := []sink.DedupOption{
+			sink.WithDedupShards(cfg.Dedup.Shards),
+			sink.WithDedupRecent(cfg.Dedup.Recent),
+		}
+		if cfg.Dedup.ContentHash {
+			dedupOpts = append(dedupOpts, sink.WithContentHash())
+		}
+		a.dedup = sink.NewDeduplicator(cfg.Dedup.CleaningInterval, dedupOpts...)
+		a.dedup.Start()
+		a.builtin["dedup"] = a.dedup.Middleware()
+		slog.Info("dedup enabled",
+			"cleaning_interval", cfg.Dedup.CleaningInterval,
+			"shards", cfg.Dedup.Shards,
+			"content_hash", cfg.Dedup.ContentHash,
+		)
+	}
+
+	if sm := cfg.Sink.Sampling; sm.Enabled {
+		rules := make([]sink.SampleRule, 0, len(sm.Rules))
+		for _, r := range sm.Rules {
+			rules = append(rules, sink.SampleRule(r))
+		}
+		if a.sampler, err = sink.NewSampler(rules); err != nil {
+			return nil, err
+		}
+		a.builtin["sample"] = a.sampler.Middleware()
+		slog.Info("sink sampling enabled", "rules", len(rules))
+	}
+
+	if cfg.RateLimit.Enabled {
+		rl := sink.NewRateLimiter(cfg.RateLimit.BytesPerSec,
+			sink.WithEventsPerSec(cfg.RateLimit.EventsPerSec),
+			sink.WithMaxWait(cfg.RateLimit.MaxWait),
+		)
+		a.builtin["ratelimit"] = rl.Middleware()
+		slog.Info("rate limit enabled",
+			"bytes_per_sec", cfg.RateLimit.BytesPerSec,
+			"events_per_sec", cfg.RateLimit.EventsPerSec,
+			"max_wait", cfg.RateLimit.MaxWait,
+		)
+	}
+
+	if cfg.Quota.Enabled {
+		a.quota = sink.NewQuota(cfg.Quota.EventsPerDay, cfg.Quota.BytesPerDay, cfg.Quota.StateFile, cfg.Quota.SaveInterval)
+		if err := a.quota.Load(); err != nil {
+			return nil, errors.New("failed to load quota state: " + err.Error())
+		}
+		a.quota.Start()
+		cl.add(func() {
+			if err := a.quota.Save(); err != nil {
+				slog.Warn("failed to save quota state", "error", err)
+			}
+		})
+		a.builtin["quota"] = a.quota.Middleware()
+		slog.Info("quota enabled",
+			"events_per_day", cfg.Quota.EventsPerDay,
+			"bytes_per_day", cfg.Quota.BytesPerDay,
+			"state_file", cfg.Quota.StateFile,
+		)
+	}
+
+	if err := a.buildStats(ctx); err != nil {
+		return nil, err
+	}
+
+	a.pipeline = cfg.Sink.Pipeline
+	if len(a.pipeline) == 0 {
+		a.pipeline = sink.DefaultPipeline
+	}
+	if a.middlewares, err = sink.BuildPipeline(a.pipeline, a.builtin, cfg.Sink.Stages, a.stageMetrics("live")...); err != nil {
+		return nil, errors.New("invalid sink pipeline: " + err.Error())
+	}
+	slog.Info("sink pipeline", "stages", a.pipeline, "stage_metrics", cfg.Sink.StageMetrics)
+
+	return cl.run, nil
+}
+
+// buildStats sets up the stats stage, rebuilt from the journal, and the
+// liveness alerts that watch it.
+func (a *app) buildStats(ctx context.Context) error {
+	cfg := a.cfg.Stats
+	if !cfg.Enabled {
+		if len(cfg.Liveness.Rules) > 0 {
+			return errors.New("stats.liveness rules need stats.enabled")
+		}
+		if cfg.Health.Enabled {
+			return errors.New("stats.health needs stats.enabled")
+		}
+		return nil
+	}
+
+	a.stats = sink.NewStats(cfg.Window)
+	start := time.Now()
+	if err := a.stats.Rebuild(ctx, a.j); err != nil {
+		return errors.New("failed to rebuild sensor stats: " + err.Error())
+	}
+	a.builtin["stats"] = a.stats.Middleware()
+	slog.Info("sensor stats enabled",
+		"window", cfg.Window,
+		"sensors", len(a.stats.Report().Sensors),
+		"rebuild", time.Since(start),
+	)
+
+	lv := cfg.Liveness
+	if len(lv.Rules) == 0 {
+		return nil
+	}
+	rules := make([]sink.LivenessRule, 0, len(lv.Rules))
+	for _, r := range lv.Rules {
+		rules = append(rules, sink.LivenessRule(r))
+	}
+	alerters := []sink.Alerter{sink.LogAlerter{}}
+	if lv.Webhook != "" {
+		headers := make(http.Header)
+		for _, h := range lv.WebhookHeaders {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return errors.New("invalid liveness webhook header: " + h)
+			}
+			headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		alerters = append(alerters, sink.WebhookAlerter{URL: lv.Webhook, Headers: headers})
+	}
+	liveness, err := sink.NewLiveness(a.stats, rules, alerters...)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := liveness.Run(ctx, lv.CheckInterval); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("liveness checker error", "error", err)
+		}
+	}()
+	slog.Info("sensor liveness alerts enabled", "rules", len(rules), "webhook", lv.Webhook != "")
+	return nil
+}
+
+// stageMetrics instruments a pipeline's stages with sink.stage_metrics.
+func (a *app) stageMetrics(name string) []sink.PipelineOption {
+	if !a.cfg.Sink.StageMetrics {
+		return nil
+	}
+	return []sink.PipelineOption{sink.WithStageMetrics(name)}
+}
+
+func (a *app) instrument(name, stage string, mw sink.Middleware) sink.Middleware {
+	if !a.cfg.Sink.StageMetrics {
+		return mw
+	}
+	return sink.Instrument(name, stage, mw)
+}
+
+// buildGuards sets up the memory watchdog and the journal disk guard, put
+// ahead of the live pipeline.
+func (a *app) buildGuards() error {
+	if wd := a.cfg.Watchdog; wd.Enabled {
+		hooks := []func(){func() {
+			// the sink is built after its guards
+			if err := a.s.Flush(); err != nil {
+				slog.Warn("early flush failed", "error", err)
+			}
+		}}
+		if a.dedup != nil {
+			hooks = append(hooks, a.dedup.Shrink)
+		}
+		var err error
+		if a.watchdog, err = sink.NewWatchdog(wd.SoftLimit, wd.HardLimit, hooks...); err != nil {
+			return err
+		}
+		a.middlewares = append([]sink.Middleware{a.instrument("live", "watchdog", a.watchdog.Middleware())}, a.middlewares...)
+		slog.Info("memory watchdog enabled",
+			"soft_limit", wd.SoftLimit,
+			"hard_limit", wd.HardLimit,
+			"interval", wd.Interval,
+		)
+	}
+
+	if dg := a.cfg.Journal.DiskGuard; dg.Enabled {
+		var hooks []func()
+		if dg.Compact {
+			hooks = append(hooks, func() {
+				reclaimed, err := a.j.Compact()
+				if err != nil {
+					slog.Warn("emergency compaction failed", "error", err)
+					return
+				}
+				slog.Info("emergency compaction done", "reclaimed_bytes", reclaimed)
+			})
+		}
+		var err error
+		if a.diskGuard, err = sink.NewDiskGuard(a.j.Size, a.cfg.Journal.Dir, dg.MaxBytes, dg.MinFreeBytes, hooks...); err != nil {
+			return err
+		}
+		a.diskGuard.Check()
+		a.middlewares = append([]sink.Middleware{a.instrument("live", "disk_guard", a.diskGuard.Middleware())}, a.middlewares...)
+		slog.Info("journal disk guard enabled",
+			"max_bytes", dg.MaxBytes,
+			"min_free_bytes", dg.MinFreeBytes,
+			"compact", dg.Compact,
+			"interval", dg.Interval,
+		)
+	}
+	return nil
+}
+
+// buildSink creates the sink, with its backfill pipeline and spill, and
+// the device reports rebuilt from the journal. The returned func saves the
+// backfill quota state and closes the spill.
+func (a *app) buildSink(ctx context.Context) (_ func(), err error) {
+	cfg := a.cfg
+	var cl cleanups
+	defer func() {
+		if err != nil {
+			cl.run()
+		}
+	}()
+
+	sinkOpts := []sink.Option{
+		sink.WithBufSize(cfg.Sink.BufferSize),
+		sink.WithMiddleware(a.middlewares...),
+	}
+	if bf := cfg.Backfill; bf.Enabled {
+		bq := sink.NewQuota(bf.EventsPerDay, bf.BytesPerDay, bf.StateFile, cfg.Quota.SaveInterval)
+		if err := bq.Load(); err != nil {
+			return nil, errors.New("failed to load backfill quota state: " + err.Error())
+		}
+		bq.Start()
+		cl.add(func() {
+			if err := bq.Save(); err != nil {
+				slog.Warn("failed to save backfill quota state", "error", err)
+			}
+		})
+
+		// the same stages, minus the protections meant for live traffic
+		backfill := maps.Clone(a.builtin)
+		backfill["dedup"] = nil
+		backfill["ratelimit"] = nil
+		backfill["quota"] = bq.Middleware()
+		mws, err := sink.BuildPipeline(a.pipeline, backfill, cfg.Sink.Stages, a.stageMetrics("backfill")...)
+		if err != nil {
+			return nil, errors.New("invalid backfill pipeline: " + err.Error())
+		}
+		if a.watchdog != nil {
+			mws = append([]sink.Middleware{a.instrument("backfill", "watchdog", a.watchdog.Middleware())}, mws...)
+		}
+		if a.diskGuard != nil {
+			mws = append([]sink.Middleware{a.instrument("backfill", "disk_guard", a.diskGuard.Middleware())}, mws...)
+		}
+		sinkOpts = append(sinkOpts, sink.WithBackfill(mws...))
+		slog.Info("backfill enabled",
+			"events_per_day", bf.EventsPerDay,
+			"bytes_per_day", bf.BytesPerDay,
+			"state_file", bf.StateFile,
+		)
+	}
+	if a.dedup != nil {
+		// so duplicates can report the sequence number of the first copy
+		sinkOpts = append(sinkOpts, sink.WithWrittenHook(a.dedup.Written))
+	}
+	for _, p := range cfg.Sink.Priorities {
+		size := p.BufferSize
+		if size <= 0 {
+			size = cfg.Sink.BufferSize
+		}
+		sinkOpts = append(sinkOpts, sink.WithPriority(p.Name, size, p.Patterns...))
+		slog.Info("priority lane enabled", "name", p.Name, "patterns", p.Patterns, "buffer_size", size)
+	}
+	switch cfg.Sink.Overflow {
+	case "evict":
+	case "reject":
+		sinkOpts = append(sinkOpts, sink.WithOverflow(rb.Reject, 0))
+	case "block":
+		sinkOpts = append(sinkOpts, sink.WithOverflow(rb.Block, cfg.Sink.OverflowWait))
+	default:
+		return nil, errors.New("unknown sink overflow mode: " + cfg.Sink.Overflow)
+	}
+	fr := cfg.Sink.FlushRetry
+	sinkOpts = append(sinkOpts,
+		sink.WithFlushRetry(fr.Base, fr.Max, fr.DegradeAfter),
+		sink.WithFlushInterval(cfg.Sink.FlushInterval),
+		sink.WithFlushBytes(cfg.Sink.FlushBytes),
+		sink.WithSyncInterval(cfg.Sink.SyncInterval),
+	)
+	a.keyCodec = sink.TextKeys
+	switch cfg.Sink.KeyFormat {
+	case "text":
+	case "binary":
+		a.keyCodec = sink.BinaryKeys
+		sinkOpts = append(sinkOpts, sink.WithKeyCodec(a.keyCodec))
+	default:
+		return nil, errors.New("unknown sink key format: " + cfg.Sink.KeyFormat)
+	}
+	if cfg.Sink.SpillFile != "" {
+		spill, err := sink.OpenSpill(cfg.Sink.SpillFile)
+		if err != nil {
+			return nil, err
+		}
+		cl.add(func() { _ = spill.Close() })
+		sinkOpts = append(sinkOpts, sink.WithSpill(spill))
+		slog.Info("sink spill enabled", "file", cfg.Sink.SpillFile)
+	}
+
+	a.s = sink.New(a.sinkJournal, sinkOpts...)
+
+	a.devices = sink.NewDevices(a.sinkJournal, a.keyCodec)
+	if err := a.devices.Rebuild(ctx, a.j); err != nil {
+		return nil, errors.New("failed to rebuild device reports: " + err.Error())
+	}
+
+	if hc := cfg.Stats.Health; hc.Enabled {
+		a.health = sink.NewHealth(a.stats, a.devices, sink.HealthPolicy{
+			SilentAfter:      hc.SilentAfter,
+			HeartbeatTimeout: hc.HeartbeatTimeout,
+			LowBattery:       hc.LowBattery,
+		})
+		a.health.Check()
+		slog.Info("sensor health enabled", "silent_after", hc.SilentAfter, "heartbeat_timeout", hc.HeartbeatTimeout)
+	}
+
+	return cl.run, nil
+}
+
+// runSink starts the sink and the checkers around it. The servers stop on
+// ctx, the sink only once the returned func is called, so that its final
+// flush comes after the last event they take and before the journals are
+// closed.
+func (a *app) runSink(ctx context.Context) func() {
+	if a.health != nil {
+		go func() {
+			if err := a.health.Run(ctx, a.cfg.Stats.Health.CheckInterval); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("sensor health checker error", "error", err)
+			}
+		}()
+	}
+
+	if a.watchdog != nil {
+		go func() {
+			if err := a.watchdog.Run(ctx, a.cfg.Watchdog.Interval); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("memory watchdog error", "error", err)
+			}
+		}()
+	}
+
+	if a.diskGuard != nil {
+		go func() {
+			if err := a.diskGuard.Run(ctx, a.cfg.Journal.DiskGuard.Interval); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("journal disk guard error", "error", err)
+			}
+		}()
+	}
+
+	sinkCtx, stopSink := context.WithCancel(context.WithoutCancel(ctx))
+	sinkDone := make(chan struct{})
+	go func() {
+		defer close(sinkDone)
+		if err := a.s.Run(sinkCtx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("sink run error", "error", err)
+		}
+	}()
+	go func() {
+		for err := range a.s.FlushErrors() {
+			slog.Warn("journal flush failed", "class", journal.Classify(err), "error", err)
+		}
+	}()
+
+	if a.opts.flushOn != nil || a.opts.rotateOn != nil {
+		go handleSignals(ctx, a.s, a.j, a.opts.flushOn, a.opts.rotateOn)
+	}
+
+	return func() {
+		stopSink()
+		<-sinkDone
+	}
+}
+
+// startReplication sets the role this sink starts in and starts sending
+// to peers and receiving from the leader, as configured.
+func (a *app) startReplication(ctx context.Context) error {
+	rc := a.cfg.Replication
+	if rc.Follow.Enabled {
+		if !rc.Receive.Enabled {
+			return errors.New("replication.follow needs replication.receive")
+		}
+		if a.cfg.Election.Enabled {
+			return errors.New("replication.follow and election are exclusive")
+		}
+		a.role.Follow(rc.Follow.Leader)
+		slog.Info("following", "leader", rc.Follow.Leader)
+	}
+	if a.cfg.Election.Enabled {
+		a.role.Follow("") // until the first round is decided
+	}
+
+	a.senders = make([]*replication.Sender, 0, len(rc.Peers))
+	for _, peer := range rc.Peers {
+		sopts := []replication.SenderOption{
+			replication.WithToken(peer.Token),
+			replication.WithBatchSize(rc.BatchSize),
+			replication.WithStateFile(filepath.Join(rc.StateDir, "peer-"+stateName(peer.URL)+".json")),
+			replication.WithGate(a.leading),
+		}
+		if rc.Name != "" {
+			sopts = append(sopts, replication.WithSourceName(rc.Name))
+		}
+		sender := replication.NewSender(a.j, peer.URL, sopts...)
+		if err := sender.Load(); err != nil {
+			return err
+		}
+		go func() {
+			if err := sender.Run(ctx, rc.Interval); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("replication error", "peer", peer.URL, "error", err)
+			}
+		}()
+		a.senders = append(a.senders, sender)
+		slog.Info("replication enabled", "peer", peer.URL, "acked", sender.Acked())
+	}
+
+	if !rc.Receive.Enabled {
+		return nil
+	}
+	if rc.Receive.Token == "" {
+		return errors.New("replication.receive needs a token")
+	}
+	a.recv = replication.NewReceiver(a.j, filepath.Join(rc.StateDir, "received.json"),
+		replication.WithOnApply(func(entries []journal.Entry) {
+			for i := range entries {
+				if a.stats != nil {
+					if err := a.stats.ObserveEntry(&entries[i]); err != nil {
+						slog.Warn("replicated entry not counted in stats", "error", err)
+					}
+				}
+				if err := a.devices.ObserveEntry(&entries[i]); err != nil {
+					slog.Warn("replicated device report not recorded", "error", err)
+				}
+			}
+		}))
+	if err := a.recv.Load(); err != nil {
+		return err
+	}
+	slog.Info("replication receiver enabled")
+	return nil
+}
+
+// leading reports whether this sink leads, for what only the leader does.
+func (a *app) leading() bool {
+	_, following := a.role.Leader()
+	return !following
+}
+
+func (a *app) startElection(ctx context.Context) error {
+	ec := a.cfg.Election
+	if !ec.Enabled {
+		return nil
+	}
+	var lease election.Lease
+	switch ec.Backend {
+	case "file":
+		if ec.File.Path == "" {
+			return errors.New("election.file.path is required")
+		}
+		lease = election.NewFileLease(ec.File.Path)
+	default:
+		return errors.New("unknown election backend: " + ec.Backend)
+	}
+	elector := election.New(lease, ec.ID,
+		election.WithTTL(ec.TTL),
+		election.WithURL(ec.URL),
+		election.WithOnChange(func(leading bool, leader string) {
+			if !leading {
+				a.role.Follow(leader)
+				return
+			}
+			// what came from the previous leader needn't go back to it
+			if a.recv != nil {
+				for _, sender := range a.senders {
+					if err := sender.SkipTo(a.recv.Written()); err != nil {
+						slog.Error("replication offset not saved", "error", err)
+					}
+				}
+			}
+			a.role.Lead()
+		}),
+	)
+	go func() {
+		if err := elector.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("leader election error", "error", err)
+		}
+	}()
+	slog.Info("leader election enabled", "backend", ec.Backend, "ttl", ec.TTL)
+	return nil
+}
+
+// serverOptions configures the HTTP server, opening the audit journal if
+// enabled; the returned func closes it.
+func (a *app) serverOptions(ctx context.Context) (_ []transport.Option, _ func(), err error) {
+	cfg := a.cfg
+	var cl cleanups
+	defer func() {
+		if err != nil {
+			cl.run()
+		}
+	}()
+
+	srvOpts := []transport.Option{
+		transport.WithJournal(a.j),
+		transport.WithEvents(a.j, a.keyCodec),
+		transport.WithDevices(a.devices),
+		transport.WithAddr(cfg.Server.Addr),
+		transport.WithBasePath(cfg.Server.BasePath),
+		transport.WithAdminToken(cfg.Server.AdminToken),
+		transport.WithReadTimeout(cfg.Server.ReadTimeout),
+		transport.WithWriteTimeout(cfg.Server.WriteTimeout),
+		transport.WithMaxBodySize(cfg.Server.MaxBodySize),
+		transport.WithBatchWorkers(cfg.Server.BatchWorkers),
+		transport.WithBatchLimit(cfg.Server.BatchLimit.Concurrency, cfg.Server.BatchLimit.Queue, cfg.Server.BatchLimit.Wait),
+	}
+
+	switch cfg.Server.JSONDecoder {
+	case "fast":
+	case "std":
+		srvOpts = append(srvOpts, transport.WithJSONDecoder(transport.StdJSON))
+	default:
+		return nil, nil, errors.New("unknown server.json_decoder: " + cfg.Server.JSONDecoder)
+	}
+
+	if cfg.Server.TLS.Cert != "" {
+		srvOpts = append(srvOpts, transport.WithTLS(cfg.Server.TLS.Cert, cfg.Server.TLS.Key))
+	}
+	if cfg.Server.TLS.ClientCA != "" {
+		srvOpts = append(srvOpts, transport.WithClientCA(cfg.Server.TLS.ClientCA))
+	}
+	if len(cfg.Server.TrustedProxies) > 0 {
+		prefixes, err := parsePrefixes(cfg.Server.TrustedProxies)
+		if err != nil {
+			return nil, nil, errors.New("invalid trusted proxy: " + err.Error())
+		}
+		srvOpts = append(srvOpts, transport.WithTrustedProxies(prefixes...))
+		slog.Info("trusted proxies", "prefixes", cfg.Server.TrustedProxies)
+	}
+	if f := cfg.Server.IPFilter; len(f.Allow) > 0 || len(f.Deny) > 0 {
+		allow, err := parsePrefixes(f.Allow)
+		if err != nil {
+			return nil, nil, errors.New("invalid ip filter allow entry: " + err.Error())
+		}
+		deny, err := parsePrefixes(f.Deny)
+		if err != nil {
+			return nil, nil, errors.New("invalid ip filter deny entry: " + err.Error())
+		}
+		a.ipFilter = transport.NewIPFilter(allow, deny)
+		srvOpts = append(srvOpts, transport.WithIPFilter(a.ipFilter))
+		slog.Info("ip filter enabled", "allow", f.Allow, "deny", f.Deny)
+	}
+	if cfg.Server.ProxyProtocol {
+		if len(cfg.Server.TrustedProxies) == 0 {
+			return nil, nil, errors.New("server.proxy_protocol needs server.trusted_proxies")
+		}
+		srvOpts = append(srvOpts, transport.WithProxyProtocol())
+	}
+	if tl := cfg.Server.TenantLabel; tl.Header != "" {
+		srvOpts = append(srvOpts, transport.WithTenantLabel(tl.Header, tl.Tenants, tl.Max))
+		slog.Info("request durations labeled by tenant", "header", tl.Header, "tenants", tl.Tenants, "max", tl.Max)
+	}
+	if cfg.Server.Compression.Enabled {
+		srvOpts = append(srvOpts, transport.WithCompression(cfg.Server.Compression.MinSize))
+	}
+	if a.quota != nil {
+		srvOpts = append(srvOpts, transport.WithQuota(a.quota))
+	}
+	if a.health != nil {
+		srvOpts = append(srvOpts, transport.WithStats(a.health))
+	} else if a.stats != nil {
+		srvOpts = append(srvOpts, transport.WithStats(a.stats))
+	}
+	if a.sampler != nil {
+		srvOpts = append(srvOpts, transport.WithSampling(a.sampler))
+	}
+	if a.dedup != nil {
+		srvOpts = append(srvOpts, transport.WithDedup(a.dedup))
+	}
+	if rw := cfg.RemoteWrite; rw.Enabled {
+		srvOpts = append(srvOpts, transport.WithRemoteWrite(rw.Labels, rw.Scale))
+		slog.Info("prometheus remote write enabled", "labels", rw.Labels, "scale", rw.Scale)
+	}
+	if cfg.Backfill.Enabled {
+		srvOpts = append(srvOpts, transport.WithBackfill())
+	}
+	if cfg.Dedup.Enabled {
+		srvOpts = append(srvOpts, transport.WithBatchDedup(cfg.Dedup.BatchTTL))
+	}
+
+	if ac := cfg.Audit; ac.Enabled {
+		key, err := journalKey(ctx, ac.HMACKey, ac.KeyProvider)
+		if err != nil {
+			return nil, nil, err
+		}
+		aj, closeAudit, err := openJournal(ac.Dir, nil, cfg.Journal.MaxSize, a.storageOpts, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		cl.add(closeAudit)
+		auditLog, err := audit.New(aj, key)
+		if errors.Is(err, audit.ErrTampered) {
+			slog.Error("audit log chain broken", "dir", ac.Dir, "error", err)
+		} else if err != nil {
+			return nil, nil, err
+		}
+		srvOpts = append(srvOpts, transport.WithAudit(auditLog))
+		slog.Info("audit log enabled", "dir", ac.Dir, "keyed", key != nil)
+	}
+
+	srvOpts = append(srvOpts, transport.WithFollower(&a.role))
+	if a.recv != nil {
+		srvOpts = append(srvOpts, transport.WithReplication(a.recv, cfg.Replication.Receive.Token))
+	}
+	if cc := cfg.Sink.Canary; cc.Enabled {
+		canary := sink.NewCanary(a.s, cc.Timeout, sink.WithCanaryGate(a.leading))
+		go func() {
+			if err := canary.Run(ctx, cc.Interval); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("canary error", "error", err)
+			}
+		}()
+		srvOpts = append(srvOpts, transport.WithCanary(canary))
+		slog.Info("canary enabled", "interval", cc.Interval, "timeout", cc.Timeout)
+	}
+
+	return srvOpts, cl.run, nil
+}
+
+// serve runs the CoAP and debug servers, if enabled, and the HTTP server
+// until ctx is done.
+func (a *app) serve(ctx context.Context, srvOpts []transport.Option) error {
+	cfg := a.cfg
+	if cfg.CoAP.Enabled {
+		coapOpts := []transport.CoAPOption{transport.WithCoAPAddr(cfg.CoAP.Addr), transport.WithCoAPFollower(&a.role)}
+		if a.ipFilter != nil {
+			coapOpts = append(coapOpts, transport.WithCoAPIPFilter(a.ipFilter))
+		}
+		coap := transport.NewCoAP(a.s, coapOpts...)
+		go func() {
+			if err := coap.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("coap server error", "error", err)
+			}
+		}()
+	}
+
+	if cfg.Debug.Enabled {
+		if cfg.Debug.Token == "" {
+			return transport.ErrDebugToken
+		}
+		debug := transport.NewDebug(cfg.Debug.Token,
+			transport.WithDebugAddr(cfg.Debug.Addr),
+			transport.WithBlockProfileRate(cfg.Debug.BlockProfileRate),
+			transport.WithMutexProfileFraction(cfg.Debug.MutexProfileFraction),
+		)
+		go func() {
+			if err := debug.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("debug server error", "error", err)
+			}
+		}()
+	}
+
+	srv := transport.New(a.s, srvOpts...)
+
+	// a signal is the normal way to stop, not an error
+	if err := srv.Run(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// handleSignals flushes or rotates on each signal until ctx is done. The
+// outcome is only logged, there being no one to answer.
+func handleSignals(ctx context.Context, s *sink.Sink, j *journal.Journal, flushOn, rotateOn <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-flushOn:
+			if err := s.Sync(); err != nil {
+				slog.Error("manual flush failed", "signal", sig, "error", err)
+				continue
+			}
+			slog.Info("sink flushed", "signal", sig)
+		case sig := <-rotateOn:
+			if err := s.Sync(); err != nil {
+				slog.Error("flush before rotation failed", "signal", sig, "error", err)
+				continue
+			}
+			info, err := j.Rotate()
+			if err != nil {
+				slog.Error("journal rotation failed", "signal", sig, "error", err)
+				continue
+			}
+			slog.Info("journal rotated", "signal", sig, "segment", info.Name, "last_seq", info.LastSeq)
+		}
+	}
+}
+
+// journalKey fetches the encryption key given inline or through a key
+// provider; nil means the journal isn't encrypted.
+func journalKey(ctx context.Context, inline string, kp config.KeyProvider) ([]byte, error) {
+	if inline != "" && kp.Type != "" {
+		return nil, errors.New("set either encryption_key or key_provider, not both")
+	}
+
+	src := keys.Source{
+		Type: kp.Type,
+		Path: kp.Path,
+		Env:  kp.Env,
+		Vault: keys.Vault{
+			Addr:  kp.Vault.Addr,
+			Token: kp.Vault.Token,
+			Path:  kp.Vault.Path,
+			Field: kp.Vault.Field,
+		},
+		KMS: keys.KMS{
+			Region:     kp.AWSKMS.Region,
+			Endpoint:   kp.AWSKMS.Endpoint,
+			Ciphertext: kp.AWSKMS.Ciphertext,
+		},
+	}
+	switch {
+	case inline != "":
+		src = keys.Source{Type: "inline", Key: inline}
+	case kp.Type == "":
+		return nil, nil
+	}
+
+	provider, err := keys.New(src)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	key, err := provider.Key(ctx)
+	if err != nil {
+		return nil, errors.New("failed to fetch encryption key from " + src.Type + ": " + err.Error())
+	}
+	return key, nil
+}
+
+// parsePrefixes parses CIDRs, taking a bare address as a prefix of just
+// itself.
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, p := range list {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(p)
+			if aerr != nil {
+				return nil, errors.New(p)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// openJournal opens the journal in dir, encrypted when key is set. The
+// returned func closes the journal and releases the directory lock.
+func openJournal(dir string, key []byte, maxSize int64, storageOpts []journal.FileOption, opts []journal.Option) (*journal.Journal, func(), error) {
+	storage, err := journal.NewFileStorage(dir, storageOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if key != nil {
+		enc, err := journal.NewAESGCMEncryptor(key)
+		if err != nil {
+			_ = storage.Close()
+			return nil, nil, errors.New("failed to create encryptor: " + err.Error())
+		}
+		opts = append(opts[:len(opts):len(opts)], journal.WithEncryptor(enc))
+		slog.Info("journal encryption enabled", "dir", dir)
+	}
+
+	j, err := journal.New(storage, maxSize, opts...)
+	if err != nil {
+		_ = storage.Close()
+		return nil, nil, err
+	}
+	return j, func() {
+		_ = j.Close()
+		_ = storage.Close()
+	}, nil
+}
+
+// stateName turns a peer URL into a file name.
+func stateName(url string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, url)
+}
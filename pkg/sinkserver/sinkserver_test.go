@@ -0,0 +1,138 @@
+package sinkserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/andriibeee/iotdemo/internal/config"
+	"github.com/andriibeee/iotdemo/internal/sink"
+	"github.com/andriibeee/iotdemo/pkg/client"
+	"github.com/andriibeee/iotdemo/pkg/journal"
+)
+
+// start runs a sink on a free port with its journal in a temp directory.
+func start(t *testing.T, opts ...Option) (cfg Config, addr string, stop func() error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr = l.Addr().String()
+	require.NoError(t, l.Close())
+
+	cfg = DefaultConfig()
+	cfg.Server.Addr = addr
+	cfg.Journal.Dir = filepath.Join(t.TempDir(), "journal")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg, opts...) }()
+
+	require.Eventually(t, func() bool {
+		status, _, err := fasthttp.Get(nil, "http://"+addr+"/healthz")
+		return err == nil && status == fasthttp.StatusOK
+	}, 10*time.Second, 20*time.Millisecond)
+
+	return cfg, addr, func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatal("Run didn't return")
+			return nil
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	cfg, addr, stop := start(t)
+	ctx := context.Background()
+
+	c, err := client.New("http://" + addr)
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Send(ctx, client.Event{Sensor: "embedded", Value: 42, UnixTimestamp: 1}))
+
+	// still buffered; shutting down flushes it
+	require.NoError(t, stop())
+
+	storage, err := journal.NewFileStorage(cfg.Journal.Dir)
+	require.NoError(t, err)
+	defer storage.Close()
+	j, err := journal.New(storage, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	var events []string
+	require.NoError(t, j.Replay(func(e *journal.Entry) error {
+		ev, err := sink.DecodeValue(e.Value)
+		require.NoError(t, err)
+		events = append(events, ev.Sensor)
+		assert.Equal(t, 42, ev.Value)
+		return nil
+	}))
+	assert.Equal(t, []string{"embedded"}, events)
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Journal.Dir = t.TempDir()
+	cfg.Journal.Layout = "spiral"
+	assert.ErrorContains(t, Run(context.Background(), cfg), "unknown journal layout")
+}
+
+func TestRunInvalidPipelineClosesJournal(t *testing.T) {
+	for name, tc := range map[string]struct {
+		set  func(*Config)
+		want string
+	}{
+		"transforms": {func(cfg *Config) { cfg.Sink.Transforms = []config.Transform{{Match: "("}} }, "invalid sink transforms"},
+		"horizon": {func(cfg *Config) {
+			cfg.Sink.Horizon.MaxAge = time.Hour
+			cfg.Sink.Horizon.Action = "shrug"
+		}, "invalid sink horizon action"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Journal.Dir = t.TempDir()
+			tc.set(&cfg)
+			assert.ErrorContains(t, Run(context.Background(), cfg), tc.want)
+
+			// the journal lock was released on the way out
+			storage, err := journal.NewFileStorage(cfg.Journal.Dir)
+			require.NoError(t, err)
+			require.NoError(t, storage.Close())
+		})
+	}
+}
+
+func TestRunSignals(t *testing.T) {
+	flush, rotate := make(chan os.Signal), make(chan os.Signal)
+	cfg, addr, stop := start(t, WithFlushOn(flush), WithRotateOn(rotate))
+	defer func() { require.NoError(t, stop()) }()
+
+	c, err := client.New("http://" + addr)
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Send(context.Background(), client.Event{Sensor: "embedded", Value: 1, UnixTimestamp: 1}))
+
+	// /events only sees what's flushed and synced
+	flush <- os.Interrupt
+	require.Eventually(t, func() bool {
+		_, body, err := fasthttp.Get(nil, "http://"+addr+"/events")
+		return err == nil && strings.Contains(string(body), `"embedded"`)
+	}, 5*time.Second, 20*time.Millisecond)
+
+	rotate <- os.Interrupt
+	require.Eventually(t, func() bool {
+		manifest, err := os.ReadFile(filepath.Join(cfg.Journal.Dir, "MANIFEST"))
+		return err == nil && strings.Contains(string(manifest), `"last_seq":1`)
+	}, 5*time.Second, 20*time.Millisecond)
+}